@@ -0,0 +1,151 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACMETLSALPNProtocol is the ALPN protocol of the ACME TLS-ALPN-01 challenge,
+// see RFC 8737.
+const ACMETLSALPNProtocol = "acme-tls/1"
+
+// oidACMEIdentifier is the id-pe-acmeIdentifier certificate extension, which
+// carries the SHA-256 digest of the key authorization of a challenge.
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// An ACMEChallengeResponder answers ACME TLS-ALPN-01 challenges on the
+// listener that serves regular connections, so that certificates can be
+// obtained without a separate validation server.
+//
+// Challenge certificates are provisioned per domain with Add or
+// AddKeyAuthorization while a challenge is pending, and removed with Remove
+// once it's validated. The Config returned by WrapConfig presents them to
+// ClientHellos that offer only the acme-tls/1 protocol, as the ACME server
+// does, and handles all other connections as the wrapped Config would.
+//
+// An ACMEChallengeResponder is safe for concurrent use. Its zero value is
+// ready to use.
+type ACMEChallengeResponder struct {
+	mu    sync.RWMutex
+	certs map[string]*Certificate
+}
+
+// Add provisions cert as the challenge certificate of domain, replacing any
+// previous one.
+func (r *ACMEChallengeResponder) Add(domain string, cert *Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.certs == nil {
+		r.certs = make(map[string]*Certificate)
+	}
+	r.certs[strings.ToLower(domain)] = cert
+}
+
+// AddKeyAuthorization generates a self-signed challenge certificate for
+// domain, as described in RFC 8737, Section 3, and provisions it as Add does.
+// keyAuth is the key authorization of the challenge, made of its token and
+// the thumbprint of the ACME account key.
+func (r *ACMEChallengeResponder) AddKeyAuthorization(domain, keyAuth string) error {
+	cert, err := acmeChallengeCertificate(domain, keyAuth)
+	if err != nil {
+		return err
+	}
+	r.Add(domain, cert)
+	return nil
+}
+
+// Remove stops answering challenges for domain.
+func (r *ACMEChallengeResponder) Remove(domain string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.certs, strings.ToLower(domain))
+}
+
+// WrapConfig returns a clone of config whose GetConfigForClient answers the
+// challenges of provisioned domains with their challenge certificate, and
+// otherwise defers to config.GetConfigForClient, if set.
+func (r *ACMEChallengeResponder) WrapConfig(config *Config) *Config {
+	wrapped := config.Clone()
+	next := config.GetConfigForClient
+	wrapped.GetConfigForClient = func(hello *ClientHelloInfo) (*Config, error) {
+		if cert := r.challengeCertificate(hello); cert != nil {
+			challengeConfig := config.Clone()
+			challengeConfig.GetConfigForClient = nil
+			challengeConfig.GetCertificate = nil
+			challengeConfig.GetCertificateForHello = nil
+			challengeConfig.Certificates = []Certificate{*cert}
+			challengeConfig.NameToCertificate = nil
+			challengeConfig.NextProtos = []string{ACMETLSALPNProtocol}
+			challengeConfig.ClientAuth = NoClientCert
+			// RFC 8737, Section 4
+			if challengeConfig.MinVersion < VersionTLS12 {
+				challengeConfig.MinVersion = VersionTLS12
+			}
+			return challengeConfig, nil
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	return wrapped
+}
+
+// challengeCertificate returns the challenge certificate to answer hello
+// with, or nil if hello isn't a challenge of a provisioned domain.
+func (r *ACMEChallengeResponder) challengeCertificate(hello *ClientHelloInfo) *Certificate {
+	if len(hello.SupportedProtos) != 1 || hello.SupportedProtos[0] != ACMETLSALPNProtocol {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certs[strings.ToLower(hello.ServerName)]
+}
+
+func acmeChallengeCertificate(domain, keyAuth string) (*Certificate, error) {
+	if domain == "" {
+		return nil, errors.New("tls: missing ACME challenge domain")
+	}
+	digest := sha256.Sum256([]byte(keyAuth))
+	extValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "ACME TLS-ALPN-01 challenge"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		DNSNames:     []string{domain},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidACMEIdentifier, Critical: true, Value: extValue},
+		},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, errors.New("tls: failed to create ACME challenge certificate: " + err.Error())
+	}
+	return &Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
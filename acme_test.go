@@ -0,0 +1,75 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestACMEChallengeResponder(t *testing.T) {
+	const keyAuth = "token.thumbprint"
+	var r ACMEChallengeResponder
+	if err := r.AddKeyAuthorization("Example.Golang", keyAuth); err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := testConfig.Clone()
+	serverConfig.NextProtos = []string{"h2"}
+	serverConfig = r.WrapConfig(serverConfig)
+
+	handshake := func(t *testing.T, serverName string, protos ...string) ConnectionState {
+		t.Helper()
+		clientConfig := testConfig.Clone()
+		clientConfig.ServerName = serverName
+		clientConfig.NextProtos = protos
+		_, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cs
+	}
+
+	t.Run("Challenge", func(t *testing.T) {
+		cs := handshake(t, "example.golang", ACMETLSALPNProtocol)
+		if cs.NegotiatedProtocol != ACMETLSALPNProtocol {
+			t.Errorf("got protocol %q", cs.NegotiatedProtocol)
+		}
+		leaf := cs.PeerCertificates[0]
+		var found bool
+		for _, ext := range leaf.Extensions {
+			if !ext.Id.Equal(oidACMEIdentifier) {
+				continue
+			}
+			found = true
+			var digest []byte
+			if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil {
+				t.Fatal(err)
+			}
+			want := sha256.Sum256([]byte(keyAuth))
+			if !ext.Critical || !bytes.Equal(digest, want[:]) {
+				t.Errorf("got acmeIdentifier critical %v, digest %x", ext.Critical, digest)
+			}
+		}
+		if !found {
+			t.Error("challenge certificate has no acmeIdentifier extension")
+		}
+	})
+	t.Run("RegularConnection", func(t *testing.T) {
+		cs := handshake(t, "example.golang", "h2", ACMETLSALPNProtocol)
+		if cs.NegotiatedProtocol != "h2" {
+			t.Errorf("got protocol %q", cs.NegotiatedProtocol)
+		}
+		if !bytes.Equal(cs.PeerCertificates[0].Raw, testConfig.Certificates[0].Certificate[0]) {
+			t.Error("regular connection got the challenge certificate")
+		}
+	})
+	t.Run("Removed", func(t *testing.T) {
+		r.Remove("example.golang")
+		clientConfig := testConfig.Clone()
+		clientConfig.ServerName = "example.golang"
+		clientConfig.NextProtos = []string{ACMETLSALPNProtocol}
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+			t.Error("challenge answered after its domain was removed")
+		}
+	})
+}
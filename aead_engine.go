@@ -0,0 +1,55 @@
+package tls
+
+import "crypto/cipher"
+
+// An AEADEngine provides the AEAD implementations that protect records, so
+// that their encryption and decryption can be offloaded, for example to a
+// cryptographic accelerator or an HSM, while the rest of the handshake runs
+// in software. It is set in [Config.AEADEngine].
+type AEADEngine interface {
+	// NewAEAD returns an AEAD for the cipher suite with ID cipherSuite,
+	// negotiated at the given protocol version, keyed with key. It is called
+	// for the AEAD cipher suites of TLS 1.2 and TLS 1.3, including SM4-GCM
+	// for SM4 hardware the sm4 package doesn't use, once for each direction
+	// and each key change, from the goroutine running the handshake or
+	// reading from the connection.
+	//
+	// The AEAD must take 12 bytes nonces and add 16 bytes tags: the
+	// per-record nonce construction of the protocol version is applied by
+	// the record layer. The AEAD is only used by one goroutine at a time.
+	//
+	// If NewAEAD returns an error, or an AEAD with other nonce or tag sizes,
+	// the built-in implementation is used instead.
+	NewAEAD(version, cipherSuite uint16, key []byte) (cipher.AEAD, error)
+}
+
+// newRecordAEAD returns the AEAD protecting records with key and the fixed
+// nonce iv, from engine if it provides one, or else from builtin.
+func newRecordAEAD(engine AEADEngine, version, suite uint16, key, iv []byte, builtin func(key, iv []byte) aead) aead {
+	if engine == nil {
+		return builtin(key, iv)
+	}
+	a, err := engine.NewAEAD(version, suite, key)
+	if err != nil || a == nil || a.NonceSize() != aeadNonceLength || a.Overhead() != 16 {
+		return builtin(key, iv)
+	}
+	// TLS 1.2 AES-GCM has a fixed nonce prefix and an explicit nonce in each
+	// record, while ChaCha20-Poly1305 and TLS 1.3 mask the sequence number.
+	if len(iv) == noncePrefixLength {
+		ret := &prefixNonceAEAD{aead: a}
+		copy(ret.nonce[:], iv)
+		return ret
+	}
+	ret := &xorNonceAEAD{aead: a}
+	copy(ret.nonceMask[:], iv)
+	return ret
+}
+
+// aeadEngine returns the AEADEngine protecting the records of c, if any.
+// QUIC connections don't use the record layer.
+func (c *Conn) aeadEngine() AEADEngine {
+	if c.quic != nil {
+		return nil
+	}
+	return c.config.AEADEngine
+}
@@ -0,0 +1,228 @@
+package tls
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+const (
+	// maxAIAResponseSize bounds the certificates downloaded by AIAFetcher,
+	// which are usually a few kilobytes.
+	maxAIAResponseSize = 1 << 16
+
+	// maxAIADepth is how many missing certificates are downloaded at most
+	// to complete a chain.
+	maxAIADepth = 4
+
+	defaultAIAFetchTimeout = 10 * time.Second
+	defaultAIACacheTTL     = 24 * time.Hour
+)
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// An AIAFetcher downloads the intermediate certificates missing from the
+// chains of peers from the caIssuers URLs of their Authority Information
+// Access extension, as web browsers do for misconfigured servers. It is set
+// in [Config.AIAFetcher], and only used when a chain can't be verified for
+// lack of an issuer.
+//
+// Downloaded certificates are cached, and downloaded once for concurrent
+// handshakes. They are only used as intermediates, so they must still chain
+// to a trusted root. The zero value is ready to use. An AIAFetcher must not be
+// copied or have its fields modified after first use.
+type AIAFetcher struct {
+	// HTTPClient is used to download certificates. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Timeout bounds each download, which is also canceled with the
+	// handshake. If zero, downloads time out after 10 seconds.
+	Timeout time.Duration
+
+	// CacheTTL is how long downloaded certificates are cached. If zero,
+	// they are cached for 24 hours.
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*aiaCacheEntry // by URL
+}
+
+type aiaCacheEntry struct {
+	mu      sync.Mutex // held while downloading
+	certs   []*x509.Certificate
+	expires time.Time
+}
+
+// chaseIntermediates builds the chains of certs again after adding the
+// issuers downloaded by c.AIAFetcher to opts.Intermediates, walking up from
+// the leaf, if building them failed with err for lack of an issuer. The
+// original error is returned if the chain remains incomplete.
+func (c *Config) chaseIntermediates(ctx context.Context, rawCerts [][]byte, certs []*x509.Certificate, opts x509.VerifyOptions, err error) ([][]*x509.Certificate, error) {
+	if _, ok := errorsAsType[x509.UnknownAuthorityError](err); !ok {
+		return nil, err
+	}
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+	} else {
+		opts.Intermediates = opts.Intermediates.Clone()
+	}
+	cert := certs[0]
+	for i := 0; i < maxAIADepth; i++ {
+		issuers, fetchErr := c.AIAFetcher.issuers(ctx, cert)
+		if fetchErr != nil {
+			return nil, fmt.Errorf("%w (failed to download missing issuer: %v)", err, fetchErr)
+		}
+		if len(issuers) == 0 {
+			break
+		}
+		for _, issuer := range issuers {
+			opts.Intermediates.AddCert(issuer)
+		}
+		chains, buildErr := c.buildCertificateChains(rawCerts, certs, opts)
+		if buildErr == nil {
+			return chains, nil
+		}
+		if _, ok := errorsAsType[x509.UnknownAuthorityError](buildErr); !ok {
+			return nil, buildErr
+		}
+		cert = issuers[0]
+	}
+	return nil, err
+}
+
+// issuers returns the certificates at the first caIssuers URL of cert that
+// can be downloaded, or nil if cert has none.
+func (f *AIAFetcher) issuers(ctx context.Context, cert *x509.Certificate) ([]*x509.Certificate, error) {
+	var errs []error
+	for _, url := range cert.IssuingCertificateURL {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+		certs, err := f.get(ctx, url)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return certs, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// get returns the certificates at url, downloading them if they're not
+// cached.
+func (f *AIAFetcher) get(ctx context.Context, url string) ([]*x509.Certificate, error) {
+	f.mu.Lock()
+	if f.cache == nil {
+		f.cache = make(map[string]*aiaCacheEntry)
+	}
+	e, ok := f.cache[url]
+	if !ok {
+		e = &aiaCacheEntry{}
+		f.cache[url] = e
+	}
+	f.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if e.certs != nil && now.Before(e.expires) {
+		return e.certs, nil
+	}
+	certs, err := f.download(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	ttl := f.CacheTTL
+	if ttl == 0 {
+		ttl = defaultAIACacheTTL
+	}
+	e.certs, e.expires = certs, now.Add(ttl)
+	return certs, nil
+}
+
+func (f *AIAFetcher) download(ctx context.Context, url string) ([]*x509.Certificate, error) {
+	client := f.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	timeout := f.Timeout
+	if timeout == 0 {
+		timeout = defaultAIAFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAIAResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAIAResponseSize {
+		return nil, errors.New("certificate too large")
+	}
+	return parseAIACertificates(data)
+}
+
+// parseAIACertificates parses a certificate served at a caIssuers URL, which
+// is either DER encoded or a certs-only CMS message (RFC 5280, Section
+// 4.2.2.1). PEM encoded certificates, which some servers use, are accepted
+// too.
+func parseAIACertificates(data []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil && block.Type == "CERTIFICATE" {
+		data = block.Bytes
+	}
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return []*x509.Certificate{cert}, nil
+	}
+
+	// ContentInfo and SignedData, see RFC 5652, Sections 3 and 5.1.
+	var contentType asn1.ObjectIdentifier
+	var version int
+	var content, signedData, rawCerts cryptobyte.String
+	s := cryptobyte.String(data)
+	if !s.ReadASN1(&s, cryptobyte_asn1.SEQUENCE) ||
+		!s.ReadASN1ObjectIdentifier(&contentType) || !contentType.Equal(oidSignedData) ||
+		!s.ReadASN1(&content, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) ||
+		!content.ReadASN1(&signedData, cryptobyte_asn1.SEQUENCE) ||
+		!signedData.ReadASN1Integer(&version) ||
+		!signedData.SkipASN1(cryptobyte_asn1.SET) || // digestAlgorithms
+		!signedData.SkipASN1(cryptobyte_asn1.SEQUENCE) || // encapContentInfo
+		!signedData.ReadASN1(&rawCerts, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, errors.New("failed to parse certificate")
+	}
+	var certs []*x509.Certificate
+	for !rawCerts.Empty() {
+		var der cryptobyte.String
+		if !rawCerts.ReadASN1Element(&der, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("failed to parse certificates-only message")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
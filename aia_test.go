@@ -0,0 +1,148 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// certsOnlyMessage returns a degenerate CMS SignedData message carrying
+// certs, as served at some caIssuers URLs.
+func certsOnlyMessage(certs ...[]byte) []byte {
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidSignedData)
+		b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1Int64(1)
+				b.AddASN1(cryptobyte_asn1.SET, func(b *cryptobyte.Builder) {})
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1ObjectIdentifier([]int{1, 2, 840, 113549, 1, 7, 1})
+				})
+				b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+					for _, cert := range certs {
+						b.AddBytes(cert)
+					}
+				})
+				b.AddASN1(cryptobyte_asn1.SET, func(b *cryptobyte.Builder) {})
+			})
+		})
+	})
+	return b.BytesOrPanic()
+}
+
+func TestAIAFetcher(t *testing.T) {
+	now := time.Now()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert := func(tmpl, parent *x509.Certificate) (*x509.Certificate, []byte) {
+		tmpl.NotBefore, tmpl.NotAfter = now.Add(-time.Hour), now.Add(time.Hour)
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, der
+	}
+	root, _ := newCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "AIA test root"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil)
+	intermediate, intermediateDER := newCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "AIA test intermediate"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, root)
+
+	var downloads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		downloads.Add(1)
+		switch req.URL.Path {
+		case "/intermediate.cer":
+			w.Write(intermediateDER)
+		case "/intermediate.p7c":
+			w.Write(certsOnlyMessage(intermediateDER))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	for _, tt := range []struct {
+		name    string
+		path    string
+		fetcher bool
+		wantErr string
+	}{
+		{name: "DER", path: "/intermediate.cer", fetcher: true},
+		{name: "CertsOnly", path: "/intermediate.p7c", fetcher: true},
+		{name: "Disabled", path: "/intermediate.cer", wantErr: "unknown authority"},
+		{name: "NotFound", path: "/missing", fetcher: true, wantErr: "404"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, leafDER := newCert(&x509.Certificate{
+				SerialNumber:          big.NewInt(3),
+				DNSNames:              []string{"example.golang"},
+				IssuingCertificateURL: []string{srv.URL + tt.path},
+			}, intermediate)
+			serverConfig := testConfig.Clone()
+			serverConfig.Certificates = []Certificate{{Certificate: [][]byte{leafDER}, PrivateKey: key}}
+			serverConfig.NameToCertificate = nil
+			clientConfig := testConfig.Clone()
+			clientConfig.InsecureSkipVerify = false
+			clientConfig.ServerName = "example.golang"
+			clientConfig.RootCAs = roots
+			clientConfig.Time = nil
+			if tt.fetcher {
+				clientConfig.AIAFetcher = &AIAFetcher{}
+			}
+
+			downloads.Store(0)
+			for i := 0; i < 2; i++ {
+				_, cs, err := testHandshake(t, clientConfig, serverConfig)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(cs.VerifiedChains) != 1 || len(cs.VerifiedChains[0]) != 3 {
+					t.Fatalf("got verified chains %v", cs.VerifiedChains)
+				}
+			}
+			if n := downloads.Load(); n != 1 {
+				t.Errorf("intermediate downloaded %d times, want once", n)
+			}
+		})
+	}
+}
@@ -4,7 +4,11 @@
 
 package tls
 
-import "strconv"
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
 
 // An AlertError is a TLS alert.
 //
@@ -16,6 +20,63 @@ func (e AlertError) Error() string {
 	return alert(e).String()
 }
 
+// A DirectedAlertError is a TLS alert that ended a connection, with whether
+// it was sent to the peer or received from it, for callers to tell apart,
+// say, a peer rejecting the certificate of this side with unknown_ca from
+// this side rejecting that of the peer. The errors of the handshakes, and of
+// the reads and writes, failing with an alert wrap one, and so its Alert,
+// except for the errors of the callbacks of Config, which are returned as
+// is.
+type DirectedAlertError struct {
+	Alert AlertError
+
+	// Sent is true if the alert was sent to the peer, and false if it was
+	// received from it.
+	Sent bool
+}
+
+func (e *DirectedAlertError) Error() string {
+	return e.Alert.Error()
+}
+
+func (e *DirectedAlertError) Unwrap() error {
+	return e.Alert
+}
+
+// alertError returns the error of the connection that sent or received a,
+// with the text of a net.OpError.
+func alertError(a alert, sent bool) error {
+	op := "remote error"
+	if sent {
+		op = "local error"
+	}
+	return fmt.Errorf("%w%.0w", &net.OpError{Op: op, Err: a}, &DirectedAlertError{Alert: AlertError(a), Sent: sent})
+}
+
+// An AlertInfo describes a TLS alert sent or received by a connection,
+// reported to [Config.AlertObserver].
+type AlertInfo struct {
+	// Sent is true for the alerts sent, and false for those received.
+	Sent bool
+
+	// Level is 1 for warning alerts, and 2 for fatal ones, as sent on the
+	// wire. Received alerts of other levels are reported as is.
+	Level uint8
+
+	// Alert is the description of the alert, such as 42 for bad_certificate.
+	Alert AlertError
+}
+
+// observeAlert reports an alert of level sent or received by c to
+// Config.AlertObserver.
+func (c *Conn) observeAlert(sent bool, level uint8, a alert) {
+	info := AlertInfo{Sent: sent, Level: level, Alert: AlertError(a)}
+	c.qlog.alert(info)
+	if c.config.AlertObserver != nil {
+		c.config.AlertObserver(info)
+	}
+}
+
 type alert uint8
 
 const (
@@ -0,0 +1,91 @@
+package tls
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDirectedAlertError(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.InsecureSkipVerify = false
+	clientConfig.ServerName = "example.golang"
+	clientConfig.Time = testTime
+	serverErr, clientErr := func() (error, error) {
+		c, s := localPipe(t)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- Server(s, testConfig).Handshake()
+			s.Close()
+		}()
+		err := Client(c, clientConfig).Handshake()
+		c.Close()
+		return <-errChan, err
+	}()
+
+	check := func(side string, err error, sent bool) {
+		a, ok := errorsAsType[*DirectedAlertError](err)
+		if !ok {
+			t.Fatalf("%s error %v doesn't wrap a DirectedAlertError", side, err)
+		}
+		if a.Sent != sent || a.Alert != AlertError(alertBadCertificate) {
+			t.Errorf("%s error wraps %+v", side, a)
+		}
+		if !errors.Is(err, AlertError(alertBadCertificate)) {
+			t.Errorf("%s error %v doesn't wrap the AlertError", side, err)
+		}
+	}
+	check("client", clientErr, true)
+	check("server", serverErr, false)
+	if want := "remote error: tls: bad certificate"; serverErr.Error() != want {
+		t.Errorf("server error %q, want %q", serverErr, want)
+	}
+}
+
+func TestDirectedAlertErrorCallback(t *testing.T) {
+	// The errors of callbacks are returned as is, even those looking like
+	// the errors of this package.
+	callbackErr := errors.New("tls: rejected by VerifyConnection")
+	clientConfig := testConfig.Clone()
+	clientConfig.VerifyConnection = func(ConnectionState) error { return callbackErr }
+	c, s := localPipe(t)
+	go func() {
+		Server(s, testConfig).Handshake()
+		s.Close()
+	}()
+	err := Client(c, clientConfig).Handshake()
+	c.Close()
+	if err != callbackErr {
+		t.Errorf("got error %v, want the error of VerifyConnection as is", err)
+	}
+}
+
+func TestAlertObserver(t *testing.T) {
+	var mu sync.Mutex
+	var alerts []AlertInfo
+	observer := func(a AlertInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		alerts = append(alerts, a)
+	}
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	clientConfig.AlertObserver = observer
+	serverConfig := testConfig.Clone()
+	serverConfig.MinVersion = VersionTLS13
+	serverConfig.AlertObserver = observer
+
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+		t.Fatal("handshake succeeded")
+	}
+	want := []AlertInfo{
+		{Sent: true, Level: alertLevelError, Alert: AlertError(alertProtocolVersion)},
+		{Sent: false, Level: alertLevelError, Alert: AlertError(alertProtocolVersion)},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(alerts, want) {
+		t.Errorf("observed alerts %+v, want %+v", alerts, want)
+	}
+}
@@ -0,0 +1,50 @@
+package tls
+
+// The chunks a handshakeArena carves messages from start small, since servers
+// read only a couple of short messages, and grow for certificate chains.
+const (
+	minHandshakeArenaChunk = 1024
+	maxHandshakeArenaChunk = 8192
+)
+
+// A handshakeArena provides the memory for the copies of the handshake
+// messages read during a handshake, to avoid allocating every one of them
+// separately. They are carved from a few chunks, which are never reused,
+// since parsed messages and what they are parsed into keep references to
+// their bytes, and are collected when those are gone.
+//
+// The arena is dropped when the handshake completes.
+type handshakeArena struct {
+	chunk     []byte // the unused part of the current chunk
+	chunkSize int
+}
+
+// alloc returns a slice of length and capacity n, so that appending to it
+// never overwrites the next allocation.
+func (a *handshakeArena) alloc(n int) []byte {
+	if a == nil || n > maxHandshakeArenaChunk/2 {
+		return make([]byte, n)
+	}
+	if n > len(a.chunk) {
+		a.chunkSize *= 2
+		if a.chunkSize < minHandshakeArenaChunk {
+			a.chunkSize = minHandshakeArenaChunk
+		} else if a.chunkSize > maxHandshakeArenaChunk {
+			a.chunkSize = maxHandshakeArenaChunk
+		}
+		for a.chunkSize < 2*n {
+			a.chunkSize *= 2
+		}
+		a.chunk = make([]byte, a.chunkSize)
+	}
+	b := a.chunk[:n:n]
+	a.chunk = a.chunk[n:]
+	return b
+}
+
+// clone returns a copy of b allocated from a.
+func (a *handshakeArena) clone(b []byte) []byte {
+	c := a.alloc(len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,87 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestARIA checks the test vectors of RFC 5794, Appendix A.
+func TestARIA(t *testing.T) {
+	plaintext, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+	for _, tt := range []struct {
+		key, ciphertext string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "d718fbd6ab644c739da95f3be6451778"},
+		{"000102030405060708090a0b0c0d0e0f1011121314151617", "26449c1805dbe7aa25a468ce263a9e79"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "f92bd7c79fb72e2f2b8f80c1972d24fc"},
+	} {
+		key, _ := hex.DecodeString(tt.key)
+		block, err := newARIACipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, 16)
+		block.Encrypt(out, plaintext)
+		if got := hex.EncodeToString(out); got != tt.ciphertext {
+			t.Errorf("ARIA-%d: got ciphertext %s, want %s", len(key)*8, got, tt.ciphertext)
+		}
+		block.Decrypt(out, out)
+		if !bytes.Equal(out, plaintext) {
+			t.Errorf("ARIA-%d: got plaintext %x, want %x", len(key)*8, out, plaintext)
+		}
+	}
+	if _, err := newARIACipher(make([]byte, 20)); err == nil {
+		t.Error("ARIA accepted a 160-bit key")
+	}
+}
+
+func TestARIACamelliaCipherSuites(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		suite  uint16
+		ecdsa  bool
+		server []uint16
+		want   uint16
+	}{
+		{name: "ARIA128", suite: TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256},
+		{name: "ARIA256", suite: TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384},
+		{name: "ARIA128-ECDSA", suite: TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, ecdsa: true},
+		{name: "Camellia128", suite: TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256},
+		{name: "Camellia256", suite: TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384},
+		{name: "Camellia256-ECDSA", suite: TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384, ecdsa: true},
+		// The opt-in suites come after the others, and not by default.
+		{name: "PreferredLast", suite: TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256,
+			server: []uint16{TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+			want:   TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+		{name: "NotDefault", suite: TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256,
+			server: defaultCipherSuites(true), want: TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.CipherSuites = []uint16{tt.suite, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}
+			clientConfig.MaxVersion = VersionTLS12
+			serverConfig := testConfig.Clone()
+			serverConfig.CipherSuites = []uint16{tt.suite}
+			if tt.server != nil {
+				serverConfig.CipherSuites = tt.server
+			}
+			if tt.ecdsa {
+				serverConfig.Certificates = []Certificate{{
+					Certificate: [][]byte{testECDSACertificate},
+					PrivateKey:  testECDSAPrivateKey,
+				}}
+				serverConfig.NameToCertificate = nil
+			}
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := cmpOr(tt.want, tt.suite)
+			if cs.CipherSuite != want {
+				t.Errorf("got cipher suite %s, want %s", CipherSuiteName(cs.CipherSuite), CipherSuiteName(want))
+			}
+		})
+	}
+}
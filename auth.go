@@ -11,10 +11,15 @@ import (
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
+
+	"github.com/cloudflare/circl/sign/ed448"
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
 )
 
 // verifyHandshakeSignature verifies a signature against unhashed handshake contents.
@@ -41,6 +46,22 @@ func verifyHandshakeSignature(sigType uint8, pubkey crypto.PublicKey, hashFunc c
 		if !ed25519.Verify(pubKey, signed, sig) {
 			return errors.New("Ed25519 verification failure")
 		}
+	case signatureEd448:
+		pubKey, ok := pubkey.(ed448.PublicKey)
+		if !ok {
+			return fmt.Errorf("expected an Ed448 public key, got %T", pubkey)
+		}
+		if !ed448.Verify(pubKey, signed, sig, "") {
+			return errors.New("Ed448 verification failure")
+		}
+	case signatureSM2:
+		pubKey, ok := pubkey.(*ecdsa.PublicKey)
+		if !ok || !isSM2PublicKey(pubKey) {
+			return fmt.Errorf("expected an SM2 public key, got %T", pubkey)
+		}
+		if !sm2.VerifyASN1WithSM2(pubKey, sm2SignatureID, signed, sig) {
+			return errors.New("SM2 verification failure")
+		}
 	case signaturePKCS1v15:
 		pubKey, ok := pubkey.(*rsa.PublicKey)
 		if !ok {
@@ -129,6 +150,10 @@ func typeAndHashFromSignatureScheme(signatureAlgorithm SignatureScheme) (sigType
 		sigType = signatureECDSA
 	case Ed25519:
 		sigType = signatureEd25519
+	case Ed448:
+		sigType = signatureEd448
+	case SM2WithSM3:
+		sigType = signatureSM2
 	default:
 		return 0, 0, fmt.Errorf("unsupported signature algorithm: %v", signatureAlgorithm)
 	}
@@ -141,7 +166,8 @@ func typeAndHashFromSignatureScheme(signatureAlgorithm SignatureScheme) (sigType
 		hash = crypto.SHA384
 	case PKCS1WithSHA512, PSSWithSHA512, ECDSAWithP521AndSHA512:
 		hash = crypto.SHA512
-	case Ed25519:
+	case Ed25519, Ed448, SM2WithSM3:
+		// SM2 hashes the message with SM3 together with the key.
 		hash = directSigning
 	default:
 		return 0, 0, fmt.Errorf("unsupported signature algorithm: %v", signatureAlgorithm)
@@ -157,6 +183,9 @@ func legacyTypeAndHashFromPublicKey(pub crypto.PublicKey) (sigType uint8, hash c
 	case *rsa.PublicKey:
 		return signaturePKCS1v15, crypto.MD5SHA1, nil
 	case *ecdsa.PublicKey:
+		if isSM2PublicKey(pub) {
+			return 0, 0, fmt.Errorf("tls: SM2 public keys are only supported in TLS 1.3")
+		}
 		return signatureECDSA, crypto.SHA1, nil
 	case ed25519.PublicKey:
 		// RFC 8422 specifies support for Ed25519 in TLS 1.0 and 1.1,
@@ -164,6 +193,8 @@ func legacyTypeAndHashFromPublicKey(pub crypto.PublicKey) (sigType uint8, hash c
 		// full signature, and not even OpenSSL bothers with the
 		// complexity, so we can't even test it properly.
 		return 0, 0, fmt.Errorf("tls: Ed25519 public keys are not supported before TLS 1.2")
+	case ed448.PublicKey:
+		return 0, 0, fmt.Errorf("tls: Ed448 public keys are not supported before TLS 1.2")
 	default:
 		return 0, 0, fmt.Errorf("tls: unsupported public key: %T", pub)
 	}
@@ -189,6 +220,12 @@ var rsaSignatureSchemes = []struct {
 func signatureSchemesForPublicKey(version uint16, pub crypto.PublicKey) []SignatureScheme {
 	switch pub := pub.(type) {
 	case *ecdsa.PublicKey:
+		if isSM2PublicKey(pub) {
+			if version < VersionTLS13 {
+				return nil
+			}
+			return []SignatureScheme{SM2WithSM3}
+		}
 		if version < VersionTLS13 {
 			// In TLS 1.2 and earlier, ECDSA algorithms are not
 			// constrained to a single curve.
@@ -220,6 +257,8 @@ func signatureSchemesForPublicKey(version uint16, pub crypto.PublicKey) []Signat
 		return sigAlgs
 	case ed25519.PublicKey:
 		return []SignatureScheme{Ed25519}
+	case ed448.PublicKey:
+		return []SignatureScheme{Ed448}
 	default:
 		return nil
 	}
@@ -268,11 +307,13 @@ func selectSignatureScheme(vers uint16, c *Certificate, peerAlgs []SignatureSche
 // an unsupported private key.
 func unsupportedCertificateError(cert *Certificate) error {
 	switch cert.PrivateKey.(type) {
-	case rsa.PrivateKey, ecdsa.PrivateKey:
+	case rsa.PrivateKey, ecdsa.PrivateKey, sm2.PrivateKey:
 		return fmt.Errorf("tls: unsupported certificate: private key is %T, expected *%T",
 			cert.PrivateKey, cert.PrivateKey)
 	case *ed25519.PrivateKey:
 		return fmt.Errorf("tls: unsupported certificate: private key is *ed25519.PrivateKey, expected ed25519.PrivateKey")
+	case *ed448.PrivateKey:
+		return fmt.Errorf("tls: unsupported certificate: private key is *ed448.PrivateKey, expected ed448.PrivateKey")
 	}
 
 	signer, ok := cert.PrivateKey.(crypto.Signer)
@@ -287,12 +328,14 @@ func unsupportedCertificateError(cert *Certificate) error {
 		case elliptic.P256():
 		case elliptic.P384():
 		case elliptic.P521():
+		case sm2.P256():
+			return fmt.Errorf("tls: SM2 certificates are only supported in TLS 1.3")
 		default:
 			return fmt.Errorf("tls: unsupported certificate curve (%s)", pub.Curve.Params().Name)
 		}
 	case *rsa.PublicKey:
 		return fmt.Errorf("tls: certificate RSA key size too small for supported signature algorithms")
-	case ed25519.PublicKey:
+	case ed25519.PublicKey, ed448.PublicKey:
 	default:
 		return fmt.Errorf("tls: unsupported certificate key (%T)", pub)
 	}
@@ -303,3 +346,72 @@ func unsupportedCertificateError(cert *Certificate) error {
 
 	return fmt.Errorf("tls: internal error: unsupported key (%T)", cert.PrivateKey)
 }
+
+// certificateSignatureScheme returns the SignatureScheme of the signature on
+// cert, or 0 if it has none. ECDSA signatures are matched by hash, since the
+// curve is that of the issuer.
+func certificateSignatureScheme(cert *x509.Certificate) SignatureScheme {
+	switch cert.SignatureAlgorithm {
+	case x509.SHA1WithRSA:
+		return PKCS1WithSHA1
+	case x509.SHA256WithRSA:
+		return PKCS1WithSHA256
+	case x509.SHA384WithRSA:
+		return PKCS1WithSHA384
+	case x509.SHA512WithRSA:
+		return PKCS1WithSHA512
+	case x509.SHA256WithRSAPSS:
+		return PSSWithSHA256
+	case x509.SHA384WithRSAPSS:
+		return PSSWithSHA384
+	case x509.SHA512WithRSAPSS:
+		return PSSWithSHA512
+	case x509.ECDSAWithSHA1:
+		return ECDSAWithSHA1
+	case x509.ECDSAWithSHA256:
+		return ECDSAWithP256AndSHA256
+	case x509.ECDSAWithSHA384:
+		return ECDSAWithP384AndSHA384
+	case x509.ECDSAWithSHA512:
+		return ECDSAWithP521AndSHA512
+	case x509.PureEd25519:
+		return Ed25519
+	case smx509.SM2WithSM3:
+		return SM2WithSM3
+	}
+	return 0
+}
+
+// checkSignatureSchemes returns an error if a certificate of certs, other than
+// a self-signed one, is signed with an algorithm not in schemes.
+func checkSignatureSchemes(certs []*x509.Certificate, schemes []SignatureScheme) error {
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			continue
+		}
+		if !isSupportedSignatureAlgorithm(certificateSignatureScheme(cert), schemes) {
+			return fmt.Errorf("certificate %v is signed with unsupported algorithm %v", cert.Subject, cert.SignatureAlgorithm)
+		}
+	}
+	return nil
+}
+
+// chainSignedWith returns nil if the certificates of the chain c are signed
+// with algorithms in schemes, or if schemes is empty.
+func chainSignedWith(c *Certificate, schemes []SignatureScheme) error {
+	if len(schemes) == 0 {
+		return nil
+	}
+	certs := make([]*x509.Certificate, 0, len(c.Certificate))
+	for j, cert := range c.Certificate {
+		x509Cert := c.Leaf
+		if j != 0 || x509Cert == nil {
+			var err error
+			if x509Cert, err = parseCertificate(cert); err != nil {
+				return fmt.Errorf("failed to parse certificate #%d in the chain: %w", j, err)
+			}
+		}
+		certs = append(certs, x509Cert)
+	}
+	return checkSignatureSchemes(certs, schemes)
+}
@@ -5,6 +5,7 @@ package tls
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 func contextAfterFunc(ctx context.Context, f func()) (stop func() bool) {
@@ -31,3 +32,18 @@ func contextAfterFunc(ctx context.Context, f func()) (stop func() bool) {
 		return stopped
 	}
 }
+
+func contextWithoutCancel(parent context.Context) context.Context {
+	return withoutCancelCtx{parent}
+}
+
+// withoutCancelCtx is the context.WithoutCancel of Go 1.21.
+type withoutCancelCtx struct {
+	c context.Context
+}
+
+func (withoutCancelCtx) Deadline() (deadline time.Time, ok bool) { return }
+func (withoutCancelCtx) Done() <-chan struct{}                   { return nil }
+func (withoutCancelCtx) Err() error                              { return nil }
+
+func (c withoutCancelCtx) Value(key any) any { return c.c.Value(key) }
@@ -5,3 +5,5 @@ package tls
 import "context"
 
 var contextAfterFunc = context.AfterFunc
+
+var contextWithoutCancel = context.WithoutCancel
@@ -0,0 +1,214 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strconv"
+)
+
+// camelliaCipher is the Camellia block cipher of RFC 3713, for the Camellia
+// cipher suites of RFC 6367. Like ariaCipher, it's based on S-box lookups,
+// which are not constant time.
+type camelliaCipher struct {
+	// k are the round keys, 18 or 24 of them depending on the key size, ke
+	// the keys of the FL layers after every six rounds, and kw the keys
+	// whitening the input and output.
+	k, kDec   []uint64
+	ke, keDec []uint64
+	kw, kwDec [4]uint64
+}
+
+// camelliaSigma are the constants of the Camellia key schedule, see RFC 3713,
+// Section 2.2.
+var camelliaSigma = [6]uint64{
+	0xa09e667f3bcc908b, 0xb67ae8584caa73b2, 0xc6ef372fe94f82be,
+	0x54ff53a5f1d36f1c, 0x10e527fade682d1d, 0xb05688c2b3e6c1fd,
+}
+
+func newCamelliaCipher(key []byte) (cipher.Block, error) {
+	var kl, kr [2]uint64
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("tls: invalid Camellia key size " + strconv.Itoa(len(key)))
+	}
+	kl[0], kl[1] = binary.BigEndian.Uint64(key[:8]), binary.BigEndian.Uint64(key[8:16])
+	switch len(key) {
+	case 24:
+		kr[0] = binary.BigEndian.Uint64(key[16:])
+		kr[1] = ^kr[0]
+	case 32:
+		kr[0], kr[1] = binary.BigEndian.Uint64(key[16:24]), binary.BigEndian.Uint64(key[24:])
+	}
+
+	d1, d2 := kl[0]^kr[0], kl[1]^kr[1]
+	d2 ^= camelliaF(d1, camelliaSigma[0])
+	d1 ^= camelliaF(d2, camelliaSigma[1])
+	d1, d2 = d1^kl[0], d2^kl[1]
+	d2 ^= camelliaF(d1, camelliaSigma[2])
+	d1 ^= camelliaF(d2, camelliaSigma[3])
+	ka := [2]uint64{d1, d2}
+	d1, d2 = ka[0]^kr[0], ka[1]^kr[1]
+	d2 ^= camelliaF(d1, camelliaSigma[4])
+	d1 ^= camelliaF(d2, camelliaSigma[5])
+	kb := [2]uint64{d1, d2}
+
+	// The subkeys are halves of rotations of KL, KR, KA and KB, see RFC
+	// 3713, Section 2.2.
+	c := new(camelliaCipher)
+	if len(key) == 16 {
+		c.kw[0], c.kw[1] = kl[0], kl[1]
+		c.k = append(c.k, camelliaRotateLeft(ka, 0)...)
+		c.k = append(c.k, camelliaRotateLeft(kl, 15)...)
+		c.k = append(c.k, camelliaRotateLeft(ka, 15)...)
+		c.ke = append(c.ke, camelliaRotateLeft(ka, 30)...)
+		c.k = append(c.k, camelliaRotateLeft(kl, 45)...)
+		c.k = append(c.k, camelliaRotateLeft(ka, 45)[0], camelliaRotateLeft(kl, 60)[1])
+		c.k = append(c.k, camelliaRotateLeft(ka, 60)...)
+		c.ke = append(c.ke, camelliaRotateLeft(kl, 77)...)
+		c.k = append(c.k, camelliaRotateLeft(kl, 94)...)
+		c.k = append(c.k, camelliaRotateLeft(ka, 94)...)
+		c.k = append(c.k, camelliaRotateLeft(kl, 111)...)
+		kw := camelliaRotateLeft(ka, 111)
+		c.kw[2], c.kw[3] = kw[0], kw[1]
+	} else {
+		c.kw[0], c.kw[1] = kl[0], kl[1]
+		c.k = append(c.k, camelliaRotateLeft(kb, 0)...)
+		c.k = append(c.k, camelliaRotateLeft(kr, 15)...)
+		c.k = append(c.k, camelliaRotateLeft(ka, 15)...)
+		c.ke = append(c.ke, camelliaRotateLeft(kr, 30)...)
+		c.k = append(c.k, camelliaRotateLeft(kb, 30)...)
+		c.k = append(c.k, camelliaRotateLeft(kl, 45)...)
+		c.k = append(c.k, camelliaRotateLeft(ka, 45)...)
+		c.ke = append(c.ke, camelliaRotateLeft(kl, 60)...)
+		c.k = append(c.k, camelliaRotateLeft(kr, 60)...)
+		c.k = append(c.k, camelliaRotateLeft(kb, 60)...)
+		c.k = append(c.k, camelliaRotateLeft(kl, 77)...)
+		c.ke = append(c.ke, camelliaRotateLeft(ka, 77)...)
+		c.k = append(c.k, camelliaRotateLeft(kr, 94)...)
+		c.k = append(c.k, camelliaRotateLeft(ka, 94)...)
+		c.k = append(c.k, camelliaRotateLeft(kl, 111)...)
+		kw := camelliaRotateLeft(kb, 111)
+		c.kw[2], c.kw[3] = kw[0], kw[1]
+	}
+
+	// Decryption uses the same network with the subkeys in reverse order.
+	c.kwDec = [4]uint64{c.kw[2], c.kw[3], c.kw[0], c.kw[1]}
+	c.kDec = make([]uint64, len(c.k))
+	for i, k := range c.k {
+		c.kDec[len(c.k)-1-i] = k
+	}
+	c.keDec = make([]uint64, len(c.ke))
+	for i, ke := range c.ke {
+		c.keDec[len(c.ke)-1-i] = ke
+	}
+	return c, nil
+}
+
+func (c *camelliaCipher) BlockSize() int { return 16 }
+
+func (c *camelliaCipher) Encrypt(dst, src []byte) { c.crypt(dst, src, &c.kw, c.k, c.ke) }
+
+func (c *camelliaCipher) Decrypt(dst, src []byte) { c.crypt(dst, src, &c.kwDec, c.kDec, c.keDec) }
+
+func (c *camelliaCipher) crypt(dst, src []byte, kw *[4]uint64, k, ke []uint64) {
+	if len(src) < 16 || len(dst) < 16 {
+		panic("tls: Camellia input not full block")
+	}
+	d1 := binary.BigEndian.Uint64(src[:8]) ^ kw[0]
+	d2 := binary.BigEndian.Uint64(src[8:16]) ^ kw[1]
+	for i := 0; i < len(k); i += 2 {
+		if i > 0 && i%6 == 0 {
+			d1 = camelliaFL(d1, ke[i/3-2])
+			d2 = camelliaFLInv(d2, ke[i/3-1])
+		}
+		d2 ^= camelliaF(d1, k[i])
+		d1 ^= camelliaF(d2, k[i+1])
+	}
+	binary.BigEndian.PutUint64(dst[:8], d2^kw[2])
+	binary.BigEndian.PutUint64(dst[8:16], d1^kw[3])
+}
+
+// camelliaRotateLeft returns the halves of the 128-bit x rotated left by n
+// bits.
+func camelliaRotateLeft(x [2]uint64, n uint) []uint64 {
+	hi, lo := x[0], x[1]
+	if n >= 64 {
+		hi, lo = lo, hi
+		n -= 64
+	}
+	if n > 0 {
+		hi, lo = hi<<n|lo>>(64-n), lo<<n|hi>>(64-n)
+	}
+	return []uint64{hi, lo}
+}
+
+// camelliaF is the round function, see RFC 3713, Section 2.4.1.
+func camelliaF(in, k uint64) uint64 {
+	x := in ^ k
+	t1 := camelliaSBox1[byte(x>>56)]
+	t2 := bits.RotateLeft8(camelliaSBox1[byte(x>>48)], 1)
+	t3 := bits.RotateLeft8(camelliaSBox1[byte(x>>40)], 7)
+	t4 := camelliaSBox1[bits.RotateLeft8(byte(x>>32), 1)]
+	t5 := bits.RotateLeft8(camelliaSBox1[byte(x>>24)], 1)
+	t6 := bits.RotateLeft8(camelliaSBox1[byte(x>>16)], 7)
+	t7 := camelliaSBox1[bits.RotateLeft8(byte(x>>8), 1)]
+	t8 := camelliaSBox1[byte(x)]
+	y1 := t1 ^ t3 ^ t4 ^ t6 ^ t7 ^ t8
+	y2 := t1 ^ t2 ^ t4 ^ t5 ^ t7 ^ t8
+	y3 := t1 ^ t2 ^ t3 ^ t5 ^ t6 ^ t8
+	y4 := t2 ^ t3 ^ t4 ^ t5 ^ t6 ^ t7
+	y5 := t1 ^ t2 ^ t6 ^ t7 ^ t8
+	y6 := t2 ^ t3 ^ t5 ^ t7 ^ t8
+	y7 := t3 ^ t4 ^ t5 ^ t6 ^ t8
+	y8 := t1 ^ t4 ^ t5 ^ t6 ^ t7
+	return uint64(y1)<<56 | uint64(y2)<<48 | uint64(y3)<<40 | uint64(y4)<<32 |
+		uint64(y5)<<24 | uint64(y6)<<16 | uint64(y7)<<8 | uint64(y8)
+}
+
+// camelliaFL and camelliaFLInv are the FL layer and its inverse, see RFC
+// 3713, Sections 2.4.2 and 2.4.3.
+func camelliaFL(in, k uint64) uint64 {
+	x1, x2 := uint32(in>>32), uint32(in)
+	x2 ^= bits.RotateLeft32(x1&uint32(k>>32), 1)
+	x1 ^= x2 | uint32(k)
+	return uint64(x1)<<32 | uint64(x2)
+}
+
+func camelliaFLInv(in, k uint64) uint64 {
+	y1, y2 := uint32(in>>32), uint32(in)
+	y1 ^= y2 | uint32(k)
+	y2 ^= bits.RotateLeft32(y1&uint32(k>>32), 1)
+	return uint64(y1)<<32 | uint64(y2)
+}
+
+func aeadCamelliaGCM(key, noncePrefix []byte) aead {
+	block, err := newCamelliaCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	return newPrefixNonceGCM(block, noncePrefix)
+}
+
+// camelliaSBox1 is the first S-box of RFC 3713, Section 2.4.4, from which the
+// other three are derived.
+var camelliaSBox1 = [256]byte{
+	0x70, 0x82, 0x2c, 0xec, 0xb3, 0x27, 0xc0, 0xe5, 0xe4, 0x85, 0x57, 0x35, 0xea, 0x0c, 0xae, 0x41,
+	0x23, 0xef, 0x6b, 0x93, 0x45, 0x19, 0xa5, 0x21, 0xed, 0x0e, 0x4f, 0x4e, 0x1d, 0x65, 0x92, 0xbd,
+	0x86, 0xb8, 0xaf, 0x8f, 0x7c, 0xeb, 0x1f, 0xce, 0x3e, 0x30, 0xdc, 0x5f, 0x5e, 0xc5, 0x0b, 0x1a,
+	0xa6, 0xe1, 0x39, 0xca, 0xd5, 0x47, 0x5d, 0x3d, 0xd9, 0x01, 0x5a, 0xd6, 0x51, 0x56, 0x6c, 0x4d,
+	0x8b, 0x0d, 0x9a, 0x66, 0xfb, 0xcc, 0xb0, 0x2d, 0x74, 0x12, 0x2b, 0x20, 0xf0, 0xb1, 0x84, 0x99,
+	0xdf, 0x4c, 0xcb, 0xc2, 0x34, 0x7e, 0x76, 0x05, 0x6d, 0xb7, 0xa9, 0x31, 0xd1, 0x17, 0x04, 0xd7,
+	0x14, 0x58, 0x3a, 0x61, 0xde, 0x1b, 0x11, 0x1c, 0x32, 0x0f, 0x9c, 0x16, 0x53, 0x18, 0xf2, 0x22,
+	0xfe, 0x44, 0xcf, 0xb2, 0xc3, 0xb5, 0x7a, 0x91, 0x24, 0x08, 0xe8, 0xa8, 0x60, 0xfc, 0x69, 0x50,
+	0xaa, 0xd0, 0xa0, 0x7d, 0xa1, 0x89, 0x62, 0x97, 0x54, 0x5b, 0x1e, 0x95, 0xe0, 0xff, 0x64, 0xd2,
+	0x10, 0xc4, 0x00, 0x48, 0xa3, 0xf7, 0x75, 0xdb, 0x8a, 0x03, 0xe6, 0xda, 0x09, 0x3f, 0xdd, 0x94,
+	0x87, 0x5c, 0x83, 0x02, 0xcd, 0x4a, 0x90, 0x33, 0x73, 0x67, 0xf6, 0xf3, 0x9d, 0x7f, 0xbf, 0xe2,
+	0x52, 0x9b, 0xd8, 0x26, 0xc8, 0x37, 0xc6, 0x3b, 0x81, 0x96, 0x6f, 0x4b, 0x13, 0xbe, 0x63, 0x2e,
+	0xe9, 0x79, 0xa7, 0x8c, 0x9f, 0x6e, 0xbc, 0x8e, 0x29, 0xf5, 0xf9, 0xb6, 0x2f, 0xfd, 0xb4, 0x59,
+	0x78, 0x98, 0x06, 0x6a, 0xe7, 0x46, 0x71, 0xba, 0xd4, 0x25, 0xab, 0x42, 0x88, 0xa2, 0x8d, 0xfa,
+	0x72, 0x07, 0xb9, 0x55, 0xf8, 0xee, 0xac, 0x0a, 0x36, 0x49, 0x2a, 0x68, 0x3c, 0x38, 0xf1, 0xa4,
+	0x40, 0x28, 0xd3, 0x7b, 0xbb, 0xc9, 0x43, 0xc1, 0x15, 0xe3, 0xad, 0xf4, 0x77, 0xc7, 0x80, 0x9e,
+}
@@ -0,0 +1,37 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestCamellia checks the test vectors of RFC 3713, Appendix A.
+func TestCamellia(t *testing.T) {
+	plaintext, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	for _, tt := range []struct {
+		key, ciphertext string
+	}{
+		{"0123456789abcdeffedcba9876543210", "67673138549669730857065648eabe43"},
+		{"0123456789abcdeffedcba98765432100011223344556677", "b4993401b3e996f84ee5cee7d79b09b9"},
+		{"0123456789abcdeffedcba987654321000112233445566778899aabbccddeeff", "9acc237dff16d76c20ef7c919e3a7509"},
+	} {
+		key, _ := hex.DecodeString(tt.key)
+		block, err := newCamelliaCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, 16)
+		block.Encrypt(out, plaintext)
+		if got := hex.EncodeToString(out); got != tt.ciphertext {
+			t.Errorf("Camellia-%d: got ciphertext %s, want %s", len(key)*8, got, tt.ciphertext)
+		}
+		block.Decrypt(out, out)
+		if !bytes.Equal(out, plaintext) {
+			t.Errorf("Camellia-%d: got plaintext %x, want %x", len(key)*8, out, plaintext)
+		}
+	}
+	if _, err := newCamelliaCipher(make([]byte, 20)); err == nil {
+		t.Error("Camellia accepted a 160-bit key")
+	}
+}
@@ -0,0 +1,203 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// A recordMAC is the HMAC of a TLS 1.0–1.2 cipher suite. It keeps the key and
+// the hash, for the constant-time verification of the MAC of CBC records
+// without Encrypt-then-MAC, whose length depends on the secret padding.
+type recordMAC struct {
+	hash.Hash // the HMAC itself
+
+	newHash func() hash.Hash
+	key     []byte
+	// constantTime is whether newHash meets the requirements of sumCBC.
+	constantTime bool
+}
+
+func newRecordMAC(mac hash.Hash, newHash func() hash.Hash, key []byte) *recordMAC {
+	return &recordMAC{
+		Hash:         mac,
+		newHash:      newHash,
+		key:          key,
+		constantTime: len(key) <= macBlockSize && supportsCBCSum(newHash),
+	}
+}
+
+const (
+	// macBlockSize and macLengthSize are the block size and the size of the
+	// length padding of the MD hashes sumCBC supports, SHA-1, SHA-256 and SM3.
+	macBlockSize  = 64
+	macLengthSize = 8
+	// macStateOffset is the offset of the chaining value in their marshaled
+	// state, after a four bytes magic.
+	macStateOffset = 4
+	// cbcVarianceBlocks is the number of hash blocks up to 256 bytes of
+	// padding and the length padding can span.
+	cbcVarianceBlocks = 6
+)
+
+// supportsCBCSum reports whether the hash has 64 bytes blocks and exposes its
+// chaining value at macStateOffset in its marshaled state, by checking it's
+// the digest of a message padded by hand.
+func supportsCBCSum(newHash func() hash.Hash) bool {
+	h := newHash()
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok || h.BlockSize() != macBlockSize {
+		return false
+	}
+	var block [macBlockSize]byte
+	copy(block[:], "abc\x80")
+	binary.BigEndian.PutUint64(block[macBlockSize-macLengthSize:], 3*8)
+	h.Write(block[:])
+	state, err := m.MarshalBinary()
+	h = newHash()
+	h.Write([]byte("abc"))
+	want := h.Sum(nil)
+	return err == nil && len(state) >= macStateOffset+len(want) &&
+		bytes.Equal(state[macStateOffset:macStateOffset+len(want)], want)
+}
+
+// sumCBC appends to out the MAC of header and data[:n], where data holds the
+// whole decrypted record and n is secret. It runs in time independent of n:
+// the blocks where the message can end are all hashed, with the final padding
+// built with constant-time masks, and the state after the actual last block
+// is selected the same way. header must already encode n as the length.
+//
+// This is the algorithm of the Lucky13 paper, as implemented in NSS and
+// BoringSSL. It's only valid if constantTime is set.
+func (m *recordMAC) sumCBC(out, seq, header, data []byte, n int) []byte {
+	var prefix [13]byte
+	copy(prefix[:], seq)
+	copy(prefix[8:], header)
+	total := len(prefix) + len(data)
+
+	var pad [macBlockSize]byte
+	copy(pad[:], m.key)
+	for i := range pad {
+		pad[i] ^= 0x36
+	}
+	inner := m.newHash()
+	inner.Write(pad[:])
+
+	// The message ends between the last 256 bytes of padding and the MAC,
+	// so only the last cbcVarianceBlocks blocks can depend on n, and the
+	// ones before are hashed as usual.
+	macSize := m.Size()
+	maxEnd := total - macSize - 1
+	numBlocks := (maxEnd + 1 + macLengthSize + macBlockSize - 1) / macBlockSize
+	k := 0
+	if numBlocks > cbcVarianceBlocks {
+		k = (numBlocks - cbcVarianceBlocks) * macBlockSize
+		inner.Write(prefix[:])
+		inner.Write(data[:k-len(prefix)])
+	}
+
+	end := len(prefix) + n
+	c := end % macBlockSize
+	indexA := end / macBlockSize                   // the block with the 0x80 byte
+	indexB := (end + macLengthSize) / macBlockSize // the block with the length
+	var length [macLengthSize]byte
+	binary.BigEndian.PutUint64(length[:], uint64(macBlockSize+end)*8)
+
+	var block [macBlockSize]byte
+	digest := make([]byte, macSize)
+	for i := k / macBlockSize; i < numBlocks; i++ {
+		isA := byte(subtle.ConstantTimeEq(int32(i), int32(indexA))) * 0xff
+		isB := byte(subtle.ConstantTimeEq(int32(i), int32(indexB))) * 0xff
+		for j := range block {
+			var b byte
+			if k < len(prefix) {
+				b = prefix[k]
+			} else if k < total {
+				b = data[k-len(prefix)]
+			}
+			k++
+			pastC := isA & ^byte(subtle.ConstantTimeLessOrEq(j+1, c)*0xff)
+			pastC1 := isA & ^byte(subtle.ConstantTimeLessOrEq(j+1, c+1)*0xff)
+			b = b&^pastC | 0x80&pastC
+			b &^= pastC1
+			// If the length doesn't fit after the 0x80 byte, it gets a
+			// block of its own.
+			b &= ^isB | isA
+			if j >= macBlockSize-macLengthSize {
+				b = b&^isB | length[j-(macBlockSize-macLengthSize)]&isB
+			}
+			block[j] = b
+		}
+		inner.Write(block[:])
+		state, _ := inner.(encoding.BinaryMarshaler).MarshalBinary()
+		state = state[macStateOffset : macStateOffset+macSize]
+		for x := range digest {
+			digest[x] |= state[x] & isB
+		}
+	}
+
+	for i := range pad {
+		pad[i] ^= 0x36 ^ 0x5c
+	}
+	outer := m.newHash()
+	outer.Write(pad[:])
+	outer.Write(digest)
+	return outer.Sum(out)
+}
+
+// copyMACCBC copies to out the MAC found at the secret offset start of the
+// decrypted record data, in time independent of start. It reads all the
+// bytes where the MAC can be into a rotated buffer, and then rotates it back.
+func copyMACCBC(out, data []byte, start int) {
+	macSize := len(out)
+	scanStart := 0
+	if len(data) > macSize+256 {
+		scanStart = len(data) - (macSize + 256)
+	}
+	var rotated [macBlockSize]byte
+	rotateOffset := 0
+	for i, j := scanStart, 0; i < len(data); i++ {
+		started := subtle.ConstantTimeEq(int32(i), int32(start))
+		rotateOffset |= j & -started
+		inMAC := subtle.ConstantTimeLessOrEq(start, i) &^ subtle.ConstantTimeLessOrEq(start+macSize, i)
+		rotated[j] |= data[i] & byte(-inMAC)
+		if j++; j == macSize {
+			j = 0
+		}
+	}
+	for i := range out {
+		// (i + rotateOffset) mod macSize, without a secret division.
+		idx := i + rotateOffset
+		idx = subtle.ConstantTimeSelect(subtle.ConstantTimeLessOrEq(macSize, idx), idx-macSize, idx)
+		var b byte
+		for x := 0; x < macSize; x++ {
+			b |= rotated[x] & byte(-subtle.ConstantTimeEq(int32(x), int32(idx)))
+		}
+		out[i] = b
+	}
+}
+
+var errCBCRecordLimit = errors.New("tls: reached Config.MaxCBCRecords with a CBC cipher suite")
+
+// macThenEncryptCBC reports whether hc protects records with a CBC cipher
+// suite without Encrypt-then-MAC.
+func (hc *halfConn) macThenEncryptCBC() bool {
+	_, isCBC := hc.cipher.(cbcMode)
+	return isCBC && !hc.encryptThenMAC
+}
+
+// checkCBCRecordLimit returns an error if hc reached Config.MaxCBCRecords
+// records with the current keys.
+func (c *Conn) checkCBCRecordLimit(hc *halfConn) error {
+	limit := c.config.MaxCBCRecords
+	if limit <= 0 || !hc.macThenEncryptCBC() {
+		return nil
+	}
+	if binary.BigEndian.Uint64(hc.seq[:]) >= uint64(limit) {
+		return errCBCRecordLimit
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm3"
+)
+
+func TestCBCSum(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		newHash func() hash.Hash
+	}{
+		{"SHA1", sha1.New},
+		{"SHA256", sha256.New},
+		{"SM3", sm3.New},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			key := bytes.Repeat([]byte{0x42}, 20)
+			mac := newRecordMAC(hmac.New(tt.newHash, key), tt.newHash, key)
+			if !mac.constantTime {
+				t.Fatal("constant-time MAC not supported")
+			}
+			seq := []byte{0, 0, 0, 0, 0, 0, 1, 2}
+			data := make([]byte, 1024)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			// Cover all the paddings for short and long records, which
+			// end in each position of the hash blocks.
+			for _, size := range []int{mac.Size() + 1, 64, 100, 333, 500, 1024} {
+				for padding := 0; padding < 256 && size-mac.Size()-padding-1 >= 0; padding++ {
+					n := size - mac.Size() - padding - 1
+					header := []byte{byte(recordTypeApplicationData), 3, 3, byte(n >> 8), byte(n)}
+					want := tls10MAC(mac, nil, seq, header, data[:n], nil)
+					if got := mac.sumCBC(nil, seq, header, data[:size], n); !bytes.Equal(got, want) {
+						t.Fatalf("size %d, padding %d: got MAC %x, want %x", size, padding, got, want)
+					}
+
+					got := make([]byte, mac.Size())
+					copyMACCBC(got, data[:size], n)
+					if want := data[n : n+mac.Size()]; !bytes.Equal(got, want) {
+						t.Fatalf("size %d, padding %d: copied MAC %x, want %x", size, padding, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMaxCBCRecords(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	clientConfig.CipherSuites = []uint16{TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}
+	clientConfig.MaxCBCRecords = 5
+
+	c, s := localPipe(t)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		server := Server(s, testConfig.Clone())
+		defer server.Close()
+		server.Handshake()
+		io.Copy(io.Discard, server)
+	}()
+
+	client := Client(c, clientConfig)
+	var err error
+	writes := 0
+	for ; writes < 10 && err == nil; writes++ {
+		_, err = client.Write([]byte("hello"))
+	}
+	if !errors.Is(err, errCBCRecordLimit) {
+		t.Fatalf("got error %v after %d writes, want %v", err, writes, errCBCRecordLimit)
+	}
+	// The Finished message was the first record.
+	if writes != 5 {
+		t.Errorf("failed after %d writes, want 5", writes)
+	}
+	client.Close()
+	<-done
+}
@@ -0,0 +1,69 @@
+package tls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	smcipher "github.com/emmansun/gmsm/cipher"
+)
+
+// cipherSuitesCCMTLS13 are the TLS 1.3 AES-CCM cipher suites, in preference
+// order. They are only enabled when listed in Config.CipherSuites, for the
+// constrained devices which don't implement AES-GCM.
+var cipherSuitesCCMTLS13 = []uint16{
+	TLS_AES_128_CCM_SHA256,
+	TLS_AES_128_CCM_8_SHA256,
+}
+
+// ccm8TagSize is the size of the truncated tag of the CCM_8 cipher suites.
+const ccm8TagSize = 8
+
+// newAESCCM returns an AES-CCM AEAD with 12 bytes nonces and tags of
+// tagSize bytes.
+func newAESCCM(key []byte, tagSize int) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := smcipher.NewCCMWithNonceAndTagSize(block, aeadNonceLength, tagSize)
+	if err != nil {
+		panic(err)
+	}
+	return copySealAEAD{aead}
+}
+
+func aeadAESCCM(key, noncePrefix []byte) aead {
+	return newPrefixNonceAESCCM(key, noncePrefix, 16)
+}
+
+func aeadAESCCM8(key, noncePrefix []byte) aead {
+	return newPrefixNonceAESCCM(key, noncePrefix, ccm8TagSize)
+}
+
+// newPrefixNonceAESCCM returns the TLS 1.2 AES-CCM AEAD of RFC 6655, whose
+// nonces are built like those of AES-GCM.
+func newPrefixNonceAESCCM(key, noncePrefix []byte, tagSize int) aead {
+	if len(noncePrefix) != noncePrefixLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	ret := &prefixNonceAEAD{aead: newAESCCM(key, tagSize)}
+	copy(ret.nonce[:], noncePrefix)
+	return ret
+}
+
+func aeadAESCCMTLS13(key, nonceMask []byte) aead {
+	return newXORNonceAESCCM(key, nonceMask, 16)
+}
+
+func aeadAESCCM8TLS13(key, nonceMask []byte) aead {
+	return newXORNonceAESCCM(key, nonceMask, ccm8TagSize)
+}
+
+func newXORNonceAESCCM(key, nonceMask []byte, tagSize int) aead {
+	if len(nonceMask) != aeadNonceLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	ret := &xorNonceAEAD{aead: newAESCCM(key, tagSize)}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
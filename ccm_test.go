@@ -0,0 +1,85 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAESCCM(t *testing.T) {
+	// NIST SP 800-38C, Example 3.
+	key, _ := hex.DecodeString("404142434445464748494a4b4c4d4e4f")
+	nonce, _ := hex.DecodeString("101112131415161718191a1b")
+	aad, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f10111213")
+	plaintext, _ := hex.DecodeString("202122232425262728292a2b2c2d2e2f3031323334353637")
+	want, _ := hex.DecodeString("e3b201a9f5b71a7a9b1ceaeccd97e70b6176aad9a4428aa5484392fbc1b09951")
+
+	aead := newAESCCM(key, ccm8TagSize)
+	buf := append([]byte(nil), plaintext...)
+	sealed := aead.Seal(buf[:0], nonce, buf, aad)
+	if !bytes.Equal(sealed, want) {
+		t.Fatalf("got %x sealed in place, want %x", sealed, want)
+	}
+	opened, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("got %x, %v opening", opened, err)
+	}
+	sealed[0] ^= 1
+	if _, err := aead.Open(nil, nonce, sealed, aad); err == nil {
+		t.Fatal("tampered record opened")
+	}
+}
+
+func TestAESCCMCipherSuites(t *testing.T) {
+	psk := ExternalPSK{Identity: []byte("device"), Key: []byte("0123456789abcdef")}
+	for _, suite := range []uint16{
+		TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
+		TLS_ECDHE_ECDSA_WITH_AES_256_CCM,
+		TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
+		TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8,
+		TLS_PSK_WITH_AES_128_CCM,
+		TLS_PSK_WITH_AES_128_CCM_8,
+		TLS_AES_128_CCM_SHA256,
+		TLS_AES_128_CCM_8_SHA256,
+	} {
+		t.Run(CipherSuiteName(suite), func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.CipherSuites = []uint16{suite}
+			serverConfig := testConfig.Clone()
+			serverConfig.CipherSuites = []uint16{suite}
+			serverConfig.Certificates = []Certificate{{
+				Certificate: [][]byte{testECDSACertificate},
+				PrivateKey:  testECDSAPrivateKey,
+			}}
+			serverConfig.NameToCertificate = nil
+			if cipherSuiteTLS13ByID(suite) == nil {
+				clientConfig.MaxVersion = VersionTLS12
+			} else {
+				// Make the client offer only the CCM suite.
+				defer func(suites, suitesNoAES []uint16) {
+					defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = suites, suitesNoAES
+				}(defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES)
+				defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = nil, nil
+			}
+			if cipherSuiteByID(suite) != nil && cipherSuiteByID(suite).flags&suitePSK != 0 {
+				clientConfig.ExternalPSKs = []ExternalPSK{psk}
+				serverConfig.ExternalPSKs = []ExternalPSK{psk}
+			}
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cs.CipherSuite != suite {
+				t.Errorf("got cipher suite %s", CipherSuiteName(cs.CipherSuite))
+			}
+		})
+	}
+
+	// Unless listed, the TLS 1.3 CCM cipher suites aren't enabled.
+	if slicesContainsFunc(testConfig.tls13CipherSuites(defaultCipherSuitesTLS13, false), func(id uint16) bool {
+		return slicesContains(cipherSuitesCCMTLS13, id)
+	}) {
+		t.Error("TLS 1.3 CCM cipher suite enabled without being listed")
+	}
+}
@@ -0,0 +1,179 @@
+package tls
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+)
+
+// A CertificateCompressionAlgorithm identifies a certificate compression
+// algorithm, see RFC 8879, Section 7.3.
+type CertificateCompressionAlgorithm uint16
+
+// Only zlib is implemented by this package. Brotli and zstd are defined for
+// the CertificateCompressor implementations that wrap third-party packages,
+// and are never offered unless such an implementation is configured.
+const (
+	CertificateCompressionZlib   CertificateCompressionAlgorithm = 1
+	CertificateCompressionBrotli CertificateCompressionAlgorithm = 2
+	CertificateCompressionZstd   CertificateCompressionAlgorithm = 3
+)
+
+// A CertificateCompressor compresses and decompresses TLS 1.3 Certificate
+// messages with a certificate compression algorithm, as specified in RFC 8879.
+// It is set in [Config.CertificateCompressors].
+//
+// [ZlibCertificateCompressor] implements the zlib algorithm. This package
+// doesn't implement brotli and zstd, which RFC 8879 also registers, to avoid
+// depending on third-party compression packages: they are left to
+// implementations wrapping those.
+type CertificateCompressor interface {
+	// Algorithm returns the algorithm implemented by the compressor.
+	Algorithm() CertificateCompressionAlgorithm
+
+	// Compress returns the compressed form of the body of an encoded
+	// Certificate message, without its handshake message header. If it
+	// returns an error, the message is sent uncompressed.
+	Compress(certificateMsg []byte) ([]byte, error)
+
+	// NewDecompressor returns a reader of the decompressed contents of r.
+	// The record layer stops reading from it once it produced the length
+	// announced by the peer, so it doesn't need to bound the output itself.
+	// If the returned reader implements io.Closer, it is closed once the
+	// message has been read.
+	NewDecompressor(r io.Reader) (io.Reader, error)
+}
+
+// ZlibCertificateCompressor is a [CertificateCompressor] implementing the zlib
+// algorithm with the compress/zlib package.
+type ZlibCertificateCompressor struct {
+	// Level is the compression level, as defined by compress/zlib. If zero,
+	// zlib.BestCompression is used, since certificate chains are usually
+	// compressed once per handshake and are small.
+	Level int
+}
+
+func (z ZlibCertificateCompressor) Algorithm() CertificateCompressionAlgorithm {
+	return CertificateCompressionZlib
+}
+
+func (z ZlibCertificateCompressor) Compress(certificateMsg []byte) ([]byte, error) {
+	level := z.Level
+	if level == 0 {
+		level = zlib.BestCompression
+	}
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(certificateMsg); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (z ZlibCertificateCompressor) NewDecompressor(r io.Reader) (io.Reader, error) {
+	return zlib.NewReader(r)
+}
+
+// certCompressionAlgorithms returns the algorithms of the configured
+// CertificateCompressors, which are offered to the peer.
+func (c *Config) certCompressionAlgorithms() []uint16 {
+	var algs []uint16
+	for _, comp := range c.CertificateCompressors {
+		if alg := uint16(comp.Algorithm()); !slicesContains(algs, alg) {
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// certificateCompressor returns the first configured CertificateCompressor
+// implementing one of algs, or nil if there is none.
+func (c *Config) certificateCompressor(algs []uint16) CertificateCompressor {
+	for _, comp := range c.CertificateCompressors {
+		if slicesContains(algs, uint16(comp.Algorithm())) {
+			return comp
+		}
+	}
+	return nil
+}
+
+// compressCertificate returns the message to send in place of certMsg, which
+// is a CompressedCertificate if the peer offered one of the configured
+// algorithms in peerAlgs and compression makes it smaller, or certMsg itself.
+func (c *Conn) compressCertificate(certMsg *certificateMsgTLS13, peerAlgs []uint16) (handshakeMessage, error) {
+	comp := c.config.certificateCompressor(peerAlgs)
+	if comp == nil {
+		return certMsg, nil
+	}
+	data, err := certMsg.marshal()
+	if err != nil {
+		return nil, err
+	}
+	body := data[4:] // the message without its type and length
+	compressed, err := comp.Compress(body)
+	if err != nil || len(compressed) == 0 || len(compressed) >= len(body) {
+		return certMsg, nil
+	}
+	return &compressedCertificateMsg{
+		algorithm:          uint16(comp.Algorithm()),
+		uncompressedLength: uint32(len(body)),
+		compressed:         compressed,
+	}, nil
+}
+
+var errBadCompressedCertificate = errors.New("tls: invalid compressed certificate")
+
+// decompressCertificate returns the Certificate message compressed in msg,
+// which must use one of the configured algorithms, since it's all c offers.
+func (c *Conn) decompressCertificate(msg *compressedCertificateMsg) (*certificateMsgTLS13, error) {
+	comp := c.config.certificateCompressor([]uint16{msg.algorithm})
+	if comp == nil {
+		c.sendAlert(alertBadCertificate)
+		return nil, errors.New("tls: received certificate compressed with an algorithm that wasn't offered")
+	}
+	// The announced length is bounded like that of uncompressed Certificate
+	// messages, and no more than that is ever decompressed.
+	if msg.uncompressedLength == 0 || msg.uncompressedLength > maxHandshakeCertificateMsg {
+		c.sendAlert(alertBadCertificate)
+		return nil, errBadCompressedCertificate
+	}
+	r, err := comp.NewDecompressor(bytes.NewReader(msg.compressed))
+	if err != nil {
+		c.sendAlert(alertBadCertificate)
+		return nil, errBadCompressedCertificate
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+	data := make([]byte, 4+msg.uncompressedLength)
+	data[0] = typeCertificate
+	data[1] = byte(msg.uncompressedLength >> 16)
+	data[2] = byte(msg.uncompressedLength >> 8)
+	data[3] = byte(msg.uncompressedLength)
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		c.sendAlert(alertBadCertificate)
+		return nil, errBadCompressedCertificate
+	}
+	// The decompressed message must be exactly as long as announced. Reading
+	// up to the end also lets the decompressor check its trailer.
+	var extra [1]byte
+	if n, err := io.ReadFull(r, extra[:]); n != 0 || err != io.EOF {
+		c.sendAlert(alertBadCertificate)
+		return nil, errBadCompressedCertificate
+	}
+
+	certMsg := new(certificateMsgTLS13)
+	if !certMsg.unmarshal(data) {
+		c.sendAlert(alertBadCertificate)
+		return nil, errBadCompressedCertificate
+	}
+	c.certCompression = CertificateCompressionAlgorithm(msg.algorithm)
+	return certMsg, nil
+}
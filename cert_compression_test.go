@@ -0,0 +1,119 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type countingCertCompressor struct {
+	ZlibCertificateCompressor
+	compressed   atomic.Int32
+	decompressed atomic.Int32
+}
+
+func (c *countingCertCompressor) Compress(certificateMsg []byte) ([]byte, error) {
+	c.compressed.Add(1)
+	return c.ZlibCertificateCompressor.Compress(certificateMsg)
+}
+
+func (c *countingCertCompressor) NewDecompressor(r io.Reader) (io.Reader, error) {
+	c.decompressed.Add(1)
+	return c.ZlibCertificateCompressor.NewDecompressor(r)
+}
+
+func TestCertificateCompression(t *testing.T) {
+	for _, tt := range []struct {
+		name             string
+		version          uint16
+		clientCompresses bool
+		clientAuth       bool
+		want             int32
+	}{
+		{"TLS13", VersionTLS13, true, false, 1},
+		{"TLS13-ClientAuth", VersionTLS13, true, true, 2},
+		{"TLS13-ServerOnly", VersionTLS13, false, true, 0},
+		{"TLS12", VersionTLS12, true, true, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverComp, clientComp := &countingCertCompressor{}, &countingCertCompressor{}
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			serverConfig.CertificateCompressors = []CertificateCompressor{serverComp}
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			if tt.clientCompresses {
+				clientConfig.CertificateCompressors = []CertificateCompressor{clientComp}
+			}
+			if tt.clientAuth {
+				serverConfig.ClientAuth = RequireAnyClientCert
+			}
+
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if len(cs.PeerCertificates) == 0 || tt.clientAuth && len(ss.PeerCertificates) == 0 {
+				t.Fatal("missing peer certificates")
+			}
+			compressed := serverComp.compressed.Load() + clientComp.compressed.Load()
+			decompressed := serverComp.decompressed.Load() + clientComp.decompressed.Load()
+			if compressed != tt.want || decompressed != tt.want {
+				t.Errorf("compressed %d and decompressed %d certificates, want %d", compressed, decompressed, tt.want)
+			}
+			if got := cs.CertificateCompression != 0; got != (tt.want > 0) {
+				t.Errorf("got client CertificateCompression %v", cs.CertificateCompression)
+			}
+		})
+	}
+}
+
+func TestDecompressCertificateLimits(t *testing.T) {
+	certMsg := &certificateMsgTLS13{certificate: testConfig.Certificates[0]}
+	data, err := certMsg.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := data[4:]
+	compress := func(b []byte) []byte {
+		compressed, err := ZlibCertificateCompressor{}.Compress(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return compressed
+	}
+
+	for _, tt := range []struct {
+		name string
+		msg  *compressedCertificateMsg
+		err  string
+	}{
+		{"valid", &compressedCertificateMsg{uint16(CertificateCompressionZlib), uint32(len(body)), compress(body)}, ""},
+		{"unoffered", &compressedCertificateMsg{uint16(CertificateCompressionBrotli), uint32(len(body)), compress(body)}, "wasn't offered"},
+		{"too long", &compressedCertificateMsg{uint16(CertificateCompressionZlib), maxHandshakeCertificateMsg + 1, compress(body)}, "invalid"},
+		{"short", &compressedCertificateMsg{uint16(CertificateCompressionZlib), uint32(len(body)) + 1, compress(body)}, "invalid"},
+		{"bomb", &compressedCertificateMsg{uint16(CertificateCompressionZlib), uint32(len(body)), compress(append(body, make([]byte, 1<<24)...))}, "invalid"},
+		{"corrupt", &compressedCertificateMsg{uint16(CertificateCompressionZlib), uint32(len(body)), bytes.Repeat([]byte{0x42}, 100)}, "invalid"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig.Clone()
+			config.CertificateCompressors = []CertificateCompressor{ZlibCertificateCompressor{}}
+			c := Client(&discardConn{}, config)
+			got, err := c.decompressCertificate(tt.msg)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("decompression failed: %v", err)
+				}
+				if !bytes.Equal(got.certificate.Certificate[0], certMsg.certificate.Certificate[0]) {
+					t.Error("decompressed certificate differs")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCertReloadInterval is how often CertificateReloader.Watch checks
+// the certificate files for changes by default.
+const defaultCertReloadInterval = 30 * time.Second
+
+// A CertificateReloader serves a certificate that can be replaced while
+// connections are being accepted, either by watching its files or by pushing
+// PEM blocks to Update. Handshakes pick the certificate that is current when
+// they start, and established connections are unaffected by replacements.
+//
+// Its GetCertificate and GetClientCertificate methods are meant to be set as
+// [Config.GetCertificate] or [Config.GetClientCertificate]. A
+// CertificateReloader must not be copied or have its fields modified after
+// first use.
+type CertificateReloader struct {
+	// OCSPStapler, if not nil, starts managing the OCSP response of each
+	// certificate when it's loaded, and stops managing the certificate it
+	// replaces, so that responses for replaced certificates are dropped. It
+	// should also be set as Config.OCSPStapler for the responses to be
+	// stapled.
+	OCSPStapler *OCSPStapler
+
+	// OnError, if not nil, is called with the errors of background reloads
+	// and of OCSPStapler.Add. After a failed reload, the current certificate
+	// keeps being served, and the reload is retried at the next check.
+	OnError func(err error)
+
+	cert   atomic.Pointer[Certificate]
+	swapMu sync.Mutex // serializes replacements of cert
+
+	mu     sync.Mutex
+	stop   chan struct{} // closed to stop the current watch
+	closed bool
+}
+
+// Update parses a public/private key pair from PEM encoded data, as
+// [X509KeyPair] does, and replaces the served certificate with it. If it
+// returns an error, the served certificate is left unchanged.
+func (r *CertificateReloader) Update(certPEMBlock, keyPEMBlock []byte) error {
+	cert, err := X509KeyPair(certPEMBlock, keyPEMBlock)
+	if err != nil {
+		return err
+	}
+	r.set(&cert)
+	return nil
+}
+
+// Watch loads a public/private key pair from the given files, as
+// [LoadX509KeyPair] does, and then checks the files every interval, or every
+// 30 seconds if interval is not positive, reloading them when their size or
+// modification time changes. It returns the error of the first load, in
+// which case the files aren't watched.
+//
+// Calling Watch again replaces the watched files.
+func (r *CertificateReloader) Watch(certFile, keyFile string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+	stamp, err := statCertFiles(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if err := r.load(certFile, keyFile); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return errors.New("tls: CertificateReloader is closed")
+	}
+	if r.stop != nil {
+		close(r.stop)
+	}
+	r.stop = make(chan struct{})
+	go r.watch(certFile, keyFile, interval, stamp, r.stop)
+	return nil
+}
+
+// Close stops watching the certificate files. The current certificate keeps
+// being served.
+func (r *CertificateReloader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	return nil
+}
+
+// GetCertificate returns the current certificate, for use as
+// [Config.GetCertificate].
+func (r *CertificateReloader) GetCertificate(*ClientHelloInfo) (*Certificate, error) {
+	if cert := r.cert.Load(); cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("tls: CertificateReloader has no certificate")
+}
+
+// GetClientCertificate returns the current certificate, for use as
+// [Config.GetClientCertificate].
+func (r *CertificateReloader) GetClientCertificate(*CertificateRequestInfo) (*Certificate, error) {
+	return r.GetCertificate(nil)
+}
+
+func (r *CertificateReloader) watch(certFile, keyFile string, interval time.Duration, stamp certFilesStamp, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		current, err := statCertFiles(certFile, keyFile)
+		if err != nil {
+			r.reportError(fmt.Errorf("tls: failed to reload certificate: %w", err))
+			continue
+		}
+		if current == stamp {
+			continue
+		}
+		// The files may be halfway through being replaced, in which case
+		// the stamp isn't updated so that they are loaded again.
+		if err := r.load(certFile, keyFile); err != nil {
+			r.reportError(fmt.Errorf("tls: failed to reload certificate: %w", err))
+			continue
+		}
+		stamp = current
+	}
+}
+
+func (r *CertificateReloader) load(certFile, keyFile string) error {
+	cert, err := LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	r.set(&cert)
+	return nil
+}
+
+func (r *CertificateReloader) set(cert *Certificate) {
+	r.swapMu.Lock()
+	defer r.swapMu.Unlock()
+	// Fetch the OCSP response first, so that it can be stapled from the
+	// first handshake using cert.
+	if r.OCSPStapler != nil {
+		if err := r.OCSPStapler.Add(context.Background(), cert); err != nil {
+			r.reportError(err)
+		}
+	}
+	old := r.cert.Swap(cert)
+	if r.OCSPStapler != nil && old != nil && !bytes.Equal(old.Certificate[0], cert.Certificate[0]) {
+		r.OCSPStapler.Remove(old)
+	}
+}
+
+func (r *CertificateReloader) reportError(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
+
+// certFilesStamp is the size and modification time of the certificate and
+// key files, used to detect changes.
+type certFilesStamp [4]int64
+
+func statCertFiles(certFile, keyFile string) (certFilesStamp, error) {
+	var stamp certFilesStamp
+	for i, name := range []string{certFile, keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return certFilesStamp{}, err
+		}
+		stamp[2*i], stamp[2*i+1] = fi.Size(), fi.ModTime().UnixNano()
+	}
+	return stamp, nil
+}
@@ -0,0 +1,150 @@
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func pemKeyPair(t *testing.T, certDER []byte, key crypto.PrivateKey) (certPEM, keyPEM []byte) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestCertificateReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	write := func(certPEM, keyPEM []byte) {
+		if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rsaCert, rsaKey := pemKeyPair(t, testRSACertificate, testRSAPrivateKey)
+	ecdsaCert, ecdsaKey := pemKeyPair(t, testECDSACertificate, testECDSAPrivateKey)
+
+	errs := make(chan error, 1)
+	r := &CertificateReloader{OnError: func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}}
+	defer r.Close()
+	if _, err := r.GetCertificate(nil); err == nil {
+		t.Error("GetCertificate succeeded before loading a certificate")
+	}
+	if err := r.Watch(certFile, keyFile, time.Millisecond); err == nil {
+		t.Fatal("Watch succeeded without certificate files")
+	}
+	write(rsaCert, rsaKey)
+	if err := r.Watch(certFile, keyFile, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := testConfig.Clone()
+	serverConfig.Certificates = nil
+	serverConfig.NameToCertificate = nil
+	serverConfig.GetCertificate = r.GetCertificate
+	handshake := func() []byte {
+		_, cs, err := testHandshake(t, testConfig, serverConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cs.PeerCertificates[0].Raw
+	}
+	if !bytes.Equal(handshake(), testRSACertificate) {
+		t.Fatal("first certificate not served")
+	}
+
+	// An invalid pair keeps the previous certificate until it's fixed.
+	for len(errs) > 0 {
+		<-errs
+	}
+	write(ecdsaCert, rsaKey)
+	select {
+	case <-errs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("invalid key pair not reported")
+	}
+	if !bytes.Equal(handshake(), testRSACertificate) {
+		t.Error("certificate replaced by an invalid key pair")
+	}
+
+	write(ecdsaCert, ecdsaKey)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(cert.Certificate[0], testECDSACertificate) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(handshake(), testECDSACertificate) {
+		t.Error("reloaded certificate not served")
+	}
+}
+
+func TestCertificateReloaderUpdate(t *testing.T) {
+	responder := newTestOCSPResponder(t)
+	first, second := responder.issue(t, 2), responder.issue(t, 3)
+	stapler := &OCSPStapler{}
+	defer stapler.Close()
+	r := &CertificateReloader{OCSPStapler: stapler}
+	defer r.Close()
+
+	update := func(cert Certificate) {
+		t.Helper()
+		certPEM, keyPEM := pemKeyPair(t, cert.Certificate[0], cert.PrivateKey)
+		issuerPEM, _ := pemKeyPair(t, cert.Certificate[1], cert.PrivateKey)
+		if err := r.Update(append(certPEM, issuerPEM...), keyPEM); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update(first)
+	if stapler.Staple(&first) == nil {
+		t.Error("no OCSP response for the first certificate")
+	}
+	if err := r.Update([]byte("invalid"), nil); err == nil {
+		t.Error("Update accepted an invalid key pair")
+	}
+
+	update(second)
+	if stapler.Staple(&first) != nil {
+		t.Error("OCSP response of the replaced certificate is still managed")
+	}
+	if stapler.Staple(&second) == nil {
+		t.Error("no OCSP response for the second certificate")
+	}
+
+	serverConfig := testConfig.Clone()
+	serverConfig.Certificates = nil
+	serverConfig.NameToCertificate = nil
+	serverConfig.GetCertificate = r.GetCertificate
+	serverConfig.OCSPStapler = stapler
+	_, cs, err := testHandshake(t, testConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cs.PeerCertificates[0].Raw, second.Certificate[0]) || cs.OCSPResponse == nil {
+		t.Error("updated certificate not served with its OCSP response")
+	}
+}
@@ -16,8 +16,10 @@ import (
 	"fmt"
 	"hash"
 	"runtime"
+	"sort"
 	_ "unsafe" // for linkname
 
+	"github.com/emmansun/gmsm/sm3"
 	"github.com/metacubex/cpu"
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -41,20 +43,28 @@ var (
 	supportedUpToTLS12 = []uint16{VersionTLS10, VersionTLS11, VersionTLS12}
 	supportedOnlyTLS12 = []uint16{VersionTLS12}
 	supportedOnlyTLS13 = []uint16{VersionTLS13}
+	supportedOnlyTLCP  = []uint16{VersionTLCP}
 )
 
 // CipherSuites returns a list of cipher suites currently implemented by this
 // package, excluding those with security issues, which are returned by
-// [InsecureCipherSuites].
+// [InsecureCipherSuites], and including those registered with
+// [RegisterCipherSuiteTLS13].
 //
 // The list is sorted by ID. Note that the default cipher suites selected by
 // this package might depend on logic that can't be captured by a static list,
 // and might not match those returned by this function.
 func CipherSuites() []*CipherSuite {
-	return []*CipherSuite{
+	suites := []*CipherSuite{
+		{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_DHE_RSA_WITH_AES_256_GCM_SHA384, "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_SM4_GCM_SM3, "TLS_SM4_GCM_SM3", supportedOnlyTLS13, false},
+		{TLS_SM4_CCM_SM3, "TLS_SM4_CCM_SM3", supportedOnlyTLS13, false},
 		{TLS_AES_128_GCM_SHA256, "TLS_AES_128_GCM_SHA256", supportedOnlyTLS13, false},
 		{TLS_AES_256_GCM_SHA384, "TLS_AES_256_GCM_SHA384", supportedOnlyTLS13, false},
 		{TLS_CHACHA20_POLY1305_SHA256, "TLS_CHACHA20_POLY1305_SHA256", supportedOnlyTLS13, false},
+		{TLS_AES_128_CCM_SHA256, "TLS_AES_128_CCM_SHA256", supportedOnlyTLS13, false},
+		{TLS_AES_128_CCM_8_SHA256, "TLS_AES_128_CCM_8_SHA256", supportedOnlyTLS13, false},
 
 		{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA", supportedUpToTLS12, false},
 		{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA", supportedUpToTLS12, false},
@@ -64,9 +74,34 @@ func CipherSuites() []*CipherSuite {
 		{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, false},
 		{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", supportedOnlyTLS12, false},
 		{TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA, "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA", supportedUpToTLS12, false},
+		{TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA, "TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA", supportedUpToTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, "TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384, "TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, "TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384, "TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_GCM_SHA256, "TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384, "TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256, "TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384, "TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_AES_128_CCM, "TLS_ECDHE_ECDSA_WITH_AES_128_CCM", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_AES_256_CCM, "TLS_ECDHE_ECDSA_WITH_AES_256_CCM", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8, "TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8, "TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8", supportedOnlyTLS12, false},
 		{TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", supportedOnlyTLS12, false},
 		{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256, "TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256, "TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384, "TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{ECC_SM4_CBC_SM3, "ECC_SM4_CBC_SM3", supportedOnlyTLCP, false},
+		{ECC_SM4_GCM_SM3, "ECC_SM4_GCM_SM3", supportedOnlyTLCP, false},
+	}
+	if custom := customCipherSuites(); len(custom) > 0 {
+		// The GOST cipher suites may come before some of the others.
+		suites = append(suites, custom...)
+		sort.Slice(suites, func(i, j int) bool { return suites[i].ID < suites[j].ID })
 	}
+	return suites
 }
 
 // InsecureCipherSuites returns a list of cipher suites currently implemented by
@@ -75,22 +110,41 @@ func CipherSuites() []*CipherSuite {
 // Most applications should not use the cipher suites in this list, and should
 // only use those returned by [CipherSuites].
 func InsecureCipherSuites() []*CipherSuite {
-	// This list includes legacy RSA kex, RC4, CBC_SHA256, and 3DES cipher
-	// suites. See cipherSuitesPreferenceOrder for details.
+	// This list includes legacy RSA kex, plain PSK, RC4, CBC_SHA256, and 3DES
+	// cipher suites. See cipherSuitesPreferenceOrder for details.
 	return []*CipherSuite{
 		{TLS_RSA_WITH_RC4_128_SHA, "TLS_RSA_WITH_RC4_128_SHA", supportedUpToTLS12, true},
 		{TLS_RSA_WITH_3DES_EDE_CBC_SHA, "TLS_RSA_WITH_3DES_EDE_CBC_SHA", supportedUpToTLS12, true},
 		{TLS_RSA_WITH_AES_128_CBC_SHA, "TLS_RSA_WITH_AES_128_CBC_SHA", supportedUpToTLS12, true},
 		{TLS_RSA_WITH_AES_256_CBC_SHA, "TLS_RSA_WITH_AES_256_CBC_SHA", supportedUpToTLS12, true},
 		{TLS_RSA_WITH_AES_128_CBC_SHA256, "TLS_RSA_WITH_AES_128_CBC_SHA256", supportedOnlyTLS12, true},
+		{TLS_PSK_WITH_AES_128_CBC_SHA, "TLS_PSK_WITH_AES_128_CBC_SHA", supportedUpToTLS12, true},
+		{TLS_PSK_WITH_AES_256_CBC_SHA, "TLS_PSK_WITH_AES_256_CBC_SHA", supportedUpToTLS12, true},
 		{TLS_RSA_WITH_AES_128_GCM_SHA256, "TLS_RSA_WITH_AES_128_GCM_SHA256", supportedOnlyTLS12, true},
 		{TLS_RSA_WITH_AES_256_GCM_SHA384, "TLS_RSA_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, true},
+		{TLS_PSK_WITH_AES_128_GCM_SHA256, "TLS_PSK_WITH_AES_128_GCM_SHA256", supportedOnlyTLS12, true},
+		{TLS_PSK_WITH_AES_256_GCM_SHA384, "TLS_PSK_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, true},
+		{TLS_PSK_WITH_AES_128_CBC_SHA256, "TLS_PSK_WITH_AES_128_CBC_SHA256", supportedOnlyTLS12, true},
 		{TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA", supportedUpToTLS12, true},
 		{TLS_ECDHE_RSA_WITH_RC4_128_SHA, "TLS_ECDHE_RSA_WITH_RC4_128_SHA", supportedUpToTLS12, true},
 		{TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA, "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA", supportedUpToTLS12, true},
 		{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256, "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256", supportedOnlyTLS12, true},
 		{TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256, "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256", supportedOnlyTLS12, true},
+		{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256, "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256", supportedOnlyTLS12, true},
+		{TLS_PSK_WITH_AES_128_CCM, "TLS_PSK_WITH_AES_128_CCM", supportedOnlyTLS12, true},
+		{TLS_PSK_WITH_AES_128_CCM_8, "TLS_PSK_WITH_AES_128_CCM_8", supportedOnlyTLS12, true},
+	}
+}
+
+// isInsecureCipherSuite reports whether id is one of the cipher suites
+// returned by [InsecureCipherSuites].
+func isInsecureCipherSuite(id uint16) bool {
+	for _, c := range InsecureCipherSuites() {
+		if c.ID == id {
+			return true
+		}
 	}
+	return false
 }
 
 // CipherSuiteName returns the standard name for the passed cipher suite ID
@@ -118,8 +172,8 @@ const (
 	suiteECDHE = 1 << iota
 	// suiteECSign indicates that the cipher suite involves an ECDSA or
 	// EdDSA signature and therefore may only be selected when the server's
-	// certificate is ECDSA or EdDSA. If this is not set then the cipher suite
-	// is RSA based.
+	// certificate is ECDSA or EdDSA. If neither this nor suitePSK is set then
+	// the cipher suite is RSA based.
 	suiteECSign
 	// suiteTLS12 indicates that the cipher suite should only be advertised
 	// and accepted when using TLS 1.2.
@@ -127,6 +181,17 @@ const (
 	// suiteSHA384 indicates that the cipher suite uses SHA384 as the
 	// handshake hash.
 	suiteSHA384
+	// suitePSK indicates that the cipher suite is authenticated by a
+	// pre-shared key instead of a certificate, and therefore may only be
+	// selected when a PSK is configured. See RFC 4279 and RFC 5489.
+	suitePSK
+	// suiteTLCP indicates that the cipher suite is a TLCP one, which may only
+	// be negotiated in TLCP, see Config.TLCP.
+	suiteTLCP
+	// suiteDHE indicates that the cipher suite involves finite field
+	// Diffie-Hellman, which may only be selected when the client supports
+	// one of our FFDHE groups. See RFC 7919.
+	suiteDHE
 )
 
 // A cipherSuite is a TLS 1.0–1.2 cipher suite, and defines the key exchange
@@ -158,9 +223,20 @@ var cipherSuites = []*cipherSuite{ // TODO: replace with a map, since the order
 	{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, 16, 20, 16, ecdheECDSAKA, suiteECDHE | suiteECSign, cipherAES, macSHA1, nil},
 	{TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA, 32, 20, 16, ecdheRSAKA, suiteECDHE, cipherAES, macSHA1, nil},
 	{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, 32, 20, 16, ecdheECDSAKA, suiteECDHE | suiteECSign, cipherAES, macSHA1, nil},
+	{TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256, 32, 0, 12, ecdhePSKKA, suiteECDHE | suitePSK | suiteTLS12, nil, nil, aeadChaCha20Poly1305},
+	{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256, 16, 0, 4, ecdhePSKKA, suiteECDHE | suitePSK | suiteTLS12, nil, nil, aeadAESGCM},
+	{TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384, 32, 0, 4, ecdhePSKKA, suiteECDHE | suitePSK | suiteTLS12 | suiteSHA384, nil, nil, aeadAESGCM},
+	{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA, 16, 20, 16, ecdhePSKKA, suiteECDHE | suitePSK, cipherAES, macSHA1, nil},
+	{TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA, 32, 20, 16, ecdhePSKKA, suiteECDHE | suitePSK, cipherAES, macSHA1, nil},
+	{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256, 16, 32, 16, ecdhePSKKA, suiteECDHE | suitePSK | suiteTLS12, cipherAES, macSHA256, nil},
 	{TLS_RSA_WITH_AES_128_GCM_SHA256, 16, 0, 4, rsaKA, suiteTLS12, nil, nil, aeadAESGCM},
 	{TLS_RSA_WITH_AES_256_GCM_SHA384, 32, 0, 4, rsaKA, suiteTLS12 | suiteSHA384, nil, nil, aeadAESGCM},
 	{TLS_RSA_WITH_AES_128_CBC_SHA256, 16, 32, 16, rsaKA, suiteTLS12, cipherAES, macSHA256, nil},
+	{TLS_PSK_WITH_AES_128_GCM_SHA256, 16, 0, 4, pskKA, suitePSK | suiteTLS12, nil, nil, aeadAESGCM},
+	{TLS_PSK_WITH_AES_256_GCM_SHA384, 32, 0, 4, pskKA, suitePSK | suiteTLS12 | suiteSHA384, nil, nil, aeadAESGCM},
+	{TLS_PSK_WITH_AES_128_CBC_SHA, 16, 20, 16, pskKA, suitePSK, cipherAES, macSHA1, nil},
+	{TLS_PSK_WITH_AES_256_CBC_SHA, 32, 20, 16, pskKA, suitePSK, cipherAES, macSHA1, nil},
+	{TLS_PSK_WITH_AES_128_CBC_SHA256, 16, 32, 16, pskKA, suitePSK | suiteTLS12, cipherAES, macSHA256, nil},
 	{TLS_RSA_WITH_AES_128_CBC_SHA, 16, 20, 16, rsaKA, 0, cipherAES, macSHA1, nil},
 	{TLS_RSA_WITH_AES_256_CBC_SHA, 32, 20, 16, rsaKA, 0, cipherAES, macSHA1, nil},
 	{TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA, 24, 20, 8, ecdheRSAKA, suiteECDHE, cipher3DES, macSHA1, nil},
@@ -170,6 +246,36 @@ var cipherSuites = []*cipherSuite{ // TODO: replace with a map, since the order
 	{TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, 16, 20, 0, ecdheECDSAKA, suiteECDHE | suiteECSign, cipherRC4, macSHA1, nil},
 }
 
+// cipherSuitesOptIn are the ARIA and Camellia cipher suites of RFC 6209 and
+// RFC 6367, the DHE ones with the groups of RFC 7919, and the AES-CCM ones of
+// RFC 6655 and RFC 7251, in preference order. They are only used when listed in Config.CipherSuites, to
+// interoperate with the endpoints requiring them, and come after all the other
+// enabled cipher suites.
+var cipherSuitesOptIn = []*cipherSuite{
+	{TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, ecdheRSAKA, suiteECDHE | suiteTLS12, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12 | suiteSHA384, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, ecdheRSAKA, suiteECDHE | suiteTLS12 | suiteSHA384, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12, nil, nil, aeadCamelliaGCM},
+	{TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, ecdheRSAKA, suiteECDHE | suiteTLS12, nil, nil, aeadCamelliaGCM},
+	{TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12 | suiteSHA384, nil, nil, aeadCamelliaGCM},
+	{TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, ecdheRSAKA, suiteECDHE | suiteTLS12 | suiteSHA384, nil, nil, aeadCamelliaGCM},
+	{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, 16, 0, 4, dheRSAKA, suiteDHE | suiteTLS12, nil, nil, aeadAESGCM},
+	{TLS_DHE_RSA_WITH_AES_256_GCM_SHA384, 32, 0, 4, dheRSAKA, suiteDHE | suiteTLS12 | suiteSHA384, nil, nil, aeadAESGCM},
+	{TLS_ECDHE_ECDSA_WITH_AES_128_CCM, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12, nil, nil, aeadAESCCM},
+	{TLS_ECDHE_ECDSA_WITH_AES_256_CCM, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12, nil, nil, aeadAESCCM},
+	{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12, nil, nil, aeadAESCCM8},
+	{TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12, nil, nil, aeadAESCCM8},
+	{TLS_PSK_WITH_AES_128_CCM, 16, 0, 4, pskKA, suitePSK | suiteTLS12, nil, nil, aeadAESCCM},
+	{TLS_PSK_WITH_AES_128_CCM_8, 16, 0, 4, pskKA, suitePSK | suiteTLS12, nil, nil, aeadAESCCM8},
+}
+
+// isCBC reports whether c is a CBC cipher suite, the only ones using
+// encrypt-then-MAC.
+func (c *cipherSuite) isCBC() bool {
+	return c.aead == nil && c.ivLen > 0
+}
+
 // selectCipherSuite returns the first TLS 1.0–1.2 cipher suite from ids which
 // is also in supportedIDs and passes the ok filter.
 func selectCipherSuite(ids, supportedIDs []uint16, ok func(*cipherSuite) bool) *cipherSuite {
@@ -194,7 +300,37 @@ type cipherSuiteTLS13 struct {
 	id     uint16
 	keyLen int
 	aead   func(key, fixedNonce []byte) aead
-	hash   crypto.Hash
+	hash   tls13Hash
+}
+
+// A tls13Hash is the hash function of a TLS 1.3 cipher suite. It's a
+// crypto.Hash, except for sm3Hash and the hashes of the custom cipher suites
+// from customHashBase, which crypto.Hash can't represent, and it
+// keeps the representation of crypto.Hash for the packages linking
+// cipherSuitesTLS13.
+type tls13Hash crypto.Hash
+
+// sm3Hash is the hash function of the ShangMi cipher suites, see RFC 8998.
+const sm3Hash tls13Hash = 0x100
+
+func (h tls13Hash) New() hash.Hash {
+	if h == sm3Hash {
+		return sm3.New()
+	}
+	if h >= customHashBase {
+		return customHash(h)()
+	}
+	return crypto.Hash(h).New()
+}
+
+func (h tls13Hash) Size() int {
+	if h == sm3Hash {
+		return sm3.Size
+	}
+	if h >= customHashBase {
+		return customHash(h)().Size()
+	}
+	return crypto.Hash(h).Size()
 }
 
 // cipherSuitesTLS13 should be an internal detail,
@@ -208,9 +344,47 @@ type cipherSuiteTLS13 struct {
 //
 //go:linkname cipherSuitesTLS13
 var cipherSuitesTLS13 = []*cipherSuiteTLS13{ // TODO: replace with a map.
-	{TLS_AES_128_GCM_SHA256, 16, aeadAESGCMTLS13, crypto.SHA256},
-	{TLS_CHACHA20_POLY1305_SHA256, 32, aeadChaCha20Poly1305, crypto.SHA256},
-	{TLS_AES_256_GCM_SHA384, 32, aeadAESGCMTLS13, crypto.SHA384},
+	{TLS_AES_128_GCM_SHA256, 16, aeadAESGCMTLS13, tls13Hash(crypto.SHA256)},
+	{TLS_CHACHA20_POLY1305_SHA256, 32, aeadChaCha20Poly1305, tls13Hash(crypto.SHA256)},
+	{TLS_AES_256_GCM_SHA384, 32, aeadAESGCMTLS13, tls13Hash(crypto.SHA384)},
+	{TLS_SM4_GCM_SM3, 16, aeadSM4GCMTLS13, sm3Hash},
+	{TLS_SM4_CCM_SM3, 16, aeadSM4CCMTLS13, sm3Hash},
+	{TLS_AES_128_CCM_SHA256, 16, aeadAESCCMTLS13, tls13Hash(crypto.SHA256)},
+	{TLS_AES_128_CCM_8_SHA256, 16, aeadAESCCM8TLS13, tls13Hash(crypto.SHA256)},
+}
+
+// tls13CipherSuites returns preferenceList, followed by the ShangMi cipher
+// suites if c enables them and the AES-CCM and custom ones listed in
+// c.CipherSuites, without those below c.SecurityLevel or not allowed by
+// c.FIPSMode. They are never used with QUIC, whose implementations link
+// cipherSuitesTLS13 and expect its hashes to be crypto.Hash values.
+func (c *Config) tls13CipherSuites(preferenceList []uint16, isQUIC bool) []uint16 {
+	if c != nil && c.ShangMiCipherSuites && !isQUIC {
+		preferenceList = slicesConcat(preferenceList, shangMiCipherSuitesTLS13)
+	}
+	if c != nil && !isQUIC {
+		for _, id := range cipherSuitesCCMTLS13 {
+			if slicesContains(c.CipherSuites, id) {
+				preferenceList = slicesConcat(preferenceList, []uint16{id})
+			}
+		}
+		for _, suite := range customCipherSuitesTLS13 {
+			if slicesContains(c.CipherSuites, suite.ID) {
+				preferenceList = slicesConcat(preferenceList, []uint16{suite.ID})
+			}
+		}
+	}
+	if level := c.securityLevel(); level.bits > 0 {
+		preferenceList = slicesDeleteFunc(slicesClone(preferenceList), func(id uint16) bool {
+			return !level.allowsCipherSuiteTLS13(id)
+		})
+	}
+	if c.fipsMode() {
+		preferenceList = slicesDeleteFunc(slicesClone(preferenceList), func(id uint16) bool {
+			return !slicesContains(allowedCipherSuitesTLS13FIPS, id)
+		})
+	}
+	return preferenceList
 }
 
 // cipherSuitesPreferenceOrder is the order in which we'll select (on the
@@ -273,35 +447,36 @@ var cipherSuitesTLS13 = []*cipherSuiteTLS13{ // TODO: replace with a map.
 //     TLS, and AES-256 is slower due to its four extra rounds (which don't
 //     contribute to the advantages above).
 //
-//   - ECDSA comes before RSA
+//   - ECDSA comes before RSA comes before PSK
 //
-//     The relative order of ECDSA and RSA cipher suites doesn't matter,
-//     as they depend on the certificate. Pick one to get a stable order.
+//     The relative order of ECDSA, RSA, and PSK cipher suites doesn't
+//     matter, as they depend on the certificate and on the configured PSKs.
+//     Pick one to get a stable order.
 var cipherSuitesPreferenceOrder = []uint16{
 	// AEADs w/ ECDHE
-	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384,
+	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256,
 
 	// CBC w/ ECDHE
-	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA,
+	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA,
 
 	// AEADs w/o ECDHE
-	TLS_RSA_WITH_AES_128_GCM_SHA256,
-	TLS_RSA_WITH_AES_256_GCM_SHA384,
+	TLS_RSA_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_128_GCM_SHA256,
+	TLS_RSA_WITH_AES_256_GCM_SHA384, TLS_PSK_WITH_AES_256_GCM_SHA384,
 
 	// CBC w/o ECDHE
-	TLS_RSA_WITH_AES_128_CBC_SHA,
-	TLS_RSA_WITH_AES_256_CBC_SHA,
+	TLS_RSA_WITH_AES_128_CBC_SHA, TLS_PSK_WITH_AES_128_CBC_SHA,
+	TLS_RSA_WITH_AES_256_CBC_SHA, TLS_PSK_WITH_AES_256_CBC_SHA,
 
 	// 3DES
 	TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
 	TLS_RSA_WITH_3DES_EDE_CBC_SHA,
 
 	// CBC_SHA256
-	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
-	TLS_RSA_WITH_AES_128_CBC_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256, TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256,
+	TLS_RSA_WITH_AES_128_CBC_SHA256, TLS_PSK_WITH_AES_128_CBC_SHA256,
 
 	// RC4
 	TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, TLS_ECDHE_RSA_WITH_RC4_128_SHA,
@@ -310,23 +485,23 @@ var cipherSuitesPreferenceOrder = []uint16{
 
 var cipherSuitesPreferenceOrderNoAES = []uint16{
 	// ChaCha20Poly1305
-	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256,
 
 	// AES-GCM w/ ECDHE
-	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384,
 
 	// The rest of cipherSuitesPreferenceOrder.
-	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-	TLS_RSA_WITH_AES_128_GCM_SHA256,
-	TLS_RSA_WITH_AES_256_GCM_SHA384,
-	TLS_RSA_WITH_AES_128_CBC_SHA,
-	TLS_RSA_WITH_AES_256_CBC_SHA,
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA,
+	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA,
+	TLS_RSA_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_128_GCM_SHA256,
+	TLS_RSA_WITH_AES_256_GCM_SHA384, TLS_PSK_WITH_AES_256_GCM_SHA384,
+	TLS_RSA_WITH_AES_128_CBC_SHA, TLS_PSK_WITH_AES_128_CBC_SHA,
+	TLS_RSA_WITH_AES_256_CBC_SHA, TLS_PSK_WITH_AES_256_CBC_SHA,
 	TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
 	TLS_RSA_WITH_3DES_EDE_CBC_SHA,
-	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
-	TLS_RSA_WITH_AES_128_CBC_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256, TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256,
+	TLS_RSA_WITH_AES_128_CBC_SHA256, TLS_PSK_WITH_AES_128_CBC_SHA256,
 	TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, TLS_ECDHE_RSA_WITH_RC4_128_SHA,
 	TLS_RSA_WITH_RC4_128_SHA,
 }
@@ -337,6 +512,14 @@ var disabledCipherSuites = map[uint16]bool{
 	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: true,
 	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   true,
 	TLS_RSA_WITH_AES_128_CBC_SHA256:         true,
+	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256:   true,
+	TLS_PSK_WITH_AES_128_CBC_SHA256:         true,
+
+	// PSK w/o ECDHE, which are not forward secret
+	TLS_PSK_WITH_AES_128_GCM_SHA256: true,
+	TLS_PSK_WITH_AES_256_GCM_SHA384: true,
+	TLS_PSK_WITH_AES_128_CBC_SHA:    true,
+	TLS_PSK_WITH_AES_256_CBC_SHA:    true,
 
 	// RC4
 	TLS_ECDHE_ECDSA_WITH_RC4_128_SHA: true,
@@ -363,6 +546,17 @@ var tdesCiphers = map[uint16]bool{
 	TLS_RSA_WITH_3DES_EDE_CBC_SHA:       true,
 }
 
+// ecdhePSKCiphers contains the ECDHE_PSK ciphers, which are secure but only
+// used if explicitly listed in Config.CipherSuites, like the PSK ciphers in
+// disabledCipherSuites.
+var ecdhePSKCiphers = map[uint16]bool{
+	TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256:       true,
+	TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384:       true,
+	TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256: true,
+	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA:          true,
+	TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA:          true,
+}
+
 var (
 	// Keep in sync with crypto/internal/fips140/aes/gcm.supportsAESGCM.
 	hasGCMAsmAMD64 = cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ && cpu.X86.HasSSE41 && cpu.X86.HasSSSE3
@@ -388,9 +582,12 @@ var aesgcmCiphers = map[uint16]bool{
 // first known cipher in the peer's preference list is an AES-GCM cipher,
 // implying the peer also has hardware support for it.
 func isAESGCMPreferred(ciphers []uint16) bool {
-	if !hasAESGCMHardwareSupport {
-		return false
-	}
+	return hasAESGCMHardwareSupport && peerPrefersAESGCM(ciphers)
+}
+
+// peerPrefersAESGCM returns whether the first known cipher in the peer's
+// preference list is an AES-GCM cipher.
+func peerPrefersAESGCM(ciphers []uint16) bool {
 	for _, cID := range ciphers {
 		if c := cipherSuiteByID(cID); c != nil {
 			return aesgcmCiphers[cID]
@@ -427,13 +624,13 @@ func cipherAES(key, iv []byte, isRead bool) any {
 func macSHA1(key []byte) hash.Hash {
 	h := sha1.New
 	h = newConstantTimeHash(h)
-	return hmac.New(h, key)
+	return newRecordMAC(hmac.New(h, key), sha1.New, key)
 }
 
 // macSHA256 returns a SHA-256 based MAC. This is only supported in TLS 1.2 and
 // is currently only used in disabled-by-default cipher suites.
 func macSHA256(key []byte) hash.Hash {
-	return hmac.New(sha256.New, key)
+	return newRecordMAC(hmac.New(sha256.New, key), sha256.New, key)
 }
 
 type aead interface {
@@ -525,6 +722,22 @@ func aeadAESGCM(key, noncePrefix []byte) aead {
 	return ret
 }
 
+// newPrefixNonceGCM returns the TLS 1.2 GCM AEAD of block, for the cipher
+// suites of other block ciphers than AES.
+func newPrefixNonceGCM(block cipher.Block, noncePrefix []byte) aead {
+	if len(noncePrefix) != noncePrefixLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &prefixNonceAEAD{aead: aead}
+	copy(ret.nonce[:], noncePrefix)
+	return ret
+}
+
 // aeadAESGCMTLS13 should be an internal detail,
 // but widely used packages access it using linkname.
 // Notable members of the hall of shame include:
@@ -621,6 +834,17 @@ func ecdheRSAKA(version uint16) keyAgreement {
 	}
 }
 
+func pskKA(version uint16) keyAgreement {
+	return &pskKeyAgreement{version: version}
+}
+
+func ecdhePSKKA(version uint16) keyAgreement {
+	return &pskKeyAgreement{
+		version: version,
+		ecdhe:   &ecdheKeyAgreement{version: version},
+	}
+}
+
 // mutualCipherSuite returns a cipherSuite given a list of supported
 // ciphersuites and the id requested by the peer.
 func mutualCipherSuite(have []uint16, want uint16) *cipherSuite {
@@ -638,6 +862,16 @@ func cipherSuiteByID(id uint16) *cipherSuite {
 			return cipherSuite
 		}
 	}
+	for _, cipherSuite := range cipherSuitesTLCP {
+		if cipherSuite.id == id {
+			return cipherSuite
+		}
+	}
+	for _, cipherSuite := range cipherSuitesOptIn {
+		if cipherSuite.id == id {
+			return cipherSuite
+		}
+	}
 	return nil
 }
 
@@ -671,7 +905,14 @@ const (
 	TLS_RSA_WITH_AES_256_CBC_SHA                  uint16 = 0x0035
 	TLS_RSA_WITH_AES_128_CBC_SHA256               uint16 = 0x003c
 	TLS_RSA_WITH_AES_128_GCM_SHA256               uint16 = 0x009c
+	TLS_PSK_WITH_AES_128_CBC_SHA                  uint16 = 0x008c
+	TLS_PSK_WITH_AES_256_CBC_SHA                  uint16 = 0x008d
 	TLS_RSA_WITH_AES_256_GCM_SHA384               uint16 = 0x009d
+	TLS_DHE_RSA_WITH_AES_128_GCM_SHA256           uint16 = 0x009e
+	TLS_DHE_RSA_WITH_AES_256_GCM_SHA384           uint16 = 0x009f
+	TLS_PSK_WITH_AES_128_GCM_SHA256               uint16 = 0x00a8
+	TLS_PSK_WITH_AES_256_GCM_SHA384               uint16 = 0x00a9
+	TLS_PSK_WITH_AES_128_CBC_SHA256               uint16 = 0x00ae
 	TLS_ECDHE_ECDSA_WITH_RC4_128_SHA              uint16 = 0xc007
 	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA          uint16 = 0xc009
 	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA          uint16 = 0xc00a
@@ -685,14 +926,49 @@ const (
 	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256       uint16 = 0xc02b
 	TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384         uint16 = 0xc030
 	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384       uint16 = 0xc02c
+	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA            uint16 = 0xc035
+	TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA            uint16 = 0xc036
+	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256         uint16 = 0xc037
+	TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256      uint16 = 0xc05c
+	TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384      uint16 = 0xc05d
+	TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256        uint16 = 0xc060
+	TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384        uint16 = 0xc061
+	TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_GCM_SHA256  uint16 = 0xc086
+	TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384  uint16 = 0xc087
+	TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256    uint16 = 0xc08a
+	TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384    uint16 = 0xc08b
+	TLS_PSK_WITH_AES_128_CCM                      uint16 = 0xc0a4
+	TLS_PSK_WITH_AES_128_CCM_8                    uint16 = 0xc0a8
+	TLS_ECDHE_ECDSA_WITH_AES_128_CCM              uint16 = 0xc0ac
+	TLS_ECDHE_ECDSA_WITH_AES_256_CCM              uint16 = 0xc0ad
+	TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8            uint16 = 0xc0ae
+	TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8            uint16 = 0xc0af
 	TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256   uint16 = 0xcca8
 	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256 uint16 = 0xcca9
+	TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256   uint16 = 0xccac
+	TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256         uint16 = 0xd001
+	TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384         uint16 = 0xd002
 
 	// TLS 1.3 cipher suites.
 	TLS_AES_128_GCM_SHA256       uint16 = 0x1301
 	TLS_AES_256_GCM_SHA384       uint16 = 0x1302
 	TLS_CHACHA20_POLY1305_SHA256 uint16 = 0x1303
 
+	// TLS 1.3 AES-CCM cipher suites. They are only enabled when listed in
+	// Config.CipherSuites.
+	TLS_AES_128_CCM_SHA256   uint16 = 0x1304
+	TLS_AES_128_CCM_8_SHA256 uint16 = 0x1305
+
+	// TLS 1.3 ShangMi cipher suites, see RFC 8998. They are only enabled by
+	// Config.ShangMiCipherSuites.
+	TLS_SM4_GCM_SM3 uint16 = 0x00c6
+	TLS_SM4_CCM_SM3 uint16 = 0x00c7
+
+	// TLCP cipher suites, see GB/T 38636-2020. They are only enabled by
+	// Config.TLCP.
+	ECC_SM4_CBC_SM3 uint16 = 0xe013
+	ECC_SM4_GCM_SM3 uint16 = 0xe053
+
 	// TLS_FALLBACK_SCSV isn't a standard cipher suite but an indicator
 	// that the client is doing version fallback. See RFC 7507.
 	TLS_FALLBACK_SCSV uint16 = 0x5600
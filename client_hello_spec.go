@@ -0,0 +1,272 @@
+package tls
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// A ClientHelloSpec describes a ClientHello as sent on the wire, with the
+// extensions in their order and GREASE values included, for cloning the
+// fingerprint of another client, such as a newly released browser. It's
+// extracted from a capture with [ParseClientHelloSpec] or
+// [ClientHelloSpecsFromPcap], or written with the extension types of the
+// uTLS compatibility layer, and sent by a [UConn].
+type ClientHelloSpec struct {
+	// LegacyVersion is the legacy_version of the ClientHello, which TLS
+	// 1.3 clients set to VersionTLS12. If zero, it's set by this package.
+	LegacyVersion uint16
+
+	CipherSuites       []uint16
+	CompressionMethods []uint8
+
+	// Extensions are the extensions of the ClientHello, in order. Those
+	// of captures are GenericExtensions.
+	Extensions []TLSExtension
+
+	// TLSVersMin and TLSVersMax, if not zero, are the protocol versions
+	// enabled by ApplyTo, instead of those of the supported_versions
+	// extension.
+	TLSVersMin uint16
+	TLSVersMax uint16
+}
+
+// A TLSExtension is an extension of a ClientHelloSpec.
+type TLSExtension interface {
+	// Len returns the length of the extension, with its type and length.
+	Len() int
+
+	// Read writes the extension, with its type and length, to b, and
+	// returns io.EOF along with its length, or io.ErrShortBuffer if b is
+	// too short.
+	Read(b []byte) (n int, err error)
+}
+
+// marshalExtension returns the type and extension_data of ext.
+func marshalExtension(ext TLSExtension) (uint16, []byte, error) {
+	b := make([]byte, ext.Len())
+	n, err := ext.Read(b)
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	if n < 4 || n != 4+(int(b[2])<<8|int(b[3])) {
+		return 0, nil, errors.New("tls: malformed extension in ClientHelloSpec")
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), b[4:n], nil
+}
+
+// builtinExtension is implemented by the extension types of this package.
+type builtinExtension interface {
+	extension() (uint16, []byte)
+}
+
+func extensionLen(e builtinExtension) int {
+	_, data := e.extension()
+	return 4 + len(data)
+}
+
+func readExtension(e builtinExtension, b []byte) (int, error) {
+	id, data := e.extension()
+	if len(b) < 4+len(data) {
+		return 0, io.ErrShortBuffer
+	}
+	b[0], b[1] = byte(id>>8), byte(id)
+	b[2], b[3] = byte(len(data)>>8), byte(len(data))
+	return 4 + copy(b[4:], data), io.EOF
+}
+
+// GenericExtension is an extension of any type, with its extension_data as
+// is. Those of captures have the ephemeral values, such as key shares, of the
+// captured connection.
+type GenericExtension struct {
+	Id   uint16
+	Data []byte
+}
+
+func (e *GenericExtension) extension() (uint16, []byte) { return e.Id, e.Data }
+func (e *GenericExtension) Len() int                    { return extensionLen(e) }
+func (e *GenericExtension) Read(b []byte) (int, error)  { return readExtension(e, b) }
+
+// isGREASE reports whether v is a GREASE value of RFC 8701, of the form
+// 0x?A?A with both bytes equal.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// ParseClientHelloSpec parses a ClientHello, either as a handshake message or
+// as the TLS records the client sent first, possibly fragmented.
+func ParseClientHelloSpec(data []byte) (*ClientHelloSpec, error) {
+	if len(data) > 0 && recordType(data[0]) == recordTypeHandshake {
+		msg, err := clientHelloFromRecords(data)
+		if err != nil {
+			return nil, err
+		}
+		data = msg
+	}
+
+	s := cryptobyte.String(data)
+	var typ uint8
+	var body cryptobyte.String
+	if !s.ReadUint8(&typ) || typ != typeClientHello || !s.ReadUint24LengthPrefixed(&body) || !s.Empty() {
+		return nil, errors.New("tls: not a ClientHello")
+	}
+	spec := &ClientHelloSpec{}
+	var random, sessionID, suites, methods []byte
+	var extensions cryptobyte.String
+	if !body.ReadUint16(&spec.LegacyVersion) || !body.ReadBytes(&random, 32) ||
+		!readUint8LengthPrefixed(&body, &sessionID) ||
+		!readUint16LengthPrefixed(&body, &suites) || len(suites)%2 != 0 ||
+		!readUint8LengthPrefixed(&body, &methods) {
+		return nil, errors.New("tls: malformed ClientHello")
+	}
+	for i := 0; i < len(suites); i += 2 {
+		spec.CipherSuites = append(spec.CipherSuites, uint16(suites[i])<<8|uint16(suites[i+1]))
+	}
+	spec.CompressionMethods = append([]uint8(nil), methods...)
+	if body.Empty() {
+		return spec, nil
+	}
+	if !body.ReadUint16LengthPrefixed(&extensions) || !body.Empty() {
+		return nil, errors.New("tls: malformed ClientHello extensions")
+	}
+	for !extensions.Empty() {
+		ext := &GenericExtension{}
+		var extData []byte
+		if !extensions.ReadUint16(&ext.Id) || !readUint16LengthPrefixed(&extensions, &extData) {
+			return nil, errors.New("tls: malformed ClientHello extensions")
+		}
+		ext.Data = append([]byte{}, extData...)
+		spec.Extensions = append(spec.Extensions, ext)
+	}
+	return spec, nil
+}
+
+// clientHelloFromRecords reassembles the ClientHello message from the
+// handshake records starting data.
+func clientHelloFromRecords(data []byte) ([]byte, error) {
+	var msg []byte
+	for len(msg) < 4 || len(msg) < 4+(int(msg[1])<<16|int(msg[2])<<8|int(msg[3])) {
+		if len(data) < recordHeaderLen || recordType(data[0]) != recordTypeHandshake {
+			return nil, errors.New("tls: truncated ClientHello records")
+		}
+		n := int(data[3])<<8 | int(data[4])
+		if len(data) < recordHeaderLen+n {
+			return nil, errors.New("tls: truncated ClientHello records")
+		}
+		msg = append(msg, data[recordHeaderLen:recordHeaderLen+n]...)
+		data = data[recordHeaderLen+n:]
+		if len(msg) > maxHandshake {
+			return nil, errors.New("tls: oversized ClientHello")
+		}
+	}
+	return msg[:4+(int(msg[1])<<16|int(msg[2])<<8|int(msg[3]))], nil
+}
+
+// Extension returns the data of the first extension of type typ, if any.
+func (s *ClientHelloSpec) Extension(typ uint16) ([]byte, bool) {
+	for _, ext := range s.Extensions {
+		if id, data, err := marshalExtension(ext); err == nil && id == typ {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// extensionIDs returns the types of the extensions, in order, skipping those
+// that fail to marshal.
+func (s *ClientHelloSpec) extensionIDs() []uint16 {
+	var ids []uint16
+	for _, ext := range s.Extensions {
+		if id, _, err := marshalExtension(ext); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// uint16List parses the extension typ, a list of uint16 with a length prefix
+// of prefixLen bytes, skipping the GREASE values.
+func (s *ClientHelloSpec) uint16List(typ uint16, prefixLen int) []uint16 {
+	data, ok := s.Extension(typ)
+	if !ok || len(data) < prefixLen {
+		return nil
+	}
+	var list []uint16
+	for data = data[prefixLen:]; len(data) >= 2; data = data[2:] {
+		if v := uint16(data[0])<<8 | uint16(data[1]); !isGREASE(v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ApplyTo sets the fields of config matching the preferences of the
+// ClientHello this package implements: the protocol versions, the TLS 1.0–1.2
+// cipher suites, the key exchanges, the signature schemes, the ALPN
+// protocols and the record size limit. Unknown and GREASE values are
+// skipped, and the order of the extensions isn't reproduced.
+func (s *ClientHelloSpec) ApplyTo(config *Config) {
+	versions := s.uint16List(extensionSupportedVersions, 1)
+	if len(versions) == 0 {
+		versions = []uint16{cmpOr(s.LegacyVersion, VersionTLS12)}
+	}
+	config.MinVersion, config.MaxVersion = 0, 0
+	for _, v := range versions {
+		if v < VersionTLS10 || v > VersionTLS13 {
+			continue
+		}
+		if config.MinVersion == 0 || v < config.MinVersion {
+			config.MinVersion = v
+		}
+		if v > config.MaxVersion {
+			config.MaxVersion = v
+		}
+	}
+	config.MinVersion = cmpOr(s.TLSVersMin, config.MinVersion)
+	config.MaxVersion = cmpOr(s.TLSVersMax, config.MaxVersion)
+
+	config.CipherSuites = nil
+	for _, id := range s.CipherSuites {
+		if cipherSuiteByID(id) != nil {
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	config.CurvePreferences = nil
+	for _, id := range s.uint16List(extensionSupportedCurves, 2) {
+		if _, err := keyExchangeForCurveID(CurveID(id)); err == nil {
+			config.CurvePreferences = append(config.CurvePreferences, CurveID(id))
+		}
+	}
+
+	config.SignatureSchemes = nil
+	for _, id := range s.uint16List(extensionSignatureAlgorithms, 2) {
+		if _, _, err := typeAndHashFromSignatureScheme(SignatureScheme(id)); err == nil {
+			config.SignatureSchemes = append(config.SignatureSchemes, SignatureScheme(id))
+		}
+	}
+
+	config.NextProtos = nil
+	if data, ok := s.Extension(extensionALPN); ok {
+		list := cryptobyte.String(data)
+		var protos cryptobyte.String
+		if list.ReadUint16LengthPrefixed(&protos) {
+			for !protos.Empty() {
+				var proto []byte
+				if !readUint8LengthPrefixed(&protos, &proto) {
+					break
+				}
+				config.NextProtos = append(config.NextProtos, string(proto))
+			}
+		}
+	}
+
+	config.RecordSizeLimit = 0
+	if data, ok := s.Extension(extensionRecordSizeLimit); ok && len(data) == 2 {
+		config.RecordSizeLimit = int(data[0])<<8 | int(data[1])
+		if config.MaxVersion == VersionTLS13 {
+			config.RecordSizeLimit-- // the content type
+		}
+	}
+}
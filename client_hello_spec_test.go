@@ -0,0 +1,243 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// sentClientHello returns the records of the ClientHello sent with config.
+func sentClientHello(t *testing.T, config *Config) []byte {
+	return firstRecord(t, func(c net.Conn) { Client(c, config).Handshake() })
+}
+
+// firstRecord returns the first record sent by handshake.
+func firstRecord(t *testing.T, handshake func(net.Conn)) []byte {
+	c, s := localPipe(t)
+	defer s.Close()
+	go func() {
+		handshake(c)
+		c.Close()
+	}()
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(s, header); err != nil {
+		t.Fatal(err)
+	}
+	record := make([]byte, recordHeaderLen+(int(header[3])<<8|int(header[4])))
+	copy(record, header)
+	if _, err := io.ReadFull(s, record[recordHeaderLen:]); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestClientHelloSpec(t *testing.T) {
+	config := testConfig.Clone()
+	config.ServerName = "example.com"
+	config.NextProtos = []string{"h2", "http/1.1"}
+	config.RecordSizeLimit = 1000
+	config.CipherSuites = []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+	config.SignatureSchemes = []SignatureScheme{Ed25519, ECDSAWithP256AndSHA256, PSSWithSHA256}
+	record := sentClientHello(t, config)
+
+	spec, err := ParseClientHelloSpec(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.LegacyVersion != VersionTLS12 {
+		t.Errorf("legacy version %x", spec.LegacyVersion)
+	}
+	if data, ok := spec.Extension(extensionServerName); !ok || !bytes.Contains(data, []byte("example.com")) {
+		t.Errorf("server_name extension %q", data)
+	}
+	msg, err := ParseClientHelloSpec(record[recordHeaderLen:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, spec) {
+		t.Errorf("the message parses as %+v, the record as %+v", msg, spec)
+	}
+
+	// A fragmented ClientHello, and the GREASE values of a browser.
+	fragmented := append([]byte{}, record[:recordHeaderLen]...)
+	binary.BigEndian.PutUint16(fragmented[3:], 10)
+	fragmented = append(fragmented, record[recordHeaderLen:recordHeaderLen+10]...)
+	fragmented = append(fragmented, record[:recordHeaderLen]...)
+	binary.BigEndian.PutUint16(fragmented[len(fragmented)-2:], uint16(len(record)-recordHeaderLen-10))
+	fragmented = append(fragmented, record[recordHeaderLen+10:]...)
+	if got, err := ParseClientHelloSpec(fragmented); err != nil || !reflect.DeepEqual(got, spec) {
+		t.Errorf("the fragmented ClientHello parses as %+v, %v", got, err)
+	}
+	if _, err := ParseClientHelloSpec(record[:len(record)-1]); err == nil {
+		t.Error("a truncated ClientHello parsed")
+	}
+	for _, ext := range spec.Extensions {
+		if ext := ext.(*GenericExtension); ext.Id == extensionSupportedCurves {
+			ext.Data = append([]byte{0, byte(len(ext.Data)), 0x3a, 0x3a}, ext.Data[2:]...)
+		}
+	}
+	// The cipher suites are in the order sent, which depends on the hardware.
+	var suites []uint16
+	for _, id := range spec.CipherSuites {
+		if slicesContains(config.CipherSuites, id) {
+			suites = append(suites, id)
+		}
+	}
+	spec.CipherSuites = append([]uint16{0x5a5a}, spec.CipherSuites...)
+
+	got := &Config{}
+	spec.ApplyTo(got)
+	if got.MinVersion != VersionTLS10 || got.MaxVersion != VersionTLS13 {
+		t.Errorf("versions %x to %x", got.MinVersion, got.MaxVersion)
+	}
+	if len(suites) != len(config.CipherSuites) || !reflect.DeepEqual(got.CipherSuites, suites) {
+		t.Errorf("cipher suites %v, want %v", got.CipherSuites, config.CipherSuites)
+	}
+	if !reflect.DeepEqual(got.CurvePreferences, config.CurvePreferences) {
+		t.Errorf("curves %v, want %v", got.CurvePreferences, config.CurvePreferences)
+	}
+	if !reflect.DeepEqual(got.SignatureSchemes, config.SignatureSchemes) {
+		t.Errorf("signature schemes %v, want %v", got.SignatureSchemes, config.SignatureSchemes)
+	}
+	if !reflect.DeepEqual(got.NextProtos, config.NextProtos) {
+		t.Errorf("ALPN protocols %v, want %v", got.NextProtos, config.NextProtos)
+	}
+	if got.RecordSizeLimit != config.RecordSizeLimit {
+		t.Errorf("record size limit %d, want %d", got.RecordSizeLimit, config.RecordSizeLimit)
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !isGREASE(v) {
+			t.Errorf("%#x isn't GREASE", v)
+		}
+	}
+	for _, v := range []uint16{0x0a1a, 0x0a0b, 0x1301} {
+		if isGREASE(v) {
+			t.Errorf("%#x is GREASE", v)
+		}
+	}
+}
+
+// testPcap returns a pcap capture over Ethernet of the segments, sent from
+// 10.0.0.1:40000 to 10.0.0.2:443.
+func testPcap(segments []tcpSegment) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header, 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeEthernet)
+	b.Write(header)
+	for _, seg := range segments {
+		frame := make([]byte, 14+20+20, 14+20+20+len(seg.payload))
+		binary.BigEndian.PutUint16(frame[12:], 0x0800)
+		ip := frame[14:]
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+20+len(seg.payload)))
+		ip[8], ip[9] = 64, 6
+		copy(ip[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+		tcp := ip[20:]
+		binary.BigEndian.PutUint16(tcp, 40000)
+		binary.BigEndian.PutUint16(tcp[2:], 443)
+		binary.BigEndian.PutUint32(tcp[4:], seg.seq)
+		tcp[12] = 5 << 4
+		frame = append(frame, seg.payload...)
+
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+		b.Write(record)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+func TestClientHelloSpecsFromPcap(t *testing.T) {
+	record := sentClientHello(t, testConfig.Clone())
+	want, err := ParseClientHelloSpec(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second segment is captured first, and then retransmitted.
+	const isn = 0xffffff00 // wrapping around
+	capture := testPcap([]tcpSegment{
+		{seq: isn + 100, payload: record[100:]},
+		{seq: isn, payload: record[:100]},
+		{seq: isn + 100, payload: record[100:]},
+	})
+	specs, err := ClientHelloSpecsFromPcap(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 1 || !reflect.DeepEqual(specs[0], want) {
+		t.Errorf("got %d ClientHellos, want the one sent", len(specs))
+	}
+
+	capture = testPcap([]tcpSegment{{seq: isn, payload: record[:100]}})
+	if specs, err := ClientHelloSpecsFromPcap(bytes.NewReader(capture)); err != nil || len(specs) != 0 {
+		t.Errorf("got %d ClientHellos from a partial one, %v", len(specs), err)
+	}
+	if _, err := ClientHelloSpecsFromPcap(bytes.NewReader(record)); err == nil {
+		t.Error("a TLS record parsed as a capture")
+	}
+}
+
+func testPcapngBlock(typ uint32, body []byte) []byte {
+	b := make([]byte, 8, 12+len(body))
+	binary.LittleEndian.PutUint32(b, typ)
+	binary.LittleEndian.PutUint32(b[4:], uint32(12+len(body)))
+	b = append(b, body...)
+	return append(b, b[4:8]...)
+}
+
+func TestClientHelloSpecsFromMalformedPcap(t *testing.T) {
+	// The lengths don't fit in an int on 32-bit platforms.
+	const huge = 0xffffff00
+
+	capture := testPcap(nil)
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[8:], huge)
+	binary.LittleEndian.PutUint32(record[12:], huge)
+	capture = append(capture, record...)
+	if specs, err := ClientHelloSpecsFromPcap(bytes.NewReader(capture)); err != nil || len(specs) != 0 {
+		t.Errorf("pcap: got %d ClientHellos, %v", len(specs), err)
+	}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb, 0x1a2b3c4d)
+	binary.LittleEndian.PutUint64(shb[8:], 0xffffffffffffffff)
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb, linkTypeEthernet)
+	header := append(testPcapngBlock(0x0a0d0d0a, shb), testPcapngBlock(1, idb)...)
+	for _, tt := range []struct {
+		name     string
+		iface    uint32
+		captured uint32
+	}{
+		{"interface", huge, 0},
+		{"captured", 0, huge},
+	} {
+		epb := make([]byte, 20)
+		binary.LittleEndian.PutUint32(epb, tt.iface)
+		binary.LittleEndian.PutUint32(epb[12:], tt.captured)
+		binary.LittleEndian.PutUint32(epb[16:], tt.captured)
+		capture := append(slicesClone(header), testPcapngBlock(6, epb)...)
+		if specs, err := ClientHelloSpecsFromPcap(bytes.NewReader(capture)); err != nil || len(specs) != 0 {
+			t.Errorf("pcapng %s: got %d ClientHellos, %v", tt.name, len(specs), err)
+		}
+	}
+
+	block := testPcapngBlock(6, make([]byte, 20))
+	binary.LittleEndian.PutUint32(block[4:], huge)
+	capture = append(slicesClone(header), block...)
+	if _, err := ClientHelloSpecsFromPcap(bytes.NewReader(capture)); err == nil {
+		t.Error("pcapng: block longer than the capture accepted")
+	}
+}
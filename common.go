@@ -18,13 +18,18 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"hash/maphash"
 	"io"
+	"math"
 	"net"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	_ "unsafe" // for linkname
+
+	"github.com/cloudflare/circl/sign/ed448"
 )
 
 const (
@@ -33,6 +38,9 @@ const (
 	VersionTLS12 = 0x0303
 	VersionTLS13 = 0x0304
 
+	// VersionTLCP is the version of TLCP, see Config.TLCP.
+	VersionTLCP = 0x0101
+
 	// Deprecated: SSLv3 is cryptographically broken, and is no longer
 	// supported by this package. See golang.org/issue/32716.
 	VersionSSL30 = 0x0300
@@ -53,6 +61,8 @@ func VersionName(version uint16) string {
 		return "TLS 1.2"
 	case VersionTLS13:
 		return "TLS 1.3"
+	case VersionTLCP:
+		return "TLCP"
 	default:
 		return fmt.Sprintf("0x%04X", version)
 	}
@@ -80,22 +90,24 @@ const (
 
 // TLS handshake message types.
 const (
-	typeHelloRequest        uint8 = 0
-	typeClientHello         uint8 = 1
-	typeServerHello         uint8 = 2
-	typeNewSessionTicket    uint8 = 4
-	typeEndOfEarlyData      uint8 = 5
-	typeEncryptedExtensions uint8 = 8
-	typeCertificate         uint8 = 11
-	typeServerKeyExchange   uint8 = 12
-	typeCertificateRequest  uint8 = 13
-	typeServerHelloDone     uint8 = 14
-	typeCertificateVerify   uint8 = 15
-	typeClientKeyExchange   uint8 = 16
-	typeFinished            uint8 = 20
-	typeCertificateStatus   uint8 = 22
-	typeKeyUpdate           uint8 = 24
-	typeMessageHash         uint8 = 254 // synthetic message
+	typeHelloRequest             uint8 = 0
+	typeClientHello              uint8 = 1
+	typeServerHello              uint8 = 2
+	typeNewSessionTicket         uint8 = 4
+	typeEndOfEarlyData           uint8 = 5
+	typeEncryptedExtensions      uint8 = 8
+	typeCertificate              uint8 = 11
+	typeServerKeyExchange        uint8 = 12
+	typeCertificateRequest       uint8 = 13
+	typeServerHelloDone          uint8 = 14
+	typeCertificateVerify        uint8 = 15
+	typeClientKeyExchange        uint8 = 16
+	typeClientCertificateRequest uint8 = 17 // RFC 9261, Section 4
+	typeFinished                 uint8 = 20
+	typeCertificateStatus        uint8 = 22
+	typeKeyUpdate                uint8 = 24
+	typeCompressedCertificate    uint8 = 25
+	typeMessageHash              uint8 = 254 // synthetic message
 )
 
 // TLS compression types.
@@ -112,7 +124,14 @@ const (
 	extensionSignatureAlgorithms     uint16 = 13
 	extensionALPN                    uint16 = 16
 	extensionSCT                     uint16 = 18
+	extensionClientCertificateType   uint16 = 19
+	extensionServerCertificateType   uint16 = 20
+	extensionPadding                 uint16 = 21
+	extensionEncryptThenMAC          uint16 = 22
 	extensionExtendedMasterSecret    uint16 = 23
+	extensionCompressCertificate     uint16 = 27
+	extensionRecordSizeLimit         uint16 = 28
+	extensionDelegatedCredential     uint16 = 34
 	extensionSessionTicket           uint16 = 35
 	extensionPreSharedKey            uint16 = 41
 	extensionEarlyData               uint16 = 42
@@ -120,6 +139,7 @@ const (
 	extensionCookie                  uint16 = 44
 	extensionPSKModes                uint16 = 45
 	extensionCertificateAuthorities  uint16 = 47
+	extensionPostHandshakeAuth       uint16 = 49
 	extensionSignatureAlgorithmsCert uint16 = 50
 	extensionKeyShare                uint16 = 51
 	extensionQUICTransportParameters uint16 = 57
@@ -147,14 +167,25 @@ const (
 	CurveP384          CurveID = 24
 	CurveP521          CurveID = 25
 	X25519             CurveID = 29
+	CurveSM2           CurveID = 41 // RFC 8998, only with Config.ShangMiCipherSuites
 	X25519MLKEM768     CurveID = 4588
 	SecP256r1MLKEM768  CurveID = 4587
 	SecP384r1MLKEM1024 CurveID = 4589
+
+	// The FFDHE groups of RFC 7919, only used by the DHE cipher suites in
+	// TLS 1.0–1.2. Unlike the other key exchanges, the DHE one is computed
+	// with math/big, which isn't constant time, so its timing may leak
+	// information about the ephemeral private exponents. The DHE cipher
+	// suites should only be enabled to interoperate with peers that support
+	// nothing else.
+	FFDHE2048 CurveID = 256
+	FFDHE3072 CurveID = 257
+	FFDHE4096 CurveID = 258
 )
 
 func isTLS13OnlyKeyExchange(curve CurveID) bool {
 	switch curve {
-	case X25519MLKEM768, SecP256r1MLKEM768, SecP384r1MLKEM1024:
+	case X25519MLKEM768, SecP256r1MLKEM768, SecP384r1MLKEM1024, CurveSM2:
 		return true
 	default:
 		return false
@@ -213,11 +244,13 @@ const (
 	signatureRSAPSS
 	signatureECDSA
 	signatureEd25519
+	signatureEd448
+	signatureSM2
 )
 
 // directSigning is a standard Hash value that signals that no pre-hashing
 // should be performed, and that the input should be signed directly. It is the
-// hash function associated with the Ed25519 signature scheme.
+// hash function associated with the Ed25519 and Ed448 signature schemes.
 var directSigning crypto.Hash = 0
 
 // helloRetryRequestRandom is set as the Random value of a ServerHello
@@ -257,6 +290,18 @@ type ConnectionState struct {
 	// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_AES_128_GCM_SHA256).
 	CipherSuite uint16
 
+	// InsecureCipherSuite is true if CipherSuite is one of those returned by
+	// [InsecureCipherSuites], and has known security issues.
+	InsecureCipherSuite bool
+
+	// FIPSApproved is true if the protocol version, cipher suite, key
+	// exchange, peer signature algorithm and peer certificate key of the
+	// connection are all in the set allowed by [Config.FIPSMode]. It only
+	// describes the negotiated algorithms, and doesn't mean that the
+	// connection is FIPS 140-3 compliant, as they aren't implemented by a
+	// validated module.
+	FIPSApproved bool
+
 	// CurveID is the key exchange mechanism used for the connection. The name
 	// refers to elliptic curves for legacy reasons, see [CurveID]. If a legacy
 	// RSA key exchange is used, this value is zero.
@@ -278,9 +323,10 @@ type ConnectionState struct {
 	// order in which they were sent. The first element is the leaf certificate
 	// that the connection is verified against.
 	//
-	// On the client side, it can't be empty. On the server side, it can be
-	// empty if Config.ClientAuth is not RequireAnyClientCert or
-	// RequireAndVerifyClientCert.
+	// On the client side, it can't be empty, unless the server authenticated
+	// with a raw public key. On the server side, it can be empty if
+	// Config.ClientAuth is not RequireAnyClientCert or
+	// RequireAndVerifyClientCert, or if the client used a raw public key.
 	//
 	// PeerCertificates and its contents should not be modified.
 	PeerCertificates []*x509.Certificate
@@ -300,6 +346,12 @@ type ConnectionState struct {
 	// through the TLS handshake for the leaf certificate, if any.
 	SignedCertificateTimestamps [][]byte
 
+	// VerifiedSCTs are the SCTs for the leaf certificate, from the
+	// certificate itself, the TLS handshake or OCSPResponse, that were
+	// signed by one of the logs in Config.CTLogs. It's only set on the
+	// client side, and not for resumed connections.
+	VerifiedSCTs []VerifiedSCT
+
 	// OCSPResponse is a stapled Online Certificate Status Protocol (OCSP)
 	// response provided by the peer for the leaf certificate, if any.
 	OCSPResponse []byte
@@ -318,14 +370,101 @@ type ConnectionState struct {
 	// are a server, or if we received a HelloRetryRequest if we are a client.
 	HelloRetryRequest bool
 
+	// EarlyDataAccepted is true if the client sent 0-RTT application data
+	// and the server accepted it. If a client queued early data with
+	// [Conn.WriteEarlyData] and this is false, the data was not delivered.
+	EarlyDataAccepted bool
+
+	// EarlyDataOffered is true if the first ClientHello of the client
+	// offered 0-RTT application data, whether or not it was accepted.
+	EarlyDataOffered bool
+
+	// Resumption is how the handshake was authenticated with a pre-shared
+	// key instead of certificates, if it was.
+	Resumption Resumption
+
+	// KeyUpdatesSent and KeyUpdatesReceived are the numbers of TLS 1.3
+	// KeyUpdate messages sent and received since the handshake.
+	KeyUpdatesSent, KeyUpdatesReceived int
+
+	// PeerALPNProtocols are the application protocols offered by the client
+	// with ALPN, in its order of preference. It's only set on the server
+	// side, since servers reply with the single protocol they selected.
+	PeerALPNProtocols []string
+
+	// PeerHelloExtensions are the types of the extensions of the last
+	// ClientHello or ServerHello of the peer, in the order it sent them. For
+	// a client that offered ECH, it's the inner ClientHello if accepted.
+	PeerHelloExtensions []uint16
+
+	// ExternalPSKIdentity is the identity of the external PSK that
+	// authenticated the connection, if any. See [Config.ExternalPSKs].
+	ExternalPSKIdentity []byte
+
+	// CovertPayload is the payload of [Config.CovertAuth] the client sent,
+	// verified by the server. It's nil on clients and if there was none.
+	CovertPayload []byte
+
+	// DelegatedCredential is the delegated credential the server signed the
+	// handshake with instead of the key of PeerCertificates[0], if any. It's
+	// only set on the client side, and not for resumed connections. See
+	// [Config.AcceptDelegatedCredentials].
+	DelegatedCredential *DelegatedCredential
+
+	// PeerRawPublicKey is the SubjectPublicKeyInfo the peer authenticated
+	// with instead of a certificate chain, if it negotiated raw public keys
+	// as specified in RFC 7250. PeerCertificates is empty when it's set. See
+	// [Config.ServerCertificateTypes] and [Config.ClientCertificateTypes].
+	PeerRawPublicKey []byte
+
+	// PeerSignatureScheme is the signature algorithm the peer signed the
+	// handshake with, or zero if it didn't sign it, like with resumption,
+	// PSKs and the RSA key exchange.
+	PeerSignatureScheme SignatureScheme
+
+	// CertificateCompression is the algorithm the peer compressed its
+	// certificate chain with, or zero if it was sent uncompressed.
+	CertificateCompression CertificateCompressionAlgorithm
+
+	// RecordSizeLimit is the maximum size of the plaintext of the records
+	// sent to the peer, which is 16384 unless the peer lowered it with the
+	// record_size_limit extension. See [Config.RecordSizeLimit].
+	RecordSizeLimit int
+
+	// ExtendedMasterSecret is whether the extended master secret of RFC 7627
+	// was used. It's always false in TLS 1.3, whose key schedule covers the
+	// whole handshake anyway.
+	ExtendedMasterSecret bool
+
+	// SecureRenegotiation is whether the peer supports the renegotiation_info
+	// extension of RFC 5746. It's always false in TLS 1.3, which has no
+	// renegotiation.
+	SecureRenegotiation bool
+
 	// ekm is a closure exposed via ExportKeyingMaterial.
 	ekm func(label string, context []byte, length int) ([]byte, error)
-
-	// testingOnlyPeerSignatureAlgorithm is the signature algorithm used by the
-	// peer to sign the handshake. It is not set for resumed connections.
-	testingOnlyPeerSignatureAlgorithm SignatureScheme
 }
 
+// A Resumption is how a handshake was authenticated with a pre-shared key,
+// reported in [ConnectionState.Resumption].
+type Resumption int
+
+const (
+	// ResumptionNone is a full handshake.
+	ResumptionNone Resumption = iota
+
+	// ResumptionTicket is a TLS 1.2 handshake resuming a session ticket.
+	ResumptionTicket
+
+	// ResumptionPSK is a TLS 1.3 handshake resuming a session with the
+	// pre-shared key of a NewSessionTicket.
+	ResumptionPSK
+
+	// ResumptionExternalPSK is a handshake authenticated with one of
+	// Config.ExternalPSKs. DidResume is false for it.
+	ResumptionExternalPSK
+)
+
 // ExportKeyingMaterial returns length bytes of exported key material in a new
 // slice as defined in RFC 5705. If context is nil, it is not used as part of
 // the seed. If the connection was set to allow renegotiation via
@@ -397,6 +536,20 @@ type ClientSessionCache interface {
 	Put(sessionKey string, cs *ClientSessionState)
 }
 
+// ServerSessionCache stores the sessions of single-use session tickets on a
+// server. See [Config.SingleUseTickets]. ServerSessionCache implementations
+// should expect to be called concurrently from different goroutines.
+type ServerSessionCache interface {
+	// Put adds the SessionState to the cache with the given ticket identity.
+	Put(identity string, ss *SessionState)
+
+	// Take removes the SessionState associated with the given ticket identity
+	// from the cache and returns it, or returns nil if none is found. Take
+	// must return a given SessionState at most once, even if called
+	// concurrently.
+	Take(identity string) *SessionState
+}
+
 //go:generate stringer -linecomment -type=SignatureScheme,CurveID,ClientAuthType -output=common_string.go
 
 // SignatureScheme identifies a signature algorithm supported by TLS. See
@@ -421,6 +574,10 @@ const (
 
 	// EdDSA algorithms.
 	Ed25519 SignatureScheme = 0x0807
+	Ed448   SignatureScheme = 0x0808
+
+	// SM2 with SM3, see RFC 8998. Only supported in TLS 1.3.
+	SM2WithSM3 SignatureScheme = 0x0708
 
 	// Legacy signature and hash algorithms for TLS 1.2.
 	PKCS1WithSHA1 SignatureScheme = 0x0201
@@ -457,6 +614,12 @@ type ClientHelloInfo struct {
 	// Algorithms Extension is being used (see RFC 5246, Section 7.4.1.4.1).
 	SignatureSchemes []SignatureScheme
 
+	// CertificateSignatureSchemes lists the signature algorithms the client
+	// accepts in certificate chains, from the signature_algorithms_cert
+	// extension (see RFC 8446, Section 4.2.3). If set, SupportsCertificate
+	// rejects chains signed with other algorithms.
+	CertificateSignatureSchemes []SignatureScheme
+
 	// SupportedProtos lists the application protocols supported by the client.
 	// SupportedProtos is set only if the Application-Layer Protocol
 	// Negotiation Extension is being used (see RFC 7301, Section 3.1).
@@ -484,10 +647,30 @@ type ClientHelloInfo struct {
 	// to a HelloRetryRequest message.
 	HelloRetryRequest bool
 
+	// AcceptableCAs contains zero or more, DER-encoded, X.501
+	// Distinguished Names of the CAs the client trusts, from the
+	// certificate_authorities extension (see RFC 8446, Section 4.2.4).
+	// SupportsCertificate rejects chains not issued by one of them.
+	AcceptableCAs [][]byte
+
+	// CovertPayload is the payload of [Config.CovertAuth] in the
+	// ClientHello, if the server verified one.
+	CovertPayload []byte
+
+	// Replayed is true if [Config.ClientHelloReplayFilter] already saw the
+	// random of the ClientHello.
+	Replayed bool
+
 	// config is embedded by the GetCertificate or GetConfigForClient caller,
 	// for use with SupportsCertificate.
 	config *Config
 
+	// msg is the ClientHello, and echOuterServerName the server name of the
+	// ClientHelloOuter it was decrypted from, if echAccepted.
+	msg                *clientHelloMsg
+	echAccepted        bool
+	echOuterServerName string
+
 	// isQUIC indicates whether the connection is a QUIC connection.
 	isQUIC bool
 
@@ -502,6 +685,43 @@ func (c *ClientHelloInfo) Context() context.Context {
 	return c.ctx
 }
 
+// A ClientHello is passed to [Config.GetCertificateForHello]. If the server
+// accepted an Encrypted Client Hello, it's the ClientHelloInner the client
+// encrypted, and the fields of ClientHelloInfo, such as ServerName,
+// SupportedProtos, SignatureSchemes and CipherSuites, are those it contains.
+type ClientHello struct {
+	*ClientHelloInfo
+
+	// Raw is the ClientHello handshake message. For a ClientHelloInner, it
+	// includes the extensions it referenced from the ClientHelloOuter.
+	Raw []byte
+
+	// ECHAccepted indicates whether the ClientHello was decrypted from an
+	// Encrypted Client Hello.
+	ECHAccepted bool
+
+	// OuterServerName is the server name of the ClientHelloOuter, usually
+	// the public name of the ECH configuration. It's only set if
+	// ECHAccepted is true.
+	OuterServerName string
+}
+
+func (c *ClientHelloInfo) clientHello() (*ClientHello, error) {
+	hello := &ClientHello{
+		ClientHelloInfo: c,
+		ECHAccepted:     c.echAccepted,
+		OuterServerName: c.echOuterServerName,
+	}
+	if c.msg != nil {
+		raw, err := c.msg.marshal()
+		if err != nil {
+			return nil, err
+		}
+		hello.Raw = raw
+	}
+	return hello, nil
+}
+
 // CertificateRequestInfo contains information from a server's
 // CertificateRequest message, which is used to demand a certificate and proof
 // of control from a client.
@@ -516,6 +736,12 @@ type CertificateRequestInfo struct {
 	// willing to verify.
 	SignatureSchemes []SignatureScheme
 
+	// CertificateSignatureSchemes lists the signature algorithms the server
+	// accepts in certificate chains, from the signature_algorithms_cert
+	// extension of a TLS 1.3 CertificateRequest. If set, SupportsCertificate
+	// rejects chains signed with other algorithms.
+	CertificateSignatureSchemes []SignatureScheme
+
 	// Version is the TLS version that was negotiated for this connection.
 	Version uint16
 
@@ -559,6 +785,27 @@ const (
 	RenegotiateFreelyAsClient
 )
 
+// AESGCMPreference controls whether AES-GCM cipher suites are preferred over
+// ChaCha20-Poly1305 ones.
+type AESGCMPreference int
+
+const (
+	// AESGCMPreferenceAuto prefers AES-GCM if both the local machine and,
+	// for servers, the client appear to have hardware support for it.
+	AESGCMPreferenceAuto AESGCMPreference = iota
+
+	// AESGCMPreferenceAlways always prefers AES-GCM.
+	AESGCMPreferenceAlways
+
+	// AESGCMPreferenceNever always prefers ChaCha20-Poly1305.
+	AESGCMPreferenceNever
+
+	// AESGCMPreferencePeer makes servers prefer AES-GCM if the client lists
+	// it before ChaCha20-Poly1305, whether or not the local machine has
+	// hardware support for it. Clients behave as with AESGCMPreferenceAuto.
+	AESGCMPreferencePeer
+)
+
 // A Config structure is used to configure a TLS client or server.
 // After one has been passed to a TLS function it must not be
 // modified. A Config may be reused; the tls package will also not
@@ -596,6 +843,15 @@ type Config struct {
 	// select the first compatible chain from Certificates.
 	NameToCertificate map[string]*Certificate
 
+	// RankCertificates makes servers select, among the Certificates
+	// compatible with the client, the one whose names match the requested
+	// server name exactly over one matching it with a wildcard, and then the
+	// one with the most efficient key: Ed25519, then ECDSA with P-256, P-384
+	// or Ed448, P-521, and then RSA. Certificates for multiple names and key
+	// types can then be listed in any order, instead of the first compatible
+	// one being selected. Ties are broken by the order of Certificates.
+	RankCertificates bool
+
 	// GetCertificate returns a Certificate based on the given
 	// ClientHelloInfo. It will only be called if the client supplies SNI
 	// information or if Certificates is empty.
@@ -607,6 +863,13 @@ type Config struct {
 	// Once a Certificate is returned it should not be modified.
 	GetCertificate func(*ClientHelloInfo) (*Certificate, error)
 
+	// GetCertificateForHello, if not nil, is called instead of GetCertificate
+	// and under the same conditions, with the encoding of the ClientHello and
+	// whether it was decrypted from an Encrypted Client Hello, so that
+	// servers for several names behind a shared ECH public name can select
+	// the certificate of the name the client actually requested.
+	GetCertificateForHello func(*ClientHello) (*Certificate, error)
+
 	// GetClientCertificate, if not nil, is called when a server requests a
 	// certificate from a client. If set, the contents of Certificates will
 	// be ignored.
@@ -624,6 +887,16 @@ type Config struct {
 	// Once a Certificate is returned it should not be modified.
 	GetClientCertificate func(*CertificateRequestInfo) (*Certificate, error)
 
+	// PostHandshakeAuth, on the client side, offers to authenticate with a
+	// certificate after a TLS 1.3 handshake, when the server calls
+	// [Conn.RequestClientCertificate]. The certificate is chosen with
+	// GetClientCertificate or Certificates, like one requested during the
+	// handshake, and it's sent while reading from the connection, so that
+	// the request is only answered by a client that keeps reading.
+	//
+	// It's ignored for QUIC connections.
+	PostHandshakeAuth bool
+
 	// GetConfigForClient, if not nil, is called after a ClientHello is
 	// received from a client. It may return a non-nil Config in order to
 	// change the Config that will be used to handle this connection. If
@@ -665,6 +938,31 @@ type Config struct {
 	// verifiedChains and its contents should not be modified.
 	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
 
+	// VerifyCertificateChains, if not nil, replaces the building and
+	// verification of the peer certificate chain, which by default uses
+	// [x509.Certificate.Verify], for example to use a platform verifier or a
+	// different trust model. It receives the raw ASN.1 certificates provided by
+	// the peer, and the options the default verification would use, with the
+	// other certificates of the peer in Intermediates. It must return at least
+	// one chain starting with the peer's leaf certificate, which become
+	// ConnectionState.VerifiedChains, or an error aborting the handshake.
+	//
+	// It's called whenever the default verification would run. On TLS 1.3
+	// clients, it runs in a separate goroutine while the server's
+	// CertificateVerify message is read and its signature checked. On
+	// resumed connections, it's called again with the certificates of the
+	// original connection, without Intermediates set, in place of checking
+	// that the verified chains are still rooted in RootCAs or ClientCAs.
+	VerifyCertificateChains func(rawCerts [][]byte, opts x509.VerifyOptions) ([][]*x509.Certificate, error)
+
+	// Verifiers, if not empty, are peer verification policies consulted in
+	// order, on the same connections as VerifyPeerCertificate and just before
+	// it. Any of them rejecting the peer aborts the handshake. A peer whose
+	// chain fails to verify is rejected unless one of them accepts it, so
+	// that ConnectionState.VerifiedChains is then empty, and the checks that
+	// need a verified chain, such as revocation, are skipped. See [Verifier].
+	Verifiers []Verifier
+
 	// VerifyConnection, if not nil, is called after normal certificate
 	// verification and after VerifyPeerCertificate by either a TLS client
 	// or server. If it returns a non-nil error, the handshake is aborted
@@ -676,11 +974,152 @@ type Config struct {
 	// settings.
 	VerifyConnection func(ConnectionState) error
 
+	// CTLogs are the Certificate Transparency logs trusted by clients to
+	// sign the SCTs of server certificates, which are recorded in
+	// ConnectionState.VerifiedSCTs. If empty, SCTs are not verified.
+	CTLogs []CTLog
+
+	// RequireCT makes clients reject server certificates without enough
+	// SCTs from CTLogs, following the policy of web browsers: SCTs from two
+	// logs if any of them is delivered in the handshake or the stapled OCSP
+	// response, or else SCTs embedded in the certificate from two logs if it
+	// is valid for up to 180 days, and three logs otherwise. The logs must
+	// be run by at least two operators. All servers are rejected if CTLogs
+	// is empty. It's checked before VerifyPeerCertificate, and not on
+	// resumed connections.
+	RequireCT bool
+
+	// RevocationChecker, if not nil, checks the revocation status of the
+	// certificates of the verified chain of the peer, except for its root.
+	// It's only used if the chain is verified, that is on clients unless
+	// InsecureSkipVerify is set, and on servers if ClientAuth is
+	// VerifyClientCertIfGiven or RequireAndVerifyClientCert, and not on
+	// resumed connections. Revoked certificates are rejected before
+	// VerifyPeerCertificate is called. See [CRLChecker].
+	RevocationChecker RevocationChecker
+
+	// RevocationMode decides whether certificates whose revocation status
+	// RevocationChecker can't determine are rejected. The default is
+	// RevocationSoftFail, which accepts them.
+	RevocationMode RevocationMode
+
+	// TLSARecords, if not empty, are the TLSA records of the server, which
+	// clients authenticate it with as specified in RFC 7671, in addition to
+	// or instead of RootCAs depending on their certificate usage, see
+	// [TLSAUsageDANEEE] and the other usages. They must have been obtained
+	// from a DNSSEC-validating resolver. Records with unknown parameters are
+	// ignored, and if none remains the server is verified as if TLSARecords
+	// were empty. TLSARecords is ignored if InsecureSkipVerify is set, and
+	// when an Encrypted Client Hello is rejected.
+	TLSARecords []TLSARecord
+
+	// PinnedPeerSPKIHashes, if not empty, lists the SHA-256 hashes of
+	// SubjectPublicKeyInfos, see [SPKIHash], one of which must be in the
+	// certificate chain of the peer: in a chain its certificate was verified
+	// with, including its root, or if it wasn't verified, such as with
+	// InsecureSkipVerify, that of its leaf certificate. Peers failing it
+	// are rejected with a *[PinError] after their chain is verified, and
+	// before VerifyPeerCertificate is called. It's not checked on resumed
+	// connections, nor for peers that sent no certificate or a raw public key.
+	//
+	// [Dialer.PinnedPeerSPKIHashes] sets pins for the connections of a Dialer.
+	PinnedPeerSPKIHashes [][]byte
+
+	// PinReportOnly makes peers failing PinnedPeerSPKIHashes only be reported
+	// to OnPinFailure instead of rejected, so that pins can be rolled out
+	// without breaking connections.
+	PinReportOnly bool
+
+	// OnPinFailure, if not nil, is called with the error of each peer
+	// failing PinnedPeerSPKIHashes, whether or not PinReportOnly is set.
+	OnPinFailure func(err *PinError)
+
+	// AIAFetcher, if not nil, downloads the intermediate certificates missing
+	// from the chain of the peer when it can't otherwise be verified. See
+	// [AIAFetcher].
+	AIAFetcher *AIAFetcher
+
+	// VerifyRawPublicKey, if not nil, is called to authenticate a peer that
+	// sent a raw public key instead of a certificate chain, with its encoded
+	// SubjectPublicKeyInfo and its parsed key. If it returns a non-nil error,
+	// the handshake is aborted and that error results, wrapped in a
+	// CertificateVerificationError.
+	//
+	// Raw public keys can't be verified against RootCAs or ClientCAs, so this
+	// callback is required on clients unless InsecureSkipVerify is set, and on
+	// servers if ClientAuth is VerifyClientCertIfGiven or
+	// RequireAndVerifyClientCert. It's called before VerifyConnection, and
+	// isn't called on resumed connections.
+	VerifyRawPublicKey func(rawPublicKey []byte, publicKey crypto.PublicKey) error
+
 	// RootCAs defines the set of root certificate authorities
 	// that clients use when verifying server certificates.
 	// If RootCAs is nil, TLS uses the host's root CA set.
 	RootCAs *x509.CertPool
 
+	// SendCertificateAuthorities causes clients to list the subjects of
+	// RootCAs in the certificate_authorities extension of TLS 1.3
+	// ClientHellos (RFC 8446, Section 4.2.4), so that servers with several
+	// certificates can pick one the client trusts, see
+	// [ClientHelloInfo.AcceptableCAs]. It's ignored if RootCAs is nil.
+	//
+	// Servers always list the subjects of ClientCAs in their requests for
+	// client certificates.
+	SendCertificateAuthorities bool
+
+	// CertificateSignatureSchemes, if not nil, lists the signature
+	// algorithms accepted in the certificate chains of the peer, separately
+	// from the algorithms of the handshake signatures. It's advertised in the
+	// signature_algorithms_cert extension (RFC 8446, Section 4.2.3), and
+	// verified chains with another algorithm, other than for the signature
+	// of the root, are discarded. If none remains, verification fails.
+	//
+	// If nil, the extension lists all the supported algorithms, including
+	// PKCS #1 v1.5 and SHA-1 ones which crypto/x509 may reject later, and
+	// those of SignatureSchemes.
+	CertificateSignatureSchemes []SignatureScheme
+
+	// SignatureSchemes, if not nil, lists the signature schemes advertised
+	// in the signature_algorithms extension, and accepted for the handshake
+	// signatures of the peer, in preference order. Schemes disabled for the
+	// protocol version, such as SHA-1 ones, are ignored.
+	//
+	// If nil, a default list is used, which doesn't include Ed448, nor
+	// SM2WithSM3 unless ShangMiCipherSuites is set.
+	SignatureSchemes []SignatureScheme
+
+	// ShangMiCipherSuites enables the TLS 1.3 cipher suites of RFC 8998,
+	// TLS_SM4_GCM_SM3 and TLS_SM4_CCM_SM3, with the CurveSM2 key exchange and,
+	// unless SignatureSchemes is set, SM2WithSM3 signatures, for
+	// interoperability with servers and clients following the Chinese
+	// cryptographic standards. They are preferred after the other cipher
+	// suites, and are not supported with QUIC.
+	ShangMiCipherSuites bool
+
+	// TLCP enables TLCP, the protocol of GB/T 38636-2020 that many services
+	// in mainland China require, with the ECC_SM4_CBC_SM3 and ECC_SM4_GCM_SM3
+	// cipher suites. Clients then only speak TLCP, regardless of MinVersion
+	// and MaxVersion, while servers accept TLCP clients in addition to the
+	// TLS versions they support. Servers sign with their SM2 certificate from
+	// Certificates or GetCertificate, and need TLCPEncryptionCertificate.
+	//
+	// Client certificates and session resumption aren't supported in TLCP.
+	TLCP bool
+
+	// TLCPEncryptionCertificate is the SM2 encryption certificate a TLCP
+	// server sends after its signing certificate, whose private key must
+	// implement crypto.Decrypter to decrypt the pre-master secret.
+	TLCPEncryptionCertificate *Certificate
+
+	// RequirePSS disables PKCS #1 v1.5 handshake signatures, which TLS 1.3
+	// already forbids, in TLS 1.2 and earlier too. They are neither
+	// advertised nor accepted from the peer, and RSA keys only sign with
+	// RSA-PSS. Since RSA-PSS is unavailable before TLS 1.2, TLS 1.0 and 1.1
+	// connections then fail if they'd need an RSA signature.
+	//
+	// Certificate signatures are unaffected, see CertificateSignatureSchemes.
+	RequirePSS bool
+
 	// NextProtos is a list of supported application level protocols, in
 	// order of preference. If both peers support ALPN, the selected
 	// protocol will be one from this list, and the connection will fail
@@ -695,6 +1134,26 @@ type Config struct {
 	// an IP address.
 	ServerName string
 
+	// SendServerName, if not empty, is sent by clients in the server_name
+	// extension instead of ServerName, which is still the name verified in
+	// the certificate of the server, such as for domain fronting.
+	SendServerName string
+
+	// OmitServerName makes clients send no server_name extension, while
+	// still verifying ServerName in the certificate of the server.
+	OmitServerName bool
+
+	// SessionIDGenerator, if not nil, is called by clients to fill the
+	// legacy session ID of the ClientHello, such as with a MAC of the rest
+	// of the message for protocols authenticating there, like ShadowTLS.
+	// It's passed the marshaled ClientHello handshake message, with a zero
+	// session ID, and the session ID of 32 bytes to overwrite.
+	//
+	// Sessions and external PSKs are not offered when SessionIDGenerator is
+	// set, as their binders cover the session ID, and it can't be used
+	// with ECH or QUIC.
+	SessionIDGenerator func(clientHello, sessionID []byte) error
+
 	// ClientAuth determines the server's policy for
 	// TLS Client Authentication. The default is NoClientCert.
 	ClientAuth ClientAuthType
@@ -720,9 +1179,34 @@ type Config struct {
 	// suites were removed from the default list, but can be re-added with the
 	// GODEBUG setting tlsrsakex=1. In Go 1.23 3DES cipher suites were removed
 	// from the default list, but can be re-added with the GODEBUG setting
-	// tls3des=1.
+	// tls3des=1. Either can also be enabled per cipher suite with
+	// InsecureCipherSuites.
+	//
+	// The ARIA, Camellia, DHE and AES-CCM cipher suites, such as
+	// TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256,
+	// TLS_DHE_RSA_WITH_AES_128_GCM_SHA256 and TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
+	// are never in the default list, and when listed are preferred after all
+	// the other cipher suites. The exception to TLS 1.3 cipher suites not
+	// being configurable is that TLS_AES_128_CCM_SHA256,
+	// TLS_AES_128_CCM_8_SHA256 and the cipher suites registered with
+	// [RegisterCipherSuiteTLS13] are enabled the same way, except with QUIC.
+	//
+	// The PSK and ECDHE_PSK cipher suites, used with ExternalPSKs, are never
+	// in the default list either.
 	CipherSuites []uint16
 
+	// InsecureCipherSuites is a list of cipher suites returned by
+	// [InsecureCipherSuites], such as TLS_RSA_WITH_3DES_EDE_CBC_SHA and
+	// TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256, which are enabled in addition to
+	// CipherSuites or, if that is nil, to the default list. Other cipher
+	// suites are ignored. Enabled insecure cipher suites are preferred after
+	// the secure ones, and [ConnectionState.InsecureCipherSuite] reports
+	// when one is negotiated.
+	//
+	// This is only meant for talking to legacy peers which support nothing
+	// better.
+	InsecureCipherSuites []uint16
+
 	// PreferServerCipherSuites is a legacy field and has no effect.
 	//
 	// It used to control whether the server would follow the client's or the
@@ -733,6 +1217,27 @@ type Config struct {
 	// Deprecated: PreferServerCipherSuites is ignored.
 	PreferServerCipherSuites bool
 
+	// AESGCMPreference overrides the detection of AES-GCM hardware support
+	// that decides, when ordering cipher suites of all versions, whether
+	// AES-GCM or ChaCha20-Poly1305 ones come first. The default is
+	// [AESGCMPreferenceAuto].
+	AESGCMPreference AESGCMPreference
+
+	// GetCipherSuitePreference, if not nil, is called by servers to order
+	// the cipher suites of all versions for each client, for example by its
+	// network. The returned suites are preferred in that order over the
+	// others, which follow in the default order. They can't enable suites,
+	// as those not enabled by the Config are ignored.
+	//
+	// If GetCipherSuitePreference returns an error, the handshake is aborted
+	// with that error.
+	GetCipherSuitePreference func(*ClientHelloInfo) ([]uint16, error)
+
+	// AEADEngine, if not nil, provides the implementations of the AES-GCM
+	// and ChaCha20-Poly1305 AEADs protecting records, in place of the
+	// built-in ones. KernelTX and KernelRX are ignored if it is set.
+	AEADEngine AEADEngine
+
 	// SessionTicketsDisabled may be set to true to disable session ticket and
 	// PSK (resumption) support. Note that on clients, session ticket support is
 	// also disabled if ClientSessionCache is nil.
@@ -748,6 +1253,50 @@ type Config struct {
 	// terminating connections for the same host, use SetSessionTicketKeys.
 	SessionTicketKey [32]byte
 
+	// TicketKeyStore, if not nil, provides the session ticket keys used by
+	// servers, taking precedence over SessionTicketKey and
+	// SetSessionTicketKeys. It allows a fleet of servers to share rotating
+	// keys, see [TicketKeyStore].
+	TicketKeyStore TicketKeyStore
+
+	// HandshakeLimiter, if not nil, bounds the number of server handshakes
+	// in progress at the same time. See [HandshakeLimiter].
+	HandshakeLimiter *HandshakeLimiter
+
+	// OCSPStapler, if not nil, provides the OCSP responses stapled to the
+	// certificates it manages, in place of their OCSPStaple field. See
+	// [OCSPStapler].
+	OCSPStapler *OCSPStapler
+
+	// DecoyMirror, if not nil, shapes the TLS 1.3 handshakes of servers
+	// after those of a decoy site. See [DecoyMirror].
+	DecoyMirror *DecoyMirror
+
+	// Fallback, if not nil, is called by servers whose handshake fails
+	// before they sent anything, such as when GetConfigForClient rejects the
+	// fingerprint of an active probe, or when the client doesn't speak TLS.
+	// Instead of an alert, the connection is handed to Fallback along with
+	// the bytes received so far, to be served by something else, such as
+	// with [FallbackProxy] the website the server poses as. The handshake
+	// returns ErrFallback once Fallback does. Fallback is called once the
+	// handshake released the Conn, with the values of its context but not
+	// its deadline or cancellation. It's not used with QUIC.
+	Fallback func(ctx context.Context, conn net.Conn, received []byte) error
+
+	// CovertAuth, if not nil, embeds authenticated data in the ClientHello
+	// of clients, which servers verify. See [CovertAuth].
+	CovertAuth *CovertAuth
+
+	// ClientHelloReplayFilter, if not nil, is consulted by servers to flag
+	// the ClientHellos they already received in [ClientHelloInfo.Replayed],
+	// see [ClientHelloReplayFilter].
+	ClientHelloReplayFilter ClientHelloReplayFilter
+
+	// HandshakeScript, if not nil, shapes the ClientHello of clients and
+	// the first records of application data after a shared script. See
+	// [HandshakeScript].
+	HandshakeScript *HandshakeScript
+
 	// ClientSessionCache is a cache of ClientSessionState entries for TLS
 	// session resumption. It is only used by clients.
 	ClientSessionCache ClientSessionCache
@@ -781,6 +1330,246 @@ type Config struct {
 	// depending on the protocol version.
 	WrapSession func(ConnectionState, *SessionState) ([]byte, error)
 
+	// ApproveResumption is called on the server before resuming a session,
+	// once the session passed the checks crypto/tls makes itself, such as the
+	// ones against the current ClientAuth requirements. If it returns false,
+	// the session is ignored and, unless the client offered another one, a
+	// full handshake is performed instead.
+	//
+	// Properties that the session does not expose, like the negotiated ALPN
+	// protocol, can be recorded in [SessionState.Extra] by [WrapSession].
+	ApproveResumption func(*SessionState, *ClientHelloInfo) bool
+
+	// SessionEvent, if not nil, is called when a session ticket is issued
+	// or received, and when a handshake resumes a session or declines to,
+	// for example to collect resumption metrics. It is called synchronously
+	// and should return quickly. See [SessionEventKind].
+	SessionEvent func(SessionEvent)
+
+	// SingleUseTickets causes servers to issue session tickets that can be
+	// used for resumption only once. The sessions are stored in
+	// ServerSessionCache under random ticket identities, and removed from it
+	// when a client presents the ticket, so they can't be replayed and their
+	// secrets are discarded after use.
+	//
+	// If ServerSessionCache is nil, no session tickets are issued. If
+	// WrapSession or UnwrapSession are set, they are used instead, and are
+	// responsible for enforcing single use.
+	SingleUseTickets bool
+
+	// ServerSessionCache stores the sessions of single-use session tickets.
+	// It is only used by servers, if SingleUseTickets is true.
+	ServerSessionCache ServerSessionCache
+
+	// SessionTicketLifetime is the lifetime of the session tickets issued by
+	// a server, after which it doesn't resume them. It is advertised in TLS
+	// 1.3 tickets, and in TLS 1.2 tickets as the lifetime hint if set. TLS 1.2
+	// tickets issued on resumption expire with the original session. If zero,
+	// or longer than the seven days permitted by RFC 8446, seven days is used.
+	SessionTicketLifetime time.Duration
+
+	// TicketAgeObfuscationDisabled makes servers send a zero ticket_age_add
+	// in TLS 1.3 session tickets, instead of a random value. Clients then send
+	// the age of the ticket in plaintext when resuming it, which may let an
+	// observer link the connections that use the same ticket.
+	TicketAgeObfuscationDisabled bool
+
+	// SessionTicketNonce selects how servers generate the ticket_nonce of TLS
+	// 1.3 session tickets. The default is TicketNonceCounter.
+	SessionTicketNonce TicketNonceScheme
+
+	// SessionTicketCount is the number of TLS 1.3 session tickets servers
+	// send after each handshake. Clients that open several connections in
+	// parallel can use a ticket for each. If zero, one ticket is sent. If
+	// negative, servers don't issue session tickets, in any TLS version,
+	// but still resume the sessions of previously issued ones.
+	SessionTicketCount int
+
+	// ResumptionTicketsDisabled, if true, stops servers from issuing new
+	// session tickets on connections that resumed a session, so clients
+	// keep using the tickets they have.
+	ResumptionTicketsDisabled bool
+
+	// EarlyData enables TLS 1.3 0-RTT application data on TCP connections.
+	//
+	// On the client, data queued with [Conn.WriteEarlyData] is sent right
+	// after the ClientHello if the session being resumed permits early data
+	// and the data fits within the limit the server advertised for it.
+	// [ConnectionState.EarlyDataAccepted] reports whether the server
+	// accepted it.
+	//
+	// On the server, session tickets permit early data if EarlyDataAntiReplay
+	// is also set, and 0-RTT data is accepted if EarlyDataAntiReplay allows
+	// it. Accepted early data is returned by [Conn.ReadEarlyData], not by
+	// [Conn.Read].
+	//
+	// Early data is not protected against replay, and is only forward secret
+	// with respect to the resumption secret. It should only carry requests
+	// that are safe to process more than once.
+	//
+	// QUIC connections ignore this field, see [QUICSessionTicketOptions].
+	EarlyData bool
+
+	// EarlyDataAntiReplay is consulted by servers before accepting 0-RTT
+	// data, see [NewEarlyDataAntiReplay]. If nil, servers never accept early
+	// data, even if EarlyData is set.
+	EarlyDataAntiReplay EarlyDataAntiReplay
+
+	// MaxEarlyData is the max_early_data_size servers advertise in the
+	// session tickets that permit early data, and so the most 0-RTT data
+	// they accept when resuming one of them. It is recorded in each ticket,
+	// so changing it only affects tickets issued afterwards, except for the
+	// tickets which don't record it, such as those of older versions, which
+	// use the current value. If zero, 16384 bytes is used.
+	MaxEarlyData uint32
+
+	// FalseStart lets TLS 1.2 clients send application data right after
+	// their Finished message, without waiting a round trip for the server's,
+	// as described in RFC 7918. It is only used in full handshakes that
+	// negotiate a forward-secret ECDHE key exchange, an AEAD cipher suite and
+	// an application protocol with ALPN. The rest of the handshake completes
+	// during the first [Conn.Read], which returns its error if the server's
+	// Finished message is invalid.
+	//
+	// With False Start, the data is sent before the server confirmed the
+	// transcript of the handshake, so it relies on the client's own
+	// cipher suite and key exchange policy for protection against
+	// downgrades.
+	FalseStart bool
+
+	// EncryptThenMAC enables the encrypt_then_mac extension of RFC 7366 in
+	// TLS 1.0–1.2. Records of CBC cipher suites then carry a MAC of their
+	// ciphertext, which is checked before decrypting them, instead of a MAC
+	// of their plaintext, closing the padding oracles of CBC like Lucky13.
+	// Clients offer it, and servers use it with clients offering it when
+	// they negotiate a CBC cipher suite.
+	EncryptThenMAC bool
+
+	// MaxCBCRecords, if positive, is the maximum number of records sent or
+	// received with each key of a TLS 1.0–1.2 CBC cipher suite without
+	// Encrypt-then-MAC, after which the connection fails. Padding oracles
+	// such as Lucky13, and birthday attacks on 64-bit block ciphers such as
+	// Sweet32 on 3DES, need many records: an application which has to
+	// enable these cipher suites can bound what a connection exposes. The
+	// count starts over when renegotiation changes the keys.
+	//
+	// MaxCBCRecords is a total over the lifetime of each key, not a rate: a
+	// connection reaches it however slowly the records are sent, and must
+	// then be replaced by a new one.
+	MaxCBCRecords int
+
+	// RecordSizeLimit, if not zero, is the maximum size of the plaintext of
+	// the records the peer may send, from 64 to 16384 bytes, for constrained
+	// endpoints. It's advertised with the record_size_limit extension of RFC
+	// 8449, which clients only send if it's set, and which servers always
+	// answer. The records sent are limited by the peer's extension either
+	// way. It's not used with QUIC.
+	RecordSizeLimit int
+
+	// PaddingPolicy, if not nil, pads the TLS 1.3 records of application
+	// data sent, within the size limit of the records. See
+	// [FixedPadding], [RandomPadding] and [LengthDistributionPadding].
+	// Records aren't padded once kernel TLS sends them.
+	PaddingPolicy PaddingPolicy
+
+	// RecordPacing, if not nil, spaces out the records of application data
+	// sent in time, except when kernel TLS sends them. See [RecordPacing].
+	RecordPacing *RecordPacing
+
+	// TrafficMorpher, if not nil, chooses the lengths of the TLS 1.3 records
+	// of application data sent after the handshake, and the dummy records
+	// among them. See [TrafficMorpher].
+	TrafficMorpher TrafficMorpher
+
+	// ClientHelloFragmentation, if not nil, splits the first ClientHello of
+	// clients across TCP segments. See [ClientHelloFragmentation].
+	ClientHelloFragmentation *ClientHelloFragmentation
+
+	// SplitClientHelloAtServerName makes clients send the ClientHello in
+	// three TLS records, the second of which holds the server_name
+	// extension, against filters reassembling TCP but not TLS records.
+	SplitClientHelloAtServerName bool
+
+	// HandshakeTransform, if not nil, is called with each handshake message
+	// about to be sent, marshaled with its header, and returns the bytes to
+	// send in its place, for byte-level changes that match the quirks of
+	// other implementations. The transcript is that of the bytes sent, so
+	// the peer accepts them, but the handshake goes on with the messages as
+	// they were built: fields it relies on, such as the random, session ID
+	// or key shares, must be left alone. It's not called for the ClientHellos
+	// with PSK binders or ECH, and the ServerHellos accepting ECH, which
+	// carry MACs of their contents. msg is not used after the call, so it
+	// may be modified in place and returned.
+	HandshakeTransform func(msg []byte) ([]byte, error)
+
+	// AcceptDelegatedCredentials lets clients accept TLS 1.3 servers signing
+	// the handshake with a delegated credential, as specified in RFC 9345,
+	// instead of the key of their certificate. Credentials are only accepted
+	// if the certificate has the DelegationUsage extension, and if they
+	// expire within seven days. See [DelegatedCredential].
+	AcceptDelegatedCredentials bool
+
+	// ServerCertificateTypes are the types of credentials, in order of
+	// preference, that TLS 1.3 servers may authenticate with, as negotiated
+	// by the server_certificate_type extension of RFC 7250. Clients offer
+	// them, and servers select the first one the client offered. If
+	// CertificateTypeRawPublicKey is selected, the server sends the public
+	// key of its certificate instead of its chain, and the client verifies it
+	// with VerifyRawPublicKey. If empty, only X.509 is used.
+	ServerCertificateTypes []CertificateType
+
+	// ClientCertificateTypes are the types of credentials, in order of
+	// preference, that TLS 1.3 clients may authenticate with, as negotiated
+	// by the client_certificate_type extension of RFC 7250. It works like
+	// ServerCertificateTypes, with roles reversed, and servers verify raw
+	// public keys with VerifyRawPublicKey. If empty, only X.509 is used.
+	ClientCertificateTypes []CertificateType
+
+	// ExternalPSKs are the TLS 1.3 pre-shared keys provisioned out of band.
+	//
+	// Clients offer all of them, imported for each hash function of the
+	// offered TLS 1.3 cipher suites, when they are not resuming a session
+	// and not using Encrypted Client Hello. Servers look up the PSKs offered
+	// by clients here, unless GetExternalPSK is set.
+	//
+	// Connections using an external PSK are authenticated by it, and don't
+	// exchange certificates. See [ConnectionState.ExternalPSKIdentity].
+	//
+	// The entries without a Context are also used by the TLS 1.2 PSK cipher
+	// suites, which must be enabled in CipherSuites. Clients use the first
+	// of them, unless GetClientPSK is set.
+	ExternalPSKs []ExternalPSK
+
+	// ExternalPSKModes are the key exchange modes allowed with ExternalPSKs,
+	// in order of preference. If empty, only PSKModeDHE is allowed.
+	//
+	// With PSKModeKE, the connection is not forward secret: compromise of the
+	// PSK exposes all the traffic protected by it. Servers preferring
+	// PSKModeKE don't need a key share from the client, and don't send a
+	// HelloRetryRequest for one unless they accept none of its PSKs. A key
+	// share the client did send is discarded if PSKModeKE is negotiated.
+	ExternalPSKModes []PSKMode
+
+	// GetExternalPSK, if not nil, is called by servers for each external
+	// PSK identity and context offered by the client, in order, that was
+	// imported for the hash function of the negotiated cipher suite. It
+	// selects the external PSK to use, or returns nil to ignore the identity.
+	// If GetExternalPSK returns an error, the handshake is aborted.
+	//
+	// With TLS 1.2 PSK cipher suites, GetExternalPSK is called once, with the
+	// PSK identity sent by the client and a nil context.
+	GetExternalPSK func(identity, context []byte) (*ExternalPSK, error)
+
+	// PSKIdentityHint is sent by servers that negotiate a TLS 1.2 PSK cipher
+	// suite, to help clients select a PSK. See RFC 4279, Section 5.2.
+	PSKIdentityHint []byte
+
+	// GetClientPSK, if not nil, is called by clients that negotiate a TLS 1.2
+	// PSK cipher suite, with the identity hint sent by the server, if any. It
+	// returns the PSK to use instead of the first suitable ExternalPSKs entry.
+	// If GetClientPSK returns nil or an error, the handshake is aborted.
+	GetClientPSK func(hint []byte) (*ExternalPSK, error)
+
 	// MinVersion contains the minimum TLS version that is acceptable.
 	//
 	// By default, TLS 1.2 is currently used as the minimum. TLS 1.0 is the
@@ -810,14 +1599,119 @@ type Config struct {
 	// [SecP256r1MLKEM768] hybrid post-quantum key exchanges, too. To disable
 	// them, set CurvePreferences explicitly or use either the
 	// GODEBUG=tlsmlkem=0 or the GODEBUG=tlssecpmlkem=0 environment variable.
+	//
+	// The FFDHE groups, such as [FFDHE2048], are only used by the DHE cipher
+	// suites. If none is listed, all of them are enabled.
 	CurvePreferences []CurveID
 
+	// MinRSAKeySize is the minimum size in bits of the RSA keys of the
+	// certificates, raw public keys and delegated credentials accepted from
+	// the peer. If zero, there is no minimum.
+	MinRSAKeySize int
+
+	// MinECStrength is the minimum security level in bits of the elliptic
+	// curve keys of the certificates, raw public keys and delegated
+	// credentials accepted from the peer, and of the key exchanges used,
+	// such as 128 for P-256, X25519 and Ed25519, or 192 for P-384. Hybrid
+	// post-quantum key exchanges count the strength of their elliptic curve
+	// part. If zero, there is no minimum.
+	MinECStrength int
+
+	// MinDHGroupSize is the minimum size in bits of the prime of the groups
+	// used by the DHE cipher suites, including the FFDHE groups enabled by
+	// CurvePreferences. If zero, 2048 bits is the minimum. It may be lowered
+	// to connect to legacy servers using smaller groups.
+	MinDHGroupSize int
+
+	// SecurityLevel, from 0 to 5, restricts the protocol versions, cipher
+	// suites, key exchanges, signature algorithms and peer key sizes to those
+	// meeting a security level, after the levels of OpenSSL 3:
+	//
+	//   - 1: 80 bits of security, RSA keys and DHE primes of at least 1024
+	//     bits, and TLS 1.2 or later.
+	//   - 2: 112 bits of security and RSA keys and DHE primes of at least
+	//     2048 bits, which disables RC4.
+	//   - 3: 128 bits of security, RSA keys and DHE primes of at least 3072
+	//     bits, and forward secrecy, which disables 3DES and the RSA key
+	//     exchange.
+	//   - 4: 192 bits of security and RSA keys and DHE primes of at least
+	//     7680 bits, which disables AES-128, SHA-1 MACs, P-256, X25519, and
+	//     SHA-256 and Ed25519 signatures.
+	//   - 5: 256 bits of security and RSA keys and DHE primes of at least
+	//     15360 bits, which only leaves AES-256, ChaCha20, P-521 and SHA-512
+	//     signatures.
+	//
+	// Hybrid post-quantum key exchanges count the strength of their elliptic
+	// curve part. The level adds to the other settings of Config, which can
+	// only restrict it further, and doesn't apply to the TLCP cipher suites.
+	// The default, 0, imposes nothing, and levels above 5 are treated as 5.
+	SecurityLevel int
+
+	// FIPSMode restricts the protocol versions, cipher suites, key
+	// exchanges, signature algorithms and peer certificate keys to those
+	// approved for FIPS 140-3, as described in the package documentation,
+	// and requires the extended master secret in TLS 1.2. It disables TLCP
+	// and the ShangMi cipher suites.
+	//
+	// It only restricts the negotiated algorithms. The cryptography, key
+	// derivation included, isn't routed through a validated module, so
+	// setting it doesn't make connections FIPS 140-3 compliant.
+	// [ConnectionState.FIPSApproved] reports whether a connection only used
+	// approved algorithms, whether FIPSMode is set or not.
+	FIPSMode bool
+
+	// CertificateCompressors are the algorithms, in order of preference,
+	// used to compress the certificate chain sent to the peer and to
+	// decompress the one it sends, as specified in RFC 8879 for TLS 1.3.
+	// Clients and servers offer to decompress with all of them, and compress
+	// with the first one the peer offered. If empty, certificates are never
+	// compressed.
+	CertificateCompressors []CertificateCompressor
+
 	// DynamicRecordSizingDisabled disables adaptive sizing of TLS records.
 	// When true, the largest possible TLS record size is always used. When
 	// false, the size of TLS records may be adjusted in an attempt to
 	// improve latency.
 	DynamicRecordSizingDisabled bool
 
+	// DynamicRecordSizingThreshold is the number of bytes a connection
+	// sends, after the handshake, before dynamic record sizing switches to
+	// the largest record size. Until then, records start small enough to fit
+	// in a TCP segment and grow with each record. If zero, 128 KiB is used.
+	DynamicRecordSizingThreshold int
+
+	// DynamicRecordSizingIdleTimeout, if positive, makes dynamic record
+	// sizing start again from small records when a connection didn't send
+	// any record for that long.
+	DynamicRecordSizingIdleTimeout time.Duration
+
+	// WriteCoalescingWindow, if positive, lets [Conn.Write] return before
+	// its records are sent, so that the records of the writes made within
+	// that window are sent in a single write to the underlying connection.
+	// Buffered records are sent at the latest after the window, as soon as
+	// they fill a full sized record, and before any other record, such as
+	// the close_notify alert of [Conn.Close]. Write errors are then
+	// reported by later calls.
+	WriteCoalescingWindow time.Duration
+
+	// KernelTX, if true, hands the encryption of outgoing records over to
+	// the kernel (kTLS) once the handshake completes, so that [Conn.Write]
+	// sends plaintext to the socket. It only takes effect on Linux, for
+	// connections over a [net.TCPConn] using an AES-GCM or
+	// ChaCha20-Poly1305 cipher suite, and if the kernel supports it;
+	// otherwise records are encrypted in userspace as usual. TLS 1.2
+	// clients only use it if Renegotiation is RenegotiateNever, and TLS
+	// 1.3 key updates fail on kernels that can't change the keys.
+	KernelTX bool
+
+	// KernelRX, if true, hands the decryption of incoming records over to
+	// the kernel (kTLS) once the handshake completes, under the same
+	// conditions as KernelTX. Application data is then decrypted in the
+	// kernel, while alerts and post-handshake messages are still processed
+	// by [Conn.Read]. It is not used if the peer sent records right after
+	// the handshake and they were already read.
+	KernelRX bool
+
 	// Renegotiation controls what types of renegotiation are supported.
 	// The default, none, is correct for the vast majority of applications.
 	Renegotiation RenegotiationSupport
@@ -830,6 +1724,45 @@ type Config struct {
 	// used for debugging.
 	KeyLogWriter io.Writer
 
+	// KeyLogger, if not nil, receives the secrets of connections, along
+	// with KeyLogWriter. See [KeyLogger].
+	KeyLogger KeyLogger
+
+	// MetricsRecorder, if not nil, receives the outcome of each handshake.
+	// See [MetricsRecorder].
+	MetricsRecorder MetricsRecorder
+
+	// RecordObserver, if not nil, is called with each TLS record sent or
+	// received, except by QUIC or kernel TLS, while the half of the
+	// connection it belongs to is locked. See [RecordInfo].
+	RecordObserver func(RecordInfo)
+
+	// RecordObserverPlaintext makes RecordObserver receive the contents of
+	// the records as well. It exposes the data of the connections, and
+	// should only be used for debugging.
+	RecordObserverPlaintext bool
+
+	// AlertObserver, if not nil, is called with each TLS alert sent or
+	// received, including warnings and close_notify, before the connection
+	// fails with it. It's called while the half of the connection the alert
+	// belongs to is locked. QUIC connections report none, since QUIC carries
+	// their alerts.
+	AlertObserver func(AlertInfo)
+
+	// QlogWriter, if not nil, is called by Client and Server for each
+	// connection, which may use its LocalAddr and RemoteAddr, such as to
+	// name a file, but no other method. The connection then writes its
+	// handshakes, handshake messages, records and alerts to the returned
+	// writer, if not nil, as qlog events serialized as JSON text sequences,
+	// and closes it when closed itself, or when a write fails. QUIC
+	// connections don't use it.
+	QlogWriter func(*Conn) io.WriteCloser
+
+	// RetainHandshakeMessages makes connections keep the handshake messages
+	// they send and receive, for [Conn.HandshakeMessages]. It is meant for
+	// debugging interoperability issues.
+	RetainHandshakeMessages bool
+
 	// EncryptedClientHelloConfigList is a serialized ECHConfigList. If
 	// provided, clients will attempt to connect to servers using Encrypted
 	// Client Hello (ECH) using one of the provided ECHConfigs.
@@ -911,6 +1844,18 @@ type Config struct {
 	// autoSessionTicketKeys is like sessionTicketKeys but is owned by the
 	// auto-rotation logic. See Config.ticketKeys.
 	autoSessionTicketKeys []ticketKey
+	// ticketKeysSnapshot holds sessionTicketKeys and autoSessionTicketKeys
+	// as of their last change, so that handshakes can read them without
+	// locking mutex. It is stored with mutex held, and cleared whenever the
+	// keys change outside of Config.ticketKeys. It is not copied by Clone.
+	ticketKeysSnapshot atomic.Pointer[ticketKeySnapshot]
+}
+
+// ticketKeySnapshot is an immutable copy of the ticket key fields of a
+// Config, see Config.loadTicketKeys.
+type ticketKeySnapshot struct {
+	sessionTicketKeys     []ticketKey
+	autoSessionTicketKeys []ticketKey
 }
 
 // EncryptedClientHelloKey holds a private key that is associated
@@ -963,6 +1908,9 @@ type ticketKey struct {
 	hmacKey [16]byte
 	// created is the time at which this ticket key was created. See Config.ticketKeys.
 	created time.Time
+	// decryptOnly is set if the key comes from a TicketKeyStore but is not
+	// active, so it can't be used for new tickets.
+	decryptOnly bool
 }
 
 // ticketKeyFromBytes converts from the external representation of a session
@@ -981,9 +1929,44 @@ func (c *Config) ticketKeyFromBytes(b [32]byte) (key ticketKey) {
 }
 
 // maxSessionTicketLifetime is the maximum allowed lifetime of a TLS 1.3 session
-// ticket, and the lifetime we set for all tickets we send.
+// ticket, and the default lifetime of the tickets we send.
 const maxSessionTicketLifetime = 7 * 24 * time.Hour
 
+func (c *Config) sessionTicketLifetime() time.Duration {
+	if c.SessionTicketLifetime <= 0 || c.SessionTicketLifetime > maxSessionTicketLifetime {
+		return maxSessionTicketLifetime
+	}
+	return c.SessionTicketLifetime
+}
+
+func (c *Config) sessionTicketCount() int {
+	if c.SessionTicketCount == 0 {
+		return 1
+	}
+	if c.SessionTicketCount < 0 {
+		return 0
+	}
+	return c.SessionTicketCount
+}
+
+func (c *Config) dynamicRecordSizingThreshold() int64 {
+	if c.DynamicRecordSizingThreshold <= 0 {
+		return recordSizeBoostThreshold
+	}
+	return int64(c.DynamicRecordSizingThreshold)
+}
+
+func (c *Config) maxEarlyData() uint32 {
+	if c.MaxEarlyData == 0 {
+		return defaultMaxEarlyData
+	}
+	// Early data is accounted for in an int.
+	if c.MaxEarlyData > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return c.MaxEarlyData
+}
+
 // Clone returns a shallow clone of c or nil if c is nil. It is safe to clone a
 // [Config] that is being used concurrently by a TLS client or server.
 //
@@ -1004,31 +1987,116 @@ func (c *Config) Clone() *Config {
 		Time:                                c.Time,
 		Certificates:                        c.Certificates,
 		NameToCertificate:                   c.NameToCertificate,
+		RankCertificates:                    c.RankCertificates,
 		GetCertificate:                      c.GetCertificate,
+		GetCertificateForHello:              c.GetCertificateForHello,
 		GetClientCertificate:                c.GetClientCertificate,
+		PostHandshakeAuth:                   c.PostHandshakeAuth,
 		GetConfigForClient:                  c.GetConfigForClient,
 		GetEncryptedClientHelloKeys:         c.GetEncryptedClientHelloKeys,
 		VerifyPeerCertificate:               c.VerifyPeerCertificate,
+		VerifyCertificateChains:             c.VerifyCertificateChains,
+		Verifiers:                           c.Verifiers,
 		VerifyConnection:                    c.VerifyConnection,
+		CTLogs:                              c.CTLogs,
+		RequireCT:                           c.RequireCT,
+		RevocationChecker:                   c.RevocationChecker,
+		RevocationMode:                      c.RevocationMode,
+		AIAFetcher:                          c.AIAFetcher,
+		TLSARecords:                         c.TLSARecords,
+		PinnedPeerSPKIHashes:                c.PinnedPeerSPKIHashes,
+		PinReportOnly:                       c.PinReportOnly,
+		OnPinFailure:                        c.OnPinFailure,
+		VerifyRawPublicKey:                  c.VerifyRawPublicKey,
 		RootCAs:                             c.RootCAs,
+		SendCertificateAuthorities:          c.SendCertificateAuthorities,
+		CertificateSignatureSchemes:         c.CertificateSignatureSchemes,
+		SignatureSchemes:                    c.SignatureSchemes,
+		ShangMiCipherSuites:                 c.ShangMiCipherSuites,
+		TLCP:                                c.TLCP,
+		TLCPEncryptionCertificate:           c.TLCPEncryptionCertificate,
+		RequirePSS:                          c.RequirePSS,
 		NextProtos:                          c.NextProtos,
 		ServerName:                          c.ServerName,
+		SendServerName:                      c.SendServerName,
+		OmitServerName:                      c.OmitServerName,
+		SessionIDGenerator:                  c.SessionIDGenerator,
 		ClientAuth:                          c.ClientAuth,
 		ClientCAs:                           c.ClientCAs,
 		InsecureSkipVerify:                  c.InsecureSkipVerify,
 		CipherSuites:                        c.CipherSuites,
+		InsecureCipherSuites:                c.InsecureCipherSuites,
 		PreferServerCipherSuites:            c.PreferServerCipherSuites,
+		AESGCMPreference:                    c.AESGCMPreference,
+		GetCipherSuitePreference:            c.GetCipherSuitePreference,
+		AEADEngine:                          c.AEADEngine,
 		SessionTicketsDisabled:              c.SessionTicketsDisabled,
 		SessionTicketKey:                    c.SessionTicketKey,
+		TicketKeyStore:                      c.TicketKeyStore,
+		HandshakeLimiter:                    c.HandshakeLimiter,
+		OCSPStapler:                         c.OCSPStapler,
+		DecoyMirror:                         c.DecoyMirror,
+		Fallback:                            c.Fallback,
+		CovertAuth:                          c.CovertAuth,
+		ClientHelloReplayFilter:             c.ClientHelloReplayFilter,
+		HandshakeScript:                     c.HandshakeScript,
 		ClientSessionCache:                  c.ClientSessionCache,
 		UnwrapSession:                       c.UnwrapSession,
 		WrapSession:                         c.WrapSession,
+		ApproveResumption:                   c.ApproveResumption,
+		SessionEvent:                        c.SessionEvent,
+		SingleUseTickets:                    c.SingleUseTickets,
+		ServerSessionCache:                  c.ServerSessionCache,
+		SessionTicketLifetime:               c.SessionTicketLifetime,
+		TicketAgeObfuscationDisabled:        c.TicketAgeObfuscationDisabled,
+		SessionTicketNonce:                  c.SessionTicketNonce,
+		SessionTicketCount:                  c.SessionTicketCount,
+		ResumptionTicketsDisabled:           c.ResumptionTicketsDisabled,
+		EarlyData:                           c.EarlyData,
+		EarlyDataAntiReplay:                 c.EarlyDataAntiReplay,
+		MaxEarlyData:                        c.MaxEarlyData,
+		FalseStart:                          c.FalseStart,
+		EncryptThenMAC:                      c.EncryptThenMAC,
+		MaxCBCRecords:                       c.MaxCBCRecords,
+		RecordSizeLimit:                     c.RecordSizeLimit,
+		PaddingPolicy:                       c.PaddingPolicy,
+		RecordPacing:                        c.RecordPacing,
+		TrafficMorpher:                      c.TrafficMorpher,
+		ClientHelloFragmentation:            c.ClientHelloFragmentation,
+		SplitClientHelloAtServerName:        c.SplitClientHelloAtServerName,
+		HandshakeTransform:                  c.HandshakeTransform,
+		AcceptDelegatedCredentials:          c.AcceptDelegatedCredentials,
+		ServerCertificateTypes:              c.ServerCertificateTypes,
+		ClientCertificateTypes:              c.ClientCertificateTypes,
+		ExternalPSKs:                        c.ExternalPSKs,
+		ExternalPSKModes:                    c.ExternalPSKModes,
+		GetExternalPSK:                      c.GetExternalPSK,
+		PSKIdentityHint:                     c.PSKIdentityHint,
+		GetClientPSK:                        c.GetClientPSK,
 		MinVersion:                          c.MinVersion,
 		MaxVersion:                          c.MaxVersion,
 		CurvePreferences:                    c.CurvePreferences,
+		MinRSAKeySize:                       c.MinRSAKeySize,
+		MinECStrength:                       c.MinECStrength,
+		MinDHGroupSize:                      c.MinDHGroupSize,
+		SecurityLevel:                       c.SecurityLevel,
+		FIPSMode:                            c.FIPSMode,
+		CertificateCompressors:              c.CertificateCompressors,
 		DynamicRecordSizingDisabled:         c.DynamicRecordSizingDisabled,
+		DynamicRecordSizingThreshold:        c.DynamicRecordSizingThreshold,
+		DynamicRecordSizingIdleTimeout:      c.DynamicRecordSizingIdleTimeout,
+		WriteCoalescingWindow:               c.WriteCoalescingWindow,
+		KernelTX:                            c.KernelTX,
+		KernelRX:                            c.KernelRX,
 		Renegotiation:                       c.Renegotiation,
 		KeyLogWriter:                        c.KeyLogWriter,
+		KeyLogger:                           c.KeyLogger,
+		MetricsRecorder:                     c.MetricsRecorder,
+		RecordObserver:                      c.RecordObserver,
+		RecordObserverPlaintext:             c.RecordObserverPlaintext,
+		AlertObserver:                       c.AlertObserver,
+		QlogWriter:                          c.QlogWriter,
+		RetainHandshakeMessages:             c.RetainHandshakeMessages,
 		EncryptedClientHelloConfigList:      c.EncryptedClientHelloConfigList,
 		EncryptedClientHelloRejectionVerify: c.EncryptedClientHelloRejectionVerify,
 		EncryptedClientHelloKeys:            c.EncryptedClientHelloKeys,
@@ -1071,52 +2139,49 @@ func (c *Config) initLegacySessionTicketKeyRLocked() {
 }
 
 // ticketKeys returns the ticketKeys for this connection.
-// If configForClient has explicitly set keys, those will
-// be returned. Otherwise, the keys on c will be used and
+// If configForClient has a TicketKeyStore or explicitly set keys,
+// those will be returned. Otherwise, the keys on c will be used and
 // may be rotated if auto-managed.
 // During rotation, any expired session ticket keys are deleted from
 // c.sessionTicketKeys. If the session ticket key that is currently
 // encrypting tickets (ie. the first ticketKey in c.sessionTicketKeys)
 // is not fresh, then a new session ticket key will be
 // created and prepended to c.sessionTicketKeys.
-func (c *Config) ticketKeys(configForClient *Config) []ticketKey {
+func (c *Config) ticketKeys(ctx context.Context, configForClient *Config) ([]ticketKey, error) {
 	// If the ConfigForClient callback returned a Config with explicitly set
 	// keys, use those, otherwise just use the original Config.
 	if configForClient != nil {
-		configForClient.mutex.RLock()
+		if configForClient.TicketKeyStore != nil && !configForClient.SessionTicketsDisabled {
+			return configForClient.storeTicketKeys(ctx)
+		}
 		if configForClient.SessionTicketsDisabled {
-			configForClient.mutex.RUnlock()
-			return nil
+			return nil, nil
 		}
-		configForClient.initLegacySessionTicketKeyRLocked()
-		if len(configForClient.sessionTicketKeys) != 0 {
-			ret := configForClient.sessionTicketKeys
-			configForClient.mutex.RUnlock()
-			return ret
+		if keys := configForClient.loadTicketKeys().sessionTicketKeys; len(keys) != 0 {
+			return keys, nil
 		}
-		configForClient.mutex.RUnlock()
 	}
 
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+	if c.TicketKeyStore != nil && !c.SessionTicketsDisabled {
+		return c.storeTicketKeys(ctx)
+	}
 	if c.SessionTicketsDisabled {
-		return nil
+		return nil, nil
 	}
-	c.initLegacySessionTicketKeyRLocked()
-	if len(c.sessionTicketKeys) != 0 {
-		return c.sessionTicketKeys
+	keys := c.loadTicketKeys()
+	if len(keys.sessionTicketKeys) != 0 {
+		return keys.sessionTicketKeys, nil
 	}
 	// Fast path for the common case where the key is fresh enough.
-	if len(c.autoSessionTicketKeys) > 0 && c.time().Sub(c.autoSessionTicketKeys[0].created) < ticketKeyRotation {
-		return c.autoSessionTicketKeys
+	if len(keys.autoSessionTicketKeys) > 0 && c.time().Sub(keys.autoSessionTicketKeys[0].created) < ticketKeyRotation {
+		return keys.autoSessionTicketKeys, nil
 	}
 
 	// autoSessionTicketKeys are managed by auto-rotation.
-	c.mutex.RUnlock()
-	defer c.mutex.RLock()
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	// Re-check the condition in case it changed since obtaining the new lock.
+	defer c.storeTicketKeysSnapshotLocked()
+	// Re-check the condition in case it changed since obtaining the lock.
 	if len(c.autoSessionTicketKeys) == 0 || c.time().Sub(c.autoSessionTicketKeys[0].created) >= ticketKeyRotation {
 		var newKey [32]byte
 		if _, err := io.ReadFull(c.rand(), newKey[:]); err != nil {
@@ -1132,7 +2197,30 @@ func (c *Config) ticketKeys(configForClient *Config) []ticketKey {
 		}
 		c.autoSessionTicketKeys = valid
 	}
-	return c.autoSessionTicketKeys
+	return c.autoSessionTicketKeys, nil
+}
+
+// loadTicketKeys returns the current ticket key fields of c. Once they are
+// initialized, it doesn't lock c.mutex until they change.
+func (c *Config) loadTicketKeys() *ticketKeySnapshot {
+	if keys := c.ticketKeysSnapshot.Load(); keys != nil {
+		return keys
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	c.initLegacySessionTicketKeyRLocked()
+	return c.storeTicketKeysSnapshotLocked()
+}
+
+// storeTicketKeysSnapshotLocked publishes the ticket key fields of c. c.mutex
+// must be held, at least for reading.
+func (c *Config) storeTicketKeysSnapshotLocked() *ticketKeySnapshot {
+	keys := &ticketKeySnapshot{
+		sessionTicketKeys:     c.sessionTicketKeys,
+		autoSessionTicketKeys: c.autoSessionTicketKeys,
+	}
+	c.ticketKeysSnapshot.Store(keys)
+	return keys
 }
 
 // SetSessionTicketKeys updates the session ticket keys for a server.
@@ -1160,6 +2248,7 @@ func (c *Config) SetSessionTicketKeys(keys [][32]byte) {
 
 	c.mutex.Lock()
 	c.sessionTicketKeys = newKeys
+	c.ticketKeysSnapshot.Store(nil)
 	c.mutex.Unlock()
 }
 
@@ -1179,6 +2268,32 @@ func (c *Config) time() time.Time {
 	return t()
 }
 
+// hasAESGCMHardwareSupport reports whether c orders cipher suites as if the
+// local machine had hardware support for AES-GCM.
+func (c *Config) hasAESGCMHardwareSupport() bool {
+	switch c.AESGCMPreference {
+	case AESGCMPreferenceAlways:
+		return true
+	case AESGCMPreferenceNever:
+		return false
+	}
+	return hasAESGCMHardwareSupport
+}
+
+// aesGCMPreferred reports whether a server prefers AES-GCM cipher suites for
+// a client that sent the given cipher suites.
+func (c *Config) aesGCMPreferred(peerCipherSuites []uint16) bool {
+	switch c.AESGCMPreference {
+	case AESGCMPreferenceAlways:
+		return true
+	case AESGCMPreferenceNever:
+		return false
+	case AESGCMPreferencePeer:
+		return peerPrefersAESGCM(peerCipherSuites)
+	}
+	return isAESGCMPreferred(peerCipherSuites)
+}
+
 func (c *Config) cipherSuites(aesGCMPreferred bool) []uint16 {
 	var cipherSuites []uint16
 	if c.CipherSuites == nil {
@@ -1188,6 +2303,33 @@ func (c *Config) cipherSuites(aesGCMPreferred bool) []uint16 {
 		cipherSuites = slicesDeleteFunc(cipherSuites, func(id uint16) bool {
 			return !slicesContains(c.CipherSuites, id)
 		})
+		for _, suite := range cipherSuitesOptIn {
+			if slicesContains(c.CipherSuites, suite.id) {
+				cipherSuites = append(cipherSuites, suite.id)
+			}
+		}
+	}
+	if len(c.InsecureCipherSuites) > 0 {
+		candidates := supportedCipherSuites(aesGCMPreferred)
+		for _, suite := range cipherSuitesOptIn {
+			candidates = append(candidates, suite.id)
+		}
+		for _, id := range candidates {
+			if slicesContains(c.InsecureCipherSuites, id) && isInsecureCipherSuite(id) &&
+				!slicesContains(cipherSuites, id) {
+				cipherSuites = append(cipherSuites, id)
+			}
+		}
+	}
+	if level := c.securityLevel(); level.bits > 0 {
+		cipherSuites = slicesDeleteFunc(cipherSuites, func(id uint16) bool {
+			return !level.allowsCipherSuite(id)
+		})
+	}
+	if c.fipsMode() {
+		cipherSuites = slicesDeleteFunc(cipherSuites, func(id uint16) bool {
+			return !slicesContains(allowedCipherSuitesFIPS, id)
+		})
 	}
 	return cipherSuites
 }
@@ -1213,7 +2355,10 @@ const roleServer = false
 // supportedVersions returns the list of supported TLS versions, sorted from
 // highest to lowest (and hence also in preference order).
 func (c *Config) supportedVersions(isClient, isQUIC bool) []uint16 {
-	versions := make([]uint16, 0, len(supportedVersions))
+	if c != nil && c.TLCP && isClient && !isQUIC && !c.FIPSMode {
+		return []uint16{VersionTLCP}
+	}
+	versions := make([]uint16, 0, len(supportedVersions)+1)
 	for _, v := range supportedVersions {
 		if (c == nil || c.MinVersion == 0) && v < VersionTLS12 {
 			if isClient {
@@ -1226,6 +2371,12 @@ func (c *Config) supportedVersions(isClient, isQUIC bool) []uint16 {
 		if c != nil && c.MinVersion != 0 && v < c.MinVersion {
 			continue
 		}
+		if v < c.securityLevel().minVersion {
+			continue
+		}
+		if c.fipsMode() && !slicesContains(allowedSupportedVersionsFIPS, v) {
+			continue
+		}
 		if c != nil && c.MaxVersion != 0 && v > c.MaxVersion {
 			continue
 		}
@@ -1234,6 +2385,11 @@ func (c *Config) supportedVersions(isClient, isQUIC bool) []uint16 {
 		}
 		versions = append(versions, v)
 	}
+	// TLCP sorts last, as it's only negotiated with TLCP clients, which
+	// support nothing else.
+	if c != nil && c.TLCP && !isQUIC && !c.FIPSMode {
+		versions = append(versions, VersionTLCP)
+	}
 	return versions
 }
 
@@ -1249,6 +2405,9 @@ func (c *Config) maxSupportedVersion(isClient, isQUIC bool) uint16 {
 // legacy maximum version value. Note that only versions supported by this
 // library are returned. Any newer peer will use supportedVersions anyway.
 func supportedVersionsFromMax(maxVersion uint16) []uint16 {
+	if maxVersion == VersionTLCP {
+		return []uint16{VersionTLCP}
+	}
 	versions := make([]uint16, 0, len(supportedVersions))
 	for _, v := range supportedVersions {
 		if v > maxVersion {
@@ -1261,6 +2420,9 @@ func supportedVersionsFromMax(maxVersion uint16) []uint16 {
 
 func (c *Config) curvePreferences(version uint16) []CurveID {
 	curvePreferences := defaultCurvePreferences()
+	if c != nil && c.ShangMiCipherSuites {
+		curvePreferences = append(curvePreferences, CurveSM2)
+	}
 	if c != nil && len(c.CurvePreferences) != 0 {
 		curvePreferences = slicesDeleteFunc(curvePreferences, func(x CurveID) bool {
 			return !slicesContains(c.CurvePreferences, x)
@@ -1269,6 +2431,16 @@ func (c *Config) curvePreferences(version uint16) []CurveID {
 	if version < VersionTLS13 {
 		curvePreferences = slicesDeleteFunc(curvePreferences, isTLS13OnlyKeyExchange)
 	}
+	if minStrength := c.minECStrength(); minStrength > 0 {
+		curvePreferences = slicesDeleteFunc(curvePreferences, func(x CurveID) bool {
+			return curveStrength(x) < minStrength
+		})
+	}
+	if c.fipsMode() {
+		curvePreferences = slicesDeleteFunc(curvePreferences, func(x CurveID) bool {
+			return !slicesContains(allowedCurvePreferencesFIPS, x)
+		})
+	}
 	return curvePreferences
 }
 
@@ -1302,7 +2474,17 @@ var errNoCertificates = errors.New("tls: no certificates configured")
 // getCertificate returns the best certificate for the given ClientHelloInfo,
 // defaulting to the first element of c.Certificates.
 func (c *Config) getCertificate(clientHello *ClientHelloInfo) (*Certificate, error) {
-	if c.GetCertificate != nil &&
+	if c.GetCertificateForHello != nil &&
+		(len(c.Certificates) == 0 || len(clientHello.ServerName) > 0) {
+		hello, err := clientHello.clientHello()
+		if err != nil {
+			return nil, err
+		}
+		cert, err := c.GetCertificateForHello(hello)
+		if cert != nil || err != nil {
+			return cert, err
+		}
+	} else if c.GetCertificate != nil &&
 		(len(c.Certificates) == 0 || len(clientHello.ServerName) > 0) {
 		cert, err := c.GetCertificate(clientHello)
 		if cert != nil || err != nil {
@@ -1334,6 +2516,12 @@ func (c *Config) getCertificate(clientHello *ClientHelloInfo) (*Certificate, err
 		}
 	}
 
+	if c.RankCertificates {
+		if cert := c.bestCertificate(clientHello); cert != nil {
+			return cert, nil
+		}
+	}
+
 	for _, cert := range c.Certificates {
 		if err := clientHello.SupportsCertificate(&cert); err == nil {
 			return &cert, nil
@@ -1344,6 +2532,58 @@ func (c *Config) getCertificate(clientHello *ClientHelloInfo) (*Certificate, err
 	return &c.Certificates[0], nil
 }
 
+// bestCertificate returns the certificate compatible with clientHello ranked
+// first as described in Config.RankCertificates, or nil if there are none.
+func (c *Config) bestCertificate(clientHello *ClientHelloInfo) *Certificate {
+	serverName := strings.ToLower(clientHello.ServerName)
+	var best *Certificate
+	var bestNameRank, bestKeyRank int
+	for i := range c.Certificates {
+		cert := &c.Certificates[i]
+		if clientHello.SupportsCertificate(cert) != nil {
+			continue
+		}
+		leaf, err := cert.leaf()
+		if err != nil {
+			continue
+		}
+		nameRank, keyRank := certificateRank(leaf, serverName)
+		if best == nil || nameRank < bestNameRank ||
+			nameRank == bestNameRank && keyRank < bestKeyRank {
+			best, bestNameRank, bestKeyRank = cert, nameRank, keyRank
+		}
+	}
+	return best
+}
+
+// certificateRank returns how well leaf matches serverName, and how efficient
+// its key is, lower being better.
+func certificateRank(leaf *x509.Certificate, serverName string) (nameRank, keyRank int) {
+	if serverName != "" && !slicesContainsFunc(leaf.DNSNames, func(name string) bool {
+		return strings.ToLower(name) == serverName
+	}) {
+		nameRank = 1
+	}
+	switch pub := leaf.PublicKey.(type) {
+	case ed25519.PublicKey:
+		keyRank = 0
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			keyRank = 1
+		case elliptic.P384():
+			keyRank = 2
+		default:
+			keyRank = 3
+		}
+	case ed448.PublicKey:
+		keyRank = 2
+	default:
+		keyRank = 4
+	}
+	return nameRank, keyRank
+}
+
 // SupportsCertificate returns nil if the provided certificate is supported by
 // the client that sent the ClientHello. Otherwise, it returns an error
 // describing the reason for the incompatibility.
@@ -1356,9 +2596,9 @@ func (c *Config) getCertificate(clientHello *ClientHelloInfo) (*Certificate, err
 // This function will call x509.ParseCertificate unless c.Leaf is set, which can
 // incur a significant performance cost.
 func (chi *ClientHelloInfo) SupportsCertificate(c *Certificate) error {
-	// Note we don't currently support certificate_authorities nor
-	// signature_algorithms_cert, and don't check the algorithms of the
-	// signatures on the chain (which anyway are a SHOULD, see RFC 8446,
+	// Note we only check the algorithms of the signatures on the chain if the
+	// client sent signature_algorithms_cert, rather than against
+	// signature_algorithms otherwise (which anyway is a SHOULD, see RFC 8446,
 	// Section 4.4.2.2).
 
 	config := chi.config
@@ -1382,6 +2622,13 @@ func (chi *ClientHelloInfo) SupportsCertificate(c *Certificate) error {
 		}
 	}
 
+	if err := signedByAcceptableCA(c, chi.AcceptableCAs); err != nil {
+		return err
+	}
+	if err := chainSignedWith(c, chi.CertificateSignatureSchemes); err != nil {
+		return err
+	}
+
 	// supportsRSAFallback returns nil if the certificate and connection support
 	// the static RSA key exchange, and unsupported otherwise. The logic for
 	// supporting static RSA is completely disjoint from the logic for
@@ -1473,6 +2720,11 @@ func (chi *ClientHelloInfo) SupportsCertificate(c *Certificate) error {
 				return errors.New("connection doesn't support Ed25519")
 			}
 			ecdsaCipherSuite = true
+		case ed448.PublicKey:
+			if vers < VersionTLS12 || len(chi.SignatureSchemes) == 0 {
+				return errors.New("connection doesn't support Ed448")
+			}
+			ecdsaCipherSuite = true
 		case *rsa.PublicKey:
 		default:
 			return supportsRSAFallback(unsupportedCertificateError(c))
@@ -1517,7 +2769,16 @@ func (cri *CertificateRequestInfo) SupportsCertificate(c *Certificate) error {
 		return err
 	}
 
-	if len(cri.AcceptableCAs) == 0 {
+	if err := chainSignedWith(c, cri.CertificateSignatureSchemes); err != nil {
+		return err
+	}
+	return signedByAcceptableCA(c, cri.AcceptableCAs)
+}
+
+// signedByAcceptableCA returns nil if a certificate of the chain c was issued
+// by one of acceptableCAs, or if acceptableCAs is empty.
+func signedByAcceptableCA(c *Certificate, acceptableCAs [][]byte) error {
+	if len(acceptableCAs) == 0 {
 		return nil
 	}
 
@@ -1527,12 +2788,12 @@ func (cri *CertificateRequestInfo) SupportsCertificate(c *Certificate) error {
 		// chain.Leaf was nil.
 		if j != 0 || x509Cert == nil {
 			var err error
-			if x509Cert, err = x509.ParseCertificate(cert); err != nil {
+			if x509Cert, err = parseCertificate(cert); err != nil {
 				return fmt.Errorf("failed to parse certificate #%d in the chain: %w", j, err)
 			}
 		}
 
-		for _, ca := range cri.AcceptableCAs {
+		for _, ca := range acceptableCAs {
 			if bytes.Equal(x509Cert.RawIssuer, ca) {
 				return nil
 			}
@@ -1569,6 +2830,7 @@ func (c *Config) BuildNameToCertificate() {
 
 const (
 	keyLogLabelTLS12           = "CLIENT_RANDOM"
+	keyLogLabelClientEarly     = "CLIENT_EARLY_TRAFFIC_SECRET"
 	keyLogLabelClientHandshake = "CLIENT_HANDSHAKE_TRAFFIC_SECRET"
 	keyLogLabelServerHandshake = "SERVER_HANDSHAKE_TRAFFIC_SECRET"
 	keyLogLabelClientTraffic   = "CLIENT_TRAFFIC_SECRET_0"
@@ -1597,14 +2859,18 @@ var writerMutex sync.Mutex
 type Certificate struct {
 	Certificate [][]byte
 	// PrivateKey contains the private key corresponding to the public key in
-	// Leaf. This must implement [crypto.Signer] with an RSA, ECDSA or Ed25519
-	// PublicKey.
+	// Leaf. This must implement [crypto.Signer] with an RSA, ECDSA, Ed25519,
+	// Ed448 or SM2 PublicKey. Ed448 keys are of the types of
+	// github.com/cloudflare/circl/sign/ed448, and SM2 private keys are
+	// *github.com/emmansun/gmsm/sm2.PrivateKey.
 	//
 	// For a server up to TLS 1.2, it can also implement crypto.Decrypter with
 	// an RSA PublicKey.
 	//
 	// If it implements [crypto.MessageSigner], SignMessage will be used instead
 	// of Sign for TLS 1.2 and later.
+	// If it implements [ContextSigner], handshake signatures are made with
+	// SignContext instead.
 	PrivateKey crypto.PrivateKey
 	// SupportedSignatureAlgorithms is an optional list restricting what
 	// signature algorithms the PrivateKey can be used for.
@@ -1619,6 +2885,12 @@ type Certificate struct {
 	// using x509.ParseCertificate to reduce per-handshake processing. If nil,
 	// the leaf certificate will be parsed as needed.
 	Leaf *x509.Certificate
+	// DelegatedCredentials contains optional delegated credentials issued by
+	// the leaf certificate, with their private keys. TLS 1.3 servers sign the
+	// handshake with the first one that hasn't expired and that the client
+	// accepts, instead of PrivateKey. PrivateKey may then be nil, but
+	// handshakes with other clients fail.
+	DelegatedCredentials []*DelegatedCredential
 }
 
 // leaf returns the parsed leaf certificate, either from c.Leaf or by parsing
@@ -1627,7 +2899,7 @@ func (c *Certificate) leaf() (*x509.Certificate, error) {
 	if c.Leaf != nil {
 		return c.Leaf, nil
 	}
-	return x509.ParseCertificate(c.Certificate[0])
+	return parseCertificate(c.Certificate[0])
 }
 
 type handshakeMessage interface {
@@ -1644,43 +2916,177 @@ type handshakeMessageWithOriginalBytes interface {
 	originalBytes() []byte
 }
 
-// lruSessionCache is a ClientSessionCache implementation that uses an LRU
-// caching strategy.
+// ClientSessionCacheOptions configures a [ShardedClientSessionCache].
+type ClientSessionCacheOptions struct {
+	// Capacity is the maximum number of sessions in the cache. If it is < 1,
+	// a default capacity of 64 is used instead.
+	Capacity int
+
+	// Shards is the number of independently locked parts the cache is split
+	// into, rounded up to a power of two, to reduce lock contention between
+	// concurrent handshakes. Each shard evicts its own least recently used
+	// session, so the eviction order is only approximately LRU. If zero, it
+	// is derived from Capacity, and small caches use a single shard.
+	Shards int
+
+	// TTL is how long a session stays in the cache after it was added. If
+	// zero, sessions are only evicted to make room for new ones.
+	TTL time.Duration
+}
+
+// ClientSessionCacheStats reports the activity of a [ShardedClientSessionCache].
+type ClientSessionCacheStats struct {
+	Len         int    // the number of sessions currently in the cache
+	Hits        uint64 // Get calls that returned a session
+	Misses      uint64 // Get calls that found no session, or an expired one
+	Evictions   uint64 // sessions removed to make room for new ones
+	Expirations uint64 // sessions removed because their TTL passed
+}
+
+// ShardedClientSessionCache is a [ClientSessionCache] bounded in size and age,
+// made of LRU shards selected by a hash of the session key. It is safe for
+// concurrent use.
+type ShardedClientSessionCache struct {
+	shards []*lruSessionCache
+	seed   maphash.Seed
+	ttl    time.Duration
+	now    func() time.Time // overridden in tests
+}
+
+// lruSessionCache is a shard of a ShardedClientSessionCache, which uses an
+// LRU caching strategy.
 type lruSessionCache struct {
 	sync.Mutex
 
 	m        map[string]*list.Element
 	q        *list.List
 	capacity int
+	ttl      time.Duration
+
+	hits, misses, evictions, expirations uint64
 }
 
 type lruSessionCacheEntry struct {
 	sessionKey string
 	state      *ClientSessionState
+	expires    time.Time // zero if the cache has no TTL
 }
 
 // NewLRUClientSessionCache returns a [ClientSessionCache] with the given
 // capacity that uses an LRU strategy. If capacity is < 1, a default capacity
-// is used instead.
+// is used instead. The returned cache is a [*ShardedClientSessionCache].
 func NewLRUClientSessionCache(capacity int) ClientSessionCache {
-	const defaultSessionCacheCapacity = 64
+	return NewShardedClientSessionCache(ClientSessionCacheOptions{Capacity: capacity})
+}
 
+// NewShardedClientSessionCache returns a [ShardedClientSessionCache]
+// configured by opts.
+func NewShardedClientSessionCache(opts ClientSessionCacheOptions) *ShardedClientSessionCache {
+	const (
+		defaultSessionCacheCapacity = 64
+		minShardCapacity            = 64
+		maxDefaultShards            = 16
+	)
+
+	capacity := opts.Capacity
 	if capacity < 1 {
 		capacity = defaultSessionCacheCapacity
 	}
-	return &lruSessionCache{
-		m:        make(map[string]*list.Element),
-		q:        list.New(),
-		capacity: capacity,
+	n := opts.Shards
+	if n < 1 {
+		n = capacity / minShardCapacity
+		if n > maxDefaultShards {
+			n = maxDefaultShards
+		}
+	}
+	if n > capacity {
+		n = capacity
+	}
+	shards := 1
+	for shards < n {
+		shards <<= 1
+	}
+
+	c := &ShardedClientSessionCache{
+		shards: make([]*lruSessionCache, shards),
+		seed:   maphash.MakeSeed(),
+		ttl:    opts.TTL,
+		now:    time.Now,
+	}
+	for i := range c.shards {
+		// Spread the capacity over the shards, without exceeding it. With
+		// more shards than capacity, some shards get a single entry.
+		shardCapacity := capacity / shards
+		if i < capacity%shards {
+			shardCapacity++
+		}
+		if shardCapacity < 1 {
+			shardCapacity = 1
+		}
+		c.shards[i] = &lruSessionCache{
+			m:        make(map[string]*list.Element),
+			q:        list.New(),
+			capacity: shardCapacity,
+			ttl:      opts.TTL,
+		}
+	}
+	return c
+}
+
+func (c *ShardedClientSessionCache) shard(sessionKey string) *lruSessionCache {
+	if len(c.shards) == 1 {
+		return c.shards[0]
 	}
+	return c.shards[maphash.String(c.seed, sessionKey)&uint64(len(c.shards)-1)]
 }
 
 // Put adds the provided (sessionKey, cs) pair to the cache. If cs is nil, the entry
 // corresponding to sessionKey is removed from the cache instead.
-func (c *lruSessionCache) Put(sessionKey string, cs *ClientSessionState) {
+func (c *ShardedClientSessionCache) Put(sessionKey string, cs *ClientSessionState) {
+	var now time.Time
+	if c.ttl > 0 {
+		now = c.now()
+	}
+	c.shard(sessionKey).put(sessionKey, cs, now)
+}
+
+// Get returns the [ClientSessionState] value associated with a given key. It
+// returns (nil, false) if no value is found.
+func (c *ShardedClientSessionCache) Get(sessionKey string) (*ClientSessionState, bool) {
+	var now time.Time
+	if c.ttl > 0 {
+		now = c.now()
+	}
+	return c.shard(sessionKey).get(sessionKey, now)
+}
+
+// Stats returns the number of sessions in the cache and the counts of cache
+// events since it was created.
+func (c *ShardedClientSessionCache) Stats() ClientSessionCacheStats {
+	var stats ClientSessionCacheStats
+	for _, s := range c.shards {
+		s.Lock()
+		stats.Len += s.q.Len()
+		stats.Hits += s.hits
+		stats.Misses += s.misses
+		stats.Evictions += s.evictions
+		stats.Expirations += s.expirations
+		s.Unlock()
+	}
+	return stats
+}
+
+// put adds or removes the entry of sessionKey at now, which is zero if the
+// cache has no TTL.
+func (c *lruSessionCache) put(sessionKey string, cs *ClientSessionState, now time.Time) {
 	c.Lock()
 	defer c.Unlock()
 
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = now.Add(c.ttl)
+	}
+
 	if elem, ok := c.m[sessionKey]; ok {
 		if cs == nil {
 			c.q.Remove(elem)
@@ -1688,37 +3094,131 @@ func (c *lruSessionCache) Put(sessionKey string, cs *ClientSessionState) {
 		} else {
 			entry := elem.Value.(*lruSessionCacheEntry)
 			entry.state = cs
+			entry.expires = expires
 			c.q.MoveToFront(elem)
 		}
 		return
 	}
+	if cs == nil {
+		return
+	}
 
 	if c.q.Len() < c.capacity {
-		entry := &lruSessionCacheEntry{sessionKey, cs}
+		entry := &lruSessionCacheEntry{sessionKey, cs, expires}
 		c.m[sessionKey] = c.q.PushFront(entry)
 		return
 	}
 
 	elem := c.q.Back()
 	entry := elem.Value.(*lruSessionCacheEntry)
+	if c.ttl > 0 && !now.Before(entry.expires) {
+		c.expirations++
+	} else {
+		c.evictions++
+	}
 	delete(c.m, entry.sessionKey)
 	entry.sessionKey = sessionKey
 	entry.state = cs
+	entry.expires = expires
 	c.q.MoveToFront(elem)
 	c.m[sessionKey] = elem
 }
 
-// Get returns the [ClientSessionState] value associated with a given key. It
-// returns (nil, false) if no value is found.
-func (c *lruSessionCache) Get(sessionKey string) (*ClientSessionState, bool) {
+// get looks up sessionKey, dropping its entry if it expired at now, which is
+// zero if the cache has no TTL.
+func (c *lruSessionCache) get(sessionKey string, now time.Time) (*ClientSessionState, bool) {
 	c.Lock()
 	defer c.Unlock()
 
-	if elem, ok := c.m[sessionKey]; ok {
+	elem, ok := c.m[sessionKey]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := elem.Value.(*lruSessionCacheEntry)
+	if c.ttl > 0 && !now.Before(entry.expires) {
+		c.q.Remove(elem)
+		delete(c.m, sessionKey)
+		c.expirations++
+		c.misses++
+		return nil, false
+	}
+	c.q.MoveToFront(elem)
+	c.hits++
+	return entry.state, true
+}
+
+// lruServerSessionCache is a ServerSessionCache implementation that uses an
+// LRU caching strategy.
+type lruServerSessionCache struct {
+	sync.Mutex
+
+	m        map[string]*list.Element
+	q        *list.List
+	capacity int
+}
+
+type lruServerSessionCacheEntry struct {
+	identity string
+	state    *SessionState
+}
+
+// NewLRUServerSessionCache returns a [ServerSessionCache] with the given
+// capacity that uses an LRU strategy. If capacity is < 1, a default capacity
+// is used instead.
+func NewLRUServerSessionCache(capacity int) ServerSessionCache {
+	const defaultSessionCacheCapacity = 1024
+
+	if capacity < 1 {
+		capacity = defaultSessionCacheCapacity
+	}
+	return &lruServerSessionCache{
+		m:        make(map[string]*list.Element),
+		q:        list.New(),
+		capacity: capacity,
+	}
+}
+
+// Put adds the provided (identity, ss) pair to the cache, evicting the least
+// recently added session if the cache is full.
+func (c *lruServerSessionCache) Put(identity string, ss *SessionState) {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.m[identity]; ok {
+		elem.Value.(*lruServerSessionCacheEntry).state = ss
 		c.q.MoveToFront(elem)
-		return elem.Value.(*lruSessionCacheEntry).state, true
+		return
+	}
+
+	if c.q.Len() < c.capacity {
+		entry := &lruServerSessionCacheEntry{identity, ss}
+		c.m[identity] = c.q.PushFront(entry)
+		return
+	}
+
+	elem := c.q.Back()
+	entry := elem.Value.(*lruServerSessionCacheEntry)
+	delete(c.m, entry.identity)
+	entry.identity = identity
+	entry.state = ss
+	c.q.MoveToFront(elem)
+	c.m[identity] = elem
+}
+
+// Take removes and returns the [SessionState] associated with a given ticket
+// identity. It returns nil if no value is found.
+func (c *lruServerSessionCache) Take(identity string) *SessionState {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.m[identity]
+	if !ok {
+		return nil
 	}
-	return nil, false
+	c.q.Remove(elem)
+	delete(c.m, identity)
+	return elem.Value.(*lruServerSessionCacheEntry).state
 }
 
 var emptyConfig Config
@@ -1733,17 +3233,76 @@ func unexpectedMessageError(wanted, got any) error {
 
 var testingOnlySupportedSignatureAlgorithms []SignatureScheme
 
+// supportedSignatureAlgorithms returns the signature algorithms supported by
+// default for the given minimum TLS version, for callers without a Config.
+func supportedSignatureAlgorithms(minVers uint16) []SignatureScheme {
+	return defaultConfig().supportedSignatureAlgorithms(minVers)
+}
+
 // supportedSignatureAlgorithms returns the supported signature algorithms for
 // the given minimum TLS version, to advertise in ClientHello and
 // CertificateRequest messages.
-func supportedSignatureAlgorithms(minVers uint16) []SignatureScheme {
+func (c *Config) supportedSignatureAlgorithms(minVers uint16) []SignatureScheme {
 	sigAlgs := defaultSupportedSignatureAlgorithms()
+	if c != nil && c.SignatureSchemes != nil {
+		sigAlgs = slicesClone(c.SignatureSchemes)
+	} else if c != nil && c.ShangMiCipherSuites {
+		sigAlgs = append(sigAlgs, SM2WithSM3)
+	}
 	if testingOnlySupportedSignatureAlgorithms != nil {
 		sigAlgs = slicesClone(testingOnlySupportedSignatureAlgorithms)
 	}
-	return slicesDeleteFunc(sigAlgs, func(s SignatureScheme) bool {
-		return isDisabledSignatureAlgorithm(minVers, s, false)
+	level := c.securityLevel()
+	sigAlgs = slicesDeleteFunc(sigAlgs, func(s SignatureScheme) bool {
+		return isDisabledSignatureAlgorithm(minVers, s, false) || !level.allowsSignatureAlgorithm(s) ||
+			c.fipsMode() && !slicesContains(allowedSignatureAlgorithmsFIPS, s)
 	})
+	return c.pssOnly(sigAlgs)
+}
+
+// errPKCS1v15Disabled is returned when a PKCS #1 v1.5 signature would be
+// needed before TLS 1.2 while Config.RequirePSS is set.
+var errPKCS1v15Disabled = errors.New("tls: PKCS #1 v1.5 signatures are disabled by Config.RequirePSS")
+
+// pssOnly returns sigAlgs without its PKCS #1 v1.5 schemes if c.RequirePSS
+// is set. sigAlgs isn't modified.
+func (c *Config) pssOnly(sigAlgs []SignatureScheme) []SignatureScheme {
+	if c == nil || !c.RequirePSS {
+		return sigAlgs
+	}
+	filtered := make([]SignatureScheme, 0, len(sigAlgs))
+	for _, s := range sigAlgs {
+		if sigType, _, _ := typeAndHashFromSignatureScheme(s); sigType != signaturePKCS1v15 {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// peerSignatureAlgorithms returns the signature algorithms of the peer, in
+// TLS 1.2, that can be used for our signatures.
+func (c *Config) peerSignatureAlgorithms(peerAlgs []SignatureScheme) ([]SignatureScheme, error) {
+	filtered := c.pssOnly(peerAlgs)
+	if len(filtered) == 0 && len(peerAlgs) > 0 {
+		return nil, errors.New("tls: peer only supports PKCS #1 v1.5 signatures, which are disabled by Config.RequirePSS")
+	}
+	if level := c.securityLevel(); level.bits > 0 {
+		filtered = slicesDeleteFunc(slicesClone(filtered), func(s SignatureScheme) bool {
+			return !level.allowsSignatureAlgorithm(s)
+		})
+		if len(filtered) == 0 && len(peerAlgs) > 0 {
+			return nil, errors.New("tls: peer only supports signature algorithms below Config.SecurityLevel")
+		}
+	}
+	if c.fipsMode() {
+		filtered = slicesDeleteFunc(slicesClone(filtered), func(s SignatureScheme) bool {
+			return !slicesContains(allowedSignatureAlgorithmsFIPS, s)
+		})
+		if len(filtered) == 0 && len(peerAlgs) > 0 {
+			return nil, errors.New("tls: peer only supports signature algorithms not allowed by Config.FIPSMode")
+		}
+	}
+	return filtered, nil
 }
 
 func isDisabledSignatureAlgorithm(version uint16, s SignatureScheme, isCert bool) bool {
@@ -1802,6 +3361,83 @@ func (e *CertificateVerificationError) Unwrap() error {
 	return e.Err
 }
 
+// verifyCertificateChains returns the verified chains of the peer
+// certificates certs, encoded as rawCerts, using c.VerifyCertificateChains if
+// set and downloading missing intermediates with c.AIAFetcher, and keeping
+// those allowed by c.CertificateSignatureSchemes.
+func (c *Config) verifyCertificateChains(ctx context.Context, rawCerts [][]byte, certs []*x509.Certificate, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+	chains, err := c.buildCertificateChains(rawCerts, certs, opts)
+	if err != nil && c.AIAFetcher != nil {
+		chains, err = c.chaseIntermediates(ctx, rawCerts, certs, opts, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.CertificateSignatureSchemes != nil {
+		// The signature of the root isn't checked, see RFC 8446, Section
+		// 4.4.2.2.
+		chains = slicesDeleteFunc(chains, func(chain []*x509.Certificate) bool {
+			return checkSignatureSchemes(chain[:len(chain)-1], c.CertificateSignatureSchemes) != nil
+		})
+		if len(chains) == 0 {
+			return nil, errors.New("tls: certificate chain signed with an algorithm not in Config.CertificateSignatureSchemes")
+		}
+	}
+	return chains, nil
+}
+
+func (c *Config) buildCertificateChains(rawCerts [][]byte, certs []*x509.Certificate, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+	if c.VerifyCertificateChains == nil {
+		return certs[0].Verify(opts)
+	}
+	chains, err := c.VerifyCertificateChains(rawCerts, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(chains) == 0 {
+		return nil, errors.New("tls: VerifyCertificateChains returned no chains")
+	}
+	for _, chain := range chains {
+		if len(chain) == 0 || !chain[0].Equal(certs[0]) {
+			return nil, errors.New("tls: VerifyCertificateChains returned a chain not starting with the leaf certificate")
+		}
+	}
+	return chains, nil
+}
+
+// certificateSignatureSchemes returns the algorithms to advertise in the
+// signature_algorithms_cert extension.
+func (c *Config) certificateSignatureSchemes() []SignatureScheme {
+	if c.CertificateSignatureSchemes != nil {
+		return c.CertificateSignatureSchemes
+	}
+	sigAlgs := supportedSignatureAlgorithmsCert()
+	for _, s := range c.SignatureSchemes {
+		if !slicesContains(sigAlgs, s) {
+			sigAlgs = append(sigAlgs, s)
+		}
+	}
+	return sigAlgs
+}
+
+// resumedChainsValid reports whether the verified chains of a resumed session
+// with peer certificates certs are still valid under opts. See
+// anyValidVerifiedChain and Config.VerifyCertificateChains.
+func (c *Config) resumedChainsValid(certs []*x509.Certificate, verifiedChains [][]*x509.Certificate, opts x509.VerifyOptions) bool {
+	if c.VerifyCertificateChains == nil {
+		return anyValidVerifiedChain(verifiedChains, opts)
+	}
+	if len(verifiedChains) == 0 {
+		return false
+	}
+	rawCerts := make([][]byte, len(certs))
+	for i, cert := range certs {
+		rawCerts[i] = cert.Raw
+	}
+	_, err := c.verifyCertificateChains(context.Background(), rawCerts, certs, opts)
+	return err == nil
+}
+
 // anyValidVerifiedChain reports if at least one of the chains in verifiedChains
 // is valid, as indicated by none of the certificates being expired and the root
 // being in opts.Roots (or in the system root pool if opts.Roots is nil). If
@@ -18,6 +18,8 @@ func _() {
 	_ = x[ECDSAWithP384AndSHA384-1283]
 	_ = x[ECDSAWithP521AndSHA512-1539]
 	_ = x[Ed25519-2055]
+	_ = x[Ed448-2056]
+	_ = x[SM2WithSM3-1800]
 	_ = x[PKCS1WithSHA1-513]
 	_ = x[ECDSAWithSHA1-515]
 }
@@ -31,11 +33,12 @@ const (
 	_SignatureScheme_name_5 = "ECDSAWithP384AndSHA384"
 	_SignatureScheme_name_6 = "PKCS1WithSHA512"
 	_SignatureScheme_name_7 = "ECDSAWithP521AndSHA512"
-	_SignatureScheme_name_8 = "PSSWithSHA256PSSWithSHA384PSSWithSHA512Ed25519"
+	_SignatureScheme_name_8 = "SM2WithSM3"
+	_SignatureScheme_name_9 = "PSSWithSHA256PSSWithSHA384PSSWithSHA512Ed25519Ed448"
 )
 
 var (
-	_SignatureScheme_index_8 = [...]uint8{0, 13, 26, 39, 46}
+	_SignatureScheme_index_9 = [...]uint8{0, 13, 26, 39, 46, 51}
 )
 
 func (i SignatureScheme) String() string {
@@ -56,9 +59,11 @@ func (i SignatureScheme) String() string {
 		return _SignatureScheme_name_6
 	case i == 1539:
 		return _SignatureScheme_name_7
-	case 2052 <= i && i <= 2055:
+	case i == 1800:
+		return _SignatureScheme_name_8
+	case 2052 <= i && i <= 2056:
 		i -= 2052
-		return _SignatureScheme_name_8[_SignatureScheme_index_8[i]:_SignatureScheme_index_8[i+1]]
+		return _SignatureScheme_name_9[_SignatureScheme_index_9[i]:_SignatureScheme_index_9[i+1]]
 	default:
 		return "SignatureScheme(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -72,19 +77,26 @@ func _() {
 	_ = x[CurveP521-25]
 	_ = x[X25519-29]
 	_ = x[X25519MLKEM768-4588]
+	_ = x[CurveSM2-41]
 	_ = x[SecP256r1MLKEM768-4587]
 	_ = x[SecP384r1MLKEM1024-4589]
+	_ = x[FFDHE2048-256]
+	_ = x[FFDHE3072-257]
+	_ = x[FFDHE4096-258]
 }
 
 const (
 	_CurveID_name_0 = "CurveP256CurveP384CurveP521"
 	_CurveID_name_1 = "X25519"
-	_CurveID_name_2 = "SecP256r1MLKEM768X25519MLKEM768SecP384r1MLKEM1024"
+	_CurveID_name_2 = "CurveSM2"
+	_CurveID_name_3 = "FFDHE2048FFDHE3072FFDHE4096"
+	_CurveID_name_4 = "SecP256r1MLKEM768X25519MLKEM768SecP384r1MLKEM1024"
 )
 
 var (
 	_CurveID_index_0 = [...]uint8{0, 9, 18, 27}
-	_CurveID_index_2 = [...]uint8{0, 17, 31, 49}
+	_CurveID_index_3 = [...]uint8{0, 9, 18, 27}
+	_CurveID_index_4 = [...]uint8{0, 17, 31, 49}
 )
 
 func (i CurveID) String() string {
@@ -94,9 +106,14 @@ func (i CurveID) String() string {
 		return _CurveID_name_0[_CurveID_index_0[i]:_CurveID_index_0[i+1]]
 	case i == 29:
 		return _CurveID_name_1
+	case i == 41:
+		return _CurveID_name_2
+	case 256 <= i && i <= 258:
+		i -= 256
+		return _CurveID_name_3[_CurveID_index_3[i]:_CurveID_index_3[i+1]]
 	case 4587 <= i && i <= 4589:
 		i -= 4587
-		return _CurveID_name_2[_CurveID_index_2[i]:_CurveID_index_2[i+1]]
+		return _CurveID_name_4[_CurveID_index_4[i]:_CurveID_index_4[i+1]]
 	default:
 		return "CurveID(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -19,6 +19,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -53,6 +54,7 @@ type Conn struct {
 	peerSigAlg       SignatureScheme
 	ocspResponse     []byte   // stapled OCSP response
 	scts             [][]byte // signed certificate timestamps from server
+	verifiedSCTs     []VerifiedSCT
 	peerCertificates []*x509.Certificate
 	// activeCertHandles contains the cache handles to certificates in
 	// peerCertificates that are used to track active references.
@@ -62,28 +64,143 @@ type Conn struct {
 	verifiedChains [][]*x509.Certificate
 	// serverName contains the server name indicated by the client, if any.
 	serverName string
-	// secureRenegotiation is true if the server echoed the secure
-	// renegotiation extension. (This is meaningless as a server because
-	// renegotiation is not supported in that case.)
+	// secureRenegotiation is true if the peer supports the secure
+	// renegotiation extension. As a server, it's only reported, since
+	// renegotiation is not supported in that case.
 	secureRenegotiation bool
+	// recordSizeLimit and peerRecordSizeLimit are the maximum sizes of the
+	// plaintext of the protected records received and sent, negotiated with
+	// the record_size_limit extension, or zero if it wasn't.
+	recordSizeLimit     int
+	peerRecordSizeLimit int
+	// certCompression is the algorithm of the compressed certificate chain
+	// of the peer, if any.
+	certCompression CertificateCompressionAlgorithm
+	// decoyFlight pads the server's first flight after Config.DecoyMirror.
+	decoyFlight *decoyFlight
+	// pacedRecords is the number of records of application data sent with
+	// Config.RecordPacing.
+	pacedRecords int
+	// recordSplits are the offsets in the data written by writeRecordLocked
+	// at which a record must end, in increasing order.
+	recordSplits []int
+	// scriptedRecords is the number of records of Config.HandshakeScript
+	// sent.
+	scriptedRecords int
+	// morphedDummies is the number of dummy records of
+	// Config.TrafficMorpher sent in a row.
+	morphedDummies int
+	// helloSpec, if not nil, is the ClientHelloSpec of a UConn, and
+	// helloSpecErr the error of its ClientHelloID.
+	helloSpec    *ClientHelloSpec
+	helloSpecErr error
+	// fallback is Config.Fallback while a server handshake may still be
+	// handed to it, and fallbackInput what the client sent so far.
+	fallback      func(ctx context.Context, conn net.Conn, received []byte) error
+	fallbackInput []byte
+	// callbackErr is the last error returned by a callback of the Config
+	// during the handshake, which it returns as is.
+	callbackErr error
+	// fallbackHandoff, if not nil, hands the connection of a failed server
+	// handshake to Config.Fallback.
+	fallbackHandoff func(ctx context.Context) error
 	// ekm is a closure for exporting keying material.
 	ekm func(label string, context []byte, length int) ([]byte, error)
 	// resumptionSecret is the resumption_master_secret for handling
 	// or sending NewSessionTicket messages.
 	resumptionSecret []byte
-	echAccepted      bool
+	// sessionTicketsSent is the number of TLS 1.3 session tickets sent by a
+	// server, used to generate their nonces.
+	sessionTicketsSent uint64
+	echAccepted        bool
+	// echOuterServerName is the server name of the ClientHelloOuter, on
+	// servers that accepted ECH.
+	echOuterServerName string
+	// earlyData is the 0-RTT application data queued by a client with
+	// WriteEarlyData, until it is sent with the first ClientHello.
+	earlyData         []byte
+	earlyDataAccepted bool
+	// earlyDataInput holds the 0-RTT data accepted by a server and not yet
+	// returned by ReadEarlyData. earlyDataLeft is how much more early data
+	// the server will accept, or skip if skipEarlyData is set.
+	earlyDataInput bytes.Buffer
+	earlyDataLeft  int
+	skipEarlyData  bool
+	// pauseForEarlyData asks the server handshake to return after sending its
+	// first flight if 0-RTT data was accepted, setting resumeHandshake to the
+	// rest of the handshake.
+	pauseForEarlyData bool
+	resumeHandshake   func(context.Context) error
+	// externalPSKIdentity is the identity of the external PSK used by the
+	// connection, if any.
+	externalPSKIdentity []byte
+	// covertPayload is the payload of Config.CovertAuth verified by a server.
+	covertPayload []byte
+	// replayedHello is whether Config.ClientHelloReplayFilter flagged the
+	// ClientHello received by a server.
+	replayedHello bool
+	// delegatedCredential is the delegated credential the server signed the
+	// handshake with, on the client side.
+	delegatedCredential *DelegatedCredential
+	// peerRawPublicKey is the SubjectPublicKeyInfo the peer authenticated
+	// with, if it used a raw public key.
+	peerRawPublicKey []byte
+	// postHandshakeTranscript is the handshake transcript through the client
+	// Finished, which post-handshake authentication extends, or nil if the
+	// client didn't offer it. See RFC 8446, Section 4.6.2.
+	postHandshakeTranscript hash.Hash
+
+	// certRequestMu protects certRequest, the post-handshake certificate
+	// request the server is waiting on, and certRequests, the number of
+	// requests sent, which makes their contexts unique.
+	certRequestMu sync.Mutex
+	certRequest   *postHandshakeCertRequest
+	certRequests  uint64
 
 	// ticketKeys is the set of active session ticket keys for this
 	// connection. The first one is used to encrypt new tickets and
 	// all are tried to decrypt tickets.
 	ticketKeys []ticketKey
 
+	// kernelInput reads the records decrypted by the kernel, if c.in.kernel
+	// is set.
+	kernelInput *kernelReader
+
 	// clientFinishedIsFirst is true if the client sent the first Finished
 	// message during the most recent handshake. This is recorded because
 	// the first transmitted Finished message is the tls-unique
 	// channel-binding value.
 	clientFinishedIsFirst bool
 
+	// keyLogger, if not nil, is the KeyLogger set with SetKeyLogger.
+	keyLogger KeyLogger
+	// trace is the ConnTrace of the context of the handshake, if any.
+	trace *ConnTrace
+	// handshakeStart is when the last handshake started.
+	handshakeStart time.Time
+	// qlog writes the events of the connection for Config.QlogWriter.
+	qlog *qlogger
+	// handshakeMessages are the messages retained for HandshakeMessages.
+	handshakeMessages []HandshakeMessage
+	// peerHelloExtensions are the extensions of the last ClientHello or
+	// ServerHello of the peer, and peerALPNProtocols the protocols the
+	// client offered, on servers.
+	peerHelloExtensions []uint16
+	peerALPNProtocols   []string
+	// earlyDataOffered is whether the first ClientHello offered 0-RTT data.
+	earlyDataOffered bool
+	// keyUpdatesSent and keyUpdatesReceived count the KeyUpdate messages
+	// since the handshake. They are read without the locks held by
+	// handleKeyUpdate.
+	keyUpdatesSent, keyUpdatesReceived atomic.Uint32
+	// arena allocates the handshake messages read while a handshake is in
+	// progress. It is nil otherwise.
+	arena *handshakeArena
+
+	// falseStart is the state of a TLS 1.2 client handshake which returned
+	// before reading the server's Finished message, see Config.FalseStart.
+	falseStart *clientHandshakeState
+
 	// closeNotifyErr is any error from sending the alertCloseNotify record.
 	closeNotifyErr error
 	// closeNotifySent is true if the Conn attempted to send an
@@ -104,14 +221,28 @@ type Conn struct {
 	in, out   halfConn
 	rawInput  bytes.Buffer // raw input, starting with a record header
 	input     bytes.Reader // application data waiting to be read, from rawInput.Next
+	inputData []byte       // the data c.input reads from
 	hand      bytes.Buffer // handshake data waiting to be read
 	buffering bool         // whether records are buffered in sendBuf
 	sendBuf   []byte       // a buffer of records waiting to be sent
 
+	// flushTimer sends the records of coalesced writes, see
+	// Config.WriteCoalescingWindow. flushScheduled is whether it's running.
+	// Both are protected by out.Mutex.
+	flushTimer     *time.Timer
+	flushScheduled bool
+
+	// rawInputBuf and handBuf hold the storage of rawInput and hand while
+	// it's borrowed from recordBufPool.
+	rawInputBuf, handBuf *[]byte
+
 	// bytesSent counts the bytes of application data sent.
 	// packetsSent counts packets.
 	bytesSent   int64
 	packetsSent int64
+	// lastRecordTime is when maxPayloadSizeForWrite was last called, if
+	// Config.DynamicRecordSizingIdleTimeout is set.
+	lastRecordTime time.Time
 
 	// retryCount counts the number of consecutive non-advancing records
 	// received by Conn.readRecord. That is, records that neither advance the
@@ -122,6 +253,10 @@ type Conn struct {
 	// the rest of the bits are the number of goroutines in Conn.Write.
 	activeCall atomic.Int32
 
+	// writeWait interrupts the delays of the records sent, when the write
+	// deadline passes or Close is called.
+	writeWait writeWaiter
+
 	tmp [16]byte
 }
 
@@ -143,7 +278,11 @@ func (c *Conn) RemoteAddr() net.Addr {
 // A zero value for t means [Conn.Read] and [Conn.Write] will not time out.
 // After a Write has timed out, the TLS state is corrupt and all future writes will return the same error.
 func (c *Conn) SetDeadline(t time.Time) error {
-	return c.conn.SetDeadline(t)
+	if err := c.conn.SetDeadline(t); err != nil {
+		return err
+	}
+	c.writeWait.setDeadline(t)
+	return nil
 }
 
 // SetReadDeadline sets the read deadline on the underlying connection.
@@ -156,7 +295,11 @@ func (c *Conn) SetReadDeadline(t time.Time) error {
 // A zero value for t means [Conn.Write] will not time out.
 // After a [Conn.Write] has timed out, the TLS state is corrupt and all future writes will return the same error.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
-	return c.conn.SetWriteDeadline(t)
+	if err := c.conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	c.writeWait.setDeadline(t)
+	return nil
 }
 
 // NetConn returns the underlying connection that is wrapped by c.
@@ -166,6 +309,36 @@ func (c *Conn) NetConn() net.Conn {
 	return c.conn
 }
 
+// Hijack returns the underlying connection once the handshake is complete,
+// for protocols exchanging their own records after a real TLS handshake,
+// like ShadowTLS. It also returns the bytes already read from the connection
+// but not processed, which precede the next reads. c must not be used
+// afterwards, not even to Close it, since the close_notify alert would
+// corrupt the records of the protocol.
+//
+// Hijack fails if c has already decrypted application data or handshake
+// messages which haven't been read, or if kernel TLS took over either
+// direction of the connection, whose records the kernel then protects.
+func (c *Conn) Hijack() (net.Conn, []byte, error) {
+	if !c.isHandshakeComplete.Load() {
+		return nil, nil, errors.New("tls: Hijack called before the handshake completed")
+	}
+	c.in.Lock()
+	defer c.in.Unlock()
+	c.out.Lock()
+	kernel := c.in.kernel || c.out.kernel
+	c.out.Unlock()
+	if kernel {
+		return nil, nil, errors.New("tls: Hijack called with kernel TLS enabled")
+	}
+	if c.input.Len() != 0 || c.hand.Len() != 0 {
+		return nil, nil, errors.New("tls: Hijack called with decrypted data pending")
+	}
+	buffered := bytes.Clone(c.rawInput.Bytes())
+	c.rawInput.Reset()
+	return c.conn, buffered, nil
+}
+
 // A halfConn represents one direction of the record layer
 // connection, either sending or receiving.
 type halfConn struct {
@@ -182,8 +355,26 @@ type halfConn struct {
 	nextCipher any       // next encryption state
 	nextMac    hash.Hash // next MAC algorithm
 
+	// encryptThenMAC is whether the CBC records are protected with
+	// encrypt-then-MAC, see RFC 7366.
+	encryptThenMAC, nextEncryptThenMAC bool
+
 	level         QUICEncryptionLevel // current QUIC encryption level
 	trafficSecret []byte              // current TLS 1.3 traffic secret
+
+	// key and iv are the AEAD key and fixed nonce of cipher, kept to hand
+	// the record layer over to the kernel. See ktls.go.
+	key, iv         []byte
+	nextKey, nextIV []byte
+	// kernel is set once the kernel encrypts or decrypts the records.
+	kernel bool
+
+	// padding is the number of zero bytes encrypt adds to the next TLS 1.3
+	// record, from Config.PaddingPolicy.
+	padding int
+
+	// epoch is the number of times the keys changed, see RecordInfo.
+	epoch int
 }
 
 type permanentError struct {
@@ -220,22 +411,42 @@ func (hc *halfConn) changeCipherSpec() error {
 	}
 	hc.cipher = hc.nextCipher
 	hc.mac = hc.nextMac
+	hc.encryptThenMAC = hc.nextEncryptThenMAC
+	hc.key, hc.iv = hc.nextKey, hc.nextIV
 	hc.nextCipher = nil
 	hc.nextMac = nil
+	hc.nextEncryptThenMAC = false
+	hc.nextKey, hc.nextIV = nil, nil
+	hc.epoch++
 	for i := range hc.seq {
 		hc.seq[i] = 0
 	}
 	return nil
 }
 
+// discardTrafficSecret drops the TLS 1.3 keys, returning the record layer to
+// plaintext. It's used by clients that need to resend their ClientHello after
+// having installed the 0-RTT keys.
+func (hc *halfConn) discardTrafficSecret() {
+	hc.trafficSecret = nil
+	hc.level = QUICEncryptionLevelInitial
+	hc.cipher = nil
+	hc.key, hc.iv = nil, nil
+	for i := range hc.seq {
+		hc.seq[i] = 0
+	}
+}
+
 // setTrafficSecret sets the traffic secret for the given encryption level. setTrafficSecret
 // should not be called directly, but rather through the Conn setWriteTrafficSecret and
 // setReadTrafficSecret wrapper methods.
-func (hc *halfConn) setTrafficSecret(suite *cipherSuiteTLS13, level QUICEncryptionLevel, secret []byte) {
+func (hc *halfConn) setTrafficSecret(suite *cipherSuiteTLS13, level QUICEncryptionLevel, secret []byte, engine AEADEngine) {
 	hc.trafficSecret = secret
 	hc.level = level
 	key, iv := suite.trafficKey(secret)
-	hc.cipher = suite.aead(key, iv)
+	hc.cipher = newRecordAEAD(engine, VersionTLS13, suite.id, key, iv, suite.aead)
+	hc.key, hc.iv = key, iv
+	hc.epoch++
 	for i := range hc.seq {
 		hc.seq[i] = 0
 	}
@@ -270,8 +481,9 @@ func (hc *halfConn) explicitNonceLen() int {
 	case aead:
 		return c.explicitNonceLen()
 	case cbcMode:
-		// TLS 1.1 introduced a per-record explicit IV to fix the BEAST attack.
-		if hc.version >= VersionTLS11 {
+		// TLS 1.1 introduced a per-record explicit IV to fix the BEAST attack,
+		// and TLCP adopted it.
+		if hc.version >= VersionTLS11 || hc.version == VersionTLCP {
 			return c.BlockSize()
 		}
 		return 0
@@ -353,6 +565,11 @@ func (hc *halfConn) decrypt(record []byte) ([]byte, recordType, error) {
 		return payload, typ, nil
 	}
 
+	// Records read from the kernel are already decrypted.
+	if hc.kernel {
+		return payload, typ, nil
+	}
+
 	paddingGood := byte(255)
 	paddingLen := 0
 
@@ -389,9 +606,25 @@ func (hc *halfConn) decrypt(record []byte) ([]byte, recordType, error) {
 			}
 		case cbcMode:
 			blockSize := c.BlockSize()
-			minPayload := explicitNonceLen + roundUp(hc.mac.Size()+1, blockSize)
-			if len(payload)%blockSize != 0 || len(payload) < minPayload {
-				return nil, 0, alertBadRecordMAC
+			if hc.encryptThenMAC {
+				// The MAC follows the IV and ciphertext, and is checked
+				// before decrypting them. See RFC 7366, Section 3.
+				n := len(payload) - hc.mac.Size()
+				if n < explicitNonceLen+blockSize || n%blockSize != 0 {
+					return nil, 0, alertBadRecordMAC
+				}
+				record[3] = byte(n >> 8)
+				record[4] = byte(n)
+				localMAC := tls10MAC(hc.mac, hc.scratchBuf[:0], hc.seq[:], record[:recordHeaderLen], payload[:n], nil)
+				if subtle.ConstantTimeCompare(localMAC, payload[n:]) != 1 {
+					return nil, 0, alertBadRecordMAC
+				}
+				payload = payload[:n]
+			} else {
+				minPayload := explicitNonceLen + roundUp(hc.mac.Size()+1, blockSize)
+				if len(payload)%blockSize != 0 || len(payload) < minPayload {
+					return nil, 0, alertBadRecordMAC
+				}
 			}
 
 			if explicitNonceLen > 0 {
@@ -400,12 +633,14 @@ func (hc *halfConn) decrypt(record []byte) ([]byte, recordType, error) {
 			}
 			c.CryptBlocks(payload, payload)
 
-			// In a limited attempt to protect against CBC padding oracles like
-			// Lucky13, the data past paddingLen (which is secret) is passed to
-			// the MAC function as extra data, to be fed into the HMAC after
-			// computing the digest. This makes the MAC roughly constant time as
-			// long as the digest computation is constant time and does not
-			// affect the subsequent write, modulo cache effects.
+			// To protect against CBC padding oracles like Lucky13, the MAC
+			// is checked in constant time with respect to paddingLen, which
+			// is secret, see recordMAC.sumCBC. For hashes it doesn't support,
+			// the data past paddingLen is passed to the MAC function as extra
+			// data, to be fed into the HMAC after computing the digest. This
+			// makes the MAC roughly constant time as long as the digest
+			// computation is constant time and does not affect the subsequent
+			// write, modulo cache effects.
 			paddingLen, paddingGood = extractPadding(payload)
 		default:
 			panic("unknown cipher type")
@@ -434,7 +669,13 @@ func (hc *halfConn) decrypt(record []byte) ([]byte, recordType, error) {
 		plaintext = payload
 	}
 
-	if hc.mac != nil {
+	if hc.mac != nil && hc.encryptThenMAC {
+		// The MAC was already checked, so the padding can't be an oracle.
+		if paddingGood != 255 {
+			return nil, 0, alertBadRecordMAC
+		}
+		plaintext = payload[:len(payload)-paddingLen]
+	} else if hc.mac != nil {
 		macSize := hc.mac.Size()
 		if len(payload) < macSize {
 			return nil, 0, alertBadRecordMAC
@@ -444,8 +685,17 @@ func (hc *halfConn) decrypt(record []byte) ([]byte, recordType, error) {
 		n = subtle.ConstantTimeSelect(int(uint32(n)>>31), 0, n) // if n < 0 { n = 0 }
 		record[3] = byte(n >> 8)
 		record[4] = byte(n)
-		remoteMAC := payload[n : n+macSize]
-		localMAC := tls10MAC(hc.mac, hc.scratchBuf[:0], hc.seq[:], record[:recordHeaderLen], payload[:n], payload[n+macSize:])
+		var remoteMAC, localMAC []byte
+		if mac, ok := hc.mac.(*recordMAC); ok && mac.constantTime {
+			// Neither the MAC computation nor its copy depend on n.
+			var remoteMACBuf [macBlockSize]byte
+			remoteMAC = remoteMACBuf[:macSize]
+			copyMACCBC(remoteMAC, payload, n)
+			localMAC = mac.sumCBC(hc.scratchBuf[:0], hc.seq[:], record[:recordHeaderLen], payload, n)
+		} else {
+			remoteMAC = payload[n : n+macSize]
+			localMAC = tls10MAC(hc.mac, hc.scratchBuf[:0], hc.seq[:], record[:recordHeaderLen], payload[:n], payload[n+macSize:])
+		}
 
 		// This is equivalent to checking the MACs and paddingGood
 		// separately, but in constant-time to prevent distinguishing
@@ -527,8 +777,11 @@ func (hc *halfConn) encrypt(record, payload []byte, rand io.Reader) ([]byte, err
 			// Encrypt the actual ContentType and replace the plaintext one.
 			record = append(record, record[0])
 			record[0] = byte(recordTypeApplicationData)
+			for ; hc.padding > 0; hc.padding-- {
+				record = append(record, 0)
+			}
 
-			n := len(payload) + 1 + c.Overhead()
+			n := len(record) - recordHeaderLen + c.Overhead()
 			record[3] = byte(n >> 8)
 			record[4] = byte(n)
 
@@ -540,6 +793,26 @@ func (hc *halfConn) encrypt(record, payload []byte, rand io.Reader) ([]byte, err
 			record = c.Seal(record, nonce, payload, additionalData)
 		}
 	case cbcMode:
+		if hc.encryptThenMAC {
+			blockSize := c.BlockSize()
+			paddingLen := blockSize - len(payload)%blockSize
+			record, dst = sliceForAppend(record, len(payload)+paddingLen)
+			copy(dst, payload)
+			for i := len(payload); i < len(dst); i++ {
+				dst[i] = byte(paddingLen - 1)
+			}
+			if len(explicitNonce) > 0 {
+				c.SetIV(explicitNonce)
+			}
+			c.CryptBlocks(dst, dst)
+			// The MAC covers the header with the length of the IV and
+			// ciphertext, followed by them. See RFC 7366, Section 3.
+			n := len(record) - recordHeaderLen
+			record[3] = byte(n >> 8)
+			record[4] = byte(n)
+			record = tls10MAC(hc.mac, record, hc.seq[:], record[:recordHeaderLen], record[recordHeaderLen:], nil)
+			break
+		}
 		mac := tls10MAC(hc.mac, hc.scratchBuf[:0], hc.seq[:], record[:recordHeaderLen], payload, nil)
 		blockSize := c.BlockSize()
 		plaintextLen := len(payload) + len(mac)
@@ -623,19 +896,24 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		return c.in.setErrorLocked(errors.New("tls: internal error: attempted to read record with pending application data"))
 	}
 	c.input.Reset(nil)
+	c.inputData = nil
 
 	if c.quic != nil {
 		return c.in.setErrorLocked(errors.New("tls: internal error: attempted to read record with QUIC transport"))
 	}
 
 	// Read header, payload.
-	if err := c.readFromUntil(c.conn, recordHeaderLen); err != nil {
+	if err := c.readFromUntil(c.recordReader(), recordHeaderLen); err != nil {
 		// RFC 8446, Section 6.1 suggests that EOF without an alertCloseNotify
 		// is an error, but popular web sites seem to do this, so we accept it
 		// if and only if at the record boundary.
 		if err == io.ErrUnexpectedEOF && c.rawInput.Len() == 0 {
 			err = io.EOF
 		}
+		// The kernel failed to decrypt the next record.
+		if err == alertBadRecordMAC && c.in.kernel {
+			return c.in.setErrorLocked(c.sendAlert(alertBadRecordMAC))
+		}
 		if e, ok := err.(net.Error); !ok || !e.Temporary() {
 			c.in.setErrorLocked(err)
 		}
@@ -680,7 +958,7 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		msg := fmt.Sprintf("oversized record received with length %d", n)
 		return c.in.setErrorLocked(c.newRecordHeaderError(nil, msg))
 	}
-	if err := c.readFromUntil(c.conn, recordHeaderLen+n); err != nil {
+	if err := c.readFromUntil(c.recordReader(), recordHeaderLen+n); err != nil {
 		if e, ok := err.(net.Error); !ok || !e.Temporary() {
 			c.in.setErrorLocked(err)
 		}
@@ -688,19 +966,43 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 	}
 
 	// Process message.
+	if err := c.checkCBCRecordLimit(&c.in); err != nil {
+		return c.in.setErrorLocked(err)
+	}
 	record := c.rawInput.Next(recordHeaderLen + n)
+	seq := c.in.seqNum()
 	data, typ, err := c.in.decrypt(record)
 	if err != nil {
+		if c.skipEarlyData && err == alertBadRecordMAC &&
+			recordType(record[0]) == recordTypeApplicationData && c.skipEarlyDataRecord(n) {
+			return nil
+		}
 		return c.in.setErrorLocked(c.sendAlert(err.(alert)))
 	}
+	if c.config.RecordObserver != nil || c.qlog != nil {
+		c.observeRecord(&c.in, typ, seq, len(record), data)
+	}
 	if len(data) > maxPlaintext {
 		return c.in.setErrorLocked(c.sendAlert(alertRecordOverflow))
 	}
+	// The limit isn't enforced during the handshake, when the peer may
+	// not know it yet, like with 0-RTT data.
+	if c.recordSizeLimit != 0 && len(data) > c.recordSizeLimit && c.isHandshakeComplete.Load() {
+		return c.in.setErrorLocked(c.sendAlert(alertRecordOverflow))
+	}
 
 	// Application Data messages are always protected.
 	if c.in.cipher == nil && typ == recordTypeApplicationData {
+		if c.skipEarlyData && c.skipEarlyDataRecord(n) {
+			return nil
+		}
 		return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 	}
+	if c.in.cipher != nil && recordType(record[0]) == recordTypeApplicationData {
+		// The first record protected with the handshake keys ends any
+		// rejected 0-RTT data.
+		c.skipEarlyData = false
+	}
 
 	if typ != recordTypeAlert && typ != recordTypeChangeCipherSpec && len(data) > 0 {
 		// This is a state-advancing message: reset the retry count.
@@ -723,6 +1025,7 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		if len(data) != 2 {
 			return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 		}
+		c.observeAlert(false, data[0], alert(data[1]))
 		if alert(data[1]) == alertCloseNotify {
 			return c.in.setErrorLocked(io.EOF)
 		}
@@ -736,14 +1039,14 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 				// Like TLS 1.2 alertLevelWarning alerts, we drop the record and retry.
 				return c.retryReadRecord(expectChangeCipherSpec)
 			}
-			return c.in.setErrorLocked(&net.OpError{Op: "remote error", Err: alert(data[1])})
+			return c.in.setErrorLocked(alertError(alert(data[1]), false))
 		}
 		switch data[0] {
 		case alertLevelWarning:
 			// Drop the record on the floor and retry.
 			return c.retryReadRecord(expectChangeCipherSpec)
 		case alertLevelError:
-			return c.in.setErrorLocked(&net.OpError{Op: "remote error", Err: alert(data[1])})
+			return c.in.setErrorLocked(alertError(alert(data[1]), false))
 		default:
 			return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 		}
@@ -772,6 +1075,14 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		}
 
 	case recordTypeApplicationData:
+		if c.in.level == QUICEncryptionLevelEarly && !c.isClient {
+			if len(data) > c.earlyDataLeft {
+				return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+			}
+			c.earlyDataLeft -= len(data)
+			c.earlyDataInput.Write(data)
+			return nil
+		}
 		if !handshakeComplete || expectChangeCipherSpec {
 			return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 		}
@@ -784,17 +1095,35 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		// to avoid copying the plaintext. This is safe because c.rawInput is
 		// not read from or written to until c.input is drained.
 		c.input.Reset(data)
+		c.inputData = data
 
 	case recordTypeHandshake:
 		if len(data) == 0 || expectChangeCipherSpec {
 			return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 		}
+		getRecordBuf(&c.hand, &c.handBuf)
 		c.hand.Write(data)
 	}
 
 	return nil
 }
 
+// skipEarlyDataRecord reports whether a server that rejected 0-RTT data can
+// discard a record with an n bytes payload as part of it. See RFC 8446,
+// Section 4.2.10.
+func (c *Conn) skipEarlyDataRecord(n int) bool {
+	// The record may have failed to decrypt, so estimate the plaintext size by
+	// removing the inner content type and the AEAD tag.
+	if n -= 1 + 16; n < 0 {
+		n = 0
+	}
+	if n > c.earlyDataLeft {
+		return false
+	}
+	c.earlyDataLeft -= n
+	return true
+}
+
 // retryReadRecord recurs into readRecordOrCCS to drop a non-advancing record, like
 // a warning alert, empty application_data, or a change_cipher_spec in TLS 1.3.
 func (c *Conn) retryReadRecord(expectChangeCipherSpec bool) error {
@@ -839,15 +1168,28 @@ func (c *Conn) readFromUntil(r io.Reader, n int) error {
 	// There might be extra input waiting on the wire. Make a best effort
 	// attempt to fetch it so that it can be used in (*Conn).Read to
 	// "predict" closeNotify alerts.
+	if c.rawInputBuf == nil && c.rawInput.Cap() == 0 {
+		// Don't hold a buffer while an idle connection waits for input.
+		if sc, ok := r.(syscall.Conn); ok {
+			if rawConn, err := sc.SyscallConn(); err == nil {
+				waitReadable(rawConn)
+			}
+		}
+	}
+	getRecordBuf(&c.rawInput, &c.rawInputBuf)
 	c.rawInput.Grow(needs + bytes.MinRead)
+	read := c.rawInput.Len()
 	_, err := c.rawInput.ReadFrom(&atLeastReader{r, int64(needs)})
+	if c.fallbackInput != nil {
+		c.fallbackInput = append(c.fallbackInput, c.rawInput.Bytes()[read:]...)
+	}
 	return err
 }
 
 // sendAlertLocked sends a TLS alert message.
 func (c *Conn) sendAlertLocked(err alert) error {
-	if c.quic != nil {
-		return c.out.setErrorLocked(&net.OpError{Op: "local error", Err: err})
+	if c.quic != nil || c.canFallBack() {
+		return c.out.setErrorLocked(alertError(err, true))
 	}
 
 	switch err {
@@ -859,12 +1201,41 @@ func (c *Conn) sendAlertLocked(err alert) error {
 	c.tmp[1] = byte(err)
 
 	_, writeErr := c.writeRecordLocked(recordTypeAlert, c.tmp[0:2])
+	if writeErr == nil {
+		c.observeAlert(true, c.tmp[0], err)
+	}
 	if err == alertCloseNotify {
 		// closeNotify is a special case in that it isn't an error.
 		return writeErr
 	}
 
-	return c.out.setErrorLocked(&net.OpError{Op: "local error", Err: err})
+	return c.out.setErrorLocked(alertError(err, true))
+}
+
+// wrapSentAlert returns err wrapping the alert c sent, if any and if it
+// doesn't wrap an alert already, with the text of err. The errors of the
+// callbacks of the Config, recorded by callbackError, and those wrapping
+// them, are returned as is.
+func (c *Conn) wrapSentAlert(err error) error {
+	if _, ok := errorsAsType[*DirectedAlertError](err); ok || c.callbackErr != nil && errors.Is(err, c.callbackErr) {
+		return err
+	}
+	c.out.Lock()
+	sent, ok := errorsAsType[*DirectedAlertError](c.out.err)
+	c.out.Unlock()
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("%w%.0w", err, sent)
+}
+
+// callbackError records err, if not nil, as returned by a callback of the
+// Config, for the handshake to return it as is, and returns it.
+func (c *Conn) callbackError(err error) error {
+	if err != nil {
+		c.callbackErr = err
+	}
+	return err
 }
 
 // sendAlert sends a TLS alert message.
@@ -882,9 +1253,9 @@ const (
 	// bytes) and a TCP header with timestamps (32 bytes).
 	tcpMSSEstimate = 1208
 
-	// recordSizeBoostThreshold is the number of bytes of application data
-	// sent after which the TLS record size will be increased to the
-	// maximum.
+	// recordSizeBoostThreshold is the default number of bytes of
+	// application data sent after which the TLS record size will be
+	// increased to the maximum.
 	recordSizeBoostThreshold = 128 * 1024
 )
 
@@ -902,14 +1273,23 @@ const (
 // Performance Web Networking", Chapter 4, or:
 // https://www.igvita.com/2013/10/24/optimizing-tls-record-size-and-buffering-latency/
 //
-// In the interests of simplicity and determinism, this code does not attempt
-// to reset the record size once the connection is idle, however.
+// The threshold is Config.DynamicRecordSizingThreshold, and the record size
+// is only reset once the connection is idle if
+// Config.DynamicRecordSizingIdleTimeout is set.
 func (c *Conn) maxPayloadSizeForWrite(typ recordType) int {
 	if c.config.DynamicRecordSizingDisabled || typ != recordTypeApplicationData {
 		return maxPlaintext
 	}
 
-	if c.bytesSent >= recordSizeBoostThreshold {
+	if idle := c.config.DynamicRecordSizingIdleTimeout; idle > 0 {
+		now := c.config.time()
+		if !c.lastRecordTime.IsZero() && now.Sub(c.lastRecordTime) >= idle {
+			c.bytesSent, c.packetsSent = 0, 0
+		}
+		c.lastRecordTime = now
+	}
+
+	if c.bytesSent >= c.config.dynamicRecordSizingThreshold() {
 		return maxPlaintext
 	}
 
@@ -957,6 +1337,18 @@ func (c *Conn) write(data []byte) (int, error) {
 		return len(data), nil
 	}
 
+	if len(c.sendBuf) > 0 {
+		// Send the records left by a coalesced Write first.
+		bufs := net.Buffers{c.sendBuf, data}
+		n, err := bufs.WriteTo(c.conn)
+		c.bytesSent += n
+		c.sendBuf = nil
+		if err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
 	n, err := c.conn.Write(data)
 	c.bytesSent += int64(n)
 	return n, err
@@ -974,7 +1366,62 @@ func (c *Conn) flush() (int, error) {
 	return n, err
 }
 
-// outBufPool pools the record-sized scratch buffers used by writeRecordLocked.
+// recordBufSize is the size of the buffers in recordBufPool, which fit a full
+// record and the extra input readFromUntil makes room for.
+const recordBufSize = recordHeaderLen + maxCiphertext + bytes.MinRead
+
+// recordBufPool pools the storage of the input buffers of idle connections.
+var recordBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, recordBufSize)
+		return &b
+	},
+}
+
+// getRecordBuf makes b use a buffer from recordBufPool, stored in *p, if b
+// has no storage yet.
+func getRecordBuf(b *bytes.Buffer, p **[]byte) {
+	if *p != nil || b.Cap() != 0 {
+		return
+	}
+	*p = recordBufPool.Get().(*[]byte)
+	*b = *bytes.NewBuffer((**p)[:0])
+}
+
+// putRecordBuf returns the storage of b to recordBufPool if b is empty and
+// its storage came from the pool.
+func putRecordBuf(b *bytes.Buffer, p **[]byte) {
+	if *p == nil || b.Len() != 0 {
+		return
+	}
+	b.Reset()
+	buf := b.Bytes()
+	*b = bytes.Buffer{}
+	// Don't keep the buffers that grew for unusually large messages.
+	if cap(buf) <= 2*recordBufSize {
+		**p = buf
+		recordBufPool.Put(*p)
+	}
+	*p = nil
+}
+
+// releaseInputBuffers returns the input buffers that don't hold any pending
+// data to recordBufPool. c.in must be locked.
+func (c *Conn) releaseInputBuffers() {
+	if c.input.Len() == 0 {
+		// c.input points into c.rawInput.
+		c.input.Reset(nil)
+		c.inputData = nil
+		putRecordBuf(&c.rawInput, &c.rawInputBuf)
+	}
+	putRecordBuf(&c.hand, &c.handBuf)
+}
+
+// writeBatchSize is the number of bytes of records writeRecordLocked
+// accumulates before writing them to the connection.
+const writeBatchSize = 64 * 1024
+
+// outBufPool pools the scratch buffers used by writeRecordLocked.
 var outBufPool = sync.Pool{
 	New: func() any {
 		return new([]byte)
@@ -996,6 +1443,9 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		}
 		return len(data), nil
 	}
+	if c.out.kernel {
+		return c.writeKernelRecordLocked(typ, data)
+	}
 
 	outBufPtr := outBufPool.Get().(*[]byte)
 	outBuf := *outBufPtr
@@ -1009,17 +1459,65 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		outBufPool.Put(outBufPtr)
 	}()
 
-	var n int
+	// Records are encrypted back to back in outBuf and written together,
+	// up to writeBatchSize bytes at a time.
+	outBuf = outBuf[:0]
+	var n, written int
 	for len(data) > 0 {
 		m := len(data)
-		if maxPayload := c.maxPayloadSizeForWrite(typ); m > maxPayload {
+		maxPayload := c.maxPayloadSizeForWrite(typ)
+		if c.peerRecordSizeLimit != 0 && c.out.cipher != nil && maxPayload > c.peerRecordSizeLimit {
+			maxPayload = c.peerRecordSizeLimit
+		}
+		if m > maxPayload {
 			m = maxPayload
 		}
+		for len(c.recordSplits) > 0 && c.recordSplits[0] <= n {
+			c.recordSplits = c.recordSplits[1:]
+		}
+		if len(c.recordSplits) > 0 && n+m > c.recordSplits[0] {
+			m = c.recordSplits[0] - n
+		}
+		length, dummy, ok := c.scriptedRecord(typ, maxPayload)
+		if !ok {
+			length, dummy, ok = c.morphedRecord(typ, len(data), maxPayload)
+		}
+		if ok {
+			if dummy {
+				m = 0
+			} else if m > length {
+				m = length
+			}
+			c.out.padding = length - m
+		} else {
+			c.out.padding = c.recordPadding(typ, m, maxPayload)
+		}
+		if delay := c.recordDelay(typ); delay > 0 {
+			if len(outBuf) > 0 {
+				if _, err := c.write(outBuf); err != nil {
+					return written, err
+				}
+				outBuf = outBuf[:0]
+				written = n
+			}
+			if err := c.writeWait.wait(delay); err != nil {
+				return written, err
+			}
+		}
 
-		_, outBuf = sliceForAppend(outBuf[:0], recordHeaderLen)
-		outBuf[0] = byte(typ)
+		start := len(outBuf)
+		var record []byte
+		outBuf, record = sliceForAppend(outBuf, recordHeaderLen)
+		record[0] = byte(typ)
 		vers := c.vers
-		if vers == 0 {
+		if vers == 0 && c.out.version == VersionTLS13 {
+			// 0-RTT data is sent before the version is negotiated.
+			vers = VersionTLS13
+		}
+		if vers == 0 && c.isClient && c.config.TLCP && c.quic == nil {
+			// TLCP servers expect TLCP records from the ClientHello on.
+			vers = VersionTLCP
+		} else if vers == 0 {
 			// Some TLS servers fail if the record version is
 			// greater than TLS 1.0 for the initial ClientHello.
 			vers = VersionTLS10
@@ -1028,24 +1526,43 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 			// See RFC 8446, Section 5.1.
 			vers = VersionTLS12
 		}
-		outBuf[1] = byte(vers >> 8)
-		outBuf[2] = byte(vers)
-		outBuf[3] = byte(m >> 8)
-		outBuf[4] = byte(m)
+		record[1] = byte(vers >> 8)
+		record[2] = byte(vers)
+		record[3] = byte(m >> 8)
+		record[4] = byte(m)
 
-		var err error
-		outBuf, err = c.out.encrypt(outBuf, data[:m], c.config.rand())
+		if err := c.checkCBCRecordLimit(&c.out); err != nil {
+			return written, c.out.setErrorLocked(err)
+		}
+		seq := c.out.seqNum()
+		record, err := c.out.encrypt(outBuf[start:], data[:m], c.config.rand())
 		if err != nil {
-			return n, err
+			return written, err
 		}
-		if _, err := c.write(outBuf); err != nil {
-			return n, err
+		if c.config.RecordObserver != nil || c.qlog != nil {
+			c.observeRecord(&c.out, typ, seq, len(record), data[:m])
 		}
+		// encrypt appends in place, unless it had to grow the buffer.
+		outBuf = append(outBuf[:start], record...)
 		n += m
 		data = data[m:]
+
+		if len(outBuf) >= writeBatchSize || len(data) == 0 {
+			var err error
+			if f := c.config.ClientHelloFragmentation; f != nil && typ == recordTypeHandshake && c.isClient && c.vers == 0 {
+				_, err = c.writeFragmented(f, outBuf)
+			} else {
+				_, err = c.write(outBuf)
+			}
+			if err != nil {
+				return written, err
+			}
+			outBuf = outBuf[:0]
+			written = n
+		}
 	}
 
-	if typ == recordTypeChangeCipherSpec && c.vers != VersionTLS13 {
+	if typ == recordTypeChangeCipherSpec && c.out.version != VersionTLS13 {
 		if err := c.out.changeCipherSpec(); err != nil {
 			return n, c.sendAlertLocked(err.(alert))
 		}
@@ -1065,13 +1582,44 @@ func (c *Conn) writeHandshakeRecord(msg handshakeMessage, transcript transcriptH
 	if err != nil {
 		return 0, err
 	}
+	if c.config.HandshakeTransform != nil && c.canTransform(msg) {
+		if data, err = c.config.HandshakeTransform(data); err != nil {
+			return 0, err
+		}
+		if len(data) < 4 {
+			return 0, errors.New("tls: HandshakeTransform returned a truncated message")
+		}
+		if hello, ok := msg.(*clientHelloMsg); ok {
+			// For the transcript of the handshake.
+			hello.original = slicesClone(data)
+		}
+	}
 	if transcript != nil {
 		transcript.Write(data)
 	}
+	c.retainHandshakeMessage(true, msg, data)
+	c.qlog.handshakeMessage(true, data)
 
+	if _, ok := msg.(*clientHelloMsg); ok && c.config.SplitClientHelloAtServerName && c.quic == nil {
+		if start, end, ok := serverNameBounds(data); ok {
+			c.recordSplits = []int{start, end}
+			defer func() { c.recordSplits = nil }()
+		}
+	}
 	return c.writeRecordLocked(recordTypeHandshake, data)
 }
 
+// canTransform reports whether Config.HandshakeTransform may change msg.
+func (c *Conn) canTransform(msg handshakeMessage) bool {
+	switch msg := msg.(type) {
+	case *clientHelloMsg:
+		return len(msg.pskBinders) == 0 && len(msg.encryptedClientHello) == 0
+	case *serverHelloMsg:
+		return !c.echAccepted
+	}
+	return true
+}
+
 // writeChangeCipherRecord writes a ChangeCipherSpec message to the connection and
 // updates the record layer state.
 func (c *Conn) writeChangeCipherRecord() error {
@@ -1107,7 +1655,7 @@ func (c *Conn) readHandshake(transcript transcriptHash) (any, error) {
 	// hasVers indicates we're past the first message, forcing someone trying to
 	// make us just allocate a large buffer to at least do the initial part of
 	// the handshake first.
-	if c.haveVers && data[0] == typeCertificate {
+	if c.haveVers && (data[0] == typeCertificate || data[0] == typeCompressedCertificate) {
 		// Since certificate messages are likely to be the only messages that
 		// can be larger than maxHandshake, we use a special limit for just
 		// those messages.
@@ -1147,6 +1695,11 @@ func (c *Conn) unmarshalHandshakeMessage(data []byte, transcript transcriptHash)
 		} else {
 			m = new(certificateMsg)
 		}
+	case typeCompressedCertificate:
+		if c.vers != VersionTLS13 {
+			return nil, c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+		}
+		m = new(compressedCertificateMsg)
 	case typeCertificateRequest:
 		if c.vers == VersionTLS13 {
 			m = new(certificateRequestMsgTLS13)
@@ -1182,7 +1735,7 @@ func (c *Conn) unmarshalHandshakeMessage(data []byte, transcript transcriptHash)
 	// The handshake message unmarshalers
 	// expect to be able to keep references to data,
 	// so pass in a fresh copy that won't be overwritten.
-	data = append([]byte(nil), data...)
+	data = c.arena.clone(data)
 
 	if !m.unmarshal(data) {
 		return nil, c.in.setErrorLocked(c.sendAlert(alertDecodeError))
@@ -1191,6 +1744,8 @@ func (c *Conn) unmarshalHandshakeMessage(data []byte, transcript transcriptHash)
 	if transcript != nil {
 		transcript.Write(data)
 	}
+	c.retainHandshakeMessage(false, m, data)
+	c.qlog.handshakeMessage(false, data)
 
 	return m, nil
 }
@@ -1237,6 +1792,21 @@ func (c *Conn) Write(b []byte) (int, error) {
 		return 0, errShutdown
 	}
 
+	if !c.coalescingWrites() {
+		return c.writeApplicationDataLocked(b)
+	}
+	c.buffering = true
+	n, err := c.writeApplicationDataLocked(b)
+	c.buffering = false
+	if err != nil {
+		return n, err
+	}
+	return n, c.scheduleFlushLocked()
+}
+
+// writeApplicationDataLocked writes b in one or more application data
+// records. c.out must be locked.
+func (c *Conn) writeApplicationDataLocked(b []byte) (int, error) {
 	// TLS 1.0 is susceptible to a chosen-plaintext
 	// attack when using block mode ciphers due to predictable IVs.
 	// This can be prevented by splitting each Application Data
@@ -1261,6 +1831,176 @@ func (c *Conn) Write(b []byte) (int, error) {
 	return n + m, c.out.setErrorLocked(err)
 }
 
+// coalescingWrites reports whether Write should leave its records in
+// c.sendBuf, as configured by Config.WriteCoalescingWindow.
+func (c *Conn) coalescingWrites() bool {
+	return c.config.WriteCoalescingWindow > 0 && c.quic == nil && !c.out.kernel && !c.buffering
+}
+
+// scheduleFlushLocked sends the records left in c.sendBuf by coalesced
+// writes if they fill a record, or else arranges for them to be sent when
+// the coalescing window expires. c.out must be locked.
+func (c *Conn) scheduleFlushLocked() error {
+	if len(c.sendBuf) >= maxPlaintext {
+		_, err := c.flush()
+		return c.out.setErrorLocked(err)
+	}
+	if len(c.sendBuf) == 0 || c.flushScheduled {
+		return nil
+	}
+	c.flushScheduled = true
+	if c.flushTimer == nil {
+		c.flushTimer = time.AfterFunc(c.config.WriteCoalescingWindow, c.flushCoalesced)
+	} else {
+		c.flushTimer.Reset(c.config.WriteCoalescingWindow)
+	}
+	return nil
+}
+
+// flushCoalesced sends the records left in c.sendBuf by coalesced writes.
+func (c *Conn) flushCoalesced() {
+	c.out.Lock()
+	defer c.out.Unlock()
+	c.flushScheduled = false
+	// A handshake in progress sends the buffered records itself.
+	if c.buffering || c.out.err != nil {
+		return
+	}
+	if _, err := c.flush(); err != nil {
+		c.out.setErrorLocked(err)
+	}
+}
+
+// ReadFrom implements [io.ReaderFrom]. If the kernel encrypts the outgoing
+// records, see [Config.KernelTX], the data of r is handed directly to the
+// underlying connection, which sends files and sockets with sendfile or
+// splice without copying them through userspace. Otherwise, ReadFrom is
+// equivalent to io.Copy(c, r).
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	// interlock with Close below
+	for {
+		x := c.activeCall.Load()
+		if x&1 != 0 {
+			return 0, net.ErrClosed
+		}
+		if c.activeCall.CompareAndSwap(x, x+2) {
+			break
+		}
+	}
+	defer c.activeCall.Add(-2)
+
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.out.Lock()
+	rf, ok := c.conn.(io.ReaderFrom)
+	if !c.out.kernel || !ok {
+		c.out.Unlock()
+		return io.Copy(writerOnly{c}, r)
+	}
+	defer c.out.Unlock()
+
+	if err := c.out.err; err != nil {
+		return 0, err
+	}
+	if c.closeNotifySent {
+		return 0, errShutdown
+	}
+
+	n, err := rf.ReadFrom(r)
+	c.bytesSent += n
+	return n, c.out.setErrorLocked(err)
+}
+
+// writerOnly hides the ReadFrom method of a Conn from io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// WriteEarlyData queues b to be sent as TLS 1.3 0-RTT application data right
+// after the ClientHello. It can only be used by clients with
+// [Config.EarlyData] set, before the handshake starts, and may be called
+// multiple times to queue more data.
+//
+// The data is sent only if the session being resumed permits early data and
+// all the queued data fits within the limit the server advertised. Once the
+// handshake completes, [ConnectionState.EarlyDataAccepted] reports whether
+// the server accepted it. If it didn't, the data was not delivered and the
+// application should send it again with [Conn.Write].
+func (c *Conn) WriteEarlyData(b []byte) (int, error) {
+	if !c.isClient {
+		return 0, errors.New("tls: WriteEarlyData called on a server connection")
+	}
+	if c.quic != nil {
+		return 0, errors.New("tls: WriteEarlyData called on a QUIC connection")
+	}
+	if c.config == nil || !c.config.EarlyData {
+		return 0, errors.New("tls: WriteEarlyData requires Config.EarlyData")
+	}
+
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+
+	if c.handshakeErr != nil {
+		return 0, c.handshakeErr
+	}
+	if c.isHandshakeComplete.Load() || c.handshakes > 0 {
+		return 0, errors.New("tls: WriteEarlyData called after the handshake")
+	}
+
+	c.earlyData = append(c.earlyData, b...)
+	return len(b), nil
+}
+
+// ReadEarlyData reads TLS 1.3 0-RTT application data accepted by a server,
+// returning [io.EOF] once all of it was read, or if there was none. It can
+// only be used by servers with [Config.EarlyData] and
+// [Config.EarlyDataAntiReplay] set.
+//
+// If called before the handshake, ReadEarlyData runs the handshake until the
+// server sent its first flight, so that early data can be processed before
+// the client completes the handshake. The handshake then resumes on the next
+// call to [Conn.Handshake], [Conn.Read], or [Conn.Write], which buffer any
+// early data not read yet. [ConnectionState.EarlyDataAccepted] reports
+// whether early data was accepted.
+//
+// Early data may be replayed by an attacker, to this server if
+// EarlyDataAntiReplay is not effective across servers, or to others. It
+// should only carry requests that are safe to process more than once.
+func (c *Conn) ReadEarlyData(b []byte) (int, error) {
+	if c.isClient {
+		return 0, errors.New("tls: ReadEarlyData called on a client connection")
+	}
+	if c.quic != nil {
+		return 0, errors.New("tls: ReadEarlyData called on a QUIC connection")
+	}
+
+	c.handshakeMutex.Lock()
+	start := c.handshakeErr == nil && !c.isHandshakeComplete.Load() && c.resumeHandshake == nil
+	c.pauseForEarlyData = start
+	c.handshakeMutex.Unlock()
+	if start {
+		if err := c.Handshake(); err != nil {
+			return 0, err
+		}
+	}
+
+	c.in.Lock()
+	defer c.in.Unlock()
+
+	// The EndOfEarlyData message is left for the handshake to process.
+	for c.earlyDataInput.Len() == 0 && c.in.level == QUICEncryptionLevelEarly && c.hand.Len() == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	if c.earlyDataInput.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.earlyDataInput.Read(b)
+}
+
 // handleRenegotiation processes a HelloRequest handshake message.
 func (c *Conn) handleRenegotiation() error {
 	if c.vers == VersionTLS13 {
@@ -1317,18 +2057,95 @@ func (c *Conn) handlePostHandshakeMessage() error {
 	if err != nil {
 		return err
 	}
+	session, err := c.dispatchPostHandshakeMessage(msg)
+	if err != nil {
+		return err
+	}
+	if session != nil {
+		c.storeSession(session)
+	}
+	return nil
+}
+
+// dispatchPostHandshakeMessage processes a TLS 1.3 post-handshake message.
+// The session resumed by a NewSessionTicket is returned, for the caller to
+// store, rather than stored.
+func (c *Conn) dispatchPostHandshakeMessage(msg any) (*SessionState, error) {
 	c.retryCount++
 	if c.retryCount > maxUselessRecords {
 		c.sendAlert(alertUnexpectedMessage)
-		return c.in.setErrorLocked(errors.New("tls: too many non-advancing records"))
+		return nil, c.in.setErrorLocked(errors.New("tls: too many non-advancing records"))
 	}
 
 	switch msg := msg.(type) {
 	case *newSessionTicketMsgTLS13:
-		return c.handleNewSessionTicket(msg)
+		return c.sessionFromTicket(msg)
 	case *keyUpdateMsg:
-		return c.handleKeyUpdate(msg)
+		return nil, c.handleKeyUpdate(msg)
+	case *certificateRequestMsgTLS13:
+		return nil, c.handleCertificateRequest(msg)
+	case *certificateMsgTLS13, *certificateVerifyMsg, *finishedMsg:
+		return nil, c.handleCertResponse(msg)
+	}
+	return nil, c.unexpectedPostHandshakeMessage(msg)
+}
+
+// handlePostHandshakeMessages processes the post-handshake messages in c.hand,
+// and keeps reading the records already buffered in c.rawInput until one
+// delivers application data. Servers commonly send several session tickets
+// right after the handshake, which this handles in one pass. Only the last
+// of those tickets is stored in the session cache, since each would replace
+// the previous one, and only if all the messages were processed successfully.
+func (c *Conn) handlePostHandshakeMessages() error {
+	if c.vers != VersionTLS13 {
+		for c.hand.Len() > 0 {
+			if err := c.handleRenegotiation(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var session *SessionState
+	for {
+		for c.hand.Len() > 0 {
+			msg, err := c.readHandshake(nil)
+			if err != nil {
+				return err
+			}
+			s, err := c.dispatchPostHandshakeMessage(msg)
+			if err != nil {
+				return err
+			}
+			if s != nil {
+				session = s
+			}
+		}
+		if c.input.Len() > 0 || !c.recordBuffered() {
+			break
+		}
+		if err := c.readRecord(); err != nil {
+			return err
+		}
+	}
+	if session != nil {
+		c.storeSession(session)
 	}
+	return nil
+}
+
+// recordBuffered reports whether c.rawInput holds a complete record, which
+// can be read without blocking.
+func (c *Conn) recordBuffered() bool {
+	hdr := c.rawInput.Bytes()
+	if len(hdr) < recordHeaderLen {
+		return false
+	}
+	n := int(hdr[3])<<8 | int(hdr[4])
+	return len(hdr) >= recordHeaderLen+n
+}
+
+func (c *Conn) unexpectedPostHandshakeMessage(msg any) error {
 	// The QUIC layer is supposed to treat an unexpected post-handshake CertificateRequest
 	// as a QUIC-level PROTOCOL_VIOLATION error (RFC 9001, Section 4.4). Returning an
 	// unexpected_message alert here doesn't provide it with enough information to distinguish
@@ -1366,12 +2183,25 @@ func (c *Conn) handleKeyUpdate(keyUpdate *keyUpdateMsg) error {
 
 		newSecret := cipherSuite.nextTrafficSecret(c.out.trafficSecret)
 		c.setWriteTrafficSecret(cipherSuite, QUICEncryptionLevelInitial, newSecret)
+		c.keyUpdatesSent.Add(1)
+		if c.out.kernel {
+			if err := c.setKernelKeys(&c.out, false); err != nil {
+				// Surface the error at the next write.
+				c.out.setErrorLocked(err)
+			}
+		}
 	}
 
 	newSecret := cipherSuite.nextTrafficSecret(c.in.trafficSecret)
 	if err := c.setReadTrafficSecret(cipherSuite, QUICEncryptionLevelInitial, newSecret, keyUpdate.updateRequested); err != nil {
 		return err
 	}
+	c.keyUpdatesReceived.Add(1)
+	if c.in.kernel {
+		if err := c.setKernelKeys(&c.in, true); err != nil {
+			return c.in.setErrorLocked(err)
+		}
+	}
 
 	return nil
 }
@@ -1394,16 +2224,10 @@ func (c *Conn) Read(b []byte) (int, error) {
 
 	c.in.Lock()
 	defer c.in.Unlock()
+	defer c.releaseInputBuffers()
 
-	for c.input.Len() == 0 {
-		if err := c.readRecord(); err != nil {
-			return 0, err
-		}
-		for c.hand.Len() > 0 {
-			if err := c.handlePostHandshakeMessage(); err != nil {
-				return 0, err
-			}
-		}
+	if err := c.fillInputLocked(); err != nil {
+		return 0, err
 	}
 
 	n, _ := c.input.Read(b)
@@ -1425,6 +2249,136 @@ func (c *Conn) Read(b []byte) (int, error) {
 	return n, nil
 }
 
+// fillInputLocked reads records until some application data is waiting in
+// c.input. c.in must be locked.
+func (c *Conn) fillInputLocked() error {
+	if c.falseStart != nil {
+		if err := c.finishFalseStartLocked(); err != nil {
+			return err
+		}
+	}
+	for c.input.Len() == 0 {
+		if err := c.readRecord(); err != nil {
+			return err
+		}
+		if c.hand.Len() > 0 {
+			if err := c.handlePostHandshakeMessages(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Peek returns up to the next n bytes of application data without advancing
+// the reader. If no data is buffered, Peek reads the next record, blocking
+// if necessary. Since the data isn't copied out of the record it was
+// decrypted in, Peek returns fewer than n bytes if the rest of the record is
+// shorter, with a nil error.
+//
+// The returned slice points into the internal buffers of c, and is only valid
+// until the next call to Read, Peek or Discard.
+func (c *Conn) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("tls: negative count")
+	}
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+
+	c.in.Lock()
+	defer c.in.Unlock()
+
+	if n == 0 {
+		return nil, nil
+	}
+	if err := c.fillInputLocked(); err != nil {
+		return nil, err
+	}
+	data := c.inputData[len(c.inputData)-c.input.Len():]
+	if len(data) > n {
+		data = data[:n]
+	}
+	return data, nil
+}
+
+// Discard skips the next n bytes of application data, reading records as
+// necessary, and returns the number of bytes discarded. If Discard skips
+// fewer than n bytes, it also returns an error.
+func (c *Conn) Discard(n int) (int, error) {
+	if n < 0 {
+		return 0, errors.New("tls: negative count")
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	defer c.releaseInputBuffers()
+
+	var discarded int
+	for discarded < n {
+		if err := c.fillInputLocked(); err != nil {
+			return discarded, err
+		}
+		m := n - discarded
+		if m > c.input.Len() {
+			m = c.input.Len()
+		}
+		c.input.Seek(int64(m), io.SeekCurrent)
+		discarded += m
+	}
+	return discarded, nil
+}
+
+// Buffered returns the number of bytes of application data that can be read
+// from the current record without blocking.
+func (c *Conn) Buffered() int {
+	c.in.Lock()
+	defer c.in.Unlock()
+	return c.input.Len()
+}
+
+// WriteTo implements [io.WriterTo]. It writes the application data read from
+// c to w, until the peer sends a close_notify alert, in which case it returns
+// a nil error. Each record is written to w straight from the buffer it was
+// decrypted in, which saves a copy compared to io.Copy with Read. With
+// [Config.KernelRX], records are decrypted by the kernel, but still read
+// through userspace, since alerts and post-handshake messages are
+// interleaved with the data.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	defer c.releaseInputBuffers()
+
+	var n int64
+	for {
+		if err := c.fillInputLocked(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+		data := c.inputData[len(c.inputData)-c.input.Len():]
+		m, err := w.Write(data)
+		n += int64(m)
+		c.input.Seek(int64(m), io.SeekCurrent)
+		if err == nil && m < len(data) {
+			err = io.ErrShortWrite
+		}
+		// Don't hold the buffers while waiting for the next record.
+		c.releaseInputBuffers()
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
 // Close closes the connection.
 func (c *Conn) Close() error {
 	// Interlock with Conn.Write above.
@@ -1438,6 +2392,8 @@ func (c *Conn) Close() error {
 			break
 		}
 	}
+	c.writeWait.close()
+	defer c.qlog.close()
 	if x != 0 {
 		// io.Writer and io.Closer should not be used concurrently.
 		// If Close is called while a Write is currently in-flight,
@@ -1458,6 +2414,11 @@ func (c *Conn) Close() error {
 	if err := c.conn.Close(); err != nil {
 		return err
 	}
+	// A concurrent Read holds c.in and releases the buffers itself.
+	if c.in.TryLock() {
+		c.releaseInputBuffers()
+		c.in.Unlock()
+	}
 	return alertErr
 }
 
@@ -1485,6 +2446,10 @@ func (c *Conn) closeNotify() error {
 		c.closeNotifySent = true
 		// Any subsequent writes will fail.
 		c.SetWriteDeadline(time.Now())
+		// The alert was sent after any coalesced records.
+		if c.flushTimer != nil {
+			c.flushTimer.Stop()
+		}
 	}
 	return c.closeNotifyErr
 }
@@ -1530,6 +2495,10 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 		return nil
 	}
 
+	// Config.Fallback is called once the handshake is done with the locks
+	// and its context, which the deferred calls below release.
+	defer func() { ret = c.handOffFallback(ctx, ret) }()
+
 	handshakeCtx, cancel := context.WithCancel(ctx)
 	// Note: defer this before calling context.AfterFunc
 	// so that we can tell the difference between the input being canceled and
@@ -1562,12 +2531,53 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 		return nil
 	}
 
+	if l := c.config.HandshakeLimiter; l != nil && !c.isClient && c.handshakes == 0 && c.resumeHandshake == nil {
+		release, err := l.acquire(handshakeCtx, remoteIP(c.conn))
+		if err != nil {
+			c.handshakeErr = err
+			return err
+		}
+		defer release()
+	}
+
 	c.in.Lock()
 	defer c.in.Unlock()
 
-	c.handshakeErr = c.handshakeFn(handshakeCtx)
+	handshakeFn := c.handshakeFn
+	resuming := c.resumeHandshake != nil
+	if resuming {
+		handshakeFn, c.resumeHandshake = c.resumeHandshake, nil
+	}
+	if c.arena == nil {
+		c.arena = new(handshakeArena)
+	}
+	if !resuming {
+		c.handshakeStart = time.Now()
+		c.handshakeMessages = nil
+		c.trace = ContextConnTrace(ctx)
+		c.trace.handshakeStart()
+		c.qlog.event("tls:handshake_started", nil)
+	}
+	c.handshakeErr = handshakeFn(handshakeCtx)
+	if c.handshakeErr == nil && c.resumeHandshake != nil {
+		// The server handshake was suspended by ReadEarlyData.
+		return nil
+	}
+	if c.handshakeErr != nil {
+		c.handshakeErr = c.wrapSentAlert(c.handshakeErr)
+	}
+	c.trace.handshakeDone(c.handshakeErr)
+	c.qlogHandshakeDone()
+	c.recordHandshake()
+	c.arena = nil
 	if c.handshakeErr == nil {
 		c.handshakes++
+		// Don't count the handshake towards dynamic record sizing.
+		c.out.Lock()
+		c.bytesSent, c.packetsSent = 0, 0
+		c.out.Unlock()
+		c.enableKernelTLS()
+		c.releaseInputBuffers()
 	} else {
 		// If an error occurred during the handshake try to flush the
 		// alert that might be left in the buffer.
@@ -1624,14 +2634,17 @@ func (c *Conn) connectionStateLocked() ConnectionState {
 	state.NegotiatedProtocol = c.clientProtocol
 	state.DidResume = c.didResume
 	state.HelloRetryRequest = c.didHRR
-	state.testingOnlyPeerSignatureAlgorithm = c.peerSigAlg
+	state.PeerSignatureScheme = c.peerSigAlg
 	state.CurveID = c.curveID
 	state.NegotiatedProtocolIsMutual = true
 	state.ServerName = c.serverName
 	state.CipherSuite = c.cipherSuite
+	state.InsecureCipherSuite = isInsecureCipherSuite(c.cipherSuite)
+	state.FIPSApproved = c.fipsApproved()
 	state.PeerCertificates = c.peerCertificates
 	state.VerifiedChains = c.verifiedChains
 	state.SignedCertificateTimestamps = c.scts
+	state.VerifiedSCTs = c.verifiedSCTs
 	state.OCSPResponse = c.ocspResponse
 	if (!c.didResume || c.extMasterSecret) && c.vers != VersionTLS13 {
 		if c.clientFinishedIsFirst {
@@ -1650,6 +2663,31 @@ func (c *Conn) connectionStateLocked() ConnectionState {
 		state.ekm = c.ekm
 	}
 	state.ECHAccepted = c.echAccepted
+	state.EarlyDataOffered = c.earlyDataOffered
+	state.EarlyDataAccepted = c.earlyDataAccepted
+	switch {
+	case c.didResume && c.vers == VersionTLS13:
+		state.Resumption = ResumptionPSK
+	case c.didResume:
+		state.Resumption = ResumptionTicket
+	case c.externalPSKIdentity != nil:
+		state.Resumption = ResumptionExternalPSK
+	}
+	state.KeyUpdatesSent = int(c.keyUpdatesSent.Load())
+	state.KeyUpdatesReceived = int(c.keyUpdatesReceived.Load())
+	state.PeerALPNProtocols = c.peerALPNProtocols
+	state.PeerHelloExtensions = c.peerHelloExtensions
+	state.ExternalPSKIdentity = c.externalPSKIdentity
+	state.CovertPayload = c.covertPayload
+	state.DelegatedCredential = c.delegatedCredential
+	state.PeerRawPublicKey = c.peerRawPublicKey
+	state.CertificateCompression = c.certCompression
+	state.RecordSizeLimit = maxPlaintext
+	if c.peerRecordSizeLimit != 0 {
+		state.RecordSizeLimit = c.peerRecordSizeLimit
+	}
+	state.ExtendedMasterSecret = c.extMasterSecret && c.vers != VersionTLS13
+	state.SecureRenegotiation = c.secureRenegotiation && c.vers != VersionTLS13
 	return state
 }
 
@@ -1695,7 +2733,7 @@ func (c *Conn) setReadTrafficSecret(suite *cipherSuiteTLS13, level QUICEncryptio
 		}
 		return errors.New("tls: handshake buffer not empty before setting read traffic secret")
 	}
-	c.in.setTrafficSecret(suite, level, secret)
+	c.in.setTrafficSecret(suite, level, secret, c.aeadEngine())
 	return nil
 }
 
@@ -1703,5 +2741,5 @@ func (c *Conn) setReadTrafficSecret(suite *cipherSuiteTLS13, level QUICEncryptio
 // being called at the same time as setReadTrafficSecret, the caller must ensure the call
 // to setWriteTrafficSecret happens first so any alerts are sent at the write level.
 func (c *Conn) setWriteTrafficSecret(suite *cipherSuiteTLS13, level QUICEncryptionLevel, secret []byte) {
-	c.out.setTrafficSecret(suite, level, secret)
+	c.out.setTrafficSecret(suite, level, secret, c.aeadEngine())
 }
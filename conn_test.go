@@ -6,9 +6,19 @@ package tls
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 func TestRoundUp(t *testing.T) {
@@ -317,3 +327,446 @@ func TestRecordBadVersionTLS13(t *testing.T) {
 		t.Fatalf("unexpected error: got %q, want %q", err, expectedErr)
 	}
 }
+
+func TestIdleConnReleasesBuffers(t *testing.T) {
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		t.Run(VersionName(version), func(t *testing.T) {
+			client, server := localPipe(t)
+			defer server.Close()
+			defer client.Close()
+
+			config := testConfig.Clone()
+			config.MaxVersion = version
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tlsConn := Server(server, config)
+				if _, err := tlsConn.Write([]byte("hello")); err != nil {
+					t.Errorf("server Write: %v", err)
+				}
+				tlsConn.Read(make([]byte, 1))
+			}()
+
+			tlsConn := Client(client, config)
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(tlsConn, buf); err != nil {
+				t.Fatal(err)
+			}
+			if tlsConn.rawInputBuf != nil || tlsConn.rawInput.Cap() != 0 {
+				t.Error("idle connection holds a raw input buffer")
+			}
+			if tlsConn.handBuf != nil || tlsConn.hand.Cap() != 0 {
+				t.Error("idle connection holds a handshake buffer")
+			}
+
+			tlsConn.Close()
+			<-done
+		})
+	}
+}
+
+func TestDynamicRecordSizingConfig(t *testing.T) {
+	now := time.Unix(0, 0)
+	config := testConfig.Clone()
+	config.Time = func() time.Time { return now }
+	config.DynamicRecordSizingThreshold = 4096
+	config.DynamicRecordSizingIdleTimeout = time.Second
+	c := &Conn{config: config, vers: VersionTLS13}
+
+	first := c.maxPayloadSizeForWrite(recordTypeApplicationData)
+	if first >= tcpMSSEstimate {
+		t.Fatalf("first record payload is %d bytes, want less than %d", first, tcpMSSEstimate)
+	}
+	if n := c.maxPayloadSizeForWrite(recordTypeApplicationData); n != 2*first {
+		t.Errorf("second record payload is %d bytes, want %d", n, 2*first)
+	}
+
+	c.bytesSent = 4096
+	if n := c.maxPayloadSizeForWrite(recordTypeApplicationData); n != maxPlaintext {
+		t.Errorf("record payload past the threshold is %d bytes, want %d", n, maxPlaintext)
+	}
+
+	now = now.Add(999 * time.Millisecond)
+	if n := c.maxPayloadSizeForWrite(recordTypeApplicationData); n != maxPlaintext {
+		t.Errorf("record payload before the idle timeout is %d bytes, want %d", n, maxPlaintext)
+	}
+
+	now = now.Add(time.Second)
+	if n := c.maxPayloadSizeForWrite(recordTypeApplicationData); n != first {
+		t.Errorf("record payload after the idle timeout is %d bytes, want %d", n, first)
+	}
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		t.Run(VersionName(version), func(t *testing.T) {
+			testWriteCoalescing(t, version, time.Hour)
+			testWriteCoalescing(t, version, time.Millisecond)
+		})
+	}
+}
+
+func testWriteCoalescing(t *testing.T, version uint16, window time.Duration) {
+	c, s := localPipe(t)
+	defer c.Close()
+	serverWCC := &writeCountingConn{Conn: s}
+
+	config := testConfig.Clone()
+	config.MaxVersion = version
+	config.SessionTicketsDisabled = true
+	config.WriteCoalescingWindow = window
+
+	errc := make(chan error, 1)
+	go func() {
+		tlsConn := Client(c, config)
+		buf := make([]byte, 10)
+		_, err := io.ReadFull(tlsConn, buf)
+		if err == nil && string(buf) != "helloworld" {
+			err = fmt.Errorf("read %q", buf)
+		}
+		errc <- err
+	}()
+
+	tlsConn := Server(serverWCC, config)
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	tlsConn.out.Lock()
+	writes := serverWCC.numWrites
+	tlsConn.out.Unlock()
+	for _, b := range []string{"hello", "world"} {
+		if _, err := tlsConn.Write([]byte(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if window == time.Hour {
+		if n := serverWCC.numWrites - writes; n != 0 {
+			t.Errorf("coalesced writes made %d writes before Close", n)
+		}
+		if err := tlsConn.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	tlsConn.Close()
+}
+
+func TestPeekDiscard(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+
+	go func() {
+		tlsConn := Server(s, testConfig)
+		defer tlsConn.Close()
+		// Each Write is sent in its own record.
+		for _, b := range []string{"hello", "world"} {
+			if _, err := tlsConn.Write([]byte(b)); err != nil {
+				t.Errorf("server Write: %v", err)
+				return
+			}
+		}
+	}()
+
+	tlsConn := Client(c, testConfig)
+	if n := tlsConn.Buffered(); n != 0 {
+		t.Errorf("Buffered() = %d before reading, want 0", n)
+	}
+	if b, err := tlsConn.Peek(3); err != nil || string(b) != "hel" {
+		t.Fatalf("Peek(3) = %q, %v, want %q", b, err, "hel")
+	}
+	if n := tlsConn.Buffered(); n != 5 {
+		t.Errorf("Buffered() = %d, want 5", n)
+	}
+	// Peek doesn't go past the current record.
+	if b, err := tlsConn.Peek(10); err != nil || string(b) != "hello" {
+		t.Fatalf("Peek(10) = %q, %v, want %q", b, err, "hello")
+	}
+	if n, err := tlsConn.Discard(7); err != nil || n != 7 {
+		t.Fatalf("Discard(7) = %d, %v, want 7", n, err)
+	}
+	buf := make([]byte, 10)
+	n, err := tlsConn.Read(buf)
+	if err != nil || string(buf[:n]) != "rld" {
+		t.Fatalf("Read = %q, %v, want %q", buf[:n], err, "rld")
+	}
+	if n, err := tlsConn.Discard(1); err != io.EOF || n != 0 {
+		t.Errorf("Discard(1) at EOF = %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func TestConnWriteTo(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+
+	msg := bytes.Repeat([]byte("0123456789"), 10000)
+	go func() {
+		tlsConn := Server(s, testConfig)
+		defer tlsConn.Close()
+		for b := msg; len(b) > 0; b = b[1000:] {
+			if _, err := tlsConn.Write(b[:1000]); err != nil {
+				t.Errorf("server Write: %v", err)
+				return
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	tlsConn := Client(c, testConfig)
+	n, err := tlsConn.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(len(msg)) || !bytes.Equal(buf.Bytes(), msg) {
+		t.Errorf("WriteTo wrote %d bytes, want the %d sent", n, len(msg))
+	}
+	if tlsConn.rawInputBuf != nil || tlsConn.handBuf != nil {
+		t.Errorf("WriteTo kept the input buffers")
+	}
+}
+
+// testAEADEngine builds AEADs with the standard library, and counts the
+// records they protect.
+type testAEADEngine struct {
+	fail    bool
+	records atomic.Int64
+}
+
+func (e *testAEADEngine) NewAEAD(version, cipherSuite uint16, key []byte) (cipher.AEAD, error) {
+	if e.fail {
+		return nil, errors.New("unavailable")
+	}
+	var a cipher.AEAD
+	var err error
+	switch cipherSuite {
+	case TLS_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:
+		a, err = chacha20poly1305.New(key)
+	default:
+		var block cipher.Block
+		if block, err = aes.NewCipher(key); err == nil {
+			a, err = cipher.NewGCM(block)
+		}
+	}
+	return &countingAEAD{a, &e.records}, err
+}
+
+type countingAEAD struct {
+	cipher.AEAD
+	records *atomic.Int64
+}
+
+func (a *countingAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	a.records.Add(1)
+	return a.AEAD.Seal(dst, nonce, plaintext, additionalData)
+}
+
+func (a *countingAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	a.records.Add(1)
+	return a.AEAD.Open(dst, nonce, ciphertext, additionalData)
+}
+
+func TestAEADEngine(t *testing.T) {
+	for _, tt := range []struct {
+		version uint16
+		suite   uint16
+	}{
+		{VersionTLS12, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		{VersionTLS12, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		{VersionTLS12, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+		{VersionTLS13, TLS_AES_128_GCM_SHA256},
+		{VersionTLS13, TLS_CHACHA20_POLY1305_SHA256},
+	} {
+		t.Run(CipherSuiteName(tt.suite), func(t *testing.T) {
+			// Each side interoperates with the built-in AEADs of the other.
+			for _, engineOnServer := range []bool{false, true} {
+				engine := &testAEADEngine{}
+				clientConfig := testConfig.Clone()
+				clientConfig.MaxVersion = tt.version
+				serverConfig := testConfig.Clone()
+				serverConfig.MaxVersion = tt.version
+				if tt.version == VersionTLS13 {
+					// TLS 1.3 cipher suites are not configurable.
+					serverConfig.AESGCMPreference = AESGCMPreferenceNever
+					if tt.suite == TLS_AES_128_GCM_SHA256 {
+						serverConfig.AESGCMPreference = AESGCMPreferenceAlways
+					}
+				} else {
+					clientConfig.CipherSuites = []uint16{tt.suite}
+				}
+				if engineOnServer {
+					serverConfig.AEADEngine = engine
+				} else {
+					clientConfig.AEADEngine = engine
+				}
+				ss, _, err := testHandshake(t, clientConfig, serverConfig)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ss.CipherSuite != tt.suite {
+					t.Fatalf("negotiated %s", CipherSuiteName(ss.CipherSuite))
+				}
+				if engine.records.Load() == 0 {
+					t.Errorf("engine on server %v: no records protected by the engine", engineOnServer)
+				}
+			}
+		})
+	}
+
+	// A failing engine falls back to the built-in AEADs.
+	clientConfig := testConfig.Clone()
+	clientConfig.AEADEngine = &testAEADEngine{fail: true}
+	if _, _, err := testHandshake(t, clientConfig, testConfig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandshakeArena(t *testing.T) {
+	a := new(handshakeArena)
+	x := a.clone([]byte("hello"))
+	y := a.clone([]byte("world"))
+	if cap(x) != len(x) {
+		t.Errorf("allocation has capacity %d, want %d", cap(x), len(x))
+	}
+	_ = append(x, '!')
+	if string(x) != "hello" || string(y) != "world" {
+		t.Errorf("allocations overlap: %q, %q", x, y)
+	}
+	if a.chunkSize != minHandshakeArenaChunk || len(a.chunk) != minHandshakeArenaChunk-10 {
+		t.Errorf("small allocations don't share a chunk")
+	}
+	if big := a.alloc(maxHandshakeArenaChunk); len(big) != maxHandshakeArenaChunk {
+		t.Errorf("large allocation has length %d", len(big))
+	}
+}
+
+func TestEncryptThenMAC(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		version uint16
+		suite   uint16
+		server  bool
+		want    bool
+	}{
+		{"TLSv10", VersionTLS10, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, true, true},
+		{"TLSv12", VersionTLS12, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256, true, true},
+		{"ServerDisabled", VersionTLS12, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, false, false},
+		{"AEAD", VersionTLS12, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, true, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.CipherSuites = []uint16{tt.suite}
+			clientConfig.EncryptThenMAC = true
+			serverConfig := testConfig.Clone()
+			serverConfig.EncryptThenMAC = tt.server
+
+			c, s := localPipe(t)
+			client, server := Client(c, clientConfig), Server(s, serverConfig)
+			defer client.Close()
+			errChan := make(chan error, 1)
+			go func() {
+				defer server.Close()
+				if err := server.Handshake(); err != nil {
+					errChan <- err
+					return
+				}
+				_, err := io.Copy(server, server)
+				errChan <- err
+			}()
+
+			msg := bytes.Repeat([]byte("encrypt-then-MAC"), 100)
+			if _, err := client.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Error("echoed data doesn't match")
+			}
+			if client.in.encryptThenMAC != tt.want || client.out.encryptThenMAC != tt.want {
+				t.Errorf("client encrypt-then-MAC %v/%v, want %v", client.in.encryptThenMAC, client.out.encryptThenMAC, tt.want)
+			}
+			if server.in.encryptThenMAC != tt.want || server.out.encryptThenMAC != tt.want {
+				t.Errorf("server encrypt-then-MAC %v/%v, want %v", server.in.encryptThenMAC, server.out.encryptThenMAC, tt.want)
+			}
+			client.Close()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// Tampering with the ciphertext fails before decryption.
+	suite := cipherSuiteByID(TLS_RSA_WITH_AES_128_CBC_SHA)
+	key, iv, macKey := make([]byte, suite.keyLen), make([]byte, suite.ivLen), make([]byte, suite.macLen)
+	out := &halfConn{version: VersionTLS12, cipher: suite.cipher(key, iv, false), mac: suite.mac(macKey), encryptThenMAC: true}
+	record, err := out.encrypt([]byte{byte(recordTypeApplicationData), 3, 3, 0, 0}, []byte("hello"), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tamper := range []bool{false, true} {
+		in := &halfConn{version: VersionTLS12, cipher: suite.cipher(key, iv, true), mac: suite.mac(macKey), encryptThenMAC: true}
+		r := slicesClone(record)
+		if tamper {
+			r[recordHeaderLen+aes.BlockSize] ^= 1
+		}
+		plaintext, _, err := in.decrypt(r)
+		if tamper {
+			if err != alertBadRecordMAC {
+				t.Errorf("tampered record: got error %v, want bad_record_mac", err)
+			}
+		} else if err != nil || string(plaintext) != "hello" {
+			t.Errorf("got %q, %v; want %q", plaintext, err, "hello")
+		}
+	}
+}
+
+func TestEncryptThenMACResumption(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	clientConfig.CipherSuites = []uint16{TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}
+	clientConfig.EncryptThenMAC = true
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	serverConfig := testConfig.Clone()
+	serverConfig.EncryptThenMAC = true
+
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+		t.Fatal(err)
+	}
+	ss, _, err := testHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ss.DidResume {
+		t.Error("session with encrypt-then-MAC not resumed")
+	}
+
+	// The server doesn't resume the session without encrypt-then-MAC.
+	noEtMConfig := serverConfig.Clone()
+	noEtMConfig.EncryptThenMAC = false
+	if ss, _, err := testHandshake(t, clientConfig, noEtMConfig); err != nil {
+		t.Fatal(err)
+	} else if ss.DidResume {
+		t.Error("session with encrypt-then-MAC resumed without it")
+	}
+
+	// Nor does the client, if the server does.
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+		t.Fatal(err)
+	}
+	noEtMConfig.UnwrapSession = func(identity []byte, cs ConnectionState) (*SessionState, error) {
+		session, err := noEtMConfig.DecryptTicket(identity, cs)
+		if session != nil {
+			session.encryptThenMAC = false
+		}
+		return session, err
+	}
+	_, _, err = testHandshake(t, clientConfig, noEtMConfig)
+	if err == nil || !strings.Contains(err.Error(), "without its encrypt-then-MAC") {
+		t.Errorf("resumption without encrypt-then-MAC: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package tls
+
+import (
+	"context"
+	"crypto"
+	"io"
+)
+
+// A ContextSigner is a [crypto.Signer] whose signatures can take a while and
+// be canceled, such as those of keys held by a remote KMS or HSM.
+//
+// If the PrivateKey of a [Certificate], or of a [DelegatedCredential] served
+// in its place, implements ContextSigner, the signatures of the
+// CertificateVerify and ServerKeyExchange messages are made with SignContext,
+// passing the context of the handshake, see [Conn.HandshakeContext]. It's
+// called on a separate goroutine, and the handshake fails with the error of
+// the context as soon as it's done, whether or not SignContext has returned.
+// Other signatures, such as those of exported authenticators, are made with
+// context.Background.
+type ContextSigner interface {
+	crypto.Signer
+	// SignContext signs msg like [crypto.MessageSigner.SignMessage] would:
+	// msg is hashed with opts.HashFunc() first, unless it's zero.
+	SignContext(ctx context.Context, rand io.Reader, msg []byte, opts crypto.SignerOpts) (signature []byte, err error)
+}
+
+// signContext signs msg with signer as cryptoSignMessage does, or with
+// SignContext if signer is a ContextSigner.
+func signContext(ctx context.Context, signer crypto.Signer, rand io.Reader, msg []byte, opts crypto.SignerOpts) ([]byte, error) {
+	cs, ok := signer.(ContextSigner)
+	if !ok {
+		return cryptoSignMessage(signer, rand, msg, opts)
+	}
+	if ctx.Done() == nil {
+		return cs.SignContext(ctx, rand, msg, opts)
+	}
+
+	type result struct {
+		sig []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sig, err := cs.SignContext(ctx, rand, msg, opts)
+		done <- result{sig, err}
+	}()
+	select {
+	case r := <-done:
+		return r.sig, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,106 @@
+package tls
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// testContextSigner is a ContextSigner recording the contexts it's called
+// with. If release is not nil, it ignores cancellation and waits for release
+// to be closed, like an unresponsive remote signer.
+type testContextSigner struct {
+	crypto.Signer
+	ctxs    chan context.Context
+	release chan struct{}
+}
+
+func (s *testContextSigner) SignContext(ctx context.Context, rand io.Reader, msg []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.ctxs <- ctx
+	if s.release != nil {
+		<-s.release
+	}
+	return cryptoSignMessage(s.Signer, rand, msg, opts)
+}
+
+func TestContextSigner(t *testing.T) {
+	type ctxKey struct{}
+	for _, tt := range []struct {
+		name       string
+		version    uint16
+		clientAuth bool
+	}{
+		{"TLS13", VersionTLS13, false},
+		{"TLS12", VersionTLS12, false},
+		{"ClientCertTLS13", VersionTLS13, true},
+		{"ClientCertTLS12", VersionTLS12, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &testContextSigner{Signer: testECDSAPrivateKey, ctxs: make(chan context.Context, 1)}
+			cert := Certificate{Certificate: [][]byte{testECDSACertificate}, PrivateKey: signer}
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			if tt.clientAuth {
+				serverConfig.ClientAuth = RequireAnyClientCert
+				clientConfig.Certificates = []Certificate{cert}
+			} else {
+				serverConfig.Certificates = []Certificate{cert}
+				serverConfig.NameToCertificate = nil
+			}
+
+			ctx := context.WithValue(context.Background(), ctxKey{}, true)
+			c, s := localPipe(t)
+			clientErr := make(chan error, 1)
+			go func() {
+				defer c.Close()
+				clientErr <- Client(c, clientConfig).HandshakeContext(ctx)
+			}()
+			server := Server(s, serverConfig)
+			if err := server.HandshakeContext(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-clientErr; err != nil {
+				t.Fatal(err)
+			}
+			server.Close()
+
+			select {
+			case signCtx := <-signer.ctxs:
+				if v, _ := signCtx.Value(ctxKey{}).(bool); !v {
+					t.Error("SignContext wasn't called with the handshake context")
+				}
+			default:
+				t.Fatal("SignContext wasn't called")
+			}
+		})
+	}
+}
+
+func TestContextSignerCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	signer := &testContextSigner{Signer: testECDSAPrivateKey, ctxs: make(chan context.Context, 1), release: release}
+	serverConfig := testConfig.Clone()
+	serverConfig.Certificates = []Certificate{{Certificate: [][]byte{testECDSACertificate}, PrivateKey: signer}}
+	serverConfig.NameToCertificate = nil
+
+	c, s := localPipe(t)
+	defer c.Close()
+	go Client(c, testConfig).Handshake()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := Server(s, serverConfig).HandshakeContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("handshake took %v to be canceled", d)
+	}
+}
@@ -0,0 +1,244 @@
+package tls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/metacubex/hkdf"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	covertAuthInfo    = "tls covert auth"
+	covertAuthTagSize = 16
+)
+
+// A CovertAuth embeds data, authenticated with a shared key, in the
+// ClientHello of an otherwise normal handshake, as a building block for
+// protocols camouflaged as TLS to recognize their clients. It is set in
+// [Config.CovertAuth].
+//
+// Clients send Payload, encrypted and authenticated, as the sole PSK
+// identity of a TLS 1.3 ClientHello, with a random binder, or as the session
+// ticket of a TLS 1.2 one. To an observer, or to a server without the key,
+// it looks like a session the server can't resume, and the full handshake
+// that follows is unaffected. Sessions and external PSKs aren't offered
+// along with it, and it can't be used with ECH.
+//
+// Servers with the key verify the identities and ticket of the ClientHello,
+// and report the payload in [ClientHelloInfo.CovertPayload], for
+// GetConfigForClient to reject the other clients, such as with
+// [Config.Fallback], and in [ConnectionState.CovertPayload].
+//
+// The tag of the identity covers the whole ClientHello, but for the session
+// ID, the PSK identities and binders and the session ticket, so the payload
+// can't be moved to another ClientHello, or sent with another key share or
+// server name. A recorded ClientHello can still be replayed whole, which
+// Config.ClientHelloReplayFilter detects. The second ClientHello answering a
+// HelloRetryRequest is authenticated again.
+// Its length is visible on the wire, so it should be padded to that of the
+// tickets of the server.
+type CovertAuth struct {
+	// Key is the secret shared by the clients and the server.
+	Key []byte
+
+	// Payload is the data sent by clients.
+	Payload []byte
+}
+
+// keys derives the encryption and MAC keys of the ClientHello with random.
+func (a *CovertAuth) keys(random []byte) (cipher.Stream, []byte, error) {
+	keys, err := hkdf.Key(sha256.New, a.Key, random, covertAuthInfo, 32+32)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	return cipher.NewCTR(block, make([]byte, aes.BlockSize)), keys[32:], nil
+}
+
+// seal returns the identity carrying Payload in the ClientHello with random,
+// with a zero tag for sign to fill in.
+func (a *CovertAuth) seal(random []byte) ([]byte, error) {
+	stream, _, err := a.keys(random)
+	if err != nil {
+		return nil, err
+	}
+	identity := make([]byte, len(a.Payload)+covertAuthTagSize)
+	stream.XORKeyStream(identity, a.Payload)
+	return identity, nil
+}
+
+// covertAuthTag returns the tag of ciphertext in the ClientHello transcript,
+// as returned by covertTranscript.
+func covertAuthTag(macKey, ciphertext, transcript []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(ciphertext)
+	mac.Write(transcript)
+	return mac.Sum(nil)[:covertAuthTagSize]
+}
+
+// sign fills in the tag of identity, returned by seal, in the ClientHello
+// with random and transcript.
+func (a *CovertAuth) sign(random, identity, transcript []byte) error {
+	_, macKey, err := a.keys(random)
+	if err != nil {
+		return err
+	}
+	ciphertext := identity[:len(identity)-covertAuthTagSize]
+	copy(identity[len(ciphertext):], covertAuthTag(macKey, ciphertext, transcript))
+	return nil
+}
+
+// open returns the payload of identity, sent in the ClientHello with random
+// and transcript, or nil if it's not authenticated.
+func (a *CovertAuth) open(random, identity, transcript []byte) []byte {
+	if len(identity) < covertAuthTagSize {
+		return nil
+	}
+	stream, macKey, err := a.keys(random)
+	if err != nil {
+		return nil
+	}
+	ciphertext, tag := identity[:len(identity)-covertAuthTagSize], identity[len(identity)-covertAuthTagSize:]
+	if !hmac.Equal(covertAuthTag(macKey, ciphertext, transcript), tag) {
+		return nil
+	}
+	payload := make([]byte, len(ciphertext))
+	stream.XORKeyStream(payload, ciphertext)
+	return payload
+}
+
+// covertTranscript returns a copy of msg, a ClientHello, with the session ID,
+// the PSK identities and binders and the session ticket zeroed. The session
+// ID is set after the tag, by Config.SessionIDGenerator.
+func covertTranscript(msg []byte) ([]byte, bool) {
+	msg = slicesClone(msg)
+	zero := func(b []byte) {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+	s := cryptobyte.String(msg)
+	var sessionID, skipped, extensions cryptobyte.String
+	if !s.Skip(4+2+32) || !s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&skipped) || !s.ReadUint8LengthPrefixed(&skipped) {
+		return nil, false
+	}
+	zero(sessionID)
+	if s.Empty() {
+		return msg, true
+	}
+	if !s.ReadUint16LengthPrefixed(&extensions) || !s.Empty() {
+		return nil, false
+	}
+	for !extensions.Empty() {
+		var id uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&id) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, false
+		}
+		switch id {
+		case extensionSessionTicket:
+			zero(data)
+		case extensionPreSharedKey:
+			var identities, binders cryptobyte.String
+			if !data.ReadUint16LengthPrefixed(&identities) || !data.ReadUint16LengthPrefixed(&binders) {
+				return nil, false
+			}
+			for !identities.Empty() {
+				var label cryptobyte.String
+				if !identities.ReadUint16LengthPrefixed(&label) || !identities.Skip(4) {
+					return nil, false
+				}
+				zero(label)
+			}
+			for !binders.Empty() {
+				var binder cryptobyte.String
+				if !binders.ReadUint8LengthPrefixed(&binder) {
+					return nil, false
+				}
+				zero(binder)
+			}
+		}
+	}
+	return msg, true
+}
+
+// addCovertAuth embeds Config.CovertAuth in hello.
+func (c *Conn) addCovertAuth(hello *clientHelloMsg, ech *echClientContext) error {
+	if ech != nil || c.quic != nil {
+		return errors.New("tls: Config.CovertAuth can't be used with ECH or QUIC")
+	}
+	identity, err := c.config.CovertAuth.seal(hello.random)
+	if err != nil {
+		return err
+	}
+	if hello.supportedVersions[0] != VersionTLS13 {
+		hello.ticketSupported = true
+		hello.sessionTicket = identity
+		return c.signCovertAuth(hello)
+	}
+	// The obfuscated ticket age and the binder of real sessions look random.
+	var age [4]byte
+	binder := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(c.config.rand(), age[:]); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c.config.rand(), binder); err != nil {
+		return err
+	}
+	hello.ticketSupported = true
+	hello.pskModes = []uint8{pskModeDHE}
+	hello.pskIdentities = []pskIdentity{{label: identity, obfuscatedTicketAge: binary.BigEndian.Uint32(age[:])}}
+	hello.pskBinders = [][]byte{binder}
+	return c.signCovertAuth(hello)
+}
+
+// covertIdentity returns the identity of Config.CovertAuth in hello, sent by
+// this client.
+func covertIdentity(hello *clientHelloMsg) []byte {
+	if len(hello.pskIdentities) > 0 {
+		return hello.pskIdentities[0].label
+	}
+	return hello.sessionTicket
+}
+
+// signCovertAuth fills in the tag of the identity of Config.CovertAuth in
+// hello, once the rest of hello is final.
+func (c *Conn) signCovertAuth(hello *clientHelloMsg) error {
+	msg, err := hello.marshal()
+	if err != nil {
+		return err
+	}
+	transcript, ok := covertTranscript(msg)
+	if !ok {
+		return errors.New("tls: internal error: malformed ClientHello")
+	}
+	return c.config.CovertAuth.sign(hello.random, covertIdentity(hello), transcript)
+}
+
+// verifyCovertAuth returns the payload of Config.CovertAuth in hello, if any.
+func (c *Conn) verifyCovertAuth(hello *clientHelloMsg) []byte {
+	a := c.config.CovertAuth
+	if a == nil || len(a.Key) == 0 {
+		return nil
+	}
+	transcript, ok := covertTranscript(hello.original)
+	if !ok {
+		return nil
+	}
+	for _, identity := range hello.pskIdentities {
+		if payload := a.open(hello.random, identity.label, transcript); payload != nil {
+			return payload
+		}
+	}
+	return a.open(hello.random, hello.sessionTicket, transcript)
+}
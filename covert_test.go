@@ -0,0 +1,125 @@
+package tls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCovertAuth(t *testing.T) {
+	key := []byte("shared covert authentication key")
+	payload := bytes.Repeat([]byte("payload"), 20)
+	for _, tt := range []struct {
+		name      string
+		vers      uint16
+		serverKey []byte
+		curves    []CurveID
+		want      []byte
+	}{
+		{"TLS13", VersionTLS13, key, nil, payload},
+		{"TLS12", VersionTLS12, key, nil, payload},
+		{"HelloRetryRequest", VersionTLS13, key, []CurveID{CurveP384}, payload},
+		{"WrongKey", VersionTLS13, []byte("another key"), nil, nil},
+		{"NoKey", VersionTLS13, nil, nil, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.vers
+			clientConfig.CovertAuth = &CovertAuth{Key: key, Payload: payload}
+			clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+			var infoPayload []byte
+			serverConfig := testConfig.Clone()
+			serverConfig.CurvePreferences = tt.curves
+			serverConfig.CovertAuth = &CovertAuth{Key: tt.serverKey}
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				infoPayload = chi.CovertPayload
+				return nil, nil
+			}
+
+			// The second connection doesn't resume the first one.
+			for i := 0; i < 2; i++ {
+				ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ss.DidResume || ss.Version != tt.vers {
+					t.Errorf("negotiated %x, resumed: %v", ss.Version, ss.DidResume)
+				}
+				if tt.curves != nil && !ss.HelloRetryRequest {
+					t.Error("no HelloRetryRequest")
+				}
+				if !bytes.Equal(ss.CovertPayload, tt.want) || !bytes.Equal(infoPayload, tt.want) {
+					t.Errorf("server got payload %q and %q, want %q", ss.CovertPayload, infoPayload, tt.want)
+				}
+				if cs.CovertPayload != nil {
+					t.Errorf("client got payload %q", cs.CovertPayload)
+				}
+			}
+		})
+	}
+}
+
+func TestCovertAuthBinding(t *testing.T) {
+	a := &CovertAuth{Key: []byte("key"), Payload: []byte("payload")}
+	random := bytes.Repeat([]byte{1}, 32)
+	transcript := []byte("transcript")
+	identity, err := a.seal(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.sign(random, identity, transcript); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.open(random, identity, transcript); string(got) != "payload" {
+		t.Errorf("opened %q", got)
+	}
+	if got := a.open(bytes.Repeat([]byte{2}, 32), identity, transcript); got != nil {
+		t.Errorf("identity opened with another random: %q", got)
+	}
+	if got := a.open(random, identity, []byte("another transcript")); got != nil {
+		t.Errorf("identity opened in another ClientHello: %q", got)
+	}
+	identity[0] ^= 1
+	if got := a.open(random, identity, transcript); got != nil {
+		t.Errorf("modified identity opened: %q", got)
+	}
+}
+
+func TestCovertAuthClientHelloBinding(t *testing.T) {
+	key := []byte("shared covert authentication key")
+	for _, vers := range []uint16{VersionTLS13, VersionTLS12} {
+		clientConfig := testConfig.Clone()
+		clientConfig.MaxVersion = vers
+		clientConfig.CovertAuth = &CovertAuth{Key: key, Payload: []byte("payload")}
+		serverConfig := testConfig.Clone()
+		serverConfig.CovertAuth = &CovertAuth{Key: key}
+		server := &Conn{config: serverConfig}
+
+		record := sentClientHello(t, clientConfig)
+		var hello clientHelloMsg
+		if !hello.unmarshal(slicesClone(record[recordHeaderLen:])) {
+			t.Fatal("failed to parse the ClientHello")
+		}
+		if got := server.verifyCovertAuth(&hello); string(got) != "payload" {
+			t.Fatalf("%s: got payload %q", VersionName(vers), got)
+		}
+
+		// The random and the identity, replayed with another key share and
+		// server name, aren't authenticated.
+		replayed := hello.clone()
+		replayed.original = nil
+		replayed.keyShares = []keyShare{{group: X25519, data: bytes.Repeat([]byte{0x42}, 32)}}
+		replayed.serverName = "attacker.example"
+		msg, err := replayed.marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got clientHelloMsg
+		if !got.unmarshal(msg) {
+			t.Fatal("failed to parse the replayed ClientHello")
+		}
+		if payload := server.verifyCovertAuth(&got); payload != nil {
+			t.Errorf("%s: replayed identity authenticated with another key share: %q", VersionName(vers), payload)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package tls
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidExtensionCRLReason         = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidExtensionDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtensionFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+const (
+	// maxCRLSize bounds the CRLs downloaded by CRLChecker.
+	maxCRLSize = 32 << 20
+
+	// defaultCRLCacheTTL is how long CRLs without a NextUpdate are cached.
+	defaultCRLCacheTTL = time.Hour
+
+	// crlReasonRemoveFromCRL is the reason of delta CRL entries lifting the
+	// certificateHold of a certificate in the base CRL. See RFC 5280,
+	// Section 5.3.1.
+	crlReasonRemoveFromCRL = 8
+)
+
+// A RevocationChecker checks whether the certificates of verified chains have
+// been revoked. It is set in [Config.RevocationChecker].
+//
+// Implementations must be safe for concurrent use. See [CRLChecker] for one
+// based on certificate revocation lists.
+type RevocationChecker interface {
+	// CheckRevocation returns a *CertificateRevokedError if cert, issued by
+	// issuer, has been revoked, and any other error if its status couldn't
+	// be determined, which [Config.RevocationMode] decides how to handle.
+	// It returns nil if cert is not revoked, or offers no way to check it.
+	// ctx is the context of the handshake.
+	CheckRevocation(ctx context.Context, cert, issuer *x509.Certificate) error
+}
+
+// RevocationMode decides how handshakes fail when a [RevocationChecker] can't
+// determine the revocation status of a certificate.
+type RevocationMode int
+
+const (
+	// RevocationSoftFail only rejects certificates known to be revoked.
+	RevocationSoftFail RevocationMode = iota
+	// RevocationHardFail also rejects certificates whose status can't be
+	// determined, for example when the CRL can't be downloaded.
+	RevocationHardFail
+)
+
+// CertificateRevokedError is returned by a [RevocationChecker] for a revoked
+// certificate, and by handshakes with peers presenting one.
+type CertificateRevokedError struct {
+	// Certificate is the revoked certificate.
+	Certificate *x509.Certificate
+	// RevocationTime is when the certificate was revoked.
+	RevocationTime time.Time
+	// Reason is the CRLReason code of the revocation, as defined in RFC
+	// 5280, Section 5.3.1, or zero if unspecified.
+	Reason int
+}
+
+func (e *CertificateRevokedError) Error() string {
+	return fmt.Sprintf("tls: certificate %q (serial %s) was revoked at %v",
+		e.Certificate.Subject, e.Certificate.SerialNumber, e.RevocationTime)
+}
+
+// revocationTimeKey is the context key of the func returning the current time
+// of the Config of a handshake, for [CRLChecker].
+type revocationTimeKey struct{}
+
+// checkRevocation checks the revocation status of the certificates of the
+// first of the verified chains, except for its root, sending the appropriate
+// alert.
+func (c *Conn) checkRevocation(ctx context.Context, chains [][]*x509.Certificate) error {
+	if c.config.RevocationChecker == nil || len(chains) == 0 {
+		return nil
+	}
+	ctx = context.WithValue(ctx, revocationTimeKey{}, c.config.time)
+	chain := chains[0]
+	for i := 0; i+1 < len(chain); i++ {
+		err := c.config.RevocationChecker.CheckRevocation(ctx, chain[i], chain[i+1])
+		if err == nil {
+			continue
+		}
+		if _, ok := errorsAsType[*CertificateRevokedError](err); ok {
+			c.sendAlert(alertCertificateRevoked)
+			return err
+		}
+		if c.config.RevocationMode == RevocationHardFail {
+			c.sendAlert(alertCertificateUnknown)
+			return fmt.Errorf("tls: failed to check certificate revocation: %w", err)
+		}
+	}
+	return nil
+}
+
+// CRLChecker is a [RevocationChecker] that downloads the certificate
+// revocation lists of the HTTP distribution points of certificates, and of
+// the delta CRLs they point to, as specified in RFC 5280. CRLs are cached
+// until their NextUpdate, and downloaded once for concurrent handshakes. They
+// are checked to be current at the time of the handshake's Config, see
+// [Config.Time].
+//
+// The zero value is ready to use. A CRLChecker must not be copied or have its
+// fields modified after first use.
+type CRLChecker struct {
+	// HTTPClient is used to download CRLs. If nil, http.DefaultClient is
+	// used.
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*crlCacheEntry // by issuer and URL
+}
+
+type crlCacheEntry struct {
+	mu      sync.Mutex // held while downloading
+	crl     *x509.RevocationList
+	expires time.Time
+}
+
+// CheckRevocation implements [RevocationChecker]. The first distribution
+// point whose CRL can be downloaded and verified is used. A certificate
+// revoked by the base CRL is reported as such even if its delta CRL can't be
+// checked.
+func (c *CRLChecker) CheckRevocation(ctx context.Context, cert, issuer *x509.Certificate) error {
+	now := time.Now
+	if f, ok := ctx.Value(revocationTimeKey{}).(func() time.Time); ok {
+		now = f
+	}
+	var errs []error
+	for _, url := range cert.CRLDistributionPoints {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+		base, err := c.getCRL(ctx, url, issuer, nil, now())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		baseErr := checkRevoked(cert, base, nil)
+		deltaURLs := freshestCRLURLs(base)
+		if len(deltaURLs) == 0 {
+			return baseErr
+		}
+		var delta *x509.RevocationList
+		for _, deltaURL := range deltaURLs {
+			if delta, err = c.getCRL(ctx, deltaURL, issuer, base, now()); err == nil {
+				break
+			}
+		}
+		if delta == nil {
+			if baseErr != nil {
+				return baseErr
+			}
+			errs = append(errs, err)
+			continue
+		}
+		return checkRevoked(cert, base, delta)
+	}
+	return errors.Join(errs...)
+}
+
+// getCRL returns the CRL at url, downloading it if it's not cached or not
+// current at now. If base is not nil, it must be a delta CRL for base.
+func (c *CRLChecker) getCRL(ctx context.Context, url string, issuer *x509.Certificate, base *x509.RevocationList, now time.Time) (*x509.RevocationList, error) {
+	issuerHash := sha256.Sum256(issuer.Raw)
+	key := string(issuerHash[:]) + url
+
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = make(map[string]*crlCacheEntry)
+	}
+	e, ok := c.cache[key]
+	if !ok {
+		e = &crlCacheEntry{}
+		c.cache[key] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.crl != nil && !now.Before(e.crl.ThisUpdate) && now.Before(e.expires) && (base == nil || deltaMatches(e.crl, base)) {
+		return e.crl, nil
+	}
+	crl, err := c.download(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	if err := verifyCRL(crl, issuer, base, now); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	e.crl, e.expires = crl, crl.NextUpdate
+	if e.expires.IsZero() {
+		e.expires = now.Add(defaultCRLCacheTTL)
+	}
+	return crl, nil
+}
+
+func (c *CRLChecker) download(ctx context.Context, url string) (*x509.RevocationList, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	der, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(der) > maxCRLSize {
+		return nil, errors.New("CRL too large")
+	}
+	return x509.ParseRevocationList(der)
+}
+
+// verifyCRL checks that crl was signed by issuer and is current, and that it
+// is a delta CRL for base if base is not nil, or a complete CRL otherwise.
+func verifyCRL(crl *x509.RevocationList, issuer *x509.Certificate, base *x509.RevocationList, now time.Time) error {
+	if string(crl.RawIssuer) != string(issuer.RawSubject) {
+		return errors.New("CRL issuer doesn't match the certificate issuer")
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return err
+	}
+	if now.Before(crl.ThisUpdate) || !crl.NextUpdate.IsZero() && !now.Before(crl.NextUpdate) {
+		return errors.New("CRL is not current")
+	}
+	_, isDelta := deltaCRLBase(crl)
+	if base == nil && isDelta {
+		return errors.New("complete CRL is a delta CRL")
+	}
+	if base != nil && !deltaMatches(crl, base) {
+		return errors.New("delta CRL doesn't apply to its base CRL")
+	}
+	return nil
+}
+
+// deltaMatches reports whether delta is a delta CRL applying to base, which
+// requires the base it builds on to be no newer than base, and delta to be
+// newer. See RFC 5280, Section 5.2.4.
+func deltaMatches(delta, base *x509.RevocationList) bool {
+	baseNumber, ok := deltaCRLBase(delta)
+	if !ok || base.Number == nil || delta.Number == nil {
+		return false
+	}
+	return baseNumber.Cmp(base.Number) <= 0 && delta.Number.Cmp(base.Number) > 0
+}
+
+// deltaCRLBase returns the BaseCRLNumber of the delta CRL indicator of crl, if
+// it has one.
+func deltaCRLBase(crl *x509.RevocationList) (*big.Int, bool) {
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidExtensionDeltaCRLIndicator) {
+			n := new(big.Int)
+			if rest, err := asn1.Unmarshal(ext.Value, &n); err != nil || len(rest) != 0 {
+				return nil, false
+			}
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// checkRevoked returns a *CertificateRevokedError if cert is revoked by base
+// or by delta, which may be nil.
+func checkRevoked(cert *x509.Certificate, base, delta *x509.RevocationList) error {
+	if delta != nil {
+		for _, rc := range delta.RevokedCertificates {
+			if rc.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+				continue
+			}
+			reason := crlEntryReason(rc.Extensions)
+			if reason == crlReasonRemoveFromCRL {
+				return nil
+			}
+			return &CertificateRevokedError{Certificate: cert, RevocationTime: rc.RevocationTime, Reason: reason}
+		}
+	}
+	for _, rc := range base.RevokedCertificates {
+		if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return &CertificateRevokedError{Certificate: cert, RevocationTime: rc.RevocationTime, Reason: crlEntryReason(rc.Extensions)}
+		}
+	}
+	return nil
+}
+
+func crlEntryReason(exts []pkix.Extension) int {
+	for _, ext := range exts {
+		if ext.Id.Equal(oidExtensionCRLReason) {
+			var reason asn1.Enumerated
+			if rest, err := asn1.Unmarshal(ext.Value, &reason); err == nil && len(rest) == 0 {
+				return int(reason)
+			}
+		}
+	}
+	return 0
+}
+
+// freshestCRLURLs returns the HTTP URLs of the delta CRLs listed in the
+// Freshest CRL extension of crl. See RFC 5280, Section 5.2.6.
+func freshestCRLURLs(crl *x509.RevocationList) []string {
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidExtensionFreshestCRL) {
+			return parseDistributionPointURLs(ext.Value)
+		}
+	}
+	return nil
+}
+
+// parseDistributionPointURLs returns the HTTP URLs in the fullName of the
+// distribution points of a CRLDistributionPoints structure. See RFC 5280,
+// Section 4.2.1.13.
+func parseDistributionPointURLs(value []byte) []string {
+	input := cryptobyte.String(value)
+	var points cryptobyte.String
+	if !input.ReadASN1(&points, cryptobyte_asn1.SEQUENCE) {
+		return nil
+	}
+	var urls []string
+	for !points.Empty() {
+		var point, name, fullName cryptobyte.String
+		var hasName bool
+		if !points.ReadASN1(&point, cryptobyte_asn1.SEQUENCE) ||
+			!point.ReadOptionalASN1(&name, &hasName, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+			return nil
+		}
+		if !hasName {
+			continue
+		}
+		var hasFullName bool
+		if !name.ReadOptionalASN1(&fullName, &hasFullName, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+			return nil
+		}
+		for hasFullName && !fullName.Empty() {
+			var gn cryptobyte.String
+			var tag cryptobyte_asn1.Tag
+			if !fullName.ReadAnyASN1(&gn, &tag) {
+				return nil
+			}
+			if url := string(gn); tag == cryptobyte_asn1.Tag(6).ContextSpecific() &&
+				(strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")) {
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
@@ -0,0 +1,318 @@
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCRLServer issues certificates pointing to the CRLs it serves.
+type testCRLServer struct {
+	*httptest.Server
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	now   time.Time
+
+	mu        sync.Mutex
+	base      []pkix.RevokedCertificate
+	delta     []pkix.RevokedCertificate // served if not nil
+	signer    *ecdsa.PrivateKey         // signs the CRLs, caKey if nil
+	broken    bool
+	noDelta   bool // the delta CRL is advertised but unavailable
+	downloads atomic.Int32
+}
+
+func newTestCRLServer(t *testing.T) *testCRLServer {
+	t.Helper()
+	s := &testCRLServer{now: time.Now().Truncate(time.Second)}
+	var err error
+	if s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CRL test CA"},
+		NotBefore:             s.now.Add(-time.Hour),
+		NotAfter:              s.now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, s.caKey.Public(), s.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.downloads.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.broken {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		tmpl := &x509.RevocationList{
+			Number:     big.NewInt(10),
+			ThisUpdate: s.now.Add(-time.Hour),
+			NextUpdate: s.now.Add(time.Hour),
+		}
+		switch req.URL.Path {
+		case "/base.crl":
+			tmpl.RevokedCertificates = s.base
+			if s.delta != nil {
+				tmpl.ExtraExtensions = []pkix.Extension{{Id: oidExtensionFreshestCRL, Value: marshalDistributionPoints(t, s.URL+"/delta.crl")}}
+			}
+		case "/delta.crl":
+			if s.noDelta {
+				http.NotFound(w, req)
+				return
+			}
+			tmpl.Number = big.NewInt(11)
+			tmpl.RevokedCertificates = s.delta
+			base, err := asn1.Marshal(big.NewInt(10))
+			if err != nil {
+				t.Error(err)
+			}
+			tmpl.ExtraExtensions = []pkix.Extension{{Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: base}}
+		default:
+			http.NotFound(w, req)
+			return
+		}
+		signer := s.signer
+		if signer == nil {
+			signer = s.caKey
+		}
+		crl, err := x509.CreateRevocationList(rand.Reader, tmpl, s.ca, signer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(crl)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func marshalDistributionPoints(t *testing.T, url string) []byte {
+	type distributionPointName struct {
+		FullName []asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	type distributionPoint struct {
+		DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	}
+	value, err := asn1.Marshal([]distributionPoint{{
+		DistributionPoint: distributionPointName{
+			FullName: []asn1.RawValue{{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(url)}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func (s *testCRLServer) issue(t *testing.T, serial int64, extKeyUsage x509.ExtKeyUsage) Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "example.golang"},
+		DNSNames:              []string{"example.golang"},
+		NotBefore:             s.now.Add(-time.Hour),
+		NotAfter:              s.now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{extKeyUsage},
+		CRLDistributionPoints: []string{s.URL + "/base.crl"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.ca, key.Public(), s.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func revokedEntry(t *testing.T, serial int64, reason int) pkix.RevokedCertificate {
+	rc := pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now().Add(-time.Minute).UTC()}
+	if reason != 0 {
+		value, err := asn1.Marshal(asn1.Enumerated(reason))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Extensions = []pkix.Extension{{Id: oidExtensionCRLReason, Value: value}}
+	}
+	return rc
+}
+
+func TestCRLChecker(t *testing.T) {
+	const certificateHold, keyCompromise = 6, 1
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		base    []pkix.RevokedCertificate
+		delta   []pkix.RevokedCertificate
+		signer  *ecdsa.PrivateKey
+		broken  bool
+		noDelta bool
+		revoked bool
+		reason  int
+		err     string
+	}{
+		{name: "Good"},
+		{name: "GoodWithDelta", delta: []pkix.RevokedCertificate{}},
+		{name: "Revoked", base: []pkix.RevokedCertificate{revokedEntry(t, 3, keyCompromise)}, revoked: true, reason: keyCompromise},
+		{name: "OtherSerialRevoked", base: []pkix.RevokedCertificate{revokedEntry(t, 4, 0)}},
+		{name: "RevokedInDelta", delta: []pkix.RevokedCertificate{revokedEntry(t, 3, 0)}, revoked: true},
+		{name: "HoldLifted", base: []pkix.RevokedCertificate{revokedEntry(t, 3, certificateHold)},
+			delta: []pkix.RevokedCertificate{revokedEntry(t, 3, crlReasonRemoveFromCRL)}},
+		{name: "RevokedDeltaUnavailable", base: []pkix.RevokedCertificate{revokedEntry(t, 3, keyCompromise)},
+			delta: []pkix.RevokedCertificate{}, noDelta: true, revoked: true, reason: keyCompromise},
+		{name: "DeltaUnavailable", delta: []pkix.RevokedCertificate{}, noDelta: true, err: "404"},
+		{name: "Unavailable", broken: true, err: "503"},
+		{name: "BadSignature", signer: otherKey, err: "verification"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestCRLServer(t)
+			s.base, s.delta, s.signer, s.broken, s.noDelta = tt.base, tt.delta, tt.signer, tt.broken, tt.noDelta
+			cert := s.issue(t, 3, x509.ExtKeyUsageServerAuth)
+
+			checker := &CRLChecker{}
+			err := checker.CheckRevocation(context.Background(), cert.Leaf, s.ca)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if !tt.revoked {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			revokedErr, ok := errorsAsType[*CertificateRevokedError](err)
+			if !ok {
+				t.Fatalf("got error %v, want a CertificateRevokedError", err)
+			}
+			if revokedErr.Reason != tt.reason || revokedErr.Certificate != cert.Leaf {
+				t.Errorf("got revocation reason %d for %v, want %d", revokedErr.Reason, revokedErr.Certificate.Subject, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCRLCheckerCache(t *testing.T) {
+	s := newTestCRLServer(t)
+	s.delta = []pkix.RevokedCertificate{}
+	cert := s.issue(t, 3, x509.ExtKeyUsageServerAuth)
+	checker := &CRLChecker{}
+	for i := 0; i < 3; i++ {
+		if err := checker.CheckRevocation(context.Background(), cert.Leaf, s.ca); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.downloads.Load(); n != 2 {
+		t.Errorf("downloaded %d CRLs, want the base and delta CRLs once", n)
+	}
+}
+
+func TestCRLCheckerTime(t *testing.T) {
+	s := newTestCRLServer(t)
+	cert := s.issue(t, 3, x509.ExtKeyUsageServerAuth)
+	checker := &CRLChecker{}
+	if err := checker.CheckRevocation(context.Background(), cert.Leaf, s.ca); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Time: func() time.Time { return s.now.Add(2 * time.Hour) }}
+	ctx := context.WithValue(context.Background(), revocationTimeKey{}, config.time)
+	err := checker.CheckRevocation(ctx, cert.Leaf, s.ca)
+	if err == nil || !strings.Contains(err.Error(), "not current") {
+		t.Errorf("got error %v at a time past NextUpdate, want a stale CRL", err)
+	}
+}
+
+func TestRevocationCheckingHandshake(t *testing.T) {
+	s := newTestCRLServer(t)
+	s.base = []pkix.RevokedCertificate{revokedEntry(t, 5, 0)}
+	roots := x509.NewCertPool()
+	roots.AddCert(s.ca)
+	good := s.issue(t, 3, x509.ExtKeyUsageServerAuth)
+	revoked := s.issue(t, 5, x509.ExtKeyUsageServerAuth)
+	revokedClient := s.issue(t, 5, x509.ExtKeyUsageClientAuth)
+
+	for _, tt := range []struct {
+		name       string
+		cert       Certificate
+		clientCert *Certificate
+		broken     bool
+		mode       RevocationMode
+		err        string
+	}{
+		{name: "Good", cert: good},
+		{name: "Revoked", cert: revoked, err: "revoked"},
+		{name: "RevokedClient", cert: good, clientCert: &revokedClient, err: "revoked"},
+		{name: "SoftFail", cert: good, broken: true},
+		{name: "HardFail", cert: good, broken: true, mode: RevocationHardFail, err: "failed to check certificate revocation"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s.mu.Lock()
+			s.broken = tt.broken
+			s.mu.Unlock()
+			serverConfig := testConfig.Clone()
+			serverConfig.Certificates = []Certificate{tt.cert}
+			serverConfig.NameToCertificate = nil
+			serverConfig.RevocationChecker = &CRLChecker{}
+			clientConfig := testConfig.Clone()
+			clientConfig.InsecureSkipVerify = false
+			clientConfig.ServerName = "example.golang"
+			clientConfig.RootCAs = roots
+			clientConfig.Time = nil
+			clientConfig.RevocationChecker = &CRLChecker{}
+			clientConfig.RevocationMode = tt.mode
+			if tt.clientCert != nil {
+				serverConfig.ClientAuth = RequireAndVerifyClientCert
+				serverConfig.ClientCAs = roots
+				serverConfig.Time = nil
+				clientConfig.Certificates = []Certificate{*tt.clientCert}
+				// Fail on the server before the client reads its response.
+				serverConfig.MaxVersion = VersionTLS12
+			}
+
+			_, _, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("handshake failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
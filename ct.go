@@ -0,0 +1,327 @@
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	// oidSCTList is the certificate extension carrying embedded SCTs. See
+	// RFC 6962, Section 3.3.
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// oidOCSPSCTList is the OCSP singleExtension carrying SCTs. See RFC 6962,
+	// Section 3.3.
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// A CTLog is a Certificate Transparency log whose signed certificate
+// timestamps (SCTs) are trusted, as listed in [Config.CTLogs].
+type CTLog struct {
+	// Description is the human-readable name of the log.
+	Description string
+
+	// Operator is the organization running the log. Logs with an empty
+	// Operator are considered to be run by distinct operators.
+	Operator string
+
+	// Key is the DER-encoded SubjectPublicKeyInfo of the log, whose SHA-256
+	// hash is the log ID.
+	Key []byte
+}
+
+// SCTSource is how a signed certificate timestamp was delivered.
+type SCTSource uint8
+
+const (
+	// SCTSourceEmbedded is an SCT embedded in the certificate.
+	SCTSourceEmbedded SCTSource = iota
+	// SCTSourceTLSExtension is an SCT sent in the
+	// signed_certificate_timestamp extension.
+	SCTSourceTLSExtension
+	// SCTSourceOCSP is an SCT included in the stapled OCSP response.
+	SCTSourceOCSP
+)
+
+// VerifiedSCT is a signed certificate timestamp for the server certificate
+// that was signed by one of the logs in [Config.CTLogs].
+type VerifiedSCT struct {
+	// Raw is the encoded SignedCertificateTimestamp.
+	Raw []byte
+	// Log is the log that signed the SCT.
+	Log *CTLog
+	// Timestamp is the time at which the log promised to include the
+	// certificate.
+	Timestamp time.Time
+	// Source is how the SCT was delivered.
+	Source SCTSource
+}
+
+// verifySCTs checks the SCTs of leaf, issued by issuer which may be nil,
+// against c.config.CTLogs, setting c.verifiedSCTs. If c.config.RequireCT is
+// set, it returns an error if they don't comply with the policy described
+// there. SCTs that can't be verified are ignored.
+func (c *Conn) verifySCTs(leaf, issuer *x509.Certificate) error {
+	type candidate struct {
+		raw    []byte
+		source SCTSource
+	}
+	var candidates []candidate
+	if issuer != nil {
+		for _, ext := range leaf.Extensions {
+			if ext.Id.Equal(oidSCTList) {
+				for _, sct := range parseSCTList(ext.Value) {
+					candidates = append(candidates, candidate{sct, SCTSourceEmbedded})
+				}
+			}
+		}
+	}
+	for _, sct := range c.scts {
+		candidates = append(candidates, candidate{sct, SCTSourceTLSExtension})
+	}
+	if c.ocspResponse != nil {
+		if resp, err := ocsp.ParseResponse(c.ocspResponse, nil); err == nil {
+			for _, ext := range resp.Extensions {
+				if ext.Id.Equal(oidOCSPSCTList) {
+					for _, sct := range parseSCTList(ext.Value) {
+						candidates = append(candidates, candidate{sct, SCTSourceOCSP})
+					}
+				}
+			}
+		}
+	}
+
+	var precertEntry []byte
+	now := c.config.time()
+	c.verifiedSCTs = nil
+	for _, cand := range candidates {
+		var entry []byte
+		if cand.source == SCTSourceEmbedded {
+			if precertEntry == nil {
+				var err error
+				if precertEntry, err = precertificateEntry(leaf, issuer); err != nil {
+					continue
+				}
+			}
+			entry = precertEntry
+		} else {
+			entry = x509Entry(leaf)
+		}
+		log, timestamp, ok := c.config.verifySCT(cand.raw, entry)
+		if !ok || timestamp.After(now) {
+			continue
+		}
+		c.verifiedSCTs = append(c.verifiedSCTs, VerifiedSCT{
+			Raw:       cand.raw,
+			Log:       log,
+			Timestamp: timestamp,
+			Source:    cand.source,
+		})
+	}
+
+	if c.config.RequireCT && !ctPolicySatisfied(c.verifiedSCTs, leaf) {
+		c.sendAlert(alertBadCertificate)
+		return &CertificateVerificationError{
+			UnverifiedCertificates: c.peerCertificates,
+			Err:                    errors.New("server certificate doesn't comply with the Certificate Transparency policy"),
+		}
+	}
+	return nil
+}
+
+// ctPolicySatisfied reports whether scts are enough to trust leaf: SCTs from
+// two logs if any of them was delivered by TLS or OCSP, or else embedded SCTs
+// from two logs for certificates valid up to 180 days, and three logs
+// otherwise. In both cases the logs must be run by at least two operators.
+func ctPolicySatisfied(scts []VerifiedSCT, leaf *x509.Certificate) bool {
+	want := 2
+	if leaf.NotAfter.Sub(leaf.NotBefore) > 180*24*time.Hour {
+		want = 3
+	}
+	logs := make(map[*CTLog]bool)
+	operators := make(map[string]bool)
+	for _, sct := range scts {
+		if sct.Source != SCTSourceEmbedded {
+			want = 2
+		}
+		logs[sct.Log] = true
+		if sct.Log.Operator != "" {
+			operators[sct.Log.Operator] = true
+		} else {
+			operators["\x00"+string(sct.Log.Key)] = true
+		}
+	}
+	return len(logs) >= want && len(operators) >= 2
+}
+
+// verifySCT parses raw as a v1 SignedCertificateTimestamp and checks that it
+// was signed by one of the configured logs over entry, the encoded
+// timestamped entry. See RFC 6962, Section 3.2.
+func (c *Config) verifySCT(raw, entry []byte) (*CTLog, time.Time, bool) {
+	s := cryptobyte.String(raw)
+	var version uint8
+	var logID []byte
+	var timestamp uint64
+	var extensions, signature []byte
+	var hashAlg, sigAlg uint8
+	if !s.ReadUint8(&version) || version != 0 ||
+		!s.ReadBytes(&logID, sha256.Size) ||
+		!s.ReadUint64(&timestamp) ||
+		!readUint16LengthPrefixed(&s, &extensions) ||
+		!s.ReadUint8(&hashAlg) || !s.ReadUint8(&sigAlg) ||
+		!readUint16LengthPrefixed(&s, &signature) || !s.Empty() {
+		return nil, time.Time{}, false
+	}
+	// Logs must sign with SHA-256. See RFC 6962, Section 2.1.4.
+	if hashAlg != 4 {
+		return nil, time.Time{}, false
+	}
+
+	var log *CTLog
+	for i := range c.CTLogs {
+		if id := sha256.Sum256(c.CTLogs[i].Key); bytes.Equal(id[:], logID) {
+			log = &c.CTLogs[i]
+			break
+		}
+	}
+	if log == nil {
+		return nil, time.Time{}, false
+	}
+	pub, err := x509.ParsePKIXPublicKey(log.Key)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint8(version)
+	b.AddUint8(0) // signature_type = certificate_timestamp
+	b.AddUint64(timestamp)
+	b.AddBytes(entry)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(extensions)
+	})
+	signed, err := b.Bytes()
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	digest := sha256.Sum256(signed)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if sigAlg != 3 || !ecdsa.VerifyASN1(pub, digest[:], signature) {
+			return nil, time.Time{}, false
+		}
+	case *rsa.PublicKey:
+		if sigAlg != 1 || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return nil, time.Time{}, false
+		}
+	default:
+		return nil, time.Time{}, false
+	}
+	return log, time.UnixMilli(int64(timestamp)), true
+}
+
+// parseSCTList returns the SCTs in the value of an SCT list extension, which
+// is an OCTET STRING wrapping a SignedCertificateTimestampList. See RFC 6962,
+// Section 3.3.
+func parseSCTList(value []byte) [][]byte {
+	var list []byte
+	if rest, err := asn1.Unmarshal(value, &list); err != nil || len(rest) != 0 {
+		return nil
+	}
+	s := cryptobyte.String(list)
+	var scts cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&scts) || !s.Empty() {
+		return nil
+	}
+	var ret [][]byte
+	for !scts.Empty() {
+		var sct []byte
+		if !readUint16LengthPrefixed(&scts, &sct) || len(sct) == 0 {
+			return nil
+		}
+		ret = append(ret, sct)
+	}
+	return ret
+}
+
+// x509Entry returns the timestamped entry of SCTs delivered separately from
+// leaf.
+func x509Entry(leaf *x509.Certificate) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(0) // entry_type = x509_entry
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(leaf.Raw)
+	})
+	return b.BytesOrPanic()
+}
+
+// precertificateEntry returns the timestamped entry of SCTs embedded in leaf,
+// which covers its TBSCertificate without the SCT list extension.
+func precertificateEntry(leaf, issuer *x509.Certificate) ([]byte, error) {
+	errMalformed := errors.New("tls: malformed certificate")
+	input := cryptobyte.String(leaf.RawTBSCertificate)
+	var tbs cryptobyte.String
+	if !input.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) {
+		return nil, errMalformed
+	}
+	extensionsTag := cryptobyte_asn1.Tag(3).Constructed().ContextSpecific()
+
+	var b cryptobyte.Builder
+	b.AddUint16(1) // entry_type = precert_entry
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	b.AddBytes(issuerKeyHash[:])
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for !tbs.Empty() {
+				var elem cryptobyte.String
+				var tag cryptobyte_asn1.Tag
+				if !tbs.ReadAnyASN1Element(&elem, &tag) {
+					b.SetError(errMalformed)
+					return
+				}
+				if tag != extensionsTag {
+					b.AddBytes(elem)
+					continue
+				}
+				var explicit, exts cryptobyte.String
+				if !elem.ReadASN1(&explicit, extensionsTag) ||
+					!explicit.ReadASN1(&exts, cryptobyte_asn1.SEQUENCE) {
+					b.SetError(errMalformed)
+					return
+				}
+				b.AddASN1(extensionsTag, func(b *cryptobyte.Builder) {
+					b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						for !exts.Empty() {
+							var ext, extContents cryptobyte.String
+							var oid asn1.ObjectIdentifier
+							if !exts.ReadASN1Element(&ext, cryptobyte_asn1.SEQUENCE) {
+								b.SetError(errMalformed)
+								return
+							}
+							extContents = ext
+							if !extContents.ReadASN1(&extContents, cryptobyte_asn1.SEQUENCE) ||
+								!extContents.ReadASN1ObjectIdentifier(&oid) {
+								b.SetError(errMalformed)
+								return
+							}
+							if !oid.Equal(oidSCTList) {
+								b.AddBytes(ext)
+							}
+						}
+					})
+				})
+			}
+		})
+	})
+	return b.Bytes()
+}
@@ -0,0 +1,263 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCTLog struct {
+	CTLog
+	key *ecdsa.PrivateKey
+}
+
+func newTestCTLog(t *testing.T, operator string) *testCTLog {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCTLog{CTLog: CTLog{Description: operator + " log", Operator: operator, Key: spki}, key: key}
+}
+
+// sign returns an SCT by l for entry, timestamped at timestamp.
+func (l *testCTLog) sign(t *testing.T, entry []byte, timestamp time.Time) []byte {
+	t.Helper()
+	var signed cryptobyte.Builder
+	signed.AddUint8(0) // version = v1
+	signed.AddUint8(0) // signature_type = certificate_timestamp
+	signed.AddUint64(uint64(timestamp.UnixMilli()))
+	signed.AddBytes(entry)
+	signed.AddUint16(0) // no extensions
+	digest := sha256.Sum256(signed.BytesOrPanic())
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logID := sha256.Sum256(l.Key)
+	var b cryptobyte.Builder
+	b.AddUint8(0)
+	b.AddBytes(logID[:])
+	b.AddUint64(uint64(timestamp.UnixMilli()))
+	b.AddUint16(0)
+	b.AddUint8(4) // sha256
+	b.AddUint8(3) // ecdsa
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(sig)
+	})
+	return b.BytesOrPanic()
+}
+
+func marshalSCTList(t *testing.T, scts [][]byte) []byte {
+	t.Helper()
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, sct := range scts {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(sct)
+			})
+		}
+	})
+	value, err := asn1.Marshal(b.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// ctTestPKI issues certificates from a test CA, optionally embedding SCTs for
+// them signed by logs.
+type ctTestPKI struct {
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	key   *ecdsa.PrivateKey
+	now   time.Time
+}
+
+func newCTTestPKI(t *testing.T) *ctTestPKI {
+	t.Helper()
+	p := &ctTestPKI{now: time.Now().Truncate(time.Second)}
+	var err error
+	if p.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	if p.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CT test CA"},
+		NotBefore:             p.now.Add(-time.Hour),
+		NotAfter:              p.now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, p.caKey.Public(), p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// issue returns a certificate valid for lifetime, with SCTs embedded by the
+// embed logs.
+func (p *ctTestPKI) issue(t *testing.T, lifetime time.Duration, embed ...*testCTLog) Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.golang"},
+		DNSNames:     []string{"example.golang"},
+		NotBefore:    p.now.Add(-time.Hour),
+		NotAfter:     p.now.Add(-time.Hour + lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	create := func() *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, p.key.Public(), p.caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf
+	}
+	leaf := create()
+	if len(embed) > 0 {
+		// The SCTs sign the certificate without them, which is what
+		// precertificateEntry reconstructs from the final certificate.
+		entry, err := precertificateEntry(leaf, p.ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var scts [][]byte
+		for _, log := range embed {
+			scts = append(scts, log.sign(t, entry, p.now))
+		}
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidSCTList, Value: marshalSCTList(t, scts)}}
+		leaf = create()
+	}
+	return Certificate{Certificate: [][]byte{leaf.Raw, p.ca.Raw}, PrivateKey: p.key, Leaf: leaf}
+}
+
+func TestCertificateTransparency(t *testing.T) {
+	p := newCTTestPKI(t)
+	logA, logB, logC := newTestCTLog(t, "A"), newTestCTLog(t, "B"), newTestCTLog(t, "C")
+	logA2 := newTestCTLog(t, "A")
+	short, long := 90*24*time.Hour, 365*24*time.Hour
+
+	plain := p.issue(t, short)
+	tlsSCTs := plain
+	tlsSCTs.SignedCertificateTimestamps = [][]byte{
+		logA.sign(t, x509Entry(plain.Leaf), p.now),
+		logB.sign(t, x509Entry(plain.Leaf), p.now),
+	}
+	futureSCTs := plain
+	futureSCTs.SignedCertificateTimestamps = [][]byte{
+		logA.sign(t, x509Entry(plain.Leaf), p.now.Add(time.Hour)),
+		logB.sign(t, x509Entry(plain.Leaf), p.now),
+	}
+	otherSCTs := plain
+	otherLeaf := p.issue(t, long).Leaf
+	otherSCTs.SignedCertificateTimestamps = [][]byte{
+		logA.sign(t, x509Entry(otherLeaf), p.now),
+		logB.sign(t, x509Entry(otherLeaf), p.now),
+	}
+
+	ocspSCTs := plain
+	ocspResp, err := ocsp.CreateResponse(p.ca, p.ca, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: plain.Leaf.SerialNumber,
+		ThisUpdate:   p.now.Add(-time.Hour),
+		NextUpdate:   p.now.Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidOCSPSCTList, Value: marshalSCTList(t, [][]byte{
+			logA.sign(t, x509Entry(plain.Leaf), p.now),
+			logC.sign(t, x509Entry(plain.Leaf), p.now),
+		})}},
+	}, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocspSCTs.OCSPStaple = ocspResp
+
+	for _, tt := range []struct {
+		name    string
+		version uint16
+		cert    Certificate
+		require bool
+		noLogs  bool
+		want    []SCTSource
+		err     bool
+	}{
+		{name: "TLSExtension", version: VersionTLS13, cert: tlsSCTs, require: true, want: []SCTSource{SCTSourceTLSExtension, SCTSourceTLSExtension}},
+		{name: "TLSExtension-TLS12", version: VersionTLS12, cert: tlsSCTs, require: true, want: []SCTSource{SCTSourceTLSExtension, SCTSourceTLSExtension}},
+		{name: "OCSP", version: VersionTLS13, cert: ocspSCTs, require: true, want: []SCTSource{SCTSourceOCSP, SCTSourceOCSP}},
+		{name: "Embedded", version: VersionTLS13, cert: p.issue(t, short, logA, logB), require: true, want: []SCTSource{SCTSourceEmbedded, SCTSourceEmbedded}},
+		{name: "EmbeddedLongLived", version: VersionTLS13, cert: p.issue(t, long, logA, logB), require: true, err: true},
+		{name: "EmbeddedLongLivedThreeLogs", version: VersionTLS13, cert: p.issue(t, long, logA, logB, logC), require: true, want: []SCTSource{SCTSourceEmbedded, SCTSourceEmbedded, SCTSourceEmbedded}},
+		{name: "SameOperator", version: VersionTLS13, cert: p.issue(t, short, logA, logA2), require: true, err: true},
+		{name: "None", version: VersionTLS13, cert: plain, require: true, err: true},
+		{name: "RequiredWithoutLogs", version: VersionTLS13, cert: tlsSCTs, require: true, noLogs: true, err: true},
+		{name: "NotRequired", version: VersionTLS13, cert: plain},
+		{name: "FutureTimestamp", version: VersionTLS13, cert: futureSCTs, want: []SCTSource{SCTSourceTLSExtension}},
+		{name: "OtherCertificate", version: VersionTLS13, cert: otherSCTs},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			serverConfig.Certificates = []Certificate{tt.cert}
+			serverConfig.NameToCertificate = nil
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.Time = func() time.Time { return p.now }
+			clientConfig.CTLogs = []CTLog{logA.CTLog, logB.CTLog, logC.CTLog, logA2.CTLog}
+			clientConfig.RequireCT = tt.require
+			if tt.noLogs {
+				clientConfig.CTLogs = nil
+			}
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.err {
+				if err == nil || !strings.Contains(err.Error(), "Certificate Transparency") {
+					t.Fatalf("got error %v, want a Certificate Transparency policy error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if len(cs.VerifiedSCTs) != len(tt.want) {
+				t.Fatalf("got %d verified SCTs, want %d", len(cs.VerifiedSCTs), len(tt.want))
+			}
+			for i, sct := range cs.VerifiedSCTs {
+				if sct.Source != tt.want[i] {
+					t.Errorf("SCT %d has source %v, want %v", i, sct.Source, tt.want[i])
+				}
+				if !sct.Timestamp.Equal(p.now) {
+					t.Errorf("SCT %d has timestamp %v, want %v", i, sct.Timestamp, p.now)
+				}
+			}
+		})
+	}
+}
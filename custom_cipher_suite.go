@@ -0,0 +1,163 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"errors"
+	"hash"
+)
+
+// A CustomCipherSuiteTLS13 is a TLS 1.3 cipher suite with a private use
+// codepoint, whose AEAD and hash are provided by the application, for closed
+// ecosystems experimenting with algorithms this package doesn't implement,
+// or the record protection and hash of one of the GOST cipher suites of RFC
+// 9367, whose key exchanges and signatures aren't supported. It's registered
+// with [RegisterCipherSuiteTLS13].
+type CustomCipherSuiteTLS13 struct {
+	// ID is the codepoint of the cipher suite, in the 0xff00–0xffff range
+	// reserved for private use by RFC 8446, Section 11, or one of the GOST
+	// cipher suites such as TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_L.
+	ID uint16
+
+	// Name is the name of the cipher suite, returned by [CipherSuiteName]
+	// and listed by [CipherSuites].
+	Name string
+
+	// KeyLen is the length in bytes of the keys of the AEAD.
+	KeyLen int
+
+	// NewAEAD returns the AEAD protecting the records with key, of KeyLen
+	// bytes. The AEAD must take 12 bytes nonces, which the record layer
+	// derives from the sequence number as specified in RFC 8446, Section
+	// 5.3. NewAEAD must not fail for keys of KeyLen bytes.
+	NewAEAD func(key []byte) (cipher.AEAD, error)
+
+	// NewRecordAEAD, if not nil, is used instead of NewAEAD, for the record
+	// protections that don't follow RFC 8446, Section 5.3, such as that of
+	// the GOST cipher suites, which derive a key for each range of records
+	// with TLSTREE. It returns the protection of the records with key, of
+	// KeyLen bytes, and iv, of IVLen bytes. Its Seal and Open methods are
+	// called with the 8 bytes sequence number of each record as the nonce.
+	// NewRecordAEAD must not fail for keys and IVs of those lengths.
+	NewRecordAEAD func(key, iv []byte) (cipher.AEAD, error)
+
+	// IVLen is the length in bytes of the write IVs derived by the key
+	// schedule, see RFC 8446, Section 7.3. It may only be set along with
+	// NewRecordAEAD. If zero, it's 12 bytes.
+	IVLen int
+
+	// NewHash returns the hash of the transcript and of the HKDF key
+	// schedule. Its states must be clonable with a Clone method or with
+	// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, as the
+	// hashes of the standard library, for HelloRetryRequest, ECH and
+	// post-handshake authentication.
+	NewHash func() hash.Hash
+}
+
+// customCipherSuitesTLS13 are the cipher suites registered with
+// RegisterCipherSuiteTLS13, in registration order. The tls13Hash of each is
+// its index plus customHashBase.
+var customCipherSuitesTLS13 []CustomCipherSuiteTLS13
+
+// customCipherSuites returns the entries of the custom cipher suites for
+// CipherSuites, in registration order.
+func customCipherSuites() []*CipherSuite {
+	var suites []*CipherSuite
+	for _, suite := range customCipherSuitesTLS13 {
+		suites = append(suites, &CipherSuite{suite.ID, suite.Name, supportedOnlyTLS13, false})
+	}
+	return suites
+}
+
+// customHashBase is the first tls13Hash of the custom cipher suites.
+const customHashBase tls13Hash = 0x200
+
+// customHash returns the hash of the custom cipher suite with tls13Hash h.
+func customHash(h tls13Hash) func() hash.Hash {
+	return customCipherSuitesTLS13[h-customHashBase].NewHash
+}
+
+// customIVLen returns the IV length of the custom cipher suite with
+// tls13Hash h.
+func customIVLen(h tls13Hash) int {
+	return customCipherSuitesTLS13[h-customHashBase].IVLen
+}
+
+// RegisterCipherSuiteTLS13 registers a custom TLS 1.3 cipher suite. Like the
+// TLS 1.3 AES-CCM cipher suites, it's then enabled by the Configs listing its
+// ID in CipherSuites, after the other TLS 1.3 cipher suites, and never with
+// QUIC.
+//
+// RegisterCipherSuiteTLS13 is meant to be called from init functions, and
+// must not be called concurrently with connections.
+func RegisterCipherSuiteTLS13(suite CustomCipherSuiteTLS13) error {
+	if suite.ID>>8 != 0xff && !slicesContains(gostCipherSuitesTLS13, suite.ID) {
+		return errors.New("tls: custom cipher suite ID outside of the private use range")
+	}
+	if cipherSuiteTLS13ByID(suite.ID) != nil {
+		return errors.New("tls: cipher suite " + CipherSuiteName(suite.ID) + " is already registered")
+	}
+	if suite.Name == "" || suite.KeyLen <= 0 || suite.NewAEAD == nil && suite.NewRecordAEAD == nil || suite.NewHash == nil {
+		return errors.New("tls: custom cipher suite needs Name, KeyLen, NewAEAD or NewRecordAEAD, and NewHash")
+	}
+	if suite.IVLen < 0 || suite.IVLen != 0 && suite.NewRecordAEAD == nil {
+		return errors.New("tls: custom cipher suite IVLen needs NewRecordAEAD")
+	}
+	if suite.IVLen == 0 {
+		suite.IVLen = aeadNonceLength
+	}
+
+	cs := &cipherSuiteTLS13{
+		id:     suite.ID,
+		keyLen: suite.KeyLen,
+		hash:   customHashBase + tls13Hash(len(customCipherSuitesTLS13)),
+	}
+	if newRecordAEAD := suite.NewRecordAEAD; newRecordAEAD != nil {
+		a, err := newRecordAEAD(make([]byte, suite.KeyLen), make([]byte, suite.IVLen))
+		if err != nil {
+			return errors.New("tls: custom cipher suite AEAD failed: " + err.Error())
+		}
+		if a.NonceSize() != 8 {
+			return errors.New("tls: custom cipher suite record AEAD doesn't take 8 bytes nonces")
+		}
+		cs.aead = func(key, iv []byte) aead {
+			a, err := newRecordAEAD(key, iv)
+			if err != nil {
+				panic("tls: custom cipher suite AEAD failed: " + err.Error())
+			}
+			return customRecordAEAD{a}
+		}
+	} else {
+		a, err := suite.NewAEAD(make([]byte, suite.KeyLen))
+		if err != nil {
+			return errors.New("tls: custom cipher suite AEAD failed: " + err.Error())
+		}
+		if a.NonceSize() != aeadNonceLength {
+			return errors.New("tls: custom cipher suite AEAD doesn't take 12 bytes nonces")
+		}
+		newAEAD := suite.NewAEAD
+		cs.aead = func(key, nonceMask []byte) aead {
+			if len(nonceMask) != aeadNonceLength {
+				panic("tls: internal error: wrong nonce length")
+			}
+			a, err := newAEAD(key)
+			if err != nil {
+				panic("tls: custom cipher suite AEAD failed: " + err.Error())
+			}
+			ret := &xorNonceAEAD{aead: a}
+			copy(ret.nonceMask[:], nonceMask)
+			return ret
+		}
+	}
+	customCipherSuitesTLS13 = append(customCipherSuitesTLS13, suite)
+	cipherSuitesTLS13 = append(cipherSuitesTLS13, cs)
+	return nil
+}
+
+// customRecordAEAD is the record protection returned by
+// CustomCipherSuiteTLS13.NewRecordAEAD, which takes the sequence numbers as
+// nonces, like xorNonceAEAD.
+type customRecordAEAD struct {
+	cipher.AEAD
+}
+
+func (customRecordAEAD) explicitNonceLen() int { return 0 }
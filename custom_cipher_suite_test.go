@@ -0,0 +1,171 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"crypto/sha512"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const testCustomCipherSuite uint16 = 0xff42
+
+var (
+	registerTestCipherSuiteOnce sync.Once
+	registerTestCipherSuiteErr  error
+)
+
+// registerTestCipherSuite registers testCustomCipherSuite, once per process
+// as it can't be unregistered.
+func registerTestCipherSuite() error {
+	registerTestCipherSuiteOnce.Do(func() {
+		registerTestCipherSuiteErr = RegisterCipherSuiteTLS13(CustomCipherSuiteTLS13{
+			ID:      testCustomCipherSuite,
+			Name:    "TLS_CHACHA20_POLY1305_SHA512",
+			KeyLen:  chacha20poly1305.KeySize,
+			NewAEAD: chacha20poly1305.New,
+			NewHash: sha512.New,
+		})
+	})
+	return registerTestCipherSuiteErr
+}
+
+// withoutDefaultCipherSuitesTLS13 makes the TLS 1.3 connections offer only
+// the cipher suites of their Config until the end of the test.
+func withoutDefaultCipherSuitesTLS13(t *testing.T) {
+	suites, suitesNoAES := defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES
+	t.Cleanup(func() {
+		defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = suites, suitesNoAES
+	})
+	defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = nil, nil
+}
+
+func TestCustomCipherSuiteTLS13(t *testing.T) {
+	if err := registerTestCipherSuite(); err != nil {
+		t.Fatal(err)
+	}
+	if name := CipherSuiteName(testCustomCipherSuite); name != "TLS_CHACHA20_POLY1305_SHA512" {
+		t.Errorf("got name %q", name)
+	}
+
+	// Make the client offer only the custom suite.
+	withoutDefaultCipherSuitesTLS13(t)
+
+	clientConfig := testConfig.Clone()
+	clientConfig.CipherSuites = []uint16{testCustomCipherSuite}
+	serverConfig := testConfig.Clone()
+	serverConfig.CipherSuites = []uint16{testCustomCipherSuite}
+	// A HelloRetryRequest clones the transcript hash.
+	serverConfig.CurvePreferences = []CurveID{CurveP384}
+
+	ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.CipherSuite != testCustomCipherSuite || !ss.HelloRetryRequest {
+		t.Errorf("got cipher suite %s and HelloRetryRequest %v", CipherSuiteName(cs.CipherSuite), ss.HelloRetryRequest)
+	}
+
+	// Unless listed, it isn't enabled.
+	if slicesContains(testConfig.tls13CipherSuites(nil, false), testCustomCipherSuite) ||
+		slicesContains(clientConfig.tls13CipherSuites(nil, true), testCustomCipherSuite) {
+		t.Error("custom cipher suite enabled without being listed or with QUIC")
+	}
+}
+
+func TestRegisterCipherSuiteTLS13Errors(t *testing.T) {
+	if err := registerTestCipherSuite(); err != nil {
+		t.Fatal(err)
+	}
+	newAEAD := func(key []byte) (cipher.AEAD, error) { return chacha20poly1305.NewX(key) }
+	for _, tt := range []struct {
+		name  string
+		suite CustomCipherSuiteTLS13
+		want  string
+	}{
+		{"PublicID", CustomCipherSuiteTLS13{ID: 0x1306, KeyLen: 32, NewAEAD: chacha20poly1305.New, NewHash: sha512.New}, "private use"},
+		{"Duplicate", CustomCipherSuiteTLS13{ID: testCustomCipherSuite, KeyLen: 32, NewAEAD: chacha20poly1305.New, NewHash: sha512.New}, "already registered"},
+		{"NoHash", CustomCipherSuiteTLS13{ID: 0xff43, Name: "x", KeyLen: 32, NewAEAD: chacha20poly1305.New}, "NewHash"},
+		{"BadKeyLen", CustomCipherSuiteTLS13{ID: 0xff43, Name: "x", KeyLen: 16, NewAEAD: chacha20poly1305.New, NewHash: sha512.New}, "AEAD failed"},
+		{"NonceSize", CustomCipherSuiteTLS13{ID: 0xff43, Name: "x", KeyLen: 32, NewAEAD: newAEAD, NewHash: sha512.New}, "12 bytes nonces"},
+		{"IVLenWithoutRecordAEAD", CustomCipherSuiteTLS13{ID: 0xff43, Name: "x", KeyLen: 32, IVLen: 16, NewAEAD: chacha20poly1305.New, NewHash: sha512.New}, "IVLen"},
+		{"RecordNonceSize", CustomCipherSuiteTLS13{ID: 0xff43, Name: "x", KeyLen: 32, NewRecordAEAD: func(key, iv []byte) (cipher.AEAD, error) { return chacha20poly1305.New(key) }, NewHash: sha512.New}, "8 bytes nonces"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterCipherSuiteTLS13(tt.suite); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// testRecordAEAD stands in for the record protection of the GOST cipher
+// suites, deriving the nonce of each record from the 16 bytes IV.
+type testRecordAEAD struct {
+	cipher.AEAD
+	iv []byte
+}
+
+func (a testRecordAEAD) NonceSize() int { return 8 }
+
+func (a testRecordAEAD) nonce(seq []byte) []byte {
+	nonce := make([]byte, aeadNonceLength)
+	copy(nonce, a.iv[4:])
+	for i, b := range seq {
+		nonce[4+i] ^= b
+	}
+	return nonce
+}
+
+func (a testRecordAEAD) Seal(dst, seq, plaintext, additionalData []byte) []byte {
+	return a.AEAD.Seal(dst, a.nonce(seq), plaintext, additionalData)
+}
+
+func (a testRecordAEAD) Open(dst, seq, ciphertext, additionalData []byte) ([]byte, error) {
+	return a.AEAD.Open(dst, a.nonce(seq), ciphertext, additionalData)
+}
+
+var (
+	registerTestGOSTCipherSuiteOnce sync.Once
+	registerTestGOSTCipherSuiteErr  error
+	testGOSTIVLen                   atomic.Int32 // of the last record AEAD
+)
+
+func TestGOSTCipherSuiteRegistration(t *testing.T) {
+	registerTestGOSTCipherSuiteOnce.Do(func() {
+		registerTestGOSTCipherSuiteErr = RegisterCipherSuiteTLS13(CustomCipherSuiteTLS13{
+			ID:     TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_L,
+			Name:   "TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_L",
+			KeyLen: chacha20poly1305.KeySize,
+			IVLen:  16,
+			NewRecordAEAD: func(key, iv []byte) (cipher.AEAD, error) {
+				testGOSTIVLen.Store(int32(len(iv)))
+				a, err := chacha20poly1305.New(key)
+				return testRecordAEAD{a, iv}, err
+			},
+			NewHash: sha512.New,
+		})
+	})
+	if err := registerTestGOSTCipherSuiteErr; err != nil {
+		t.Fatal(err)
+	}
+	testGOSTIVLen.Store(0)
+
+	withoutDefaultCipherSuitesTLS13(t)
+
+	config := testConfig.Clone()
+	config.CipherSuites = []uint16{TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_L}
+	_, cs, err := testHandshake(t, config, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.CipherSuite != TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_L {
+		t.Errorf("got cipher suite %s", CipherSuiteName(cs.CipherSuite))
+	}
+	if n := testGOSTIVLen.Load(); n != 16 {
+		t.Errorf("record AEAD created with a %d bytes IV", n)
+	}
+}
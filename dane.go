@@ -0,0 +1,155 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Certificate usages of TLSA records, see RFC 7218, Section 2.1.
+const (
+	// TLSAUsagePKIXTA requires the chain to be verified against
+	// Config.RootCAs, through the CA certificate matched by the record.
+	TLSAUsagePKIXTA uint8 = 0
+	// TLSAUsagePKIXEE requires the chain to be verified against
+	// Config.RootCAs, and the leaf certificate to match the record.
+	TLSAUsagePKIXEE uint8 = 1
+	// TLSAUsageDANETA makes the CA certificate matched by the record, which
+	// must be sent by the server unless the record holds a full
+	// certificate, the only trust anchor of the chain.
+	TLSAUsageDANETA uint8 = 2
+	// TLSAUsageDANEEE only requires the leaf certificate to match the
+	// record. Its names and validity period are not checked, see RFC 7671,
+	// Section 5.1.
+	TLSAUsageDANEEE uint8 = 3
+)
+
+// Selectors of TLSA records, see RFC 7218, Section 2.2.
+const (
+	TLSASelectorCert uint8 = 0 // the whole certificate
+	TLSASelectorSPKI uint8 = 1 // its SubjectPublicKeyInfo
+)
+
+// Matching types of TLSA records, see RFC 7218, Section 2.3.
+const (
+	TLSAMatchingFull   uint8 = 0 // the selected content itself
+	TLSAMatchingSHA256 uint8 = 1 // its SHA-256 hash
+	TLSAMatchingSHA512 uint8 = 2 // its SHA-512 hash
+)
+
+// A TLSARecord is a DNS TLSA resource record, which associates a certificate
+// or public key with a TLS server, as specified in RFC 6698. See
+// [Config.TLSARecords].
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	// Data is the certificate association data.
+	Data []byte
+}
+
+// usable reports whether r has parameters known to this package. Other
+// records are ignored, see RFC 6698, Section 4.1.
+func (r *TLSARecord) usable() bool {
+	return r.Usage <= TLSAUsageDANEEE && r.Selector <= TLSASelectorSPKI && r.MatchingType <= TLSAMatchingSHA512
+}
+
+// matches reports whether cert is the certificate associated by r.
+func (r *TLSARecord) matches(cert *x509.Certificate) bool {
+	content := cert.Raw
+	if r.Selector == TLSASelectorSPKI {
+		content = cert.RawSubjectPublicKeyInfo
+	}
+	switch r.MatchingType {
+	case TLSAMatchingSHA256:
+		h := sha256.Sum256(content)
+		return bytes.Equal(h[:], r.Data)
+	case TLSAMatchingSHA512:
+		h := sha512.Sum512(content)
+		return bytes.Equal(h[:], r.Data)
+	default:
+		return bytes.Equal(content, r.Data)
+	}
+}
+
+// verifyTLSA returns the verified chains of the server certificates certs if
+// they match one of the usable records of c.TLSARecords, as specified in RFC
+// 7671. opts are the options of the PKIX verification. Without usable
+// records, certs are verified as if c.TLSARecords were empty.
+func (c *Config) verifyTLSA(ctx context.Context, rawCerts [][]byte, certs []*x509.Certificate, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+	var usable bool
+	var pkixChains [][]*x509.Certificate
+	var pkixErr error
+	var pkixVerified bool
+	for i := range c.TLSARecords {
+		r := &c.TLSARecords[i]
+		if !r.usable() {
+			continue
+		}
+		usable = true
+		switch r.Usage {
+		case TLSAUsageDANEEE:
+			if r.matches(certs[0]) {
+				return [][]*x509.Certificate{certs[:1]}, nil
+			}
+		case TLSAUsageDANETA:
+			if chains, err := c.verifyDANETA(ctx, r, rawCerts, certs, opts); err == nil {
+				return chains, nil
+			}
+		default:
+			if !pkixVerified {
+				pkixChains, pkixErr = c.verifyCertificateChains(ctx, rawCerts, certs, opts)
+				pkixVerified = true
+			}
+			var matching [][]*x509.Certificate
+			for _, chain := range pkixChains {
+				candidates := chain[:1]
+				if r.Usage == TLSAUsagePKIXTA {
+					candidates = chain[1:]
+				}
+				if slicesContainsFunc(candidates, r.matches) {
+					matching = append(matching, chain)
+				}
+			}
+			if len(matching) > 0 {
+				return matching, nil
+			}
+		}
+	}
+	if !usable {
+		return c.verifyCertificateChains(ctx, rawCerts, certs, opts)
+	}
+	if pkixErr != nil {
+		return nil, fmt.Errorf("tls: server certificate matches none of the TLSA records: %w", pkixErr)
+	}
+	return nil, errors.New("tls: server certificate matches none of the TLSA records")
+}
+
+// verifyDANETA verifies certs with the trust anchor associated by the
+// DANE-TA record r.
+func (c *Config) verifyDANETA(ctx context.Context, r *TLSARecord, rawCerts [][]byte, certs []*x509.Certificate, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+	roots := x509.NewCertPool()
+	var found bool
+	for _, cert := range certs[1:] {
+		if r.matches(cert) {
+			roots.AddCert(cert)
+			found = true
+		}
+	}
+	// A trust anchor published in full needn't be sent by the server.
+	if r.Selector == TLSASelectorCert && r.MatchingType == TLSAMatchingFull {
+		if anchor, err := x509.ParseCertificate(r.Data); err == nil {
+			roots.AddCert(anchor)
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.New("tls: no trust anchor matches the DANE-TA record")
+	}
+	opts.Roots = roots
+	return c.verifyCertificateChains(ctx, rawCerts, certs, opts)
+}
@@ -0,0 +1,81 @@
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLSARecords(t *testing.T) {
+	leaf, err := x509.ParseCertificate(testRSACertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafSPKIHash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	issuerHash := sha256.Sum256(issuer.Raw)
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+
+	for _, tt := range []struct {
+		name       string
+		record     TLSARecord
+		sendIssuer bool
+		trustRoot  bool
+		expired    bool
+		wantErr    string
+	}{
+		{name: "DANEEE", record: TLSARecord{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchingSHA256, leafSPKIHash[:]}, expired: true},
+		{name: "DANEEEFull", record: TLSARecord{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchingFull, leaf.Raw}},
+		{name: "DANEEEMismatch", record: TLSARecord{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchingSHA256, issuerHash[:]}, trustRoot: true, wantErr: "none of the TLSA records"},
+		{name: "DANETA", record: TLSARecord{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingSHA256, issuerHash[:]}, sendIssuer: true},
+		{name: "DANETANotSent", record: TLSARecord{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingSHA256, issuerHash[:]}, wantErr: "none of the TLSA records"},
+		{name: "DANETAPublished", record: TLSARecord{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingFull, issuer.Raw}},
+		{name: "DANETAExpired", record: TLSARecord{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingFull, issuer.Raw}, expired: true, wantErr: "none of the TLSA records"},
+		{name: "PKIXTA", record: TLSARecord{TLSAUsagePKIXTA, TLSASelectorCert, TLSAMatchingSHA256, issuerHash[:]}, trustRoot: true},
+		{name: "PKIXTAUntrusted", record: TLSARecord{TLSAUsagePKIXTA, TLSASelectorCert, TLSAMatchingSHA256, issuerHash[:]}, sendIssuer: true, wantErr: "certificate signed by unknown authority"},
+		{name: "PKIXEE", record: TLSARecord{TLSAUsagePKIXEE, TLSASelectorSPKI, TLSAMatchingSHA256, leafSPKIHash[:]}, trustRoot: true},
+		{name: "PKIXEEMismatch", record: TLSARecord{TLSAUsagePKIXEE, TLSASelectorCert, TLSAMatchingSHA256, issuerHash[:]}, trustRoot: true, wantErr: "none of the TLSA records"},
+		{name: "Unusable", record: TLSARecord{4, TLSASelectorCert, TLSAMatchingSHA256, issuerHash[:]}, trustRoot: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.Certificates = []Certificate{{Certificate: [][]byte{testRSACertificate}, PrivateKey: testRSAPrivateKey}}
+			if tt.sendIssuer {
+				serverConfig.Certificates[0].Certificate = append(serverConfig.Certificates[0].Certificate, testRSACertificateIssuer)
+			}
+			serverConfig.NameToCertificate = nil
+			clientConfig := testConfig.Clone()
+			clientConfig.InsecureSkipVerify = false
+			clientConfig.ServerName = "example.golang"
+			clientConfig.RootCAs = x509.NewCertPool()
+			if tt.trustRoot {
+				clientConfig.RootCAs = roots
+			}
+			clientConfig.Time = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+			if tt.expired {
+				clientConfig.Time = func() time.Time { return time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC) }
+			}
+			clientConfig.TLSARecords = []TLSARecord{tt.record}
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cs.VerifiedChains) == 0 || !cs.VerifiedChains[0][0].Equal(leaf) {
+				t.Errorf("got verified chains %v", cs.VerifiedChains)
+			}
+		})
+	}
+}
@@ -54,7 +54,8 @@ func defaultCipherSuites(aesGCMPreferred bool) []uint16 {
 	return slicesDeleteFunc(cipherSuites, func(c uint16) bool {
 		return disabledCipherSuites[c] ||
 			rsaKexCiphers[c] ||
-			tdesCiphers[c]
+			tdesCiphers[c] ||
+			ecdhePSKCiphers[c]
 	})
 }
 
@@ -0,0 +1,258 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// oidDelegationUsage is the DelegationUsage certificate extension, which
+// allows the certificate to issue delegated credentials. See RFC 9345,
+// Section 4.2.
+var oidDelegationUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 44363, 44}
+
+// maxDelegatedCredentialValidity is the longest remaining validity of the
+// delegated credentials clients accept. See RFC 9345, Section 4.1.3.
+const maxDelegatedCredentialValidity = 7 * 24 * time.Hour
+
+const delegatedCredentialServerContext = "TLS, server delegated credentials\x00"
+
+// delegatedCredentialSchemes are the signature algorithms of the delegated
+// credentials that can be minted, and that clients accept. RSA keys are not
+// supported, since credentials can't use rsa_pkcs1 or rsa_pss_rsae schemes.
+var delegatedCredentialSchemes = []SignatureScheme{
+	ECDSAWithP256AndSHA256,
+	ECDSAWithP384AndSHA384,
+	ECDSAWithP521AndSHA512,
+	Ed25519,
+}
+
+// A DelegatedCredential is a short-lived key a server certificate delegates
+// the signing of TLS 1.3 handshakes to, as specified in RFC 9345. It lets the
+// certificate's own private key stay off the servers terminating
+// connections, which only hold credentials valid for a few days.
+//
+// Delegated credentials are minted with [NewDelegatedCredential], and used by
+// servers when set in [Certificate.DelegatedCredentials]. Clients accept them
+// if [Config.AcceptDelegatedCredentials] is set.
+type DelegatedCredential struct {
+	// Raw is the encoded delegated credential, as sent in handshakes.
+	Raw []byte
+
+	// ValidTime is the validity period of the credential, counted from the
+	// NotBefore time of the certificate that issued it.
+	ValidTime time.Duration
+
+	// Scheme is the signature algorithm the credential signs handshakes
+	// with, which is bound to its PublicKey.
+	Scheme SignatureScheme
+
+	// PublicKey is the public key of the credential.
+	PublicKey crypto.PublicKey
+
+	// Algorithm is the signature algorithm the issuing certificate signed
+	// the credential with.
+	Algorithm SignatureScheme
+
+	// PrivateKey is the private key corresponding to PublicKey. It's set by
+	// NewDelegatedCredential, and must be set by servers using a credential
+	// obtained from ParseDelegatedCredential. It's never set for the
+	// credentials received from peers.
+	PrivateKey crypto.Signer
+
+	cred      []byte // the encoded Credential structure
+	signature []byte
+}
+
+// NotAfter returns the expiration time of the credential issued by leaf.
+func (dc *DelegatedCredential) NotAfter(leaf *x509.Certificate) time.Time {
+	return leaf.NotBefore.Add(dc.ValidTime)
+}
+
+// NewDelegatedCredential generates a key for the signature algorithm scheme,
+// and returns a delegated credential for it signed by cert, valid until
+// notAfter. The leaf certificate of cert must have the DelegationUsage
+// extension and allow digital signatures.
+//
+// scheme must be one of ECDSAWithP256AndSHA256, ECDSAWithP384AndSHA384,
+// ECDSAWithP521AndSHA512 or Ed25519. Clients reject credentials that are
+// valid for more than seven days at the time of the handshake, so notAfter
+// should be at most that far in the future.
+func NewDelegatedCredential(cert *Certificate, scheme SignatureScheme, notAfter time.Time) (*DelegatedCredential, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("tls: delegated credential issuer has no certificate")
+	}
+	leaf, err := cert.leaf()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDelegationUsage(leaf); err != nil {
+		return nil, err
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("tls: certificate private key (%T) doesn't implement crypto.Signer", cert.PrivateKey)
+	}
+	validTime := notAfter.Sub(leaf.NotBefore)
+	if validTime <= 0 || validTime > math.MaxUint32*time.Second {
+		return nil, errors.New("tls: delegated credential expiration is out of range")
+	}
+
+	var priv crypto.Signer
+	switch scheme {
+	case ECDSAWithP256AndSHA256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAWithP384AndSHA384:
+		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case ECDSAWithP521AndSHA512:
+		priv, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case Ed25519:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("tls: unsupported delegated credential signature algorithm %v", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Ed448 issuers are only usable with clients that opted in to Ed448.
+	issuerAlgs := append(supportedSignatureAlgorithms(VersionTLS13), Ed448)
+	algorithm, err := selectSignatureScheme(VersionTLS13, cert, issuerAlgs)
+	if err != nil {
+		return nil, err
+	}
+	dc := &DelegatedCredential{
+		ValidTime:  validTime.Truncate(time.Second),
+		Scheme:     scheme,
+		PublicKey:  priv.Public(),
+		Algorithm:  algorithm,
+		PrivateKey: priv,
+	}
+	spki, err := x509.MarshalPKIXPublicKey(dc.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	var b cryptobyte.Builder
+	b.AddUint32(uint32(dc.ValidTime / time.Second))
+	b.AddUint16(uint16(dc.Scheme))
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(spki)
+	})
+	if dc.cred, err = b.Bytes(); err != nil {
+		return nil, err
+	}
+
+	sigType, sigHash, err := typeAndHashFromSignatureScheme(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	signOpts := crypto.SignerOpts(sigHash)
+	if sigType == signatureRSAPSS {
+		signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
+	} else if sigType == signatureSM2 {
+		signOpts = sm2SignerOpts
+	}
+	dc.signature, err = signContext(context.Background(), signer, rand.Reader, dc.signedMessage(leaf), signOpts)
+	if err != nil {
+		return nil, errors.New("tls: failed to sign delegated credential: " + err.Error())
+	}
+
+	b = cryptobyte.Builder{}
+	b.AddBytes(dc.cred)
+	b.AddUint16(uint16(dc.Algorithm))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(dc.signature)
+	})
+	if dc.Raw, err = b.Bytes(); err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+// ParseDelegatedCredential parses an encoded delegated credential, such as
+// the Raw field of one minted by [NewDelegatedCredential]. Its PrivateKey is
+// not set.
+func ParseDelegatedCredential(raw []byte) (*DelegatedCredential, error) {
+	dc := &DelegatedCredential{Raw: slicesClone(raw)}
+	s := cryptobyte.String(dc.Raw)
+	var validTime uint32
+	var spki []byte
+	if !s.ReadUint32(&validTime) ||
+		!s.ReadUint16((*uint16)(&dc.Scheme)) ||
+		!readUint24LengthPrefixed(&s, &spki) || len(spki) == 0 {
+		return nil, errors.New("tls: malformed delegated credential")
+	}
+	dc.cred = dc.Raw[:len(dc.Raw)-len(s)]
+	if !s.ReadUint16((*uint16)(&dc.Algorithm)) ||
+		!readUint16LengthPrefixed(&s, &dc.signature) || len(dc.signature) == 0 ||
+		!s.Empty() {
+		return nil, errors.New("tls: malformed delegated credential")
+	}
+	dc.ValidTime = time.Duration(validTime) * time.Second
+
+	pub, err := x509.ParsePKIXPublicKey(spki)
+	if err != nil {
+		return nil, errors.New("tls: malformed delegated credential public key: " + err.Error())
+	}
+	dc.PublicKey = pub
+	if !isSupportedSignatureAlgorithm(dc.Scheme, signatureSchemesForPublicKey(VersionTLS13, pub)) {
+		return nil, errors.New("tls: delegated credential public key doesn't match its signature algorithm")
+	}
+	return dc, nil
+}
+
+// signedMessage returns the message signed by leaf to issue dc. See RFC 9345,
+// Section 4.
+func (dc *DelegatedCredential) signedMessage(leaf *x509.Certificate) []byte {
+	b := bytes.NewBuffer(make([]byte, 0, 64+len(delegatedCredentialServerContext)+len(leaf.Raw)+len(dc.cred)+2))
+	b.Write(signaturePadding)
+	b.WriteString(delegatedCredentialServerContext)
+	b.Write(leaf.Raw)
+	b.Write(dc.cred)
+	b.Write([]byte{byte(dc.Algorithm >> 8), byte(dc.Algorithm)})
+	return b.Bytes()
+}
+
+// verify checks that dc was issued by leaf.
+func (dc *DelegatedCredential) verify(leaf *x509.Certificate) error {
+	if err := checkDelegationUsage(leaf); err != nil {
+		return err
+	}
+	if !isSupportedSignatureAlgorithm(dc.Algorithm, signatureSchemesForPublicKey(VersionTLS13, leaf.PublicKey)) {
+		return errors.New("tls: delegated credential signed with invalid signature algorithm")
+	}
+	sigType, sigHash, err := typeAndHashFromSignatureScheme(dc.Algorithm)
+	if err != nil {
+		return err
+	}
+	if err := verifyHandshakeSignature(sigType, leaf.PublicKey, sigHash, dc.signedMessage(leaf), dc.signature); err != nil {
+		return errors.New("tls: invalid signature on delegated credential: " + err.Error())
+	}
+	return nil
+}
+
+// checkDelegationUsage checks that leaf can issue delegated credentials.
+func checkDelegationUsage(leaf *x509.Certificate) error {
+	if leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return errors.New("tls: certificate can't issue delegated credentials without the digitalSignature key usage")
+	}
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidDelegationUsage) {
+			return nil
+		}
+	}
+	return errors.New("tls: certificate can't issue delegated credentials without the DelegationUsage extension")
+}
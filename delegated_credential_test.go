@@ -0,0 +1,185 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func delegationCertificate(t *testing.T, delegation bool, notBefore time.Time) Certificate {
+	t.Helper()
+	return delegationCertificateOnCurve(t, elliptic.P256(), delegation, notBefore)
+}
+
+func delegationCertificateOnCurve(t *testing.T, curve elliptic.Curve, delegation bool, notBefore time.Time) Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.golang"},
+		DNSNames:     []string{"example.golang"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if delegation {
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidDelegationUsage, Value: []byte{0x05, 0x00}}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestDelegatedCredentials(t *testing.T) {
+	notBefore := time.Unix(1700000000, 0)
+	now := notBefore.Add(time.Hour)
+	cert := delegationCertificate(t, true, notBefore)
+	dc, err := NewDelegatedCredential(&cert, Ed25519, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	longDC, err := NewDelegatedCredential(&cert, ECDSAWithP256AndSHA256, now.Add(30*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		version    uint16
+		accept     bool
+		dcs        []*DelegatedCredential
+		serverTime time.Time
+		noKey      bool
+		want       *DelegatedCredential
+		err        string
+	}{
+		{name: "Accepted", version: VersionTLS13, accept: true, dcs: []*DelegatedCredential{dc}, want: dc},
+		{name: "WithoutParentKey", version: VersionTLS13, accept: true, dcs: []*DelegatedCredential{dc}, noKey: true, want: dc},
+		{name: "NotAccepted", version: VersionTLS13, dcs: []*DelegatedCredential{dc}},
+		{name: "TLS12", version: VersionTLS12, accept: true, dcs: []*DelegatedCredential{dc}},
+		{name: "Expired", version: VersionTLS13, accept: true, dcs: []*DelegatedCredential{dc}, serverTime: now.Add(48 * time.Hour)},
+		{name: "TooLong", version: VersionTLS13, accept: true, dcs: []*DelegatedCredential{longDC}, err: "valid for too long"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverCert := cert
+			serverCert.DelegatedCredentials = tt.dcs
+			if tt.noKey {
+				serverCert.PrivateKey = nil
+			}
+			serverConfig := testConfig.Clone()
+			serverConfig.Certificates = []Certificate{serverCert}
+			serverConfig.NameToCertificate = nil
+			serverConfig.MaxVersion = tt.version
+			serverConfig.Time = func() time.Time { return now }
+			if !tt.serverTime.IsZero() {
+				serverConfig.Time = func() time.Time { return tt.serverTime }
+			}
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.AcceptDelegatedCredentials = tt.accept
+			clientConfig.Time = func() time.Time { return now }
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if tt.want == nil {
+				if cs.DelegatedCredential != nil {
+					t.Error("unexpected delegated credential")
+				}
+				return
+			}
+			if cs.DelegatedCredential == nil || string(cs.DelegatedCredential.Raw) != string(tt.want.Raw) {
+				t.Fatal("delegated credential not used")
+			}
+			if cs.DelegatedCredential.PrivateKey != nil {
+				t.Error("peer delegated credential has a private key")
+			}
+			if cs.PeerSignatureScheme != tt.want.Scheme {
+				t.Errorf("peer signature algorithm is %v, want %v", cs.PeerSignatureScheme, tt.want.Scheme)
+			}
+		})
+	}
+}
+
+func TestDelegatedCredentialWeakKey(t *testing.T) {
+	notBefore := time.Unix(1700000000, 0)
+	now := notBefore.Add(time.Hour)
+	cert := delegationCertificateOnCurve(t, elliptic.P384(), true, notBefore)
+	dc, err := NewDelegatedCredential(&cert, ECDSAWithP256AndSHA256, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.DelegatedCredentials = []*DelegatedCredential{dc}
+	serverConfig := testConfig.Clone()
+	serverConfig.Certificates = []Certificate{cert}
+	serverConfig.NameToCertificate = nil
+	serverConfig.Time = func() time.Time { return now }
+	clientConfig := testConfig.Clone()
+	clientConfig.AcceptDelegatedCredentials = true
+	clientConfig.MinECStrength = 192
+	clientConfig.Time = func() time.Time { return now }
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil || !strings.Contains(err.Error(), "delegated credential containing 128-bit") {
+		t.Fatalf("got error %v, want a weak delegated credential", err)
+	}
+}
+
+func TestNewDelegatedCredential(t *testing.T) {
+	notBefore := time.Unix(1700000000, 0)
+	cert := delegationCertificate(t, true, notBefore)
+	for _, scheme := range delegatedCredentialSchemes {
+		dc, err := NewDelegatedCredential(&cert, scheme, notBefore.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("%v: %v", scheme, err)
+		}
+		parsed, err := ParseDelegatedCredential(dc.Raw)
+		if err != nil {
+			t.Fatalf("%v: %v", scheme, err)
+		}
+		if parsed.Scheme != scheme || parsed.Algorithm != dc.Algorithm || parsed.ValidTime != time.Hour ||
+			!parsed.NotAfter(cert.Leaf).Equal(notBefore.Add(time.Hour)) {
+			t.Errorf("%v: parsed credential doesn't match", scheme)
+		}
+		if err := parsed.verify(cert.Leaf); err != nil {
+			t.Errorf("%v: %v", scheme, err)
+		}
+
+		other := delegationCertificate(t, true, notBefore)
+		if err := parsed.verify(other.Leaf); err == nil {
+			t.Errorf("%v: credential verified with another certificate", scheme)
+		}
+	}
+
+	if _, err := NewDelegatedCredential(&cert, PSSWithSHA256, notBefore.Add(time.Hour)); err == nil {
+		t.Error("minted a credential with an RSA signature algorithm")
+	}
+	if _, err := NewDelegatedCredential(&cert, Ed25519, notBefore.Add(-time.Hour)); err == nil {
+		t.Error("minted a credential expiring before the certificate is valid")
+	}
+	noDelegation := delegationCertificate(t, false, notBefore)
+	if _, err := NewDelegatedCredential(&noDelegation, Ed25519, notBefore.Add(time.Hour)); err == nil {
+		t.Error("minted a credential with a certificate without DelegationUsage")
+	}
+}
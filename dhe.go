@@ -0,0 +1,289 @@
+package tls
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"strconv"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	// minDHEPrimeBits is the default of Config.MinDHGroupSize, and
+	// maxDHEPrimeBits the maximum size of the primes accepted from servers
+	// which don't use the groups of RFC 7919.
+	minDHEPrimeBits = 2048
+	maxDHEPrimeBits = 8192
+)
+
+// ffdheGroup is a finite field Diffie-Hellman group of RFC 7919, Appendix A,
+// all of which have generator 2.
+type ffdheGroup struct {
+	id CurveID
+	p  *big.Int
+}
+
+var ffdheGroups = []ffdheGroup{
+	{FFDHE2048, ffdhePrime(ffdhe2048Prime)},
+	{FFDHE3072, ffdhePrime(ffdhe3072Prime)},
+	{FFDHE4096, ffdhePrime(ffdhe4096Prime)},
+}
+
+// The primes of the FFDHE groups, see RFC 7919, Appendix A.
+const (
+	ffdhe2048Prime = "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B423861285C97FFFFFFFFFFFFFFFF"
+
+	ffdhe3072Prime = "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B66C62E37FFFFFFFFFFFFFFFF"
+
+	ffdhe4096Prime = "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B669E1EF16E6F52C3164DF4FB" +
+		"7930E9E4E58857B6AC7D5F42D69F6D187763CF1D5503400487F55BA57E31CC7A" +
+		"7135C886EFB4318AED6A1E012D9E6832A907600A918130C46DC778F971AD0038" +
+		"092999A333CB8B7A1A1DB93D7140003C2A4ECEA9F98D0ACC0A8291CDCEC97DCF" +
+		"8EC9B55A7F88A46B4DB5A851F44182E1C68A007E5E655F6AFFFFFFFFFFFFFFFF"
+)
+
+func ffdhePrime(s string) *big.Int {
+	p, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("tls: internal error: invalid FFDHE prime")
+	}
+	return p
+}
+
+func isFFDHEGroup(id CurveID) bool {
+	return id >= 256 && id <= 511
+}
+
+// ffdheGroups returns the FFDHE groups enabled by c, in preference order:
+// those listed in CurvePreferences, or all of them if none is listed, as long
+// as they are not smaller than MinDHGroupSize.
+func (c *Config) ffdheGroups() []CurveID {
+	var ids []CurveID
+	for _, group := range ffdheGroups {
+		if group.p.BitLen() < c.minDHGroupSize() {
+			continue
+		}
+		if c == nil || !slicesContainsFunc(c.CurvePreferences, isFFDHEGroup) || slicesContains(c.CurvePreferences, group.id) {
+			ids = append(ids, group.id)
+		}
+	}
+	return ids
+}
+
+// mutualFFDHEGroup returns the group a server uses with a client offering
+// supportedGroups, or nil if none is acceptable. Clients not offering any
+// FFDHE group get FFDHE2048. See RFC 7919, Section 4.
+func (c *Config) mutualFFDHEGroup(supportedGroups []CurveID) *ffdheGroup {
+	enabled := c.ffdheGroups()
+	if len(enabled) == 0 {
+		return nil
+	}
+	if !slicesContainsFunc(supportedGroups, isFFDHEGroup) {
+		supportedGroups = enabled[:1]
+	}
+	for _, id := range supportedGroups {
+		if !slicesContains(enabled, id) {
+			continue
+		}
+		for i := range ffdheGroups {
+			if ffdheGroups[i].id == id {
+				return &ffdheGroups[i]
+			}
+		}
+	}
+	return nil
+}
+
+func dheRSAKA(version uint16) keyAgreement {
+	return &dheKeyAgreement{ecdheKeyAgreement: ecdheKeyAgreement{isRSA: true, version: version}}
+}
+
+// dheKeyAgreement implements the finite field Diffie-Hellman key agreement
+// of the DHE_RSA cipher suites, signed like the ECDHE one. Servers only use
+// the groups of RFC 7919. Clients also accept other groups, from servers
+// predating it, if their prime is a safe prime of at least minDHEPrimeBits.
+type dheKeyAgreement struct {
+	ecdheKeyAgreement
+
+	// p and x are the prime and the private exponent of the server, set by
+	// generateServerKeyExchange.
+	p, x *big.Int
+}
+
+// generateDHEKey returns a private exponent and the matching public value
+// g^x mod p, left-padded to the size of p. The exponents are twice as long
+// as the strength of the FFDHE groups, see RFC 7919, Section 5.2.
+//
+// The exponentiations here and in dheSharedSecret use math/big, which is not
+// constant time. There is no constant-time modular exponentiation among the
+// dependencies of this package, which is why the DHE cipher suites are only
+// enabled on request, as documented on FFDHE2048.
+func generateDHEKey(rand io.Reader, p, g *big.Int) (x *big.Int, y []byte, err error) {
+	exponentLen := 32
+	if p.BitLen() > 2048 {
+		exponentLen = 48
+	}
+	b := make([]byte, exponentLen)
+	if _, err := io.ReadFull(rand, b); err != nil {
+		return nil, nil, err
+	}
+	b[0] |= 0x80
+	x = new(big.Int).SetBytes(b)
+	y = new(big.Int).Exp(g, x, p).FillBytes(make([]byte, (p.BitLen()+7)/8))
+	return x, y, nil
+}
+
+// dheSharedSecret returns peer^x mod p, with the leading zeros stripped as
+// required by RFC 5246, Section 8.1.2, after checking that peer is in
+// [2, p-2], which with a safe prime excludes the small subgroups.
+func dheSharedSecret(peer []byte, x, p *big.Int) ([]byte, bool) {
+	y := new(big.Int).SetBytes(peer)
+	pMinus1 := new(big.Int).Sub(p, big.NewInt(1))
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(pMinus1) >= 0 {
+		return nil, false
+	}
+	return new(big.Int).Exp(y, x, p).Bytes(), true
+}
+
+func (ka *dheKeyAgreement) generateServerKeyExchange(ctx context.Context, config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	group := config.mutualFFDHEGroup(clientHello.supportedCurves)
+	if group == nil {
+		return nil, errors.New("tls: no supported FFDHE groups offered")
+	}
+	g := big.NewInt(2)
+	x, y, err := generateDHEKey(config.rand(), group.p, g)
+	if err != nil {
+		return nil, err
+	}
+	ka.curveID, ka.p, ka.x = group.id, group.p, x
+
+	// See RFC 5246, Section 7.4.3.
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(group.p.Bytes())
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(g.Bytes())
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(y)
+	})
+	serverDHParams, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return ka.signServerParams(ctx, config, cert, clientHello, hello, serverDHParams)
+}
+
+func (ka *dheKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
+	s := cryptobyte.String(ckx.ciphertext)
+	var yc []byte
+	if !s.ReadUint16LengthPrefixed((*cryptobyte.String)(&yc)) || !s.Empty() {
+		return nil, errClientKeyExchange
+	}
+	preMasterSecret, ok := dheSharedSecret(yc, ka.x, ka.p)
+	if !ok {
+		return nil, errClientKeyExchange
+	}
+	return preMasterSecret, nil
+}
+
+func (ka *dheKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	s := cryptobyte.String(skx.key)
+	var pBytes, gBytes, ys []byte
+	if !s.ReadUint16LengthPrefixed((*cryptobyte.String)(&pBytes)) ||
+		!s.ReadUint16LengthPrefixed((*cryptobyte.String)(&gBytes)) ||
+		!s.ReadUint16LengthPrefixed((*cryptobyte.String)(&ys)) {
+		return errServerKeyExchange
+	}
+	serverDHParams := skx.key[:len(skx.key)-len(s)]
+	if err := ka.verifyServerParams(config, clientHello, serverHello, cert, serverDHParams, s); err != nil {
+		return err
+	}
+
+	p := new(big.Int).SetBytes(pBytes)
+	g := new(big.Int).SetBytes(gBytes)
+	ka.curveID = 0
+	for _, group := range ffdheGroups {
+		if group.p.Cmp(p) == 0 && g.Cmp(big.NewInt(2)) == 0 {
+			ka.curveID = group.id
+		}
+	}
+	if ka.curveID == 0 {
+		if err := checkDHEGroup(p, g, config.minDHGroupSize()); err != nil {
+			return err
+		}
+	} else if !slicesContains(config.ffdheGroups(), ka.curveID) {
+		return errors.New("tls: server selected a disabled FFDHE group")
+	}
+
+	x, yc, err := generateDHEKey(config.rand(), p, g)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	ka.preMasterSecret, ok = dheSharedSecret(ys, x, p)
+	if !ok {
+		return errServerKeyExchange
+	}
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(yc)
+	})
+	ka.ckx = new(clientKeyExchangeMsg)
+	ka.ckx.ciphertext, err = b.Bytes()
+	return err
+}
+
+// checkDHEGroup checks a group chosen by a server not using the groups of RFC
+// 7919, rejecting primes smaller than minBits, and those which are not safe
+// primes and so have small subgroups.
+func checkDHEGroup(p, g *big.Int, minBits int) error {
+	if p.BitLen() < minBits {
+		return errors.New("tls: server selected a weak DHE prime of " + strconv.Itoa(p.BitLen()) + " bits")
+	}
+	if p.BitLen() > maxDHEPrimeBits {
+		return errors.New("tls: server selected an oversized DHE prime")
+	}
+	pMinus1 := new(big.Int).Sub(p, big.NewInt(1))
+	if g.Cmp(big.NewInt(1)) <= 0 || g.Cmp(pMinus1) >= 0 {
+		return errServerKeyExchange
+	}
+	q := new(big.Int).Rsh(p, 1)
+	if !p.ProbablyPrime(0) || !q.ProbablyPrime(0) {
+		return errors.New("tls: server selected a DHE modulus which is not a safe prime")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package tls
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestDHECipherSuites(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		suite        uint16
+		clientCurves []CurveID
+		serverCurves []CurveID
+		wantCurve    CurveID
+	}{
+		{name: "AES128", suite: TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, wantCurve: FFDHE2048},
+		{name: "AES256", suite: TLS_DHE_RSA_WITH_AES_256_GCM_SHA384, wantCurve: FFDHE2048},
+		{name: "FFDHE4096", suite: TLS_DHE_RSA_WITH_AES_128_GCM_SHA256,
+			clientCurves: []CurveID{X25519, FFDHE4096}, wantCurve: FFDHE4096},
+		{name: "ServerPreferences", suite: TLS_DHE_RSA_WITH_AES_128_GCM_SHA256,
+			serverCurves: []CurveID{X25519, FFDHE3072}, wantCurve: FFDHE3072},
+		// Servers must not select DHE without a mutual FFDHE group.
+		{name: "NoMutualGroup", suite: TLS_DHE_RSA_WITH_AES_128_GCM_SHA256,
+			clientCurves: []CurveID{X25519, FFDHE4096}, serverCurves: []CurveID{X25519, FFDHE2048}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.CipherSuites = []uint16{tt.suite, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+			clientConfig.CurvePreferences = tt.clientCurves
+			clientConfig.MaxVersion = VersionTLS12
+			serverConfig := testConfig.Clone()
+			serverConfig.CipherSuites = []uint16{tt.suite, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+			serverConfig.CurvePreferences = tt.serverCurves
+			// Prefer DHE when possible.
+			serverConfig.GetCipherSuitePreference = func(*ClientHelloInfo) ([]uint16, error) {
+				return []uint16{tt.suite}, nil
+			}
+
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantSuite := tt.suite
+			if tt.wantCurve == 0 {
+				wantSuite = TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+			}
+			if cs.CipherSuite != wantSuite {
+				t.Errorf("got cipher suite %s, want %s", CipherSuiteName(cs.CipherSuite), CipherSuiteName(wantSuite))
+			}
+			if tt.wantCurve != 0 && (cs.CurveID != tt.wantCurve || ss.CurveID != tt.wantCurve) {
+				t.Errorf("got groups %v and %v, want %v", cs.CurveID, ss.CurveID, tt.wantCurve)
+			}
+		})
+	}
+}
+
+func TestCheckDHEGroup(t *testing.T) {
+	ffdhe3072 := ffdhePrime(ffdhe3072Prime)
+	two := big.NewInt(2)
+	for _, tt := range []struct {
+		name    string
+		p, g    *big.Int
+		wantErr string
+	}{
+		{name: "SafePrime", p: ffdhe3072, g: two},
+		{name: "Weak", p: new(big.Int).Rsh(ffdhe3072, 1200), g: two, wantErr: "weak DHE prime"},
+		{name: "Composite", p: new(big.Int).Add(ffdhe3072, two), g: two, wantErr: "not a safe prime"},
+		{name: "Generator", p: ffdhe3072, g: big.NewInt(1), wantErr: "invalid ServerKeyExchange"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDHEGroup(tt.p, tt.g, minDHEPrimeBits)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package tls
+
+import (
+	"sync"
+	"time"
+)
+
+// EarlyDataAntiReplay protects the 0-RTT data accepted by a server against
+// replays. See RFC 8446, Section 8.
+//
+// A single instance only protects the servers that share it. Deployments
+// where the same session tickets are accepted by multiple machines need an
+// implementation backed by a shared store, for example a distributed
+// ClientHello filter. Implementations must be safe for concurrent use.
+type EarlyDataAntiReplay interface {
+	// AcceptEarlyData is called by a server before accepting the 0-RTT data
+	// of a ClientHello, after the PSK binder has been verified. binder is the
+	// ClientHello's PSK binder, which is unique to it, and skew is the
+	// difference between the ticket age reported by the client and the one
+	// observed by the server.
+	//
+	// AcceptEarlyData reports whether the early data may be accepted. It must
+	// return false for every binder it already accepted, at least for as long
+	// as it would accept a ClientHello with that skew. If it returns false,
+	// the handshake continues and the early data is discarded.
+	AcceptEarlyData(binder []byte, skew time.Duration) bool
+}
+
+// NewEarlyDataAntiReplay returns an [EarlyDataAntiReplay] that implements the
+// ClientHello recording and freshness checks of RFC 8446, Section 8.2 and 8.3.
+// It rejects 0-RTT data whose ticket age is off by more than window, and
+// remembers the ClientHellos it accepted until a replay would be rejected as
+// stale anyway.
+//
+// The returned value is local to the process, and must be shared by all the
+// Configs that accept the same session tickets.
+func NewEarlyDataAntiReplay(window time.Duration) EarlyDataAntiReplay {
+	return &earlyDataWindow{
+		window: window,
+		seen:   make(map[string]struct{}),
+	}
+}
+
+type earlyDataWindow struct {
+	window time.Duration
+
+	sync.Mutex
+	seen map[string]struct{}
+	// queue holds the binders in seen in the order they were accepted, and
+	// so in the order they expire.
+	queue []earlyDataWindowEntry
+}
+
+type earlyDataWindowEntry struct {
+	binder string
+	expiry time.Time
+}
+
+func (w *earlyDataWindow) AcceptEarlyData(binder []byte, skew time.Duration) bool {
+	if skew > w.window || skew < -w.window {
+		return false
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	// A replay ages along with the original, so it fails the freshness check
+	// at the latest two windows after the original was accepted.
+	now := time.Now()
+	i := 0
+	for i < len(w.queue) && now.After(w.queue[i].expiry) {
+		delete(w.seen, w.queue[i].binder)
+		i++
+	}
+	w.queue = w.queue[i:]
+
+	if _, ok := w.seen[string(binder)]; ok {
+		return false
+	}
+	w.seen[string(binder)] = struct{}{}
+	w.queue = append(w.queue, earlyDataWindowEntry{
+		binder: string(binder),
+		expiry: now.Add(2 * w.window),
+	})
+	return true
+}
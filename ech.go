@@ -623,6 +623,7 @@ func (c *Conn) processECHClientHello(outer *clientHelloMsg, echKeys []EncryptedC
 		}
 
 		c.echAccepted = true
+		c.echOuterServerName = outer.serverName
 
 		return echInner, &echServerContext{
 			hpkeContext: hpkeContext,
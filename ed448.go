@@ -0,0 +1,52 @@
+package tls
+
+import (
+	"encoding/asn1"
+	"errors"
+
+	"github.com/cloudflare/circl/sign/ed448"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// oidPublicKeyEd448 identifies Ed448 keys and signatures, see RFC 8410.
+var oidPublicKeyEd448 = asn1.ObjectIdentifier{1, 3, 101, 113}
+
+// parseEd448PublicKey parses an Ed448 SubjectPublicKeyInfo.
+func parseEd448PublicKey(spki []byte) (ed448.PublicKey, bool) {
+	var key asn1.BitString
+	s := cryptobyte.String(spki)
+	if !s.ReadASN1(&s, cryptobyte_asn1.SEQUENCE) ||
+		!readEd448AlgorithmIdentifier(&s) ||
+		!s.ReadASN1BitString(&key) || !s.Empty() ||
+		key.BitLength != ed448.PublicKeySize*8 {
+		return nil, false
+	}
+	return ed448.PublicKey(key.Bytes), true
+}
+
+// parseEd448PrivateKey parses an Ed448 key in PKCS #8 form.
+func parseEd448PrivateKey(der []byte) (ed448.PrivateKey, error) {
+	var version int
+	var outer, seed cryptobyte.String
+	s := cryptobyte.String(der)
+	if !s.ReadASN1(&s, cryptobyte_asn1.SEQUENCE) ||
+		!s.ReadASN1Integer(&version) || version != 0 && version != 1 ||
+		!readEd448AlgorithmIdentifier(&s) ||
+		!s.ReadASN1(&outer, cryptobyte_asn1.OCTET_STRING) ||
+		!outer.ReadASN1(&seed, cryptobyte_asn1.OCTET_STRING) || !outer.Empty() ||
+		len(seed) != ed448.SeedSize {
+		return nil, errors.New("tls: failed to parse Ed448 private key")
+	}
+	return ed448.NewKeyFromSeed(seed), nil
+}
+
+// readEd448AlgorithmIdentifier reads an AlgorithmIdentifier, and reports
+// whether it's that of Ed448, which has no parameters.
+func readEd448AlgorithmIdentifier(s *cryptobyte.String) bool {
+	var algorithm cryptobyte.String
+	var oid asn1.ObjectIdentifier
+	return s.ReadASN1(&algorithm, cryptobyte_asn1.SEQUENCE) &&
+		algorithm.ReadASN1ObjectIdentifier(&oid) && algorithm.Empty() &&
+		oid.Equal(oidPublicKeyEd448)
+}
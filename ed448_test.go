@@ -0,0 +1,96 @@
+package tls
+
+import (
+	"testing"
+
+	"github.com/cloudflare/circl/sign/ed448"
+)
+
+const ed448CertificatePEM = `
+-----BEGIN CERTIFICATE-----
+MIIBzzCCAU+gAwIBAgIULMBP6uegMP26KoBqQrfEXryKFCowBQYDK2VxMBIxEDAO
+BgNVBAoMB0FjbWUgQ28wIBcNMjYxMDE0MDc1MTIwWhgPMjEyNjA5MjAwNzUxMjBa
+MBIxEDAOBgNVBAoMB0FjbWUgQ28wQzAFBgMrZXEDOgDPNquv0yrzsK3nVjAnSobS
+Uqo6m1M9SM5fBrnmeQbFWH+OApbTizYzrKhLu/gYl8O6TGjQnr0hp4CjgZswgZgw
+HQYDVR0OBBYEFGnpoazQVuccnhOypjb/Ycmv4zokMB8GA1UdIwQYMBaAFGnpoazQ
+VuccnhOypjb/Ycmv4zokMBkGA1UdEQQSMBCCDmV4YW1wbGUuZ29sYW5nMA4GA1Ud
+DwEB/wQEAwIHgDAdBgNVHSUEFjAUBggrBgEFBQcDAQYIKwYBBQUHAwIwDAYDVR0T
+AQH/BAIwADAFBgMrZXEDcwDRMnDDQ7sqO3vDcDNjPkFZT96Yci2aMptrAc3hiw35
+GfniyAGL4MP4cyfxrhFFDu7I/U/EuumWEYDI9GWRbjqLuSHXfLVuwMPPQ14s+OSc
+I4o21BvS5J94TVla+hATybyA02sUPO4lElG6ijKCELXpEAA=
+-----END CERTIFICATE-----`
+
+var ed448KeyPEM = testingKey(`
+-----BEGIN TESTING KEY-----
+MEcCAQAwBQYDK2VxBDsEOXSVlyYtDZANOwQ/NhZz8ToLWhVitKMHRjQWZyxJG+av
+hK/Wi9PGuEU/TkRX54kOTq1u/SonGxe87w==
+-----END TESTING KEY-----`)
+
+func TestEd448(t *testing.T) {
+	cert, err := X509KeyPair([]byte(ed448CertificatePEM), []byte(ed448KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cert.PrivateKey.(ed448.PrivateKey); !ok {
+		t.Fatalf("got private key of type %T", cert.PrivateKey)
+	}
+	withEd448 := append(supportedSignatureAlgorithms(VersionTLS12), Ed448)
+
+	for _, tt := range []struct {
+		name       string
+		version    uint16
+		clientAuth bool
+		optIn      bool
+	}{
+		{name: "TLS13", version: VersionTLS13, optIn: true},
+		{name: "TLS12", version: VersionTLS12, optIn: true},
+		{name: "ClientCertTLS13", version: VersionTLS13, clientAuth: true, optIn: true},
+		{name: "ClientCertTLS12", version: VersionTLS12, clientAuth: true, optIn: true},
+		{name: "NotOffered", version: VersionTLS13},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			if tt.optIn {
+				clientConfig.SignatureSchemes = withEd448
+				serverConfig.SignatureSchemes = withEd448
+			}
+			if tt.clientAuth {
+				serverConfig.ClientAuth = RequireAnyClientCert
+				clientConfig.Certificates = []Certificate{cert}
+			} else {
+				serverConfig.Certificates = []Certificate{cert}
+				serverConfig.NameToCertificate = nil
+			}
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if !tt.optIn {
+				if err == nil {
+					t.Fatal("Ed448 certificate used with a peer that didn't offer Ed448")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := cs
+			if tt.clientAuth {
+				state = ss
+			}
+			if len(state.PeerCertificates) != 1 {
+				t.Fatalf("got %d peer certificates", len(state.PeerCertificates))
+			}
+			if _, ok := state.PeerCertificates[0].PublicKey.(ed448.PublicKey); !ok {
+				t.Errorf("got peer public key of type %T", state.PeerCertificates[0].PublicKey)
+			}
+		})
+	}
+}
+
+func TestEd448SignatureScheme(t *testing.T) {
+	sigType, hash, err := typeAndHashFromSignatureScheme(Ed448)
+	if err != nil || sigType != signatureEd448 || hash != 0 {
+		t.Errorf("got type %d, hash %v, err %v", sigType, hash, err)
+	}
+}
@@ -0,0 +1,69 @@
+package tls
+
+import "net"
+
+// The predicates below classify the errors returned by handshakes, reads and
+// writes, such as for the retry logic of dialers, without matching their
+// text. They look at the whole chain of wrapped errors.
+
+// IsTimeout reports whether err is due to a deadline or timeout, of the
+// underlying connection or of the context of a handshake.
+func IsTimeout(err error) bool {
+	netErr, ok := errorsAsType[net.Error](err)
+	return ok && netErr.Timeout()
+}
+
+// PeerAlert returns the alert the peer sent to end the connection, if that
+// is what err is due to. The close_notify alert ends connections with io.EOF
+// instead.
+func PeerAlert(err error) (AlertError, bool) {
+	if a, ok := errorsAsType[*DirectedAlertError](err); ok && !a.Sent {
+		return a.Alert, true
+	}
+	return 0, false
+}
+
+// IsVerificationError reports whether err is due to this side rejecting the
+// certificates of the peer, because they don't verify, are revoked, have keys
+// weaker than Config.MinRSAKeySize or Config.MinECStrength, don't comply with
+// Config.RequireCT, or don't match Config.PinnedPeerSPKIHashes, or rejecting
+// its raw public key or delegated credential. The errors of
+// Config.VerifyPeerCertificate and Config.VerifyConnection are returned as
+// is, and are only classified if they wrap one of those of this package,
+// while those of Config.VerifyRawPublicKey, which stands for the verification
+// of the chain, are classified.
+func IsVerificationError(err error) bool {
+	if _, ok := errorsAsType[*CertificateVerificationError](err); ok {
+		return true
+	}
+	if _, ok := errorsAsType[*CertificateRevokedError](err); ok {
+		return true
+	}
+	_, ok := errorsAsType[*PinError](err)
+	return ok
+}
+
+// protocolViolationAlerts are the alerts sent on receiving malformed or
+// unexpected data, rather than on failing to negotiate or authenticate.
+var protocolViolationAlerts = []alert{
+	alertUnexpectedMessage,
+	alertBadRecordMAC,
+	alertRecordOverflow,
+	alertDecompressionFailure,
+	alertIllegalParameter,
+	alertDecodeError,
+	alertUnsupportedExtension,
+	alertMissingExtension,
+}
+
+// IsProtocolViolation reports whether err is due to this side receiving data
+// that doesn't follow the protocol, such as records that aren't TLS ones or
+// malformed or unexpected messages. Those of the peer rejecting the data of
+// this side are reported by PeerAlert instead.
+func IsProtocolViolation(err error) bool {
+	if _, ok := errorsAsType[RecordHeaderError](err); ok {
+		return true
+	}
+	a, ok := errorsAsType[*DirectedAlertError](err)
+	return ok && a.Sent && slicesContains(protocolViolationAlerts, alert(a.Alert))
+}
@@ -0,0 +1,103 @@
+package tls
+
+import (
+	"crypto"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// handshakeErrors returns the errors of the handshakes of a client with
+// clientConfig, or writing clientData instead if not nil, and a server with
+// serverConfig.
+func handshakeErrors(t *testing.T, clientConfig, serverConfig *Config, clientData []byte) (clientErr, serverErr error) {
+	c, s := localPipe(t)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- Server(s, serverConfig).Handshake()
+		s.Close()
+	}()
+	if clientData != nil {
+		_, clientErr = c.Write(clientData)
+	} else {
+		clientErr = Client(c, clientConfig).Handshake()
+	}
+	c.Close()
+	return clientErr, <-errChan
+}
+
+func TestErrorClassification(t *testing.T) {
+	verifyingConfig := testConfig.Clone()
+	verifyingConfig.InsecureSkipVerify = false
+	verifyingConfig.ServerName = "example.golang"
+	verifyingConfig.Time = testTime
+	tls12Config := testConfig.Clone()
+	tls12Config.MaxVersion = VersionTLS12
+	tls13Config := testConfig.Clone()
+	tls13Config.MinVersion = VersionTLS13
+
+	clientErr, serverErr := handshakeErrors(t, verifyingConfig, testConfig, nil)
+	if !IsVerificationError(clientErr) || IsVerificationError(serverErr) {
+		t.Errorf("IsVerificationError(%v) and IsVerificationError(%v)", clientErr, serverErr)
+	}
+	if a, ok := PeerAlert(serverErr); !ok || a != AlertError(alertBadCertificate) {
+		t.Errorf("PeerAlert(%v) = %v, %v", serverErr, a, ok)
+	}
+	if _, ok := PeerAlert(clientErr); ok || IsProtocolViolation(clientErr) || IsTimeout(clientErr) {
+		t.Errorf("client error %v classified as a peer alert, protocol violation or timeout", clientErr)
+	}
+
+	// The test certificate has a 1024-bit RSA key, and no SCTs.
+	weakKeyConfig := testConfig.Clone()
+	weakKeyConfig.MinRSAKeySize = 2048
+	ctConfig := testConfig.Clone()
+	ctConfig.RequireCT = true
+	rpkServerConfig := testConfig.Clone()
+	rpkServerConfig.ServerCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	rpkConfig := testConfig.Clone()
+	rpkConfig.ServerCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	rpkConfig.VerifyRawPublicKey = func([]byte, crypto.PublicKey) error { return errors.New("unknown key") }
+	for _, tt := range []struct {
+		name                       string
+		clientConfig, serverConfig *Config
+	}{
+		{"WeakKey", weakKeyConfig, testConfig},
+		{"CertificateTransparency", ctConfig, testConfig},
+		{"RawPublicKey", rpkConfig, rpkServerConfig},
+	} {
+		clientErr, _ := handshakeErrors(t, tt.clientConfig, tt.serverConfig, nil)
+		if !IsVerificationError(clientErr) {
+			t.Errorf("%s: IsVerificationError(%v) = false", tt.name, clientErr)
+		}
+	}
+
+	clientErr, serverErr = handshakeErrors(t, tls12Config, tls13Config, nil)
+	if a, ok := PeerAlert(clientErr); !ok || a != AlertError(alertProtocolVersion) {
+		t.Errorf("PeerAlert(%v) = %v, %v", clientErr, a, ok)
+	}
+	if IsProtocolViolation(serverErr) {
+		t.Errorf("failed negotiation %v classified as a protocol violation", serverErr)
+	}
+
+	_, serverErr = handshakeErrors(t, nil, testConfig, []byte("GET / HTTP/1.1\r\n\r\n"))
+	if !IsProtocolViolation(serverErr) {
+		t.Errorf("IsProtocolViolation(%v) = false", serverErr)
+	}
+	// A record with an empty ClientHello.
+	_, serverErr = handshakeErrors(t, nil, testConfig, []byte{22, 3, 1, 0, 4, typeClientHello, 0, 0, 0})
+	if !IsProtocolViolation(serverErr) {
+		t.Errorf("IsProtocolViolation(%v) = false", serverErr)
+	}
+
+	c, s := localPipe(t)
+	defer s.Close()
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(-time.Second))
+	if err := Client(c, testConfig).Handshake(); !IsTimeout(err) {
+		t.Errorf("IsTimeout(%v) = false", err)
+	}
+	if IsTimeout(&net.OpError{Op: "read", Err: alertInternalError}) {
+		t.Error("alert classified as a timeout")
+	}
+}
@@ -0,0 +1,331 @@
+package tls
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"hash"
+	"io"
+)
+
+// AuthenticatorRequest returns an authenticator request, as described in
+// RFC 9261, Section 4, asking the peer to prove possession of an additional
+// certificate. The application protocol carries the request to the peer,
+// which answers with [Conn.ExportAuthenticator], and the answer is checked
+// with [Conn.ValidateAuthenticator].
+//
+// Servers send a CertificateRequest listing the subjects of Config.ClientCAs,
+// if set, and clients a ClientCertificateRequest. Exported authenticators are
+// only supported after a TLS 1.3 handshake.
+func (c *Conn) AuthenticatorRequest() ([]byte, error) {
+	if _, err := c.authenticatorSuite(); err != nil {
+		return nil, err
+	}
+	certReq := &certificateRequestMsgTLS13{
+		requestContext:                   make([]byte, 32),
+		ocspStapling:                     true,
+		scts:                             true,
+		supportedSignatureAlgorithms:     c.config.supportedSignatureAlgorithms(VersionTLS13),
+		supportedSignatureAlgorithmsCert: c.config.certificateSignatureSchemes(),
+	}
+	if _, err := io.ReadFull(c.config.rand(), certReq.requestContext); err != nil {
+		return nil, err
+	}
+	if !c.isClient && c.config.ClientCAs != nil {
+		certReq.certificateAuthorities = c.config.ClientCAs.Subjects()
+	}
+	request, err := certReq.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if c.isClient {
+		// A ClientCertificateRequest has the same contents.
+		request[0] = typeClientCertificateRequest
+	}
+	return request, nil
+}
+
+// ExportAuthenticator returns an authenticator for cert, as described in
+// RFC 9261, Section 5, answering an authenticator request the peer created
+// with [Conn.AuthenticatorRequest]. If cert is nil or has no certificates, an
+// empty authenticator is returned, which refuses the request.
+//
+// Servers may also authenticate without a request, by passing a nil request
+// and a non-empty cert. The signature algorithm is chosen among those of the
+// request, or of this package for such spontaneous authenticators.
+func (c *Conn) ExportAuthenticator(request []byte, cert *Certificate) ([]byte, error) {
+	suite, err := c.authenticatorSuite()
+	if err != nil {
+		return nil, err
+	}
+	hasCert := cert != nil && len(cert.Certificate) > 0
+
+	var certReq *certificateRequestMsgTLS13
+	if request != nil {
+		// The request was created by the peer.
+		if certReq, err = parseAuthenticatorRequest(request, !c.isClient); err != nil {
+			return nil, err
+		}
+	} else {
+		if c.isClient {
+			return nil, errors.New("tls: clients can only send authenticators in response to a request")
+		}
+		if !hasCert {
+			return nil, errors.New("tls: spontaneous authenticator without a certificate")
+		}
+		certReq = &certificateRequestMsgTLS13{
+			requestContext:               make([]byte, 32),
+			supportedSignatureAlgorithms: c.config.supportedSignatureAlgorithms(VersionTLS13),
+		}
+		if _, err := io.ReadFull(c.config.rand(), certReq.requestContext); err != nil {
+			return nil, err
+		}
+	}
+
+	transcript, finishedKey, err := c.authenticatorTranscript(suite, c.isClient, request)
+	if err != nil {
+		return nil, err
+	}
+
+	certMsg := &certificateMsgTLS13{requestContext: certReq.requestContext}
+	if hasCert {
+		certMsg.certificate = *cert
+		certMsg.scts = certReq.scts && len(cert.SignedCertificateTimestamps) > 0
+		certMsg.ocspStapling = certReq.ocspStapling && len(cert.OCSPStaple) > 0
+	}
+	certData, err := certMsg.marshal()
+	if err != nil {
+		return nil, err
+	}
+	transcript.Write(certData)
+
+	// An empty authenticator is only a Finished message, which still covers
+	// the empty Certificate message. See RFC 9261, Section 5.3.
+	var authenticator []byte
+	if hasCert {
+		authenticator = certData
+
+		certVerify := &certificateVerifyMsg{hasSignatureAlgorithm: true}
+		certVerify.signatureAlgorithm, err = selectSignatureScheme(VersionTLS13, cert, certReq.supportedSignatureAlgorithms)
+		if err != nil {
+			return nil, err
+		}
+		sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		signOpts := crypto.SignerOpts(sigHash)
+		if sigType == signatureRSAPSS {
+			signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
+		} else if sigType == signatureSM2 {
+			signOpts = sm2SignerOpts
+		}
+		signed := signedMessage(authenticatorSignatureContext, transcript)
+		certVerify.signature, err = signContext(context.Background(), cert.PrivateKey.(crypto.Signer), c.config.rand(), signed, signOpts)
+		if err != nil {
+			return nil, errors.New("tls: failed to sign authenticator: " + err.Error())
+		}
+		certVerifyData, err := certVerify.marshal()
+		if err != nil {
+			return nil, err
+		}
+		transcript.Write(certVerifyData)
+		authenticator = append(authenticator, certVerifyData...)
+	}
+
+	finished, err := (&finishedMsg{
+		verifyData: authenticatorFinished(suite, finishedKey, transcript),
+	}).marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(authenticator, finished...), nil
+}
+
+// ValidateAuthenticator checks an authenticator created by the peer with
+// [Conn.ExportAuthenticator] in response to request, which was returned by
+// [Conn.AuthenticatorRequest] on this connection. Clients may pass a nil
+// request to validate a spontaneous server authenticator.
+//
+// It returns the certificate chain of the authenticator, or no certificates
+// and a nil error for an empty authenticator. The chain isn't verified, which
+// is up to the caller, for example with [x509.Certificate.Verify].
+func (c *Conn) ValidateAuthenticator(request, authenticator []byte) ([]*x509.Certificate, error) {
+	suite, err := c.authenticatorSuite()
+	if err != nil {
+		return nil, err
+	}
+	peerIsClient := !c.isClient
+
+	var certReq *certificateRequestMsgTLS13
+	if request != nil {
+		// The request was created by this side.
+		if certReq, err = parseAuthenticatorRequest(request, c.isClient); err != nil {
+			return nil, err
+		}
+	} else if peerIsClient {
+		return nil, errors.New("tls: client authenticators require a request")
+	}
+
+	transcript, finishedKey, err := c.authenticatorTranscript(suite, peerIsClient, request)
+	if err != nil {
+		return nil, err
+	}
+
+	msgType, msg, rest := splitAuthenticatorMessage(authenticator)
+	var certs []*x509.Certificate
+	if msgType == typeFinished && certReq != nil {
+		emptyCert, err := (&certificateMsgTLS13{requestContext: certReq.requestContext}).marshal()
+		if err != nil {
+			return nil, err
+		}
+		transcript.Write(emptyCert)
+	} else {
+		certMsg := new(certificateMsgTLS13)
+		if msgType != typeCertificate || !certMsg.unmarshal(msg) {
+			return nil, errors.New("tls: invalid authenticator Certificate message")
+		}
+		if certReq != nil && !hmac.Equal(certMsg.requestContext, certReq.requestContext) {
+			return nil, errors.New("tls: authenticator doesn't answer the request")
+		}
+		// RFC 9261, Section 5.2.1
+		if len(certMsg.certificate.Certificate) == 0 {
+			return nil, errors.New("tls: authenticator has an empty Certificate message")
+		}
+		for _, der := range certMsg.certificate.Certificate {
+			cert, err := parseCertificate(der)
+			if err != nil {
+				return nil, errors.New("tls: failed to parse authenticator certificate: " + err.Error())
+			}
+			certs = append(certs, cert)
+		}
+		transcript.Write(msg)
+
+		msgType, msg, rest = splitAuthenticatorMessage(rest)
+		certVerify := &certificateVerifyMsg{hasSignatureAlgorithm: true}
+		if msgType != typeCertificateVerify || !certVerify.unmarshal(msg) {
+			return nil, errors.New("tls: invalid authenticator CertificateVerify message")
+		}
+		supportedAlgs := c.config.supportedSignatureAlgorithms(VersionTLS13)
+		if certReq != nil {
+			supportedAlgs = certReq.supportedSignatureAlgorithms
+		}
+		pub := certs[0].PublicKey
+		if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, supportedAlgs) ||
+			!isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, signatureSchemesForPublicKey(VersionTLS13, pub)) {
+			return nil, errors.New("tls: authenticator certificate used with invalid signature algorithm")
+		}
+		sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		if sigType == signaturePKCS1v15 || sigHash == crypto.SHA1 {
+			return nil, errors.New("tls: authenticator certificate used with invalid signature algorithm")
+		}
+		signed := signedMessage(authenticatorSignatureContext, transcript)
+		if err := verifyHandshakeSignature(sigType, pub, sigHash, signed, certVerify.signature); err != nil {
+			return nil, errors.New("tls: invalid authenticator signature: " + err.Error())
+		}
+		transcript.Write(msg)
+
+		msgType, msg, rest = splitAuthenticatorMessage(rest)
+	}
+
+	finished := new(finishedMsg)
+	if msgType != typeFinished || !finished.unmarshal(msg) || len(rest) != 0 {
+		return nil, errors.New("tls: invalid authenticator Finished message")
+	}
+	if !hmac.Equal(finished.verifyData, authenticatorFinished(suite, finishedKey, transcript)) {
+		return nil, errors.New("tls: invalid authenticator Finished hash")
+	}
+	return certs, nil
+}
+
+// authenticatorSuite returns the cipher suite of the connection, whose hash
+// exported authenticators use.
+func (c *Conn) authenticatorSuite() (*cipherSuiteTLS13, error) {
+	if !c.isHandshakeComplete.Load() {
+		return nil, errors.New("tls: exported authenticators require a completed handshake")
+	}
+	if c.vers != VersionTLS13 {
+		return nil, errors.New("tls: exported authenticators are only supported in TLS 1.3")
+	}
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil {
+		return nil, errors.New("tls: internal error: unknown cipher suite")
+	}
+	return suite, nil
+}
+
+// authenticatorTranscript returns the transcript of an authenticator created
+// by the client if byClient, or by the server otherwise, starting with the
+// Handshake Context and the request, and its Finished MAC Key.
+func (c *Conn) authenticatorTranscript(suite *cipherSuiteTLS13, byClient bool, request []byte) (hash.Hash, []byte, error) {
+	// RFC 9261, Section 5.1
+	handshakeContextLabel := "EXPORTER-server authenticator handshake context"
+	finishedKeyLabel := "EXPORTER-server authenticator finished key"
+	if byClient {
+		handshakeContextLabel = "EXPORTER-client authenticator handshake context"
+		finishedKeyLabel = "EXPORTER-client authenticator finished key"
+	}
+	handshakeContext, err := c.ekm(handshakeContextLabel, nil, suite.hash.Size())
+	if err != nil {
+		return nil, nil, err
+	}
+	finishedKey, err := c.ekm(finishedKeyLabel, nil, suite.hash.Size())
+	if err != nil {
+		return nil, nil, err
+	}
+	transcript := suite.hash.New()
+	transcript.Write(handshakeContext)
+	transcript.Write(request)
+	return transcript, finishedKey, nil
+}
+
+// parseAuthenticatorRequest parses an authenticator request created by the
+// client if byClient, which is then a ClientCertificateRequest.
+func parseAuthenticatorRequest(request []byte, byClient bool) (*certificateRequestMsgTLS13, error) {
+	wantType := typeCertificateRequest
+	if byClient {
+		wantType = typeClientCertificateRequest
+	}
+	certReq := new(certificateRequestMsgTLS13)
+	msgType, msg, rest := splitAuthenticatorMessage(request)
+	if msgType != wantType || len(rest) != 0 || !certReq.unmarshal(msg) {
+		return nil, errors.New("tls: invalid authenticator request")
+	}
+	// RFC 9261, Section 4
+	if len(certReq.requestContext) == 0 {
+		return nil, errors.New("tls: authenticator request has an empty context")
+	}
+	return certReq, nil
+}
+
+// splitAuthenticatorMessage returns the type and bytes of the first handshake
+// message of data, and the data following it. msgType is zero, which isn't
+// the type of any authenticator message, if data doesn't start with a
+// complete message.
+func splitAuthenticatorMessage(data []byte) (msgType uint8, msg, rest []byte) {
+	if len(data) < 4 {
+		return 0, nil, data
+	}
+	n := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if len(data) < 4+n {
+		return 0, nil, data
+	}
+	return data[0], data[:4+n], data[4+n:]
+}
+
+// authenticatorSignatureContext is the context string of the CertificateVerify
+// signatures of authenticators, with its separator, see RFC 9261, Section
+// 5.2.2. Unlike in the handshake, it is the same for clients and servers.
+const authenticatorSignatureContext = "Exported Authenticator\x00"
+
+func authenticatorFinished(suite *cipherSuiteTLS13, finishedKey []byte, transcript hash.Hash) []byte {
+	verifyData := hmac.New(suite.hash.New, finishedKey)
+	verifyData.Write(transcript.Sum(nil))
+	return verifyData.Sum(nil)
+}
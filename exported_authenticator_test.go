@@ -0,0 +1,134 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestExportedAuthenticators(t *testing.T) {
+	clientCert := Certificate{Certificate: [][]byte{testECDSACertificate}, PrivateKey: testECDSAPrivateKey}
+	serverCert := Certificate{Certificate: [][]byte{testRSACertificate}, PrivateKey: testRSAPrivateKey}
+	client, server := postHandshakeAuthConns(t, testConfig, testConfig)
+
+	serverRequest, err := server.AuthenticatorRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientRequest, err := client.AuthenticatorRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverRequest[0] != typeCertificateRequest || clientRequest[0] != typeClientCertificateRequest {
+		t.Errorf("got request types %d and %d", serverRequest[0], clientRequest[0])
+	}
+
+	export := func(c *Conn, request []byte, cert *Certificate) []byte {
+		t.Helper()
+		authenticator, err := c.ExportAuthenticator(request, cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return authenticator
+	}
+	truncated := export(client, serverRequest, &clientCert)
+	truncated = truncated[:len(truncated)-1]
+	for _, tt := range []struct {
+		name          string
+		validator     *Conn
+		request       []byte
+		authenticator []byte
+		want          []byte // leaf certificate, or nil for an empty authenticator
+		err           bool
+	}{
+		{name: "Client", validator: server, request: serverRequest, authenticator: export(client, serverRequest, &clientCert), want: testECDSACertificate},
+		{name: "Server", validator: client, request: clientRequest, authenticator: export(server, clientRequest, &serverCert), want: testRSACertificate},
+		{name: "Spontaneous", validator: client, authenticator: export(server, nil, &serverCert), want: testRSACertificate},
+		{name: "Empty", validator: server, request: serverRequest, authenticator: export(client, serverRequest, nil)},
+		{name: "WrongRequest", validator: client, request: clientRequest, authenticator: export(server, nil, &serverCert), err: true},
+		{name: "WrongSide", validator: client, request: serverRequest, authenticator: export(client, serverRequest, &clientCert), err: true},
+		{name: "SpontaneousClient", validator: server, authenticator: export(client, serverRequest, &clientCert), err: true},
+		{name: "Truncated", validator: server, request: serverRequest, authenticator: truncated, err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, err := tt.validator.ValidateAuthenticator(tt.request, tt.authenticator)
+			if tt.err {
+				if err == nil {
+					t.Fatal("invalid authenticator accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if len(certs) != 0 {
+					t.Errorf("got %d certificates from an empty authenticator", len(certs))
+				}
+				return
+			}
+			if len(certs) != 1 || !bytes.Equal(certs[0].Raw, tt.want) {
+				t.Errorf("got %d certificates, want the exported certificate", len(certs))
+			}
+		})
+	}
+
+	t.Run("Tampered", func(t *testing.T) {
+		authenticator := export(client, serverRequest, &clientCert)
+		for i := 0; i < len(authenticator); i += 7 {
+			authenticator[i] ^= 0x80
+			if _, err := server.ValidateAuthenticator(serverRequest, authenticator); err == nil {
+				t.Fatalf("authenticator with byte %d modified accepted", i)
+			}
+			authenticator[i] ^= 0x80
+		}
+	})
+}
+
+func TestExportedAuthenticatorsTLS12(t *testing.T) {
+	config := testConfig.Clone()
+	config.MaxVersion = VersionTLS12
+	client, _ := postHandshakeAuthConns(t, config, config)
+	if _, err := client.AuthenticatorRequest(); err == nil {
+		t.Error("authenticator request created over TLS 1.2")
+	}
+}
+
+func TestExportedAuthenticatorSignature(t *testing.T) {
+	// Check the CertificateVerify signature as specified in RFC 9261,
+	// Section 5.2.2, independently of the implementation.
+	cert := Certificate{Certificate: [][]byte{testEd25519Certificate}, PrivateKey: testEd25519PrivateKey}
+	client, server := postHandshakeAuthConns(t, testConfig, testConfig)
+	authenticator, err := server.ExportAuthenticator(nil, &cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suite := cipherSuiteTLS13ByID(server.ConnectionState().CipherSuite)
+	cs := client.ConnectionState()
+	handshakeContext, err := cs.ExportKeyingMaterial("EXPORTER-server authenticator handshake context", nil, suite.hash.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	certLen := 4 + (int(authenticator[1])<<16 | int(authenticator[2])<<8 | int(authenticator[3]))
+	certVerify := authenticator[certLen:]
+	if authenticator[0] != typeCertificate || certVerify[0] != typeCertificateVerify {
+		t.Fatalf("unexpected authenticator messages %d and %d", authenticator[0], certVerify[0])
+	}
+	if scheme := SignatureScheme(certVerify[4])<<8 | SignatureScheme(certVerify[5]); scheme != Ed25519 {
+		t.Fatalf("signed with %v, want Ed25519", scheme)
+	}
+	sigLen := int(certVerify[6])<<8 | int(certVerify[7])
+	signature := certVerify[8 : 8+sigLen]
+
+	h := suite.hash.New()
+	h.Write(handshakeContext)
+	h.Write(authenticator[:certLen])
+	signed := bytes.Repeat([]byte{0x20}, 64)
+	signed = append(signed, "Exported Authenticator"...)
+	signed = append(signed, 0)
+	signed = h.Sum(signed)
+	if !ed25519.Verify(testEd25519PrivateKey.Public().(ed25519.PublicKey), signed, signature) {
+		t.Error("CertificateVerify doesn't sign the RFC 9261 message")
+	}
+}
@@ -0,0 +1,111 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrFallback is returned by the handshake of servers whose connection was
+// handed to [Config.Fallback], joined with the error of the handshake.
+var ErrFallback = errors.New("tls: handshake failed, connection handed to Config.Fallback")
+
+// FallbackProxy returns a [Config.Fallback] handler proxying the connections
+// to address, such as the real website the server poses as, dialing it with
+// dial, or a net.Dialer if nil. When either side is done sending, the write
+// side of the other connection is closed, if it supports CloseWrite, and it
+// returns once both sides are done, after closing the connections.
+func FallbackProxy(address string, dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, conn net.Conn, received []byte) error {
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	return func(ctx context.Context, conn net.Conn, received []byte) error {
+		defer conn.Close()
+		target, err := dial(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		defer target.Close()
+		if _, err := target.Write(received); err != nil {
+			return err
+		}
+		done := make(chan struct{}, 2)
+		go func() {
+			proxyCopy(target, conn)
+			done <- struct{}{}
+		}()
+		go func() {
+			proxyCopy(conn, target)
+			done <- struct{}{}
+		}()
+		<-done
+		<-done
+		return nil
+	}
+}
+
+// proxyCopy copies src to dst until src is done, and then closes the write
+// side of dst, or all of dst if it can't be half-closed or the copy failed,
+// in which case the copy in the other direction ends with it.
+func proxyCopy(dst, src net.Conn) {
+	_, err := io.Copy(dst, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+		if cw.CloseWrite() == nil {
+			return
+		}
+	}
+	dst.Close()
+}
+
+// startFallback records the input of the server handshake for
+// Config.Fallback, if set, and suppresses its alerts.
+func (c *Conn) startFallback() {
+	if c.config.Fallback != nil && c.quic == nil && c.handshakes == 0 {
+		c.fallback = c.config.Fallback
+		c.fallbackInput = []byte{}
+	}
+}
+
+// canFallBack reports whether the handshake can still be handed to
+// Config.Fallback, with nothing sent to the client yet. c.out must be locked.
+func (c *Conn) canFallBack() bool {
+	return c.fallback != nil && c.bytesSent == 0 && len(c.sendBuf) == 0
+}
+
+// finishFallback stops recording the input of the handshake and, if the
+// handshake failed with err before the server sent anything, prepares the
+// hand-off of the connection to Config.Fallback, which handOffFallback does
+// once the handshake released its locks.
+func (c *Conn) finishFallback(err error) error {
+	c.out.Lock()
+	canFallBack := c.canFallBack()
+	fallback, received := c.fallback, c.fallbackInput
+	c.fallback, c.fallbackInput = nil, nil
+	c.out.Unlock()
+	if err == nil || !canFallBack {
+		return err
+	}
+	conn := c.conn
+	c.fallbackHandoff = func(ctx context.Context) error {
+		return fallback(ctx, conn, received)
+	}
+	return errors.Join(ErrFallback, err)
+}
+
+// handOffFallback calls Config.Fallback if the handshake that failed with
+// err prepared it, with the values of ctx but not its cancellation or
+// deadline, which only bound the handshake.
+func (c *Conn) handOffFallback(ctx context.Context, err error) error {
+	c.handshakeMutex.Lock()
+	handoff := c.fallbackHandoff
+	c.fallbackHandoff = nil
+	c.handshakeMutex.Unlock()
+	if handoff == nil || !errors.Is(err, ErrFallback) {
+		return err
+	}
+	if fallbackErr := handoff(contextWithoutCancel(ctx)); fallbackErr != nil {
+		return errors.Join(err, fallbackErr)
+	}
+	return err
+}
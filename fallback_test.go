@@ -0,0 +1,209 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFallback(t *testing.T) {
+	errFingerprint := errors.New("unknown fingerprint")
+	serverConfig := testConfig.Clone()
+	serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+		return nil, errFingerprint
+	}
+	var received []byte
+	serverConfig.Fallback = func(ctx context.Context, conn net.Conn, b []byte) error {
+		received = b
+		_, err := io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		conn.Close()
+		return err
+	}
+
+	c, s := localPipe(t)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- Server(s, serverConfig).Handshake()
+	}()
+	record := sentClientHello(t, testConfig.Clone())
+	if _, err := c.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	response, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if string(response) != "HTTP/1.1 400 Bad Request\r\n\r\n" {
+		t.Errorf("client received %q, want the response of the fallback", response)
+	}
+	if err := <-errChan; !errors.Is(err, ErrFallback) || !errors.Is(err, errFingerprint) {
+		t.Errorf("server returned %v", err)
+	}
+	if string(received) != string(record) {
+		t.Errorf("fallback received %x, want the ClientHello %x", received, record)
+	}
+}
+
+func TestFallbackContext(t *testing.T) {
+	type ctxKey struct{}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey{}, "value"), time.Minute)
+	defer cancel()
+	var server *Conn
+	serverConfig := testConfig.Clone()
+	serverConfig.MinVersion = VersionTLS13
+	serverConfig.Fallback = func(fallbackCtx context.Context, conn net.Conn, b []byte) error {
+		defer conn.Close()
+		// The handshake doesn't hold the locks of the Conn anymore.
+		server.ConnectionState()
+		cancel()
+		if _, ok := fallbackCtx.Deadline(); ok || fallbackCtx.Err() != nil {
+			t.Error("fallback context bound by the handshake context")
+		}
+		if fallbackCtx.Value(ctxKey{}) != "value" {
+			t.Error("fallback context lost the values of the handshake context")
+		}
+		return nil
+	}
+
+	c, s := localPipe(t)
+	errChan := make(chan error, 1)
+	go func() {
+		server = Server(s, serverConfig)
+		errChan <- server.HandshakeContext(ctx)
+	}()
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	if _, err := c.Write(sentClientHello(t, clientConfig)); err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(c)
+	c.Close()
+	if err := <-errChan; !errors.Is(err, ErrFallback) {
+		t.Errorf("server returned %v", err)
+	}
+}
+
+func TestFallbackProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, len("GET / HTTP/1.1\r\n\r\n"))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n"+strings.Fields(string(buf))[1])
+	}()
+
+	serverConfig := testConfig.Clone()
+	serverConfig.Fallback = FallbackProxy(l.Addr().String(), nil)
+	c, s := localPipe(t)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- Server(s, serverConfig).Handshake()
+	}()
+	// An active probe, which doesn't speak TLS, sees the real site.
+	if _, err := io.WriteString(c, "GET / HTTP/1.1\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	response, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if string(response) != "HTTP/1.1 200 OK\r\n\r\n/" {
+		t.Errorf("probe received %q", response)
+	}
+	if err := <-errChan; !errors.Is(err, ErrFallback) {
+		t.Errorf("server returned %v", err)
+	}
+}
+
+func TestFallbackProxyHalfClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The target only answers once the probe is done sending.
+		request, err := io.ReadAll(conn)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "received "+string(request))
+	}()
+
+	serverConfig := testConfig.Clone()
+	serverConfig.Fallback = FallbackProxy(l.Addr().String(), nil)
+	c, s := localPipe(t)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- Server(s, serverConfig).Handshake()
+	}()
+	if _, err := io.WriteString(c, "not TLS"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	response, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if string(response) != "received not TLS" {
+		t.Errorf("probe received %q", response)
+	}
+	if err := <-errChan; !errors.Is(err, ErrFallback) {
+		t.Errorf("server returned %v", err)
+	}
+}
+
+func TestFallbackAfterServerHello(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	serverConfig.ClientAuth = RequireAnyClientCert
+	serverConfig.Fallback = func(ctx context.Context, conn net.Conn, b []byte) error {
+		t.Error("fallback called after the ServerHello")
+		return nil
+	}
+	clientConfig := testConfig.Clone()
+	clientConfig.Certificates = nil
+
+	c, s := localPipe(t)
+	defer s.Close()
+	errChan := make(chan error, 1)
+	go func() {
+		client := Client(c, clientConfig)
+		defer client.Close()
+		if err := client.Handshake(); err != nil {
+			errChan <- err
+			return
+		}
+		_, err := client.Read(make([]byte, 1))
+		errChan <- err
+	}()
+	if err := Server(s, serverConfig).Handshake(); err == nil || errors.Is(err, ErrFallback) {
+		t.Errorf("server returned %v", err)
+	}
+	if err := <-errChan; err == nil || !strings.Contains(err.Error(), "certificate required") {
+		t.Errorf("client returned %v, want the alert of the server", err)
+	}
+}
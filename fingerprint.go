@@ -0,0 +1,205 @@
+package tls
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RawClientHello returns the ClientHello handshake message as received. If
+// the server accepted an Encrypted Client Hello, it's the ClientHelloInner,
+// as reconstructed from the ClientHelloOuter.
+func (c *ClientHelloInfo) RawClientHello() []byte {
+	if c.msg == nil {
+		return nil
+	}
+	if raw := c.msg.originalBytes(); raw != nil {
+		return raw
+	}
+	raw, err := c.msg.marshal()
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// Spec returns the ClientHelloSpec of the ClientHello.
+func (c *ClientHelloInfo) Spec() (*ClientHelloSpec, error) {
+	raw := c.RawClientHello()
+	if raw == nil {
+		return nil, errors.New("tls: ClientHello unavailable")
+	}
+	return ParseClientHelloSpec(raw)
+}
+
+// JA3 returns the JA3 fingerprint of the ClientHello, or an empty string if
+// it's unavailable. See [ClientHelloSpec.JA3].
+func (c *ClientHelloInfo) JA3() string {
+	spec, err := c.Spec()
+	if err != nil {
+		return ""
+	}
+	return spec.JA3()
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello, or an empty string if
+// it's unavailable. See [ClientHelloSpec.JA4].
+func (c *ClientHelloInfo) JA4() string {
+	spec, err := c.Spec()
+	if err != nil {
+		return ""
+	}
+	return spec.JA4(c.isQUIC)
+}
+
+// JA3 returns the JA3 fingerprint of the ClientHello, the hexadecimal MD5 of
+// its version, cipher suites, extensions, supported groups and point formats,
+// without the GREASE values. It changes with the order of the extensions,
+// which some browsers randomize; JA4 doesn't.
+func (s *ClientHelloSpec) JA3() string {
+	var extensions []uint16
+	for _, id := range s.extensionIDs() {
+		if !isGREASE(id) {
+			extensions = append(extensions, id)
+		}
+	}
+	var points []uint16
+	if data, ok := s.Extension(extensionSupportedPoints); ok && len(data) > 0 {
+		for _, p := range data[1:] {
+			points = append(points, uint16(p))
+		}
+	}
+	fields := []string{
+		strconv.Itoa(int(cmpOr(s.LegacyVersion, VersionTLS12))),
+		ja3List(s.CipherSuites),
+		ja3List(extensions),
+		ja3List(s.uint16List(extensionSupportedCurves, 2)),
+		ja3List(points),
+	}
+	sum := md5.Sum([]byte(strings.Join(fields, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+func ja3List(list []uint16) string {
+	var b strings.Builder
+	for _, v := range list {
+		if isGREASE(v) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+	return b.String()
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello, sent over QUIC if quic
+// is true, such as "t13d1516h2_8daaf6152771_e5627efa2ab1". Unlike JA3, it
+// sorts the cipher suites and extensions, which leaves it unchanged by the
+// random order of the extensions of some browsers.
+func (s *ClientHelloSpec) JA4(quic bool) string {
+	var b strings.Builder
+	if quic {
+		b.WriteByte('q')
+	} else {
+		b.WriteByte('t')
+	}
+
+	vers := s.LegacyVersion
+	for _, v := range s.uint16List(extensionSupportedVersions, 1) {
+		if v > vers {
+			vers = v
+		}
+	}
+	switch vers {
+	case VersionTLS13:
+		b.WriteString("13")
+	case VersionTLS12:
+		b.WriteString("12")
+	case VersionTLS11:
+		b.WriteString("11")
+	case VersionTLS10:
+		b.WriteString("10")
+	case VersionSSL30:
+		b.WriteString("s3")
+	default:
+		b.WriteString("00")
+	}
+
+	if _, ok := s.Extension(extensionServerName); ok {
+		b.WriteByte('d')
+	} else {
+		b.WriteByte('i')
+	}
+
+	var suites, extensions []string
+	for _, id := range s.CipherSuites {
+		if !isGREASE(id) {
+			suites = append(suites, fmt.Sprintf("%04x", id))
+		}
+	}
+	extensionsCount := 0
+	for _, id := range s.extensionIDs() {
+		if isGREASE(id) {
+			continue
+		}
+		extensionsCount++
+		if id != extensionServerName && id != extensionALPN {
+			extensions = append(extensions, fmt.Sprintf("%04x", id))
+		}
+	}
+	fmt.Fprintf(&b, "%02d%02d", minInt(len(suites), 99), minInt(extensionsCount, 99))
+
+	alpn := "00"
+	if data, ok := s.Extension(extensionALPN); ok && len(data) > 3 && int(data[2]) > 0 && len(data) >= 3+int(data[2]) {
+		proto := data[3 : 3+int(data[2])]
+		first, last := proto[0], proto[len(proto)-1]
+		if isAlphanumeric(first) && isAlphanumeric(last) {
+			alpn = string([]byte{first, last})
+		} else {
+			h := hex.EncodeToString(proto)
+			alpn = h[:1] + h[len(h)-1:]
+		}
+	}
+	b.WriteString(alpn)
+
+	sort.Strings(suites)
+	sort.Strings(extensions)
+	b.WriteByte('_')
+	b.WriteString(ja4Hash(strings.Join(suites, ",")))
+	b.WriteByte('_')
+	if len(extensions) == 0 {
+		b.WriteString(ja4Hash(""))
+		return b.String()
+	}
+	c := strings.Join(extensions, ",")
+	var schemes []string
+	for _, id := range s.uint16List(extensionSignatureAlgorithms, 2) {
+		schemes = append(schemes, fmt.Sprintf("%04x", id))
+	}
+	if len(schemes) > 0 {
+		c += "_" + strings.Join(schemes, ",")
+	}
+	b.WriteString(ja4Hash(c))
+	return b.String()
+}
+
+// ja4Hash returns the truncated SHA-256 of a part of a JA4 fingerprint, or
+// zeroes if it's empty.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+func isAlphanumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
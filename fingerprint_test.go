@@ -0,0 +1,149 @@
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// chromeSpec returns a ClientHelloSpec with the preferences of Chrome, whose
+// JA4 fingerprint is documented as t13d1516h2_8daaf6152771_e5627efa2ab1.
+func chromeSpec() *ClientHelloSpec {
+	ext := func(typ uint16, data ...byte) TLSExtension {
+		return &GenericExtension{Id: typ, Data: data}
+	}
+	return &ClientHelloSpec{
+		LegacyVersion: VersionTLS12,
+		CipherSuites: []uint16{0x4a4a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030,
+			0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		CompressionMethods: []uint8{0},
+		Extensions: []TLSExtension{
+			ext(0x2a2a),
+			ext(extensionServerName, 0, 14, 0, 0, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm'),
+			ext(0x0017),
+			ext(0xff01, 0),
+			ext(extensionSupportedCurves, 0, 8, 0x3a, 0x3a, 0, 0x1d, 0, 0x17, 0, 0x18),
+			ext(extensionSupportedPoints, 1, 0),
+			ext(0x0023),
+			ext(extensionALPN, 0, 12, 2, 'h', '2', 8, 'h', 't', 't', 'p', '/', '1', '.', '1'),
+			ext(0x0005, 1, 0, 0, 0, 0),
+			ext(extensionSignatureAlgorithms, 0, 16, 4, 3, 8, 4, 4, 1, 5, 3, 8, 5, 5, 1, 8, 6, 6, 1),
+			ext(0x0012),
+			ext(0x0033, 0, 0),
+			ext(0x002d, 1, 1),
+			ext(extensionSupportedVersions, 6, 0x6a, 0x6a, 3, 4, 3, 3),
+			ext(0x001b, 2, 0, 2),
+			ext(0x4469, 0, 3, 2, 'h', '2'),
+			ext(0x0015, 0, 0),
+			ext(0xdada, 0),
+		},
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	spec := chromeSpec()
+	if got, want := spec.JA4(false), "t13d1516h2_8daaf6152771_e5627efa2ab1"; got != want {
+		t.Errorf("JA4 %s, want %s", got, want)
+	}
+	if got, want := spec.JA4(true), "q13d1516h2_8daaf6152771_e5627efa2ab1"; got != want {
+		t.Errorf("JA4 over QUIC %s, want %s", got, want)
+	}
+	ja3 := spec.JA3()
+	if want := "cd08e31494f9531f560d64c695473da9"; ja3 != want {
+		t.Errorf("JA3 %s, want %s", ja3, want)
+	}
+
+	// JA4 doesn't change with the order of the extensions, unlike JA3.
+	spec.Extensions[2], spec.Extensions[3] = spec.Extensions[3], spec.Extensions[2]
+	if got, want := spec.JA4(false), "t13d1516h2_8daaf6152771_e5627efa2ab1"; got != want {
+		t.Errorf("JA4 of the shuffled extensions %s, want %s", got, want)
+	}
+	if spec.JA3() == ja3 {
+		t.Error("JA3 unchanged by the order of the extensions")
+	}
+}
+
+// marshalClientHelloSpec returns a ClientHello record with the fields of
+// spec, and a zero random and session ID.
+func marshalClientHelloSpec(spec *ClientHelloSpec) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(typeClientHello)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(spec.LegacyVersion)
+		b.AddBytes(make([]byte, 32))
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(make([]byte, 32))
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, id := range spec.CipherSuites {
+				b.AddUint16(id)
+			}
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(spec.CompressionMethods)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, ext := range spec.Extensions {
+				id, data, err := marshalExtension(ext)
+				if err != nil {
+					panic(err)
+				}
+				b.AddUint16(id)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(data)
+				})
+			}
+		})
+	})
+	msg := b.BytesOrPanic()
+	return append([]byte{byte(recordTypeHandshake), 3, 1, byte(len(msg) >> 8), byte(len(msg))}, msg...)
+}
+
+func TestClientHelloInfoFingerprints(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.NextProtos = []string{"h2"}
+	spec, err := ParseClientHelloSpec(sentClientHello(t, clientConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja3 := spec.JA3()
+	// The fingerprints are those of the ClientHello as received, with its
+	// order of extensions and GREASE values.
+	for i, j := 0, len(spec.Extensions)-1; i < j; i, j = i+1, j-1 {
+		spec.Extensions[i], spec.Extensions[j] = spec.Extensions[j], spec.Extensions[i]
+	}
+	spec.Extensions = append(spec.Extensions, &GenericExtension{Id: 0x1a1a})
+	record := marshalClientHelloSpec(spec)
+
+	var info *ClientHelloInfo
+	serverConfig := testConfig.Clone()
+	serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+		info = chi
+		return nil, errors.New("fingerprinted")
+	}
+	c, s := localPipe(t)
+	go func() {
+		c.Write(record)
+		io.Copy(io.Discard, c)
+		c.Close()
+	}()
+	if err := Server(s, serverConfig).Handshake(); err == nil || err.Error() != "fingerprinted" {
+		t.Fatalf("server returned %v", err)
+	}
+	s.Close()
+	if raw := info.RawClientHello(); !bytes.Equal(raw, record[recordHeaderLen:]) {
+		t.Errorf("raw ClientHello %x, want %x", raw, record[recordHeaderLen:])
+	}
+	if got := info.JA3(); got != spec.JA3() || got == ja3 {
+		t.Errorf("JA3 %s, want %s", got, spec.JA3())
+	}
+	if got := info.JA4(); got != spec.JA4(false) || got[:4] != "t13i" || got[8:10] != "h2" {
+		t.Errorf("JA4 %s, want %s", got, spec.JA4(false))
+	}
+	if _, err := (&ClientHelloInfo{}).Spec(); err == nil {
+		t.Error("Spec of an empty ClientHelloInfo succeeded")
+	}
+}
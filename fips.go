@@ -0,0 +1,101 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+)
+
+// The algorithms allowed by Config.FIPSMode, those of SP 800-52 Rev. 2 which
+// are approved by SP 800-140C and SP 800-140D, as in FIPS 140-3 mode of the
+// standard library.
+var (
+	allowedSupportedVersionsFIPS = []uint16{
+		VersionTLS12,
+		VersionTLS13,
+	}
+	allowedCurvePreferencesFIPS = []CurveID{
+		X25519MLKEM768,
+		SecP256r1MLKEM768,
+		SecP384r1MLKEM1024,
+		CurveP256,
+		CurveP384,
+		CurveP521,
+	}
+	allowedSignatureAlgorithmsFIPS = []SignatureScheme{
+		PSSWithSHA256,
+		ECDSAWithP256AndSHA256,
+		Ed25519,
+		PSSWithSHA384,
+		PSSWithSHA512,
+		PKCS1WithSHA256,
+		PKCS1WithSHA384,
+		PKCS1WithSHA512,
+		ECDSAWithP384AndSHA384,
+		ECDSAWithP521AndSHA512,
+	}
+	allowedCipherSuitesFIPS = []uint16{
+		TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	allowedCipherSuitesTLS13FIPS = []uint16{
+		TLS_AES_128_GCM_SHA256,
+		TLS_AES_256_GCM_SHA384,
+	}
+)
+
+// fipsMode reports whether c is restricted to the FIPS 140-3 approved
+// algorithms.
+func (c *Config) fipsMode() bool {
+	return c != nil && c.FIPSMode
+}
+
+// isKeyAllowedFIPS reports whether a certificate public key is of an approved
+// type and size: RSA of at least 2048 bits, ECDSA over the NIST curves from
+// P-256, or Ed25519.
+func isKeyAllowedFIPS(pub any) bool {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return pub.N.BitLen() >= 2048
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return true
+		}
+	case ed25519.PublicKey:
+		return true
+	}
+	return false
+}
+
+// errEMSRequiredFIPS is returned when a TLS 1.2 peer doesn't support the
+// extended master secret, as the master secret derivation of RFC 7627 is the
+// only one approved for TLS 1.2.
+var errEMSRequiredFIPS = errors.New("tls: peer doesn't support the extended master secret, required by Config.FIPSMode")
+
+// fipsApproved reports whether everything negotiated for the connection is in
+// the set allowed by Config.FIPSMode, whether or not it's set.
+func (c *Conn) fipsApproved() bool {
+	if !slicesContains(allowedSupportedVersionsFIPS, c.vers) {
+		return false
+	}
+	if c.vers == VersionTLS13 {
+		if !slicesContains(allowedCipherSuitesTLS13FIPS, c.cipherSuite) {
+			return false
+		}
+	} else if !c.extMasterSecret || !slicesContains(allowedCipherSuitesFIPS, c.cipherSuite) {
+		return false
+	}
+	// The key exchange and the signature are absent when resuming.
+	if c.curveID != 0 && !slicesContains(allowedCurvePreferencesFIPS, c.curveID) {
+		return false
+	}
+	if c.peerSigAlg != 0 && !slicesContains(allowedSignatureAlgorithmsFIPS, c.peerSigAlg) {
+		return false
+	}
+	return len(c.peerCertificates) == 0 || isKeyAllowedFIPS(c.peerCertificates[0].PublicKey)
+}
@@ -0,0 +1,94 @@
+package tls
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFIPSMode(t *testing.T) {
+	ecdsaCert := Certificate{
+		Certificate: [][]byte{testP256Certificate},
+		PrivateKey:  testP256PrivateKey,
+	}
+
+	for _, tt := range []struct {
+		name         string
+		clientFIPS   bool
+		serverFIPS   bool
+		maxVersion   uint16
+		cipherSuites []uint16
+		curves       []CurveID
+		rsaCert      bool
+		wantError    string
+		wantApproved bool
+	}{
+		{name: "TLS13", clientFIPS: true, serverFIPS: true, wantApproved: true},
+		{name: "TLS12", clientFIPS: true, serverFIPS: true, maxVersion: VersionTLS12, wantApproved: true},
+		{name: "NotSet", curves: []CurveID{CurveP256}, wantApproved: true},
+		{name: "X25519", curves: []CurveID{X25519}},
+		{name: "ChaCha20", maxVersion: VersionTLS12, cipherSuites: []uint16{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}},
+		{name: "ClientX25519", clientFIPS: true, curves: []CurveID{X25519}, wantError: "curve"},
+		{name: "ServerChaCha20", serverFIPS: true, maxVersion: VersionTLS12, cipherSuites: []uint16{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}, wantError: "no cipher suite"},
+		{name: "ServerTLS11", serverFIPS: true, maxVersion: VersionTLS11, wantError: "protocol version"},
+		{name: "SmallRSA", clientFIPS: true, rsaCert: true, wantError: "not allowed by Config.FIPSMode"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.FIPSMode = tt.clientFIPS
+			clientConfig.CurvePreferences = tt.curves
+			clientConfig.CipherSuites = tt.cipherSuites
+			if tt.maxVersion != 0 {
+				clientConfig.MaxVersion = tt.maxVersion
+			}
+			serverConfig := testConfig.Clone()
+			serverConfig.FIPSMode = tt.serverFIPS
+			serverConfig.AESGCMPreference = AESGCMPreferenceAlways
+			if !tt.rsaCert {
+				serverConfig.Certificates = []Certificate{ecdsaCert}
+				serverConfig.NameToCertificate = nil
+			}
+
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("got error %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cs.FIPSApproved != tt.wantApproved || ss.FIPSApproved != tt.wantApproved {
+				t.Errorf("got FIPSApproved %v on the client and %v on the server, want %v", cs.FIPSApproved, ss.FIPSApproved, tt.wantApproved)
+			}
+		})
+	}
+}
+
+func TestFIPSModeAlgorithms(t *testing.T) {
+	config := &Config{FIPSMode: true, TLCP: true, ShangMiCipherSuites: true, CipherSuites: allCipherSuites()}
+	if got, want := config.supportedVersions(roleClient, false), []uint16{VersionTLS13, VersionTLS12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got versions %v, want %v", got, want)
+	}
+	for _, id := range config.cipherSuites(true) {
+		if !slicesContains(allowedCipherSuitesFIPS, id) {
+			t.Errorf("cipher suite %s enabled", CipherSuiteName(id))
+		}
+	}
+	for _, id := range config.tls13CipherSuites(defaultCipherSuitesTLS13, false) {
+		if !slicesContains(allowedCipherSuitesTLS13FIPS, id) {
+			t.Errorf("cipher suite %s enabled", CipherSuiteName(id))
+		}
+	}
+	for _, curve := range config.curvePreferences(VersionTLS13) {
+		if !slicesContains(allowedCurvePreferencesFIPS, curve) {
+			t.Errorf("curve %v enabled", curve)
+		}
+	}
+	for _, s := range config.supportedSignatureAlgorithms(VersionTLS12) {
+		if !slicesContains(allowedSignatureAlgorithmsFIPS, s) {
+			t.Errorf("signature algorithm %v enabled", s)
+		}
+	}
+}
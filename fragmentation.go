@@ -0,0 +1,93 @@
+package tls
+
+import (
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// A ClientHelloFragmentation splits the records of the first ClientHello of a
+// client across multiple TCP segments, written apart, against the filters
+// inspecting the server name of the first segment of the connections without
+// reassembling them. It is set in [Config.ClientHelloFragmentation].
+//
+// The segments are separate writes to the underlying connection, which a
+// *net.TCPConn sends as separate segments since Go disables Nagle's
+// algorithm by default.
+type ClientHelloFragmentation struct {
+	// Sizes are the sizes of the first segments, such as []int{1} to split
+	// the first byte from the rest.
+	Sizes []int
+
+	// MinSize and MaxSize, if MaxSize is not zero, split the rest into
+	// segments of random sizes from MinSize to MaxSize. Otherwise, the rest
+	// is sent in one segment.
+	MinSize, MaxSize int
+
+	// Delay is the delay between two segments, plus a random jitter from
+	// zero to Jitter. It ends early, failing the handshake, if the write
+	// deadline of the connection passes or it's closed.
+	Delay, Jitter time.Duration
+}
+
+// writeFragmented writes the records of the ClientHello in data according to
+// f.
+func (c *Conn) writeFragmented(f *ClientHelloFragmentation, data []byte) (int, error) {
+	rand := c.config.rand()
+	written := 0
+	for i := 0; len(data) > 0; i++ {
+		n := len(data)
+		if i < len(f.Sizes) {
+			n = f.Sizes[i]
+		} else if f.MaxSize > 0 && f.MaxSize >= f.MinSize {
+			n = f.MinSize + randIntn(rand, f.MaxSize-f.MinSize+1)
+		}
+		if n < 1 {
+			n = 1
+		} else if n > len(data) {
+			n = len(data)
+		}
+		if i > 0 {
+			delay := f.Delay
+			if f.Jitter > 0 {
+				delay += time.Duration(randIntn(rand, int(f.Jitter)+1))
+			}
+			if delay > 0 {
+				if err := c.writeWait.wait(delay); err != nil {
+					return written, err
+				}
+			}
+		}
+		m, err := c.write(data[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		data = data[n:]
+	}
+	return written, nil
+}
+
+// serverNameBounds returns the offsets in the marshaled ClientHello msg of the
+// start and end of its server_name extension.
+func serverNameBounds(msg []byte) (start, end int, ok bool) {
+	s := cryptobyte.String(msg)
+	var extensions cryptobyte.String
+	if !s.Skip(4+2+32) || !skipUint8LengthPrefixed(&s) || !skipUint16LengthPrefixed(&s) ||
+		!skipUint8LengthPrefixed(&s) || !s.ReadUint16LengthPrefixed(&extensions) {
+		return 0, 0, false
+	}
+	for offset := len(msg) - len(extensions); !extensions.Empty(); {
+		var typ uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&typ) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return 0, 0, false
+		}
+		next := len(msg) - len(extensions)
+		if typ == extensionServerName {
+			return offset, next, true
+		}
+		offset = next
+	}
+	return 0, 0, false
+}
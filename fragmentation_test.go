@@ -0,0 +1,170 @@
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A writeRecorder is a net.Conn recording the writes to it, and their sizes.
+type writeRecorder struct {
+	net.Conn
+	sync.Mutex
+	writes  []int
+	written []byte
+}
+
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	w.Lock()
+	w.writes = append(w.writes, len(b))
+	w.written = append(w.written, b...)
+	w.Unlock()
+	return w.Conn.Write(b)
+}
+
+func TestClientHelloFragmentation(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		f    *ClientHelloFragmentation
+		// check is called with the sizes of the writes of the ClientHello.
+		check func(t *testing.T, writes []int)
+	}{
+		{"FirstByte", &ClientHelloFragmentation{Sizes: []int{1}}, func(t *testing.T, writes []int) {
+			if len(writes) != 2 || writes[0] != 1 {
+				t.Errorf("ClientHello written as %v", writes)
+			}
+		}},
+		{"Sizes", &ClientHelloFragmentation{Sizes: []int{3, 0, 100}, Delay: time.Millisecond}, func(t *testing.T, writes []int) {
+			if len(writes) != 4 || writes[0] != 3 || writes[1] != 1 || writes[2] != 100 {
+				t.Errorf("ClientHello written as %v", writes)
+			}
+		}},
+		{"Random", &ClientHelloFragmentation{Sizes: []int{5}, MinSize: 10, MaxSize: 20}, func(t *testing.T, writes []int) {
+			if writes[0] != 5 || len(writes) < 3 {
+				t.Errorf("ClientHello written as %v", writes)
+			}
+			for _, n := range writes[1 : len(writes)-1] {
+				if n < 10 || n > 20 {
+					t.Errorf("ClientHello written as %v", writes)
+				}
+			}
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.ClientHelloFragmentation = tt.f
+			c, s := localPipe(t)
+			recorder := &writeRecorder{Conn: c}
+			client := Client(recorder, clientConfig)
+			defer client.Close()
+			checked := false
+			serverConfig := testConfig.Clone()
+			serverConfig.GetConfigForClient = func(*ClientHelloInfo) (*Config, error) {
+				recorder.Lock()
+				defer recorder.Unlock()
+				tt.check(t, recorder.writes)
+				checked = true
+				return nil, nil
+			}
+			server := Server(s, serverConfig)
+			defer server.Close()
+			errChan := make(chan error, 1)
+			go func() {
+				if err := server.Handshake(); err != nil {
+					errChan <- err
+					return
+				}
+				_, err := server.Read(make([]byte, 5))
+				errChan <- err
+			}()
+			if err := client.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the ClientHello is fragmented.
+			recorder.Lock()
+			writes := len(recorder.writes)
+			if !checked {
+				t.Error("the ClientHello wasn't checked")
+			}
+			recorder.Unlock()
+			if _, err := client.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+			recorder.Lock()
+			defer recorder.Unlock()
+			if len(recorder.writes) != writes+1 {
+				t.Errorf("application data written in %d writes", len(recorder.writes)-writes)
+			}
+		})
+	}
+}
+
+func TestSplitClientHelloAtServerName(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.ServerName = "example.com"
+	clientConfig.SplitClientHelloAtServerName = true
+	if _, _, err := testHandshake(t, clientConfig, testConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	c, s := localPipe(t)
+	defer s.Close()
+	go func() {
+		Client(c, clientConfig).Handshake()
+		c.Close()
+	}()
+	var records [][]byte
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		header := make([]byte, recordHeaderLen)
+		if _, err := io.ReadFull(s, header); err != nil {
+			t.Fatal(err)
+		}
+		record := make([]byte, recordHeaderLen+(int(header[3])<<8|int(header[4])))
+		copy(record, header)
+		if _, err := io.ReadFull(s, record[recordHeaderLen:]); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record[recordHeaderLen:])
+		stream = append(stream, record...)
+	}
+	spec, err := ParseClientHelloSpec(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverName, _ := spec.Extension(extensionServerName)
+	if want := append([]byte{0, 0, 0, byte(len(serverName))}, serverName...); !bytes.Equal(records[1], want) {
+		t.Errorf("second record %x, want the server_name extension %x", records[1], want)
+	}
+}
+
+func TestClientHelloFragmentationDeadline(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.ClientHelloFragmentation = &ClientHelloFragmentation{Sizes: []int{1}, Delay: time.Hour}
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	defer client.Close()
+	defer s.Close()
+	go io.Copy(io.Discard, s)
+
+	client.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	errChan := make(chan error, 1)
+	go func() { errChan <- client.Handshake() }()
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Handshake returned %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the delay between the segments wasn't interrupted")
+	}
+}
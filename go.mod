@@ -3,11 +3,12 @@ module github.com/metacubex/tls
 go 1.20
 
 require (
+	github.com/cloudflare/circl v1.3.7
+	github.com/emmansun/gmsm v0.29.8
 	github.com/metacubex/cpu v0.1.0
 	github.com/metacubex/hkdf v0.1.0
 	github.com/metacubex/hpke v0.1.0
 	github.com/metacubex/mlkem v0.1.0
 	golang.org/x/crypto v0.33.0
+	golang.org/x/sys v0.30.0
 )
-
-require golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,42 @@
+package tls
+
+// The GOST R 34.11-2012 cipher suites of RFC 9189.
+//
+// They are not implemented by this package, which has no implementation of
+// the Kuznyechik and Magma ciphers, of the Streebog hash the PRF is built on,
+// of the GOST R 34.10-2012 curves and signatures, or of the key export the
+// key exchange needs. The IDs are defined so that a GetConfigForClient
+// callback can recognize the clients offering them in
+// [ClientHelloInfo.CipherSuites], for example to hand them off to a server
+// which supports them.
+const (
+	TLS_GOSTR341112_256_WITH_KUZNYECHIK_CTR_OMAC uint16 = 0xc100
+	TLS_GOSTR341112_256_WITH_MAGMA_CTR_OMAC      uint16 = 0xc101
+	TLS_GOSTR341112_256_WITH_28147_CNT_IMIT      uint16 = 0xc102
+)
+
+// The TLS 1.3 GOST cipher suites of RFC 9367.
+//
+// Only their record protection and hash can be provided, by registering them
+// with [RegisterCipherSuiteTLS13] with the Streebog hash as NewHash, and the
+// MGM mode of Kuznyechik or Magma along with the TLSTREE rekeying as
+// NewRecordAEAD, with IVLen set to the block size of the cipher. The GOST key
+// exchange groups and GOST R 34.10-2012 signature algorithms of RFC 9367 are
+// neither implemented nor pluggable: the handshake uses those of this
+// package, so it doesn't interoperate with the peers requiring GOST
+// certificates or key exchanges.
+const (
+	TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_L uint16 = 0xc103
+	TLS_GOSTR341112_256_WITH_MAGMA_MGM_L      uint16 = 0xc104
+	TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_S uint16 = 0xc105
+	TLS_GOSTR341112_256_WITH_MAGMA_MGM_S      uint16 = 0xc106
+)
+
+// gostCipherSuitesTLS13 are the IDs of RFC 9367, which may be registered
+// with RegisterCipherSuiteTLS13 besides the private use ones.
+var gostCipherSuitesTLS13 = []uint16{
+	TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_L,
+	TLS_GOSTR341112_256_WITH_MAGMA_MGM_L,
+	TLS_GOSTR341112_256_WITH_KUZNYECHIK_MGM_S,
+	TLS_GOSTR341112_256_WITH_MAGMA_MGM_S,
+}
@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cloudflare/circl/sign/ed448"
 	"github.com/metacubex/hpke"
 )
 
@@ -38,8 +39,9 @@ type clientHandshakeState struct {
 
 func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echClientContext, error) {
 	config := c.config
-	if len(config.ServerName) == 0 && !config.InsecureSkipVerify {
-		return nil, nil, nil, errors.New("tls: either ServerName or InsecureSkipVerify must be specified in the tls.Config")
+	if len(config.ServerName) == 0 && !config.InsecureSkipVerify && len(config.ExternalPSKs) == 0 &&
+		config.GetClientPSK == nil {
+		return nil, nil, nil, errors.New("tls: either ServerName, InsecureSkipVerify or ExternalPSKs must be specified in the tls.Config")
 	}
 
 	nextProtosLength := 0
@@ -63,14 +65,20 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echCli
 	maxVersion := supportedVersions[0]
 	minVersion := supportedVersions[len(supportedVersions)-1]
 
+	if maxVersion == VersionTLCP {
+		hello, err := c.makeTLCPClientHello()
+		return hello, nil, nil, err
+	}
+
 	hello := &clientHelloMsg{
 		vers:                         maxVersion,
 		compressionMethods:           []uint8{compressionNone},
 		random:                       make([]byte, 32),
 		extendedMasterSecret:         true,
+		encryptThenMAC:               config.EncryptThenMAC && minVersion < VersionTLS13,
 		ocspStapling:                 true,
 		scts:                         true,
-		serverName:                   hostnameInSNI(config.ServerName),
+		serverName:                   hostnameInSNI(config.sentServerName()),
 		supportedCurves:              config.curvePreferences(maxVersion),
 		supportedPoints:              []uint8{pointFormatUncompressed},
 		secureRenegotiationSupported: true,
@@ -88,14 +96,22 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echCli
 	if c.handshakes > 0 {
 		hello.secureRenegotiation = c.clientFinished[:]
 	}
+	if config.RecordSizeLimit != 0 && c.quic == nil {
+		hello.recordSizeLimit = config.recordSizeLimit(maxVersion)
+	}
 
-	hello.cipherSuites = config.cipherSuites(hasAESGCMHardwareSupport)
+	hello.cipherSuites = config.cipherSuites(config.hasAESGCMHardwareSupport())
 	// Don't advertise TLS 1.2-only cipher suites unless we're attempting TLS 1.2.
 	if maxVersion < VersionTLS12 {
 		hello.cipherSuites = slicesDeleteFunc(hello.cipherSuites, func(id uint16) bool {
 			return cipherSuiteByID(id).flags&suiteTLS12 != 0
 		})
 	}
+	if !config.hasClientPSK() {
+		hello.cipherSuites = slicesDeleteFunc(hello.cipherSuites, func(id uint16) bool {
+			return cipherSuiteByID(id).flags&suitePSK != 0
+		})
+	}
 
 	_, err := io.ReadFull(config.rand(), hello.random)
 	if err != nil {
@@ -115,8 +131,8 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echCli
 	}
 
 	if maxVersion >= VersionTLS12 {
-		hello.supportedSignatureAlgorithms = supportedSignatureAlgorithms(minVersion)
-		hello.supportedSignatureAlgorithmsCert = supportedSignatureAlgorithmsCert()
+		hello.supportedSignatureAlgorithms = config.supportedSignatureAlgorithms(minVersion)
+		hello.supportedSignatureAlgorithmsCert = config.certificateSignatureSchemes()
 	}
 
 	var keyShareKeys *keySharePrivateKeys
@@ -126,11 +142,11 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echCli
 			hello.cipherSuites = nil
 		}
 
-		if hasAESGCMHardwareSupport {
-			hello.cipherSuites = append(hello.cipherSuites, defaultCipherSuitesTLS13...)
-		} else {
-			hello.cipherSuites = append(hello.cipherSuites, defaultCipherSuitesTLS13NoAES...)
+		preferenceList := defaultCipherSuitesTLS13
+		if !config.hasAESGCMHardwareSupport() {
+			preferenceList = defaultCipherSuitesTLS13NoAES
 		}
+		hello.cipherSuites = append(hello.cipherSuites, config.tls13CipherSuites(preferenceList, c.quic != nil)...)
 
 		if len(hello.supportedCurves) == 0 {
 			return nil, nil, nil, errors.New("tls: no supported elliptic curves for ECDHE")
@@ -151,6 +167,26 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echCli
 		if len(hello.keyShares) == 2 && !slicesContains(hello.supportedCurves, hello.keyShares[1].group) {
 			hello.keyShares = hello.keyShares[:1]
 		}
+
+		hello.certCompressionAlgorithms = config.certCompressionAlgorithms()
+		if config.AcceptDelegatedCredentials {
+			hello.delegatedCredentialSchemes = delegatedCredentialSchemes
+		}
+		hello.serverCertificateTypes = certificateTypes(config.ServerCertificateTypes)
+		hello.clientCertificateTypes = certificateTypes(config.ClientCertificateTypes)
+		hello.postHandshakeAuth = config.PostHandshakeAuth && c.quic == nil
+		if config.SendCertificateAuthorities && config.RootCAs != nil {
+			hello.certificateAuthorities = config.RootCAs.Subjects()
+		}
+	}
+
+	// The FFDHE groups are only offered along with the DHE cipher suites,
+	// and never get a TLS 1.3 key share.
+	if slicesContainsFunc(hello.cipherSuites, func(id uint16) bool {
+		suite := cipherSuiteByID(id)
+		return suite != nil && suite.flags&suiteDHE != 0
+	}) {
+		hello.supportedCurves = append(hello.supportedCurves, config.ffdheGroups()...)
 	}
 
 	if c.quic != nil {
@@ -189,6 +225,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echCli
 		hello.ticketSupported = false
 		hello.secureRenegotiationSupported = false
 		hello.extendedMasterSecret = false
+		hello.encryptThenMAC = false
 
 		info := append([]byte("tls ech\x00"), ech.config.raw...)
 		ech.encapsulatedKey, ech.hpkeContext, err = hpke.NewSender(echPK, kdf, aead, info)
@@ -221,16 +258,37 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 	// need to be reset.
 	c.didResume = false
 	c.curveID = 0
+	c.earlyDataOffered = false
 
+	if c.helloSpecErr != nil {
+		return c.helloSpecErr
+	}
 	hello, keyShareKeys, ech, err := c.makeClientHello()
 	if err != nil {
 		return err
 	}
+	if c.helloSpec != nil {
+		if err := c.applyClientHelloSpec(hello, ech); err != nil {
+			return err
+		}
+	}
+	if c.config.HandshakeScript != nil && c.config.HandshakeScript.ClientHello != nil {
+		if err := c.scriptClientHello(hello); err != nil {
+			return err
+		}
+	}
 
 	session, earlySecret, binderKey, err := c.loadSession(hello)
 	if err != nil {
 		return err
 	}
+	var externalPSKs []*importedPSK
+	if session == nil && ech == nil && hello.vers != VersionTLCP && c.config.SessionIDGenerator == nil && c.config.CovertAuth == nil {
+		externalPSKs, err = c.loadExternalPSKs(hello)
+		if err != nil {
+			return err
+		}
+	}
 	if session != nil {
 		defer func() {
 			// If we got a handshake failure when resuming a session, throw away
@@ -270,11 +328,24 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 		}
 	}
 
+	if c.config.CovertAuth != nil {
+		if err := c.addCovertAuth(hello, ech); err != nil {
+			return err
+		}
+	}
+	if c.config.SessionIDGenerator != nil {
+		if err := c.generateSessionID(hello, ech); err != nil {
+			return err
+		}
+	}
+
 	c.serverName = hello.serverName
 
 	if _, err := c.writeHandshakeRecord(hello, nil); err != nil {
 		return err
 	}
+	c.trace.helloSent()
+	c.earlyDataOffered = hello.earlyData
 
 	if hello.earlyData {
 		suite := cipherSuiteTLS13ByID(session.cipherSuite)
@@ -287,8 +358,16 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 			return err
 		}
 		earlyTrafficSecret := earlySecret.ClientEarlyTrafficSecret(transcript)
-		c.quicSetWriteSecret(QUICEncryptionLevelEarly, suite.id, earlyTrafficSecret)
+		if err := c.writeKeyLog(keyLogLabelClientEarly, transcriptHello.random, earlyTrafficSecret); err != nil {
+			return err
+		}
+		if c.quic != nil {
+			c.quicSetWriteSecret(QUICEncryptionLevelEarly, suite.id, earlyTrafficSecret)
+		} else if err := c.writeEarlyData(suite, earlyTrafficSecret); err != nil {
+			return err
+		}
 	}
+	c.earlyData = nil
 
 	// serverHelloMsg is not included in the transcript
 	msg, err := c.readHandshake(nil)
@@ -301,11 +380,19 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 		c.sendAlert(alertUnexpectedMessage)
 		return unexpectedMessageError(serverHello, msg)
 	}
+	c.trace.helloReceived()
+	c.peerHelloExtensions = serverHello.extensions
 
 	if err := c.pickTLSVersion(serverHello); err != nil {
 		return err
 	}
 
+	if hello.earlyData && c.quic == nil && c.vers != VersionTLS13 {
+		c.out.discardTrafficSecret()
+		c.sendAlert(alertProtocolVersion)
+		return errors.New("tls: server selected TLS 1.2 in response to 0-RTT data")
+	}
+
 	// If we are negotiating a protocol version that's lower than what we
 	// support, check for the server downgrade canaries.
 	// See RFC 8446, Section 4.1.3.
@@ -328,6 +415,8 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 			session:      session,
 			earlySecret:  earlySecret,
 			binderKey:    binderKey,
+			externalPSKs: externalPSKs,
+			sentDummyCCS: hello.earlyData && c.quic == nil,
 			echContext:   ech,
 		}
 		return hs.handshake()
@@ -343,9 +432,25 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 	return hs.handshake()
 }
 
+// generateSessionID sets the session ID of the final hello with
+// Config.SessionIDGenerator.
+func (c *Conn) generateSessionID(hello *clientHelloMsg, ech *echClientContext) error {
+	if ech != nil || c.quic != nil {
+		return errors.New("tls: Config.SessionIDGenerator can't be used with ECH or QUIC")
+	}
+	hello.sessionId = make([]byte, 32)
+	msg, err := hello.marshal()
+	if err != nil {
+		return err
+	}
+	return c.config.SessionIDGenerator(msg, hello.sessionId)
+}
+
 func (c *Conn) loadSession(hello *clientHelloMsg) (
 	session *SessionState, earlySecret *tls13EarlySecret, binderKey []byte, err error) {
-	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil {
+	// TLCP sessions aren't resumed.
+	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil || hello.vers == VersionTLCP ||
+		c.config.SessionIDGenerator != nil || c.config.CovertAuth != nil {
 		return nil, nil, nil, nil
 	}
 
@@ -411,7 +516,7 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (
 			Roots:       c.config.RootCAs,
 			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		}
-		if !anyValidVerifiedChain(session.verifiedChains, opts) {
+		if !c.config.resumedChainsValid(session.peerCertificates, session.verifiedChains, opts) {
 			// No valid chains, delete the entry.
 			c.config.ClientSessionCache.Put(cacheKey, nil)
 			return nil, nil, nil, nil
@@ -453,19 +558,21 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (
 		return nil, nil, nil, nil
 	}
 
-	if c.quic != nil {
-		if c.quic.enableSessionEvents {
-			c.quicResumeSession(session)
-		}
+	if c.quic != nil && c.quic.enableSessionEvents {
+		c.quicResumeSession(session)
+	}
 
-		// For 0-RTT, the cipher suite has to match exactly, and we need to be
-		// offering the same ALPN.
-		if session.EarlyData && mutualCipherSuiteTLS13(hello.cipherSuites, session.cipherSuite) != nil {
-			for _, alpn := range hello.alpnProtocols {
-				if alpn == session.alpnProtocol {
-					hello.earlyData = true
-					break
-				}
+	// For 0-RTT, the cipher suite has to match exactly, and we need to be
+	// offering the same ALPN.
+	if session.EarlyData && c.canSendEarlyData(session) &&
+		mutualCipherSuiteTLS13(hello.cipherSuites, session.cipherSuite) != nil {
+		if c.quic == nil && session.alpnProtocol == "" && len(hello.alpnProtocols) == 0 {
+			hello.earlyData = true
+		}
+		for _, alpn := range hello.alpnProtocols {
+			if alpn == session.alpnProtocol {
+				hello.earlyData = true
+				break
 			}
 		}
 	}
@@ -490,6 +597,41 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (
 	return
 }
 
+// canSendEarlyData reports whether the client has 0-RTT data to send that the
+// server allowed for session. QUIC handles early data outside of crypto/tls.
+func (c *Conn) canSendEarlyData(session *SessionState) bool {
+	if c.quic != nil {
+		return true
+	}
+	return c.config.EarlyData && len(c.earlyData) > 0 &&
+		uint64(len(c.earlyData)) <= uint64(session.maxEarlyData)
+}
+
+// writeEarlyData sends the data queued by [Conn.WriteEarlyData] right after
+// the first ClientHello, protected with the client_early_traffic_secret. The
+// write keys are left in place until the client sends EndOfEarlyData or learns
+// that the server rejected the early data. See RFC 8446, Section 4.2.10.
+func (c *Conn) writeEarlyData(suite *cipherSuiteTLS13, earlyTrafficSecret []byte) error {
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	// 0-RTT is only offered when resuming a TLS 1.3 session, so the record
+	// layer can use TLS 1.3 framing before the ServerHello.
+	c.out.version = VersionTLS13
+
+	// When offering early data, the compatibility ChangeCipherSpec is sent
+	// immediately after the first ClientHello. See RFC 8446, Appendix D.4.
+	if _, err := c.writeRecordLocked(recordTypeChangeCipherSpec, []byte{1}); err != nil {
+		return err
+	}
+
+	c.out.setTrafficSecret(suite, QUICEncryptionLevelEarly, earlyTrafficSecret, c.aeadEngine())
+	if _, err := c.writeRecordLocked(recordTypeApplicationData, c.earlyData); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *Conn) pickTLSVersion(serverHello *serverHelloMsg) error {
 	peerVersion := serverHello.vers
 	if serverHello.supportedVersion != 0 {
@@ -552,6 +694,11 @@ func (hs *clientHandshakeState) handshake() error {
 
 	c.buffering = true
 	c.didResume = isResume
+	if isResume {
+		c.sessionEvent(SessionResumed, hs.session)
+	} else if hs.session != nil {
+		c.sessionEvent(SessionResumptionRejected, hs.session)
+	}
 	if isResume {
 		if err := hs.establishKeys(); err != nil {
 			return err
@@ -569,7 +716,7 @@ func (hs *clientHandshakeState) handshake() error {
 		if c.config.VerifyConnection != nil {
 			if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
 				c.sendAlert(alertBadCertificate)
-				return err
+				return c.callbackError(err)
 			}
 		}
 		if err := hs.sendFinished(c.clientFinished[:]); err != nil {
@@ -592,6 +739,12 @@ func (hs *clientHandshakeState) handshake() error {
 			return err
 		}
 		c.clientFinishedIsFirst = true
+		if hs.canFalseStart() {
+			c.falseStart = hs
+			c.ekm = ekmFromMasterSecret(c.vers, hs.suite, hs.masterSecret, hs.hello.random, hs.serverHello.random)
+			c.isHandshakeComplete.Store(true)
+			return nil
+		}
 		if err := hs.readSessionTicket(); err != nil {
 			return err
 		}
@@ -609,6 +762,37 @@ func (hs *clientHandshakeState) handshake() error {
 	return nil
 }
 
+// canFalseStart reports whether the full handshake may return before the
+// server's Finished message, see Config.FalseStart.
+func (hs *clientHandshakeState) canFalseStart() bool {
+	c := hs.c
+	return c.config.FalseStart && c.handshakes == 0 &&
+		hs.suite.flags&suiteECDHE != 0 && hs.suite.aead != nil &&
+		c.clientProtocol != ""
+}
+
+// finishFalseStartLocked reads the server's Finished message, and the session
+// ticket before it, of a handshake which returned early for False Start.
+// c.in must be locked.
+func (c *Conn) finishFalseStartLocked() error {
+	hs := c.falseStart
+	c.falseStart = nil
+
+	err := hs.readSessionTicket()
+	if err == nil {
+		err = hs.readFinished(c.serverFinished[:])
+	}
+	if err == nil {
+		err = hs.saveSessionTicket()
+	}
+	if err != nil {
+		// The connection can't be trusted anymore, so fail any further
+		// reads with the same error.
+		c.in.setErrorLocked(err)
+	}
+	return err
+}
+
 func (hs *clientHandshakeState) pickCipherSuite() error {
 	if hs.suite = mutualCipherSuite(hs.hello.cipherSuites, hs.serverHello.cipherSuite); hs.suite == nil {
 		hs.c.sendAlert(alertHandshakeFailure)
@@ -626,70 +810,96 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 	if err != nil {
 		return err
 	}
-	certMsg, ok := msg.(*certificateMsg)
-	if !ok || len(certMsg.certificates) == 0 {
-		c.sendAlert(alertUnexpectedMessage)
-		return unexpectedMessageError(certMsg, msg)
-	}
-
-	msg, err = c.readHandshake(&hs.finishedHash)
-	if err != nil {
-		return err
-	}
-
-	cs, ok := msg.(*certificateStatusMsg)
-	if ok {
-		// RFC4366 on Certificate Status Request:
-		// The server MAY return a "certificate_status" message.
-
-		if !hs.serverHello.ocspStapling {
-			// If a server returns a "CertificateStatus" message, then the
-			// server MUST have included an extension of type "status_request"
-			// with empty "extension_data" in the extended server hello.
 
+	// PSK cipher suites authenticate the server with the PSK instead of a
+	// certificate. See RFC 4279, Section 2.
+	usingPSK := hs.suite.flags&suitePSK != 0
+	var serverCert *x509.Certificate
+	if !usingPSK {
+		certMsg, ok := msg.(*certificateMsg)
+		if !ok || len(certMsg.certificates) == 0 {
 			c.sendAlert(alertUnexpectedMessage)
-			return errors.New("tls: received unexpected CertificateStatus message")
+			return unexpectedMessageError(certMsg, msg)
 		}
 
-		c.ocspResponse = cs.response
-
 		msg, err = c.readHandshake(&hs.finishedHash)
 		if err != nil {
 			return err
 		}
-	}
 
-	if c.handshakes == 0 {
-		// If this is the first handshake on a connection, process and
-		// (optionally) verify the server's certificates.
-		if err := c.verifyServerCertificate(certMsg.certificates); err != nil {
-			return err
+		cs, ok := msg.(*certificateStatusMsg)
+		if ok {
+			// RFC4366 on Certificate Status Request:
+			// The server MAY return a "certificate_status" message.
+
+			if !hs.serverHello.ocspStapling {
+				// If a server returns a "CertificateStatus" message, then the
+				// server MUST have included an extension of type "status_request"
+				// with empty "extension_data" in the extended server hello.
+
+				c.sendAlert(alertUnexpectedMessage)
+				return errors.New("tls: received unexpected CertificateStatus message")
+			}
+
+			c.ocspResponse = cs.response
+
+			msg, err = c.readHandshake(&hs.finishedHash)
+			if err != nil {
+				return err
+			}
 		}
-	} else {
-		// This is a renegotiation handshake. We require that the
-		// server's identity (i.e. leaf certificate) is unchanged and
-		// thus any previous trust decision is still valid.
-		//
-		// See https://mitls.org/pages/attacks/3SHAKE for the
-		// motivation behind this requirement.
-		if !bytes.Equal(c.peerCertificates[0].Raw, certMsg.certificates[0]) {
-			c.sendAlert(alertBadCertificate)
-			return errors.New("tls: server's identity changed during renegotiation")
+
+		if c.handshakes == 0 {
+			// If this is the first handshake on a connection, process and
+			// (optionally) verify the server's certificates.
+			if err := c.verifyServerCertificate(hs.ctx, certMsg.certificates); err != nil {
+				return err
+			}
+		} else {
+			// This is a renegotiation handshake. We require that the
+			// server's identity (i.e. leaf certificate) is unchanged and
+			// thus any previous trust decision is still valid.
+			//
+			// See https://mitls.org/pages/attacks/3SHAKE for the
+			// motivation behind this requirement.
+			if len(c.peerCertificates) == 0 || !bytes.Equal(c.peerCertificates[0].Raw, certMsg.certificates[0]) {
+				c.sendAlert(alertBadCertificate)
+				return errors.New("tls: server's identity changed during renegotiation")
+			}
 		}
+		serverCert = c.peerCertificates[0]
+	} else if c.handshakes > 0 && len(c.peerCertificates) > 0 {
+		c.sendAlert(alertHandshakeFailure)
+		return errors.New("tls: server's identity changed during renegotiation")
 	}
 
 	keyAgreement := hs.suite.ka(c.vers)
+	if keyAgreement, ok := keyAgreement.(*tlcpECCKeyAgreement); ok {
+		if len(c.peerCertificates) < 2 {
+			c.sendAlert(alertBadCertificate)
+			return errors.New("tls: TLCP server sent no encryption certificate")
+		}
+		keyAgreement.encCert = c.peerCertificates[1]
+	}
 
 	skx, ok := msg.(*serverKeyExchangeMsg)
 	if ok {
-		err = keyAgreement.processServerKeyExchange(c.config, hs.hello, hs.serverHello, c.peerCertificates[0], skx)
+		err = keyAgreement.processServerKeyExchange(c.config, hs.hello, hs.serverHello, serverCert, skx)
 		if err != nil {
 			c.sendAlert(alertIllegalParameter)
 			return err
 		}
-		if keyAgreement, ok := keyAgreement.(*ecdheKeyAgreement); ok {
+		switch keyAgreement := keyAgreement.(type) {
+		case *ecdheKeyAgreement:
 			c.curveID = keyAgreement.curveID
 			c.peerSigAlg = keyAgreement.signatureAlgorithm
+		case *dheKeyAgreement:
+			c.curveID = keyAgreement.curveID
+			c.peerSigAlg = keyAgreement.signatureAlgorithm
+		case *pskKeyAgreement:
+			if keyAgreement.ecdhe != nil {
+				c.curveID = keyAgreement.ecdhe.curveID
+			}
 		}
 
 		msg, err = c.readHandshake(&hs.finishedHash)
@@ -701,7 +911,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 	var chainToSend *Certificate
 	var certRequested bool
 	certReq, ok := msg.(*certificateRequestMsg)
-	if ok {
+	if ok && !usingPSK {
 		certRequested = true
 
 		cri := certificateRequestInfoFromMsg(hs.ctx, c.vers, certReq)
@@ -726,18 +936,34 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 	// Certificate message, even if it's empty because we don't have a
 	// certificate to send.
 	if certRequested {
-		certMsg = new(certificateMsg)
+		certMsg := new(certificateMsg)
 		certMsg.certificates = chainToSend.Certificate
 		if _, err := hs.c.writeHandshakeRecord(certMsg, &hs.finishedHash); err != nil {
 			return err
 		}
 	}
 
-	preMasterSecret, ckx, err := keyAgreement.generateClientKeyExchange(c.config, hs.hello, c.peerCertificates[0])
+	preMasterSecret, ckx, err := keyAgreement.generateClientKeyExchange(c.config, hs.hello, serverCert)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
+	if keyAgreement, ok := keyAgreement.(*pskKeyAgreement); ok {
+		if c.handshakes > 0 {
+			if !bytes.Equal(c.externalPSKIdentity, keyAgreement.identity) {
+				c.sendAlert(alertHandshakeFailure)
+				return errors.New("tls: PSK identity changed during renegotiation")
+			}
+		} else {
+			c.externalPSKIdentity = keyAgreement.identity
+			if c.config.VerifyConnection != nil {
+				if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
+					c.sendAlert(alertBadCertificate)
+					return c.callbackError(err)
+				}
+			}
+		}
+	}
 	if ckx != nil {
 		if _, err := hs.c.writeHandshakeRecord(ckx, &hs.finishedHash); err != nil {
 			return err
@@ -752,7 +978,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		hs.masterSecret = masterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret,
 			hs.hello.random, hs.serverHello.random)
 	}
-	if err := c.config.writeKeyLog(keyLogLabelTLS12, hs.hello.random, hs.masterSecret); err != nil {
+	if err := c.writeKeyLog(keyLogLabelTLS12, hs.hello.random, hs.masterSecret); err != nil {
 		c.sendAlert(alertInternalError)
 		return errors.New("tls: failed to write to key log: " + err.Error())
 	}
@@ -767,7 +993,12 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		}
 
 		if c.vers >= VersionTLS12 {
-			signatureAlgorithm, err := selectSignatureScheme(c.vers, chainToSend, certReq.supportedSignatureAlgorithms)
+			peerAlgs, err := c.config.peerSignatureAlgorithms(certReq.supportedSignatureAlgorithms)
+			if err != nil {
+				c.sendAlert(alertHandshakeFailure)
+				return err
+			}
+			signatureAlgorithm, err := selectSignatureScheme(c.vers, chainToSend, peerAlgs)
 			if err != nil {
 				c.sendAlert(alertHandshakeFailure)
 				return err
@@ -786,7 +1017,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 			if sigType == signatureRSAPSS {
 				signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
 			}
-			certVerify.signature, err = cryptoSignMessage(key, c.config.rand(), hs.finishedHash.buffer, signOpts)
+			certVerify.signature, err = signContext(hs.ctx, key, c.config.rand(), hs.finishedHash.buffer, signOpts)
 			if err != nil {
 				c.sendAlert(alertInternalError)
 				return err
@@ -797,6 +1028,10 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 				c.sendAlert(alertIllegalParameter)
 				return err
 			}
+			if sigType == signaturePKCS1v15 && c.config.RequirePSS {
+				c.sendAlert(alertHandshakeFailure)
+				return errPKCS1v15Disabled
+			}
 			signed := hs.finishedHash.hashForClientCertificate(sigType)
 			certVerify.signature, err = key.Sign(c.config.rand(), signed, sigHash)
 			if err != nil {
@@ -828,12 +1063,16 @@ func (hs *clientHandshakeState) establishKeys() error {
 		serverCipher = hs.suite.cipher(serverKey, serverIV, true /* for reading */)
 		serverHash = hs.suite.mac(serverMAC)
 	} else {
-		clientCipher = hs.suite.aead(clientKey, clientIV)
-		serverCipher = hs.suite.aead(serverKey, serverIV)
+		clientCipher = newRecordAEAD(c.aeadEngine(), c.vers, hs.suite.id, clientKey, clientIV, hs.suite.aead)
+		serverCipher = newRecordAEAD(c.aeadEngine(), c.vers, hs.suite.id, serverKey, serverIV, hs.suite.aead)
 	}
 
 	c.in.prepareCipherSpec(c.vers, serverCipher, serverHash)
 	c.out.prepareCipherSpec(c.vers, clientCipher, clientHash)
+	c.in.nextEncryptThenMAC = hs.serverHello.encryptThenMAC
+	c.out.nextEncryptThenMAC = hs.serverHello.encryptThenMAC
+	c.in.nextKey, c.in.nextIV = serverKey, serverIV
+	c.out.nextKey, c.out.nextIV = clientKey, clientIV
 	return nil
 }
 
@@ -856,6 +1095,31 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 		return false, errors.New("tls: server selected unsupported compression format")
 	}
 
+	if c.config.fipsMode() && !hs.serverHello.extendedMasterSecret {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errEMSRequiredFIPS
+	}
+
+	if hs.serverHello.encryptThenMAC && (!hs.hello.encryptThenMAC || !hs.suite.isCBC()) {
+		c.sendAlert(alertUnsupportedExtension)
+		return false, errors.New("tls: server sent an unrequested encrypt_then_mac extension")
+	}
+	// RFC 7366, Section 3.1
+	if c.handshakes > 0 && c.in.encryptThenMAC && !hs.serverHello.encryptThenMAC {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server disabled encrypt-then-MAC during renegotiation")
+	}
+
+	if hs.serverHello.recordSizeLimit != 0 {
+		if hs.hello.recordSizeLimit == 0 {
+			c.sendAlert(alertUnsupportedExtension)
+			return false, errors.New("tls: server sent an unrequested record_size_limit extension")
+		}
+		if err := c.setRecordSizeLimits(hs.hello.recordSizeLimit, hs.serverHello.recordSizeLimit); err != nil {
+			return false, err
+		}
+	}
+
 	supportsPointFormat := false
 	offeredNonCompressedFormat := false
 	for _, format := range hs.serverHello.supportedPoints {
@@ -915,6 +1179,12 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 		return false, errors.New("tls: server resumed a session with a different EMS extension")
 	}
 
+	// RFC 7366, Section 3.1
+	if hs.session.encryptThenMAC && !hs.serverHello.encryptThenMAC {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server resumed a session without its encrypt-then-MAC")
+	}
+
 	// Restore master secret and certificates from previous state
 	hs.masterSecret = hs.session.secret
 	c.extMasterSecret = hs.session.extMasterSecret
@@ -1013,7 +1283,9 @@ func (hs *clientHandshakeState) readSessionTicket() error {
 }
 
 func (hs *clientHandshakeState) saveSessionTicket() error {
-	if hs.ticket == nil {
+	// Sessions established with a PSK cipher suite don't record the PSK
+	// identity, so they are not resumed.
+	if hs.ticket == nil || hs.suite.flags&suitePSK != 0 {
 		return nil
 	}
 	c := hs.c
@@ -1025,7 +1297,9 @@ func (hs *clientHandshakeState) saveSessionTicket() error {
 
 	session := c.sessionState()
 	session.secret = hs.masterSecret
+	session.encryptThenMAC = hs.serverHello.encryptThenMAC
 	session.ticket = hs.ticket
+	c.sessionEvent(SessionTicketReceived, session)
 
 	cs := &ClientSessionState{session: session}
 	c.config.ClientSessionCache.Put(cacheKey, cs)
@@ -1058,94 +1332,196 @@ func checkKeySize(n int) (max int, ok bool) {
 
 // verifyServerCertificate parses and verifies the provided chain, setting
 // c.verifiedChains and c.peerCertificates or sending the appropriate alert.
-func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
+func (c *Conn) verifyServerCertificate(ctx context.Context, certificates [][]byte) error {
+	v, err := c.startServerCertificateVerification(ctx, certificates, false)
+	if err != nil {
+		return err
+	}
+	return v.finish()
+}
+
+// serverCertificateVerification is the verification of the certificates
+// sent by the server. Its chain verification, by x509.Certificate.Verify,
+// Config.VerifyCertificateChains or Config.TLSARecords, may run in a separate
+// goroutine until finish is called. The candidate chains are still built and
+// verified one after the other, within that goroutine.
+type serverCertificateVerification struct {
+	c             *Conn
+	ctx           context.Context
+	certificates  [][]byte
+	activeHandles []*activeCert
+	certs         []*x509.Certificate
+	echRejected   bool
+
+	// done is closed once chains and err are set by the chain verification,
+	// or is nil if the chain isn't verified.
+	done   chan struct{}
+	chains [][]*x509.Certificate
+	err    error
+}
+
+// startServerCertificateVerification parses the certificates sent by the
+// server and starts verifying their chain, in a separate goroutine if async
+// is true. Everything else, such as VerifyPeerCertificate, runs in finish,
+// and the leaf certificate must not be trusted until it returns.
+func (c *Conn) startServerCertificateVerification(ctx context.Context, certificates [][]byte, async bool) (*serverCertificateVerification, error) {
+	c.trace.certificatesReceived(certificates)
 	activeHandles := make([]*activeCert, len(certificates))
 	certs := make([]*x509.Certificate, len(certificates))
 	for i, asn1Data := range certificates {
 		cert, err := globalCertCache.newCert(asn1Data)
 		if err != nil {
 			c.sendAlert(alertDecodeError)
-			return errors.New("tls: failed to parse certificate from server: " + err.Error())
+			return nil, errors.New("tls: failed to parse certificate from server: " + err.Error())
 		}
+		activeHandles[i] = cert
+		certs[i] = cert.cert
 		if cert.cert.PublicKeyAlgorithm == x509.RSA {
 			n := cert.cert.PublicKey.(*rsa.PublicKey).N.BitLen()
 			if max, ok := checkKeySize(n); !ok {
 				c.sendAlert(alertBadCertificate)
-				return fmt.Errorf("tls: server sent certificate containing RSA key larger than %d bits", max)
+				return nil, fmt.Errorf("tls: server sent certificate containing RSA key larger than %d bits", max)
 			}
 		}
-		activeHandles[i] = cert
-		certs[i] = cert.cert
+		if err := c.config.checkPeerKeySize(cert.cert.PublicKey); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return nil, &CertificateVerificationError{
+				UnverifiedCertificates: certs[:i+1],
+				Err:                    errors.New("server sent certificate containing " + err.Error()),
+			}
+		}
+	}
+
+	v := &serverCertificateVerification{
+		c:             c,
+		ctx:           ctx,
+		certificates:  certificates,
+		activeHandles: activeHandles,
+		certs:         certs,
+		echRejected:   c.config.EncryptedClientHelloConfigList != nil && !c.echAccepted,
 	}
 
-	echRejected := c.config.EncryptedClientHelloConfigList != nil && !c.echAccepted
-	if echRejected {
+	var opts x509.VerifyOptions
+	if v.echRejected {
 		if c.config.EncryptedClientHelloRejectionVerify != nil {
 			if err := c.config.EncryptedClientHelloRejectionVerify(c.connectionStateLocked()); err != nil {
 				c.sendAlert(alertBadCertificate)
-				return err
-			}
-		} else {
-			opts := x509.VerifyOptions{
-				Roots:         c.config.RootCAs,
-				CurrentTime:   c.config.time(),
-				DNSName:       c.serverName,
-				Intermediates: x509.NewCertPool(),
+				return nil, c.callbackError(err)
 			}
-
-			for _, cert := range certs[1:] {
-				opts.Intermediates.AddCert(cert)
-			}
-			chains, err := certs[0].Verify(opts)
-			if err != nil {
-				c.sendAlert(alertBadCertificate)
-				return &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
-			}
-
-			c.verifiedChains = chains
+			return v, nil
+		}
+		opts = x509.VerifyOptions{
+			Roots:         c.config.RootCAs,
+			CurrentTime:   c.config.time(),
+			DNSName:       c.serverName,
+			Intermediates: x509.NewCertPool(),
 		}
 	} else if !c.config.InsecureSkipVerify {
-		opts := x509.VerifyOptions{
+		// Clients that only set ExternalPSKs can't verify certificates.
+		if c.config.ServerName == "" {
+			c.sendAlert(alertHandshakeFailure)
+			return nil, errors.New("tls: server did not use an external PSK, and ServerName is not set to verify its certificate")
+		}
+		opts = x509.VerifyOptions{
 			Roots:         c.config.RootCAs,
 			CurrentTime:   c.config.time(),
 			DNSName:       c.config.ServerName,
 			Intermediates: x509.NewCertPool(),
 		}
+	} else {
+		return v, nil
+	}
 
-		for _, cert := range certs[1:] {
-			opts.Intermediates.AddCert(cert)
-		}
-		chains, err := certs[0].Verify(opts)
-		if err != nil {
-			c.sendAlert(alertBadCertificate)
-			return &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	v.done = make(chan struct{})
+	verify := func() {
+		if len(c.config.TLSARecords) > 0 && !v.echRejected {
+			v.chains, v.err = c.config.verifyTLSA(v.ctx, certificates, certs, opts)
+		} else {
+			v.chains, v.err = c.config.verifyCertificateChains(v.ctx, certificates, certs, opts)
 		}
+		close(v.done)
+	}
+	if async {
+		go verify()
+	} else {
+		verify()
+	}
+	return v, nil
+}
 
-		c.verifiedChains = chains
+// finish waits for the chain verification, and completes the verification
+// of the server certificates.
+func (v *serverCertificateVerification) finish() (err error) {
+	c, certs := v.c, v.certs
+	defer func() { c.trace.verificationDone(err) }()
+	consultVerifiers := len(c.config.Verifiers) > 0 && !v.echRejected
+	var verifyErr error
+	if v.done != nil {
+		<-v.done
+		if v.err != nil {
+			verifyErr = &CertificateVerificationError{UnverifiedCertificates: certs, Err: v.err}
+			if !consultVerifiers {
+				c.sendAlert(alertBadCertificate)
+				return verifyErr
+			}
+		} else {
+			c.verifiedChains = v.chains
+			if err := c.checkRevocation(v.ctx, c.verifiedChains); err != nil {
+				return err
+			}
+		}
 	}
 
 	switch certs[0].PublicKey.(type) {
-	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, ed448.PublicKey:
 		break
 	default:
 		c.sendAlert(alertUnsupportedCertificate)
 		return fmt.Errorf("tls: server's certificate contains an unsupported type of public key: %T", certs[0].PublicKey)
 	}
 
-	c.activeCertHandles = activeHandles
+	c.activeCertHandles = v.activeHandles
 	c.peerCertificates = certs
 
-	if c.config.VerifyPeerCertificate != nil && !echRejected {
-		if err := c.config.VerifyPeerCertificate(certificates, c.verifiedChains); err != nil {
-			c.sendAlert(alertBadCertificate)
+	if !v.echRejected {
+		if err := c.checkPinnedKeys(certs, c.verifiedChains); err != nil {
+			return err
+		}
+	}
+
+	// Without CTLogs, no SCT verifies, and RequireCT rejects the server.
+	if (len(c.config.CTLogs) > 0 || c.config.RequireCT) && !v.echRejected {
+		var issuer *x509.Certificate
+		if len(c.verifiedChains) > 0 && len(c.verifiedChains[0]) > 1 {
+			issuer = c.verifiedChains[0][1]
+		} else if len(certs) > 1 {
+			issuer = certs[1]
+		}
+		if err := c.verifySCTs(certs[0], issuer); err != nil {
 			return err
 		}
 	}
 
-	if c.config.VerifyConnection != nil && !echRejected {
+	if consultVerifiers {
+		if err := c.consultVerifiers(v.ctx, v.certificates, verifyErr, alertBadCertificate); err != nil {
+			return err
+		}
+	}
+
+	if c.config.VerifyPeerCertificate != nil && !v.echRejected {
+		if err := c.config.VerifyPeerCertificate(v.certificates, c.verifiedChains); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return c.callbackError(err)
+		}
+	}
+
+	if c.config.VerifyConnection != nil && !v.echRejected {
 		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
 			c.sendAlert(alertBadCertificate)
-			return err
+			return c.callbackError(err)
 		}
 	}
 
@@ -1204,7 +1580,7 @@ func certificateRequestInfoFromMsg(ctx context.Context, vers uint16, certReq *ce
 			continue
 		}
 		switch sigType {
-		case signatureECDSA, signatureEd25519:
+		case signatureECDSA, signatureEd25519, signatureEd448:
 			if ecAvail {
 				cri.SignatureSchemes = append(cri.SignatureSchemes, sigScheme)
 			}
@@ -1220,7 +1596,8 @@ func certificateRequestInfoFromMsg(ctx context.Context, vers uint16, certReq *ce
 
 func (c *Conn) getClientCertificate(cri *CertificateRequestInfo) (*Certificate, error) {
 	if c.config.GetClientCertificate != nil {
-		return c.config.GetClientCertificate(cri)
+		cert, err := c.config.GetClientCertificate(cri)
+		return cert, c.callbackError(err)
 	}
 
 	for _, chain := range c.config.Certificates {
@@ -1246,6 +1623,15 @@ func (c *Conn) clientSessionCacheKey() string {
 	return ""
 }
 
+// sentServerName returns the name clients send in the server_name extension,
+// before hostnameInSNI.
+func (c *Config) sentServerName() string {
+	if c.OmitServerName {
+		return ""
+	}
+	return cmpOr(c.SendServerName, c.ServerName)
+}
+
 // hostnameInSNI converts name into an appropriate hostname for SNI.
 // Literal IP addresses and absolute FQDNs are not permitted as SNI values.
 // See RFC 6066, Section 3.
@@ -29,6 +29,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -894,14 +895,14 @@ func testResumption(t *testing.T, version uint16) {
 	}
 
 	getTicket := func() []byte {
-		return clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).state.session.ticket
+		return clientConfig.ClientSessionCache.(*ShardedClientSessionCache).shards[0].q.Front().Value.(*lruSessionCacheEntry).state.session.ticket
 	}
 	deleteTicket := func() {
-		ticketKey := clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).sessionKey
+		ticketKey := clientConfig.ClientSessionCache.(*ShardedClientSessionCache).shards[0].q.Front().Value.(*lruSessionCacheEntry).sessionKey
 		clientConfig.ClientSessionCache.Put(ticketKey, nil)
 	}
 	corruptTicket := func() {
-		clientConfig.ClientSessionCache.(*lruSessionCache).q.Front().Value.(*lruSessionCacheEntry).state.session.secret[0] ^= 0xff
+		clientConfig.ClientSessionCache.(*ShardedClientSessionCache).shards[0].q.Front().Value.(*lruSessionCacheEntry).state.session.secret[0] ^= 0xff
 	}
 	randomKey := func() [32]byte {
 		var k [32]byte
@@ -1155,6 +1156,118 @@ func TestLRUClientSessionCache(t *testing.T) {
 	}
 }
 
+func TestShardedClientSessionCache(t *testing.T) {
+	cache := NewShardedClientSessionCache(ClientSessionCacheOptions{
+		Capacity: 100,
+		Shards:   3,
+		TTL:      time.Minute,
+	})
+	if len(cache.shards) != 4 {
+		t.Fatalf("got %d shards, want 4", len(cache.shards))
+	}
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	cs := make([]ClientSessionState, 1000)
+	for i := range cs {
+		cache.Put(strconv.Itoa(i), &cs[i])
+	}
+	stats := cache.Stats()
+	if stats.Len != 100 || stats.Evictions != 900 {
+		t.Errorf("after 1000 Puts: got %+v, want 100 sessions and 900 evictions", stats)
+	}
+	if s, ok := cache.Get("999"); !ok || s != &cs[999] {
+		t.Errorf("session cache failed lookup for the last added key")
+	}
+	if _, ok := cache.Get("0"); ok {
+		t.Errorf("session cache should have evicted the first added key")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get("999"); ok {
+		t.Errorf("session cache returned an expired session")
+	}
+	stats = cache.Stats()
+	if stats.Len != 99 || stats.Hits != 1 || stats.Misses != 2 || stats.Expirations != 1 {
+		t.Errorf("after expiration: got %+v", stats)
+	}
+
+	// Concurrent use, for the race detector.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := strconv.Itoa(i*100 + j)
+				cache.Put(key, &cs[j])
+				cache.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if stats := cache.Stats(); stats.Len > 100 {
+		t.Errorf("session cache grew to %d sessions, over its capacity", stats.Len)
+	}
+}
+
+// countingSessionCache counts the sessions put in a ClientSessionCache.
+type countingSessionCache struct {
+	ClientSessionCache
+	puts int
+}
+
+func (c *countingSessionCache) Put(sessionKey string, cs *ClientSessionState) {
+	c.puts++
+	c.ClientSessionCache.Put(sessionKey, cs)
+}
+
+func TestCoalescedSessionTickets(t *testing.T) {
+	const tickets = 8
+	serverConfig := testConfig.Clone()
+	serverConfig.SessionTicketCount = tickets
+
+	var received int
+	cache := &countingSessionCache{ClientSessionCache: NewLRUClientSessionCache(1)}
+	clientConfig := testConfig.Clone()
+	clientConfig.ClientSessionCache = cache
+	clientConfig.SessionEvent = func(e SessionEvent) {
+		if e.Kind == SessionTicketReceived {
+			received++
+		}
+	}
+
+	c, s := localPipe(t)
+	defer c.Close()
+	sent := make(chan error, 1)
+	go func() {
+		defer s.Close()
+		srv := Server(s, serverConfig)
+		_, err := srv.Write([]byte("hello"))
+		sent <- err
+		srv.Read(make([]byte, 1))
+	}()
+	cli := Client(c, clientConfig)
+	if err := cli.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// Let all the tickets arrive before reading them.
+	if err := <-sent; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(cli, buf); err != nil {
+		t.Fatal(err)
+	}
+	if received != tickets {
+		t.Errorf("received %d session tickets, want %d", received, tickets)
+	}
+	if cache.puts != 1 {
+		t.Errorf("stored %d sessions in the cache, want only the last one", cache.puts)
+	}
+}
+
 func TestKeyLogTLS12(t *testing.T) {
 	var serverBuf, clientBuf bytes.Buffer
 
@@ -1249,6 +1362,151 @@ func TestKeyLogTLS13(t *testing.T) {
 	checkKeylogLines("server", serverBuf.String())
 }
 
+func TestKeyLogger(t *testing.T) {
+	var configLabels, connLabels []string
+	var lines bytes.Buffer
+	clientConfig := testConfig.Clone()
+	clientConfig.KeyLogWriter = &lines
+	clientConfig.KeyLogger = KeyLoggerFunc(func(label string, _, _ []byte) {
+		configLabels = append(configLabels, label)
+	})
+	serverConfig := testConfig.Clone()
+	serverConfig.KeyLogger = clientConfig.KeyLogger
+
+	c, s := localPipe(t)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		if err := Server(s, serverConfig).Handshake(); err != nil {
+			t.Errorf("server: %s", err)
+		}
+		s.Close()
+	}()
+	client := Client(c, clientConfig)
+	client.SetKeyLogger(KeyLoggerFunc(func(label string, _, _ []byte) {
+		connLabels = append(connLabels, label)
+	}))
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("client: %s", err)
+	}
+	c.Close()
+	<-done
+
+	want := []string{keyLogLabelClientHandshake, keyLogLabelServerHandshake, keyLogLabelClientTraffic, keyLogLabelServerTraffic}
+	if !reflect.DeepEqual(connLabels, want) {
+		t.Errorf("client logged %v, want %v", connLabels, want)
+	}
+	// Only the server used the KeyLogger of the Config.
+	if !reflect.DeepEqual(configLabels, want) {
+		t.Errorf("server logged %v, want %v", configLabels, want)
+	}
+	if n := strings.Count(lines.String(), "\n"); n != 4 {
+		t.Errorf("client wrote %d key log lines, want 4", n)
+	}
+}
+
+func TestRetainHandshakeMessages(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.RetainHandshakeMessages = true
+
+	c, s := localPipe(t)
+	server := Server(s, testConfig)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		if err := server.Handshake(); err != nil {
+			t.Errorf("server: %s", err)
+		}
+		s.Close()
+	}()
+	client := Client(c, clientConfig)
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("client: %s", err)
+	}
+	c.Close()
+	<-done
+
+	if msgs := server.HandshakeMessages(); msgs != nil {
+		t.Errorf("server retained %d messages", len(msgs))
+	}
+	msgs := client.HandshakeMessages()
+	var types []uint8
+	for i, m := range msgs {
+		if m.Sent != (i == 0 || i == len(msgs)-1) {
+			t.Errorf("message of type %d sent: %v", m.Type, m.Sent)
+		}
+		if len(m.Raw) < 4 || m.Raw[0] != m.Type || int(m.Raw[1])<<16|int(m.Raw[2])<<8|int(m.Raw[3]) != len(m.Raw)-4 {
+			t.Errorf("message of type %d is %x", m.Type, m.Raw)
+		}
+		types = append(types, m.Type)
+	}
+	want := []uint8{typeClientHello, typeServerHello, typeEncryptedExtensions, typeCertificate, typeCertificateVerify, typeFinished, typeFinished}
+	if !bytes.Equal(types, want) {
+		t.Fatalf("retained messages of types %v, want %v", types, want)
+	}
+	if hello, ok := msgs[0].Parsed.(*clientHelloMsg); !ok || hello.serverName != clientConfig.ServerName {
+		t.Errorf("parsed ClientHello: %+v", msgs[0].Parsed)
+	}
+}
+
+func TestWriteEarlyData(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+	defer s.Close()
+
+	if _, err := Server(s, testConfig).WriteEarlyData([]byte("x")); err == nil {
+		t.Error("WriteEarlyData on a server connection succeeded")
+	}
+	if _, err := Client(c, testConfig).WriteEarlyData([]byte("x")); err == nil {
+		t.Error("WriteEarlyData without Config.EarlyData succeeded")
+	}
+
+	clientConfig := testConfig.Clone()
+	clientConfig.EarlyData = true
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	serverConfig := testConfig.Clone()
+
+	// The server doesn't allow early data in its tickets, so the queued data
+	// must not be offered, even when resuming.
+	for i := 0; i < 2; i++ {
+		c, s := localPipe(t)
+		done := make(chan error, 1)
+		go func() {
+			srv := Server(s, serverConfig)
+			if err := srv.Handshake(); err != nil {
+				done <- err
+				return
+			}
+			_, err := srv.Write([]byte("hello"))
+			done <- err
+		}()
+
+		cli := Client(c, clientConfig)
+		if _, err := cli.WriteEarlyData([]byte("early")); err != nil {
+			t.Fatalf("WriteEarlyData: %v", err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(cli, buf); err != nil {
+			t.Fatalf("client: %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("server: %v", err)
+		}
+		state := cli.ConnectionState()
+		if state.DidResume != (i == 1) {
+			t.Errorf("#%d: DidResume = %v", i, state.DidResume)
+		}
+		if state.EarlyDataAccepted {
+			t.Errorf("#%d: early data unexpectedly accepted", i)
+		}
+		if _, err := cli.WriteEarlyData([]byte("x")); err == nil {
+			t.Errorf("#%d: WriteEarlyData after the handshake succeeded", i)
+		}
+		cli.Close()
+		s.Close()
+	}
+}
+
 func TestHandshakeClientALPNMatch(t *testing.T) {
 	config := testConfig.Clone()
 	config.NextProtos = []string{"proto2", "proto1"}
@@ -1791,6 +2049,41 @@ func testVerifyConnection(t *testing.T, version uint16) {
 	}
 }
 
+func TestServerCertificateErrorPrecedence(t *testing.T) {
+	issuer, err := x509.ParseCertificate(testRSA2048CertificateIssuer)
+	if err != nil {
+		panic(err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(issuer)
+
+	// The server signs with a key that doesn't match its certificate.
+	serverConfig := testConfig.Clone()
+	serverConfig.MinVersion = VersionTLS13
+	serverConfig.Certificates = []Certificate{{
+		Certificate: [][]byte{testRSA2048Certificate},
+		PrivateKey:  testRSAPrivateKey,
+	}}
+	clientConfig := testConfig.Clone()
+	clientConfig.InsecureSkipVerify = false
+	clientConfig.ServerName = "example.golang"
+	clientConfig.Time = testTime
+
+	clientConfig.RootCAs = rootCAs
+	_, _, err = testHandshake(t, clientConfig, serverConfig)
+	if err == nil || !strings.Contains(err.Error(), "invalid signature by the server certificate") {
+		t.Errorf("trusted certificate with a bad signature: got %v", err)
+	}
+
+	// An untrusted certificate is reported as such, even though the
+	// signature is checked while its chain is verified.
+	clientConfig.RootCAs = x509.NewCertPool()
+	_, _, err = testHandshake(t, clientConfig, serverConfig)
+	if err == nil || !strings.Contains(err.Error(), "certificate signed by unknown authority") {
+		t.Errorf("untrusted certificate with a bad signature: got %v", err)
+	}
+}
+
 func TestVerifyPeerCertificate(t *testing.T) {
 	t.Run("TLSv12", func(t *testing.T) { testVerifyPeerCertificate(t, VersionTLS12) })
 	t.Run("TLSv13", func(t *testing.T) { testVerifyPeerCertificate(t, VersionTLS13) })
@@ -2186,6 +2479,104 @@ func testBuffering(t *testing.T, version uint16) {
 	}
 }
 
+// delayedWriteConn holds all but the first write until release is closed.
+type delayedWriteConn struct {
+	net.Conn
+	release   chan struct{}
+	numWrites int
+}
+
+func (c *delayedWriteConn) Write(data []byte) (int, error) {
+	if c.numWrites++; c.numWrites > 1 {
+		<-c.release
+	}
+	return c.Conn.Write(data)
+}
+
+func TestFalseStart(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		nextProtos []string
+		suite      uint16
+		want       bool
+	}{
+		{"ECDHE-AEAD-ALPN", []string{"h2"}, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, true},
+		{"NoALPN", nil, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, false},
+		{"CBC", []string{"h2"}, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, false},
+		{"RSAKeyExchange", []string{"h2"}, TLS_RSA_WITH_AES_128_GCM_SHA256, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testFalseStart(t, tt.nextProtos, tt.suite, tt.want)
+		})
+	}
+}
+
+func testFalseStart(t *testing.T, nextProtos []string, suite uint16, want bool) {
+	c, s := localPipe(t)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Minute))
+
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = VersionTLS12
+	serverConfig.CipherSuites = []uint16{suite}
+	serverConfig.NextProtos = nextProtos
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	clientConfig.CipherSuites = []uint16{suite}
+	clientConfig.NextProtos = nextProtos
+	clientConfig.FalseStart = true
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	// With False Start, the client handshake completes while the server's
+	// Finished message is held back.
+	serverConn := &delayedWriteConn{Conn: s, release: make(chan struct{})}
+	if !want {
+		close(serverConn.release)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		defer s.Close()
+		srv := Server(serverConn, serverConfig)
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(srv, buf); err != nil {
+			errc <- err
+			return
+		}
+		_, err := srv.Write(buf)
+		errc <- err
+	}()
+
+	cli := Client(c, clientConfig)
+	if err := cli.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cli.falseStart != nil; got != want {
+		t.Fatalf("handshake returned early: %v, want %v", got, want)
+	}
+	if _, err := cli.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if want {
+		close(serverConn.release)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(cli, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("got %q, want %q", buf, "hello")
+	}
+	if cli.falseStart != nil {
+		t.Errorf("False Start handshake not finished after Read")
+	}
+	if stats := clientConfig.ClientSessionCache.(*ShardedClientSessionCache).Stats(); stats.Len != 1 {
+		t.Errorf("got %d cached sessions, want 1", stats.Len)
+	}
+}
+
 func TestAlertFlushing(t *testing.T) {
 	c, s := localPipe(t)
 	done := make(chan bool)
@@ -2783,13 +3174,13 @@ func TestHandshakeRSATooBig(t *testing.T) {
 	c := &Conn{conn: &discardConn{}, config: testConfig.Clone()}
 
 	expectedErr := "tls: server sent certificate containing RSA key larger than 8192 bits"
-	err := c.verifyServerCertificate([][]byte{testCert.Bytes})
+	err := c.verifyServerCertificate(context.Background(), [][]byte{testCert.Bytes})
 	if err == nil || err.Error() != expectedErr {
 		t.Errorf("Conn.verifyServerCertificate unexpected error: want %q, got %q", expectedErr, err)
 	}
 
 	expectedErr = "tls: client sent certificate containing RSA key larger than 8192 bits"
-	err = c.processCertsFromClient(Certificate{Certificate: [][]byte{testCert.Bytes}})
+	err = c.processCertsFromClient(context.Background(), Certificate{Certificate: [][]byte{testCert.Bytes}})
 	if err == nil || err.Error() != expectedErr {
 		t.Errorf("Conn.processCertsFromClient unexpected error: want %q, got %q", expectedErr, err)
 	}
@@ -2914,3 +3305,112 @@ func TestECHTLS12Server(t *testing.T) {
 		t.Fatalf("unexpected handshake error: got %q, want %q", err, expectedErr)
 	}
 }
+
+func TestSendServerName(t *testing.T) {
+	cert, err := x509.ParseCertificate(testRSA2048Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert)
+
+	for _, tt := range []struct {
+		name       string
+		serverName string
+		send       string
+		omit       bool
+		wantSent   string
+		wantErr    bool
+	}{
+		{"Send", "example.golang", "front.example", false, "front.example", false},
+		{"Omit", "example.golang", "front.example", true, "", false},
+		{"VerifiesServerName", "other.example", "example.golang", false, "example.golang", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent string
+			serverConfig := testConfig.Clone()
+			serverConfig.Certificates = []Certificate{{Certificate: [][]byte{testRSA2048Certificate}, PrivateKey: testRSA2048PrivateKey}}
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				sent = chi.ServerName
+				return nil, nil
+			}
+			clientConfig := testConfig.Clone()
+			clientConfig.InsecureSkipVerify = false
+			clientConfig.RootCAs = rootCAs
+			clientConfig.Time = testTime
+			clientConfig.ServerName = tt.serverName
+			clientConfig.SendServerName = tt.send
+			clientConfig.OmitServerName = tt.omit
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handshake error %v, want error: %v", err, tt.wantErr)
+			}
+			if sent != tt.wantSent {
+				t.Errorf("server got server name %q, want %q", sent, tt.wantSent)
+			}
+			if err == nil && cs.ServerName != tt.wantSent {
+				t.Errorf("ConnectionState.ServerName %q, want %q", cs.ServerName, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestHandshakeTransform(t *testing.T) {
+	const extraExtension = 0x7777
+	// addExtension appends an empty extension to a ClientHello.
+	addExtension := func(msg []byte) ([]byte, error) {
+		if msg[0] != typeClientHello {
+			return msg, nil
+		}
+		// The offset of the extensions, after the session ID, cipher
+		// suites and compression methods.
+		offset := 4 + 2 + 32
+		offset += 1 + int(msg[offset])
+		offset += 2 + int(binary.BigEndian.Uint16(msg[offset:]))
+		offset += 1 + int(msg[offset])
+		out := append(slicesClone(msg), extraExtension>>8, extraExtension&0xff, 0, 0)
+		binary.BigEndian.PutUint16(out[offset:], uint16(len(out)-offset-2))
+		bodyLen := len(out) - 4
+		out[1], out[2], out[3] = byte(bodyLen>>16), byte(bodyLen>>8), byte(bodyLen)
+		return out, nil
+	}
+
+	for _, vers := range []uint16{VersionTLS13, VersionTLS12} {
+		t.Run(fmt.Sprintf("%x", vers), func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = vers
+			clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+			clientConfig.HandshakeTransform = addExtension
+
+			var sent []uint8
+			var extended []bool
+			serverConfig := testConfig.Clone()
+			serverConfig.HandshakeTransform = func(msg []byte) ([]byte, error) {
+				sent = append(sent, msg[0])
+				return msg, nil
+			}
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				extended = append(extended, slicesContains(chi.Extensions, extraExtension))
+				return nil, nil
+			}
+
+			for i := 0; i < 2; i++ {
+				ss, _, err := testHandshake(t, clientConfig, serverConfig)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ss.DidResume != (i == 1) {
+					t.Errorf("connection %d resumed: %v", i, ss.DidResume)
+				}
+			}
+			// The TLS 1.3 ClientHello resuming a session has PSK binders.
+			if want := []bool{true, vers == VersionTLS12}; !slicesEqual(extended, want) {
+				t.Errorf("extension sent %v, want %v", extended, want)
+			}
+			if !slicesContains(sent, typeServerHello) || !slicesContains(sent, typeFinished) {
+				t.Errorf("transformed messages %v", sent)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/subtle"
+	"crypto/x509"
 	"errors"
 	"hash"
 	"time"
@@ -29,13 +30,25 @@ type clientHandshakeStateTLS13 struct {
 	earlySecret *tls13EarlySecret
 	binderKey   []byte
 
-	certReq       *certificateRequestMsgTLS13
-	usingPSK      bool
-	sentDummyCCS  bool
-	suite         *cipherSuiteTLS13
-	transcript    hash.Hash
-	masterSecret  *tls13MasterSecret
-	trafficSecret []byte // client_application_traffic_secret_0
+	// externalPSKs are the imported PSKs offered instead of a session, in
+	// the same order as hello.pskIdentities.
+	externalPSKs []*importedPSK
+
+	certReq  *certificateRequestMsgTLS13
+	usingPSK bool
+	// serverCertType and clientCertType are the types of the credentials
+	// sent by each peer, as selected by the server. See RFC 7250.
+	serverCertType CertificateType
+	clientCertType CertificateType
+	sentDummyCCS   bool
+	suite          *cipherSuiteTLS13
+	transcript     hash.Hash
+	masterSecret   *tls13MasterSecret
+	trafficSecret  []byte // client_application_traffic_secret_0
+
+	// handshakeWriteSecret is the client_handshake_traffic_secret, held back
+	// while the 0-RTT write keys are still in use over TCP.
+	handshakeWriteSecret []byte
 
 	echContext *echClientContext
 }
@@ -53,7 +66,7 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 	}
 
 	// Consistency check on the presence of a keyShare and its parameters.
-	if hs.keyShareKeys == nil || hs.keyShareKeys.ecdhe == nil || len(hs.hello.keyShares) == 0 {
+	if hs.keyShareKeys == nil || (hs.keyShareKeys.ecdhe == nil && hs.keyShareKeys.sm2 == nil) || len(hs.hello.keyShares) == 0 {
 		return c.sendAlert(alertInternalError)
 	}
 
@@ -75,6 +88,7 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 	}
 
 	if bytes.Equal(hs.serverHello.random, helloRetryRequestRandom) {
+		c.trace.helloRetryRequest()
 		if err := hs.sendDummyChangeCipherSpec(); err != nil {
 			return err
 		}
@@ -97,7 +111,7 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 		acceptConfirmation := tls13ExpandLabel(h, prk, "ech accept confirmation", confTranscript.Sum(nil), 8)
 		if subtle.ConstantTimeCompare(acceptConfirmation, hs.serverHello.random[len(hs.serverHello.random)-8:]) == 1 {
 			hs.hello = hs.echContext.innerHello
-			c.serverName = c.config.ServerName
+			c.serverName = c.config.sentServerName()
 			hs.transcript = hs.echContext.innerTranscript
 			c.echAccepted = true
 
@@ -123,6 +137,11 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 	if err := hs.processServerHello(); err != nil {
 		return err
 	}
+	if c.didResume {
+		c.sessionEvent(SessionResumed, hs.session)
+	} else if hs.session != nil {
+		c.sessionEvent(SessionResumptionRejected, hs.session)
+	}
 	if err := hs.sendDummyChangeCipherSpec(); err != nil {
 		return err
 	}
@@ -138,6 +157,9 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 	if err := hs.readServerFinished(); err != nil {
 		return err
 	}
+	if err := hs.sendEndOfEarlyData(); err != nil {
+		return err
+	}
 	if err := hs.sendClientCertificate(); err != nil {
 		return err
 	}
@@ -272,7 +294,7 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 			acceptConfirmation := tls13ExpandLabel(h, prk, "hrr ech accept confirmation", confTranscript.Sum(nil), 8)
 			if subtle.ConstantTimeCompare(acceptConfirmation, hs.serverHello.encryptedClientHello) == 1 {
 				hello = hs.echContext.innerHello
-				c.serverName = c.config.ServerName
+				c.serverName = c.config.sentServerName()
 				isInnerHello = true
 				c.echAccepted = true
 			}
@@ -330,9 +352,36 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 		}
 		// Do not send the fallback ECDH key share in a HRR response.
 		hello.keyShares = hello.keyShares[:1]
-	}
-
-	if len(hello.pskIdentities) > 0 {
+		hello.layout = retryLayout(hello.layout)
+	}
+
+	// Without a session or external PSKs, the identity of Config.CovertAuth
+	// is sent again, and signed again below.
+	if len(hello.pskIdentities) > 0 && hs.externalPSKs != nil {
+		// Only offer the PSKs imported for the hash of the selected suite.
+		var psks []*importedPSK
+		var identities []pskIdentity
+		var binders [][]byte
+		for i, psk := range hs.externalPSKs {
+			if psk.suite.hash == hs.suite.hash {
+				psks = append(psks, psk)
+				identities = append(identities, hello.pskIdentities[i])
+				binders = append(binders, hello.pskBinders[i])
+			}
+		}
+		hs.externalPSKs = psks
+		hello.pskIdentities = identities
+		hello.pskBinders = binders
+		if len(psks) > 0 {
+			if err := computeAndUpdateImportedPSKs(hello, psks, func(transcript hash.Hash) error {
+				transcript.Write([]byte{typeMessageHash, 0, 0, uint8(len(chHash))})
+				transcript.Write(chHash)
+				return transcriptMsg(hs.serverHello, transcript)
+			}); err != nil {
+				return err
+			}
+		}
+	} else if len(hello.pskIdentities) > 0 && hs.session != nil {
 		pskSuite := cipherSuiteTLS13ByID(hs.session.cipherSuite)
 		if pskSuite == nil {
 			return c.sendAlert(alertInternalError)
@@ -359,9 +408,22 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 		}
 	}
 
-	if hello.earlyData {
+	if hello.earlyData || hs.hello.earlyData {
+		// The second ClientHello must not offer early data, and the first
+		// flight of 0-RTT data is implicitly rejected.
 		hello.earlyData = false
-		c.quicRejectedEarlyData()
+		hs.hello.earlyData = false
+		if c.quic != nil {
+			c.quicRejectedEarlyData()
+		} else {
+			c.out.discardTrafficSecret()
+		}
+	}
+
+	if c.config.CovertAuth != nil && len(covertIdentity(hello)) > 0 {
+		if err := c.signCovertAuth(hello); err != nil {
+			return err
+		}
 	}
 
 	if isInnerHello {
@@ -385,6 +447,7 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, hs.transcript); err != nil {
 		return err
 	}
+	c.trace.helloSent()
 
 	// serverHelloMsg is not included in the transcript
 	msg, err := c.readHandshake(nil)
@@ -397,7 +460,9 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 		c.sendAlert(alertUnexpectedMessage)
 		return unexpectedMessageError(serverHello, msg)
 	}
+	c.trace.helloReceived()
 	hs.serverHello = serverHello
+	c.peerHelloExtensions = serverHello.extensions
 
 	if err := hs.checkServerHelloOrHRR(); err != nil {
 		return err
@@ -426,10 +491,13 @@ func (hs *clientHandshakeStateTLS13) processServerHello() error {
 	}
 
 	if hs.serverHello.serverShare.group == 0 {
-		c.sendAlert(alertIllegalParameter)
-		return errors.New("tls: server did not send a key share")
-	}
-	if !slicesContainsFunc(hs.hello.keyShares, func(ks keyShare) bool {
+		// Only psk_ke handshakes lack a key exchange.
+		if !hs.serverHello.selectedIdentityPresent || hs.externalPSKs == nil ||
+			!slicesContains(hs.hello.pskModes, pskModePlain) {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server did not send a key share")
+		}
+	} else if !slicesContainsFunc(hs.hello.keyShares, func(ks keyShare) bool {
 		return ks.group == hs.serverHello.serverShare.group
 	}) {
 		c.sendAlert(alertIllegalParameter)
@@ -445,6 +513,18 @@ func (hs *clientHandshakeStateTLS13) processServerHello() error {
 		return errors.New("tls: server selected an invalid PSK")
 	}
 
+	if hs.externalPSKs != nil {
+		psk := hs.externalPSKs[hs.serverHello.selectedIdentity]
+		if psk.suite.hash != hs.suite.hash {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected an invalid PSK and cipher suite pair")
+		}
+		hs.usingPSK = true
+		hs.earlySecret = psk.earlySecret
+		c.externalPSKIdentity = psk.psk.Identity
+		return nil
+	}
+
 	if len(hs.hello.pskIdentities) != 1 || hs.session == nil {
 		return c.sendAlert(alertInternalError)
 	}
@@ -470,17 +550,21 @@ func (hs *clientHandshakeStateTLS13) processServerHello() error {
 func (hs *clientHandshakeStateTLS13) establishHandshakeKeys() error {
 	c := hs.c
 
-	ke, err := keyExchangeForCurveID(hs.serverHello.serverShare.group)
-	if err != nil {
-		c.sendAlert(alertInternalError)
-		return err
-	}
-	sharedKey, err := ke.clientSharedSecret(hs.keyShareKeys, hs.serverHello.serverShare.data)
-	if err != nil {
-		c.sendAlert(alertIllegalParameter)
-		return errors.New("tls: invalid server key share")
+	// psk_ke handshakes have no shared secret, see processServerHello.
+	var sharedKey []byte
+	if hs.serverHello.serverShare.group != 0 {
+		ke, err := keyExchangeForCurveID(hs.serverHello.serverShare.group)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+		sharedKey, err = ke.clientSharedSecret(hs.keyShareKeys, hs.serverHello.serverShare.data)
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: invalid server key share")
+		}
+		c.curveID = hs.serverHello.serverShare.group
 	}
-	c.curveID = hs.serverHello.serverShare.group
 
 	earlySecret := hs.earlySecret
 	if !hs.usingPSK {
@@ -490,7 +574,11 @@ func (hs *clientHandshakeStateTLS13) establishHandshakeKeys() error {
 	handshakeSecret := earlySecret.HandshakeSecret(sharedKey)
 
 	clientSecret := handshakeSecret.ClientHandshakeTrafficSecret(hs.transcript)
-	c.setWriteTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret)
+	if hs.hello.earlyData && c.quic == nil {
+		hs.handshakeWriteSecret = clientSecret
+	} else {
+		c.setWriteTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret)
+	}
 	serverSecret := handshakeSecret.ServerHandshakeTrafficSecret(hs.transcript)
 	if err := c.setReadTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, serverSecret, false); err != nil {
 		return err
@@ -503,12 +591,12 @@ func (hs *clientHandshakeStateTLS13) establishHandshakeKeys() error {
 		}
 	}
 
-	err = c.config.writeKeyLog(keyLogLabelClientHandshake, hs.hello.random, clientSecret)
+	err := c.writeKeyLog(keyLogLabelClientHandshake, hs.hello.random, clientSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
-	err = c.config.writeKeyLog(keyLogLabelServerHandshake, hs.hello.random, serverSecret)
+	err = c.writeKeyLog(keyLogLabelServerHandshake, hs.hello.random, serverSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
@@ -557,12 +645,26 @@ func (hs *clientHandshakeStateTLS13) readServerParameters() error {
 		}
 	}
 
+	if encryptedExtensions.recordSizeLimit != 0 {
+		if hs.hello.recordSizeLimit == 0 {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent an unrequested record_size_limit extension")
+		}
+		if err := c.setRecordSizeLimits(hs.hello.recordSizeLimit, encryptedExtensions.recordSizeLimit); err != nil {
+			return err
+		}
+	}
+
 	if !hs.hello.earlyData && encryptedExtensions.earlyData {
 		c.sendAlert(alertUnsupportedExtension)
 		return errors.New("tls: server sent an unexpected early_data extension")
 	}
 	if hs.hello.earlyData && !encryptedExtensions.earlyData {
-		c.quicRejectedEarlyData()
+		if c.quic != nil {
+			c.quicRejectedEarlyData()
+		} else {
+			c.setWriteTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, hs.handshakeWriteSecret)
+		}
 	}
 	if encryptedExtensions.earlyData {
 		if hs.session.cipherSuite != c.cipherSuite {
@@ -573,6 +675,21 @@ func (hs *clientHandshakeStateTLS13) readServerParameters() error {
 			c.sendAlert(alertHandshakeFailure)
 			return errors.New("tls: server accepted 0-RTT with the wrong ALPN")
 		}
+		c.earlyDataAccepted = true
+	}
+	if !hs.usingPSK {
+		if !offeredCertificateType(hs.hello.serverCertificateTypes, encryptedExtensions.serverCertificateType) {
+			c.sendAlert(alertUnsupportedCertificate)
+			return errors.New("tls: server selected an unadvertised server certificate type")
+		}
+		hs.serverCertType = CertificateType(encryptedExtensions.serverCertificateType)
+	}
+	if encryptedExtensions.clientCertificateType != 0 {
+		if !slicesContains(hs.hello.clientCertificateTypes, encryptedExtensions.clientCertificateType) {
+			c.sendAlert(alertUnsupportedCertificate)
+			return errors.New("tls: server selected an unadvertised client certificate type")
+		}
+		hs.clientCertType = CertificateType(encryptedExtensions.clientCertificateType)
 	}
 	if hs.echContext != nil {
 		if hs.echContext.echRejected {
@@ -598,7 +715,7 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 		if c.config.VerifyConnection != nil {
 			if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
 				c.sendAlert(alertBadCertificate)
-				return err
+				return c.callbackError(err)
 			}
 		}
 		return nil
@@ -611,6 +728,11 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 
 	certReq, ok := msg.(*certificateRequestMsgTLS13)
 	if ok {
+		// RFC 8446, Section 4.3.2
+		if len(certReq.requestContext) != 0 {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server sent a CertificateRequest with a non-empty context during the handshake")
+		}
 		hs.certReq = certReq
 
 		msg, err = c.readHandshake(hs.transcript)
@@ -619,21 +741,51 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 		}
 	}
 
+	if compressed, ok := msg.(*compressedCertificateMsg); ok {
+		msg, err = c.decompressCertificate(compressed)
+		if err != nil {
+			return err
+		}
+	}
+
 	certMsg, ok := msg.(*certificateMsgTLS13)
 	if !ok {
 		c.sendAlert(alertUnexpectedMessage)
 		return unexpectedMessageError(certMsg, msg)
 	}
-	if len(certMsg.certificate.Certificate) == 0 {
-		c.sendAlert(alertDecodeError)
-		return errors.New("tls: received empty certificates message")
+	if len(certMsg.requestContext) != 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server sent a Certificate with a non-empty context")
 	}
 
-	c.scts = certMsg.certificate.SignedCertificateTimestamps
-	c.ocspResponse = certMsg.certificate.OCSPStaple
+	var pub crypto.PublicKey
+	var verification *serverCertificateVerification
+	if hs.serverCertType == CertificateTypeRawPublicKey {
+		if certMsg.delegatedCredential != nil {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent a delegated credential with a raw public key")
+		}
+		if pub, err = c.processPeerRawPublicKey(certMsg.certificate.Certificate); err != nil {
+			return err
+		}
+	} else {
+		if len(certMsg.certificate.Certificate) == 0 {
+			c.sendAlert(alertDecodeError)
+			return errors.New("tls: received empty certificates message")
+		}
 
-	if err := c.verifyServerCertificate(certMsg.certificate.Certificate); err != nil {
-		return err
+		c.scts = certMsg.certificate.SignedCertificateTimestamps
+		c.ocspResponse = certMsg.certificate.OCSPStaple
+
+		// Verify the chain while the CertificateVerify message is read and
+		// its signature checked, which only involve the public key of the
+		// leaf certificate. This is the only overlap of the verification with
+		// the handshake, and TLS 1.2 verifies the chain before going on.
+		verification, err = c.startServerCertificateVerification(hs.ctx, certMsg.certificate.Certificate, true)
+		if err != nil {
+			return err
+		}
+		pub = verification.certs[0].PublicKey
 	}
 
 	// certificateVerifyMsg is included in the transcript, but not until
@@ -650,34 +802,111 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 		return unexpectedMessageError(certVerify, msg)
 	}
 
+	var dc *DelegatedCredential
+	var sigAlert alert
+	var sigErr error
+	if certMsg.delegatedCredential != nil {
+		dc, sigAlert, sigErr = hs.checkDelegatedCredential(verification.certs[0], certMsg.delegatedCredential)
+	}
+	if sigErr == nil {
+		sigAlert, sigErr = hs.checkServerSignature(pub, dc, certVerify)
+	}
+	// Set before finish, for VerifyConnection.
+	c.delegatedCredential = dc
+	// Certificate errors take precedence over signature ones.
+	if verification != nil {
+		if err := verification.finish(); err != nil {
+			return err
+		}
+	} else if c.config.VerifyConnection != nil {
+		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return c.callbackError(err)
+		}
+	}
+	if sigErr != nil {
+		c.sendAlert(sigAlert)
+		return sigErr
+	}
+	c.peerSigAlg = certVerify.signatureAlgorithm
+
+	if err := transcriptMsg(certVerify, hs.transcript); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkDelegatedCredential parses the delegated credential sent by the server
+// and checks that leaf issued it and that it can be used, or returns the alert
+// to send. See RFC 9345, Section 4.1.3.
+func (hs *clientHandshakeStateTLS13) checkDelegatedCredential(leaf *x509.Certificate, raw []byte) (*DelegatedCredential, alert, error) {
+	c := hs.c
+
+	if !c.config.AcceptDelegatedCredentials {
+		return nil, alertUnexpectedMessage, errors.New("tls: server sent an unsolicited delegated credential")
+	}
+	dc, err := ParseDelegatedCredential(raw)
+	if err != nil {
+		return nil, alertDecodeError, err
+	}
+	if !slicesContains(delegatedCredentialSchemes, dc.Scheme) {
+		return nil, alertIllegalParameter, errors.New("tls: server sent a delegated credential with an unsupported signature algorithm")
+	}
+	if err := c.config.checkPeerKeySize(dc.PublicKey); err != nil {
+		return nil, alertBadCertificate, &CertificateVerificationError{
+			UnverifiedCertificates: []*x509.Certificate{leaf},
+			Err:                    errors.New("server sent delegated credential containing " + err.Error()),
+		}
+	}
+	now := c.config.time()
+	if notAfter := dc.NotAfter(leaf); !now.Before(notAfter) {
+		return nil, alertBadCertificate, errors.New("tls: server's delegated credential has expired")
+	} else if notAfter.Sub(now) > maxDelegatedCredentialValidity {
+		return nil, alertIllegalParameter, errors.New("tls: server's delegated credential is valid for too long")
+	}
+	if !isSupportedSignatureAlgorithm(dc.Algorithm, hs.hello.supportedSignatureAlgorithms) {
+		return nil, alertIllegalParameter, errors.New("tls: delegated credential signed with invalid signature algorithm")
+	}
+	if err := dc.verify(leaf); err != nil {
+		return nil, alertIllegalParameter, err
+	}
+	return dc, 0, nil
+}
+
+// checkServerSignature verifies the signature of certVerify by pub, the key the
+// server authenticated with, or by dc if the server used a delegated
+// credential, and returns the alert to send if it's invalid.
+func (hs *clientHandshakeStateTLS13) checkServerSignature(pub crypto.PublicKey, dc *DelegatedCredential, certVerify *certificateVerifyMsg) (alert, error) {
+	c := hs.c
+
+	if dc != nil {
+		if certVerify.signatureAlgorithm != dc.Scheme {
+			return alertIllegalParameter, errors.New("tls: delegated credential used with invalid signature algorithm")
+		}
+		pub = dc.PublicKey
+	}
+
 	// See RFC 8446, Section 4.4.3.
 	// We don't use hs.hello.supportedSignatureAlgorithms because it might
 	// include PKCS#1 v1.5 and SHA-1 if the ClientHello also supported TLS 1.2.
-	if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, supportedSignatureAlgorithms(c.vers)) ||
-		!isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, signatureSchemesForPublicKey(c.vers, c.peerCertificates[0].PublicKey)) {
-		c.sendAlert(alertIllegalParameter)
-		return errors.New("tls: certificate used with invalid signature algorithm")
+	if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, c.config.supportedSignatureAlgorithms(c.vers)) ||
+		!isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, signatureSchemesForPublicKey(c.vers, pub)) {
+		return alertIllegalParameter, errors.New("tls: certificate used with invalid signature algorithm")
 	}
 	sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
 	if err != nil {
-		return c.sendAlert(alertInternalError)
+		return alertInternalError, alertInternalError
 	}
 	if sigType == signaturePKCS1v15 || sigHash == crypto.SHA1 {
-		return c.sendAlert(alertInternalError)
+		return alertInternalError, alertInternalError
 	}
 	signed := signedMessage(serverSignatureContext, hs.transcript)
-	if err := verifyHandshakeSignature(sigType, c.peerCertificates[0].PublicKey,
+	if err := verifyHandshakeSignature(sigType, pub,
 		sigHash, signed, certVerify.signature); err != nil {
-		c.sendAlert(alertDecryptError)
-		return errors.New("tls: invalid signature by the server certificate: " + err.Error())
+		return alertDecryptError, errors.New("tls: invalid signature by the server certificate: " + err.Error())
 	}
-	c.peerSigAlg = certVerify.signatureAlgorithm
-
-	if err := transcriptMsg(certVerify, hs.transcript); err != nil {
-		return err
-	}
-
-	return nil
+	return 0, nil
 }
 
 func (hs *clientHandshakeStateTLS13) readServerFinished() error {
@@ -715,12 +944,12 @@ func (hs *clientHandshakeStateTLS13) readServerFinished() error {
 		return err
 	}
 
-	err = c.config.writeKeyLog(keyLogLabelClientTraffic, hs.hello.random, hs.trafficSecret)
+	err = c.writeKeyLog(keyLogLabelClientTraffic, hs.hello.random, hs.trafficSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
-	err = c.config.writeKeyLog(keyLogLabelServerTraffic, hs.hello.random, serverSecret)
+	err = c.writeKeyLog(keyLogLabelServerTraffic, hs.hello.random, serverSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
@@ -731,6 +960,24 @@ func (hs *clientHandshakeStateTLS13) readServerFinished() error {
 	return nil
 }
 
+// sendEndOfEarlyData closes the 0-RTT flight once the server accepted it, and
+// switches to the handshake traffic keys. QUIC does not use EndOfEarlyData.
+// See RFC 8446, Section 4.5.
+func (hs *clientHandshakeStateTLS13) sendEndOfEarlyData() error {
+	c := hs.c
+
+	if !c.earlyDataAccepted || c.quic != nil {
+		return nil
+	}
+
+	if _, err := c.writeHandshakeRecord(&endOfEarlyDataMsg{}, hs.transcript); err != nil {
+		return err
+	}
+	c.setWriteTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, hs.handshakeWriteSecret)
+
+	return nil
+}
+
 func (hs *clientHandshakeStateTLS13) sendClientCertificate() error {
 	c := hs.c
 
@@ -746,10 +993,11 @@ func (hs *clientHandshakeStateTLS13) sendClientCertificate() error {
 	}
 
 	cert, err := c.getClientCertificate(&CertificateRequestInfo{
-		AcceptableCAs:    hs.certReq.certificateAuthorities,
-		SignatureSchemes: hs.certReq.supportedSignatureAlgorithms,
-		Version:          c.vers,
-		ctx:              hs.ctx,
+		AcceptableCAs:               hs.certReq.certificateAuthorities,
+		SignatureSchemes:            hs.certReq.supportedSignatureAlgorithms,
+		CertificateSignatureSchemes: hs.certReq.supportedSignatureAlgorithmsCert,
+		Version:                     c.vers,
+		ctx:                         hs.ctx,
 	})
 	if err != nil {
 		return err
@@ -757,11 +1005,24 @@ func (hs *clientHandshakeStateTLS13) sendClientCertificate() error {
 
 	certMsg := new(certificateMsgTLS13)
 
-	certMsg.certificate = *cert
-	certMsg.scts = hs.certReq.scts && len(cert.SignedCertificateTimestamps) > 0
-	certMsg.ocspStapling = hs.certReq.ocspStapling && len(cert.OCSPStaple) > 0
+	if hs.clientCertType == CertificateTypeRawPublicKey && len(cert.Certificate) > 0 {
+		spki, err := rawPublicKey(cert)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+		certMsg.certificate = Certificate{Certificate: [][]byte{spki}}
+	} else {
+		certMsg.certificate = *cert
+		certMsg.scts = hs.certReq.scts && len(cert.SignedCertificateTimestamps) > 0
+		certMsg.ocspStapling = hs.certReq.ocspStapling && len(cert.OCSPStaple) > 0
+	}
 
-	if _, err := hs.c.writeHandshakeRecord(certMsg, hs.transcript); err != nil {
+	msg, err := c.compressCertificate(certMsg, hs.certReq.certCompressionAlgorithms)
+	if err != nil {
+		return err
+	}
+	if _, err := hs.c.writeHandshakeRecord(msg, hs.transcript); err != nil {
 		return err
 	}
 
@@ -770,39 +1031,52 @@ func (hs *clientHandshakeStateTLS13) sendClientCertificate() error {
 		return nil
 	}
 
+	certVerifyMsg, err := c.clientCertificateVerify(hs.ctx, cert, hs.certReq.supportedSignatureAlgorithms, hs.transcript)
+	if err != nil {
+		return err
+	}
+
+	if _, err := hs.c.writeHandshakeRecord(certVerifyMsg, hs.transcript); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// clientCertificateVerify signs transcript with cert, using one of the
+// signature algorithms the server requested.
+func (c *Conn) clientCertificateVerify(ctx context.Context, cert *Certificate, peerAlgs []SignatureScheme, transcript hash.Hash) (*certificateVerifyMsg, error) {
 	certVerifyMsg := new(certificateVerifyMsg)
 	certVerifyMsg.hasSignatureAlgorithm = true
 
-	certVerifyMsg.signatureAlgorithm, err = selectSignatureScheme(c.vers, cert, hs.certReq.supportedSignatureAlgorithms)
+	var err error
+	certVerifyMsg.signatureAlgorithm, err = selectSignatureScheme(c.vers, cert, peerAlgs)
 	if err != nil {
 		// getClientCertificate returned a certificate incompatible with the
 		// CertificateRequestInfo supported signature algorithms.
 		c.sendAlert(alertHandshakeFailure)
-		return err
+		return nil, err
 	}
 
 	sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerifyMsg.signatureAlgorithm)
 	if err != nil {
-		return c.sendAlert(alertInternalError)
+		return nil, c.sendAlert(alertInternalError)
 	}
 
-	signed := signedMessage(clientSignatureContext, hs.transcript)
+	signed := signedMessage(clientSignatureContext, transcript)
 	signOpts := crypto.SignerOpts(sigHash)
 	if sigType == signatureRSAPSS {
 		signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
+	} else if sigType == signatureSM2 {
+		signOpts = sm2SignerOpts
 	}
-	sig, err := cryptoSignMessage(cert.PrivateKey.(crypto.Signer), c.config.rand(), signed, signOpts)
+	sig, err := signContext(ctx, cert.PrivateKey.(crypto.Signer), c.config.rand(), signed, signOpts)
 	if err != nil {
 		c.sendAlert(alertInternalError)
-		return errors.New("tls: failed to sign handshake: " + err.Error())
+		return nil, errors.New("tls: failed to sign handshake: " + err.Error())
 	}
 	certVerifyMsg.signature = sig
-
-	if _, err := hs.c.writeHandshakeRecord(certVerifyMsg, hs.transcript); err != nil {
-		return err
-	}
-
-	return nil
+	return certVerifyMsg, nil
 }
 
 func (hs *clientHandshakeStateTLS13) sendClientFinished() error {
@@ -818,6 +1092,10 @@ func (hs *clientHandshakeStateTLS13) sendClientFinished() error {
 
 	c.setWriteTrafficSecret(hs.suite, QUICEncryptionLevelApplication, hs.trafficSecret)
 
+	if hs.hello.postHandshakeAuth && hs.clientCertType != CertificateTypeRawPublicKey {
+		c.postHandshakeTranscript = cloneHash(hs.transcript, hs.suite.hash)
+	}
+
 	if !c.config.SessionTicketsDisabled && c.config.ClientSessionCache != nil {
 		c.resumptionSecret = hs.masterSecret.ResumptionMasterSecret(hs.transcript)
 	}
@@ -829,40 +1107,48 @@ func (hs *clientHandshakeStateTLS13) sendClientFinished() error {
 	return nil
 }
 
-func (c *Conn) handleNewSessionTicket(msg *newSessionTicketMsgTLS13) error {
+// sessionFromTicket returns the session resumed by a ticket sent by the
+// server, or nil if it can't be used by this client.
+func (c *Conn) sessionFromTicket(msg *newSessionTicketMsgTLS13) (*SessionState, error) {
 	if !c.isClient {
 		c.sendAlert(alertUnexpectedMessage)
-		return errors.New("tls: received new session ticket from a client")
+		return nil, errors.New("tls: received new session ticket from a client")
 	}
 
 	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil {
-		return nil
+		return nil, nil
+	}
+
+	// Sessions don't record raw public keys, so resuming would leave the
+	// server without an identity.
+	if c.peerRawPublicKey != nil {
+		return nil, nil
 	}
 
 	// See RFC 8446, Section 4.6.1.
 	if msg.lifetime == 0 {
-		return nil
+		return nil, nil
 	}
 	lifetime := time.Duration(msg.lifetime) * time.Second
 	if lifetime > maxSessionTicketLifetime {
 		c.sendAlert(alertIllegalParameter)
-		return errors.New("tls: received a session ticket with invalid lifetime")
+		return nil, errors.New("tls: received a session ticket with invalid lifetime")
 	}
 
 	if len(msg.label) == 0 {
 		c.sendAlert(alertDecodeError)
-		return errors.New("tls: received a session ticket with empty opaque ticket label")
+		return nil, errors.New("tls: received a session ticket with empty opaque ticket label")
 	}
 
 	// RFC 9001, Section 4.6.1
 	if c.quic != nil && msg.maxEarlyData != 0 && msg.maxEarlyData != 0xffffffff {
 		c.sendAlert(alertIllegalParameter)
-		return errors.New("tls: invalid early data for QUIC connection")
+		return nil, errors.New("tls: invalid early data for QUIC connection")
 	}
 
 	cipherSuite := cipherSuiteTLS13ByID(c.cipherSuite)
 	if cipherSuite == nil || c.resumptionSecret == nil {
-		return c.sendAlert(alertInternalError)
+		return nil, c.sendAlert(alertInternalError)
 	}
 
 	psk := tls13ExpandLabel(cipherSuite.hash.New, c.resumptionSecret, "resumption",
@@ -872,16 +1158,26 @@ func (c *Conn) handleNewSessionTicket(msg *newSessionTicketMsgTLS13) error {
 	session.secret = psk
 	session.useBy = uint64(c.config.time().Add(lifetime).Unix())
 	session.ageAdd = msg.ageAdd
-	session.EarlyData = c.quic != nil && msg.maxEarlyData == 0xffffffff // RFC 9001, Section 4.6.1
+	if c.quic != nil {
+		session.EarlyData = msg.maxEarlyData == 0xffffffff // RFC 9001, Section 4.6.1
+	} else {
+		session.EarlyData = msg.maxEarlyData > 0
+	}
+	session.maxEarlyData = msg.maxEarlyData
 	session.ticket = msg.label
+	c.sessionEvent(SessionTicketReceived, session)
+	return session, nil
+}
+
+// storeSession stores a session received after the handshake in the session
+// cache, or hands it to the QUIC layer.
+func (c *Conn) storeSession(session *SessionState) {
 	if c.quic != nil && c.quic.enableSessionEvents {
 		c.quicStoreSession(session)
-		return nil
+		return
 	}
 	cs := &ClientSessionState{session: session}
 	if cacheKey := c.clientSessionCacheKey(); cacheKey != "" {
 		c.config.ClientSessionCache.Put(cacheKey, cs)
 	}
-
-	return nil
 }
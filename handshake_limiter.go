@@ -0,0 +1,128 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errHandshakeLimited = errors.New("tls: timed out waiting for a handshake slot")
+
+// A HandshakeLimiter bounds the number of server handshakes in progress at
+// the same time, to protect servers from being overwhelmed by the CPU cost of
+// handshakes, for example during a flood of new connections. Handshakes over
+// the limit wait for a slot to become free, until QueueTimeout or until the
+// handshake context is done, at which point they fail without sending an
+// alert.
+//
+// A HandshakeLimiter is set in [Config.HandshakeLimiter], and can be shared
+// by multiple Configs and listeners. It must not be copied or have its
+// fields modified after first use.
+type HandshakeLimiter struct {
+	// MaxHandshakes is the maximum number of handshakes in progress. If
+	// zero, there is no limit.
+	MaxHandshakes int
+
+	// MaxHandshakesPerIP is the maximum number of handshakes in progress
+	// with clients from the same IP address. If zero, there is no limit.
+	MaxHandshakesPerIP int
+
+	// QueueTimeout is how long a handshake waits for a slot. If zero, it
+	// waits until the handshake context is done.
+	QueueTimeout time.Duration
+
+	mu      sync.Mutex
+	active  int
+	perIP   map[string]int
+	release chan struct{} // closed when a slot is released
+}
+
+// InProgress returns the number of handshakes holding a slot.
+func (l *HandshakeLimiter) InProgress() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+func (l *HandshakeLimiter) available(ip string) bool {
+	if l.MaxHandshakes > 0 && l.active >= l.MaxHandshakes {
+		return false
+	}
+	return l.MaxHandshakesPerIP <= 0 || l.perIP[ip] < l.MaxHandshakesPerIP
+}
+
+// acquire waits for a slot for a handshake with a client at ip, and returns
+// the function to release it.
+func (l *HandshakeLimiter) acquire(ctx context.Context, ip string) (func(), error) {
+	var timeout <-chan time.Time
+	if l.QueueTimeout > 0 {
+		t := time.NewTimer(l.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	l.mu.Lock()
+	for !l.available(ip) {
+		if l.release == nil {
+			l.release = make(chan struct{})
+		}
+		release := l.release
+		l.mu.Unlock()
+		select {
+		case <-release:
+		case <-timeout:
+			return nil, errHandshakeLimited
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		l.mu.Lock()
+	}
+	l.active++
+	if l.MaxHandshakesPerIP > 0 {
+		if l.perIP == nil {
+			l.perIP = make(map[string]int)
+		}
+		l.perIP[ip]++
+	}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(func() { l.releaseSlot(ip) }) }, nil
+}
+
+func (l *HandshakeLimiter) releaseSlot(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if l.MaxHandshakesPerIP > 0 {
+		if l.perIP[ip]--; l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+	}
+	// Wake up all the waiting handshakes, to check for a slot again.
+	if l.release != nil {
+		close(l.release)
+		l.release = nil
+	}
+}
+
+// remoteIP returns the IP address of the peer of conn, or its address if it
+// isn't an IP one.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
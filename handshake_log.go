@@ -0,0 +1,48 @@
+package tls
+
+// A HandshakeMessage is a handshake message of a connection, retained if
+// [Config.RetainHandshakeMessages] is set, and returned by
+// [Conn.HandshakeMessages].
+type HandshakeMessage struct {
+	// Sent is true for the messages sent, and false for those received.
+	Sent bool
+
+	// Type is the type of the message, such as 1 for client_hello.
+	Type uint8
+
+	// Raw is the message as sent or received, with its four-byte header.
+	// For a ClientHello offering ECH, it is the outer one.
+	Raw []byte
+
+	// Parsed is the message as parsed by this package, to be printed with
+	// the %+v verb of package fmt. Its types are unexported and change
+	// between versions. For the messages changed by Config.HandshakeTransform,
+	// it is the original message.
+	Parsed any
+}
+
+// HandshakeMessages returns the handshake messages of the last handshake of
+// c, in order, followed by those of renegotiations, if
+// Config.RetainHandshakeMessages is set.
+func (c *Conn) HandshakeMessages() []HandshakeMessage {
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	return slicesClone(c.handshakeMessages)
+}
+
+// retainHandshakeMessage records msg, sent or received, as raw.
+func (c *Conn) retainHandshakeMessage(sent bool, msg handshakeMessage, raw []byte) {
+	if !c.config.RetainHandshakeMessages || c.isHandshakeComplete.Load() {
+		return
+	}
+	if sent {
+		// raw is in a pooled buffer.
+		raw = slicesClone(raw)
+	}
+	c.handshakeMessages = append(c.handshakeMessages, HandshakeMessage{
+		Sent:   sent,
+		Type:   raw[0],
+		Raw:    raw,
+		Parsed: msg,
+	})
+}
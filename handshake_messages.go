@@ -86,6 +86,8 @@ type clientHelloMsg struct {
 	secureRenegotiationSupported     bool
 	secureRenegotiation              []byte
 	extendedMasterSecret             bool
+	encryptThenMAC                   bool
+	recordSizeLimit                  uint16
 	alpnProtocols                    []string
 	scts                             bool
 	supportedVersions                []uint16
@@ -97,8 +99,16 @@ type clientHelloMsg struct {
 	pskBinders                       [][]byte
 	quicTransportParameters          []byte
 	encryptedClientHello             []byte
+	certCompressionAlgorithms        []uint16
+	delegatedCredentialSchemes       []SignatureScheme
+	serverCertificateTypes           []uint8
+	clientCertificateTypes           []uint8
+	postHandshakeAuth                bool
+	certificateAuthorities           [][]byte
 	// extensions are only populated on the server-side of a handshake
 	extensions []uint16
+	// layout, if not nil, is the layout of the extensions of a UConn.
+	layout []helloExtension
 }
 
 func (m *clientHelloMsg) marshalMsg(echInner bool) ([]byte, error) {
@@ -145,6 +155,18 @@ func (m *clientHelloMsg) marshalMsg(echInner bool) ([]byte, error) {
 		exts.AddUint16(extensionExtendedMasterSecret)
 		exts.AddUint16(0) // empty extension_data
 	}
+	if m.encryptThenMAC && !echInner {
+		// RFC 7366
+		exts.AddUint16(extensionEncryptThenMAC)
+		exts.AddUint16(0) // empty extension_data
+	}
+	if m.recordSizeLimit != 0 {
+		// RFC 8449, Section 4
+		exts.AddUint16(extensionRecordSizeLimit)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint16(m.recordSizeLimit)
+		})
+	}
 	if m.scts {
 		// RFC 6962, Section 3.3.1
 		exts.AddUint16(extensionSCT)
@@ -155,6 +177,64 @@ func (m *clientHelloMsg) marshalMsg(echInner bool) ([]byte, error) {
 		exts.AddUint16(extensionEarlyData)
 		exts.AddUint16(0) // empty extension_data
 	}
+	if len(m.certCompressionAlgorithms) > 0 {
+		// RFC 8879, Section 3
+		exts.AddUint16(extensionCompressCertificate)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint8LengthPrefixed(func(exts *cryptobyte.Builder) {
+				for _, alg := range m.certCompressionAlgorithms {
+					exts.AddUint16(alg)
+				}
+			})
+		})
+	}
+	if len(m.delegatedCredentialSchemes) > 0 {
+		// RFC 9345, Section 4.1.1
+		exts.AddUint16(extensionDelegatedCredential)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+				for _, sigAlgo := range m.delegatedCredentialSchemes {
+					exts.AddUint16(uint16(sigAlgo))
+				}
+			})
+		})
+	}
+	if len(m.serverCertificateTypes) > 0 {
+		// RFC 7250, Section 3
+		exts.AddUint16(extensionServerCertificateType)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint8LengthPrefixed(func(exts *cryptobyte.Builder) {
+				exts.AddBytes(m.serverCertificateTypes)
+			})
+		})
+	}
+	if len(m.clientCertificateTypes) > 0 {
+		// RFC 7250, Section 3
+		exts.AddUint16(extensionClientCertificateType)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint8LengthPrefixed(func(exts *cryptobyte.Builder) {
+				exts.AddBytes(m.clientCertificateTypes)
+			})
+		})
+	}
+	if m.postHandshakeAuth {
+		// RFC 8446, Section 4.2.6
+		exts.AddUint16(extensionPostHandshakeAuth)
+		exts.AddUint16(0) // empty extension_data
+	}
+	if len(m.certificateAuthorities) > 0 {
+		// RFC 8446, Section 4.2.4
+		exts.AddUint16(extensionCertificateAuthorities)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+				for _, ca := range m.certificateAuthorities {
+					exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+						exts.AddBytes(ca)
+					})
+				}
+			})
+		})
+	}
 	if m.quicTransportParameters != nil { // marshal zero-length parameters when present
 		// RFC 9001, Section 8.2
 		exts.AddUint16(extensionQUICTransportParameters)
@@ -343,6 +423,12 @@ func (m *clientHelloMsg) marshalMsg(echInner bool) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if m.layout != nil && !echInner {
+		headerLen := 4 + 2 + 32 + 1 + len(m.sessionId) + 2 + 2*len(m.cipherSuites) + 1 + len(m.compressionMethods) + 2
+		if extBytes, err = m.layoutExtensions(extBytes, headerLen); err != nil {
+			return nil, err
+		}
+	}
 
 	var b cryptobyte.Builder
 	b.AddUint8(typeClientHello)
@@ -570,6 +656,14 @@ func (m *clientHelloMsg) unmarshal(data []byte) bool {
 		case extensionExtendedMasterSecret:
 			// RFC 7627
 			m.extendedMasterSecret = true
+		case extensionEncryptThenMAC:
+			// RFC 7366
+			m.encryptThenMAC = true
+		case extensionRecordSizeLimit:
+			// RFC 8449, Section 4
+			if !extData.ReadUint16(&m.recordSizeLimit) {
+				return false
+			}
 		case extensionALPN:
 			// RFC 7301, Section 3.1
 			var protoList cryptobyte.String
@@ -623,6 +717,53 @@ func (m *clientHelloMsg) unmarshal(data []byte) bool {
 		case extensionEarlyData:
 			// RFC 8446, Section 4.2.10
 			m.earlyData = true
+		case extensionCompressCertificate:
+			// RFC 8879, Section 3
+			if !readCertCompressionAlgorithms(&extData, &m.certCompressionAlgorithms) {
+				return false
+			}
+		case extensionDelegatedCredential:
+			// RFC 9345, Section 4.1.1
+			var sigAndAlgs cryptobyte.String
+			if !extData.ReadUint16LengthPrefixed(&sigAndAlgs) || sigAndAlgs.Empty() {
+				return false
+			}
+			for !sigAndAlgs.Empty() {
+				var sigAndAlg uint16
+				if !sigAndAlgs.ReadUint16(&sigAndAlg) {
+					return false
+				}
+				m.delegatedCredentialSchemes = append(
+					m.delegatedCredentialSchemes, SignatureScheme(sigAndAlg))
+			}
+		case extensionServerCertificateType:
+			// RFC 7250, Section 3
+			if !readUint8LengthPrefixed(&extData, &m.serverCertificateTypes) ||
+				len(m.serverCertificateTypes) == 0 {
+				return false
+			}
+		case extensionClientCertificateType:
+			// RFC 7250, Section 3
+			if !readUint8LengthPrefixed(&extData, &m.clientCertificateTypes) ||
+				len(m.clientCertificateTypes) == 0 {
+				return false
+			}
+		case extensionPostHandshakeAuth:
+			// RFC 8446, Section 4.2.6
+			m.postHandshakeAuth = true
+		case extensionCertificateAuthorities:
+			// RFC 8446, Section 4.2.4
+			var auths cryptobyte.String
+			if !extData.ReadUint16LengthPrefixed(&auths) || auths.Empty() {
+				return false
+			}
+			for !auths.Empty() {
+				var ca []byte
+				if !readUint16LengthPrefixed(&auths, &ca) || len(ca) == 0 {
+					return false
+				}
+				m.certificateAuthorities = append(m.certificateAuthorities, ca)
+			}
 		case extensionPSKModes:
 			// RFC 8446, Section 4.2.9
 			if !readUint8LengthPrefixed(&extData, &m.pskModes) {
@@ -703,6 +844,8 @@ func (m *clientHelloMsg) clone() *clientHelloMsg {
 		secureRenegotiationSupported:     m.secureRenegotiationSupported,
 		secureRenegotiation:              slicesClone(m.secureRenegotiation),
 		extendedMasterSecret:             m.extendedMasterSecret,
+		encryptThenMAC:                   m.encryptThenMAC,
+		recordSizeLimit:                  m.recordSizeLimit,
 		alpnProtocols:                    slicesClone(m.alpnProtocols),
 		scts:                             m.scts,
 		supportedVersions:                slicesClone(m.supportedVersions),
@@ -714,6 +857,13 @@ func (m *clientHelloMsg) clone() *clientHelloMsg {
 		pskBinders:                       slicesClone(m.pskBinders),
 		quicTransportParameters:          slicesClone(m.quicTransportParameters),
 		encryptedClientHello:             slicesClone(m.encryptedClientHello),
+		certCompressionAlgorithms:        slicesClone(m.certCompressionAlgorithms),
+		delegatedCredentialSchemes:       slicesClone(m.delegatedCredentialSchemes),
+		serverCertificateTypes:           slicesClone(m.serverCertificateTypes),
+		clientCertificateTypes:           slicesClone(m.clientCertificateTypes),
+		postHandshakeAuth:                m.postHandshakeAuth,
+		certificateAuthorities:           slicesClone(m.certificateAuthorities),
+		layout:                           m.layout,
 	}
 }
 
@@ -729,6 +879,8 @@ type serverHelloMsg struct {
 	secureRenegotiationSupported bool
 	secureRenegotiation          []byte
 	extendedMasterSecret         bool
+	encryptThenMAC               bool
+	recordSizeLimit              uint16
 	alpnProtocol                 string
 	scts                         [][]byte
 	supportedVersion             uint16
@@ -742,6 +894,9 @@ type serverHelloMsg struct {
 	// HelloRetryRequest extensions
 	cookie        []byte
 	selectedGroup CurveID
+
+	// extensions are only populated on the client-side of a handshake
+	extensions []uint16
 }
 
 func (m *serverHelloMsg) marshal() ([]byte, error) {
@@ -766,6 +921,16 @@ func (m *serverHelloMsg) marshal() ([]byte, error) {
 		exts.AddUint16(extensionExtendedMasterSecret)
 		exts.AddUint16(0) // empty extension_data
 	}
+	if m.encryptThenMAC {
+		exts.AddUint16(extensionEncryptThenMAC)
+		exts.AddUint16(0) // empty extension_data
+	}
+	if m.recordSizeLimit != 0 {
+		exts.AddUint16(extensionRecordSizeLimit)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint16(m.recordSizeLimit)
+		})
+	}
 	if len(m.alpnProtocol) > 0 {
 		exts.AddUint16(extensionALPN)
 		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
@@ -904,6 +1069,7 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 			return false
 		}
 		seenExts[extension] = true
+		m.extensions = append(m.extensions, extension)
 
 		switch extension {
 		case extensionStatusRequest:
@@ -917,6 +1083,12 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 			m.secureRenegotiationSupported = true
 		case extensionExtendedMasterSecret:
 			m.extendedMasterSecret = true
+		case extensionEncryptThenMAC:
+			m.encryptThenMAC = true
+		case extensionRecordSizeLimit:
+			if !extData.ReadUint16(&m.recordSizeLimit) {
+				return false
+			}
 		case extensionALPN:
 			var protoList cryptobyte.String
 			if !extData.ReadUint16LengthPrefixed(&protoList) || protoList.Empty() {
@@ -1007,6 +1179,11 @@ type encryptedExtensionsMsg struct {
 	earlyData               bool
 	echRetryConfigs         []byte
 	serverNameAck           bool
+	// serverCertificateType and clientCertificateType are the negotiated
+	// CertificateTypes, only sent if not X.509.
+	serverCertificateType uint8
+	clientCertificateType uint8
+	recordSizeLimit       uint16
 }
 
 func (m *encryptedExtensionsMsg) marshal() ([]byte, error) {
@@ -1046,6 +1223,27 @@ func (m *encryptedExtensionsMsg) marshal() ([]byte, error) {
 				b.AddUint16(extensionServerName)
 				b.AddUint16(0) // empty extension_data
 			}
+			if m.serverCertificateType != 0 {
+				// RFC 7250, Section 3
+				b.AddUint16(extensionServerCertificateType)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8(m.serverCertificateType)
+				})
+			}
+			if m.clientCertificateType != 0 {
+				// RFC 7250, Section 3
+				b.AddUint16(extensionClientCertificateType)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8(m.clientCertificateType)
+				})
+			}
+			if m.recordSizeLimit != 0 {
+				// RFC 8449, Section 4
+				b.AddUint16(extensionRecordSizeLimit)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(m.recordSizeLimit)
+				})
+			}
 		})
 	})
 
@@ -1106,6 +1304,21 @@ func (m *encryptedExtensionsMsg) unmarshal(data []byte) bool {
 				return false
 			}
 			m.serverNameAck = true
+		case extensionServerCertificateType:
+			// RFC 7250, Section 3
+			if !extData.ReadUint8(&m.serverCertificateType) {
+				return false
+			}
+		case extensionClientCertificateType:
+			// RFC 7250, Section 3
+			if !extData.ReadUint8(&m.clientCertificateType) {
+				return false
+			}
+		case extensionRecordSizeLimit:
+			// RFC 8449, Section 4
+			if !extData.ReadUint16(&m.recordSizeLimit) {
+				return false
+			}
 		default:
 			// Ignore unknown extensions.
 			continue
@@ -1244,11 +1457,14 @@ func (m *newSessionTicketMsgTLS13) unmarshal(data []byte) bool {
 }
 
 type certificateRequestMsgTLS13 struct {
+	original                         []byte
+	requestContext                   []byte
 	ocspStapling                     bool
 	scts                             bool
 	supportedSignatureAlgorithms     []SignatureScheme
 	supportedSignatureAlgorithmsCert []SignatureScheme
 	certificateAuthorities           [][]byte
+	certCompressionAlgorithms        []uint16
 }
 
 func (m *certificateRequestMsgTLS13) marshal() ([]byte, error) {
@@ -1257,7 +1473,9 @@ func (m *certificateRequestMsgTLS13) marshal() ([]byte, error) {
 	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
 		// certificate_request_context (SHALL be zero length unless used for
 		// post-handshake authentication)
-		b.AddUint8(0)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(m.requestContext)
+		})
 
 		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
 			if m.ocspStapling {
@@ -1305,6 +1523,16 @@ func (m *certificateRequestMsgTLS13) marshal() ([]byte, error) {
 					})
 				})
 			}
+			if len(m.certCompressionAlgorithms) > 0 {
+				b.AddUint16(extensionCompressCertificate)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+						for _, alg := range m.certCompressionAlgorithms {
+							b.AddUint16(alg)
+						}
+					})
+				})
+			}
 		})
 	})
 
@@ -1312,16 +1540,19 @@ func (m *certificateRequestMsgTLS13) marshal() ([]byte, error) {
 }
 
 func (m *certificateRequestMsgTLS13) unmarshal(data []byte) bool {
-	*m = certificateRequestMsgTLS13{}
+	*m = certificateRequestMsgTLS13{original: data}
 	s := cryptobyte.String(data)
 
 	var context, extensions cryptobyte.String
 	if !s.Skip(4) || // message type and uint24 length field
-		!s.ReadUint8LengthPrefixed(&context) || !context.Empty() ||
+		!s.ReadUint8LengthPrefixed(&context) ||
 		!s.ReadUint16LengthPrefixed(&extensions) ||
 		!s.Empty() {
 		return false
 	}
+	if !context.Empty() {
+		m.requestContext = context
+	}
 
 	for !extensions.Empty() {
 		var extension uint16
@@ -1374,6 +1605,10 @@ func (m *certificateRequestMsgTLS13) unmarshal(data []byte) bool {
 				}
 				m.certificateAuthorities = append(m.certificateAuthorities, ca)
 			}
+		case extensionCompressCertificate:
+			if !readCertCompressionAlgorithms(&extData, &m.certCompressionAlgorithms) {
+				return false
+			}
 		default:
 			// Ignore unknown extensions.
 			continue
@@ -1387,6 +1622,10 @@ func (m *certificateRequestMsgTLS13) unmarshal(data []byte) bool {
 	return true
 }
 
+func (m *certificateRequestMsgTLS13) originalBytes() []byte {
+	return m.original
+}
+
 type certificateMsg struct {
 	certificates [][]byte
 }
@@ -1458,16 +1697,21 @@ func (m *certificateMsg) unmarshal(data []byte) bool {
 }
 
 type certificateMsgTLS13 struct {
-	certificate  Certificate
-	ocspStapling bool
-	scts         bool
+	original            []byte
+	requestContext      []byte
+	certificate         Certificate
+	ocspStapling        bool
+	scts                bool
+	delegatedCredential []byte
 }
 
 func (m *certificateMsgTLS13) marshal() ([]byte, error) {
 	var b cryptobyte.Builder
 	b.AddUint8(typeCertificate)
 	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
-		b.AddUint8(0) // certificate_request_context
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(m.requestContext)
+		})
 
 		certificate := m.certificate
 		if !m.ocspStapling {
@@ -1476,13 +1720,16 @@ func (m *certificateMsgTLS13) marshal() ([]byte, error) {
 		if !m.scts {
 			certificate.SignedCertificateTimestamps = nil
 		}
-		marshalCertificate(b, certificate)
+		marshalCertificate(b, certificate, m.delegatedCredential)
 	})
 
 	return b.Bytes()
 }
 
-func marshalCertificate(b *cryptobyte.Builder, certificate Certificate) {
+// marshalCertificate marshals the CertificateEntry list of certificate, with
+// the delegated_credential extension for the leaf if delegatedCredential is
+// not nil.
+func marshalCertificate(b *cryptobyte.Builder, certificate Certificate, delegatedCredential []byte) {
 	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
 		for i, cert := range certificate.Certificate {
 			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
@@ -1514,22 +1761,32 @@ func marshalCertificate(b *cryptobyte.Builder, certificate Certificate) {
 						})
 					})
 				}
+				if delegatedCredential != nil {
+					// RFC 9345, Section 4.1.1
+					b.AddUint16(extensionDelegatedCredential)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes(delegatedCredential)
+					})
+				}
 			})
 		}
 	})
 }
 
 func (m *certificateMsgTLS13) unmarshal(data []byte) bool {
-	*m = certificateMsgTLS13{}
+	*m = certificateMsgTLS13{original: data}
 	s := cryptobyte.String(data)
 
 	var context cryptobyte.String
 	if !s.Skip(4) || // message type and uint24 length field
-		!s.ReadUint8LengthPrefixed(&context) || !context.Empty() ||
-		!unmarshalCertificate(&s, &m.certificate) ||
+		!s.ReadUint8LengthPrefixed(&context) ||
+		!unmarshalCertificate(&s, &m.certificate, &m.delegatedCredential) ||
 		!s.Empty() {
 		return false
 	}
+	if !context.Empty() {
+		m.requestContext = context
+	}
 
 	m.scts = m.certificate.SignedCertificateTimestamps != nil
 	m.ocspStapling = m.certificate.OCSPStaple != nil
@@ -1537,7 +1794,14 @@ func (m *certificateMsgTLS13) unmarshal(data []byte) bool {
 	return true
 }
 
-func unmarshalCertificate(s *cryptobyte.String, certificate *Certificate) bool {
+func (m *certificateMsgTLS13) originalBytes() []byte {
+	return m.original
+}
+
+// unmarshalCertificate parses a CertificateEntry list into certificate, and
+// the delegated_credential extension of the leaf into delegatedCredential,
+// unless it's nil.
+func unmarshalCertificate(s *cryptobyte.String, certificate *Certificate, delegatedCredential *[]byte) bool {
 	var certList cryptobyte.String
 	if !s.ReadUint24LengthPrefixed(&certList) {
 		return false
@@ -1584,6 +1848,14 @@ func unmarshalCertificate(s *cryptobyte.String, certificate *Certificate) bool {
 					certificate.SignedCertificateTimestamps = append(
 						certificate.SignedCertificateTimestamps, sct)
 				}
+			case extensionDelegatedCredential:
+				if delegatedCredential == nil {
+					continue
+				}
+				if !extData.ReadBytes(delegatedCredential, len(extData)) ||
+					len(*delegatedCredential) == 0 {
+					return false
+				}
 			default:
 				// Ignore unknown extensions.
 				continue
@@ -1597,6 +1869,54 @@ func unmarshalCertificate(s *cryptobyte.String, certificate *Certificate) bool {
 	return true
 }
 
+// readCertCompressionAlgorithms reads the non-empty list of algorithms of a
+// compress_certificate extension.
+func readCertCompressionAlgorithms(s *cryptobyte.String, out *[]uint16) bool {
+	var algs cryptobyte.String
+	if !s.ReadUint8LengthPrefixed(&algs) || algs.Empty() {
+		return false
+	}
+	for !algs.Empty() {
+		var alg uint16
+		if !algs.ReadUint16(&alg) {
+			return false
+		}
+		*out = append(*out, alg)
+	}
+	return true
+}
+
+type compressedCertificateMsg struct {
+	algorithm          uint16
+	uncompressedLength uint32
+	compressed         []byte
+}
+
+func (m *compressedCertificateMsg) marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint8(typeCompressedCertificate)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(m.algorithm)
+		b.AddUint24(m.uncompressedLength)
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(m.compressed)
+		})
+	})
+
+	return b.Bytes()
+}
+
+func (m *compressedCertificateMsg) unmarshal(data []byte) bool {
+	*m = compressedCertificateMsg{}
+	s := cryptobyte.String(data)
+
+	return s.Skip(4) && // message type and uint24 length field
+		s.ReadUint16(&m.algorithm) &&
+		s.ReadUint24(&m.uncompressedLength) &&
+		readUint24LengthPrefixed(&s, &m.compressed) &&
+		len(m.compressed) > 0 && s.Empty()
+}
+
 type serverKeyExchangeMsg struct {
 	key []byte
 }
@@ -1882,7 +2202,8 @@ func (m *certificateVerifyMsg) unmarshal(data []byte) bool {
 }
 
 type newSessionTicketMsg struct {
-	ticket []byte
+	lifetimeHint uint32
+	ticket       []byte
 }
 
 func (m *newSessionTicketMsg) marshal() ([]byte, error) {
@@ -1894,6 +2215,10 @@ func (m *newSessionTicketMsg) marshal() ([]byte, error) {
 	x[1] = uint8(length >> 16)
 	x[2] = uint8(length >> 8)
 	x[3] = uint8(length)
+	x[4] = uint8(m.lifetimeHint >> 24)
+	x[5] = uint8(m.lifetimeHint >> 16)
+	x[6] = uint8(m.lifetimeHint >> 8)
+	x[7] = uint8(m.lifetimeHint)
 	x[8] = uint8(ticketLen >> 8)
 	x[9] = uint8(ticketLen)
 	copy(x[10:], m.ticket)
@@ -1916,6 +2241,7 @@ func (m *newSessionTicketMsg) unmarshal(data []byte) bool {
 		return false
 	}
 
+	m.lifetimeHint = uint32(data[4])<<24 | uint32(data[5])<<16 | uint32(data[6])<<8 | uint32(data[7])
 	m.ticket = data[10:]
 
 	return true
@@ -36,6 +36,7 @@ var tests = []handshakeMessage{
 	&newSessionTicketMsgTLS13{},
 	&certificateRequestMsgTLS13{},
 	&certificateMsgTLS13{},
+	&compressedCertificateMsg{},
 	&SessionState{},
 }
 
@@ -87,17 +88,25 @@ func TestMarshalUnmarshal(t *testing.T) {
 					}
 					ch.extensions = nil
 				}
+				if sh, ok := m.(*serverHelloMsg); ok {
+					// Likewise, but for the client-side.
+					sh.extensions = nil
+				}
 
-				// clientHelloMsg and serverHelloMsg, when unmarshalled, store
-				// their original representation, for later use in the handshake
-				// transcript. In order to prevent DeepEqual from failing since
-				// we didn't create the original message via unmarshalling, nil
-				// the field.
+				// clientHelloMsg, serverHelloMsg and the TLS 1.3 certificate
+				// messages, when unmarshalled, store their original
+				// representation, for later use in the handshake transcript. In
+				// order to prevent DeepEqual from failing since we didn't create
+				// the original message via unmarshalling, nil the field.
 				switch t := m.(type) {
 				case *clientHelloMsg:
 					t.original = nil
 				case *serverHelloMsg:
 					t.original = nil
+				case *certificateRequestMsgTLS13:
+					t.original = nil
+				case *certificateMsgTLS13:
+					t.original = nil
 				}
 
 				if !reflect.DeepEqual(m1, m) {
@@ -201,6 +210,12 @@ func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	if rand.Intn(10) > 5 {
 		m.extendedMasterSecret = true
 	}
+	if rand.Intn(10) > 5 {
+		m.encryptThenMAC = true
+	}
+	if rand.Intn(10) > 5 {
+		m.recordSizeLimit = uint16(rand.Intn(0xffff) + 1)
+	}
 	for i := 0; i < rand.Intn(5); i++ {
 		m.supportedVersions = append(m.supportedVersions, uint16(rand.Intn(0xffff)+1))
 	}
@@ -241,6 +256,24 @@ func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	if rand.Intn(10) > 5 {
 		m.earlyData = true
 	}
+	for i := 0; i < rand.Intn(3); i++ {
+		m.certCompressionAlgorithms = append(m.certCompressionAlgorithms, uint16(rand.Intn(0xffff)))
+	}
+	if rand.Intn(10) > 5 {
+		m.delegatedCredentialSchemes = supportedSignatureAlgorithms(VersionTLS13)
+	}
+	if rand.Intn(10) > 5 {
+		m.serverCertificateTypes = []uint8{uint8(CertificateTypeRawPublicKey), uint8(CertificateTypeX509)}
+	}
+	if rand.Intn(10) > 5 {
+		m.clientCertificateTypes = randomBytes(rand.Intn(4)+1, rand)
+	}
+	if rand.Intn(10) > 5 {
+		m.postHandshakeAuth = true
+	}
+	for i := 0; i < rand.Intn(3); i++ {
+		m.certificateAuthorities = append(m.certificateAuthorities, randomBytes(rand.Intn(10)+1, rand))
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -275,6 +308,12 @@ func (*serverHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	if rand.Intn(10) > 5 {
 		m.extendedMasterSecret = true
 	}
+	if rand.Intn(10) > 5 {
+		m.encryptThenMAC = true
+	}
+	if rand.Intn(10) > 5 {
+		m.recordSizeLimit = uint16(rand.Intn(0xffff) + 1)
+	}
 	if rand.Intn(10) > 5 {
 		m.supportedVersion = uint16(rand.Intn(0xffff) + 1)
 	}
@@ -312,6 +351,15 @@ func (*encryptedExtensionsMsg) Generate(rand *rand.Rand, size int) reflect.Value
 	if rand.Intn(10) > 5 {
 		m.earlyData = true
 	}
+	if rand.Intn(10) > 5 {
+		m.serverCertificateType = uint8(rand.Intn(0xff) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.clientCertificateType = uint8(rand.Intn(0xff) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.recordSizeLimit = uint16(rand.Intn(0xffff) + 1)
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -363,6 +411,7 @@ func (*finishedMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 
 func (*newSessionTicketMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	m := &newSessionTicketMsg{}
+	m.lifetimeHint = uint32(rand.Int31())
 	m.ticket = randomBytes(rand.Intn(4), rand)
 	return reflect.ValueOf(m)
 }
@@ -434,9 +483,14 @@ func (*SessionState) Generate(rand *rand.Rand, size int) reflect.Value {
 		if s.isClient {
 			s.useBy = uint64(rand.Int63())
 			s.ageAdd = uint32(rand.Int63() & math.MaxUint32)
+			s.maxEarlyData = uint32(rand.Int63() & math.MaxUint32)
+		} else if s.EarlyData {
+			s.ageAdd = uint32(rand.Int63() & math.MaxUint32)
+			s.maxEarlyData = uint32(rand.Int63() & math.MaxUint32)
 		}
 	} else {
 		s.curveID = CurveID(rand.Intn(30000) + 1)
+		s.encryptThenMAC = rand.Intn(10) > 5
 	}
 	return reflect.ValueOf(s)
 }
@@ -476,6 +530,9 @@ func (*newSessionTicketMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Val
 
 func (*certificateRequestMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
 	m := &certificateRequestMsgTLS13{}
+	if rand.Intn(10) > 5 {
+		m.requestContext = randomBytes(rand.Intn(32)+1, rand)
+	}
 	if rand.Intn(10) > 5 {
 		m.ocspStapling = true
 	}
@@ -494,11 +551,17 @@ func (*certificateRequestMsgTLS13) Generate(rand *rand.Rand, size int) reflect.V
 			m.certificateAuthorities[i] = randomBytes(rand.Intn(10)+1, rand)
 		}
 	}
+	for i := 0; i < rand.Intn(3); i++ {
+		m.certCompressionAlgorithms = append(m.certCompressionAlgorithms, uint16(rand.Intn(0xffff)))
+	}
 	return reflect.ValueOf(m)
 }
 
 func (*certificateMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
 	m := &certificateMsgTLS13{}
+	if rand.Intn(10) > 5 {
+		m.requestContext = randomBytes(rand.Intn(32)+1, rand)
+	}
 	for i := 0; i < rand.Intn(2)+1; i++ {
 		m.certificate.Certificate = append(
 			m.certificate.Certificate, randomBytes(rand.Intn(500)+1, rand))
@@ -514,6 +577,17 @@ func (*certificateMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
 				m.certificate.SignedCertificateTimestamps, randomBytes(rand.Intn(500)+1, rand))
 		}
 	}
+	if rand.Intn(10) > 5 {
+		m.delegatedCredential = randomBytes(rand.Intn(200)+1, rand)
+	}
+	return reflect.ValueOf(m)
+}
+
+func (*compressedCertificateMsg) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &compressedCertificateMsg{}
+	m.algorithm = uint16(rand.Intn(0xffff))
+	m.uncompressedLength = uint32(rand.Intn(1 << 24))
+	m.compressed = randomBytes(rand.Intn(500)+1, rand)
 	return reflect.ValueOf(m)
 }
 
@@ -17,6 +17,8 @@ import (
 	"hash"
 	"io"
 	"time"
+
+	"github.com/cloudflare/circl/sign/ed448"
 )
 
 // serverHandshakeState contains details of a server handshake in progress.
@@ -28,6 +30,7 @@ type serverHandshakeState struct {
 	hello        *serverHelloMsg
 	suite        *cipherSuite
 	ecdheOk      bool
+	dheOk        bool
 	ecSignOk     bool
 	rsaDecryptOk bool
 	rsaSignOk    bool
@@ -38,7 +41,10 @@ type serverHandshakeState struct {
 }
 
 // serverHandshake performs a TLS handshake as a server.
-func (c *Conn) serverHandshake(ctx context.Context) error {
+func (c *Conn) serverHandshake(ctx context.Context) (err error) {
+	c.startFallback()
+	defer func() { err = c.finishFallback(err) }()
+
 	clientHello, ech, err := c.readClientHello(ctx)
 	if err != nil {
 		return err
@@ -74,6 +80,11 @@ func (hs *serverHandshakeState) handshake() error {
 	if err := hs.checkForResumption(); err != nil {
 		return err
 	}
+	if hs.sessionState != nil {
+		c.sessionEvent(SessionResumed, hs.sessionState)
+	} else if len(hs.clientHello.sessionTicket) > 0 {
+		c.sessionEvent(SessionResumptionRejected, nil)
+	}
 	if hs.sessionState != nil {
 		// The client has included a session ticket and so we do an abbreviated handshake.
 		if err := hs.doResumeHandshake(); err != nil {
@@ -142,6 +153,10 @@ func (c *Conn) readClientHello(ctx context.Context) (*clientHelloMsg, *echServer
 		c.sendAlert(alertUnexpectedMessage)
 		return nil, nil, unexpectedMessageError(clientHello, msg)
 	}
+	c.trace.helloReceived()
+	if filter := c.config.ClientHelloReplayFilter; filter != nil {
+		c.replayedHello = filter.SeenClientHello(clientHello.random)
+	}
 
 	// ECH processing has to be done before we do any other negotiation based on
 	// the contents of the client hello, since we may swap it out completely.
@@ -152,7 +167,7 @@ func (c *Conn) readClientHello(ctx context.Context) (*clientHelloMsg, *echServer
 			echKeys, err = c.config.GetEncryptedClientHelloKeys(clientHelloInfo(ctx, c, clientHello))
 			if err != nil {
 				c.sendAlert(alertInternalError)
-				return nil, nil, err
+				return nil, nil, c.callbackError(err)
 			}
 		}
 		clientHello, ech, err = c.processECHClientHello(clientHello, echKeys)
@@ -161,18 +176,23 @@ func (c *Conn) readClientHello(ctx context.Context) (*clientHelloMsg, *echServer
 		}
 	}
 
+	c.covertPayload = c.verifyCovertAuth(clientHello)
+
 	var configForClient *Config
 	originalConfig := c.config
 	if c.config.GetConfigForClient != nil {
 		chi := clientHelloInfo(ctx, c, clientHello)
 		if configForClient, err = c.config.GetConfigForClient(chi); err != nil {
 			c.sendAlert(alertInternalError)
-			return nil, nil, err
+			return nil, nil, c.callbackError(err)
 		} else if configForClient != nil {
 			c.config = configForClient
 		}
 	}
-	c.ticketKeys = originalConfig.ticketKeys(configForClient)
+	if c.ticketKeys, err = originalConfig.ticketKeys(ctx, configForClient); err != nil {
+		c.sendAlert(alertInternalError)
+		return nil, nil, err
+	}
 
 	clientVersions := clientHello.supportedVersions
 	if clientHello.vers >= VersionTLS13 && len(clientVersions) == 0 {
@@ -234,7 +254,7 @@ func (hs *serverHandshakeState) processClientHello() error {
 	serverRandom := hs.hello.random
 	// Downgrade protection canaries. See RFC 8446, Section 4.1.3.
 	maxVers := c.config.maxSupportedVersion(roleServer, c.quic != nil)
-	if maxVers >= VersionTLS12 && c.vers < maxVers || testingOnlyForceDowngradeCanary {
+	if maxVers >= VersionTLS12 && c.vers < maxVers && c.vers != VersionTLCP || testingOnlyForceDowngradeCanary {
 		if c.vers == VersionTLS12 {
 			copy(serverRandom[24:], downgradeCanaryTLS12)
 		} else {
@@ -254,11 +274,24 @@ func (hs *serverHandshakeState) processClientHello() error {
 	}
 
 	hs.hello.extendedMasterSecret = hs.clientHello.extendedMasterSecret
+	if c.config.fipsMode() && !hs.hello.extendedMasterSecret {
+		c.sendAlert(alertHandshakeFailure)
+		return errEMSRequiredFIPS
+	}
 	hs.hello.secureRenegotiationSupported = hs.clientHello.secureRenegotiationSupported
+	c.secureRenegotiation = hs.clientHello.secureRenegotiationSupported
+	if hs.clientHello.recordSizeLimit != 0 {
+		hs.hello.recordSizeLimit = c.config.recordSizeLimit(c.vers)
+		if err := c.setRecordSizeLimits(hs.hello.recordSizeLimit, hs.clientHello.recordSizeLimit); err != nil {
+			return err
+		}
+	}
 	hs.hello.compressionMethod = compressionNone
 	if len(hs.clientHello.serverName) > 0 {
 		c.serverName = hs.clientHello.serverName
 	}
+	c.peerHelloExtensions = hs.clientHello.extensions
+	c.peerALPNProtocols = hs.clientHello.alpnProtocols
 
 	selectedProto, err := negotiateALPN(c.config.NextProtos, hs.clientHello.alpnProtocols, false)
 	if err != nil {
@@ -269,15 +302,23 @@ func (hs *serverHandshakeState) processClientHello() error {
 	c.clientProtocol = selectedProto
 
 	hs.cert, err = c.config.getCertificate(clientHelloInfo(hs.ctx, c, hs.clientHello))
+	if err == errNoCertificates && c.config.hasExternalPSKs() {
+		// Only PSK cipher suites can be negotiated.
+		hs.cert, err = nil, nil
+	}
 	if err != nil {
 		if err == errNoCertificates {
 			c.sendAlert(alertUnrecognizedName)
-		} else {
-			c.sendAlert(alertInternalError)
+			return err
 		}
-		return err
+		// Those of GetCertificate and GetCertificateForHello.
+		c.sendAlert(alertInternalError)
+		return c.callbackError(err)
+	}
+	if hs.cert != nil {
+		hs.cert = c.config.stapleCertificate(hs.cert)
 	}
-	if hs.clientHello.scts {
+	if hs.cert != nil && hs.clientHello.scts {
 		hs.hello.scts = hs.cert.SignedCertificateTimestamps
 	}
 
@@ -286,6 +327,7 @@ func (hs *serverHandshakeState) processClientHello() error {
 		c.sendAlert(alertMissingExtension)
 		return err
 	}
+	hs.dheOk = c.config.mutualFFDHEGroup(hs.clientHello.supportedCurves) != nil
 
 	if hs.ecdheOk && len(hs.clientHello.supportedPoints) > 0 {
 		// Although omitting the ec_point_formats extension is permitted, some
@@ -296,11 +338,15 @@ func (hs *serverHandshakeState) processClientHello() error {
 		hs.hello.supportedPoints = []uint8{pointFormatUncompressed}
 	}
 
+	if hs.cert == nil {
+		return nil
+	}
+
 	if priv, ok := hs.cert.PrivateKey.(crypto.Signer); ok {
 		switch priv.Public().(type) {
 		case *ecdsa.PublicKey:
 			hs.ecSignOk = true
-		case ed25519.PublicKey:
+		case ed25519.PublicKey, ed448.PublicKey:
 			hs.ecSignOk = true
 		case *rsa.PublicKey:
 			hs.rsaSignOk = true
@@ -313,6 +359,9 @@ func (hs *serverHandshakeState) processClientHello() error {
 		switch priv.Public().(type) {
 		case *rsa.PublicKey:
 			hs.rsaDecryptOk = true
+		case *ecdsa.PublicKey:
+			// SM2 private keys are Decrypters too, which only TLCP uses,
+			// with the key of Config.TLCPEncryptionCertificate.
 		default:
 			c.sendAlert(alertInternalError)
 			return fmt.Errorf("tls: unsupported decryption key type (%T)", priv.Public())
@@ -390,7 +439,15 @@ func supportsECDHE(c *Config, version uint16, supportedCurves []CurveID, support
 func (hs *serverHandshakeState) pickCipherSuite() error {
 	c := hs.c
 
-	preferenceList := c.config.cipherSuites(isAESGCMPreferred(hs.clientHello.cipherSuites))
+	preferenceList := c.config.cipherSuites(c.config.aesGCMPreferred(hs.clientHello.cipherSuites))
+	if c.vers == VersionTLCP {
+		preferenceList = c.config.tlcpCipherSuites()
+	}
+	preferenceList, err := c.cipherSuitePreference(hs.ctx, hs.clientHello, preferenceList)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
 
 	hs.suite = selectCipherSuite(preferenceList, hs.clientHello.cipherSuites, hs.cipherSuiteOk)
 	if hs.suite == nil {
@@ -414,8 +471,53 @@ func (hs *serverHandshakeState) pickCipherSuite() error {
 	return nil
 }
 
+// encryptThenMACOk reports whether encrypt-then-MAC is used with the client,
+// which requires a CBC cipher suite. See RFC 7366, Section 3.
+func (hs *serverHandshakeState) encryptThenMACOk() bool {
+	return hs.c.config.EncryptThenMAC && hs.clientHello.encryptThenMAC &&
+		hs.suite.isCBC() && hs.c.vers != VersionTLCP
+}
+
+// cipherSuitePreference returns preferenceList, the enabled cipher suites in
+// preference order, reordered by Config.GetCipherSuitePreference for the
+// client that sent clientHello.
+func (c *Conn) cipherSuitePreference(ctx context.Context, clientHello *clientHelloMsg, preferenceList []uint16) ([]uint16, error) {
+	if c.config.GetCipherSuitePreference == nil {
+		return preferenceList, nil
+	}
+	preferred, err := c.config.GetCipherSuitePreference(clientHelloInfo(ctx, c, clientHello))
+	if err != nil {
+		return nil, c.callbackError(err)
+	}
+	ordered := make([]uint16, 0, len(preferenceList))
+	for _, id := range preferred {
+		if slicesContains(preferenceList, id) && !slicesContains(ordered, id) {
+			ordered = append(ordered, id)
+		}
+	}
+	for _, id := range preferenceList {
+		if !slicesContains(ordered, id) {
+			ordered = append(ordered, id)
+		}
+	}
+	return ordered, nil
+}
+
 func (hs *serverHandshakeState) cipherSuiteOk(c *cipherSuite) bool {
-	if c.flags&suiteECDHE != 0 {
+	if (hs.c.vers == VersionTLCP) != (c.flags&suiteTLCP != 0) {
+		return false
+	}
+	if c.flags&suiteTLCP != 0 {
+		return hs.ecSignOk && hs.c.config.TLCPEncryptionCertificate != nil
+	}
+	if c.flags&suitePSK != 0 {
+		if !hs.c.config.hasExternalPSKs() {
+			return false
+		}
+		if c.flags&suiteECDHE != 0 && !hs.ecdheOk {
+			return false
+		}
+	} else if c.flags&suiteECDHE != 0 {
 		if !hs.ecdheOk {
 			return false
 		}
@@ -426,6 +528,10 @@ func (hs *serverHandshakeState) cipherSuiteOk(c *cipherSuite) bool {
 		} else if !hs.rsaSignOk {
 			return false
 		}
+	} else if c.flags&suiteDHE != 0 {
+		if !hs.dheOk || !hs.rsaSignOk {
+			return false
+		}
 	} else if !hs.rsaDecryptOk {
 		return false
 	}
@@ -453,6 +559,11 @@ func (hs *serverHandshakeState) checkForResumption() error {
 			return nil
 		}
 		sessionState = ss
+	} else if c.config.SingleUseTickets {
+		sessionState = c.config.takeSingleUseSession(hs.clientHello.sessionTicket)
+		if sessionState == nil {
+			return nil
+		}
 	} else {
 		plaintext := c.config.decryptTicket(hs.clientHello.sessionTicket, c.ticketKeys)
 		if plaintext == nil {
@@ -469,7 +580,7 @@ func (hs *serverHandshakeState) checkForResumption() error {
 	// re-wrapping the same master secret in different tickets over and over for
 	// too long, weakening forward secrecy.
 	createdAt := time.Unix(int64(sessionState.createdAt), 0)
-	if c.config.time().Sub(createdAt) > maxSessionTicketLifetime {
+	if c.config.time().Sub(createdAt) > c.config.sessionTicketLifetime() {
 		return nil
 	}
 
@@ -514,7 +625,7 @@ func (hs *serverHandshakeState) checkForResumption() error {
 		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
 	if sessionHasClientCerts && c.config.ClientAuth >= VerifyClientCertIfGiven &&
-		!anyValidVerifiedChain(sessionState.verifiedChains, opts) {
+		!c.config.resumedChainsValid(sessionState.peerCertificates, sessionState.verifiedChains, opts) {
 		return nil
 	}
 
@@ -528,6 +639,17 @@ func (hs *serverHandshakeState) checkForResumption() error {
 		return errors.New("tls: session supported extended_master_secret but client does not")
 	}
 
+	// RFC 7366, Section 3.1: a session that used encrypt-then-MAC is not
+	// resumed without it.
+	if sessionState.encryptThenMAC && (!c.config.EncryptThenMAC || !hs.clientHello.encryptThenMAC) {
+		return nil
+	}
+
+	if c.config.ApproveResumption != nil &&
+		!c.config.ApproveResumption(sessionState, clientHelloInfo(hs.ctx, c, hs.clientHello)) {
+		return nil
+	}
+
 	c.peerCertificates = sessionState.peerCertificates
 	c.ocspResponse = sessionState.ocspResponse
 	c.scts = sessionState.scts
@@ -545,13 +667,15 @@ func (hs *serverHandshakeState) doResumeHandshake() error {
 
 	hs.hello.cipherSuite = hs.suite.id
 	c.cipherSuite = hs.suite.id
+	hs.hello.encryptThenMAC = hs.encryptThenMACOk()
 	// We echo the client's session ID in the ServerHello to let it know
 	// that we're doing a resumption.
 	hs.hello.sessionId = hs.clientHello.sessionId
 	// We always send a new session ticket, even if it wraps the same master
 	// secret and it's potentially encrypted with the same key, to help the
 	// client avoid cross-connection tracking from a network observer.
-	hs.hello.ticketSupported = true
+	hs.hello.ticketSupported = !c.config.ResumptionTicketsDisabled &&
+		c.config.sessionTicketCount() > 0 && !c.ticketKeysUnavailable()
 	hs.finishedHash = newFinishedHash(c.vers, hs.suite)
 	hs.finishedHash.discardHandshakeBuffer()
 	if err := transcriptMsg(hs.clientHello, &hs.finishedHash); err != nil {
@@ -560,11 +684,12 @@ func (hs *serverHandshakeState) doResumeHandshake() error {
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, &hs.finishedHash); err != nil {
 		return err
 	}
+	c.trace.helloSent()
 
 	if c.config.VerifyConnection != nil {
 		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
 			c.sendAlert(alertBadCertificate)
-			return err
+			return c.callbackError(err)
 		}
 	}
 
@@ -576,7 +701,12 @@ func (hs *serverHandshakeState) doResumeHandshake() error {
 func (hs *serverHandshakeState) doFullHandshake() error {
 	c := hs.c
 
-	if hs.clientHello.ocspStapling && len(hs.cert.OCSPStaple) > 0 {
+	// PSK cipher suites authenticate both peers with the PSK, and don't
+	// exchange certificates. See RFC 4279, Section 2.
+	usingPSK := hs.suite.flags&suitePSK != 0
+	if usingPSK {
+		hs.hello.scts = nil
+	} else if hs.clientHello.ocspStapling && len(hs.cert.OCSPStaple) > 0 {
 		hs.hello.ocspStapling = true
 	}
 
@@ -584,11 +714,21 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		hs.hello.serverNameAck = true
 	}
 
-	hs.hello.ticketSupported = hs.clientHello.ticketSupported && !c.config.SessionTicketsDisabled
+	// Sessions established with a PSK cipher suite are not resumable, as
+	// they don't carry the PSK identity.
+	hs.hello.ticketSupported = hs.clientHello.ticketSupported && !c.config.SessionTicketsDisabled &&
+		!c.config.singleUseTicketsUnavailable() && !c.ticketKeysUnavailable() && !usingPSK &&
+		c.config.sessionTicketCount() > 0 && c.vers != VersionTLCP
 	hs.hello.cipherSuite = hs.suite.id
+	hs.hello.encryptThenMAC = hs.encryptThenMACOk()
 
+	if c.vers == VersionTLCP && requiresClientCert(c.config.ClientAuth) {
+		c.sendAlert(alertHandshakeFailure)
+		return errors.New("tls: client certificates are not supported in TLCP")
+	}
+	requestClientCert := c.config.ClientAuth >= RequestClientCert && !usingPSK && c.vers != VersionTLCP
 	hs.finishedHash = newFinishedHash(hs.c.vers, hs.suite)
-	if c.config.ClientAuth == NoClientCert {
+	if !requestClientCert {
 		// No need to keep a full record of the handshake if client
 		// certificates won't be used.
 		hs.finishedHash.discardHandshakeBuffer()
@@ -599,11 +739,17 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, &hs.finishedHash); err != nil {
 		return err
 	}
+	c.trace.helloSent()
 
-	certMsg := new(certificateMsg)
-	certMsg.certificates = hs.cert.Certificate
-	if _, err := hs.c.writeHandshakeRecord(certMsg, &hs.finishedHash); err != nil {
-		return err
+	if !usingPSK {
+		certMsg := new(certificateMsg)
+		certMsg.certificates = hs.cert.Certificate
+		if c.vers == VersionTLCP {
+			certMsg.certificates = tlcpCertificates(hs.cert, c.config.TLCPEncryptionCertificate)
+		}
+		if _, err := hs.c.writeHandshakeRecord(certMsg, &hs.finishedHash); err != nil {
+			return err
+		}
 	}
 
 	if hs.hello.ocspStapling {
@@ -615,15 +761,23 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	}
 
 	keyAgreement := hs.suite.ka(c.vers)
-	skx, err := keyAgreement.generateServerKeyExchange(c.config, hs.cert, hs.clientHello, hs.hello)
+	skx, err := keyAgreement.generateServerKeyExchange(hs.ctx, c.config, hs.cert, hs.clientHello, hs.hello)
 	if err != nil {
 		c.sendAlert(alertHandshakeFailure)
 		return err
 	}
 	if skx != nil {
-		if keyAgreement, ok := keyAgreement.(*ecdheKeyAgreement); ok {
+		switch keyAgreement := keyAgreement.(type) {
+		case *ecdheKeyAgreement:
+			c.curveID = keyAgreement.curveID
+			c.peerSigAlg = keyAgreement.signatureAlgorithm
+		case *dheKeyAgreement:
 			c.curveID = keyAgreement.curveID
 			c.peerSigAlg = keyAgreement.signatureAlgorithm
+		case *pskKeyAgreement:
+			if keyAgreement.ecdhe != nil {
+				c.curveID = keyAgreement.ecdhe.curveID
+			}
 		}
 		if _, err := hs.c.writeHandshakeRecord(skx, &hs.finishedHash); err != nil {
 			return err
@@ -631,7 +785,7 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	}
 
 	var certReq *certificateRequestMsg
-	if c.config.ClientAuth >= RequestClientCert {
+	if requestClientCert {
 		// Request a client certificate
 		certReq = new(certificateRequestMsg)
 		certReq.certificateTypes = []byte{
@@ -640,7 +794,7 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		}
 		if c.vers >= VersionTLS12 {
 			certReq.hasSignatureAlgorithm = true
-			certReq.supportedSignatureAlgorithms = supportedSignatureAlgorithms(c.vers)
+			certReq.supportedSignatureAlgorithms = c.config.supportedSignatureAlgorithms(c.vers)
 		}
 
 		// An empty list of certificateAuthorities signals to
@@ -674,14 +828,14 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 
 	// If we requested a client certificate, then the client must send a
 	// certificate message, even if it's empty.
-	if c.config.ClientAuth >= RequestClientCert {
+	if requestClientCert {
 		certMsg, ok := msg.(*certificateMsg)
 		if !ok {
 			c.sendAlert(alertUnexpectedMessage)
 			return unexpectedMessageError(certMsg, msg)
 		}
 
-		if err := c.processCertsFromClient(Certificate{
+		if err := c.processCertsFromClient(hs.ctx, Certificate{
 			Certificate: certMsg.certificates,
 		}); err != nil {
 			return err
@@ -695,10 +849,10 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 			return err
 		}
 	}
-	if c.config.VerifyConnection != nil {
+	if c.config.VerifyConnection != nil && !usingPSK {
 		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
 			c.sendAlert(alertBadCertificate)
-			return err
+			return c.callbackError(err)
 		}
 	}
 
@@ -711,9 +865,22 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 
 	preMasterSecret, err := keyAgreement.processClientKeyExchange(c.config, hs.cert, ckx, c.vers)
 	if err != nil {
-		c.sendAlert(alertIllegalParameter)
+		if err == errUnknownPSKIdentity {
+			c.sendAlert(alertUnknownPSKIdentity)
+		} else {
+			c.sendAlert(alertIllegalParameter)
+		}
 		return err
 	}
+	if keyAgreement, ok := keyAgreement.(*pskKeyAgreement); ok {
+		c.externalPSKIdentity = keyAgreement.identity
+		if c.config.VerifyConnection != nil {
+			if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
+				c.sendAlert(alertBadCertificate)
+				return c.callbackError(err)
+			}
+		}
+	}
 	if hs.hello.extendedMasterSecret {
 		c.extMasterSecret = true
 		hs.masterSecret = extMasterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret,
@@ -722,7 +889,7 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		hs.masterSecret = masterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret,
 			hs.clientHello.random, hs.hello.random)
 	}
-	if err := c.config.writeKeyLog(keyLogLabelTLS12, hs.clientHello.random, hs.masterSecret); err != nil {
+	if err := c.writeKeyLog(keyLogLabelTLS12, hs.clientHello.random, hs.masterSecret); err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
@@ -772,6 +939,10 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 				c.sendAlert(alertIllegalParameter)
 				return err
 			}
+			if sigType == signaturePKCS1v15 && c.config.RequirePSS {
+				c.sendAlert(alertHandshakeFailure)
+				return errPKCS1v15Disabled
+			}
 			signed := hs.finishedHash.hashForClientCertificate(sigType)
 			if err := verifyLegacyHandshakeSignature(sigType, pub, sigHash, signed, certVerify.signature); err != nil {
 				c.sendAlert(alertDecryptError)
@@ -806,12 +977,16 @@ func (hs *serverHandshakeState) establishKeys() error {
 		serverCipher = hs.suite.cipher(serverKey, serverIV, false /* not for reading */)
 		serverHash = hs.suite.mac(serverMAC)
 	} else {
-		clientCipher = hs.suite.aead(clientKey, clientIV)
-		serverCipher = hs.suite.aead(serverKey, serverIV)
+		clientCipher = newRecordAEAD(c.aeadEngine(), c.vers, hs.suite.id, clientKey, clientIV, hs.suite.aead)
+		serverCipher = newRecordAEAD(c.aeadEngine(), c.vers, hs.suite.id, serverKey, serverIV, hs.suite.aead)
 	}
 
 	c.in.prepareCipherSpec(c.vers, clientCipher, clientHash)
 	c.out.prepareCipherSpec(c.vers, serverCipher, serverHash)
+	c.in.nextEncryptThenMAC = hs.hello.encryptThenMAC
+	c.out.nextEncryptThenMAC = hs.hello.encryptThenMAC
+	c.in.nextKey, c.in.nextIV = clientKey, clientIV
+	c.out.nextKey, c.out.nextIV = serverKey, serverIV
 
 	return nil
 }
@@ -861,6 +1036,7 @@ func (hs *serverHandshakeState) sendSessionTicket() error {
 
 	state := c.sessionState()
 	state.secret = hs.masterSecret
+	state.encryptThenMAC = hs.hello.encryptThenMAC
 	if hs.sessionState != nil {
 		// If this is re-wrapping an old key, then keep
 		// the original time it was created.
@@ -872,6 +1048,12 @@ func (hs *serverHandshakeState) sendSessionTicket() error {
 		if err != nil {
 			return err
 		}
+	} else if c.config.SingleUseTickets {
+		var err error
+		m.ticket, err = c.config.storeSingleUseSession(state)
+		if err != nil {
+			return err
+		}
 	} else {
 		stateBytes, err := state.Bytes()
 		if err != nil {
@@ -882,10 +1064,19 @@ func (hs *serverHandshakeState) sendSessionTicket() error {
 			return err
 		}
 	}
+	if c.config.SessionTicketLifetime != 0 {
+		// The hint is the time left until checkForResumption rejects the
+		// session. See RFC 5077, Section 3.3.
+		createdAt := time.Unix(int64(state.createdAt), 0)
+		if left := c.config.sessionTicketLifetime() - c.config.time().Sub(createdAt); left > 0 {
+			m.lifetimeHint = uint32(left / time.Second)
+		}
+	}
 
 	if _, err := hs.c.writeHandshakeRecord(m, &hs.finishedHash); err != nil {
 		return err
 	}
+	c.sessionEvent(SessionTicketIssued, state)
 
 	return nil
 }
@@ -910,12 +1101,13 @@ func (hs *serverHandshakeState) sendFinished(out []byte) error {
 
 // processCertsFromClient takes a chain of client certificates either from a
 // certificateMsg message or a certificateMsgTLS13 message and verifies them.
-func (c *Conn) processCertsFromClient(certificate Certificate) error {
+func (c *Conn) processCertsFromClient(ctx context.Context, certificate Certificate) (err error) {
 	certificates := certificate.Certificate
+	c.trace.certificatesReceived(certificates)
+	defer func() { c.trace.verificationDone(err) }()
 	certs := make([]*x509.Certificate, len(certificates))
-	var err error
 	for i, asn1Data := range certificates {
-		if certs[i], err = x509.ParseCertificate(asn1Data); err != nil {
+		if certs[i], err = parseCertificate(asn1Data); err != nil {
 			c.sendAlert(alertDecodeError)
 			return errors.New("tls: failed to parse client certificate: " + err.Error())
 		}
@@ -926,6 +1118,13 @@ func (c *Conn) processCertsFromClient(certificate Certificate) error {
 				return fmt.Errorf("tls: client sent certificate containing RSA key larger than %d bits", max)
 			}
 		}
+		if err := c.config.checkPeerKeySize(certs[i].PublicKey); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return &CertificateVerificationError{
+				UnverifiedCertificates: certs[:i+1],
+				Err:                    errors.New("client sent certificate containing " + err.Error()),
+			}
+		}
 	}
 
 	if len(certs) == 0 && requiresClientCert(c.config.ClientAuth) {
@@ -937,6 +1136,8 @@ func (c *Conn) processCertsFromClient(certificate Certificate) error {
 		return errors.New("tls: client didn't provide a certificate")
 	}
 
+	var verifyErr error
+	var verifyAlert alert
 	if c.config.ClientAuth >= VerifyClientCertIfGiven && len(certs) > 0 {
 		opts := x509.VerifyOptions{
 			Roots:         c.config.ClientCAs,
@@ -949,19 +1150,26 @@ func (c *Conn) processCertsFromClient(certificate Certificate) error {
 			opts.Intermediates.AddCert(cert)
 		}
 
-		chains, err := certs[0].Verify(opts)
+		chains, err := c.config.verifyCertificateChains(ctx, certificates, certs, opts)
 		if err != nil {
 			if _, ok := errorsAsType[x509.UnknownAuthorityError](err); ok {
-				c.sendAlert(alertUnknownCA)
+				verifyAlert = alertUnknownCA
 			} else if errCertificateInvalid, ok := errorsAsType[x509.CertificateInvalidError](err); ok && errCertificateInvalid.Reason == x509.Expired {
-				c.sendAlert(alertCertificateExpired)
+				verifyAlert = alertCertificateExpired
 			} else {
-				c.sendAlert(alertBadCertificate)
+				verifyAlert = alertBadCertificate
+			}
+			verifyErr = &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+			if len(c.config.Verifiers) == 0 {
+				c.sendAlert(verifyAlert)
+				return verifyErr
+			}
+		} else {
+			c.verifiedChains = chains
+			if err := c.checkRevocation(ctx, chains); err != nil {
+				return err
 			}
-			return &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
 		}
-
-		c.verifiedChains = chains
 	}
 
 	c.peerCertificates = certs
@@ -970,17 +1178,27 @@ func (c *Conn) processCertsFromClient(certificate Certificate) error {
 
 	if len(certs) > 0 {
 		switch certs[0].PublicKey.(type) {
-		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey, ed448.PublicKey:
 		default:
 			c.sendAlert(alertUnsupportedCertificate)
 			return fmt.Errorf("tls: client certificate contains an unsupported public key of type %T", certs[0].PublicKey)
 		}
 	}
 
+	if err := c.checkPinnedKeys(certs, c.verifiedChains); err != nil {
+		return err
+	}
+
+	if len(c.config.Verifiers) > 0 {
+		if err := c.consultVerifiers(ctx, certificates, verifyErr, verifyAlert); err != nil {
+			return err
+		}
+	}
+
 	if c.config.VerifyPeerCertificate != nil {
 		if err := c.config.VerifyPeerCertificate(certificates, c.verifiedChains); err != nil {
 			c.sendAlert(alertBadCertificate)
-			return err
+			return c.callbackError(err)
 		}
 	}
 
@@ -998,18 +1216,25 @@ func clientHelloInfo(ctx context.Context, c *Conn, clientHello *clientHelloMsg)
 		conn = c.quic.clientHelloInfoConn
 	}
 	return &ClientHelloInfo{
-		CipherSuites:      clientHello.cipherSuites,
-		ServerName:        clientHello.serverName,
-		SupportedCurves:   clientHello.supportedCurves,
-		SupportedPoints:   clientHello.supportedPoints,
-		SignatureSchemes:  clientHello.supportedSignatureAlgorithms,
-		SupportedProtos:   clientHello.alpnProtocols,
-		SupportedVersions: supportedVersions,
-		Extensions:        clientHello.extensions,
-		Conn:              conn,
-		HelloRetryRequest: c.didHRR,
-		config:            c.config,
-		isQUIC:            c.quic != nil,
-		ctx:               ctx,
+		CipherSuites:                clientHello.cipherSuites,
+		ServerName:                  clientHello.serverName,
+		SupportedCurves:             clientHello.supportedCurves,
+		SupportedPoints:             clientHello.supportedPoints,
+		SignatureSchemes:            clientHello.supportedSignatureAlgorithms,
+		SupportedProtos:             clientHello.alpnProtocols,
+		SupportedVersions:           supportedVersions,
+		Extensions:                  clientHello.extensions,
+		Conn:                        conn,
+		HelloRetryRequest:           c.didHRR,
+		AcceptableCAs:               clientHello.certificateAuthorities,
+		CertificateSignatureSchemes: clientHello.supportedSignatureAlgorithmsCert,
+		CovertPayload:               c.covertPayload,
+		Replayed:                    c.replayedHello,
+		config:                      c.config,
+		msg:                         clientHello,
+		echAccepted:                 c.echAccepted,
+		echOuterServerName:          c.echOuterServerName,
+		isQUIC:                      c.quic != nil,
+		ctx:                         ctx,
 	}
 }
@@ -22,8 +22,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -1284,6 +1286,689 @@ func TestServerResumptionDisabled(t *testing.T) {
 	runServerTestTLS13(t, testResume)
 }
 
+type rejectEarlyData struct{}
+
+func (rejectEarlyData) AcceptEarlyData([]byte, time.Duration) bool { return false }
+
+func TestServerEarlyData(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.EarlyData = true
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	serverConfig := testConfig.Clone()
+	serverConfig.EarlyData = true
+	serverConfig.EarlyDataAntiReplay = NewEarlyDataAntiReplay(10 * time.Second)
+
+	run := func(serverConfig *Config, early string) (clientState, serverState ConnectionState, serverEarly string) {
+		t.Helper()
+		c, s := localPipe(t)
+		defer c.Close()
+		defer s.Close()
+
+		type result struct {
+			early string
+			err   error
+		}
+		done := make(chan result, 1)
+		srv := Server(s, serverConfig)
+		go func() {
+			earlyData, err := io.ReadAll(readerFunc(srv.ReadEarlyData))
+			if err == nil {
+				err = srv.Handshake()
+			}
+			if err == nil {
+				_, err = srv.Write([]byte("hello"))
+			}
+			done <- result{string(earlyData), err}
+		}()
+
+		cli := Client(c, clientConfig)
+		if early != "" {
+			if _, err := cli.WriteEarlyData([]byte(early)); err != nil {
+				t.Fatalf("WriteEarlyData: %v", err)
+			}
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(cli, buf); err != nil {
+			t.Fatalf("client: %v", err)
+		}
+		res := <-done
+		if res.err != nil {
+			t.Fatalf("server: %v", res.err)
+		}
+		return cli.ConnectionState(), srv.ConnectionState(), res.early
+	}
+
+	// The first connection gets a ticket that allows early data.
+	if cs, _, early := run(serverConfig, "ignored"); cs.DidResume || cs.EarlyDataAccepted || early != "" {
+		t.Fatalf("first connection: DidResume = %v, EarlyDataAccepted = %v, early data %q",
+			cs.DidResume, cs.EarlyDataAccepted, early)
+	}
+
+	cs, ss, early := run(serverConfig, "early data")
+	if !cs.DidResume || !ss.DidResume {
+		t.Fatal("second connection did not resume")
+	}
+	if !cs.EarlyDataAccepted || !ss.EarlyDataAccepted {
+		t.Errorf("EarlyDataAccepted: client %v, server %v", cs.EarlyDataAccepted, ss.EarlyDataAccepted)
+	}
+	if early != "early data" {
+		t.Errorf("server read early data %q", early)
+	}
+
+	// The anti-replay filter rejects the early data, but the handshake must
+	// still succeed by skipping it.
+	rejectConfig := serverConfig.Clone()
+	rejectConfig.EarlyDataAntiReplay = rejectEarlyData{}
+	cs, ss, early = run(rejectConfig, "early data")
+	if !cs.DidResume || !ss.DidResume {
+		t.Fatal("third connection did not resume")
+	}
+	if cs.EarlyDataAccepted || ss.EarlyDataAccepted || early != "" {
+		t.Errorf("rejected early data: client %v, server %v, early data %q",
+			cs.EarlyDataAccepted, ss.EarlyDataAccepted, early)
+	}
+	if !cs.EarlyDataOffered || !ss.EarlyDataOffered {
+		t.Errorf("EarlyDataOffered: client %v, server %v", cs.EarlyDataOffered, ss.EarlyDataOffered)
+	}
+
+	// A server that doesn't support early data skips it too.
+	cs, ss, early = run(testConfig, "early data")
+	if !cs.DidResume || cs.EarlyDataAccepted || ss.EarlyDataAccepted || early != "" {
+		t.Errorf("unsupported early data: DidResume %v, client %v, server %v, early data %q",
+			cs.DidResume, cs.EarlyDataAccepted, ss.EarlyDataAccepted, early)
+	}
+}
+
+func TestServerMaxEarlyData(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.EarlyData = true
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	serverConfig := testConfig.Clone()
+	serverConfig.EarlyData = true
+	serverConfig.EarlyDataAntiReplay = NewEarlyDataAntiReplay(10 * time.Second)
+	serverConfig.MaxEarlyData = 16
+
+	run := func(early []byte) (serverEarly []byte, err error) {
+		t.Helper()
+		c, s := localPipe(t)
+		defer c.Close()
+		defer s.Close()
+
+		done := make(chan error, 1)
+		srv := Server(s, serverConfig)
+		go func() {
+			var err error
+			serverEarly, err = io.ReadAll(readerFunc(srv.ReadEarlyData))
+			if err == nil {
+				err = srv.Handshake()
+			}
+			if err == nil {
+				_, err = srv.Write([]byte("hello"))
+			}
+			srv.Close()
+			done <- err
+		}()
+
+		cli := Client(c, clientConfig)
+		if early != nil {
+			if _, err := cli.WriteEarlyData(early); err != nil {
+				t.Fatalf("WriteEarlyData: %v", err)
+			}
+		}
+		io.ReadFull(cli, make([]byte, 5))
+		return serverEarly, <-done
+	}
+
+	if _, err := run(nil); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	session := clientConfig.ClientSessionCache.(*ShardedClientSessionCache).shards[0].q.Front().Value.(*lruSessionCacheEntry).state.session
+	if session.maxEarlyData != 16 {
+		t.Fatalf("ticket max_early_data_size = %d, want 16", session.maxEarlyData)
+	}
+
+	early := bytes.Repeat([]byte("a"), 16)
+	if serverEarly, err := run(early); err != nil {
+		t.Fatalf("early data within the limit: %v", err)
+	} else if !bytes.Equal(serverEarly, early) {
+		t.Errorf("server read early data %q, want %q", serverEarly, early)
+	}
+
+	// Raising the limit doesn't affect the tickets already issued, which are
+	// still enforced by the server.
+	serverConfig.MaxEarlyData = 1024
+	session = clientConfig.ClientSessionCache.(*ShardedClientSessionCache).shards[0].q.Front().Value.(*lruSessionCacheEntry).state.session
+	session.maxEarlyData = 1024
+	if _, err := run(bytes.Repeat([]byte("a"), 100)); err == nil {
+		t.Error("server accepted more early data than the ticket allowed")
+	}
+}
+
+func TestServerMaxEarlyDataUnrecorded(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.EarlyData = true
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	serverConfig := testConfig.Clone()
+	serverConfig.EarlyData = true
+	serverConfig.EarlyDataAntiReplay = NewEarlyDataAntiReplay(10 * time.Second)
+	// A session which, like the tickets of older versions, doesn't record
+	// its max_early_data_size.
+	serverConfig.UnwrapSession = func(identity []byte, cs ConnectionState) (*SessionState, error) {
+		session, err := serverConfig.DecryptTicket(identity, cs)
+		if session != nil {
+			session.maxEarlyData = 0
+		}
+		return session, err
+	}
+
+	run := func(early []byte) (serverEarly []byte, err error) {
+		t.Helper()
+		c, s := localPipe(t)
+		defer c.Close()
+		defer s.Close()
+
+		done := make(chan error, 1)
+		srv := Server(s, serverConfig)
+		go func() {
+			var err error
+			serverEarly, err = io.ReadAll(readerFunc(srv.ReadEarlyData))
+			if err == nil {
+				err = srv.Handshake()
+			}
+			if err == nil {
+				_, err = srv.Write([]byte("hello"))
+			}
+			srv.Close()
+			done <- err
+		}()
+
+		cli := Client(c, clientConfig)
+		if early != nil {
+			if _, err := cli.WriteEarlyData(early); err != nil {
+				t.Fatalf("WriteEarlyData: %v", err)
+			}
+		}
+		io.ReadFull(cli, make([]byte, 5))
+		return serverEarly, <-done
+	}
+
+	if _, err := run(nil); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	early := bytes.Repeat([]byte("a"), 100)
+	if serverEarly, err := run(early); err != nil {
+		t.Fatalf("early data within the default limit: %v", err)
+	} else if !bytes.Equal(serverEarly, early) {
+		t.Errorf("server read early data %q, want %q", serverEarly, early)
+	}
+}
+
+func TestEarlyDataAntiReplay(t *testing.T) {
+	ar := NewEarlyDataAntiReplay(time.Second)
+	if ar.AcceptEarlyData([]byte("a"), 2*time.Second) {
+		t.Error("accepted early data with a large skew")
+	}
+	if ar.AcceptEarlyData([]byte("a"), -2*time.Second) {
+		t.Error("accepted early data with a large negative skew")
+	}
+	if !ar.AcceptEarlyData([]byte("a"), 0) {
+		t.Error("rejected fresh early data")
+	}
+	if ar.AcceptEarlyData([]byte("a"), 0) {
+		t.Error("accepted replayed early data")
+	}
+	if !ar.AcceptEarlyData([]byte("b"), -time.Second/2) {
+		t.Error("rejected fresh early data with a different binder")
+	}
+}
+
+func TestServerSingleUseTickets(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testServerSingleUseTickets(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testServerSingleUseTickets(t, VersionTLS13) })
+}
+
+func testServerSingleUseTickets(t *testing.T, version uint16) {
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = version
+	serverConfig.SingleUseTickets = true
+	serverConfig.ServerSessionCache = NewLRUServerSessionCache(0)
+	serverConfig.Rand = rand.Reader // ticket identities must be unique
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = version
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	testResume := func(want bool) {
+		t.Helper()
+		ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if ss.DidResume != want || cs.DidResume != want {
+			t.Fatalf("DidResume: server %v, client %v, want %v", ss.DidResume, cs.DidResume, want)
+		}
+	}
+	cachedEntry := func() lruSessionCacheEntry {
+		return *clientConfig.ClientSessionCache.(*ShardedClientSessionCache).shards[0].q.Front().Value.(*lruSessionCacheEntry)
+	}
+
+	testResume(false)
+	used := cachedEntry()
+	if len(used.state.session.ticket) != singleUseTicketLen {
+		t.Errorf("ticket length is %d, want %d", len(used.state.session.ticket), singleUseTicketLen)
+	}
+	testResume(true)
+	if cachedEntry().state == used.state {
+		t.Fatal("client did not receive a new ticket")
+	}
+
+	// Presenting the same ticket again must not resume.
+	clientConfig.ClientSessionCache.Put(used.sessionKey, used.state)
+	testResume(false)
+
+	// Without a cache, no tickets are issued.
+	serverConfig.ServerSessionCache = nil
+	testResume(false)
+	testResume(false)
+}
+
+func TestServerSessionTicketPolicy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+	serverConfig := testConfig.Clone()
+	serverConfig.Time = clock
+	serverConfig.Rand = rand.Reader
+	serverConfig.SessionTicketLifetime = time.Hour
+	clientConfig := testConfig.Clone()
+	clientConfig.Time = clock
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	testResume := func(want bool) *SessionState {
+		t.Helper()
+		ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if ss.DidResume != want || cs.DidResume != want {
+			t.Fatalf("DidResume: server %v, client %v, want %v", ss.DidResume, cs.DidResume, want)
+		}
+		front := clientConfig.ClientSessionCache.(*ShardedClientSessionCache).shards[0].q.Front()
+		return front.Value.(*lruSessionCacheEntry).state.session
+	}
+
+	session := testResume(false)
+	if lifetime := session.useBy - session.createdAt; lifetime != 3600 {
+		t.Errorf("ticket lifetime is %ds, want 3600s", lifetime)
+	}
+	if session.ageAdd == 0 {
+		t.Error("ticket_age_add is zero")
+	}
+
+	now = now.Add(50 * time.Minute)
+	testResume(true)
+	now = now.Add(61 * time.Minute)
+	testResume(false)
+
+	serverConfig.TicketAgeObfuscationDisabled = true
+	serverConfig.SessionTicketNonce = TicketNonceRandom
+	if session := testResume(true); session.ageAdd != 0 {
+		t.Errorf("ticket_age_add is %d, want zero", session.ageAdd)
+	}
+	testResume(true)
+}
+
+func TestServerSessionTicketCount(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(10)
+	serverConfig := testConfig.Clone()
+	serverConfig.Rand = rand.Reader
+	var issued int
+	serverConfig.SessionEvent = func(e SessionEvent) {
+		if e.Kind == SessionTicketIssued {
+			issued++
+		}
+	}
+
+	for _, tt := range []struct {
+		count     int
+		noResumed bool
+		resume    bool
+		want      int
+	}{
+		{count: 0, want: 1},
+		{count: 3, want: 3},
+		{count: 3, resume: true, want: 3},
+		{count: 3, noResumed: true, resume: true, want: 0},
+		{count: -1, want: 0},
+	} {
+		if !tt.resume {
+			clientConfig.ClientSessionCache = NewLRUClientSessionCache(10)
+		}
+		serverConfig.SessionTicketCount = tt.count
+		serverConfig.ResumptionTicketsDisabled = tt.noResumed
+		issued = 0
+		ss, _, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if ss.DidResume != tt.resume {
+			t.Errorf("count %d: DidResume = %v, want %v", tt.count, ss.DidResume, tt.resume)
+		}
+		if issued != tt.want {
+			t.Errorf("count %d, resumption tickets disabled %v: issued %d tickets, want %d",
+				tt.count, tt.noResumed, issued, tt.want)
+		}
+	}
+
+	// A TLS 1.2 resumption without a new ticket keeps the old one usable.
+	clientConfig.MaxVersion = VersionTLS12
+	serverConfig.SessionTicketCount = 0
+	serverConfig.ResumptionTicketsDisabled = true
+	for _, want := range []bool{false, true, true} {
+		if ss, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		} else if ss.DidResume != want {
+			t.Errorf("TLS 1.2: DidResume = %v, want %v", ss.DidResume, want)
+		}
+	}
+}
+
+func TestTicketNonceCounter(t *testing.T) {
+	c := &Conn{config: testConfig}
+	c.sessionTicketsSent = 0xfe
+	for _, want := range []string{"fe", "ff", "0100"} {
+		nonce, err := c.nextTicketNonce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%x", nonce); got != want {
+			t.Errorf("nonce %q, want %q", got, want)
+		}
+	}
+	c.sessionTicketsSent = 0
+	if nonce, _ := c.nextTicketNonce(); len(nonce) != 0 {
+		t.Errorf("first nonce is %x, want empty", nonce)
+	}
+}
+
+func TestServerApproveResumption(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testServerApproveResumption(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testServerApproveResumption(t, VersionTLS13) })
+}
+
+func testServerApproveResumption(t *testing.T, version uint16) {
+	approve := true
+	var calls int
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = version
+	serverConfig.ApproveResumption = func(ss *SessionState, chi *ClientHelloInfo) bool {
+		calls++
+		if ss == nil || chi == nil || chi.ServerName != "example.golang" {
+			t.Errorf("ApproveResumption called with %v, %v", ss, chi)
+		}
+		return approve
+	}
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = version
+	clientConfig.ServerName = "example.golang"
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	testResume := func(want bool) {
+		t.Helper()
+		ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if ss.DidResume != want || cs.DidResume != want {
+			t.Fatalf("DidResume: server %v, client %v, want %v", ss.DidResume, cs.DidResume, want)
+		}
+	}
+
+	testResume(false)
+	if calls != 0 {
+		t.Errorf("ApproveResumption called %d times without a session", calls)
+	}
+	testResume(true)
+	approve = false
+	testResume(false)
+	if calls != 2 {
+		t.Errorf("ApproveResumption called %d times, want 2", calls)
+	}
+}
+
+type failingTicketKeyStore struct{}
+
+func (failingTicketKeyStore) TicketKeys(context.Context) ([]TicketKey, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestServerTicketKeyStore(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testServerTicketKeyStore(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testServerTicketKeyStore(t, VersionTLS13) })
+}
+
+func testServerTicketKeyStore(t *testing.T, version uint16) {
+	now := time.Unix(1700000000, 0)
+	ring := &TicketKeyRing{}
+	var servers [2]*Config
+	for i := range servers {
+		servers[i] = testConfig.Clone()
+		servers[i].MaxVersion = version
+		servers[i].Time = func() time.Time { return now }
+		servers[i].TicketKeyStore = ring
+	}
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = version
+	clientConfig.Time = func() time.Time { return now }
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	testResume := func(serverConfig *Config, want bool) {
+		t.Helper()
+		ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if ss.DidResume != want || cs.DidResume != want {
+			t.Fatalf("DidResume: server %v, client %v, want %v", ss.DidResume, cs.DidResume, want)
+		}
+	}
+	setKeys := func(keys ...TicketKey) {
+		t.Helper()
+		if err := ring.SetTicketKeys(keys); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An empty ring issues no tickets.
+	testResume(servers[0], false)
+	testResume(servers[1], false)
+
+	key1 := TicketKey{ID: "1", Key: [32]byte{1}, NotAfter: now.Add(time.Hour)}
+	key2 := TicketKey{ID: "2", Key: [32]byte{2}, NotBefore: now.Add(time.Minute)}
+	setKeys(key1, key2)
+	testResume(servers[0], false)
+	testResume(servers[1], true)
+
+	// Once key2 is active, it encrypts the new tickets, and key1 still
+	// decrypts the ones issued before.
+	now = now.Add(5 * time.Minute)
+	testResume(servers[0], true)
+	setKeys(key2)
+	testResume(servers[1], true)
+
+	// Once key1 expires, its tickets are rejected.
+	setKeys(key1)
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	testResume(servers[0], false)
+	setKeys(key1, key2)
+	now = now.Add(time.Hour)
+	testResume(servers[1], false)
+
+	if err := ring.SetTicketKeys([]TicketKey{key1, key1}); err == nil {
+		t.Error("duplicate key IDs were accepted")
+	}
+	if err := ring.SetTicketKeys([]TicketKey{{ID: "3", NotBefore: now, NotAfter: now}}); err == nil {
+		t.Error("empty validity window was accepted")
+	}
+
+	servers[0].TicketKeyStore = failingTicketKeyStore{}
+	if _, _, err := testHandshake(t, clientConfig, servers[0]); err == nil {
+		t.Error("handshake succeeded despite the store error")
+	}
+}
+
+func TestTicketKeysSnapshot(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	config := &Config{Time: func() time.Time { return now }}
+
+	keys, err := config.ticketKeys(context.Background(), nil)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("got %d automatic ticket keys, %v; want 1", len(keys), err)
+	}
+	first := keys[0].aesKey
+	if allocs := testing.AllocsPerRun(100, func() {
+		config.ticketKeys(context.Background(), nil)
+	}); allocs != 0 {
+		t.Errorf("reading fresh ticket keys allocates %v times", allocs)
+	}
+
+	// Rotation publishes the new keys.
+	now = now.Add(ticketKeyRotation)
+	keys, _ = config.ticketKeys(context.Background(), nil)
+	if len(keys) != 2 || keys[0].aesKey == first || keys[1].aesKey != first {
+		t.Fatalf("got %d ticket keys after rotation, want the new key and the old one", len(keys))
+	}
+	if again, _ := config.ticketKeys(context.Background(), nil); &again[0] != &keys[0] {
+		t.Errorf("rotated ticket keys not reused")
+	}
+
+	// Explicit keys take effect at once.
+	config.SetSessionTicketKeys([][32]byte{{1}})
+	want := config.ticketKeyFromBytes([32]byte{1})
+	if keys, _ = config.ticketKeys(context.Background(), nil); len(keys) != 1 || keys[0].aesKey != want.aesKey {
+		t.Errorf("SetSessionTicketKeys did not replace the ticket keys")
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testSessionEvents(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testSessionEvents(t, VersionTLS13) })
+}
+
+func testSessionEvents(t *testing.T, version uint16) {
+	var mu sync.Mutex
+	var serverEvents, clientEvents []SessionEventKind
+	record := func(events *[]SessionEventKind) func(SessionEvent) {
+		return func(e SessionEvent) {
+			if e.Session == nil && (e.Kind != SessionResumptionRejected || events != &serverEvents) {
+				t.Errorf("event %d without a session", e.Kind)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			*events = append(*events, e.Kind)
+		}
+	}
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = version
+	serverConfig.SessionEvent = record(&serverEvents)
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = version
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	clientConfig.SessionEvent = record(&clientEvents)
+
+	check := func(server, client []SessionEventKind) {
+		t.Helper()
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(serverEvents, server) {
+			t.Errorf("server events %v, want %v", serverEvents, server)
+		}
+		if !reflect.DeepEqual(clientEvents, client) {
+			t.Errorf("client events %v, want %v", clientEvents, client)
+		}
+		serverEvents, clientEvents = nil, nil
+	}
+
+	check([]SessionEventKind{SessionTicketIssued}, []SessionEventKind{SessionTicketReceived})
+	check([]SessionEventKind{SessionResumed, SessionTicketIssued},
+		[]SessionEventKind{SessionResumed, SessionTicketReceived})
+	serverConfig.SetSessionTicketKeys([][32]byte{{1}})
+	check([]SessionEventKind{SessionResumptionRejected, SessionTicketIssued},
+		[]SessionEventKind{SessionResumptionRejected, SessionTicketReceived})
+}
+
+func TestHandshakeLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := &HandshakeLimiter{MaxHandshakes: 2, MaxHandshakesPerIP: 1, QueueTimeout: 10 * time.Millisecond}
+	releaseA, err := l.acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire(ctx, "a"); err != errHandshakeLimited {
+		t.Errorf("second handshake from the same IP: got %v, want %v", err, errHandshakeLimited)
+	}
+	releaseB, err := l.acquire(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire(ctx, "c"); err != errHandshakeLimited {
+		t.Errorf("handshake over the limit: got %v, want %v", err, errHandshakeLimited)
+	}
+	if n := l.InProgress(); n != 2 {
+		t.Errorf("InProgress() = %d, want 2", n)
+	}
+
+	// A queued handshake starts when a slot is released.
+	l.QueueTimeout = 0
+	done := make(chan error)
+	go func() {
+		release, err := l.acquire(ctx, "c")
+		if release != nil {
+			release()
+		}
+		done <- err
+	}()
+	releaseA()
+	releaseA() // releasing twice is a no-op
+	if err := <-done; err != nil {
+		t.Errorf("queued handshake: %v", err)
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.acquire(cctx, "b"); err != context.Canceled {
+		t.Errorf("queued handshake with a canceled context: got %v, want %v", err, context.Canceled)
+	}
+	releaseB()
+	if n := l.InProgress(); n != 0 {
+		t.Errorf("InProgress() = %d, want 0", n)
+	}
+
+	// Server handshakes go through the limiter.
+	l.MaxHandshakes, l.QueueTimeout = 1, 10*time.Millisecond
+	serverConfig := testConfig.Clone()
+	serverConfig.HandshakeLimiter = l
+	release, err := l.acquire(ctx, "z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, s := localPipe(t)
+	err = Server(s, serverConfig).Handshake()
+	c.Close()
+	s.Close()
+	if err != errHandshakeLimited {
+		t.Errorf("server handshake over the limit: got %v, want %v", err, errHandshakeLimited)
+	}
+	release()
+	if _, _, err := testHandshake(t, testConfig, serverConfig); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.InProgress(); n != 0 {
+		t.Errorf("InProgress() = %d after the handshake, want 0", n)
+	}
+}
+
 func TestFallbackSCSV(t *testing.T) {
 	serverConfig := Config{
 		Certificates: testConfig.Certificates,
@@ -1807,6 +2492,7 @@ func TestAESCipherReordering(t *testing.T) {
 		clientCiphers   []uint16
 		serverHasAESGCM bool
 		serverCiphers   []uint16
+		preference      AESGCMPreference
 		expectedCipher  uint16
 	}{
 		{
@@ -1819,6 +2505,39 @@ func TestAESCipherReordering(t *testing.T) {
 			serverHasAESGCM: true,
 			expectedCipher:  TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
 		},
+		{
+			name: "client doesn't have hardware AES, server always prefers AES (pick AES-GCM)",
+			clientCiphers: []uint16{
+				TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_RSA_WITH_AES_128_CBC_SHA,
+			},
+			serverHasAESGCM: false,
+			preference:      AESGCMPreferenceAlways,
+			expectedCipher:  TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			name: "client prefers AES-GCM, server has hardware AES but never prefers it (pick ChaCha)",
+			clientCiphers: []uint16{
+				TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				TLS_RSA_WITH_AES_128_CBC_SHA,
+			},
+			serverHasAESGCM: true,
+			preference:      AESGCMPreferenceNever,
+			expectedCipher:  TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		{
+			name: "client prefers AES-GCM, server doesn't have hardware AES but follows the client (pick AES-GCM)",
+			clientCiphers: []uint16{
+				TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				TLS_RSA_WITH_AES_128_CBC_SHA,
+			},
+			serverHasAESGCM: false,
+			preference:      AESGCMPreferencePeer,
+			expectedCipher:  TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
 		{
 			name: "client prefers AES-GCM, server doesn't have hardware AES (pick ChaCha)",
 			clientCiphers: []uint16{
@@ -1916,7 +2635,8 @@ func TestAESCipherReordering(t *testing.T) {
 			hs := &serverHandshakeState{
 				c: &Conn{
 					config: &Config{
-						CipherSuites: tc.serverCiphers,
+						CipherSuites:     tc.serverCiphers,
+						AESGCMPreference: tc.preference,
 					},
 					vers: VersionTLS12,
 				},
@@ -1949,6 +2669,7 @@ func TestAESCipherReorderingTLS13(t *testing.T) {
 		name            string
 		clientCiphers   []uint16
 		serverHasAESGCM bool
+		preference      AESGCMPreference
 		expectedCipher  uint16
 	}{
 		{
@@ -1960,6 +2681,46 @@ func TestAESCipherReorderingTLS13(t *testing.T) {
 			serverHasAESGCM: true,
 			expectedCipher:  TLS_CHACHA20_POLY1305_SHA256,
 		},
+		{
+			name: "neither server nor client have hardware AES, server always prefers AES (pick AES)",
+			clientCiphers: []uint16{
+				TLS_CHACHA20_POLY1305_SHA256,
+				TLS_AES_128_GCM_SHA256,
+			},
+			serverHasAESGCM: false,
+			preference:      AESGCMPreferenceAlways,
+			expectedCipher:  TLS_AES_128_GCM_SHA256,
+		},
+		{
+			name: "client prefers AES, server has hardware AES but never prefers it (pick ChaCha)",
+			clientCiphers: []uint16{
+				TLS_AES_128_GCM_SHA256,
+				TLS_CHACHA20_POLY1305_SHA256,
+			},
+			serverHasAESGCM: true,
+			preference:      AESGCMPreferenceNever,
+			expectedCipher:  TLS_CHACHA20_POLY1305_SHA256,
+		},
+		{
+			name: "client prefers AES, server doesn't have hardware AES but follows the client (pick AES)",
+			clientCiphers: []uint16{
+				TLS_AES_128_GCM_SHA256,
+				TLS_CHACHA20_POLY1305_SHA256,
+			},
+			serverHasAESGCM: false,
+			preference:      AESGCMPreferencePeer,
+			expectedCipher:  TLS_AES_128_GCM_SHA256,
+		},
+		{
+			name: "client prefers ChaCha, server has hardware AES but follows the client (pick ChaCha)",
+			clientCiphers: []uint16{
+				TLS_CHACHA20_POLY1305_SHA256,
+				TLS_AES_128_GCM_SHA256,
+			},
+			serverHasAESGCM: true,
+			preference:      AESGCMPreferencePeer,
+			expectedCipher:  TLS_CHACHA20_POLY1305_SHA256,
+		},
 		{
 			name: "neither server nor client have hardware AES (pick ChaCha)",
 			clientCiphers: []uint16{
@@ -2015,7 +2776,7 @@ func TestAESCipherReorderingTLS13(t *testing.T) {
 			pk, _ := ecdh.X25519().GenerateKey(rand.Reader)
 			hs := &serverHandshakeStateTLS13{
 				c: &Conn{
-					config: &Config{},
+					config: &Config{AESGCMPreference: tc.preference},
 					vers:   VersionTLS13,
 				},
 				clientHello: &clientHelloMsg{
@@ -2039,6 +2800,69 @@ func TestAESCipherReorderingTLS13(t *testing.T) {
 	}
 }
 
+func TestGetCipherSuitePreference(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		version   uint16
+		suites    []uint16
+		preferred []uint16
+		want      uint16
+	}{
+		{
+			name:      "TLS 1.2",
+			version:   VersionTLS12,
+			suites:    []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+			preferred: []uint16{TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+			want:      TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		{
+			name:      "TLS 1.3",
+			version:   VersionTLS13,
+			preferred: []uint16{TLS_AES_256_GCM_SHA384},
+			want:      TLS_AES_256_GCM_SHA384,
+		},
+		{
+			name:      "NotEnabled",
+			version:   VersionTLS12,
+			suites:    []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			preferred: []uint16{TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			want:      TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.CipherSuites = tt.suites
+			serverConfig := testConfig.Clone()
+			serverConfig.CipherSuites = tt.suites
+			var called int
+			serverConfig.GetCipherSuitePreference = func(chi *ClientHelloInfo) ([]uint16, error) {
+				called++
+				return tt.preferred, nil
+			}
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if called != 1 {
+				t.Errorf("GetCipherSuitePreference called %d times, want once", called)
+			}
+			if cs.CipherSuite != tt.want {
+				t.Errorf("got cipher suite %s, want %s", CipherSuiteName(cs.CipherSuite), CipherSuiteName(tt.want))
+			}
+		})
+	}
+
+	serverConfig := testConfig.Clone()
+	serverConfig.GetCipherSuitePreference = func(*ClientHelloInfo) ([]uint16, error) {
+		return nil, errors.New("no preference")
+	}
+	if _, _, err := testHandshake(t, testConfig, serverConfig); err == nil || !strings.Contains(err.Error(), "no preference") {
+		t.Errorf("got error %v, want the error of GetCipherSuitePreference", err)
+	}
+}
+
 // TestServerHandshakeContextCancellation tests that canceling
 // the context given to the server side conn.HandshakeContext
 // interrupts the in-progress handshake.
@@ -28,6 +28,12 @@ import (
 // messages cause too much work in session ticket decryption attempts.
 const maxClientPSKIdentities = 5
 
+// defaultMaxEarlyData is the max_early_data_size of the session tickets that
+// permit early data over TCP if Config.MaxEarlyData is zero. Servers also skip
+// at least this much rejected early data, even if they don't accept it
+// themselves.
+const defaultMaxEarlyData = 16384
+
 type echServerContext struct {
 	hpkeContext *hpke.Recipient
 	configID    uint8
@@ -41,16 +47,24 @@ type echServerContext struct {
 }
 
 type serverHandshakeStateTLS13 struct {
-	c               *Conn
-	ctx             context.Context
-	clientHello     *clientHelloMsg
-	hello           *serverHelloMsg
-	sentDummyCCS    bool
-	usingPSK        bool
-	earlyData       bool
-	suite           *cipherSuiteTLS13
-	cert            *Certificate
-	sigAlg          SignatureScheme
+	c            *Conn
+	ctx          context.Context
+	clientHello  *clientHelloMsg
+	hello        *serverHelloMsg
+	sentDummyCCS bool
+	usingPSK     bool
+	earlyData    bool
+	suite        *cipherSuiteTLS13
+	decoy        *decoyProfile
+	cert         *Certificate
+	sigAlg       SignatureScheme
+	// delegatedCredential is the delegated credential of cert signing the
+	// handshake, if any.
+	delegatedCredential *DelegatedCredential
+	// serverCertType and clientCertType are the negotiated types of the
+	// credentials sent by each peer, see RFC 7250.
+	serverCertType  CertificateType
+	clientCertType  CertificateType
 	earlySecret     *tls13EarlySecret
 	sharedKey       []byte
 	handshakeSecret *tls13HandshakeSecret
@@ -59,6 +73,18 @@ type serverHandshakeStateTLS13 struct {
 	transcript      hash.Hash
 	clientFinished  []byte
 	echContext      *echServerContext
+
+	// handshakeReadSecret is the client_handshake_traffic_secret, held back
+	// while reading 0-RTT data over TCP.
+	handshakeReadSecret []byte
+
+	// ticketMaxEarlyData is the max_early_data_size of the session ticket
+	// the client sent early data for, if the server could decrypt it.
+	ticketMaxEarlyData uint32
+
+	// keyExchangeDeferred is true if the client offered external PSKs with
+	// psk_ke, and sent no key share to use without a HelloRetryRequest.
+	keyExchangeDeferred bool
 }
 
 func (hs *serverHandshakeStateTLS13) handshake() error {
@@ -68,9 +94,33 @@ func (hs *serverHandshakeStateTLS13) handshake() error {
 	if err := hs.processClientHello(); err != nil {
 		return err
 	}
+	if err := hs.checkForExternalPSK(); err != nil {
+		return err
+	}
 	if err := hs.checkForResumption(); err != nil {
 		return err
 	}
+	if hs.keyExchangeDeferred && !hs.usingPSK {
+		// No external PSK was accepted for psk_ke, so the key exchange is
+		// needed after all, and the second ClientHello offers the PSKs anew.
+		hs.keyExchangeDeferred = false
+		if err := hs.selectKeyExchange(false); err != nil {
+			return err
+		}
+		if err := hs.checkForExternalPSK(); err != nil {
+			return err
+		}
+		if err := hs.checkForResumption(); err != nil {
+			return err
+		}
+	}
+	if !hs.usingPSK && len(hs.clientHello.pskIdentities) > 0 {
+		c.sessionEvent(SessionResumptionRejected, nil)
+	}
+	if hs.clientHello.earlyData && !hs.earlyData && c.quic == nil {
+		c.skipEarlyData = true
+		c.earlyDataLeft = hs.rejectedEarlyDataLimit()
+	}
 	if err := hs.pickCertificate(); err != nil {
 		return err
 	}
@@ -90,6 +140,24 @@ func (hs *serverHandshakeStateTLS13) handshake() error {
 	if _, err := c.flush(); err != nil {
 		return err
 	}
+	if hs.earlyData && c.quic == nil && c.pauseForEarlyData {
+		// Let ReadEarlyData return the 0-RTT data before the client's second
+		// flight arrives.
+		c.resumeHandshake = func(ctx context.Context) error {
+			hs.ctx = ctx
+			return hs.readClientFlight()
+		}
+		return nil
+	}
+	return hs.readClientFlight()
+}
+
+// readClientFlight reads the client's second flight and completes the
+// handshake.
+func (hs *serverHandshakeStateTLS13) readClientFlight() error {
+	if err := hs.readEndOfEarlyData(); err != nil {
+		return err
+	}
 	if err := hs.readClientCertificate(); err != nil {
 		return err
 	}
@@ -97,7 +165,7 @@ func (hs *serverHandshakeStateTLS13) handshake() error {
 		return err
 	}
 
-	c.isHandshakeComplete.Store(true)
+	hs.c.isHandshakeComplete.Store(true)
 
 	return nil
 }
@@ -155,29 +223,29 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 		return errors.New("tls: initial handshake had non-empty renegotiation extension")
 	}
 
-	if hs.clientHello.earlyData && c.quic != nil {
-		if len(hs.clientHello.pskIdentities) == 0 {
-			c.sendAlert(alertIllegalParameter)
-			return errors.New("tls: early_data without pre_shared_key")
-		}
-	} else if hs.clientHello.earlyData {
-		// See RFC 8446, Section 4.2.10 for the complicated behavior required
-		// here. The scenario is that a different server at our address offered
-		// to accept early data in the past, which we can't handle. For now, all
-		// 0-RTT enabled session tickets need to expire before a Go server can
-		// replace a server or join a pool. That's the same requirement that
-		// applies to mixing or replacing with any TLS 1.2 server.
-		c.sendAlert(alertUnsupportedExtension)
-		return errors.New("tls: client sent unexpected early data")
+	if hs.clientHello.earlyData && len(hs.clientHello.pskIdentities) == 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: early_data without pre_shared_key")
 	}
+	c.earlyDataOffered = hs.clientHello.earlyData
 
 	hs.hello.sessionId = hs.clientHello.sessionId
 	hs.hello.compressionMethod = compressionNone
 
 	preferenceList := defaultCipherSuitesTLS13
-	if !hasAESGCMHardwareSupport || !isAESGCMPreferred(hs.clientHello.cipherSuites) {
+	if !c.config.aesGCMPreferred(hs.clientHello.cipherSuites) {
 		preferenceList = defaultCipherSuitesTLS13NoAES
 	}
+	preferenceList = c.config.tls13CipherSuites(preferenceList, c.quic != nil)
+	preferenceList, err := c.cipherSuitePreference(hs.ctx, hs.clientHello, preferenceList)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+	hs.decoy = c.config.DecoyMirror.current()
+	if hs.decoy != nil && c.quic == nil {
+		preferenceList = preferFirst(preferenceList, hs.decoy.cipherSuite)
+	}
 	for _, suiteID := range preferenceList {
 		hs.suite = mutualCipherSuiteTLS13(hs.clientHello.cipherSuites, suiteID)
 		if hs.suite != nil {
@@ -193,6 +261,63 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 	hs.hello.cipherSuite = hs.suite.id
 	hs.transcript = hs.suite.hash.New()
 
+	if err := hs.selectKeyExchange(true); err != nil {
+		return err
+	}
+
+	selectedProto, err := negotiateALPN(c.config.NextProtos, hs.clientHello.alpnProtocols, c.quic != nil)
+	if err != nil {
+		c.sendAlert(alertNoApplicationProtocol)
+		return err
+	}
+	c.clientProtocol = selectedProto
+
+	var ok bool
+	hs.serverCertType, ok = negotiateCertificateType(c.config.ServerCertificateTypes, hs.clientHello.serverCertificateTypes)
+	if !ok {
+		c.sendAlert(alertUnsupportedCertificate)
+		return errors.New("tls: client doesn't support any configured server certificate type")
+	}
+	hs.clientCertType, ok = negotiateCertificateType(c.config.ClientCertificateTypes, hs.clientHello.clientCertificateTypes)
+	if !ok && hs.requestClientCert() {
+		c.sendAlert(alertUnsupportedCertificate)
+		return errors.New("tls: client doesn't support any configured client certificate type")
+	}
+
+	if c.quic != nil {
+		// RFC 9001 Section 4.2: Clients MUST NOT offer TLS versions older than 1.3.
+		for _, v := range hs.clientHello.supportedVersions {
+			if v < VersionTLS13 {
+				c.sendAlert(alertProtocolVersion)
+				return errors.New("tls: client offered TLS version older than TLS 1.3")
+			}
+		}
+		// RFC 9001 Section 8.2.
+		if hs.clientHello.quicTransportParameters == nil {
+			c.sendAlert(alertMissingExtension)
+			return errors.New("tls: client did not send a quic_transport_parameters extension")
+		}
+		c.quicSetTransportParameters(hs.clientHello.quicTransportParameters)
+	} else {
+		if hs.clientHello.quicTransportParameters != nil {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: client sent an unexpected quic_transport_parameters extension")
+		}
+	}
+
+	c.serverName = hs.clientHello.serverName
+	c.peerHelloExtensions = hs.clientHello.extensions
+	c.peerALPNProtocols = hs.clientHello.alpnProtocols
+	return nil
+}
+
+// selectKeyExchange selects the key exchange group, sending a
+// HelloRetryRequest if the client didn't send a key share for it. If mayDefer
+// is true and the client offered external PSKs with psk_ke, the key exchange
+// is instead deferred, see handshake.
+func (hs *serverHandshakeStateTLS13) selectKeyExchange(mayDefer bool) error {
+	c := hs.c
+
 	// First, if a post-quantum key exchange is available, use one. See
 	// draft-ietf-tls-key-share-prediction-01, Section 4 for why this must be
 	// first.
@@ -206,6 +331,10 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 		return !slicesContains(hs.clientHello.supportedCurves, group)
 	})
 	if len(preferredGroups) == 0 {
+		if mayDefer && hs.pskKEOffered() {
+			hs.keyExchangeDeferred = true
+			return nil
+		}
 		c.sendAlert(alertHandshakeFailure)
 		return errors.New("tls: no key exchanges supported by both client and server")
 	}
@@ -223,6 +352,11 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 	sort.SliceStable(preferredGroups, func(i, j int) bool {
 		return isPQKeyExchange(preferredGroups[i]) && !isPQKeyExchange(preferredGroups[j])
 	})
+	// The key share of the decoy changes the size of the ServerHello, but
+	// isn't worth a HelloRetryRequest.
+	if hs.decoy != nil && c.quic == nil && hasKeyShare(hs.decoy.curveID) {
+		preferredGroups = preferFirst(preferredGroups, hs.decoy.curveID)
+	}
 	selectedGroup := preferredGroups[0]
 
 	var clientKeyShare *keyShare
@@ -233,6 +367,12 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 		}
 	}
 	if clientKeyShare == nil {
+		// A HelloRetryRequest isn't needed if the client is up for psk_ke,
+		// unless no external PSK is accepted. See handshake.
+		if mayDefer && hs.pskKEOffered() {
+			hs.keyExchangeDeferred = true
+			return nil
+		}
 		ks, err := hs.doHelloRetryRequest(selectedGroup)
 		if err != nil {
 			return err
@@ -251,43 +391,36 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: invalid client key share")
 	}
+	return nil
+}
 
-	selectedProto, err := negotiateALPN(c.config.NextProtos, hs.clientHello.alpnProtocols, c.quic != nil)
-	if err != nil {
-		c.sendAlert(alertNoApplicationProtocol)
-		return err
-	}
-	c.clientProtocol = selectedProto
+// pskKEOffered reports whether the client offered external PSKs with the
+// psk_ke mode preferred by the server.
+func (hs *serverHandshakeStateTLS13) pskKEOffered() bool {
+	mode, ok := hs.externalPSKMode()
+	return ok && mode == PSKModeKE && len(hs.clientHello.pskIdentities) > 0
+}
 
-	if c.quic != nil {
-		// RFC 9001 Section 4.2: Clients MUST NOT offer TLS versions older than 1.3.
-		for _, v := range hs.clientHello.supportedVersions {
-			if v < VersionTLS13 {
-				c.sendAlert(alertProtocolVersion)
-				return errors.New("tls: client offered TLS version older than TLS 1.3")
-			}
-		}
-		// RFC 9001 Section 8.2.
-		if hs.clientHello.quicTransportParameters == nil {
-			c.sendAlert(alertMissingExtension)
-			return errors.New("tls: client did not send a quic_transport_parameters extension")
-		}
-		c.quicSetTransportParameters(hs.clientHello.quicTransportParameters)
-	} else {
-		if hs.clientHello.quicTransportParameters != nil {
-			c.sendAlert(alertUnsupportedExtension)
-			return errors.New("tls: client sent an unexpected quic_transport_parameters extension")
+// externalPSKMode returns the mode of the external PSKs, the first of
+// Config.ExternalPSKModes offered by the client.
+func (hs *serverHandshakeStateTLS13) externalPSKMode() (PSKMode, bool) {
+	c := hs.c
+	if len(c.config.ExternalPSKs) == 0 && c.config.GetExternalPSK == nil {
+		return 0, false
+	}
+	for _, m := range c.config.externalPSKModes() {
+		if slicesContains(hs.clientHello.pskModes, uint8(m)) {
+			return m, true
 		}
 	}
-
-	c.serverName = hs.clientHello.serverName
-	return nil
+	return 0, false
 }
 
 func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 	c := hs.c
 
-	if c.config.SessionTicketsDisabled {
+	// Resumption uses psk_dhe_ke, which needs the key exchange.
+	if c.config.SessionTicketsDisabled || hs.usingPSK || hs.keyExchangeDeferred {
 		return nil
 	}
 
@@ -325,6 +458,11 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 			if sessionState == nil {
 				continue
 			}
+		} else if c.config.SingleUseTickets {
+			sessionState = c.config.takeSingleUseSession(identity.label)
+			if sessionState == nil {
+				continue
+			}
 		} else {
 			plaintext := c.config.decryptTicket(identity.label, c.ticketKeys)
 			if plaintext == nil {
@@ -342,7 +480,7 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 		}
 
 		createdAt := time.Unix(int64(sessionState.createdAt), 0)
-		if c.config.time().Sub(createdAt) > maxSessionTicketLifetime {
+		if c.config.time().Sub(createdAt) > c.config.sessionTicketLifetime() {
 			continue
 		}
 
@@ -371,7 +509,12 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 		}
 		if sessionHasClientCerts && c.config.ClientAuth >= VerifyClientCertIfGiven &&
-			!anyValidVerifiedChain(sessionState.verifiedChains, opts) {
+			!c.config.resumedChainsValid(sessionState.peerCertificates, sessionState.verifiedChains, opts) {
+			continue
+		}
+
+		if c.config.ApproveResumption != nil &&
+			!c.config.ApproveResumption(sessionState, clientHelloInfo(hs.ctx, c, hs.clientHello)) {
 			continue
 		}
 
@@ -401,19 +544,41 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 			return errors.New("tls: invalid PSK binder")
 		}
 
-		if c.quic != nil && hs.clientHello.earlyData && i == 0 &&
+		// Sessions encoded without the early data fields, such as the
+		// tickets issued by older versions, allow the current MaxEarlyData.
+		maxEarlyData := sessionState.maxEarlyData
+		if maxEarlyData == 0 {
+			maxEarlyData = c.config.maxEarlyData()
+		}
+		if hs.clientHello.earlyData && i == 0 && sessionState.EarlyData {
+			hs.ticketMaxEarlyData = maxEarlyData
+		}
+		if hs.clientHello.earlyData && i == 0 &&
 			sessionState.EarlyData && sessionState.cipherSuite == hs.suite.id &&
-			sessionState.alpnProtocol == c.clientProtocol {
+			sessionState.alpnProtocol == c.clientProtocol &&
+			hs.acceptEarlyData(sessionState, identity, hs.clientHello.pskBinders[i]) {
 			hs.earlyData = true
+			c.earlyDataAccepted = true
 
 			transcript := hs.suite.hash.New()
 			if err := transcriptMsg(hs.clientHello, transcript); err != nil {
 				return err
 			}
 			earlyTrafficSecret := hs.earlySecret.ClientEarlyTrafficSecret(transcript)
-			if err := c.quicSetReadSecret(QUICEncryptionLevelEarly, hs.suite.id, earlyTrafficSecret); err != nil {
+			if err := c.writeKeyLog(keyLogLabelClientEarly, hs.clientHello.random, earlyTrafficSecret); err != nil {
+				c.sendAlert(alertInternalError)
 				return err
 			}
+			if c.quic != nil {
+				if err := c.quicSetReadSecret(QUICEncryptionLevelEarly, hs.suite.id, earlyTrafficSecret); err != nil {
+					return err
+				}
+			} else {
+				if err := c.setReadTrafficSecret(hs.suite, QUICEncryptionLevelEarly, earlyTrafficSecret, false); err != nil {
+					return err
+				}
+				c.earlyDataLeft = int(maxEarlyData)
+			}
 		}
 
 		c.didResume = true
@@ -425,12 +590,103 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 		hs.hello.selectedIdentityPresent = true
 		hs.hello.selectedIdentity = uint16(i)
 		hs.usingPSK = true
+		c.sessionEvent(SessionResumed, sessionState)
 		return nil
 	}
 
 	return nil
 }
 
+// checkForExternalPSK looks for an imported external PSK among the identities
+// offered by the client, see RFC 9258.
+func (hs *serverHandshakeStateTLS13) checkForExternalPSK() error {
+	c := hs.c
+
+	mode, ok := hs.externalPSKMode()
+	if !ok {
+		return nil
+	}
+	if len(hs.clientHello.pskIdentities) != len(hs.clientHello.pskBinders) {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: invalid or missing PSK binders")
+	}
+
+	for i, identity := range hs.clientHello.pskIdentities {
+		if i >= maxClientPSKIdentities {
+			break
+		}
+
+		var id importedIdentity
+		if !id.unmarshal(identity.label) || id.targetProtocol != VersionTLS13 ||
+			id.targetKDF == 0 || id.targetKDF != kdfForHash(hs.suite.hash) {
+			continue
+		}
+		psk, err := c.config.getExternalPSK(id.externalIdentity, id.context)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return c.callbackError(err)
+		}
+		if psk == nil || kdfForHash(tls13Hash(psk.hash())) == 0 {
+			continue
+		}
+
+		earlySecret := tls13NewEarlySecret(hs.suite.hash.New, importPSK(psk, identity.label, hs.suite.hash))
+		binderKey := earlySecret.ImportedBinderKey()
+		// Clone the transcript in case a HelloRetryRequest was recorded.
+		transcript := cloneHash(hs.transcript, hs.suite.hash)
+		if transcript == nil {
+			c.sendAlert(alertInternalError)
+			return errors.New("tls: internal error: failed to clone hash")
+		}
+		clientHelloBytes, err := hs.clientHello.marshalWithoutBinders()
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+		transcript.Write(clientHelloBytes)
+		pskBinder := hs.suite.finishedHash(binderKey, transcript)
+		if !hmac.Equal(hs.clientHello.pskBinders[i], pskBinder) {
+			c.sendAlert(alertDecryptError)
+			return errors.New("tls: invalid PSK binder")
+		}
+
+		hs.earlySecret = earlySecret
+		c.externalPSKIdentity = psk.Identity
+		if mode == PSKModeKE {
+			// The handshake secret is derived from the PSK alone, and the
+			// ServerHello carries no key_share. See RFC 8446, Section 7.1.
+			hs.sharedKey = nil
+			hs.hello.serverShare = keyShare{}
+			c.curveID = 0
+		}
+
+		hs.hello.selectedIdentityPresent = true
+		hs.hello.selectedIdentity = uint16(i)
+		hs.usingPSK = true
+		return nil
+	}
+
+	return nil
+}
+
+// acceptEarlyData reports whether the 0-RTT data sent with the PSK identity
+// of session can be accepted. QUIC leaves anti-replay to the application.
+func (hs *serverHandshakeStateTLS13) acceptEarlyData(session *SessionState, identity pskIdentity, binder []byte) bool {
+	c := hs.c
+	if c.quic != nil {
+		return true
+	}
+	if !c.config.EarlyData || c.config.EarlyDataAntiReplay == nil {
+		return false
+	}
+
+	// See RFC 8446, Section 8.3.
+	createdAt := time.Unix(int64(session.createdAt), 0)
+	age := c.config.time().Sub(createdAt)
+	clientAge := time.Duration(identity.obfuscatedTicketAge-session.ageAdd) * time.Millisecond
+	return c.config.EarlyDataAntiReplay.AcceptEarlyData(binder, clientAge-age)
+}
+
 type hashCloner interface {
 	hash.Hash
 	Clone() (hashCloner, error)
@@ -441,7 +697,7 @@ type hashCloner interface {
 // [encoding.BinaryMarshaler] and [encoding.BinaryUnmarshaler]
 // interfaces implemented by standard library hashes to clone the state of in
 // to a new instance of h. It returns nil if the operation fails.
-func cloneHash(in hash.Hash, h crypto.Hash) hash.Hash {
+func cloneHash(in hash.Hash, h interface{ New() hash.Hash }) hash.Hash {
 	if cloner, ok := in.(hashCloner); ok {
 		if out, err := cloner.Clone(); err == nil {
 			return out
@@ -488,10 +744,20 @@ func (hs *serverHandshakeStateTLS13) pickCertificate() error {
 	if err != nil {
 		if err == errNoCertificates {
 			c.sendAlert(alertUnrecognizedName)
-		} else {
-			c.sendAlert(alertInternalError)
+			return err
 		}
-		return err
+		// Those of GetCertificate and GetCertificateForHello.
+		c.sendAlert(alertInternalError)
+		return c.callbackError(err)
+	}
+	hs.cert = c.config.stapleCertificate(certificate)
+	// A raw public key is sent without the certificate, which can't delegate.
+	if hs.serverCertType != CertificateTypeRawPublicKey {
+		hs.delegatedCredential = hs.pickDelegatedCredential()
+	}
+	if hs.delegatedCredential != nil {
+		hs.sigAlg = hs.delegatedCredential.Scheme
+		return nil
 	}
 	hs.sigAlg, err = selectSignatureScheme(c.vers, certificate, hs.clientHello.supportedSignatureAlgorithms)
 	if err != nil {
@@ -500,11 +766,33 @@ func (hs *serverHandshakeStateTLS13) pickCertificate() error {
 		c.sendAlert(alertHandshakeFailure)
 		return err
 	}
-	hs.cert = certificate
 
 	return nil
 }
 
+// pickDelegatedCredential returns the first delegated credential of hs.cert
+// that is valid and acceptable to the client, or nil.
+func (hs *serverHandshakeStateTLS13) pickDelegatedCredential() *DelegatedCredential {
+	if len(hs.clientHello.delegatedCredentialSchemes) == 0 || len(hs.cert.DelegatedCredentials) == 0 {
+		return nil
+	}
+	leaf, err := hs.cert.leaf()
+	if err != nil {
+		return nil
+	}
+	now := hs.c.config.time()
+	for _, dc := range hs.cert.DelegatedCredentials {
+		if dc.PrivateKey == nil || !now.Before(dc.NotAfter(leaf)) {
+			continue
+		}
+		if isSupportedSignatureAlgorithm(dc.Scheme, hs.clientHello.delegatedCredentialSchemes) &&
+			isSupportedSignatureAlgorithm(dc.Algorithm, hs.clientHello.supportedSignatureAlgorithms) {
+			return dc
+		}
+	}
+	return nil
+}
+
 // sendDummyChangeCipherSpec sends a ChangeCipherSpec record for compatibility
 // with middleboxes that didn't implement TLS correctly. See RFC 8446, Appendix D.4.
 func (hs *serverHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
@@ -570,11 +858,19 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 	if _, err := hs.c.writeHandshakeRecord(helloRetryRequest, hs.transcript); err != nil {
 		return nil, err
 	}
+	c.trace.helloRetryRequest()
 
 	if err := hs.sendDummyChangeCipherSpec(); err != nil {
 		return nil, err
 	}
 
+	// Any 0-RTT data sent with the first ClientHello is rejected, and comes
+	// before the second ClientHello. See RFC 8446, Section 4.2.10.
+	if hs.clientHello.earlyData && c.quic == nil {
+		c.skipEarlyData = true
+		c.earlyDataLeft = hs.rejectedEarlyDataLimit()
+	}
+
 	// clientHelloMsg is not included in the transcript.
 	msg, err := c.readHandshake(nil)
 	if err != nil {
@@ -586,6 +882,13 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 		c.sendAlert(alertUnexpectedMessage)
 		return nil, unexpectedMessageError(clientHello, msg)
 	}
+	c.trace.helloReceived()
+
+	// Replaying the first ClientHello doesn't authenticate the second one.
+	if c.covertPayload != nil && c.config.CovertAuth != nil && !bytes.Equal(c.verifyCovertAuth(clientHello), c.covertPayload) {
+		c.sendAlert(alertIllegalParameter)
+		return nil, errors.New("tls: second client hello not authenticated by Config.CovertAuth")
+	}
 
 	if hs.echContext != nil {
 		if len(clientHello.encryptedClientHello) == 0 {
@@ -738,10 +1041,14 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, hs.transcript); err != nil {
 		return err
 	}
+	c.trace.helloSent()
 
 	if err := hs.sendDummyChangeCipherSpec(); err != nil {
 		return err
 	}
+	if c.quic == nil {
+		c.decoyFlight = newDecoyFlight(hs.decoy)
+	}
 
 	earlySecret := hs.earlySecret
 	if earlySecret == nil {
@@ -752,7 +1059,9 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 	serverSecret := hs.handshakeSecret.ServerHandshakeTrafficSecret(hs.transcript)
 	c.setWriteTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, serverSecret)
 	clientSecret := hs.handshakeSecret.ClientHandshakeTrafficSecret(hs.transcript)
-	if err := c.setReadTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret, false); err != nil {
+	if hs.earlyData && c.quic == nil {
+		hs.handshakeReadSecret = clientSecret
+	} else if err := c.setReadTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret, false); err != nil {
 		return err
 	}
 
@@ -763,12 +1072,12 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 		}
 	}
 
-	err := c.config.writeKeyLog(keyLogLabelClientHandshake, hs.clientHello.random, clientSecret)
+	err := c.writeKeyLog(keyLogLabelClientHandshake, hs.clientHello.random, clientSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
-	err = c.config.writeKeyLog(keyLogLabelServerHandshake, hs.clientHello.random, serverSecret)
+	err = c.writeKeyLog(keyLogLabelServerHandshake, hs.clientHello.random, serverSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
@@ -783,12 +1092,24 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 			return err
 		}
 		encryptedExtensions.quicTransportParameters = p
-		encryptedExtensions.earlyData = hs.earlyData
+	}
+	encryptedExtensions.earlyData = hs.earlyData
+	if hs.clientHello.recordSizeLimit != 0 && c.quic == nil {
+		encryptedExtensions.recordSizeLimit = c.config.recordSizeLimit(c.vers)
+		if err := c.setRecordSizeLimits(encryptedExtensions.recordSizeLimit, hs.clientHello.recordSizeLimit); err != nil {
+			return err
+		}
 	}
 
 	if !hs.c.didResume && hs.clientHello.serverName != "" {
 		encryptedExtensions.serverNameAck = true
 	}
+	if !hs.usingPSK && hs.serverCertType != CertificateTypeX509 {
+		encryptedExtensions.serverCertificateType = uint8(hs.serverCertType)
+	}
+	if hs.requestClientCert() && hs.clientCertType != CertificateTypeX509 {
+		encryptedExtensions.clientCertificateType = uint8(hs.clientCertType)
+	}
 
 	// If client sent ECH extension, but we didn't accept it,
 	// send retry configs, if available.
@@ -797,7 +1118,7 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 		echKeys, err = hs.c.config.GetEncryptedClientHelloKeys(clientHelloInfo(hs.ctx, c, hs.clientHello))
 		if err != nil {
 			c.sendAlert(alertInternalError)
-			return err
+			return c.callbackError(err)
 		}
 	}
 	if len(echKeys) > 0 && len(hs.clientHello.encryptedClientHello) > 0 && hs.echContext == nil {
@@ -832,11 +1153,12 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 		certReq := new(certificateRequestMsgTLS13)
 		certReq.ocspStapling = true
 		certReq.scts = true
-		certReq.supportedSignatureAlgorithms = supportedSignatureAlgorithms(c.vers)
-		certReq.supportedSignatureAlgorithmsCert = supportedSignatureAlgorithmsCert()
+		certReq.supportedSignatureAlgorithms = c.config.supportedSignatureAlgorithms(c.vers)
+		certReq.supportedSignatureAlgorithmsCert = c.config.certificateSignatureSchemes()
 		if c.config.ClientCAs != nil {
 			certReq.certificateAuthorities = c.config.ClientCAs.Subjects()
 		}
+		certReq.certCompressionAlgorithms = c.config.certCompressionAlgorithms()
 
 		if _, err := hs.c.writeHandshakeRecord(certReq, hs.transcript); err != nil {
 			return err
@@ -845,11 +1167,31 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 
 	certMsg := new(certificateMsgTLS13)
 
-	certMsg.certificate = *hs.cert
-	certMsg.scts = hs.clientHello.scts && len(hs.cert.SignedCertificateTimestamps) > 0
-	certMsg.ocspStapling = hs.clientHello.ocspStapling && len(hs.cert.OCSPStaple) > 0
+	if hs.serverCertType == CertificateTypeRawPublicKey {
+		spki, err := rawPublicKey(hs.cert)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+		certMsg.certificate = Certificate{Certificate: [][]byte{spki}}
+	} else {
+		certMsg.certificate = *hs.cert
+		certMsg.scts = hs.clientHello.scts && len(hs.cert.SignedCertificateTimestamps) > 0
+		certMsg.ocspStapling = hs.clientHello.ocspStapling && len(hs.cert.OCSPStaple) > 0
+	}
+	var signer crypto.Signer
+	if hs.delegatedCredential != nil {
+		certMsg.delegatedCredential = hs.delegatedCredential.Raw
+		signer = hs.delegatedCredential.PrivateKey
+	} else {
+		signer = hs.cert.PrivateKey.(crypto.Signer)
+	}
 
-	if _, err := hs.c.writeHandshakeRecord(certMsg, hs.transcript); err != nil {
+	msg, err := c.compressCertificate(certMsg, hs.clientHello.certCompressionAlgorithms)
+	if err != nil {
+		return err
+	}
+	if _, err := hs.c.writeHandshakeRecord(msg, hs.transcript); err != nil {
 		return err
 	}
 
@@ -866,10 +1208,12 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 	signOpts := crypto.SignerOpts(sigHash)
 	if sigType == signatureRSAPSS {
 		signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
+	} else if sigType == signatureSM2 {
+		signOpts = sm2SignerOpts
 	}
-	sig, err := cryptoSignMessage(hs.cert.PrivateKey.(crypto.Signer), c.config.rand(), signed, signOpts)
+	sig, err := signContext(hs.ctx, signer, c.config.rand(), signed, signOpts)
 	if err != nil {
-		public := hs.cert.PrivateKey.(crypto.Signer).Public()
+		public := signer.Public()
 		if rsaKey, ok := public.(*rsa.PublicKey); ok && sigType == signatureRSAPSS &&
 			rsaKey.N.BitLen()/8 < sigHash.Size()*2+2 { // key too small for RSA-PSS
 			c.sendAlert(alertHandshakeFailure)
@@ -894,7 +1238,12 @@ func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
 		verifyData: hs.suite.finishedHash(c.out.trafficSecret, hs.transcript),
 	}
 
-	if _, err := hs.c.writeHandshakeRecord(finished, hs.transcript); err != nil {
+	if c.decoyFlight != nil {
+		c.decoyFlight.last = true
+	}
+	_, err := hs.c.writeHandshakeRecord(finished, hs.transcript)
+	c.decoyFlight = nil
+	if err != nil {
 		return err
 	}
 
@@ -910,12 +1259,12 @@ func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
 		c.quicSetWriteSecret(QUICEncryptionLevelApplication, hs.suite.id, serverSecret)
 	}
 
-	err := c.config.writeKeyLog(keyLogLabelClientTraffic, hs.clientHello.random, hs.trafficSecret)
+	err = c.writeKeyLog(keyLogLabelClientTraffic, hs.clientHello.random, hs.trafficSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
-	err = c.config.writeKeyLog(keyLogLabelServerTraffic, hs.clientHello.random, serverSecret)
+	err = c.writeKeyLog(keyLogLabelServerTraffic, hs.clientHello.random, serverSecret)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
@@ -925,8 +1274,9 @@ func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
 
 	// If we did not request client certificates, at this point we can
 	// precompute the client finished and roll the transcript forward to send
-	// session tickets in our first flight.
-	if !hs.requestClientCert() {
+	// session tickets in our first flight. That's not possible if the client
+	// still owes us an EndOfEarlyData message.
+	if !hs.requestClientCert() && !(hs.earlyData && c.quic == nil) {
 		if err := hs.sendSessionTickets(); err != nil {
 			return err
 		}
@@ -936,7 +1286,8 @@ func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
 }
 
 func (hs *serverHandshakeStateTLS13) shouldSendSessionTickets() bool {
-	if hs.c.config.SessionTicketsDisabled {
+	if hs.c.config.SessionTicketsDisabled || hs.c.config.singleUseTicketsUnavailable() ||
+		hs.c.ticketKeysUnavailable() {
 		return false
 	}
 
@@ -945,6 +1296,16 @@ func (hs *serverHandshakeStateTLS13) shouldSendSessionTickets() bool {
 		return false
 	}
 
+	if hs.c.didResume && hs.c.config.ResumptionTicketsDisabled {
+		return false
+	}
+
+	// Sessions don't record raw public keys, so the client would resume
+	// without its identity.
+	if hs.c.peerRawPublicKey != nil {
+		return false
+	}
+
 	// Don't send tickets the client wouldn't use. See RFC 8446, Section 4.2.9.
 	return slicesContains(hs.clientHello.pskModes, pskModeDHE)
 }
@@ -965,7 +1326,13 @@ func (hs *serverHandshakeStateTLS13) sendSessionTickets() error {
 	if !hs.shouldSendSessionTickets() {
 		return nil
 	}
-	return c.sendSessionTicket(false, nil)
+	earlyData := c.config.EarlyData && c.config.EarlyDataAntiReplay != nil
+	for i := 0; i < c.config.sessionTicketCount(); i++ {
+		if err := c.sendSessionTicket(earlyData, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (c *Conn) sendSessionTicket(earlyData bool, extra [][]byte) error {
@@ -973,23 +1340,48 @@ func (c *Conn) sendSessionTicket(earlyData bool, extra [][]byte) error {
 	if suite == nil {
 		return errors.New("tls: internal error: unknown cipher suite")
 	}
-	// ticket_nonce, which must be unique per connection, is always left at
-	// zero because we only ever send one ticket per connection.
+	m := new(newSessionTicketMsgTLS13)
+
+	// ticket_nonce must be unique per connection.
+	var err error
+	if m.nonce, err = c.nextTicketNonce(); err != nil {
+		return err
+	}
 	psk := tls13ExpandLabel(suite.hash.New, c.resumptionSecret, "resumption",
-		nil, suite.hash.Size())
+		m.nonce, suite.hash.Size())
 
-	m := new(newSessionTicketMsgTLS13)
+	// ticket_age_add is a random 32-bit value. See RFC 8446, section 4.6.1
+	// It is stored in the ticket to check the freshness of 0-RTT data.
+	if !c.config.TicketAgeObfuscationDisabled {
+		ageAdd := make([]byte, 4)
+		if _, err := c.config.rand().Read(ageAdd); err != nil {
+			return err
+		}
+		m.ageAdd = binary.LittleEndian.Uint32(ageAdd)
+	}
 
 	state := c.sessionState()
 	state.secret = psk
 	state.EarlyData = earlyData
 	state.Extra = extra
+	state.ageAdd = m.ageAdd
+	if earlyData && c.quic != nil {
+		// RFC 9001, Section 4.6.1
+		m.maxEarlyData = 0xffffffff
+	} else if earlyData {
+		m.maxEarlyData = c.config.maxEarlyData()
+	}
+	state.maxEarlyData = m.maxEarlyData
 	if c.config.WrapSession != nil {
-		var err error
 		m.label, err = c.config.WrapSession(c.connectionStateLocked(), state)
 		if err != nil {
 			return err
 		}
+	} else if c.config.SingleUseTickets {
+		m.label, err = c.config.storeSingleUseSession(state)
+		if err != nil {
+			return err
+		}
 	} else {
 		stateBytes, err := state.Bytes()
 		if err != nil {
@@ -1001,26 +1393,59 @@ func (c *Conn) sendSessionTicket(earlyData bool, extra [][]byte) error {
 			return err
 		}
 	}
-	m.lifetime = uint32(maxSessionTicketLifetime / time.Second)
+	m.lifetime = uint32(c.config.sessionTicketLifetime() / time.Second)
 
-	// ticket_age_add is a random 32-bit value. See RFC 8446, section 4.6.1
-	// The value is not stored anywhere; we never need to check the ticket age
-	// because 0-RTT is not supported.
-	ageAdd := make([]byte, 4)
-	if _, err := c.config.rand().Read(ageAdd); err != nil {
+	if _, err := c.writeHandshakeRecord(m, nil); err != nil {
 		return err
 	}
-	m.ageAdd = binary.LittleEndian.Uint32(ageAdd)
+	c.sessionEvent(SessionTicketIssued, state)
 
-	if earlyData {
-		// RFC 9001, Section 4.6.1
-		m.maxEarlyData = 0xffffffff
+	return nil
+}
+
+// rejectedEarlyDataLimit returns how much rejected 0-RTT data the server skips
+// before giving up on the connection: what the client's session ticket allowed
+// if it could be decrypted, and otherwise the most current tickets allow.
+func (hs *serverHandshakeStateTLS13) rejectedEarlyDataLimit() int {
+	if hs.ticketMaxEarlyData != 0 {
+		return int(hs.ticketMaxEarlyData)
+	}
+	limit := hs.c.config.maxEarlyData()
+	if limit < defaultMaxEarlyData {
+		limit = defaultMaxEarlyData
 	}
+	return int(limit)
+}
 
-	if _, err := c.writeHandshakeRecord(m, nil); err != nil {
+// readEndOfEarlyData reads the EndOfEarlyData message that ends the accepted
+// 0-RTT data, which the record layer buffers for ReadEarlyData, and switches
+// to the handshake traffic keys. See RFC 8446, Section 4.5.
+func (hs *serverHandshakeStateTLS13) readEndOfEarlyData() error {
+	c := hs.c
+
+	if !hs.earlyData || c.quic != nil {
+		return nil
+	}
+
+	msg, err := c.readHandshake(hs.transcript)
+	if err != nil {
+		return err
+	}
+
+	endOfEarlyData, ok := msg.(*endOfEarlyDataMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(endOfEarlyData, msg)
+	}
+
+	if err := c.setReadTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, hs.handshakeReadSecret, false); err != nil {
 		return err
 	}
 
+	// Session tickets had to wait for EndOfEarlyData to be in the transcript.
+	if !hs.requestClientCert() {
+		return hs.sendSessionTickets()
+	}
 	return nil
 }
 
@@ -1033,7 +1458,7 @@ func (hs *serverHandshakeStateTLS13) readClientCertificate() error {
 		if c.config.VerifyConnection != nil {
 			if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
 				c.sendAlert(alertBadCertificate)
-				return err
+				return c.callbackError(err)
 			}
 		}
 		return nil
@@ -1047,24 +1472,45 @@ func (hs *serverHandshakeStateTLS13) readClientCertificate() error {
 		return err
 	}
 
+	if compressed, ok := msg.(*compressedCertificateMsg); ok {
+		msg, err = c.decompressCertificate(compressed)
+		if err != nil {
+			return err
+		}
+	}
+
 	certMsg, ok := msg.(*certificateMsgTLS13)
 	if !ok {
 		c.sendAlert(alertUnexpectedMessage)
 		return unexpectedMessageError(certMsg, msg)
 	}
+	if len(certMsg.requestContext) != 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: client sent a Certificate with a non-empty context during the handshake")
+	}
 
-	if err := c.processCertsFromClient(certMsg.certificate); err != nil {
-		return err
+	var pub crypto.PublicKey
+	if hs.clientCertType == CertificateTypeRawPublicKey {
+		if pub, err = c.processPeerRawPublicKey(certMsg.certificate.Certificate); err != nil {
+			return err
+		}
+	} else {
+		if err := c.processCertsFromClient(hs.ctx, certMsg.certificate); err != nil {
+			return err
+		}
+		if len(c.peerCertificates) > 0 {
+			pub = c.peerCertificates[0].PublicKey
+		}
 	}
 
 	if c.config.VerifyConnection != nil {
 		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
 			c.sendAlert(alertBadCertificate)
-			return err
+			return c.callbackError(err)
 		}
 	}
 
-	if len(certMsg.certificate.Certificate) != 0 {
+	if pub != nil {
 		// certificateVerifyMsg is included in the transcript, but not until
 		// after we verify the handshake signature, since the state before
 		// this message was sent is used.
@@ -1079,28 +1525,9 @@ func (hs *serverHandshakeStateTLS13) readClientCertificate() error {
 			return unexpectedMessageError(certVerify, msg)
 		}
 
-		// See RFC 8446, Section 4.4.3.
-		// We don't use certReq.supportedSignatureAlgorithms because it would
-		// require keeping the certificateRequestMsgTLS13 around in the hs.
-		if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, supportedSignatureAlgorithms(c.vers)) ||
-			!isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, signatureSchemesForPublicKey(c.vers, c.peerCertificates[0].PublicKey)) {
-			c.sendAlert(alertIllegalParameter)
-			return errors.New("tls: client certificate used with invalid signature algorithm")
-		}
-		sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
-		if err != nil {
-			return c.sendAlert(alertInternalError)
-		}
-		if sigType == signaturePKCS1v15 || sigHash == crypto.SHA1 {
-			return c.sendAlert(alertInternalError)
-		}
-		signed := signedMessage(clientSignatureContext, hs.transcript)
-		if err := verifyHandshakeSignature(sigType, c.peerCertificates[0].PublicKey,
-			sigHash, signed, certVerify.signature); err != nil {
-			c.sendAlert(alertDecryptError)
-			return errors.New("tls: invalid signature by the client certificate: " + err.Error())
+		if err := c.verifyClientCertificateVerify(pub, certVerify, hs.transcript); err != nil {
+			return err
 		}
-		c.peerSigAlg = certVerify.signatureAlgorithm
 
 		if err := transcriptMsg(certVerify, hs.transcript); err != nil {
 			return err
@@ -1116,6 +1543,34 @@ func (hs *serverHandshakeStateTLS13) readClientCertificate() error {
 	return nil
 }
 
+// verifyClientCertificateVerify checks the signature of certVerify by pub over
+// transcript, which doesn't include certVerify yet.
+func (c *Conn) verifyClientCertificateVerify(pub crypto.PublicKey, certVerify *certificateVerifyMsg, transcript hash.Hash) error {
+	// See RFC 8446, Section 4.4.3.
+	// We don't use certReq.supportedSignatureAlgorithms because it would
+	// require keeping the certificateRequestMsgTLS13 around in the hs.
+	if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, c.config.supportedSignatureAlgorithms(c.vers)) ||
+		!isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, signatureSchemesForPublicKey(c.vers, pub)) {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: client certificate used with invalid signature algorithm")
+	}
+	sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
+	if err != nil {
+		return c.sendAlert(alertInternalError)
+	}
+	if sigType == signaturePKCS1v15 || sigHash == crypto.SHA1 {
+		return c.sendAlert(alertInternalError)
+	}
+	signed := signedMessage(clientSignatureContext, transcript)
+	if err := verifyHandshakeSignature(sigType, pub,
+		sigHash, signed, certVerify.signature); err != nil {
+		c.sendAlert(alertDecryptError)
+		return errors.New("tls: invalid signature by the client certificate: " + err.Error())
+	}
+	c.peerSigAlg = certVerify.signatureAlgorithm
+	return nil
+}
+
 func (hs *serverHandshakeStateTLS13) readClientFinished() error {
 	c := hs.c
 
@@ -1140,5 +1595,11 @@ func (hs *serverHandshakeStateTLS13) readClientFinished() error {
 		return err
 	}
 
+	// hs.transcript already includes the client Finished, see
+	// sendSessionTickets.
+	if hs.clientHello.postHandshakeAuth && c.quic == nil && hs.clientCertType != CertificateTypeRawPublicKey {
+		c.postHandshakeTranscript = cloneHash(hs.transcript, hs.suite.hash)
+	}
+
 	return nil
 }
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package tls
+
+import "syscall"
+
+func waitReadable(syscall.RawConn) {}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package tls
+
+import "syscall"
+
+// waitReadable blocks until rawConn has data to read, or an error or EOF to
+// report, without reading anything.
+func waitReadable(rawConn syscall.RawConn) {
+	var b [1]byte
+	rawConn.Read(func(fd uintptr) bool {
+		// The socket is non-blocking, and reading doesn't consume the data.
+		_, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK)
+		return err != syscall.EAGAIN
+	})
+}
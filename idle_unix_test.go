@@ -0,0 +1,51 @@
+//go:build unix
+
+package tls
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitReadable(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+	defer s.Close()
+	rawConn, err := s.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		waitReadable(rawConn)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("waitReadable returned without input")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	// The input is still there, and waitReadable doesn't wait for more.
+	waitReadable(rawConn)
+	b := make([]byte, 2)
+	if n, err := s.Read(b); err != nil || string(b[:n]) != "x" {
+		t.Errorf("Read = %q, %v, want %q", b[:n], err, "x")
+	}
+
+	// The peer closing the connection wakes up waitReadable.
+	done = make(chan struct{})
+	go func() {
+		waitReadable(rawConn)
+		close(done)
+	}()
+	c.(*net.TCPConn).Close()
+	<-done
+}
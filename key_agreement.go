@@ -5,6 +5,7 @@
 package tls
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdh"
 	"crypto/md5"
@@ -14,6 +15,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/cryptobyte"
 )
 
 // A keyAgreement implements the client and server side of a TLS 1.0–1.2 key
@@ -24,7 +27,7 @@ type keyAgreement interface {
 	// In the case that the key agreement protocol doesn't use a
 	// ServerKeyExchange message, generateServerKeyExchange can return nil,
 	// nil.
-	generateServerKeyExchange(*Config, *Certificate, *clientHelloMsg, *serverHelloMsg) (*serverKeyExchangeMsg, error)
+	generateServerKeyExchange(context.Context, *Config, *Certificate, *clientHelloMsg, *serverHelloMsg) (*serverKeyExchangeMsg, error)
 	processClientKeyExchange(*Config, *Certificate, *clientKeyExchangeMsg, uint16) ([]byte, error)
 
 	// On the client side, the next two methods are called in order.
@@ -42,7 +45,7 @@ var errServerKeyExchange = errors.New("tls: invalid ServerKeyExchange message")
 // encrypts the pre-master secret to the server's public key.
 type rsaKeyAgreement struct{}
 
-func (ka rsaKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+func (ka rsaKeyAgreement) generateServerKeyExchange(ctx context.Context, config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
 	return nil, nil
 }
 
@@ -137,7 +140,7 @@ func hashForServerKeyExchange(sigType uint8, slices ...[]byte) []byte {
 // ecdheKeyAgreement implements a TLS key agreement where the server
 // generates an ephemeral EC public/private key pair and signs it. The
 // pre-master secret is then calculated using ECDH. The signature may
-// be ECDSA, Ed25519 or RSA.
+// be ECDSA, Ed25519, Ed448 or RSA.
 type ecdheKeyAgreement struct {
 	version uint16
 	isRSA   bool
@@ -154,7 +157,9 @@ type ecdheKeyAgreement struct {
 	key                *ecdh.PrivateKey
 }
 
-func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+// generateServerParams selects a curve, generates the server's ephemeral key,
+// and returns the ServerECDHParams of the ServerKeyExchange message.
+func (ka *ecdheKeyAgreement) generateServerParams(config *Config, clientHello *clientHelloMsg) ([]byte, error) {
 	for _, c := range clientHello.supportedCurves {
 		if config.supportsCurve(ka.version, c) {
 			ka.curveID = c
@@ -184,6 +189,21 @@ func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Cer
 	serverECDHEParams[3] = byte(len(ecdhePublic))
 	copy(serverECDHEParams[4:], ecdhePublic)
 
+	return serverECDHEParams, nil
+}
+
+func (ka *ecdheKeyAgreement) generateServerKeyExchange(ctx context.Context, config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	serverECDHEParams, err := ka.generateServerParams(config, clientHello)
+	if err != nil {
+		return nil, err
+	}
+	return ka.signServerParams(ctx, config, cert, clientHello, hello, serverECDHEParams)
+}
+
+// signServerParams returns the ServerKeyExchange message made of the server
+// key exchange params and their signature with cert, also used by
+// dheKeyAgreement.
+func (ka *ecdheKeyAgreement) signServerParams(ctx context.Context, config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg, params []byte) (*serverKeyExchangeMsg, error) {
 	priv, ok := cert.PrivateKey.(crypto.Signer)
 	if !ok {
 		return nil, fmt.Errorf("tls: certificate private key of type %T does not implement crypto.Signer", cert.PrivateKey)
@@ -191,7 +211,11 @@ func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Cer
 
 	var sig []byte
 	if ka.version >= VersionTLS12 {
-		ka.signatureAlgorithm, err = selectSignatureScheme(ka.version, cert, clientHello.supportedSignatureAlgorithms)
+		peerAlgs, err := config.peerSignatureAlgorithms(clientHello.supportedSignatureAlgorithms)
+		if err != nil {
+			return nil, err
+		}
+		ka.signatureAlgorithm, err = selectSignatureScheme(ka.version, cert, peerAlgs)
 		if err != nil {
 			return nil, err
 		}
@@ -199,7 +223,7 @@ func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Cer
 		if err != nil {
 			return nil, err
 		}
-		signed := slicesConcat(clientHello.random, hello.random, serverECDHEParams)
+		signed := slicesConcat(clientHello.random, hello.random, params)
 		if (sigType == signaturePKCS1v15 || sigType == signatureRSAPSS) != ka.isRSA {
 			return nil, errors.New("tls: certificate cannot be used with the selected cipher suite")
 		}
@@ -207,22 +231,25 @@ func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Cer
 		if sigType == signatureRSAPSS {
 			signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
 		}
-		sig, err = cryptoSignMessage(priv, config.rand(), signed, signOpts)
+		sig, err = signContext(ctx, priv, config.rand(), signed, signOpts)
 		if err != nil {
-			return nil, errors.New("tls: failed to sign ECDHE parameters: " + err.Error())
+			return nil, errors.New("tls: failed to sign key exchange parameters: " + err.Error())
 		}
 	} else {
 		sigType, sigHash, err := legacyTypeAndHashFromPublicKey(priv.Public())
 		if err != nil {
 			return nil, err
 		}
-		signed := hashForServerKeyExchange(sigType, clientHello.random, hello.random, serverECDHEParams)
+		if sigType == signaturePKCS1v15 && config.RequirePSS {
+			return nil, errPKCS1v15Disabled
+		}
+		signed := hashForServerKeyExchange(sigType, clientHello.random, hello.random, params)
 		if (sigType == signaturePKCS1v15) != ka.isRSA {
 			return nil, errors.New("tls: certificate cannot be used with the selected cipher suite")
 		}
 		sig, err = priv.Sign(config.rand(), signed, sigHash)
 		if err != nil {
-			return nil, errors.New("tls: failed to sign ECDHE parameters: " + err.Error())
+			return nil, errors.New("tls: failed to sign key exchange parameters: " + err.Error())
 		}
 	}
 
@@ -231,9 +258,9 @@ func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Cer
 	if ka.version >= VersionTLS12 {
 		sigAndHashLen = 2
 	}
-	skx.key = make([]byte, len(serverECDHEParams)+sigAndHashLen+2+len(sig))
-	copy(skx.key, serverECDHEParams)
-	k := skx.key[len(serverECDHEParams):]
+	skx.key = make([]byte, len(params)+sigAndHashLen+2+len(sig))
+	copy(skx.key, params)
+	k := skx.key[len(params):]
 	if ka.version >= VersionTLS12 {
 		k[0] = byte(ka.signatureAlgorithm >> 8)
 		k[1] = byte(ka.signatureAlgorithm)
@@ -279,7 +306,16 @@ func (ka *ecdheKeyAgreement) processServerKeyExchange(config *Config, clientHell
 	serverECDHEParams := skx.key[:4+publicLen]
 	publicKey := serverECDHEParams[4:]
 
-	sig := skx.key[4+publicLen:]
+	if err := ka.processServerParams(config, clientHello, publicKey); err != nil {
+		return err
+	}
+	return ka.verifyServerParams(config, clientHello, serverHello, cert, serverECDHEParams, skx.key[4+publicLen:])
+}
+
+// verifyServerParams checks sig, the signature part of a ServerKeyExchange
+// message, over the server key exchange params, also used by
+// dheKeyAgreement.
+func (ka *ecdheKeyAgreement) verifyServerParams(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, params, sig []byte) error {
 	if len(sig) < 2 {
 		return errServerKeyExchange
 	}
@@ -296,6 +332,48 @@ func (ka *ecdheKeyAgreement) processServerKeyExchange(config *Config, clientHell
 	}
 	sig = sig[2:]
 
+	var sigType uint8
+	var sigHash crypto.Hash
+	var err error
+	if ka.version >= VersionTLS12 {
+		if !isSupportedSignatureAlgorithm(ka.signatureAlgorithm, clientHello.supportedSignatureAlgorithms) {
+			return errors.New("tls: certificate used with invalid signature algorithm")
+		}
+		sigType, sigHash, err = typeAndHashFromSignatureScheme(ka.signatureAlgorithm)
+		if err != nil {
+			return err
+		}
+		if (sigType == signaturePKCS1v15 || sigType == signatureRSAPSS) != ka.isRSA {
+			return errServerKeyExchange
+		}
+		signed := slicesConcat(clientHello.random, serverHello.random, params)
+		if err := verifyHandshakeSignature(sigType, cert.PublicKey, sigHash, signed, sig); err != nil {
+			return errors.New("tls: invalid signature by the server certificate: " + err.Error())
+		}
+	} else {
+		sigType, sigHash, err = legacyTypeAndHashFromPublicKey(cert.PublicKey)
+		if err != nil {
+			return err
+		}
+		if sigType == signaturePKCS1v15 && config.RequirePSS {
+			return errPKCS1v15Disabled
+		}
+		if (sigType == signaturePKCS1v15) != ka.isRSA {
+			return errServerKeyExchange
+		}
+		signed := hashForServerKeyExchange(sigType, clientHello.random, serverHello.random, params)
+		if err := verifyLegacyHandshakeSignature(sigType, cert.PublicKey, sigHash, signed, sig); err != nil {
+			return errors.New("tls: invalid signature by the server certificate: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// processServerParams generates the client's ephemeral key for ka.curveID,
+// computes the shared secret with the server's publicKey, and prepares the
+// ClientKeyExchange message.
+func (ka *ecdheKeyAgreement) processServerParams(config *Config, clientHello *clientHelloMsg, publicKey []byte) error {
 	if !slicesContains(clientHello.supportedCurves, ka.curveID) {
 		return errors.New("tls: server selected unoffered curve")
 	}
@@ -325,46 +403,166 @@ func (ka *ecdheKeyAgreement) processServerKeyExchange(config *Config, clientHell
 	ka.ckx.ciphertext[0] = byte(len(ourPublicKey))
 	copy(ka.ckx.ciphertext[1:], ourPublicKey)
 
-	var sigType uint8
-	var sigHash crypto.Hash
-	if ka.version >= VersionTLS12 {
-		if !isSupportedSignatureAlgorithm(ka.signatureAlgorithm, clientHello.supportedSignatureAlgorithms) {
-			return errors.New("tls: certificate used with invalid signature algorithm")
-		}
-		sigType, sigHash, err = typeAndHashFromSignatureScheme(ka.signatureAlgorithm)
+	return nil
+}
+
+func (ka *ecdheKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
+	if ka.ckx == nil {
+		return nil, nil, errors.New("tls: missing ServerKeyExchange message")
+	}
+
+	return ka.preMasterSecret, ka.ckx, nil
+}
+
+// pskKeyAgreement implements the TLS key agreements where the pre-master
+// secret is derived from a pre-shared key, on its own (RFC 4279) or combined
+// with an unauthenticated ECDHE exchange (RFC 5489).
+type pskKeyAgreement struct {
+	version uint16
+	// ecdhe is nil for plain PSK cipher suites.
+	ecdhe *ecdheKeyAgreement
+
+	// hint is the server's PSK identity hint, set by processServerKeyExchange.
+	hint []byte
+	// identity is the PSK identity, set by processClientKeyExchange and
+	// generateClientKeyExchange.
+	identity []byte
+}
+
+var errUnknownPSKIdentity = errors.New("tls: unknown PSK identity")
+
+func (ka *pskKeyAgreement) generateServerKeyExchange(ctx context.Context, config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	// Plain PSK servers may omit the ServerKeyExchange without a hint.
+	if ka.ecdhe == nil && len(config.PSKIdentityHint) == 0 {
+		return nil, nil
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(config.PSKIdentityHint)
+	})
+	if ka.ecdhe != nil {
+		serverECDHEParams, err := ka.ecdhe.generateServerParams(config, clientHello)
 		if err != nil {
-			return err
-		}
-		if (sigType == signaturePKCS1v15 || sigType == signatureRSAPSS) != ka.isRSA {
-			return errServerKeyExchange
-		}
-		signed := slicesConcat(clientHello.random, serverHello.random, serverECDHEParams)
-		if err := verifyHandshakeSignature(sigType, cert.PublicKey, sigHash, signed, sig); err != nil {
-			return errors.New("tls: invalid signature by the server certificate: " + err.Error())
+			return nil, err
 		}
-	} else {
-		sigType, sigHash, err = legacyTypeAndHashFromPublicKey(cert.PublicKey)
+		b.AddBytes(serverECDHEParams)
+	}
+	key, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &serverKeyExchangeMsg{key: key}, nil
+}
+
+func (ka *pskKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
+	s := cryptobyte.String(ckx.ciphertext)
+	var identity []byte
+	if !s.ReadUint16LengthPrefixed((*cryptobyte.String)(&identity)) || len(identity) == 0 {
+		return nil, errClientKeyExchange
+	}
+
+	var otherSecret []byte
+	if ka.ecdhe != nil {
+		var err error
+		otherSecret, err = ka.ecdhe.processClientKeyExchange(config, cert, &clientKeyExchangeMsg{ciphertext: s}, version)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if (sigType == signaturePKCS1v15) != ka.isRSA {
+	} else if !s.Empty() {
+		return nil, errClientKeyExchange
+	}
+
+	psk, err := config.getExternalPSK(identity, nil)
+	if err != nil {
+		return nil, err
+	}
+	if psk == nil || len(psk.Key) == 0 {
+		return nil, errUnknownPSKIdentity
+	}
+	ka.identity = identity
+
+	return pskPreMasterSecret(otherSecret, psk.Key)
+}
+
+func (ka *pskKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	s := cryptobyte.String(skx.key)
+	if !s.ReadUint16LengthPrefixed((*cryptobyte.String)(&ka.hint)) {
+		return errServerKeyExchange
+	}
+	if ka.ecdhe == nil {
+		if !s.Empty() {
 			return errServerKeyExchange
 		}
-		signed := hashForServerKeyExchange(sigType, clientHello.random, serverHello.random, serverECDHEParams)
-		if err := verifyLegacyHandshakeSignature(sigType, cert.PublicKey, sigHash, signed, sig); err != nil {
-			return errors.New("tls: invalid signature by the server certificate: " + err.Error())
-		}
+		return nil
 	}
 
-	return nil
+	// The ServerECDHParams are not signed, the handshake is authenticated
+	// by the PSK instead.
+	var curveType uint8
+	var curveID uint16
+	var publicKey []byte
+	if !s.ReadUint8(&curveType) || !s.ReadUint16(&curveID) ||
+		!s.ReadUint8LengthPrefixed((*cryptobyte.String)(&publicKey)) || !s.Empty() {
+		return errServerKeyExchange
+	}
+	if curveType != 3 { // named curve
+		return errors.New("tls: server selected unsupported curve")
+	}
+	ka.ecdhe.curveID = CurveID(curveID)
+	return ka.ecdhe.processServerParams(config, clientHello, publicKey)
 }
 
-func (ka *ecdheKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
-	if ka.ckx == nil {
-		return nil, nil, errors.New("tls: missing ServerKeyExchange message")
+func (ka *pskKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
+	psk, err := config.getClientPSK(ka.hint)
+	if err != nil {
+		return nil, nil, err
+	}
+	if psk == nil || len(psk.Identity) == 0 || len(psk.Key) == 0 {
+		return nil, nil, errors.New("tls: no PSK available for the selected cipher suite")
 	}
+	ka.identity = psk.Identity
 
-	return ka.preMasterSecret, ka.ckx, nil
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(psk.Identity)
+	})
+	var otherSecret []byte
+	if ka.ecdhe != nil {
+		var ecdheCKX *clientKeyExchangeMsg
+		otherSecret, ecdheCKX, err = ka.ecdhe.generateClientKeyExchange(config, clientHello, cert)
+		if err != nil {
+			return nil, nil, err
+		}
+		b.AddBytes(ecdheCKX.ciphertext)
+	}
+	ckx := new(clientKeyExchangeMsg)
+	if ckx.ciphertext, err = b.Bytes(); err != nil {
+		return nil, nil, err
+	}
+
+	preMasterSecret, err := pskPreMasterSecret(otherSecret, psk.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return preMasterSecret, ckx, nil
+}
+
+// pskPreMasterSecret returns the pre-master secret of a PSK key agreement,
+// where otherSecret is the ECDHE shared secret, if any. See RFC 4279,
+// Section 2 and RFC 5489, Section 2.
+func pskPreMasterSecret(otherSecret, psk []byte) ([]byte, error) {
+	if otherSecret == nil {
+		otherSecret = make([]byte, len(psk))
+	}
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(otherSecret)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(psk)
+	})
+	return b.Bytes()
 }
 
 // generateECDHEKey returns a PrivateKey that implements Diffie-Hellman
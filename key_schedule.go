@@ -11,6 +11,7 @@ import (
 	"hash"
 	"io"
 
+	sm2ecdh "github.com/emmansun/gmsm/ecdh"
 	"github.com/metacubex/mlkem"
 )
 
@@ -25,9 +26,14 @@ func (c *cipherSuiteTLS13) nextTrafficSecret(trafficSecret []byte) []byte {
 
 // trafficKey generates traffic keys according to RFC 8446, Section 7.3.
 func (c *cipherSuiteTLS13) trafficKey(trafficSecret []byte) (key, iv []byte) {
-	key = tls13ExpandLabel(c.hash.New, trafficSecret, "key", nil, c.keyLen)
-	iv = tls13ExpandLabel(c.hash.New, trafficSecret, "iv", nil, aeadNonceLength)
-	return
+	ivLen := aeadNonceLength
+	if c.hash >= customHashBase {
+		ivLen = customIVLen(c.hash)
+	}
+	k := newTLS13KDF(c.hash.New, trafficSecret)
+	b := k.expandLabel(make([]byte, 0, c.keyLen+ivLen), "key", nil, c.keyLen)
+	b = k.expandLabel(b, "iv", nil, ivLen)
+	return b[:c.keyLen:c.keyLen], b[c.keyLen:]
 }
 
 // finishedHash generates the Finished verify_data or PskBinderEntry according
@@ -52,6 +58,7 @@ func (c *cipherSuiteTLS13) exportKeyingMaterial(s *tls13MasterSecret, transcript
 type keySharePrivateKeys struct {
 	ecdhe *ecdh.PrivateKey
 	mlkem mlkem.Decapsulator
+	sm2   *sm2ecdh.PrivateKey
 }
 
 // A keyExchange implements a TLS 1.3 KEM.
@@ -93,6 +100,8 @@ func keyExchangeForCurveID(id CurveID) (keyExchange, error) {
 		return &ecdhKeyExchange{id, ecdh.P384()}, nil
 	case CurveP521:
 		return &ecdhKeyExchange{id, ecdh.P521()}, nil
+	case CurveSM2:
+		return &sm2KeyExchange{}, nil
 	case X25519MLKEM768:
 		return &hybridKeyExchange{id, ecdhKeyExchange{X25519, ecdh.X25519()},
 			32, mlkem.EncapsulationKeySize768, mlkem.CiphertextSize768,
@@ -9,8 +9,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"strings"
+	"sync"
 	"testing"
 	"unicode"
+
+	"github.com/metacubex/hkdf"
 )
 
 func TestACVPVectors(t *testing.T) {
@@ -116,3 +119,47 @@ func TestTrafficKey(t *testing.T) {
 		t.Errorf("cipherSuiteTLS13.trafficKey() gotIV = % x, want % x", gotIV, wantIV)
 	}
 }
+
+func TestExpandLabelLengths(t *testing.T) {
+	// Compare against the generic HKDF-Expand for outputs spanning several
+	// blocks, as well as consecutive derivations from the same secret.
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	k := newTLS13KDF(sha256.New, secret)
+	for _, length := range []int{1, 12, 32, 33, 64, 100} {
+		for _, context := range [][]byte{nil, []byte("context")} {
+			hkdfLabel := []byte{byte(length >> 8), byte(length), byte(len("tls13 label"))}
+			hkdfLabel = append(hkdfLabel, "tls13 label"...)
+			hkdfLabel = append(hkdfLabel, byte(len(context)))
+			hkdfLabel = append(hkdfLabel, context...)
+			want, err := hkdf.Expand(sha256.New, secret, string(hkdfLabel), length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := k.expandLabel(nil, "label", context, length); !bytes.Equal(got, want) {
+				t.Errorf("expandLabel(%d, %q) = %x, want %x", length, context, got, want)
+			}
+		}
+	}
+}
+
+func TestExporterConcurrent(t *testing.T) {
+	es := tls13NewEarlySecret(sha256.New, nil)
+	ms := es.HandshakeSecret(nil).MasterSecret()
+	exp := ms.ExporterMasterSecret(sha256.New())
+	want := exp.Exporter("label", []byte("context"), 32)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got := exp.Exporter("label", []byte("context"), 32); !bytes.Equal(got, want) {
+					t.Errorf("Exporter = %x, want %x", got, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,41 @@
+package tls
+
+// A KeyLogger receives the secrets of the connections it's set on, like
+// Config.KeyLogWriter but with the values rather than lines of the NSS key
+// log format, for example to capture the keys of selected connections only.
+// It is set in [Config.KeyLogger], or for a single connection with
+// [Conn.SetKeyLogger]. Use of a KeyLogger compromises security and should
+// only be used for debugging.
+type KeyLogger interface {
+	// LogKey is called with each secret of a handshake, as soon as it's
+	// derived, and the label of the key log format for it, such as
+	// "CLIENT_HANDSHAKE_TRAFFIC_SECRET". It must not retain the slices.
+	LogKey(label string, clientRandom, secret []byte)
+}
+
+// KeyLoggerFunc is a [KeyLogger] implemented by a function.
+type KeyLoggerFunc func(label string, clientRandom, secret []byte)
+
+// LogKey calls f(label, clientRandom, secret).
+func (f KeyLoggerFunc) LogKey(label string, clientRandom, secret []byte) {
+	f(label, clientRandom, secret)
+}
+
+// SetKeyLogger sets the KeyLogger of c, in place of that of Config.KeyLogger.
+// It must be called before the handshake.
+func (c *Conn) SetKeyLogger(l KeyLogger) {
+	c.keyLogger = l
+}
+
+// writeKeyLog reports a secret to the KeyLogger of c and writes it to
+// Config.KeyLogWriter.
+func (c *Conn) writeKeyLog(label string, clientRandom, secret []byte) error {
+	l := c.keyLogger
+	if l == nil {
+		l = c.config.KeyLogger
+	}
+	if l != nil {
+		l.LogKey(label, clientRandom, secret)
+	}
+	return c.config.writeKeyLog(label, clientRandom, secret)
+}
@@ -0,0 +1,106 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"strconv"
+
+	"github.com/cloudflare/circl/sign/ed448"
+)
+
+// curveStrength returns the security level in bits of a key exchange, as
+// compared against MinECStrength. Hybrid key exchanges count the strength of
+// their elliptic curve part.
+func curveStrength(curve CurveID) int {
+	switch curve {
+	case X25519, CurveP256, CurveSM2, X25519MLKEM768, SecP256r1MLKEM768:
+		return 128
+	case CurveP384, SecP384r1MLKEM1024:
+		return 192
+	case CurveP521:
+		return 256
+	}
+	return 0
+}
+
+// minDHGroupSize returns the minimum size in bits of the prime of a DHE
+// group accepted by c.
+func (c *Config) minDHGroupSize() int {
+	if c == nil {
+		return minDHEPrimeBits
+	}
+	size := c.MinDHGroupSize
+	if size == 0 {
+		size = minDHEPrimeBits
+	}
+	return maxInt(size, c.securityLevel().rsaBits)
+}
+
+// minRSAKeySize returns the minimum size in bits of the RSA keys of peers.
+func (c *Config) minRSAKeySize() int {
+	if c == nil {
+		return 0
+	}
+	return maxInt(c.MinRSAKeySize, c.securityLevel().rsaBits)
+}
+
+// minECStrength returns the minimum strength in bits of the elliptic curve
+// keys of peers and of the key exchanges.
+func (c *Config) minECStrength() int {
+	if c == nil {
+		return 0
+	}
+	return maxInt(c.MinECStrength, c.securityLevel().bits)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// checkPeerKeySize checks the public key of a certificate sent by the peer
+// against the minimum sizes of c.
+func (c *Config) checkPeerKeySize(pub any) error {
+	if c.fipsMode() && !isKeyAllowedFIPS(pub) {
+		return errors.New("a key not allowed by Config.FIPSMode")
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if n, minSize := pub.N.BitLen(), c.minRSAKeySize(); n < minSize {
+			return errors.New("RSA key of " + strconv.Itoa(n) + " bits, smaller than the minimum of " + strconv.Itoa(minSize))
+		}
+	case *ecdsa.PublicKey, ed25519.PublicKey, ed448.PublicKey:
+		if strength, minStrength := keyStrength(pub), c.minECStrength(); strength < minStrength {
+			return errors.New(strconv.Itoa(strength) + "-bit strength key, weaker than the minimum of " + strconv.Itoa(minStrength))
+		}
+	}
+	return nil
+}
+
+// keyStrength returns the security level in bits of an elliptic curve key.
+func keyStrength(pub any) int {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if pub.Params().BitSize > 512 {
+			// P-521 has about the strength of a 512-bit curve.
+			return 256
+		}
+		return pub.Params().BitSize / 2
+	case ed25519.PublicKey:
+		return 128
+	case ed448.PublicKey:
+		return 224
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package tls
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMinPeerKeySizes(t *testing.T) {
+	ecdsaCert := Certificate{
+		Certificate: [][]byte{testP256Certificate},
+		PrivateKey:  testP256PrivateKey,
+	}
+
+	for _, tt := range []struct {
+		name      string
+		cert      *Certificate
+		modify    func(*Config)
+		clientCA  bool
+		wantError string
+	}{
+		{name: "RSA", modify: func(c *Config) { c.MinRSAKeySize = 1024 }},
+		{name: "SmallRSA", modify: func(c *Config) { c.MinRSAKeySize = 2048 }, wantError: "RSA key of 1024 bits"},
+		{name: "ClientSmallRSA", modify: func(c *Config) { c.MinRSAKeySize = 2048 }, clientCA: true, wantError: "client sent certificate containing RSA key"},
+		{name: "ECDSA", cert: &ecdsaCert, modify: func(c *Config) { c.MinECStrength = 128 }},
+		{name: "WeakECDSA", cert: &ecdsaCert, modify: func(c *Config) { c.MinECStrength = 192 }, wantError: "128-bit strength key"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			serverConfig := testConfig.Clone()
+			if tt.cert != nil {
+				serverConfig.Certificates = []Certificate{*tt.cert}
+				serverConfig.NameToCertificate = nil
+			}
+			if tt.clientCA {
+				clientConfig.Certificates = []Certificate{testConfig.Certificates[0]}
+				serverConfig.ClientAuth = RequireAnyClientCert
+				// In TLS 1.3, the client handshake completes before the
+				// server rejects its certificate.
+				clientConfig.MaxVersion = VersionTLS12
+				tt.modify(serverConfig)
+			} else {
+				tt.modify(clientConfig)
+			}
+
+			_, _, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("got error %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestMinKeyExchangeStrength(t *testing.T) {
+	config := &Config{MinECStrength: 192}
+	want := []CurveID{SecP384r1MLKEM1024, CurveP384, CurveP521}
+	if got := config.curvePreferences(VersionTLS13); !reflect.DeepEqual(got, want) {
+		t.Errorf("got curve preferences %v, want %v", got, want)
+	}
+
+	config = &Config{MinDHGroupSize: 3072}
+	if got := config.ffdheGroups(); slicesContains(got, FFDHE2048) || len(got) != len(ffdheGroups)-1 {
+		t.Errorf("got FFDHE groups %v, want all but FFDHE2048", got)
+	}
+	if err := checkDHEGroup(ffdhePrime(ffdhe2048Prime), big.NewInt(2), config.minDHGroupSize()); err == nil {
+		t.Error("2048-bit DHE prime accepted with a MinDHGroupSize of 3072")
+	}
+}
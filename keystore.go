@@ -0,0 +1,61 @@
+package tls
+
+import (
+	"errors"
+	"sync"
+)
+
+var errPlatformKeystoreUnsupported = errors.New("tls: platform keystore is not supported on this platform")
+
+// A PlatformKeystore gives access to the client identities of the operating
+// system's certificate store: the personal ("MY") store of the current user,
+// with keys held by CNG, on Windows, and the identities of the keychain search
+// list on macOS, when built with cgo.
+//
+// Private keys never leave the keystore, so that non-exportable and hardware
+// backed keys can be used. The PrivateKey of each Certificate is a
+// crypto.Signer that has the operating system sign on its behalf, which may
+// prompt the user for a PIN or for access to the key. Only RSA and ECDSA keys
+// are supported.
+//
+// A PlatformKeystore is safe for concurrent use. Its Certificates must not be
+// used after Close.
+type PlatformKeystore struct {
+	certs     []Certificate
+	close     func()
+	closeOnce sync.Once
+}
+
+// OpenPlatformKeystore loads the client identities of the platform keystore.
+// Identities whose certificate or key can't be used are skipped.
+func OpenPlatformKeystore() (*PlatformKeystore, error) {
+	certs, close, err := openPlatformKeystore()
+	if err != nil {
+		return nil, err
+	}
+	return &PlatformKeystore{certs: certs, close: close}, nil
+}
+
+// Certificates returns the identities of the keystore, with their Leaf set.
+func (ks *PlatformKeystore) Certificates() []Certificate {
+	return slicesClone(ks.certs)
+}
+
+// GetClientCertificate returns the first identity of the keystore that is
+// acceptable to the server, with [CertificateRequestInfo.SupportsCertificate],
+// or an empty Certificate to continue the handshake without one. It can be
+// set as [Config.GetClientCertificate].
+func (ks *PlatformKeystore) GetClientCertificate(cri *CertificateRequestInfo) (*Certificate, error) {
+	for i := range ks.certs {
+		if cri.SupportsCertificate(&ks.certs[i]) == nil {
+			return &ks.certs[i], nil
+		}
+	}
+	return new(Certificate), nil
+}
+
+// Close releases the identities of the keystore.
+func (ks *PlatformKeystore) Close() error {
+	ks.closeOnce.Do(ks.close)
+	return nil
+}
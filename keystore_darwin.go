@@ -0,0 +1,205 @@
+//go:build darwin && cgo
+
+package tls
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+enum { schemePKCS1v15, schemePSS, schemeECDSA };
+enum { hashRaw, hashSHA1, hashSHA256, hashSHA384, hashSHA512 };
+
+static CFArrayRef copyIdentities(OSStatus *status) {
+	const void *keys[] = {kSecClass, kSecMatchLimit, kSecReturnRef};
+	const void *values[] = {kSecClassIdentity, kSecMatchLimitAll, kCFBooleanTrue};
+	CFDictionaryRef query = CFDictionaryCreate(kCFAllocatorDefault, keys, values, 3,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFTypeRef result = NULL;
+	*status = SecItemCopyMatching(query, &result);
+	CFRelease(query);
+	return (CFArrayRef)result;
+}
+
+static SecIdentityRef identityAtIndex(CFArrayRef identities, CFIndex i) {
+	return (SecIdentityRef)CFArrayGetValueAtIndex(identities, i);
+}
+
+static CFDataRef copyCertificateData(SecIdentityRef identity) {
+	SecCertificateRef cert = NULL;
+	if (SecIdentityCopyCertificate(identity, &cert) != errSecSuccess) {
+		return NULL;
+	}
+	CFDataRef data = SecCertificateCopyData(cert);
+	CFRelease(cert);
+	return data;
+}
+
+static SecKeyAlgorithm keyAlgorithm(int scheme, int hash) {
+	switch (scheme) {
+	case schemePKCS1v15:
+		switch (hash) {
+		case hashRaw: return kSecKeyAlgorithmRSASignatureDigestPKCS1v15Raw;
+		case hashSHA1: return kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA1;
+		case hashSHA256: return kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA256;
+		case hashSHA384: return kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA384;
+		case hashSHA512: return kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA512;
+		}
+		break;
+	case schemePSS:
+		switch (hash) {
+		case hashSHA256: return kSecKeyAlgorithmRSASignatureDigestPSSSHA256;
+		case hashSHA384: return kSecKeyAlgorithmRSASignatureDigestPSSSHA384;
+		case hashSHA512: return kSecKeyAlgorithmRSASignatureDigestPSSSHA512;
+		}
+		break;
+	case schemeECDSA:
+		switch (hash) {
+		case hashSHA1: return kSecKeyAlgorithmECDSASignatureDigestX962SHA1;
+		case hashSHA256: return kSecKeyAlgorithmECDSASignatureDigestX962SHA256;
+		case hashSHA384: return kSecKeyAlgorithmECDSASignatureDigestX962SHA384;
+		case hashSHA512: return kSecKeyAlgorithmECDSASignatureDigestX962SHA512;
+		}
+		break;
+	}
+	return NULL;
+}
+
+// signDigest returns NULL without setting *error if the algorithm is not
+// supported.
+static CFDataRef signDigest(SecKeyRef key, int scheme, int hash, const UInt8 *digest, CFIndex len, CFErrorRef *error) {
+	SecKeyAlgorithm algorithm = keyAlgorithm(scheme, hash);
+	if (algorithm == NULL) {
+		return NULL;
+	}
+	CFDataRef data = CFDataCreate(kCFAllocatorDefault, digest, len);
+	CFDataRef sig = SecKeyCreateSignature(key, algorithm, data, error);
+	CFRelease(data);
+	return sig;
+}
+*/
+import "C"
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+func openPlatformKeystore() ([]Certificate, func(), error) {
+	var status C.OSStatus
+	identities := C.copyIdentities(&status)
+	if status == C.errSecItemNotFound {
+		return nil, func() {}, nil
+	}
+	if status != C.errSecSuccess {
+		return nil, nil, fmt.Errorf("tls: failed to search the keychain: OSStatus %d", int32(status))
+	}
+	defer C.CFRelease(C.CFTypeRef(identities))
+
+	var certs []Certificate
+	var keys []C.SecKeyRef
+	n := C.CFArrayGetCount(identities)
+	for i := C.CFIndex(0); i < n; i++ {
+		identity := C.identityAtIndex(identities, i)
+		data := C.copyCertificateData(identity)
+		if data == 0 {
+			continue
+		}
+		der := cfDataBytes(data)
+		C.CFRelease(C.CFTypeRef(data))
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		switch leaf.PublicKey.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			continue
+		}
+		var key C.SecKeyRef
+		if C.SecIdentityCopyPrivateKey(identity, &key) != C.errSecSuccess {
+			continue
+		}
+		keys = append(keys, key)
+		certs = append(certs, Certificate{
+			Certificate: [][]byte{der},
+			PrivateKey:  &keychainSigner{key: key, pub: leaf.PublicKey},
+			Leaf:        leaf,
+		})
+	}
+	return certs, func() {
+		for _, key := range keys {
+			C.CFRelease(C.CFTypeRef(key))
+		}
+	}, nil
+}
+
+func cfDataBytes(data C.CFDataRef) []byte {
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+}
+
+// keychainSigner is a crypto.Signer backed by a keychain SecKeyRef.
+type keychainSigner struct {
+	key C.SecKeyRef
+	pub crypto.PublicKey
+}
+
+func (s *keychainSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *keychainSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var hash C.int
+	switch opts.HashFunc() {
+	case crypto.MD5SHA1:
+		hash = C.hashRaw
+	case crypto.SHA1:
+		hash = C.hashSHA1
+	case crypto.SHA256:
+		hash = C.hashSHA256
+	case crypto.SHA384:
+		hash = C.hashSHA384
+	case crypto.SHA512:
+		hash = C.hashSHA512
+	default:
+		return nil, errors.New("tls: unsupported hash function for keychain key")
+	}
+
+	var scheme C.int
+	switch s.pub.(type) {
+	case *rsa.PublicKey:
+		scheme = C.schemePKCS1v15
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			// The Security framework only uses salts as long as the hash.
+			if pssOpts.SaltLength != rsa.PSSSaltLengthEqualsHash && pssOpts.SaltLength != pssOpts.Hash.Size() {
+				return nil, errors.New("tls: unsupported PSS salt length for keychain key")
+			}
+			scheme = C.schemePSS
+		}
+	case *ecdsa.PublicKey:
+		scheme = C.schemeECDSA
+	default:
+		return nil, errors.New("tls: unsupported keychain key type")
+	}
+
+	var cfErr C.CFErrorRef
+	sig := C.signDigest(s.key, scheme, hash, (*C.UInt8)(unsafe.Pointer(&digest[0])), C.CFIndex(len(digest)), &cfErr)
+	if sig == 0 {
+		if cfErr == 0 {
+			return nil, errors.New("tls: unsupported signature algorithm for keychain key")
+		}
+		code := C.CFErrorGetCode(cfErr)
+		C.CFRelease(C.CFTypeRef(cfErr))
+		return nil, fmt.Errorf("tls: keychain signing failed: error %d", int(code))
+	}
+	defer C.CFRelease(C.CFTypeRef(sig))
+	// SecKeyCreateSignature already returns ECDSA signatures ASN.1 encoded.
+	return cfDataBytes(sig), nil
+}
@@ -0,0 +1,7 @@
+//go:build !windows && !(darwin && cgo)
+
+package tls
+
+func openPlatformKeystore() ([]Certificate, func(), error) {
+	return nil, nil, errPlatformKeystoreUnsupported
+}
@@ -0,0 +1,42 @@
+package tls
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestPlatformKeystoreGetClientCertificate(t *testing.T) {
+	if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
+		if _, err := OpenPlatformKeystore(); err != errPlatformKeystoreUnsupported {
+			t.Errorf("got error %v, want %v", err, errPlatformKeystoreUnsupported)
+		}
+	}
+
+	ks := &PlatformKeystore{certs: []Certificate{
+		{Certificate: [][]byte{testRSACertificate}, PrivateKey: testRSAPrivateKey},
+		{Certificate: [][]byte{testECDSACertificate}, PrivateKey: testECDSAPrivateKey},
+	}, close: func() {}}
+	defer ks.Close()
+
+	cert, err := ks.GetClientCertificate(&CertificateRequestInfo{
+		SignatureSchemes: []SignatureScheme{ECDSAWithP521AndSHA512},
+		Version:          VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert != &ks.certs[1] {
+		t.Errorf("got certificate %v, want the ECDSA one", cert)
+	}
+
+	cert, err = ks.GetClientCertificate(&CertificateRequestInfo{
+		SignatureSchemes: []SignatureScheme{Ed25519},
+		Version:          VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 0 {
+		t.Errorf("got certificate %v, want none", cert)
+	}
+}
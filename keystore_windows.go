@@ -0,0 +1,200 @@
+//go:build windows
+
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"unsafe"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modncrypt = windows.NewLazySystemDLL("ncrypt.dll")
+
+	procNCryptSignHash = modncrypt.NewProc("NCryptSignHash")
+)
+
+// Padding schemes of NCryptSignHash, see bcrypt.h.
+const (
+	bcryptPadPKCS1 = 0x00000002
+	bcryptPadPSS   = 0x00000008
+)
+
+type bcryptPKCS1PaddingInfo struct {
+	algID *uint16
+}
+
+type bcryptPSSPaddingInfo struct {
+	algID  *uint16
+	cbSalt uint32
+}
+
+func openPlatformKeystore() ([]Certificate, func(), error) {
+	store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0,
+		windows.CERT_SYSTEM_STORE_CURRENT_USER|windows.CERT_STORE_READONLY_FLAG,
+		uintptr(unsafe.Pointer(windows.StringToUTF16Ptr("MY"))))
+	if err != nil {
+		return nil, nil, errors.New("tls: failed to open the certificate store: " + err.Error())
+	}
+	// Duplicated contexts keep the store open until they're freed.
+	defer windows.CertCloseStore(store, 0)
+
+	var certs []Certificate
+	var contexts []*windows.CertContext
+	var ctx *windows.CertContext
+	for {
+		ctx, err = windows.CertEnumCertificatesInStore(store, ctx)
+		if err != nil {
+			if errors.Is(err, windows.Errno(windows.CRYPT_E_NOT_FOUND)) {
+				break
+			}
+			for _, ctx := range contexts {
+				windows.CertFreeCertificateContext(ctx)
+			}
+			return nil, nil, errors.New("tls: failed to enumerate the certificate store: " + err.Error())
+		}
+		der := make([]byte, ctx.Length)
+		copy(der, unsafe.Slice(ctx.EncodedCert, ctx.Length))
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		switch leaf.PublicKey.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			continue
+		}
+		// With CRYPT_ACQUIRE_CACHE_FLAG the key is owned, and freed, by the
+		// certificate context.
+		var key windows.Handle
+		var keySpec uint32
+		var callerFree bool
+		if err := windows.CryptAcquireCertificatePrivateKey(ctx,
+			windows.CRYPT_ACQUIRE_CACHE_FLAG|windows.CRYPT_ACQUIRE_SILENT_FLAG|windows.CRYPT_ACQUIRE_ONLY_NCRYPT_KEY_FLAG,
+			nil, &key, &keySpec, &callerFree); err != nil || keySpec != windows.CERT_NCRYPT_KEY_SPEC || callerFree {
+			continue
+		}
+		dup := windows.CertDuplicateCertificateContext(ctx)
+		contexts = append(contexts, dup)
+		certs = append(certs, Certificate{
+			Certificate: [][]byte{der},
+			PrivateKey:  &cngSigner{key: key, pub: leaf.PublicKey},
+			Leaf:        leaf,
+		})
+	}
+	return certs, func() {
+		for _, ctx := range contexts {
+			windows.CertFreeCertificateContext(ctx)
+		}
+	}, nil
+}
+
+// cngSigner is a crypto.Signer backed by a CNG key handle.
+type cngSigner struct {
+	key windows.Handle
+	pub crypto.PublicKey
+}
+
+func (s *cngSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *cngSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var algID *uint16
+	if opts.HashFunc() != crypto.MD5SHA1 {
+		name, ok := cngHashAlgorithm(opts.HashFunc())
+		if !ok {
+			return nil, errors.New("tls: unsupported hash function for CNG key")
+		}
+		algID = windows.StringToUTF16Ptr(name)
+	}
+
+	var paddingInfo unsafe.Pointer
+	var flags uint32
+	switch s.pub.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			saltLen := pssOpts.SaltLength
+			if saltLen == rsa.PSSSaltLengthEqualsHash {
+				saltLen = pssOpts.Hash.Size()
+			} else if saltLen < 0 {
+				return nil, errors.New("tls: unsupported PSS salt length for CNG key")
+			}
+			paddingInfo, flags = unsafe.Pointer(&bcryptPSSPaddingInfo{algID: algID, cbSalt: uint32(saltLen)}), bcryptPadPSS
+		} else {
+			paddingInfo, flags = unsafe.Pointer(&bcryptPKCS1PaddingInfo{algID: algID}), bcryptPadPKCS1
+		}
+	case *ecdsa.PublicKey:
+	default:
+		return nil, errors.New("tls: unsupported CNG key type")
+	}
+
+	var size uint32
+	if err := ncryptSignHash(s.key, paddingInfo, digest, nil, &size, flags); err != nil {
+		return nil, err
+	}
+	sig := make([]byte, size)
+	if err := ncryptSignHash(s.key, paddingInfo, digest, sig, &size, flags); err != nil {
+		return nil, err
+	}
+	sig = sig[:size]
+	if _, ok := s.pub.(*ecdsa.PublicKey); ok {
+		// CNG returns the IEEE P1363 encoding, r || s.
+		return marshalECDSASignature(sig)
+	}
+	return sig, nil
+}
+
+func ncryptSignHash(key windows.Handle, paddingInfo unsafe.Pointer, digest, sig []byte, size *uint32, flags uint32) error {
+	var sigPtr *byte
+	if len(sig) > 0 {
+		sigPtr = &sig[0]
+	}
+	r, _, _ := procNCryptSignHash.Call(uintptr(key), uintptr(paddingInfo),
+		uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+		uintptr(unsafe.Pointer(sigPtr)), uintptr(len(sig)),
+		uintptr(unsafe.Pointer(size)), uintptr(flags))
+	if r != 0 {
+		return errors.New("tls: NCryptSignHash failed: " + windows.Errno(r).Error())
+	}
+	return nil
+}
+
+func cngHashAlgorithm(h crypto.Hash) (string, bool) {
+	switch h {
+	case crypto.SHA1:
+		return "SHA1", true
+	case crypto.SHA256:
+		return "SHA256", true
+	case crypto.SHA384:
+		return "SHA384", true
+	case crypto.SHA512:
+		return "SHA512", true
+	}
+	return "", false
+}
+
+// marshalECDSASignature converts a fixed-size r || s ECDSA signature to the
+// ASN.1 encoding used by TLS.
+func marshalECDSASignature(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("tls: invalid ECDSA signature length")
+	}
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1BigInt(r)
+		b.AddASN1BigInt(s)
+	})
+	return b.Bytes()
+}
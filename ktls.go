@@ -0,0 +1,226 @@
+package tls
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Kernel TLS cipher types and versions, see include/uapi/linux/tls.h.
+const (
+	ktlsCipherAESGCM128        uint16 = 51
+	ktlsCipherAESGCM256        uint16 = 52
+	ktlsCipherChaCha20Poly1305 uint16 = 54
+)
+
+var errKernelTLSUnsupported = errors.New("tls: kernel TLS is not supported")
+
+// ktlsCryptoInfo holds the fields of the kernel's tls12_crypto_info_*
+// structures, which are used for TLS 1.3 as well.
+type ktlsCryptoInfo struct {
+	version    uint16
+	cipherType uint16
+	iv         []byte
+	key        []byte
+	salt       []byte
+	recSeq     []byte
+}
+
+// kernelCipher returns the kernel TLS cipher type of the negotiated cipher
+// suite, or zero if the kernel can't take it over.
+func (c *Conn) kernelCipher() uint16 {
+	switch c.vers {
+	case VersionTLS13:
+		switch c.cipherSuite {
+		case TLS_AES_128_GCM_SHA256:
+			return ktlsCipherAESGCM128
+		case TLS_AES_256_GCM_SHA384:
+			return ktlsCipherAESGCM256
+		case TLS_CHACHA20_POLY1305_SHA256:
+			return ktlsCipherChaCha20Poly1305
+		}
+	case VersionTLS12:
+		suite := cipherSuiteByID(c.cipherSuite)
+		if suite == nil || suite.aead == nil {
+			return 0
+		}
+		// AES-GCM uses a 4 bytes fixed nonce and an explicit one, while
+		// ChaCha20-Poly1305 uses a 12 bytes fixed nonce.
+		switch {
+		case suite.ivLen == 4 && suite.keyLen == 16:
+			return ktlsCipherAESGCM128
+		case suite.ivLen == 4 && suite.keyLen == 32:
+			return ktlsCipherAESGCM256
+		case suite.ivLen == 12:
+			return ktlsCipherChaCha20Poly1305
+		}
+	}
+	return 0
+}
+
+// kernelRawConn returns the socket underlying c, or nil if it can't be handed
+// over to the kernel.
+func (c *Conn) kernelRawConn() syscall.RawConn {
+	tcpConn, ok := c.conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	return rawConn
+}
+
+// setKernelKeys programs the socket with the current keys of hc, which is
+// c.in if rx is true and c.out otherwise.
+func (c *Conn) setKernelKeys(hc *halfConn, rx bool) error {
+	cipherType := c.kernelCipher()
+	rawConn := c.kernelRawConn()
+	if cipherType == 0 || rawConn == nil || hc.key == nil {
+		return errKernelTLSUnsupported
+	}
+
+	info := &ktlsCryptoInfo{
+		version:    c.vers,
+		cipherType: cipherType,
+		key:        hc.key,
+		recSeq:     hc.seq[:],
+	}
+	switch {
+	case cipherType == ktlsCipherChaCha20Poly1305:
+		info.iv = hc.iv
+	case c.vers == VersionTLS13:
+		info.salt, info.iv = hc.iv[:4], hc.iv[4:]
+	default:
+		// The explicit nonce of the TLS 1.2 AES-GCM records we produce is the
+		// sequence number, and the kernel increments it along with recSeq.
+		info.salt, info.iv = hc.iv, hc.seq[:]
+	}
+	return ktlsSetKeys(rawConn, rx, info)
+}
+
+// enableKernelTLS hands the encryption of outgoing records and the decryption
+// of incoming ones over to the kernel after a successful handshake, as
+// configured by c.config.KernelTX and KernelRX. If the kernel can't do it,
+// the record layer stays in userspace. c.in must be locked.
+func (c *Conn) enableKernelTLS() {
+	if !c.config.KernelTX && !c.config.KernelRX || c.quic != nil || c.config.AEADEngine != nil || c.kernelCipher() == 0 {
+		return
+	}
+	// The kernel keys can't be changed by a TLS 1.2 renegotiation.
+	if c.vers == VersionTLS12 && c.isClient && c.config.Renegotiation != RenegotiateNever {
+		return
+	}
+
+	// The kernel can only take over from a record boundary, before reading
+	// anything past the handshake.
+	if c.config.KernelRX && !c.in.kernel && c.in.err == nil &&
+		c.rawInput.Len() == 0 && c.input.Len() == 0 && c.hand.Len() == 0 {
+		if rawConn := c.kernelRawConn(); rawConn != nil && c.setKernelKeys(&c.in, true) == nil {
+			c.in.kernel = true
+			c.kernelInput = &kernelReader{
+				rawConn: rawConn,
+				version: c.vers,
+				oob:     make([]byte, ktlsRecordTypeSpace),
+			}
+		}
+	}
+
+	if !c.config.KernelTX {
+		return
+	}
+	c.out.Lock()
+	defer c.out.Unlock()
+	if c.out.kernel || c.out.err != nil || len(c.sendBuf) != 0 || c.closeNotifySent {
+		return
+	}
+	if err := c.setKernelKeys(&c.out, false); err != nil {
+		return
+	}
+	c.out.kernel = true
+}
+
+// recordReader returns the source of the records read by c: the socket, or
+// the plaintext records decrypted by the kernel.
+func (c *Conn) recordReader() io.Reader {
+	if c.in.kernel {
+		return c.kernelInput
+	}
+	return c.conn
+}
+
+// kernelReader reads the records decrypted by the kernel, and presents them
+// as unprotected records, each with its header, to the userspace record
+// layer. This way alerts and post-handshake messages are processed as usual.
+type kernelReader struct {
+	rawConn syscall.RawConn
+	version uint16
+	buf     *[]byte // from recordBufPool, while a record is pending
+	pending []byte  // the unread part of buf
+	oob     []byte  // receives the record type, see ktlsRecvRecord
+}
+
+func (r *kernelReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.buf == nil {
+			waitReadable(r.rawConn)
+			r.buf = recordBufPool.Get().(*[]byte)
+		}
+		buf := (*r.buf)[:recordHeaderLen+maxPlaintext]
+		typ, n, err := ktlsRecvRecord(r.rawConn, buf[recordHeaderLen:], r.oob)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, io.EOF
+		}
+		vers := r.version
+		if vers == VersionTLS13 {
+			vers = VersionTLS12
+		}
+		buf[0] = byte(typ)
+		buf[1] = byte(vers >> 8)
+		buf[2] = byte(vers)
+		buf[3] = byte(n >> 8)
+		buf[4] = byte(n)
+		r.pending = buf[:recordHeaderLen+n]
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	if len(r.pending) == 0 {
+		recordBufPool.Put(r.buf)
+		r.buf = nil
+	}
+	return n, nil
+}
+
+// writeKernelRecordLocked writes a record of type typ through a socket whose
+// outgoing records are encrypted by the kernel.
+func (c *Conn) writeKernelRecordLocked(typ recordType, data []byte) (int, error) {
+	if typ == recordTypeApplicationData {
+		n, err := c.conn.Write(data)
+		c.bytesSent += int64(n)
+		return n, err
+	}
+
+	rawConn := c.kernelRawConn()
+	if rawConn == nil {
+		return 0, errKernelTLSUnsupported
+	}
+	var n int
+	for n < len(data) {
+		m := len(data) - n
+		if m > maxPlaintext {
+			m = maxPlaintext
+		}
+		sent, err := ktlsSendRecord(rawConn, typ, data[n:n+m])
+		n += sent
+		c.bytesSent += int64(sent)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
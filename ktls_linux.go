@@ -0,0 +1,118 @@
+//go:build linux
+
+package tls
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Socket options and control messages of the kernel TLS ULP, see
+// include/uapi/linux/tls.h.
+const (
+	ktlsTX            = 1
+	ktlsRX            = 2
+	ktlsSetRecordType = 1
+	ktlsGetRecordType = 2
+)
+
+// marshal encodes info as the kernel's tls12_crypto_info_* structure, whose
+// header fields are in native byte order.
+func (info *ktlsCryptoInfo) marshal() []byte {
+	b := make([]byte, 4, 4+len(info.iv)+len(info.key)+len(info.salt)+len(info.recSeq))
+	*(*uint16)(unsafe.Pointer(&b[0])) = info.version
+	*(*uint16)(unsafe.Pointer(&b[2])) = info.cipherType
+	b = append(b, info.iv...)
+	b = append(b, info.key...)
+	b = append(b, info.salt...)
+	return append(b, info.recSeq...)
+}
+
+// ktlsSetKeys installs the TLS ULP on the socket, if needed, and sets the
+// keys of the receive or transmit direction.
+func ktlsSetKeys(rawConn syscall.RawConn, rx bool, info *ktlsCryptoInfo) error {
+	opt := ktlsTX
+	if rx {
+		opt = ktlsRX
+	}
+	var err error
+	if ctrlErr := rawConn.Control(func(fd uintptr) {
+		err = unix.SetsockoptString(int(fd), unix.SOL_TCP, unix.TCP_ULP, "tls")
+		if err == unix.EEXIST {
+			err = nil
+		}
+		if err == nil {
+			err = unix.SetsockoptString(int(fd), unix.SOL_TLS, opt, string(info.marshal()))
+		}
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
+
+// ktlsSendRecord makes the kernel send data in records of type typ.
+func ktlsSendRecord(rawConn syscall.RawConn, typ recordType, data []byte) (int, error) {
+	oob := make([]byte, unix.CmsgSpace(1))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_TLS
+	h.Type = ktlsSetRecordType
+	h.SetLen(unix.CmsgLen(1))
+	oob[unix.CmsgLen(0)] = byte(typ)
+
+	var n int
+	var err error
+	if writeErr := rawConn.Write(func(fd uintptr) bool {
+		var sent int
+		for n < len(data) {
+			sent, err = unix.SendmsgN(int(fd), data[n:], oob, nil, 0)
+			if err != nil {
+				return err != unix.EAGAIN
+			}
+			n += sent
+		}
+		return true
+	}); writeErr != nil {
+		return n, writeErr
+	}
+	return n, err
+}
+
+// ktlsRecordTypeSpace is the size of the control message carrying the type
+// of the records received.
+var ktlsRecordTypeSpace = unix.CmsgSpace(1)
+
+// ktlsRecvRecord reads the plaintext of records decrypted by the kernel into
+// buf, and returns their type, received in oob, which must be at least
+// ktlsRecordTypeSpace long. The kernel returns a single record at a time,
+// except for consecutive application data records. A record the kernel
+// failed to decrypt is reported as alertBadRecordMAC.
+func ktlsRecvRecord(rawConn syscall.RawConn, buf, oob []byte) (recordType, int, error) {
+	var n, oobn int
+	var err error
+	if readErr := rawConn.Read(func(fd uintptr) bool {
+		n, oobn, _, _, err = unix.Recvmsg(int(fd), buf, oob, 0)
+		return err != unix.EAGAIN
+	}); readErr != nil {
+		return 0, 0, readErr
+	}
+	if err == unix.EBADMSG {
+		return 0, 0, alertBadRecordMAC
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	typ := recordTypeApplicationData
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.SOL_TLS && msg.Header.Type == ktlsGetRecordType && len(msg.Data) > 0 {
+			typ = recordType(msg.Data[0])
+		}
+	}
+	return typ, n, nil
+}
@@ -0,0 +1,293 @@
+//go:build linux
+
+package tls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestKernelCryptoInfoSize(t *testing.T) {
+	// sizeof(struct tls12_crypto_info_*) for each cipher type.
+	for _, tt := range []struct {
+		version uint16
+		suite   uint16
+		want    int
+	}{
+		{VersionTLS12, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, 40},
+		{VersionTLS12, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, 56},
+		{VersionTLS12, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, 56},
+		{VersionTLS13, TLS_AES_128_GCM_SHA256, 40},
+		{VersionTLS13, TLS_AES_256_GCM_SHA384, 56},
+		{VersionTLS13, TLS_CHACHA20_POLY1305_SHA256, 56},
+	} {
+		c := &Conn{vers: tt.version, cipherSuite: tt.suite}
+		cipherType := c.kernelCipher()
+		if cipherType == 0 {
+			t.Errorf("%s: not supported by kernel TLS", CipherSuiteName(tt.suite))
+			continue
+		}
+		var key, iv []byte
+		if tt.version == VersionTLS13 {
+			suite := cipherSuiteTLS13ByID(tt.suite)
+			key, iv = make([]byte, suite.keyLen), make([]byte, aeadNonceLength)
+		} else {
+			suite := cipherSuiteByID(tt.suite)
+			key, iv = make([]byte, suite.keyLen), make([]byte, suite.ivLen)
+		}
+		info := &ktlsCryptoInfo{version: tt.version, cipherType: cipherType, key: key, recSeq: make([]byte, 8)}
+		switch {
+		case cipherType == ktlsCipherChaCha20Poly1305:
+			info.iv = iv
+		case tt.version == VersionTLS13:
+			info.salt, info.iv = iv[:4], iv[4:]
+		default:
+			info.salt, info.iv = iv, make([]byte, 8)
+		}
+		if got := len(info.marshal()); got != tt.want {
+			t.Errorf("%s: crypto info is %d bytes, want %d", CipherSuiteName(tt.suite), got, tt.want)
+		}
+	}
+
+	c := &Conn{vers: VersionTLS12, cipherSuite: TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}
+	if c.kernelCipher() != 0 {
+		t.Error("CBC cipher suite is supported by kernel TLS")
+	}
+}
+
+// kernelTLSSupported reports whether the kernel takes the keys of the given
+// version and cipher suite for the receive or transmit direction, on a
+// loopback connection of its own.
+func kernelTLSSupported(t *testing.T, version, suite uint16, rx bool) bool {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	c := &Conn{conn: client, vers: version, cipherSuite: suite}
+	hc := &halfConn{}
+	if version == VersionTLS13 {
+		s := cipherSuiteTLS13ByID(suite)
+		hc.key, hc.iv = make([]byte, s.keyLen), make([]byte, aeadNonceLength)
+	} else {
+		s := cipherSuiteByID(suite)
+		hc.key, hc.iv = make([]byte, s.keyLen), make([]byte, s.ivLen)
+	}
+	err = c.setKernelKeys(hc, rx)
+	t.Logf("kernel TLS keys (rx %v): %v", rx, err)
+	return err == nil
+}
+
+func TestKernelTLS(t *testing.T) {
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		t.Run(VersionName(version), func(t *testing.T) {
+			testKernelTLS(t, version)
+		})
+	}
+}
+
+func testKernelTLS(t *testing.T, version uint16) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no TCP listener: %v", err)
+	}
+	defer ln.Close()
+
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = version
+	serverConfig.KernelTX = true
+	serverConfig.KernelRX = true
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = version
+	clientConfig.KernelTX = true
+	clientConfig.KernelRX = true
+
+	// The kernel support depends on the negotiated cipher suite, which is the
+	// same for all the handshakes with these configs.
+	config := testConfig.Clone()
+	config.MaxVersion = version
+	_, cs, err := testHandshake(t, config, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kernelTLSSupported(t, version, cs.CipherSuite, false) ||
+		!kernelTLSSupported(t, version, cs.CipherSuite, true) {
+		t.Skip("kernel TLS is not supported")
+	}
+
+	msg := make([]byte, 100000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		srv := Server(c, serverConfig)
+		defer srv.Close()
+		if err := srv.Handshake(); err != nil {
+			errc <- err
+			return
+		}
+		t.Logf("server kernel TX: %v, RX: %v", srv.out.kernel, srv.in.kernel)
+		if !srv.out.kernel {
+			errc <- errors.New("kernel TX offload not enabled")
+			return
+		}
+		// The client doesn't send anything past its Finished before reading,
+		// so the server can hand the receive side over as well. The client
+		// may have buffered what follows the server Finished, and then keeps
+		// decrypting in userspace.
+		if !srv.in.kernel {
+			errc <- errors.New("kernel RX offload not enabled")
+			return
+		}
+		// Send msg from a file, which the kernel can do with sendfile.
+		f, err := os.CreateTemp(t.TempDir(), "msg")
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer f.Close()
+		if _, err := f.Write(msg); err != nil {
+			errc <- err
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			errc <- err
+			return
+		}
+		if n, err := io.Copy(srv, f); err != nil || n != int64(len(msg)) {
+			errc <- fmt.Errorf("sent %d bytes from file: %v", n, err)
+			return
+		}
+		// Echo back what the client sends.
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(srv, buf); err != nil {
+			errc <- err
+			return
+		}
+		_, err = srv.Write(buf)
+		errc <- err
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := Client(c, clientConfig)
+	defer cli.Close()
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(cli, buf); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if _, err := cli.Write(buf); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if _, err := io.ReadFull(cli, buf); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	for i := range buf {
+		if buf[i] != msg[i] {
+			t.Fatalf("received data differs at byte %d", i)
+		}
+	}
+
+	// The client replies with an alert through the kernel, if enabled.
+	t.Logf("client kernel TX: %v, RX: %v", cli.out.kernel, cli.in.kernel)
+	if !cli.out.kernel {
+		t.Error("client kernel TX offload not enabled")
+	}
+	if err := cli.Close(); err != nil {
+		t.Errorf("client Close: %v", err)
+	}
+}
+
+func TestKernelTLSBadRecord(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no TCP listener: %v", err)
+	}
+	defer ln.Close()
+
+	config := testConfig.Clone()
+	_, cs, err := testHandshake(t, config, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kernelTLSSupported(t, cs.Version, cs.CipherSuite, true) {
+		t.Skip("kernel TLS is not supported")
+	}
+
+	serverConfig := testConfig.Clone()
+	serverConfig.KernelRX = true
+	errc := make(chan error, 1)
+	ready := make(chan struct{})
+	go func() {
+		defer close(ready)
+		c, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		srv := Server(c, serverConfig)
+		defer srv.Close()
+		if err := srv.Handshake(); err != nil {
+			errc <- err
+			return
+		}
+		if !srv.in.kernel {
+			errc <- errors.New("kernel RX offload not enabled")
+			return
+		}
+		ready <- struct{}{}
+		_, err = srv.Read(make([]byte, 1))
+		errc <- err
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := Client(c, testConfig.Clone())
+	defer cli.Close()
+	if err := cli.Handshake(); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	// A record the kernel can't decrypt, once it has the keys.
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server: %v", <-errc)
+	}
+	record := append([]byte{byte(recordTypeApplicationData), 3, 3, 0, 32}, make([]byte, 32)...)
+	if _, err := c.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "bad record MAC") {
+		t.Errorf("server: %v, want a bad record MAC error", err)
+	}
+	if _, err := cli.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "remote error: tls: bad record MAC") {
+		t.Errorf("client: %v, want a bad_record_mac alert", err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package tls
+
+import "syscall"
+
+func ktlsSetKeys(syscall.RawConn, bool, *ktlsCryptoInfo) error {
+	return errKernelTLSUnsupported
+}
+
+func ktlsSendRecord(syscall.RawConn, recordType, []byte) (int, error) {
+	return 0, errKernelTLSUnsupported
+}
+
+const ktlsRecordTypeSpace = 0
+
+func ktlsRecvRecord(syscall.RawConn, []byte, []byte) (recordType, int, error) {
+	return 0, 0, errKernelTLSUnsupported
+}
@@ -0,0 +1,59 @@
+package tls
+
+import "time"
+
+// A MetricsRecorder receives the outcome of the handshakes of the
+// connections of a Config, to count them by version, cipher suite,
+// resumption or alert, and to measure their latency, with a metrics system
+// such as Prometheus. [VersionName], [CipherSuiteName] and
+// [AlertError.Error] make labels of the values. It is set in
+// [Config.MetricsRecorder], and must be safe for concurrent use.
+type MetricsRecorder interface {
+	// RecordHandshake is called when a handshake completes or fails.
+	RecordHandshake(m HandshakeMetrics)
+}
+
+// HandshakeMetrics describes a handshake, reported to a MetricsRecorder.
+type HandshakeMetrics struct {
+	IsClient bool
+
+	// Version, CipherSuite and DidResume are those of ConnectionState, set
+	// if the handshake got that far.
+	Version     uint16
+	CipherSuite uint16
+	DidResume   bool
+
+	// Duration is the time the handshake took, from the call that started
+	// it, and including the round trips and the callbacks.
+	Duration time.Duration
+
+	// Err is the error of the handshake, if it failed.
+	Err error
+
+	// Alert is the alert that ended a failed handshake, if AlertSent or
+	// AlertReceived is set, as it was sent to the peer or received from it.
+	Alert         AlertError
+	AlertSent     bool
+	AlertReceived bool
+}
+
+// recordHandshake reports the handshake that just completed or failed to
+// Config.MetricsRecorder.
+func (c *Conn) recordHandshake() {
+	recorder := c.config.MetricsRecorder
+	if recorder == nil {
+		return
+	}
+	m := HandshakeMetrics{
+		IsClient:    c.isClient,
+		Version:     c.vers,
+		CipherSuite: c.cipherSuite,
+		DidResume:   c.didResume,
+		Duration:    time.Since(c.handshakeStart),
+		Err:         c.handshakeErr,
+	}
+	if a, ok := errorsAsType[*DirectedAlertError](m.Err); ok {
+		m.Alert, m.AlertSent, m.AlertReceived = a.Alert, a.Sent, !a.Sent
+	}
+	recorder.RecordHandshake(m)
+}
@@ -0,0 +1,65 @@
+package tls
+
+import (
+	"sync"
+	"testing"
+)
+
+// metricsLog is a MetricsRecorder keeping the metrics it receives.
+type metricsLog struct {
+	sync.Mutex
+	handshakes []HandshakeMetrics
+}
+
+func (l *metricsLog) RecordHandshake(m HandshakeMetrics) {
+	l.Lock()
+	defer l.Unlock()
+	l.handshakes = append(l.handshakes, m)
+}
+
+func (l *metricsLog) last(t *testing.T) HandshakeMetrics {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.handshakes) == 0 {
+		t.Fatal("no handshake recorded")
+	}
+	return l.handshakes[len(l.handshakes)-1]
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	clientLog, serverLog := &metricsLog{}, &metricsLog{}
+	clientConfig := testConfig.Clone()
+	clientConfig.MetricsRecorder = clientLog
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	serverConfig := testConfig.Clone()
+	serverConfig.MetricsRecorder = serverLog
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range []HandshakeMetrics{clientLog.last(t), serverLog.last(t)} {
+			if m.Err != nil || m.Version != VersionTLS13 || m.CipherSuite == 0 || m.DidResume != (i == 1) || m.Duration <= 0 {
+				t.Errorf("connection %d: %+v", i, m)
+			}
+		}
+		if m := clientLog.last(t); !m.IsClient {
+			t.Errorf("client recorded %+v", m)
+		}
+	}
+
+	clientConfig.MaxVersion = VersionTLS12
+	serverConfig.MinVersion = VersionTLS13
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+		t.Fatal("handshake succeeded")
+	}
+	if m := serverLog.last(t); m.Err == nil || !m.AlertSent || m.AlertReceived || m.Alert != AlertError(alertProtocolVersion) {
+		t.Errorf("server recorded %+v", m)
+	}
+	if m := clientLog.last(t); m.Err == nil || m.AlertSent || !m.AlertReceived || m.Alert != AlertError(alertProtocolVersion) {
+		t.Errorf("client recorded %+v", m)
+	}
+	if n := len(clientLog.handshakes); n != 3 {
+		t.Errorf("client recorded %d handshakes, want 3", n)
+	}
+}
@@ -0,0 +1,240 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	decoyMirrorInterval = time.Hour
+	decoySampleTimeout  = 30 * time.Second
+)
+
+// A DecoyMirror samples the TLS 1.3 handshake of a live decoy site, and
+// shapes the handshakes of the servers using it after it, so that active
+// probes see the same responses from both: the ServerHello negotiates the
+// cipher suite and key exchange of the decoy when the client offers them,
+// and the encrypted records of the server's flight, which hold its
+// certificate chain, are padded to the sizes of those of the decoy. Records
+// larger than their counterparts are left alone, so the certificate chain
+// should be no larger than the decoy's.
+//
+// The decoy is sampled again in the background every Interval, to follow
+// changes of its certificate or configuration. A DecoyMirror is set in
+// [Config.DecoyMirror], and can be shared by multiple Configs. It must not be
+// copied or have its fields modified after it's started with Start, and must
+// be closed with Close when it's no longer needed.
+type DecoyMirror struct {
+	// Address is the address of the decoy, such as "example.com:443".
+	Address string
+
+	// ServerName is the server name sent to the decoy. If empty, the host
+	// of Address is used.
+	ServerName string
+
+	// DialContext dials Address over TCP. If nil, a net.Dialer is used.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Interval is the time between two samples. If zero, it's one hour.
+	Interval time.Duration
+
+	// OnError, if not nil, is called with the errors of background samples,
+	// after which the last successful sample keeps being mirrored.
+	OnError func(err error)
+
+	profile atomic.Pointer[decoyProfile]
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	closed bool
+}
+
+// A decoyProfile is what's mirrored from a sample of the decoy.
+type decoyProfile struct {
+	cipherSuite uint16
+	curveID     CurveID
+	// flight is the plaintext length of each encrypted record of the
+	// decoy's first flight, from EncryptedExtensions to Finished.
+	flight []int
+}
+
+// Start samples the decoy, returning the error if it fails, and then keeps
+// sampling it in the background until Close is called.
+func (m *DecoyMirror) Start(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return errors.New("tls: DecoyMirror is closed")
+	}
+	started := m.timer != nil
+	m.mu.Unlock()
+	if started {
+		return errors.New("tls: DecoyMirror already started")
+	}
+
+	err := m.sample(ctx)
+	m.schedule()
+	return err
+}
+
+// Close stops the background samples. The last sample keeps being mirrored.
+func (m *DecoyMirror) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	return nil
+}
+
+func (m *DecoyMirror) schedule() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	interval := m.Interval
+	if interval <= 0 {
+		interval = decoyMirrorInterval
+	}
+	m.timer = time.AfterFunc(interval, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), decoySampleTimeout)
+		defer cancel()
+		if err := m.sample(ctx); err != nil && m.OnError != nil {
+			m.OnError(err)
+		}
+		m.schedule()
+	})
+}
+
+// sample runs a handshake with the decoy, recording the records it sends.
+func (m *DecoyMirror) sample(ctx context.Context) error {
+	dial := m.DialContext
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	serverName := m.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(m.Address)
+		if err != nil {
+			return err
+		}
+		serverName = host
+	}
+	conn, err := dial(ctx, "tcp", m.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	recorder := &readRecorder{Conn: conn}
+	client := Client(recorder, &Config{
+		ServerName: serverName,
+		// The decoy is only measured.
+		InsecureSkipVerify: true,
+		MinVersion:         VersionTLS13,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	if err := client.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	state := client.ConnectionState()
+	profile := &decoyProfile{cipherSuite: state.CipherSuite, curveID: state.CurveID}
+	overhead := client.in.cipher.(aead).Overhead()
+	// The client read the decoy's flight and nothing more before sending its
+	// Finished, after the ServerHello and the compatibility
+	// ChangeCipherSpec.
+	for b := recorder.read; len(b) >= recordHeaderLen; {
+		n := int(b[3])<<8 | int(b[4])
+		if len(b) < recordHeaderLen+n {
+			break
+		}
+		if recordType(b[0]) == recordTypeApplicationData && n > overhead {
+			profile.flight = append(profile.flight, n-overhead-1)
+		}
+		b = b[recordHeaderLen+n:]
+	}
+	if len(profile.flight) == 0 {
+		return errors.New("tls: no encrypted records received from the decoy")
+	}
+	m.profile.Store(profile)
+	return nil
+}
+
+// current returns the last sample of the decoy, or nil if there's none.
+func (m *DecoyMirror) current() *decoyProfile {
+	if m == nil {
+		return nil
+	}
+	return m.profile.Load()
+}
+
+// A readRecorder is a net.Conn recording what's read from it.
+type readRecorder struct {
+	net.Conn
+	read []byte
+}
+
+func (r *readRecorder) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	r.read = append(r.read, b[:n]...)
+	return n, err
+}
+
+// preferFirst returns list with id moved to the front, if it's in it.
+func preferFirst[T comparable](list []T, id T) []T {
+	i := slicesIndexFunc(list, func(x T) bool { return x == id })
+	if i <= 0 {
+		return list
+	}
+	ordered := append([]T{id}, list[:i]...)
+	return append(ordered, list[i+1:]...)
+}
+
+// A decoyFlight pads the encrypted records of the server's first flight after
+// those of the decoy's.
+type decoyFlight struct {
+	targets []int
+	// records and sent are the number of records sent so far, and the sum
+	// of their plaintext lengths, padding included.
+	records, sent int
+	// last is set before the Finished record, which makes up for the
+	// difference with the whole flight of the decoy.
+	last bool
+}
+
+func newDecoyFlight(profile *decoyProfile) *decoyFlight {
+	if profile == nil {
+		return nil
+	}
+	return &decoyFlight{targets: profile.flight}
+}
+
+// padding returns the padding of the next record, with n bytes of content
+// and room for max.
+func (f *decoyFlight) padding(n, max int) int {
+	target := 0
+	if f.last {
+		for _, t := range f.targets {
+			target += t
+		}
+		target -= f.sent
+	} else if f.records < len(f.targets)-1 {
+		// The last record of the decoy is left for the Finished.
+		target = f.targets[f.records]
+	}
+	padding := target - n
+	if padding < 0 {
+		padding = 0
+	} else if padding > max-n {
+		padding = max - n
+	}
+	f.records++
+	f.sent += n + padding
+	return padding
+}
@@ -0,0 +1,91 @@
+package tls
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDecoyMirror(t *testing.T) {
+	decoyConfig := testConfig.Clone()
+	decoyConfig.AESGCMPreference = AESGCMPreferenceNever
+	decoyConfig.CurvePreferences = []CurveID{X25519}
+	mirror := &DecoyMirror{
+		Address: "decoy.example:443",
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, s := localPipe(t)
+			go func() {
+				decoy := Server(s, decoyConfig)
+				decoy.Handshake()
+				decoy.Close()
+			}()
+			return c, nil
+		},
+	}
+	if err := mirror.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer mirror.Close()
+	decoy := mirror.current()
+	if decoy.cipherSuite != TLS_CHACHA20_POLY1305_SHA256 || decoy.curveID != X25519 {
+		t.Errorf("sampled %s and %s", CipherSuiteName(decoy.cipherSuite), decoy.curveID)
+	}
+	// EncryptedExtensions, Certificate, CertificateVerify and Finished.
+	if len(decoy.flight) != 4 {
+		t.Fatalf("sampled %d encrypted records, want 4", len(decoy.flight))
+	}
+
+	// A smaller certificate is padded to the decoy's.
+	serverConfig := testConfig.Clone()
+	serverConfig.CurvePreferences = nil
+	serverConfig.Certificates = []Certificate{{
+		Certificate: [][]byte{testEd25519Certificate},
+		PrivateKey:  testEd25519PrivateKey,
+	}}
+	serverConfig.DecoyMirror = mirror
+	c, s := localPipe(t)
+	go func() {
+		server := Server(s, serverConfig)
+		server.Handshake()
+		server.Close()
+	}()
+	probe := &DecoyMirror{
+		Address: "server.example:443",
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return c, nil
+		},
+	}
+	if err := probe.sample(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := probe.current(); !reflect.DeepEqual(got, decoy) {
+		t.Errorf("server looks like %+v, decoy like %+v", got, decoy)
+	}
+}
+
+func TestDecoyFlight(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		targets []int
+		records []int // the last one is the Finished
+		want    []int
+	}{
+		{"Matching", []int{10, 500, 100, 36}, []int{6, 300, 80, 36}, []int{4, 200, 20, 0}},
+		{"Larger", []int{10, 500, 100, 36}, []int{6, 600, 80, 36}, []int{4, 0, 20, 0}},
+		{"OneRecord", []int{1000}, []int{6, 300, 80, 36}, []int{0, 0, 0, 578}},
+		{"MoreRecords", []int{10, 500, 100, 20, 36}, []int{6, 300, 80, 36}, []int{4, 200, 20, 20}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newDecoyFlight(&decoyProfile{flight: tt.targets})
+			var got []int
+			for i, n := range tt.records {
+				f.last = i == len(tt.records)-1
+				got = append(got, f.padding(n, maxPlaintext))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got padding %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package tls
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// A TrafficMorpher shapes the TLS 1.3 records of application data sent on
+// established connections after a target traffic profile, by choosing their
+// lengths, which splits and pads the writes, and sending dummy records among
+// them, for research and against traffic fingerprinting. It is set in
+// [Config.TrafficMorpher], and applies after [HandshakeScript.Records].
+// Dummy records can also be sent at any time with [Conn.WriteDummyRecord].
+type TrafficMorpher interface {
+	// NextRecord is called before each record of application data, with
+	// pending bytes of the write left to send. It returns the length of the
+	// content of the record, at most max, made of data and padding, and
+	// whether it's a dummy record holding only padding, sent before the
+	// data. If length is zero or less, the record is sent as usual.
+	// rand is the source of randomness of the Config.
+	NextRecord(rand io.Reader, pending, max int) (length int, dummy bool)
+}
+
+// LengthDistributionMorpher returns a TrafficMorpher which sends records of
+// lengths drawn from lengths, with the relative weights, splitting the
+// writes that don't fit and padding the others. Unlike
+// [LengthDistributionPadding], short records can be drawn for long writes.
+func LengthDistributionMorpher(lengths, weights []int) TrafficMorpher {
+	return lengthDistributionMorpher{newLengthDistribution(lengths, weights)}
+}
+
+type lengthDistributionMorpher struct {
+	p *lengthDistributionPadding
+}
+
+func (m lengthDistributionMorpher) NextRecord(rand io.Reader, _, _ int) (int, bool) {
+	// The same draw as the padding of a record of zero bytes.
+	return m.p.Padding(rand, 0, 0), false
+}
+
+// morphedRecord returns the length and kind of the next record of type typ
+// of Config.TrafficMorpher, at most max, with pending bytes to send, or
+// false if it doesn't apply.
+func (c *Conn) morphedRecord(typ recordType, pending, max int) (length int, dummy, ok bool) {
+	morpher := c.config.TrafficMorpher
+	if morpher == nil || typ != recordTypeApplicationData ||
+		c.out.version != VersionTLS13 || c.out.cipher == nil || !c.isHandshakeComplete.Load() {
+		return 0, false, false
+	}
+	length, dummy = morpher.NextRecord(c.config.rand(), pending, max)
+	if length <= 0 {
+		return 0, false, false
+	}
+	// Peers give up after too many records without data in a row.
+	if dummy && c.morphedDummies >= maxUselessRecords {
+		dummy = false
+	}
+	if dummy {
+		c.morphedDummies++
+	} else {
+		c.morphedDummies = 0
+	}
+	if length > max {
+		length = max
+	}
+	if limit := c.peerRecordSizeLimit; limit != 0 && length > limit {
+		length = limit
+	}
+	return length, dummy, true
+}
+
+// WriteDummyRecord sends a TLS 1.3 record of application data holding
+// length bytes of padding, and no data, which peers drop. It fails on
+// connections of other versions, with QUIC or kernel TLS, and if length
+// exceeds the record size limit of the peer, see
+// [ConnectionState.RecordSizeLimit]. Peers implementing this package abort
+// the connections sending more records without data in a row than
+// maxUselessRecords, 16, including those of Config.TrafficMorpher.
+func (c *Conn) WriteDummyRecord(length int) error {
+	// interlock with Close, as in Write
+	for {
+		x := c.activeCall.Load()
+		if x&1 != 0 {
+			return net.ErrClosed
+		}
+		if c.activeCall.CompareAndSwap(x, x+2) {
+			break
+		}
+	}
+	defer c.activeCall.Add(-2)
+
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	if err := c.out.err; err != nil {
+		return err
+	}
+	if c.closeNotifySent {
+		return errShutdown
+	}
+	if c.vers != VersionTLS13 || c.quic != nil || c.out.kernel {
+		return errors.New("tls: dummy records require TLS 1.3 without QUIC or kernel TLS")
+	}
+	if length < 0 || length > maxPlaintext {
+		return errors.New("tls: invalid dummy record length")
+	}
+	if limit := c.peerRecordSizeLimit; limit != 0 && length > limit {
+		return errors.New("tls: dummy record length exceeds the record size limit of the peer")
+	}
+
+	record := []byte{byte(recordTypeApplicationData), 3, 3, 0, 0}
+	c.out.padding = length
+	seq := c.out.seqNum()
+	record, err := c.out.encrypt(record, nil, c.config.rand())
+	if err != nil {
+		return err
+	}
+	if c.config.RecordObserver != nil || c.qlog != nil {
+		c.observeRecord(&c.out, recordTypeApplicationData, seq, len(record), nil)
+	}
+	_, err = c.write(record)
+	return err
+}
@@ -0,0 +1,122 @@
+package tls
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+// shapeList is a TrafficMorpher returning its records in order, and then
+// the last one.
+type shapeList []ScriptedRecord
+
+func (l *shapeList) NextRecord(_ io.Reader, _, _ int) (int, bool) {
+	r := (*l)[0]
+	if len(*l) > 1 {
+		*l = (*l)[1:]
+	}
+	return r.Length, r.Dummy
+}
+
+// morphedLengths returns the lengths of the records of application data sent
+// by a client with morpher for write, and the server reading n bytes.
+func morphedLengths(t *testing.T, morpher TrafficMorpher, n int, write func(*Conn) error) []int {
+	clientConfig := testConfig.Clone()
+	clientConfig.TrafficMorpher = morpher
+	c, s := localPipe(t)
+	recorder := &writeRecorder{Conn: c}
+	client := Client(recorder, clientConfig)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		if err := server.Handshake(); err != nil {
+			errChan <- err
+			return
+		}
+		_, err := io.ReadFull(server, make([]byte, n))
+		errChan <- err
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Lock()
+	before := len(recorder.written)
+	recorder.Unlock()
+	if err := write(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	recorder.Lock()
+	defer recorder.Unlock()
+	return recordLengths(recorder.written[before:])
+}
+
+func TestTrafficMorpher(t *testing.T) {
+	write := func(c *Conn) error {
+		if _, err := c.Write(make([]byte, 300)); err != nil {
+			return err
+		}
+		return c.WriteDummyRecord(80)
+	}
+	shapes := &shapeList{{Length: 50, Dummy: true}, {Length: 120}}
+	got := morphedLengths(t, shapes, 300, write)
+	if want := []int{50, 120, 120, 120, 80}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records of %v bytes, want %v", got, want)
+	}
+
+	got = morphedLengths(t, LengthDistributionMorpher([]int{100}, []int{1}), 300, write)
+	if want := []int{100, 100, 100, 80}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records of %v bytes, want %v", got, want)
+	}
+
+	// Data is sent after as many dummy records as peers accept.
+	got = morphedLengths(t, &shapeList{{Length: 10, Dummy: true}}, 5, func(c *Conn) error {
+		_, err := c.Write(make([]byte, 5))
+		return err
+	})
+	if len(got) != maxUselessRecords+1 || got[maxUselessRecords] != 10 {
+		t.Errorf("records of %v bytes", got)
+	}
+}
+
+func TestWriteDummyRecordTLS12(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+	go server.Handshake()
+	if err := client.WriteDummyRecord(10); err == nil {
+		t.Error("dummy record sent with TLS 1.2")
+	}
+}
+
+func TestWriteDummyRecordSizeLimit(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.RecordSizeLimit = 16384
+	serverConfig := testConfig.Clone()
+	serverConfig.RecordSizeLimit = 100
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, serverConfig)
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		if server.Handshake() == nil {
+			io.Copy(io.Discard, server)
+		}
+	}()
+	if err := client.WriteDummyRecord(101); err == nil {
+		t.Error("dummy record larger than the record size limit sent")
+	}
+	if err := client.WriteDummyRecord(100); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,302 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// maxOCSPResponseSize bounds the responses read from OCSP responders,
+	// which are usually a few kilobytes.
+	maxOCSPResponseSize = 1 << 16
+
+	ocspFetchTimeout     = 30 * time.Second
+	ocspMinRetryInterval = time.Minute
+	ocspMaxRetryInterval = time.Hour
+)
+
+// An OCSPStapler keeps OCSP responses for server certificates fresh, so that
+// they can be stapled to handshakes without the certificates' OCSPStaple
+// fields being managed by hand.
+//
+// Responses are fetched from the responders listed in the
+// AuthorityInformationAccess extension of each leaf certificate, validated
+// against its issuer, and refreshed in the background at a random point past
+// the middle of their validity period, so that fleets of servers don't query
+// responders all at once. Failed fetches are retried with exponential backoff,
+// and the last valid response keeps being stapled until its NextUpdate.
+//
+// An OCSPStapler is set in [Config.OCSPStapler], and can be shared by
+// multiple Configs. It must not be copied or have its fields modified after
+// first use, and must be closed with Close when it's no longer needed.
+type OCSPStapler struct {
+	// HTTPClient is used to query OCSP responders. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// OnError, if not nil, is called with the errors of background refreshes,
+	// which are otherwise retried silently.
+	OnError func(leaf *x509.Certificate, err error)
+
+	mu      sync.Mutex
+	entries map[string]*ocspEntry // by leaf certificate DER
+	closed  bool
+}
+
+type ocspEntry struct {
+	leaf, issuer *x509.Certificate
+	staple       atomic.Pointer[ocspStaple]
+	ctx          context.Context
+	cancel       context.CancelFunc
+	timer        *time.Timer
+	failures     int
+}
+
+type ocspStaple struct {
+	raw                    []byte
+	thisUpdate, nextUpdate time.Time
+}
+
+// Add starts managing the OCSP response of cert, whose chain must include
+// the issuer of its leaf certificate. It fetches a first response before
+// returning, and returns the error of the fetch if it fails, in which case
+// it is retried in the background. Adding a certificate that is already
+// managed has no effect.
+func (s *OCSPStapler) Add(ctx context.Context, cert *Certificate) error {
+	if len(cert.Certificate) < 2 {
+		return errors.New("tls: OCSP stapling requires the issuer certificate in the chain")
+	}
+	leaf, err := cert.leaf()
+	if err != nil {
+		return err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return errors.New("tls: certificate doesn't list an OCSP responder")
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("tls: OCSPStapler is closed")
+	}
+	if _, ok := s.entries[string(cert.Certificate[0])]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]*ocspEntry)
+	}
+	e := &ocspEntry{leaf: leaf, issuer: issuer}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	s.entries[string(cert.Certificate[0])] = e
+	s.mu.Unlock()
+
+	err = s.refresh(ctx, e)
+	if err != nil {
+		err = fmt.Errorf("tls: failed to fetch OCSP response: %w", err)
+	}
+	return err
+}
+
+// Remove stops managing the OCSP response of cert.
+func (s *OCSPStapler) Remove(cert *Certificate) {
+	if len(cert.Certificate) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[string(cert.Certificate[0])]; ok {
+		e.stop()
+		delete(s.entries, string(cert.Certificate[0]))
+	}
+}
+
+// Close stops all background refreshes. Responses that were already fetched
+// keep being returned by Staple until they expire.
+func (s *OCSPStapler) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, e := range s.entries {
+		e.stop()
+	}
+	return nil
+}
+
+// Staple returns the current OCSP response for cert, or nil if it isn't
+// managed or if no valid response is available.
+func (s *OCSPStapler) Staple(cert *Certificate) []byte {
+	if len(cert.Certificate) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	e := s.entries[string(cert.Certificate[0])]
+	s.mu.Unlock()
+	if e == nil {
+		return nil
+	}
+	staple := e.staple.Load()
+	if staple == nil || !time.Now().Before(staple.nextUpdate) {
+		return nil
+	}
+	return staple.raw
+}
+
+func (e *ocspEntry) stop() {
+	e.cancel()
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+}
+
+// refresh fetches a new response for e and schedules the next refresh.
+func (s *OCSPStapler) refresh(ctx context.Context, e *ocspEntry) error {
+	staple, err := s.fetch(ctx, e)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.ctx.Err() != nil {
+		return err // removed or closed while fetching
+	}
+	var delay time.Duration
+	if err != nil {
+		delay = ocspMinRetryInterval << e.failures
+		if delay > ocspMaxRetryInterval || delay <= 0 {
+			delay = ocspMaxRetryInterval
+		} else {
+			e.failures++
+		}
+	} else {
+		e.failures = 0
+		e.staple.Store(staple)
+		delay = ocspRefreshDelay(staple, time.Now(), mathrand.Int63n)
+	}
+	e.timer = time.AfterFunc(delay, func() {
+		ctx, cancel := context.WithTimeout(e.ctx, ocspFetchTimeout)
+		defer cancel()
+		if err := s.refresh(ctx, e); err != nil && s.OnError != nil && e.ctx.Err() == nil {
+			s.OnError(e.leaf, err)
+		}
+	})
+	return err
+}
+
+// ocspRefreshDelay returns how long to wait before refreshing staple, at a
+// random point between the middle and three quarters of its validity period.
+// randInt63n is the source of randomness, for testing.
+func ocspRefreshDelay(staple *ocspStaple, now time.Time, randInt63n func(int64) int64) time.Duration {
+	validity := staple.nextUpdate.Sub(staple.thisUpdate)
+	refreshAt := staple.thisUpdate.Add(validity / 2)
+	if window := int64(validity / 4); window > 0 {
+		refreshAt = refreshAt.Add(time.Duration(randInt63n(window)))
+	}
+	if delay := refreshAt.Sub(now); delay > ocspMinRetryInterval {
+		return delay
+	}
+	return ocspMinRetryInterval
+}
+
+// fetch queries the OCSP responders of e.leaf in order, and returns the first
+// valid response.
+func (s *OCSPStapler) fetch(ctx context.Context, e *ocspEntry) (*ocspStaple, error) {
+	req, err := ocsp.CreateRequest(e.leaf, e.issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, server := range e.leaf.OCSPServer {
+		raw, err := s.query(ctx, server, req)
+		if err == nil {
+			var staple *ocspStaple
+			if staple, err = parseOCSPStaple(raw, e.leaf, e.issuer, time.Now()); err == nil {
+				return staple, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *OCSPStapler) query(ctx context.Context, server string, req []byte) ([]byte, error) {
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	httpReq.Header.Set("Accept", "application/ocsp-response")
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxOCSPResponseSize {
+		return nil, errors.New("OCSP response too large")
+	}
+	return raw, nil
+}
+
+// parseOCSPStaple checks that raw is an OCSP response for leaf signed on
+// behalf of issuer, reporting it as good and valid at now.
+func parseOCSPStaple(raw []byte, leaf, issuer *x509.Certificate, now time.Time) (*ocspStaple, error) {
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		return nil, errors.New("certificate is revoked")
+	default:
+		return nil, errors.New("certificate status is unknown")
+	}
+	// Responses without a NextUpdate could be stapled indefinitely, and
+	// aren't used by the CAs issuing certificates to public servers.
+	if resp.NextUpdate.IsZero() {
+		return nil, errors.New("OCSP response has no NextUpdate")
+	}
+	if !now.Before(resp.NextUpdate) || !resp.NextUpdate.After(resp.ThisUpdate) {
+		return nil, errors.New("OCSP response has expired")
+	}
+	return &ocspStaple{raw: raw, thisUpdate: resp.ThisUpdate, nextUpdate: resp.NextUpdate}, nil
+}
+
+// stapleCertificate returns cert with the OCSP response of c.OCSPStapler, if
+// it has a valid one for it.
+func (c *Config) stapleCertificate(cert *Certificate) *Certificate {
+	if c.OCSPStapler == nil {
+		return cert
+	}
+	staple := c.OCSPStapler.Staple(cert)
+	if staple == nil {
+		return cert
+	}
+	stapled := *cert
+	stapled.OCSPStaple = staple
+	return &stapled
+}
@@ -0,0 +1,227 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testOCSPResponder serves OCSP responses for the certificates issued by its
+// CA, reporting them with status.
+type testOCSPResponder struct {
+	*httptest.Server
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	signer crypto.Signer // signs the responses, caKey if nil
+	status atomic.Int32
+	hits   atomic.Int32
+}
+
+func newTestOCSPResponder(t *testing.T) *testOCSPResponder {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "OCSP test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &testOCSPResponder{ca: ca, caKey: caKey}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.hits.Add(1)
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ocspReq, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now().Truncate(time.Second)
+		tmpl := ocsp.Response{
+			Status:       int(r.status.Load()),
+			SerialNumber: ocspReq.SerialNumber,
+			ThisUpdate:   now.Add(-time.Hour),
+			NextUpdate:   now.Add(72 * time.Hour),
+		}
+		if tmpl.Status == ocsp.Revoked {
+			tmpl.RevokedAt = now.Add(-time.Hour)
+		}
+		signer := r.signer
+		if signer == nil {
+			signer = caKey
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, tmpl, signer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// issue returns a certificate issued by the CA of r, with r as its responder.
+func (r *testOCSPResponder) issue(t *testing.T, serial int64) Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.golang"},
+		DNSNames:     []string{"example.golang"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{r.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, r.ca, key.Public(), r.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Certificate{Certificate: [][]byte{der, r.ca.Raw}, PrivateKey: key}
+}
+
+func TestOCSPStapler(t *testing.T) {
+	responder := newTestOCSPResponder(t)
+	cert := responder.issue(t, 2)
+	stapler := &OCSPStapler{}
+	defer stapler.Close()
+	if err := stapler.Add(context.Background(), &cert); err != nil {
+		t.Fatal(err)
+	}
+	staple := stapler.Staple(&cert)
+	if staple == nil {
+		t.Fatal("no OCSP response after Add")
+	}
+	// Adding the certificate again, even as a copy, doesn't fetch again.
+	certCopy := cert
+	if err := stapler.Add(context.Background(), &certCopy); err != nil {
+		t.Fatal(err)
+	}
+	if hits := responder.hits.Load(); hits != 1 {
+		t.Errorf("responder queried %d times, want 1", hits)
+	}
+
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		serverConfig := testConfig.Clone()
+		serverConfig.MaxVersion = version
+		serverConfig.Certificates = []Certificate{cert}
+		serverConfig.NameToCertificate = nil
+		serverConfig.OCSPStapler = stapler
+		clientConfig := testConfig.Clone()
+		clientConfig.MaxVersion = version
+		_, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %v", version, err)
+		}
+		if !bytes.Equal(cs.OCSPResponse, staple) {
+			t.Errorf("%x: OCSP response not stapled", version)
+		}
+	}
+
+	stapler.Remove(&cert)
+	if stapler.Staple(&cert) != nil {
+		t.Error("OCSP response returned after Remove")
+	}
+}
+
+func TestOCSPStaplerInvalidResponses(t *testing.T) {
+	responder := newTestOCSPResponder(t)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		status int
+		signer crypto.Signer
+		err    string
+	}{
+		{name: "Revoked", status: ocsp.Revoked, err: "revoked"},
+		{name: "Unknown", status: ocsp.Unknown, err: "unknown"},
+		{name: "WrongSigner", status: ocsp.Good, signer: otherKey, err: "signature"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			responder.status.Store(int32(tt.status))
+			responder.signer = tt.signer
+			cert := responder.issue(t, 3)
+			stapler := &OCSPStapler{}
+			defer stapler.Close()
+			err := stapler.Add(context.Background(), &cert)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if stapler.Staple(&cert) != nil {
+				t.Error("invalid OCSP response is stapled")
+			}
+		})
+	}
+
+	stapler := &OCSPStapler{}
+	noIssuer := responder.issue(t, 4)
+	noIssuer.Certificate = noIssuer.Certificate[:1]
+	if err := stapler.Add(context.Background(), &noIssuer); err == nil {
+		t.Error("added a certificate without an issuer")
+	}
+	stapler.Close()
+	withIssuer := responder.issue(t, 5)
+	if err := stapler.Add(context.Background(), &withIssuer); err == nil {
+		t.Error("added a certificate to a closed stapler")
+	}
+}
+
+func TestOCSPRefreshDelay(t *testing.T) {
+	thisUpdate := time.Unix(1700000000, 0)
+	staple := &ocspStaple{thisUpdate: thisUpdate, nextUpdate: thisUpdate.Add(4 * 24 * time.Hour)}
+
+	earliest := ocspRefreshDelay(staple, thisUpdate, func(int64) int64 { return 0 })
+	if earliest != 2*24*time.Hour {
+		t.Errorf("earliest refresh after %v, want half of the validity period", earliest)
+	}
+	latest := ocspRefreshDelay(staple, thisUpdate, func(n int64) int64 { return n - 1 })
+	if latest >= 3*24*time.Hour || latest <= earliest {
+		t.Errorf("latest refresh after %v, want before three quarters of the validity period", latest)
+	}
+	late := ocspRefreshDelay(staple, thisUpdate.Add(3*24*time.Hour), func(int64) int64 { return 0 })
+	if late != ocspMinRetryInterval {
+		t.Errorf("refresh past the refresh window after %v, want %v", late, ocspMinRetryInterval)
+	}
+}
@@ -0,0 +1,137 @@
+package tls
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A RecordPacing delays the writes of the records of application data, to
+// shape the timing of the traffic sent, such as that of a TLS connection
+// tunnelled in another, whose handshake would otherwise be sent at once. It
+// is set in [Config.RecordPacing].
+//
+// Each record is sent after the previous one with a delay of Delay, plus a
+// random jitter from zero to Jitter, except the first records sent after the
+// handshake, up to InitialRecords of them, which use InitialDelay instead of
+// Delay. The first record is sent without delay.
+//
+// The delays hold the write side of the connection, until they end, its write
+// deadline passes or it's closed. The records of a Write are sent as they're
+// paced, rather than together.
+type RecordPacing struct {
+	Delay  time.Duration
+	Jitter time.Duration
+
+	InitialRecords int
+	InitialDelay   time.Duration
+}
+
+// delay returns the delay before the record of application data following
+// the sent ones.
+func (p *RecordPacing) delay(rand io.Reader, sent int) time.Duration {
+	if p == nil || sent == 0 {
+		return 0
+	}
+	d := p.Delay
+	if sent < p.InitialRecords {
+		d = p.InitialDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(randIntn(rand, int(p.Jitter)+1))
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// recordDelay returns the delay before the next record of type typ, and
+// counts it.
+func (c *Conn) recordDelay(typ recordType) time.Duration {
+	if typ != recordTypeApplicationData || c.config.RecordPacing == nil {
+		return 0
+	}
+	d := c.config.RecordPacing.delay(c.config.rand(), c.pacedRecords)
+	c.pacedRecords++
+	return d
+}
+
+// A writeWaiter waits for the delays of the records sent, until the write
+// deadline of the Conn passes or it's closed.
+type writeWaiter struct {
+	mu       sync.Mutex
+	deadline time.Time
+	closed   bool
+	// changed is closed when deadline or closed changes, to wake the waits.
+	changed chan struct{}
+}
+
+func (w *writeWaiter) setDeadline(t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deadline = t
+	w.notifyLocked()
+}
+
+func (w *writeWaiter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.notifyLocked()
+}
+
+func (w *writeWaiter) notifyLocked() {
+	if w.changed != nil {
+		close(w.changed)
+		w.changed = nil
+	}
+}
+
+// wait waits for d. It returns os.ErrDeadlineExceeded if the write deadline
+// passes first, and net.ErrClosed if the Conn is closed first.
+func (w *writeWaiter) wait(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var deadlineTimer *time.Timer
+	defer func() {
+		if deadlineTimer != nil {
+			deadlineTimer.Stop()
+		}
+	}()
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return net.ErrClosed
+		}
+		deadline := w.deadline
+		if w.changed == nil {
+			w.changed = make(chan struct{})
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			until := time.Until(deadline)
+			if until <= 0 {
+				return os.ErrDeadlineExceeded
+			}
+			if deadlineTimer != nil {
+				deadlineTimer.Stop()
+			}
+			deadlineTimer = time.NewTimer(until)
+			expired = deadlineTimer.C
+		}
+		select {
+		case <-timer.C:
+			return nil
+		case <-expired:
+			return os.ErrDeadlineExceeded
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package tls
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecordPacingDelay(t *testing.T) {
+	p := &RecordPacing{Delay: 10 * time.Millisecond, InitialRecords: 3, InitialDelay: time.Millisecond}
+	for sent, want := range []time.Duration{0, time.Millisecond, time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond} {
+		if got := p.delay(rand.Reader, sent); got != want {
+			t.Errorf("delay after %d records is %v, want %v", sent, got, want)
+		}
+	}
+
+	p = &RecordPacing{Delay: 10 * time.Millisecond, Jitter: 5 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if got := p.delay(rand.Reader, 1); got < 10*time.Millisecond || got > 15*time.Millisecond {
+			t.Fatalf("delay %v out of the jitter", got)
+		}
+	}
+	if got := (*RecordPacing)(nil).delay(rand.Reader, 1); got != 0 {
+		t.Errorf("nil RecordPacing delays by %v", got)
+	}
+}
+
+func TestRecordPacing(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	clientConfig := testConfig.Clone()
+	clientConfig.RecordPacing = &RecordPacing{Delay: delay}
+
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	// Three records, of which the first is sent straight away.
+	data := make([]byte, 2*maxPlaintext+1)
+	errChan := make(chan error, 1)
+	firstChan := make(chan time.Time, 1)
+	go func() {
+		if err := server.Handshake(); err != nil {
+			errChan <- err
+			return
+		}
+		buf := make([]byte, len(data))
+		if _, err := io.ReadFull(server, buf[:1]); err != nil {
+			errChan <- err
+			return
+		}
+		firstChan <- time.Now()
+		_, err := io.ReadFull(server, buf[1:])
+		errChan <- err
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Errorf("three records were written in %v, want at least %v", elapsed, 2*delay)
+	}
+	if first := (<-firstChan).Sub(start); first >= delay {
+		t.Errorf("the first record was received after %v", first)
+	}
+}
+
+func TestRecordPacingInterrupted(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// interrupt is called before the paced Write, to end its delay.
+		interrupt func(c *Conn)
+		want      error
+	}{
+		{"Deadline", func(c *Conn) {
+			c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+		}, os.ErrDeadlineExceeded},
+		{"DeadlineMoved", func(c *Conn) {
+			time.AfterFunc(50*time.Millisecond, func() { c.SetWriteDeadline(time.Now()) })
+		}, os.ErrDeadlineExceeded},
+		{"Close", func(c *Conn) {
+			time.AfterFunc(50*time.Millisecond, func() { c.Close() })
+		}, net.ErrClosed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.RecordPacing = &RecordPacing{Delay: time.Hour}
+
+			c, s := localPipe(t)
+			client := Client(c, clientConfig)
+			server := Server(s, testConfig.Clone())
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				if server.Handshake() == nil {
+					io.Copy(io.Discard, server)
+				}
+			}()
+			if err := client.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.interrupt(client)
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := client.Write(make([]byte, maxPlaintext+1))
+				errChan <- err
+			}()
+			select {
+			case err := <-errChan:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Write returned %v, want %v", err, tt.want)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("the delay of the Write wasn't interrupted")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package tls
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// A PaddingPolicy chooses the padding of the TLS 1.3 records of application
+// data, which RFC 8446, Section 5.4 allows to hide the size of the writes,
+// for protocols tunnelled over TLS whose packet sizes would give them away.
+// It is set in [Config.PaddingPolicy].
+type PaddingPolicy interface {
+	// Padding returns the number of padding bytes to add to a record with
+	// n bytes of application data, at most max, the room left in the
+	// record. rand is the source of randomness of the Config.
+	Padding(rand io.Reader, n, max int) int
+}
+
+// FixedPadding returns a PaddingPolicy which pads the records to a multiple
+// of size bytes of application data, so that all the writes up to size bytes
+// look alike.
+func FixedPadding(size int) PaddingPolicy {
+	return fixedPadding(size)
+}
+
+type fixedPadding int
+
+func (size fixedPadding) Padding(_ io.Reader, n, max int) int {
+	if size <= 0 {
+		return 0
+	}
+	return (int(size) - n%int(size)) % int(size)
+}
+
+// RandomPadding returns a PaddingPolicy which adds from min to max bytes of
+// padding to each record, uniformly at random.
+func RandomPadding(min, max int) PaddingPolicy {
+	return randomPadding{min, max}
+}
+
+type randomPadding struct{ min, max int }
+
+func (p randomPadding) Padding(rand io.Reader, _, _ int) int {
+	if p.max < p.min || p.min < 0 {
+		return 0
+	}
+	return p.min + randIntn(rand, p.max-p.min+1)
+}
+
+// LengthDistributionPadding returns a PaddingPolicy which pads each record to
+// a length of application data drawn from lengths, with the relative
+// weights, such as the distribution of the packet sizes of another protocol.
+// The length is drawn among those at least as long as the record, and the
+// record isn't padded if there are none.
+func LengthDistributionPadding(lengths, weights []int) PaddingPolicy {
+	return newLengthDistribution(lengths, weights)
+}
+
+func newLengthDistribution(lengths, weights []int) *lengthDistributionPadding {
+	p := &lengthDistributionPadding{}
+	for i, length := range lengths {
+		if i < len(weights) && weights[i] > 0 {
+			p.lengths = append(p.lengths, length)
+			p.weights = append(p.weights, weights[i])
+		}
+	}
+	sort.Sort(p)
+	return p
+}
+
+// lengthDistributionPadding holds the lengths of the distribution sorted,
+// with their weights.
+type lengthDistributionPadding struct {
+	lengths, weights []int
+}
+
+func (p *lengthDistributionPadding) Len() int           { return len(p.lengths) }
+func (p *lengthDistributionPadding) Less(i, j int) bool { return p.lengths[i] < p.lengths[j] }
+func (p *lengthDistributionPadding) Swap(i, j int) {
+	p.lengths[i], p.lengths[j] = p.lengths[j], p.lengths[i]
+	p.weights[i], p.weights[j] = p.weights[j], p.weights[i]
+}
+
+func (p *lengthDistributionPadding) Padding(rand io.Reader, n, max int) int {
+	first := sort.SearchInts(p.lengths, n)
+	total := 0
+	for _, w := range p.weights[first:] {
+		total += w
+	}
+	if total == 0 {
+		return 0
+	}
+	r := randIntn(rand, total)
+	for i, w := range p.weights[first:] {
+		if r < w {
+			return p.lengths[first+i] - n
+		}
+		r -= w
+	}
+	return 0
+}
+
+// randIntn returns a number in [0, n) read from rand, or zero if it fails.
+// The bias is negligible for the small n of the padding policies.
+func randIntn(rand io.Reader, n int) int {
+	var b [8]byte
+	if n <= 1 {
+		return 0
+	}
+	if _, err := io.ReadFull(rand, b[:]); err != nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(b[:]) % uint64(n))
+}
+
+// recordPadding returns the padding of the next TLS 1.3 record of application
+// data, with n bytes of it, in a record with room for max.
+func (c *Conn) recordPadding(typ recordType, n, max int) int {
+	if c.out.version != VersionTLS13 || c.out.cipher == nil {
+		return 0
+	}
+	if typ == recordTypeHandshake && c.decoyFlight != nil {
+		return c.decoyFlight.padding(n, max)
+	}
+	policy := c.config.PaddingPolicy
+	if policy == nil || typ != recordTypeApplicationData {
+		return 0
+	}
+	padding := policy.Padding(c.config.rand(), n, max-n)
+	if padding < 0 {
+		return 0
+	}
+	if padding > max-n {
+		return max - n
+	}
+	return padding
+}
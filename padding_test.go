@@ -0,0 +1,103 @@
+package tls
+
+import (
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestPaddingPolicies(t *testing.T) {
+	fixed := FixedPadding(256)
+	for n, want := range map[int]int{1: 255, 255: 1, 256: 0, 257: 255} {
+		if got := fixed.Padding(rand.Reader, n, maxPlaintext); got != want {
+			t.Errorf("FixedPadding(256) pads %d bytes with %d, want %d", n, got, want)
+		}
+	}
+
+	random := RandomPadding(10, 20)
+	for i := 0; i < 100; i++ {
+		if got := random.Padding(rand.Reader, 100, maxPlaintext); got < 10 || got > 20 {
+			t.Fatalf("RandomPadding(10, 20) padded with %d", got)
+		}
+	}
+
+	distribution := LengthDistributionPadding([]int{1400, 100, 600, 50}, []int{1, 1, 1, 0})
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		got := 80 + distribution.Padding(rand.Reader, 80, maxPlaintext)
+		if got != 100 && got != 600 && got != 1400 {
+			t.Fatalf("LengthDistributionPadding padded 80 bytes to %d", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("LengthDistributionPadding only padded to %v", seen)
+	}
+	if got := distribution.Padding(rand.Reader, 2000, maxPlaintext); got != 0 {
+		t.Errorf("LengthDistributionPadding padded a longer record with %d", got)
+	}
+}
+
+func TestPaddingPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		vers   uint16
+		policy PaddingPolicy
+		data   int
+		want   int // length of the record of application data
+	}{
+		{"TLS13", VersionTLS13, FixedPadding(1000), 5, recordHeaderLen + 1000 + 1 + 16},
+		{"TLS13Full", VersionTLS13, FixedPadding(1000), maxPlaintext, recordHeaderLen + maxPlaintext + 1 + 16},
+		{"TLS12", VersionTLS12, FixedPadding(1000), 5, recordHeaderLen + 8 + 5 + 16},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.vers
+			clientConfig.CipherSuites = []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+			clientConfig.PaddingPolicy = tt.policy
+			clientConfig.DynamicRecordSizingDisabled = true
+
+			c, s := localPipe(t)
+			recorder := &recordingConn{Conn: c}
+			client := Client(recorder, clientConfig)
+			server := Server(s, testConfig.Clone())
+			defer client.Close()
+			defer server.Close()
+
+			errChan := make(chan error, 1)
+			go func() {
+				buf := make([]byte, tt.data)
+				if err := server.Handshake(); err != nil {
+					errChan <- err
+					return
+				}
+				_, err := io.ReadFull(server, buf)
+				errChan <- err
+			}()
+			if err := client.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+			before := recordedBytes(recorder)
+			if _, err := client.Write(make([]byte, tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+			if written := recordedBytes(recorder) - before; written != tt.want {
+				t.Errorf("wrote %d bytes, want %d", written, tt.want)
+			}
+		})
+	}
+}
+
+// recordedBytes returns the number of bytes r saw so far, in both directions.
+func recordedBytes(r *recordingConn) int {
+	r.Lock()
+	defer r.Unlock()
+	n := 0
+	for _, flow := range r.flows {
+		n += len(flow)
+	}
+	return n
+}
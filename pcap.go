@@ -0,0 +1,278 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ClientHelloSpecsFromPcap extracts the ClientHellos of the TCP connections
+// of a capture in the pcap or pcapng format, such as one of a browser
+// recorded with tcpdump or Wireshark, in the order they were sent. The
+// ClientHello is sent in the clear, so no key log is needed. Connections
+// whose ClientHello isn't whole in the capture are skipped.
+func ClientHelloSpecsFromPcap(r io.Reader) ([]*ClientHelloSpec, error) {
+	packets, err := readPcap(r)
+	if err != nil {
+		return nil, err
+	}
+	streams := make(map[string]*tcpStream)
+	var order []*tcpStream
+	for _, p := range packets {
+		seg, ok := parseTCPSegment(p.linkType, p.data)
+		if !ok || len(seg.payload) == 0 {
+			continue
+		}
+		s := streams[seg.flow]
+		if s == nil {
+			s = &tcpStream{}
+			streams[seg.flow] = s
+			order = append(order, s)
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	var specs []*ClientHelloSpec
+	for _, s := range order {
+		data := s.reassemble()
+		if len(data) < 2 || recordType(data[0]) != recordTypeHandshake || data[1] != 3 {
+			continue
+		}
+		spec, err := ParseClientHelloSpec(data)
+		if err != nil {
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+type pcapPacket struct {
+	linkType uint32
+	data     []byte
+}
+
+// Link types of https://www.tcpdump.org/linktypes.html.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeSLL      = 113
+	linkTypeSLL2     = 276
+)
+
+// readPcap reads the packets of a capture in the pcap or pcapng format.
+func readPcap(r io.Reader) ([]pcapPacket, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, errors.New("tls: truncated capture")
+	}
+	if binary.LittleEndian.Uint32(data) == 0x0a0d0d0a {
+		return readPcapng(data)
+	}
+	return readClassicPcap(data)
+}
+
+func readClassicPcap(data []byte) ([]pcapPacket, error) {
+	if len(data) < 24 {
+		return nil, errors.New("tls: truncated pcap header")
+	}
+	var order binary.ByteOrder
+	switch magic := binary.LittleEndian.Uint32(data); magic {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("tls: unknown capture format %#x", magic)
+	}
+	linkType := order.Uint32(data[20:])
+	var packets []pcapPacket
+	for data = data[24:]; len(data) >= 16; {
+		// The lengths are compared as uint64, as they may not fit in an
+		// int on 32-bit platforms.
+		captured := order.Uint32(data[8:])
+		if uint64(captured) > uint64(len(data)-16) {
+			break // truncated capture
+		}
+		n := int(captured)
+		packets = append(packets, pcapPacket{linkType, data[16 : 16+n]})
+		data = data[16+n:]
+	}
+	return packets, nil
+}
+
+func readPcapng(data []byte) ([]pcapPacket, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	var linkTypes []uint32
+	var packets []pcapPacket
+	for len(data) >= 12 {
+		typ := order.Uint32(data)
+		if typ == 0x0a0d0d0a {
+			// A section header block, of which the byte-order magic
+			// gives the endianness of the section.
+			if binary.LittleEndian.Uint32(data[8:]) == 0x1a2b3c4d {
+				order = binary.LittleEndian
+			} else {
+				order = binary.BigEndian
+			}
+			linkTypes = nil
+		}
+		blockLen := order.Uint32(data[4:])
+		if blockLen < 12 || uint64(blockLen) > uint64(len(data)) {
+			return nil, errors.New("tls: malformed pcapng block")
+		}
+		n := int(blockLen)
+		body := data[8 : n-4]
+		switch typ {
+		case 1: // interface description block
+			if len(body) >= 2 {
+				linkTypes = append(linkTypes, uint32(order.Uint16(body)))
+			}
+		case 6: // enhanced packet block
+			if len(body) < 20 {
+				return nil, errors.New("tls: malformed pcapng packet")
+			}
+			iface, captured := order.Uint32(body), order.Uint32(body[12:])
+			if uint64(iface) < uint64(len(linkTypes)) && uint64(captured) <= uint64(len(body)-20) {
+				packets = append(packets, pcapPacket{linkTypes[iface], body[20 : 20+int(captured)]})
+			}
+		case 3: // simple packet block
+			if len(body) >= 4 && len(linkTypes) > 0 {
+				captured := len(body) - 4
+				if original := order.Uint32(body); uint64(original) < uint64(captured) {
+					captured = int(original)
+				}
+				packets = append(packets, pcapPacket{linkTypes[0], body[4 : 4+captured]})
+			}
+		}
+		data = data[n:]
+	}
+	return packets, nil
+}
+
+type tcpSegment struct {
+	flow    string // source and destination addresses and ports
+	seq     uint32
+	payload []byte
+}
+
+// parseTCPSegment parses a TCP segment over IPv4 or IPv6 in a frame of the
+// link type.
+func parseTCPSegment(linkType uint32, frame []byte) (seg tcpSegment, ok bool) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return seg, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		for etherType == 0x8100 && len(frame) >= 4 { // 802.1Q VLAN tags
+			etherType, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case linkTypeSLL:
+		if len(frame) < 16 {
+			return seg, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	case linkTypeSLL2:
+		if len(frame) < 20 {
+			return seg, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame), frame[20:]
+	case linkTypeNull, linkTypeRaw:
+		if linkType == linkTypeNull {
+			if len(frame) < 4 {
+				return seg, false
+			}
+			frame = frame[4:]
+		}
+		if len(frame) > 0 && frame[0]>>4 == 6 {
+			etherType = 0x86dd
+		} else {
+			etherType = 0x0800
+		}
+	default:
+		return seg, false
+	}
+
+	var addrs []byte
+	switch etherType {
+	case 0x0800:
+		if len(frame) < 20 || frame[0]>>4 != 4 || frame[9] != 6 {
+			return seg, false
+		}
+		headerLen, totalLen := int(frame[0]&0xf)*4, int(binary.BigEndian.Uint16(frame[2:]))
+		if headerLen < 20 || totalLen < headerLen || len(frame) < headerLen {
+			return seg, false
+		}
+		if totalLen < len(frame) {
+			frame = frame[:totalLen] // Ethernet padding
+		}
+		// Fragments aren't reassembled.
+		if binary.BigEndian.Uint16(frame[6:])&0x3fff != 0 {
+			return seg, false
+		}
+		addrs, frame = frame[12:20], frame[headerLen:]
+	case 0x86dd:
+		// Extension headers are not parsed.
+		if len(frame) < 40 || frame[6] != 6 {
+			return seg, false
+		}
+		if payloadLen := int(binary.BigEndian.Uint16(frame[4:])); 40+payloadLen < len(frame) {
+			frame = frame[:40+payloadLen]
+		}
+		addrs, frame = frame[8:40], frame[40:]
+	default:
+		return seg, false
+	}
+
+	if len(frame) < 20 {
+		return seg, false
+	}
+	headerLen := int(frame[12]>>4) * 4
+	if headerLen < 20 || len(frame) < headerLen {
+		return seg, false
+	}
+	seg.flow = string(addrs) + string(frame[:4])
+	seg.seq = binary.BigEndian.Uint32(frame[4:])
+	seg.payload = frame[headerLen:]
+	return seg, true
+}
+
+// A tcpStream is one direction of a TCP connection.
+type tcpStream struct {
+	segments []tcpSegment
+}
+
+// reassemble returns the contiguous data at the start of the stream,
+// dropping retransmissions. The stream is assumed to start with the first
+// segment with data in the capture.
+func (s *tcpStream) reassemble() []byte {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	isn := s.segments[0].seq
+	sort.SliceStable(s.segments, func(i, j int) bool {
+		return int32(s.segments[i].seq-isn) < int32(s.segments[j].seq-isn)
+	})
+	var data bytes.Buffer
+	next := s.segments[0].seq
+	for _, seg := range s.segments {
+		offset := int32(next - seg.seq)
+		if offset < 0 {
+			break // a missing segment
+		}
+		if int(offset) < len(seg.payload) {
+			data.Write(seg.payload[offset:])
+			next = seg.seq + uint32(len(seg.payload))
+		}
+	}
+	return data.Bytes()
+}
@@ -0,0 +1,80 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+)
+
+// SPKIHash returns the SHA-256 hash of the SubjectPublicKeyInfo of cert, as
+// listed in [Config.PinnedPeerSPKIHashes].
+func SPKIHash(cert *x509.Certificate) []byte {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return h[:]
+}
+
+// PinError is returned by handshakes with peers whose certificate chain
+// contains none of the keys of [Config.PinnedPeerSPKIHashes], and is passed to
+// [Config.OnPinFailure].
+type PinError struct {
+	// ServerName is the server name requested by the client, if any.
+	ServerName string
+	// PeerCertificates are the certificates sent by the peer, and
+	// VerifiedChains the chains built from them, if they were verified. They
+	// should not be modified.
+	PeerCertificates []*x509.Certificate
+	VerifiedChains   [][]*x509.Certificate
+	// ReportOnly is true if the handshake proceeded regardless, because
+	// Config.PinReportOnly is set.
+	ReportOnly bool
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("tls: peer certificate chain for %q contains no pinned public key", e.ServerName)
+}
+
+// checkPinnedKeys checks the verified chains of the peer, or its leaf
+// certificate if they weren't verified, against
+// c.config.PinnedPeerSPKIHashes, sending the appropriate alert. The other
+// certificates the peer sent aren't checked, as it can send any.
+func (c *Conn) checkPinnedKeys(certs []*x509.Certificate, chains [][]*x509.Certificate) error {
+	pins := c.config.PinnedPeerSPKIHashes
+	if len(pins) == 0 || len(certs) == 0 {
+		return nil
+	}
+	if len(chains) == 0 && containsPinnedKey(pins, certs[:1]) {
+		return nil
+	}
+	for _, chain := range chains {
+		if containsPinnedKey(pins, chain) {
+			return nil
+		}
+	}
+	err := &PinError{
+		ServerName:       c.serverName,
+		PeerCertificates: certs,
+		VerifiedChains:   chains,
+		ReportOnly:       c.config.PinReportOnly,
+	}
+	if c.config.OnPinFailure != nil {
+		c.config.OnPinFailure(err)
+	}
+	if c.config.PinReportOnly {
+		return nil
+	}
+	c.sendAlert(alertBadCertificate)
+	return err
+}
+
+func containsPinnedKey(pins [][]byte, certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		h := SPKIHash(cert)
+		for _, pin := range pins {
+			if bytes.Equal(h, pin) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinnedPeerSPKIHashes(t *testing.T) {
+	leaf, err := x509.ParseCertificate(testRSACertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := x509.ParseCertificate(testECDSACertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+
+	for _, tt := range []struct {
+		name       string
+		pins       [][]byte
+		verify     bool
+		reportOnly bool
+		clientAuth bool
+		extra      bool // the server sends an unrelated certificate too
+		wantErr    bool
+		wantReport bool
+	}{
+		{name: "Leaf", pins: [][]byte{SPKIHash(other), SPKIHash(leaf)}},
+		{name: "Root", pins: [][]byte{SPKIHash(issuer)}, verify: true},
+		{name: "RootUnverified", pins: [][]byte{SPKIHash(issuer)}, wantErr: true, wantReport: true},
+		{name: "Mismatch", pins: [][]byte{SPKIHash(other)}, wantErr: true, wantReport: true},
+		{name: "ExtraUnverified", pins: [][]byte{SPKIHash(other)}, extra: true, wantErr: true, wantReport: true},
+		{name: "ExtraVerified", pins: [][]byte{SPKIHash(other)}, extra: true, verify: true, wantErr: true, wantReport: true},
+		{name: "ReportOnly", pins: [][]byte{SPKIHash(other)}, reportOnly: true, wantReport: true},
+		{name: "ClientCert", pins: [][]byte{SPKIHash(leaf)}, clientAuth: true},
+		{name: "ClientCertMismatch", pins: [][]byte{SPKIHash(other)}, clientAuth: true, wantErr: true, wantReport: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			serverConfig := testConfig.Clone()
+			pinned := clientConfig
+			if tt.clientAuth {
+				serverConfig.ClientAuth = RequireAnyClientCert
+				clientConfig.Certificates = testConfig.Certificates[:1]
+				pinned = serverConfig
+				// Fail on the server before the client reads its response.
+				serverConfig.MaxVersion = VersionTLS12
+			}
+			if tt.extra {
+				cert := serverConfig.Certificates[0]
+				cert.Certificate = [][]byte{cert.Certificate[0], testECDSACertificate}
+				serverConfig.Certificates = []Certificate{cert}
+			}
+			if tt.verify {
+				clientConfig.InsecureSkipVerify = false
+				clientConfig.RootCAs = roots
+				clientConfig.ServerName = "example.golang"
+				clientConfig.Time = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+			}
+			var report *PinError
+			pinned.PinnedPeerSPKIHashes = tt.pins
+			pinned.PinReportOnly = tt.reportOnly
+			pinned.OnPinFailure = func(err *PinError) { report = err }
+
+			_, _, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "no pinned public key") {
+					t.Errorf("got error %v, want a pinning failure", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if (report != nil) != tt.wantReport {
+				t.Fatalf("got report %v, want one: %v", report, tt.wantReport)
+			}
+			wantCerts := 1
+			if tt.extra {
+				wantCerts = 2
+			}
+			if report != nil && (report.ReportOnly != tt.reportOnly || len(report.PeerCertificates) != wantCerts) {
+				t.Errorf("got report %+v", report)
+			}
+		})
+	}
+}
+
+func TestDialerPinnedPeerSPKIHashes(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				Server(conn, testConfig).Handshake()
+			}()
+		}
+	}()
+
+	leaf, err := x509.ParseCertificate(testRSACertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig.Clone()
+	config.ServerName = "example.golang"
+	config.PinnedPeerSPKIHashes = [][]byte{SPKIHash(leaf)}
+
+	d := Dialer{Config: config}
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	d.PinnedPeerSPKIHashes = [][]byte{make([]byte, 32)}
+	if _, err := d.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("Dialer.PinnedPeerSPKIHashes was ignored")
+	}
+	if !bytes.Equal(config.PinnedPeerSPKIHashes[0], SPKIHash(leaf)) {
+		t.Error("Dialer modified its Config")
+	}
+}
@@ -0,0 +1,375 @@
+package tls
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// maxPostHandshakeAuthInput is the maximum amount of application data
+// RequestClientCertificate buffers while waiting for the client's response.
+const maxPostHandshakeAuthInput = 1 << 20
+
+// errClientDeclinedCertificate is returned by RequestClientCertificate when
+// the client answers with an empty certificate.
+var errClientDeclinedCertificate = errors.New("tls: client declined to send a certificate")
+
+// postHandshakeCertRequest is a post-handshake CertificateRequest sent by the
+// server, and the state of the client's response to it.
+type postHandshakeCertRequest struct {
+	context    []byte
+	transcript hash.Hash
+
+	gotCert       bool
+	pub           crypto.PublicKey // nil if the client declined
+	peer          peerCertState    // set on the Conn once Finished is verified
+	gotCertVerify bool
+
+	done chan struct{} // closed once err is set
+	err  error
+}
+
+// peerCertState is the state of the connection set from the certificates
+// of the peer.
+type peerCertState struct {
+	certificates   []*x509.Certificate
+	verifiedChains [][]*x509.Certificate
+	ocspResponse   []byte
+	scts           [][]byte
+}
+
+func (c *Conn) peerCertState() peerCertState {
+	return peerCertState{c.peerCertificates, c.verifiedChains, c.ocspResponse, c.scts}
+}
+
+func (c *Conn) setPeerCertState(s peerCertState) {
+	c.peerCertificates, c.verifiedChains, c.ocspResponse, c.scts = s.certificates, s.verifiedChains, s.ocspResponse, s.scts
+}
+
+func (req *postHandshakeCertRequest) isDone() bool {
+	select {
+	case <-req.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// RequestClientCertificate asks the client to authenticate with a certificate
+// after the handshake, as described in RFC 8446, Section 4.6.2, and waits for
+// its response. It's only supported on TLS 1.3 server connections whose
+// client offered post-handshake authentication, see
+// [Config.PostHandshakeAuth].
+//
+// The certificate is verified as one sent during the handshake would be,
+// according to Config.ClientAuth, Config.VerifyPeerCertificate and
+// Config.VerifyConnection, and it replaces the peer certificates reported by
+// [Conn.ConnectionState]. A certificate failing verification closes the
+// connection with an alert. If the client declines to send a certificate,
+// RequestClientCertificate returns an error and the connection remains
+// usable.
+//
+// Application data the client sends before its response is buffered and
+// returned by subsequent calls to Read. If ctx is canceled before the response
+// arrives, the connection is closed.
+func (c *Conn) RequestClientCertificate(ctx context.Context) (err error) {
+	if c.isClient {
+		return errors.New("tls: RequestClientCertificate called on a client connection")
+	}
+	if !c.isHandshakeComplete.Load() {
+		return errors.New("tls: RequestClientCertificate called before the handshake completed")
+	}
+	if c.vers != VersionTLS13 || c.postHandshakeTranscript == nil {
+		return errors.New("tls: client didn't offer post-handshake authentication")
+	}
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil {
+		return errors.New("tls: internal error: unknown cipher suite")
+	}
+
+	req := &postHandshakeCertRequest{
+		context:    make([]byte, 8),
+		transcript: cloneHash(c.postHandshakeTranscript, suite.hash),
+		done:       make(chan struct{}),
+	}
+	if req.transcript == nil {
+		return errors.New("tls: internal error: failed to clone the handshake transcript")
+	}
+	certReq := &certificateRequestMsgTLS13{
+		ocspStapling:                     true,
+		scts:                             true,
+		supportedSignatureAlgorithms:     c.config.supportedSignatureAlgorithms(c.vers),
+		supportedSignatureAlgorithmsCert: c.config.certificateSignatureSchemes(),
+	}
+	if c.config.ClientCAs != nil {
+		certReq.certificateAuthorities = c.config.ClientCAs.Subjects()
+	}
+
+	c.certRequestMu.Lock()
+	if c.certRequest != nil {
+		c.certRequestMu.Unlock()
+		return errors.New("tls: a client certificate request is already pending")
+	}
+	c.certRequests++
+	binary.BigEndian.PutUint64(req.context, c.certRequests)
+	certReq.requestContext = req.context
+	data, err := certReq.marshal()
+	if err != nil {
+		c.certRequestMu.Unlock()
+		return err
+	}
+	req.transcript.Write(data)
+	c.certRequest = req
+	c.certRequestMu.Unlock()
+
+	if ctx.Done() != nil {
+		// Close the connection if ctx is canceled, like HandshakeContext.
+		stop := contextAfterFunc(ctx, func() { _ = c.conn.Close() })
+		defer func() {
+			if !stop() {
+				err = ctx.Err()
+			}
+		}()
+	}
+
+	c.out.Lock()
+	_, err = c.writeRecordLocked(recordTypeHandshake, data)
+	c.out.Unlock()
+	if err != nil {
+		c.finishCertRequest(req, err)
+		return err
+	}
+
+	// The response may be read by a concurrent Read, which holds c.in until
+	// it returns application data, so wait for either.
+	readErr := make(chan error, 1)
+	go func() {
+		c.in.Lock()
+		defer c.in.Unlock()
+		readErr <- c.readCertResponseLocked(req)
+	}()
+	select {
+	case <-req.done:
+	case err := <-readErr:
+		if err != nil {
+			c.finishCertRequest(req, err)
+		}
+	}
+	<-req.done
+	return req.err
+}
+
+// readCertResponseLocked reads records until req is answered, holding back
+// the application data read in the meantime for Read to return.
+func (c *Conn) readCertResponseLocked(req *postHandshakeCertRequest) error {
+	var held []byte
+	defer func() {
+		if len(held) > 0 {
+			held = append(held, c.inputData[len(c.inputData)-c.input.Len():]...)
+			c.input.Reset(held)
+			c.inputData = held
+		}
+	}()
+	for !req.isDone() {
+		if n := c.input.Len(); n > 0 {
+			if len(held)+n > maxPostHandshakeAuthInput {
+				c.sendAlert(alertUnexpectedMessage)
+				return c.in.setErrorLocked(errors.New("tls: too much application data received before the client certificate"))
+			}
+			held = append(held, c.inputData[len(c.inputData)-n:]...)
+			c.input.Reset(nil)
+			c.inputData = nil
+		}
+		if err := c.readRecord(); err != nil {
+			return err
+		}
+		if c.hand.Len() > 0 {
+			if err := c.handlePostHandshakeMessages(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finishCertRequest completes req with err, if it isn't already.
+func (c *Conn) finishCertRequest(req *postHandshakeCertRequest, err error) {
+	c.certRequestMu.Lock()
+	defer c.certRequestMu.Unlock()
+	if c.certRequest != req {
+		return
+	}
+	c.certRequest = nil
+	req.err = err
+	close(req.done)
+}
+
+// handleCertResponse processes a message of the client's response to a
+// post-handshake CertificateRequest.
+func (c *Conn) handleCertResponse(msg any) error {
+	c.certRequestMu.Lock()
+	req := c.certRequest
+	c.certRequestMu.Unlock()
+	if c.isClient || req == nil {
+		return c.unexpectedPostHandshakeMessage(msg)
+	}
+	// The peer certificates are protected by handshakeMutex, which is
+	// acquired as for renegotiation.
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	if err := c.processCertResponse(req, msg); err != nil {
+		c.finishCertRequest(req, err)
+		return c.in.setErrorLocked(err)
+	}
+	return nil
+}
+
+func (c *Conn) processCertResponse(req *postHandshakeCertRequest, msg any) error {
+	switch msg := msg.(type) {
+	case *certificateMsgTLS13:
+		if req.gotCert {
+			return c.unexpectedPostHandshakeMessage(msg)
+		}
+		if !hmac.Equal(msg.requestContext, req.context) {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: client sent a Certificate with an unknown context")
+		}
+		if err := transcriptMsg(msg, req.transcript); err != nil {
+			return err
+		}
+		req.gotCert = true
+		if len(msg.certificate.Certificate) == 0 {
+			return nil
+		}
+		// The certificates are staged in req until the response is
+		// authenticated, and the previous ones kept meanwhile.
+		prev := c.peerCertState()
+		c.setPeerCertState(peerCertState{})
+		err := c.processCertsFromClient(context.Background(), msg.certificate)
+		req.peer = c.peerCertState()
+		c.setPeerCertState(prev)
+		if err != nil {
+			return err
+		}
+		req.pub = req.peer.certificates[0].PublicKey
+		return nil
+
+	case *certificateVerifyMsg:
+		if req.pub == nil || req.gotCertVerify {
+			return c.unexpectedPostHandshakeMessage(msg)
+		}
+		if err := c.verifyClientCertificateVerify(req.pub, msg, req.transcript); err != nil {
+			return err
+		}
+		if err := transcriptMsg(msg, req.transcript); err != nil {
+			return err
+		}
+		req.gotCertVerify = true
+		return nil
+
+	case *finishedMsg:
+		if !req.gotCert || (req.pub != nil && !req.gotCertVerify) {
+			return c.unexpectedPostHandshakeMessage(msg)
+		}
+		suite := cipherSuiteTLS13ByID(c.cipherSuite)
+		if !hmac.Equal(suite.finishedHash(c.in.trafficSecret, req.transcript), msg.verifyData) {
+			c.sendAlert(alertDecryptError)
+			return errors.New("tls: invalid client finished hash")
+		}
+		if req.pub == nil {
+			c.finishCertRequest(req, errClientDeclinedCertificate)
+			return nil
+		}
+		prev := c.peerCertState()
+		c.setPeerCertState(req.peer)
+		if c.config.VerifyConnection != nil {
+			if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
+				c.setPeerCertState(prev)
+				c.sendAlert(alertBadCertificate)
+				return c.callbackError(err)
+			}
+		}
+		c.finishCertRequest(req, nil)
+		return nil
+	}
+	return c.unexpectedPostHandshakeMessage(msg)
+}
+
+// handleCertificateRequest answers a post-handshake CertificateRequest, on
+// the client side.
+func (c *Conn) handleCertificateRequest(certReq *certificateRequestMsgTLS13) error {
+	if !c.isClient || c.postHandshakeTranscript == nil {
+		return c.unexpectedPostHandshakeMessage(certReq)
+	}
+	// RFC 8446, Section 4.3.2
+	if len(certReq.requestContext) == 0 {
+		c.sendAlert(alertIllegalParameter)
+		return c.in.setErrorLocked(errors.New("tls: server sent a post-handshake CertificateRequest with an empty context"))
+	}
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil {
+		return c.in.setErrorLocked(c.sendAlert(alertInternalError))
+	}
+	transcript := cloneHash(c.postHandshakeTranscript, suite.hash)
+	if transcript == nil {
+		return c.in.setErrorLocked(c.sendAlert(alertInternalError))
+	}
+	if err := transcriptMsg(certReq, transcript); err != nil {
+		return c.in.setErrorLocked(err)
+	}
+
+	cert, err := c.getClientCertificate(&CertificateRequestInfo{
+		AcceptableCAs:               certReq.certificateAuthorities,
+		SignatureSchemes:            certReq.supportedSignatureAlgorithms,
+		CertificateSignatureSchemes: certReq.supportedSignatureAlgorithmsCert,
+		Version:                     c.vers,
+		ctx:                         context.Background(),
+	})
+	if err != nil {
+		return c.in.setErrorLocked(err)
+	}
+
+	certMsg := &certificateMsgTLS13{
+		requestContext: certReq.requestContext,
+		certificate:    *cert,
+		scts:           certReq.scts && len(cert.SignedCertificateTimestamps) > 0,
+		ocspStapling:   certReq.ocspStapling && len(cert.OCSPStaple) > 0,
+	}
+	data, err := certMsg.marshal()
+	if err != nil {
+		return c.in.setErrorLocked(err)
+	}
+	transcript.Write(data)
+	if len(cert.Certificate) > 0 {
+		certVerifyMsg, err := c.clientCertificateVerify(context.Background(), cert, certReq.supportedSignatureAlgorithms, transcript)
+		if err != nil {
+			return c.in.setErrorLocked(err)
+		}
+		certVerify, err := certVerifyMsg.marshal()
+		if err != nil {
+			return c.in.setErrorLocked(err)
+		}
+		transcript.Write(certVerify)
+		data = append(data, certVerify...)
+	}
+
+	// The response is written at once, since handshake messages must not be
+	// interleaved with application data (RFC 8446, Section 5.1).
+	c.out.Lock()
+	defer c.out.Unlock()
+	finished, err := (&finishedMsg{
+		verifyData: suite.finishedHash(c.out.trafficSecret, transcript),
+	}).marshal()
+	if err != nil {
+		return c.in.setErrorLocked(err)
+	}
+	data = append(data, finished...)
+	if _, err := c.writeRecordLocked(recordTypeHandshake, data); err != nil {
+		return c.in.setErrorLocked(err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func postHandshakeAuthConns(t *testing.T, clientConfig, serverConfig *Config) (client, server *Conn) {
+	t.Helper()
+	c, s := localPipe(t)
+	client, server = Client(c, clientConfig), Server(s, serverConfig)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	errc := make(chan error, 1)
+	go func() { errc <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	return client, server
+}
+
+func TestPostHandshakeAuth(t *testing.T) {
+	clientCert := Certificate{Certificate: [][]byte{testECDSACertificate}, PrivateKey: testECDSAPrivateKey}
+
+	for _, tt := range []struct {
+		name      string
+		version   uint16
+		offer     bool
+		cert      *Certificate
+		reject    bool
+		requests  int
+		err       string
+		closeConn bool
+	}{
+		{name: "Success", version: VersionTLS13, offer: true, cert: &clientCert, requests: 2},
+		{name: "Declined", version: VersionTLS13, offer: true, requests: 1, err: "declined"},
+		{name: "Rejected", version: VersionTLS13, offer: true, cert: &clientCert, reject: true, requests: 1, err: "rejected", closeConn: true},
+		{name: "NotOffered", version: VersionTLS13, cert: &clientCert, requests: 1, err: "didn't offer"},
+		{name: "TLS12", version: VersionTLS12, offer: true, cert: &clientCert, requests: 1, err: "didn't offer"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.PostHandshakeAuth = tt.offer
+			clientConfig.Certificates = nil
+			if tt.cert != nil {
+				clientConfig.Certificates = []Certificate{*tt.cert}
+			}
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			verified := 0
+			serverConfig.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error {
+				if tt.reject {
+					return errors.New("client certificate rejected")
+				}
+				verified++
+				return nil
+			}
+			client, server := postHandshakeAuthConns(t, clientConfig, serverConfig)
+
+			// The client sends application data before it sees the requests,
+			// and answers them while reading.
+			clientErr := make(chan error, 1)
+			go func() {
+				if _, err := client.Write([]byte("before")); err != nil {
+					clientErr <- err
+					return
+				}
+				buf := make([]byte, len("after"))
+				if _, err := io.ReadFull(client, buf); err != nil {
+					clientErr <- err
+					return
+				}
+				if string(buf) != "after" {
+					clientErr <- errors.New("client read " + string(buf))
+					return
+				}
+				clientErr <- nil
+			}()
+
+			for i := 0; i < tt.requests; i++ {
+				err := server.RequestClientCertificate(context.Background())
+				if tt.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.err) {
+						t.Fatalf("got error %v, want %q", err, tt.err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+			}
+			if tt.closeConn {
+				if err := <-clientErr; err == nil {
+					t.Error("client kept reading after its certificate was rejected")
+				}
+				return
+			}
+
+			buf := make([]byte, len("before"))
+			if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "before" {
+				t.Fatalf("server read %q, %v; want the data sent before the response", buf, err)
+			}
+			if _, err := server.Write([]byte("after")); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-clientErr; err != nil {
+				t.Fatalf("client: %v", err)
+			}
+
+			peerCerts := server.ConnectionState().PeerCertificates
+			if tt.err != "" {
+				if len(peerCerts) != 0 || verified != 0 {
+					t.Errorf("got %d peer certificates after a failed request", len(peerCerts))
+				}
+				return
+			}
+			if len(peerCerts) != 1 || !bytes.Equal(peerCerts[0].Raw, testECDSACertificate) {
+				t.Errorf("got %d peer certificates, want the client certificate", len(peerCerts))
+			}
+			if verified != tt.requests {
+				t.Errorf("client certificate verified %d times, want %d", verified, tt.requests)
+			}
+		})
+	}
+}
+
+func TestPostHandshakeAuthRejectedState(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS13
+	clientConfig.PostHandshakeAuth = true
+	clientConfig.Certificates = []Certificate{{Certificate: [][]byte{testECDSACertificate}, PrivateKey: testECDSAPrivateKey}}
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = VersionTLS13
+	sawCerts := false
+	serverConfig.VerifyConnection = func(cs ConnectionState) error {
+		if len(cs.PeerCertificates) > 0 {
+			sawCerts = true
+			return errors.New("connection rejected")
+		}
+		return nil
+	}
+	client, server := postHandshakeAuthConns(t, clientConfig, serverConfig)
+	go io.Copy(io.Discard, client)
+
+	if err := server.RequestClientCertificate(context.Background()); err == nil || !strings.Contains(err.Error(), "connection rejected") {
+		t.Fatalf("got error %v, want the VerifyConnection one", err)
+	}
+	if !sawCerts {
+		t.Error("VerifyConnection didn't see the client certificate")
+	}
+	// The rejected certificates aren't left in the connection state.
+	if cs := server.ConnectionState(); len(cs.PeerCertificates) != 0 || len(cs.VerifiedChains) != 0 {
+		t.Errorf("got %d peer certificates after the response was rejected", len(cs.PeerCertificates))
+	}
+}
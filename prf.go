@@ -14,6 +14,8 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+
+	"github.com/emmansun/gmsm/sm3"
 )
 
 type prfFunc func(secret []byte, label string, seed []byte, keyLen int) []byte
@@ -31,18 +33,23 @@ func pHash(result, secret, seed []byte, hash func() hash.Hash) {
 	h.Write(seed)
 	a := h.Sum(nil)
 
-	j := 0
-	for j < len(result) {
+	var b []byte
+	for len(result) > 0 {
 		h.Reset()
 		h.Write(a)
 		h.Write(seed)
-		b := h.Sum(nil)
-		copy(result[j:], b)
-		j += len(b)
+		if len(result) >= h.Size() {
+			// Write whole blocks directly into result.
+			h.Sum(result[:0])
+			result = result[h.Size():]
+		} else {
+			b = h.Sum(b[:0])
+			result = result[copy(result, b):]
+		}
 
 		h.Reset()
 		h.Write(a)
-		a = h.Sum(nil)
+		a = h.Sum(a[:0])
 	}
 }
 
@@ -95,6 +102,9 @@ func prfAndHashForVersion(version uint16, suite *cipherSuite) (prfFunc, crypto.H
 			return prf12(sha512.New384), crypto.SHA384
 		}
 		return prf12(sha256.New), crypto.SHA256
+	case VersionTLCP:
+		// The hash of TLCP, SM3, isn't a crypto.Hash, see newFinishedHash.
+		return prf12(sm3.New), crypto.Hash(0)
 	default:
 		panic("unknown version")
 	}
@@ -158,6 +168,9 @@ func newFinishedHash(version uint16, cipherSuite *cipherSuite) finishedHash {
 	}
 
 	prf, hash := prfAndHashForVersion(version, cipherSuite)
+	if version == VersionTLCP {
+		return finishedHash{sm3.New(), sm3.New(), nil, nil, buffer, version, prf}
+	}
 	if hash != 0 {
 		return finishedHash{hash.New(), hash.New(), nil, nil, buffer, version, prf}
 	}
@@ -171,7 +184,7 @@ type finishedHash struct {
 	client hash.Hash
 	server hash.Hash
 
-	// Prior to TLS 1.2, an additional MD5 hash is required.
+	// Prior to TLS 1.2, except in TLCP, an additional MD5 hash is required.
 	clientMD5 hash.Hash
 	serverMD5 hash.Hash
 
@@ -186,7 +199,7 @@ func (h *finishedHash) Write(msg []byte) (n int, err error) {
 	h.client.Write(msg)
 	h.server.Write(msg)
 
-	if h.version < VersionTLS12 {
+	if h.clientMD5 != nil {
 		h.clientMD5.Write(msg)
 		h.serverMD5.Write(msg)
 	}
@@ -199,7 +212,7 @@ func (h *finishedHash) Write(msg []byte) (n int, err error) {
 }
 
 func (h finishedHash) Sum() []byte {
-	if h.version >= VersionTLS12 {
+	if h.clientMD5 == nil {
 		return h.client.Sum(nil)
 	}
 
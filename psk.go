@@ -0,0 +1,254 @@
+package tls
+
+import (
+	"crypto"
+	"errors"
+	"hash"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ExternalPSK is a pre-shared key provisioned out of band. In TLS 1.3,
+// external PSKs are never used directly, but imported as specified in RFC
+// 9258, which binds them to TLS 1.3 and to the hash function of the negotiated
+// cipher suite. The TLS 1.2 PSK cipher suites use Identity and Key as is, and
+// only PSKs without a Context.
+type ExternalPSK struct {
+	// Identity is the external identity of the PSK. It is sent in plaintext
+	// by the client, and must not be empty.
+	Identity []byte
+
+	// Key is the secret key material of the PSK.
+	Key []byte
+
+	// Context is optional context information, which must be the same on
+	// the client and on the server for the PSK to be used.
+	Context []byte
+
+	// Hash is the hash function associated with the PSK. If zero, SHA-256
+	// is used. Only SHA-256 and SHA-384 are supported.
+	Hash crypto.Hash
+}
+
+func (psk *ExternalPSK) hash() crypto.Hash {
+	if psk.Hash == 0 {
+		return crypto.SHA256
+	}
+	return psk.Hash
+}
+
+// PSKMode is a TLS 1.3 PSK key exchange mode. See RFC 8446, Section 4.2.9.
+type PSKMode uint8
+
+const (
+	// PSKModeKE (psk_ke) derives the connection keys from the PSK alone,
+	// without forward secrecy.
+	PSKModeKE PSKMode = PSKMode(pskModePlain)
+
+	// PSKModeDHE (psk_dhe_ke) combines the PSK with an (EC)DHE key exchange.
+	PSKModeDHE PSKMode = PSKMode(pskModeDHE)
+)
+
+func (c *Config) externalPSKModes() []PSKMode {
+	if len(c.ExternalPSKModes) == 0 {
+		return []PSKMode{PSKModeDHE}
+	}
+	return c.ExternalPSKModes
+}
+
+// KDF identifiers from the TLS KDF Identifiers registry, see RFC 9258,
+// Section 10.
+const (
+	kdfHKDFSHA256 uint16 = 0x0001
+	kdfHKDFSHA384 uint16 = 0x0002
+)
+
+func kdfForHash(h tls13Hash) uint16 {
+	switch crypto.Hash(h) {
+	case crypto.SHA256:
+		return kdfHKDFSHA256
+	case crypto.SHA384:
+		return kdfHKDFSHA384
+	default:
+		return 0
+	}
+}
+
+// importedIdentity is the ImportedIdentity structure of RFC 9258, Section 4.1,
+// which is used as the PSK identity on the wire.
+type importedIdentity struct {
+	externalIdentity []byte
+	context          []byte
+	targetProtocol   uint16
+	targetKDF        uint16
+}
+
+func (id *importedIdentity) marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(id.externalIdentity)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(id.context)
+	})
+	b.AddUint16(id.targetProtocol)
+	b.AddUint16(id.targetKDF)
+	return b.Bytes()
+}
+
+func (id *importedIdentity) unmarshal(data []byte) bool {
+	s := cryptobyte.String(data)
+	return s.ReadUint16LengthPrefixed((*cryptobyte.String)(&id.externalIdentity)) &&
+		len(id.externalIdentity) > 0 &&
+		s.ReadUint16LengthPrefixed((*cryptobyte.String)(&id.context)) &&
+		s.ReadUint16(&id.targetProtocol) &&
+		s.ReadUint16(&id.targetKDF) && s.Empty()
+}
+
+// importPSK derives the imported PSK for the given identity and target hash,
+// as specified in RFC 9258, Section 4.2.
+func importPSK(psk *ExternalPSK, identity []byte, target tls13Hash) []byte {
+	h := psk.hash().New
+	epskx := tls13extract(h, psk.Key, nil)
+	identityHash := h()
+	identityHash.Write(identity)
+	return tls13ExpandLabel(h, epskx, "derived psk", identityHash.Sum(nil), target.Size())
+}
+
+// importedPSK is an external PSK imported for a target cipher suite hash and
+// offered by a client.
+type importedPSK struct {
+	psk         *ExternalPSK
+	suite       *cipherSuiteTLS13 // any offered suite with the target hash
+	earlySecret *tls13EarlySecret
+	binderKey   []byte
+}
+
+// loadExternalPSKs imports the Config.ExternalPSKs for the hash of each TLS
+// 1.3 cipher suite offered in hello, and offers them in the pre_shared_key
+// extension, in the same order as the returned list.
+func (c *Conn) loadExternalPSKs(hello *clientHelloMsg) ([]*importedPSK, error) {
+	if len(c.config.ExternalPSKs) == 0 || hello.supportedVersions[0] != VersionTLS13 ||
+		c.handshakes != 0 {
+		return nil, nil
+	}
+
+	// One imported PSK per target hash and external PSK.
+	var suites []*cipherSuiteTLS13
+	for _, id := range hello.cipherSuites {
+		suite := cipherSuiteTLS13ByID(id)
+		// There are no importers for suites without an RFC 9258 KDF, like SM3.
+		if suite != nil && kdfForHash(suite.hash) != 0 && !slicesContainsFunc(suites, func(s *cipherSuiteTLS13) bool {
+			return s.hash == suite.hash
+		}) {
+			suites = append(suites, suite)
+		}
+	}
+
+	var psks []*importedPSK
+	for i := range c.config.ExternalPSKs {
+		psk := &c.config.ExternalPSKs[i]
+		if len(psk.Identity) == 0 || kdfForHash(tls13Hash(psk.hash())) == 0 {
+			return nil, errors.New("tls: invalid ExternalPSK")
+		}
+		for _, suite := range suites {
+			id := &importedIdentity{
+				externalIdentity: psk.Identity,
+				context:          psk.Context,
+				targetProtocol:   VersionTLS13,
+				targetKDF:        kdfForHash(suite.hash),
+			}
+			label, err := id.marshal()
+			if err != nil {
+				return nil, err
+			}
+			earlySecret := tls13NewEarlySecret(suite.hash.New, importPSK(psk, label, suite.hash))
+			psks = append(psks, &importedPSK{
+				psk:         psk,
+				suite:       suite,
+				earlySecret: earlySecret,
+				binderKey:   earlySecret.ImportedBinderKey(),
+			})
+			// External PSKs don't have a ticket age. See RFC 8446, Section 4.2.11.
+			hello.pskIdentities = append(hello.pskIdentities, pskIdentity{label: label})
+			hello.pskBinders = append(hello.pskBinders, make([]byte, suite.hash.Size()))
+		}
+	}
+	if len(psks) == 0 {
+		return nil, nil
+	}
+	hello.pskModes = nil
+	for _, mode := range c.config.externalPSKModes() {
+		hello.pskModes = append(hello.pskModes, uint8(mode))
+	}
+
+	if err := computeAndUpdateImportedPSKs(hello, psks, nil); err != nil {
+		return nil, err
+	}
+	return psks, nil
+}
+
+// computeAndUpdateImportedPSKs sets the binders of the imported PSKs offered
+// in m. If not nil, prefix writes the messages that precede m to each PSK's
+// transcript.
+func computeAndUpdateImportedPSKs(m *clientHelloMsg, psks []*importedPSK, prefix func(hash.Hash) error) error {
+	helloBytes, err := m.marshalWithoutBinders()
+	if err != nil {
+		return err
+	}
+	binders := make([][]byte, 0, len(psks))
+	for _, psk := range psks {
+		transcript := psk.suite.hash.New()
+		if prefix != nil {
+			if err := prefix(transcript); err != nil {
+				return err
+			}
+		}
+		transcript.Write(helloBytes)
+		binders = append(binders, psk.suite.finishedHash(psk.binderKey, transcript))
+	}
+	return m.updateBinders(binders)
+}
+
+// getExternalPSK returns the external PSK a server should use for identity
+// and context, or nil.
+func (c *Config) getExternalPSK(identity, context []byte) (*ExternalPSK, error) {
+	if c.GetExternalPSK != nil {
+		return c.GetExternalPSK(identity, context)
+	}
+	for i := range c.ExternalPSKs {
+		psk := &c.ExternalPSKs[i]
+		if string(psk.Identity) == string(identity) && string(psk.Context) == string(context) {
+			return psk, nil
+		}
+	}
+	return nil, nil
+}
+
+// hasExternalPSKs reports whether a server may negotiate a TLS 1.2 PSK cipher
+// suite.
+func (c *Config) hasExternalPSKs() bool {
+	return len(c.ExternalPSKs) > 0 || c.GetExternalPSK != nil
+}
+
+// hasClientPSK reports whether a client may offer the TLS 1.2 PSK cipher
+// suites.
+func (c *Config) hasClientPSK() bool {
+	return c.GetClientPSK != nil || slicesContainsFunc(c.ExternalPSKs, func(psk ExternalPSK) bool {
+		return len(psk.Context) == 0
+	})
+}
+
+// getClientPSK returns the external PSK a client should use with a TLS 1.2
+// PSK cipher suite, given the server's identity hint, or nil.
+func (c *Config) getClientPSK(hint []byte) (*ExternalPSK, error) {
+	if c.GetClientPSK != nil {
+		return c.GetClientPSK(hint)
+	}
+	for i := range c.ExternalPSKs {
+		if psk := &c.ExternalPSKs[i]; len(psk.Context) == 0 {
+			return psk, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,359 @@
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"strings"
+	"testing"
+)
+
+func TestExternalPSK(t *testing.T) {
+	psk := ExternalPSK{
+		Identity: []byte("client"),
+		Key:      bytes.Repeat([]byte{0x42}, 32),
+		Context:  []byte("context"),
+	}
+
+	clientConfig := testConfig.Clone()
+	clientConfig.ExternalPSKs = []ExternalPSK{psk}
+	serverConfig := testConfig.Clone()
+	serverConfig.Certificates = nil
+	serverConfig.ExternalPSKs = []ExternalPSK{psk}
+
+	check := func(t *testing.T, clientConfig, serverConfig *Config, want []byte) {
+		t.Helper()
+		ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if !bytes.Equal(ss.ExternalPSKIdentity, want) || !bytes.Equal(cs.ExternalPSKIdentity, want) {
+			t.Errorf("ExternalPSKIdentity: server %q, client %q, want %q",
+				ss.ExternalPSKIdentity, cs.ExternalPSKIdentity, want)
+		}
+		if want != nil && (len(cs.PeerCertificates) != 0 || ss.DidResume || cs.DidResume) {
+			t.Errorf("PSK connection has %d peer certificates, DidResume %v",
+				len(cs.PeerCertificates), cs.DidResume)
+		}
+	}
+
+	t.Run("Basic", func(t *testing.T) {
+		check(t, clientConfig, serverConfig, psk.Identity)
+	})
+
+	t.Run("SHA384", func(t *testing.T) {
+		clientConfig := clientConfig.Clone()
+		clientConfig.ExternalPSKs = []ExternalPSK{{
+			Identity: []byte("other"),
+			Key:      []byte("other key"),
+		}, psk}
+		clientConfig.ExternalPSKs[1].Hash = crypto.SHA384
+		serverConfig := serverConfig.Clone()
+		serverConfig.ExternalPSKs = clientConfig.ExternalPSKs[1:]
+		check(t, clientConfig, serverConfig, psk.Identity)
+	})
+
+	t.Run("HelloRetryRequest", func(t *testing.T) {
+		serverConfig := serverConfig.Clone()
+		serverConfig.CurvePreferences = []CurveID{CurveP384}
+		clientConfig := clientConfig.Clone()
+		clientConfig.CurvePreferences = []CurveID{X25519, CurveP384}
+		check(t, clientConfig, serverConfig, psk.Identity)
+	})
+
+	t.Run("GetExternalPSK", func(t *testing.T) {
+		serverConfig := testConfig.Clone()
+		var offered []string
+		serverConfig.GetExternalPSK = func(identity, context []byte) (*ExternalPSK, error) {
+			offered = append(offered, string(identity)+"/"+string(context))
+			return nil, nil
+		}
+		// The server falls back to certificates.
+		check(t, clientConfig, serverConfig, nil)
+		// Only the identity imported for the hash of the selected suite is
+		// looked up.
+		if len(offered) != 1 || offered[0] != "client/context" {
+			t.Errorf("offered identities: %q", offered)
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		serverConfig := serverConfig.Clone()
+		serverConfig.ExternalPSKs = []ExternalPSK{psk}
+		serverConfig.ExternalPSKs[0].Key = []byte("wrong")
+		_, _, err := testHandshake(t, clientConfig, serverConfig)
+		if err == nil || !strings.Contains(err.Error(), "invalid PSK binder") {
+			t.Errorf("handshake with the wrong key: %v", err)
+		}
+	})
+
+	t.Run("TLSv12", func(t *testing.T) {
+		serverConfig := testConfig.Clone()
+		serverConfig.MaxVersion = VersionTLS12
+		serverConfig.ExternalPSKs = []ExternalPSK{psk}
+		check(t, clientConfig, serverConfig, nil)
+	})
+}
+
+func TestExternalPSKOnly(t *testing.T) {
+	psk := ExternalPSK{
+		Identity: []byte("device-42"),
+		Key:      bytes.Repeat([]byte{0x17}, 32),
+	}
+
+	for _, test := range []struct {
+		name          string
+		clientModes   []PSKMode
+		serverModes   []PSKMode
+		wantKeyShares bool
+	}{
+		{"DHE", nil, nil, true},
+		{"KE", []PSKMode{PSKModeKE}, []PSKMode{PSKModeKE}, false},
+		{"ServerPrefersKE", []PSKMode{PSKModeDHE, PSKModeKE}, []PSKMode{PSKModeKE, PSKModeDHE}, false},
+		{"ClientOnlyDHE", nil, []PSKMode{PSKModeKE, PSKModeDHE}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// Neither side has any certificates, and the client doesn't
+			// even know the server name.
+			clientConfig := &Config{
+				ExternalPSKs:     []ExternalPSK{psk},
+				ExternalPSKModes: test.clientModes,
+			}
+			serverConfig := &Config{
+				ExternalPSKs:     []ExternalPSK{psk},
+				ExternalPSKModes: test.serverModes,
+			}
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if !bytes.Equal(cs.ExternalPSKIdentity, psk.Identity) || !bytes.Equal(ss.ExternalPSKIdentity, psk.Identity) {
+				t.Errorf("ExternalPSKIdentity: server %q, client %q", ss.ExternalPSKIdentity, cs.ExternalPSKIdentity)
+			}
+			if (cs.CurveID != 0) != test.wantKeyShares || (ss.CurveID != 0) != test.wantKeyShares {
+				t.Errorf("CurveID: server %v, client %v", ss.CurveID, cs.CurveID)
+			}
+		})
+	}
+
+	t.Run("NoModeOverlap", func(t *testing.T) {
+		clientConfig := &Config{
+			ExternalPSKs:     []ExternalPSK{psk},
+			ExternalPSKModes: []PSKMode{PSKModeKE},
+		}
+		serverConfig := &Config{ExternalPSKs: []ExternalPSK{psk}}
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+			t.Error("handshake succeeded without a certificate or a PSK mode in common")
+		}
+	})
+
+	t.Run("ServerWithoutPSK", func(t *testing.T) {
+		// A client without a ServerName must not accept a certificate.
+		clientConfig := &Config{ExternalPSKs: []ExternalPSK{psk}}
+		_, _, err := testHandshake(t, clientConfig, testConfig)
+		if err == nil || !strings.Contains(err.Error(), "did not use an external PSK") {
+			t.Errorf("handshake with a certificate: %v", err)
+		}
+	})
+}
+
+func TestPSKCipherSuites(t *testing.T) {
+	psk := ExternalPSK{
+		Identity: []byte("sensor-7"),
+		Key:      bytes.Repeat([]byte{0x5a}, 16),
+	}
+
+	for _, suite := range []uint16{
+		TLS_PSK_WITH_AES_128_GCM_SHA256,
+		TLS_PSK_WITH_AES_256_GCM_SHA384,
+		TLS_PSK_WITH_AES_128_CBC_SHA,
+		TLS_PSK_WITH_AES_128_CBC_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA,
+	} {
+		t.Run(CipherSuiteName(suite), func(t *testing.T) {
+			clientConfig := &Config{
+				MaxVersion:   VersionTLS12,
+				CipherSuites: []uint16{suite},
+				ExternalPSKs: []ExternalPSK{psk},
+			}
+			serverConfig := &Config{
+				CipherSuites: []uint16{suite},
+				ExternalPSKs: []ExternalPSK{psk},
+			}
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if cs.CipherSuite != suite || ss.CipherSuite != suite {
+				t.Errorf("CipherSuite: server %s, client %s", CipherSuiteName(ss.CipherSuite), CipherSuiteName(cs.CipherSuite))
+			}
+			if !bytes.Equal(cs.ExternalPSKIdentity, psk.Identity) || !bytes.Equal(ss.ExternalPSKIdentity, psk.Identity) {
+				t.Errorf("ExternalPSKIdentity: server %q, client %q", ss.ExternalPSKIdentity, cs.ExternalPSKIdentity)
+			}
+			if wantCurve := cipherSuiteByID(suite).flags&suiteECDHE != 0; (cs.CurveID != 0) != wantCurve || (ss.CurveID != 0) != wantCurve {
+				t.Errorf("CurveID: server %v, client %v", ss.CurveID, cs.CurveID)
+			}
+			if len(cs.PeerCertificates) != 0 {
+				t.Errorf("client received %d certificates", len(cs.PeerCertificates))
+			}
+		})
+	}
+
+	t.Run("IdentityHint", func(t *testing.T) {
+		for _, suite := range []uint16{TLS_PSK_WITH_AES_128_GCM_SHA256, TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256} {
+			var gotHint []byte
+			clientConfig := &Config{
+				MaxVersion:   VersionTLS12,
+				CipherSuites: []uint16{suite},
+				GetClientPSK: func(hint []byte) (*ExternalPSK, error) {
+					gotHint = hint
+					return &psk, nil
+				},
+			}
+			var gotIdentity []byte
+			serverConfig := &Config{
+				CipherSuites:    []uint16{suite},
+				PSKIdentityHint: []byte("gateway"),
+				GetExternalPSK: func(identity, context []byte) (*ExternalPSK, error) {
+					gotIdentity = identity
+					return &psk, nil
+				},
+			}
+			if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+				t.Fatalf("%s: handshake failed: %v", CipherSuiteName(suite), err)
+			}
+			if string(gotHint) != "gateway" || !bytes.Equal(gotIdentity, psk.Identity) {
+				t.Errorf("%s: client got hint %q, server got identity %q", CipherSuiteName(suite), gotHint, gotIdentity)
+			}
+		}
+	})
+
+	t.Run("UnknownIdentity", func(t *testing.T) {
+		clientConfig := &Config{
+			MaxVersion:   VersionTLS12,
+			CipherSuites: []uint16{TLS_PSK_WITH_AES_128_GCM_SHA256},
+			ExternalPSKs: []ExternalPSK{{Identity: []byte("stranger"), Key: psk.Key}},
+		}
+		serverConfig := &Config{
+			CipherSuites: []uint16{TLS_PSK_WITH_AES_128_GCM_SHA256},
+			ExternalPSKs: []ExternalPSK{psk},
+		}
+		_, _, err := testHandshake(t, clientConfig, serverConfig)
+		if err == nil || !strings.Contains(err.Error(), "unknown PSK identity") {
+			t.Errorf("handshake with an unknown identity: %v", err)
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		clientConfig := &Config{
+			MaxVersion:   VersionTLS12,
+			CipherSuites: []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+			ExternalPSKs: []ExternalPSK{{Identity: psk.Identity, Key: []byte("wrong")}},
+		}
+		serverConfig := &Config{
+			CipherSuites: []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+			ExternalPSKs: []ExternalPSK{psk},
+		}
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+			t.Error("handshake with the wrong key succeeded")
+		}
+	})
+
+	t.Run("NotOfferedWithoutPSK", func(t *testing.T) {
+		// A server with both a certificate and PSKs works with clients that
+		// have no PSK, even if they enable the PSK cipher suites.
+		clientConfig := testConfig.Clone()
+		clientConfig.MaxVersion = VersionTLS12
+		clientConfig.CipherSuites = []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+		serverConfig := testConfig.Clone()
+		serverConfig.CipherSuites = clientConfig.CipherSuites
+		serverConfig.ExternalPSKs = []ExternalPSK{psk}
+		ss, _, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if ss.CipherSuite != TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || ss.ExternalPSKIdentity != nil {
+			t.Errorf("negotiated %s with PSK identity %q", CipherSuiteName(ss.CipherSuite), ss.ExternalPSKIdentity)
+		}
+	})
+
+	t.Run("NotDefault", func(t *testing.T) {
+		// The PSK cipher suites must be enabled in CipherSuites.
+		clientConfig := &Config{
+			MaxVersion:   VersionTLS12,
+			ExternalPSKs: []ExternalPSK{psk},
+		}
+		serverConfig := &Config{ExternalPSKs: []ExternalPSK{psk}}
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+			t.Error("handshake succeeded with the default cipher suites")
+		}
+		for _, aesGCMPreferred := range []bool{true, false} {
+			for _, id := range defaultCipherSuites(aesGCMPreferred) {
+				if cipherSuiteByID(id).flags&suitePSK != 0 {
+					t.Errorf("%s is enabled by default", CipherSuiteName(id))
+				}
+			}
+		}
+	})
+}
+
+func TestExternalPSKModeKEWithoutKeyShare(t *testing.T) {
+	psk := ExternalPSK{
+		Identity: []byte("device-42"),
+		Key:      bytes.Repeat([]byte{0x17}, 32),
+	}
+
+	for _, test := range []struct {
+		name string
+		// The client sends a key share for the first of clientCurves only.
+		clientCurves, serverCurves []CurveID
+	}{
+		{"NoKeyShare", []CurveID{X25519, CurveP256}, []CurveID{CurveP256}},
+		{"NoGroupInCommon", []CurveID{X25519}, []CurveID{CurveP256}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			clientConfig := &Config{
+				ExternalPSKs:     []ExternalPSK{psk},
+				ExternalPSKModes: []PSKMode{PSKModeKE, PSKModeDHE},
+				CurvePreferences: test.clientCurves,
+			}
+			serverConfig := &Config{
+				ExternalPSKs:     []ExternalPSK{psk},
+				ExternalPSKModes: []PSKMode{PSKModeKE},
+				CurvePreferences: test.serverCurves,
+			}
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if ss.HelloRetryRequest || !bytes.Equal(ss.ExternalPSKIdentity, psk.Identity) {
+				t.Errorf("HelloRetryRequest %v, ExternalPSKIdentity %q", ss.HelloRetryRequest, ss.ExternalPSKIdentity)
+			}
+			if cs.CurveID != 0 || ss.CurveID != 0 {
+				t.Errorf("CurveID: server %v, client %v", ss.CurveID, cs.CurveID)
+			}
+		})
+	}
+
+	t.Run("UnknownPSK", func(t *testing.T) {
+		// Without a PSK to accept, the server falls back to a
+		// HelloRetryRequest and its certificate.
+		clientConfig := testConfig.Clone()
+		clientConfig.ExternalPSKs = []ExternalPSK{psk}
+		clientConfig.ExternalPSKModes = []PSKMode{PSKModeKE, PSKModeDHE}
+		clientConfig.CurvePreferences = []CurveID{X25519, CurveP256}
+		serverConfig := testConfig.Clone()
+		serverConfig.ExternalPSKs = []ExternalPSK{{Identity: []byte("other"), Key: psk.Key}}
+		serverConfig.ExternalPSKModes = []PSKMode{PSKModeKE}
+		serverConfig.CurvePreferences = []CurveID{CurveP256}
+		ss, _, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if !ss.HelloRetryRequest || ss.ExternalPSKIdentity != nil || ss.CurveID != CurveP256 {
+			t.Errorf("HelloRetryRequest %v, ExternalPSKIdentity %q, CurveID %v", ss.HelloRetryRequest, ss.ExternalPSKIdentity, ss.CurveID)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package tls
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// qlogVersion is the version of the qlog main schema the events follow.
+// There is no qlog schema for TLS, so the events are in a "tls" category of
+// their own, modeled after the QUIC ones.
+const qlogVersion = "0.3"
+
+var handshakeMessageNames = map[uint8]string{
+	typeHelloRequest:             "hello_request",
+	typeClientHello:              "client_hello",
+	typeServerHello:              "server_hello",
+	typeNewSessionTicket:         "new_session_ticket",
+	typeEndOfEarlyData:           "end_of_early_data",
+	typeEncryptedExtensions:      "encrypted_extensions",
+	typeCertificate:              "certificate",
+	typeServerKeyExchange:        "server_key_exchange",
+	typeCertificateRequest:       "certificate_request",
+	typeServerHelloDone:          "server_hello_done",
+	typeCertificateVerify:        "certificate_verify",
+	typeClientKeyExchange:        "client_key_exchange",
+	typeClientCertificateRequest: "client_certificate_request",
+	typeFinished:                 "finished",
+	typeCertificateStatus:        "certificate_status",
+	typeKeyUpdate:                "key_update",
+	typeCompressedCertificate:    "compressed_certificate",
+}
+
+var recordTypeNames = map[recordType]string{
+	recordTypeChangeCipherSpec: "change_cipher_spec",
+	recordTypeAlert:            "alert",
+	recordTypeHandshake:        "handshake",
+	recordTypeApplicationData:  "application_data",
+}
+
+// A qlogger writes the events of a connection to the writer returned by
+// Config.QlogWriter, as JSON text sequences (RFC 7464), the streaming
+// serialization of qlog. Its methods do nothing on a nil qlogger.
+type qlogger struct {
+	mu    sync.Mutex
+	w     io.WriteCloser // nil once closed or after a write error
+	start time.Time
+}
+
+type qlogEvent struct {
+	Time float64        `json:"time"`
+	Name string         `json:"name"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// newQlogger returns the qlogger of c, or nil if Config.QlogWriter is nil or
+// returns nil.
+func newQlogger(c *Conn) *qlogger {
+	if c.config == nil || c.config.QlogWriter == nil {
+		return nil
+	}
+	w := c.config.QlogWriter(c)
+	if w == nil {
+		return nil
+	}
+	q := &qlogger{w: w, start: time.Now()}
+	vantagePoint := "server"
+	if c.isClient {
+		vantagePoint = "client"
+	}
+	q.write(map[string]any{
+		"qlog_version": qlogVersion,
+		"qlog_format":  "JSON-SEQ",
+		"trace": map[string]any{
+			"vantage_point": map[string]any{"type": vantagePoint},
+			"common_fields": map[string]any{
+				"time_format":    "relative",
+				"reference_time": float64(q.start.UnixNano()) / 1e6,
+			},
+		},
+	})
+	return q
+}
+
+// write writes a record of the text sequence, with q.mu held.
+func (q *qlogger) write(v any) {
+	if q.w == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	b = append(append([]byte{0x1e}, b...), '\n')
+	if _, err := q.w.Write(b); err != nil {
+		q.w.Close()
+		q.w = nil
+	}
+}
+
+func (q *qlogger) event(name string, data map[string]any) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.write(qlogEvent{
+		Time: float64(time.Since(q.start).Microseconds()) / 1e3,
+		Name: name,
+		Data: data,
+	})
+}
+
+// close closes the writer of q. The events that follow are dropped.
+func (q *qlogger) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.w != nil {
+		q.w.Close()
+		q.w = nil
+	}
+}
+
+func qlogEventName(sent bool, name string) string {
+	if sent {
+		return "tls:" + name + "_sent"
+	}
+	return "tls:" + name + "_received"
+}
+
+func (q *qlogger) handshakeMessage(sent bool, raw []byte) {
+	if q == nil {
+		return
+	}
+	name, ok := handshakeMessageNames[raw[0]]
+	if !ok {
+		name = strconv.Itoa(int(raw[0]))
+	}
+	q.event(qlogEventName(sent, "handshake_message"), map[string]any{
+		"type":   name,
+		"length": len(raw) - 4,
+	})
+}
+
+func (q *qlogger) record(info RecordInfo) {
+	if q == nil {
+		return
+	}
+	name, ok := recordTypeNames[recordType(info.Type)]
+	if !ok {
+		name = strconv.Itoa(int(info.Type))
+	}
+	q.event(qlogEventName(info.Sent, "record"), map[string]any{
+		"content_type":    name,
+		"length":          info.Length,
+		"epoch":           info.Epoch,
+		"sequence_number": info.Seq,
+	})
+}
+
+func (q *qlogger) alert(info AlertInfo) {
+	if q == nil {
+		return
+	}
+	level := strconv.Itoa(int(info.Level))
+	switch info.Level {
+	case alertLevelWarning:
+		level = "warning"
+	case alertLevelError:
+		level = "fatal"
+	}
+	description, ok := alertText[alert(info.Alert)]
+	if !ok {
+		description = strconv.Itoa(int(info.Alert))
+	}
+	q.event(qlogEventName(info.Sent, "alert"), map[string]any{
+		"level":       level,
+		"description": description,
+		"code":        int(info.Alert),
+	})
+}
+
+// qlogHandshakeDone logs the outcome of the handshake of c.
+func (c *Conn) qlogHandshakeDone() {
+	if c.qlog == nil {
+		return
+	}
+	if c.handshakeErr != nil {
+		c.qlog.event("tls:handshake_failed", map[string]any{"error": c.handshakeErr.Error()})
+		return
+	}
+	data := map[string]any{
+		"version":      VersionName(c.vers),
+		"cipher_suite": CipherSuiteName(c.cipherSuite),
+		"resumed":      c.didResume,
+	}
+	if c.curveID != 0 {
+		data["group"] = c.curveID.String()
+	}
+	if c.clientProtocol != "" {
+		data["alpn"] = c.clientProtocol
+	}
+	c.qlog.event("tls:handshake_done", data)
+}
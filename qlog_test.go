@@ -0,0 +1,106 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+)
+
+type qlogBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+	closed bool
+}
+
+func (b *qlogBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *qlogBuffer) Close() error {
+	b.Lock()
+	defer b.Unlock()
+	b.closed = true
+	return nil
+}
+
+func TestQlogWriter(t *testing.T) {
+	buffers := make(map[bool]*qlogBuffer)
+	config := testConfig.Clone()
+	config.QlogWriter = func(c *Conn) io.WriteCloser {
+		b := new(qlogBuffer)
+		buffers[c.isClient] = b
+		return b
+	}
+
+	c, s := localPipe(t)
+	client := Client(c, config)
+	server := Server(s, config)
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(server)
+		server.Close()
+		errChan <- err
+	}()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	for isClient, b := range buffers {
+		if !b.closed {
+			t.Errorf("qlog of client %v not closed", isClient)
+		}
+		records := bytes.Split(b.Bytes(), []byte{0x1e})
+		if len(records[0]) != 0 {
+			t.Fatalf("qlog of client %v starts with %q", isClient, records[0])
+		}
+		var header struct {
+			Format string `json:"qlog_format"`
+			Trace  struct {
+				VantagePoint struct {
+					Type string `json:"type"`
+				} `json:"vantage_point"`
+			} `json:"trace"`
+		}
+		if err := json.Unmarshal(records[1], &header); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[bool]string{true: "client", false: "server"}[isClient]; header.Format != "JSON-SEQ" || header.Trace.VantagePoint.Type != want {
+			t.Errorf("qlog header %s", records[1])
+		}
+		names := make(map[string]int)
+		var done map[string]any
+		for _, record := range records[2:] {
+			var event qlogEvent
+			if err := json.Unmarshal(record, &event); err != nil {
+				t.Fatalf("event %q: %v", record, err)
+			}
+			names[event.Name]++
+			if event.Name == "tls:handshake_done" {
+				done = event.Data
+			}
+		}
+		if done["version"] != "TLS 1.3" || done["resumed"] != false {
+			t.Errorf("handshake done with %v", done)
+		}
+		for _, name := range []string{"tls:handshake_started", "tls:handshake_message_sent", "tls:handshake_message_received",
+			"tls:record_sent", "tls:record_received"} {
+			if names[name] == 0 {
+				t.Errorf("qlog of client %v has no %s events", isClient, name)
+			}
+		}
+		if isClient && names["tls:alert_sent"] != 1 || !isClient && names["tls:alert_received"] != 1 {
+			t.Errorf("qlog of client %v has events %v", isClient, names)
+		}
+	}
+	if len(buffers) != 2 {
+		t.Errorf("got %d qlogs, want 2", len(buffers))
+	}
+}
@@ -321,7 +321,7 @@ type QUICSessionTicketOptions struct {
 // Currently, it can only be called once.
 func (q *QUICConn) SendSessionTicket(opts QUICSessionTicketOptions) error {
 	c := q.conn
-	if c.config.SessionTicketsDisabled {
+	if c.config.SessionTicketsDisabled || c.config.singleUseTicketsUnavailable() {
 		return nil
 	}
 	if !c.isHandshakeComplete.Load() {
@@ -0,0 +1,143 @@
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/circl/sign/ed448"
+)
+
+// A CertificateType is a type of credential that peers authenticate with, as
+// negotiated by the client_certificate_type and server_certificate_type
+// extensions. See RFC 7250.
+type CertificateType uint8
+
+const (
+	// CertificateTypeX509 is a chain of X.509 certificates, the default.
+	CertificateTypeX509 CertificateType = 0
+	// CertificateTypeRawPublicKey is a bare SubjectPublicKeyInfo, which is
+	// authenticated by [Config.VerifyRawPublicKey] instead of a PKI.
+	CertificateTypeRawPublicKey CertificateType = 2
+)
+
+// certificateTypes returns the wire form of the types configured in types,
+// or nil if only X.509 certificates are, in which case the extension isn't
+// sent.
+func certificateTypes(types []CertificateType) []uint8 {
+	var ret []uint8
+	for _, t := range types {
+		if t != CertificateTypeX509 && t != CertificateTypeRawPublicKey {
+			continue
+		}
+		if !slicesContains(ret, uint8(t)) {
+			ret = append(ret, uint8(t))
+		}
+	}
+	if len(ret) == 1 && ret[0] == uint8(CertificateTypeX509) {
+		return nil
+	}
+	return ret
+}
+
+// negotiateCertificateType returns the first type in ours the peer offered,
+// and whether there is one. If the peer didn't send the extension, only X.509
+// can be used, and an empty ours means the same.
+func negotiateCertificateType(ours []CertificateType, offered []uint8) (CertificateType, bool) {
+	if len(offered) == 0 {
+		offered = []uint8{uint8(CertificateTypeX509)}
+	}
+	if len(ours) == 0 {
+		ours = []CertificateType{CertificateTypeX509}
+	}
+	for _, t := range ours {
+		if slicesContains(offered, uint8(t)) {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// offeredCertificateType reports whether selected, or X.509 if the peer
+// didn't send the extension, is one of the wire types returned by
+// certificateTypes.
+func offeredCertificateType(offered []uint8, selected uint8) bool {
+	if len(offered) == 0 {
+		return selected == uint8(CertificateTypeX509)
+	}
+	return slicesContains(offered, selected)
+}
+
+// rawPublicKey returns the SubjectPublicKeyInfo of the key of cert, which is
+// sent instead of its certificate chain.
+func rawPublicKey(cert *Certificate) ([]byte, error) {
+	priv, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, unsupportedCertificateError(cert)
+	}
+	return x509.MarshalPKIXPublicKey(priv.Public())
+}
+
+// processPeerRawPublicKey parses and verifies the raw public key in the
+// Certificate message of the peer, setting c.peerRawPublicKey or sending the
+// appropriate alert. It returns nil if a client sent no key.
+func (c *Conn) processPeerRawPublicKey(certificates [][]byte) (crypto.PublicKey, error) {
+	if len(certificates) == 0 {
+		if c.isClient {
+			c.sendAlert(alertDecodeError)
+			return nil, errors.New("tls: received empty certificates message")
+		}
+		if requiresClientCert(c.config.ClientAuth) {
+			c.sendAlert(alertCertificateRequired)
+			return nil, errors.New("tls: client didn't provide a raw public key")
+		}
+		return nil, nil
+	}
+	if len(certificates) != 1 {
+		c.sendAlert(alertBadCertificate)
+		return nil, errors.New("tls: peer sent more than one raw public key")
+	}
+	pub, err := parsePKIXPublicKey(certificates[0])
+	if err != nil {
+		c.sendAlert(alertBadCertificate)
+		return nil, errors.New("tls: failed to parse raw public key: " + err.Error())
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if max, ok := checkKeySize(pub.N.BitLen()); !ok {
+			c.sendAlert(alertBadCertificate)
+			return nil, fmt.Errorf("tls: peer sent RSA raw public key larger than %d bits", max)
+		}
+	case *ecdsa.PublicKey, ed25519.PublicKey, ed448.PublicKey:
+	default:
+		c.sendAlert(alertUnsupportedCertificate)
+		return nil, fmt.Errorf("tls: peer sent an unsupported type of raw public key: %T", pub)
+	}
+	if err := c.config.checkPeerKeySize(pub); err != nil {
+		c.sendAlert(alertBadCertificate)
+		return nil, &CertificateVerificationError{Err: errors.New("peer sent raw public key: " + err.Error())}
+	}
+
+	// There is no chain to verify, so the key must be authenticated by the
+	// application whenever certificates would be verified.
+	mustVerify := !c.config.InsecureSkipVerify
+	if !c.isClient {
+		mustVerify = c.config.ClientAuth >= VerifyClientCertIfGiven
+	}
+	if c.config.VerifyRawPublicKey != nil {
+		if err := c.config.VerifyRawPublicKey(certificates[0], pub); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return nil, &CertificateVerificationError{Err: err}
+		}
+	} else if mustVerify {
+		c.sendAlert(alertBadCertificate)
+		return nil, &CertificateVerificationError{Err: errors.New("received a raw public key, but Config.VerifyRawPublicKey is not set")}
+	}
+
+	c.peerRawPublicKey = certificates[0]
+	return pub, nil
+}
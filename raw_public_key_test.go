@@ -0,0 +1,177 @@
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRawPublicKeys(t *testing.T) {
+	spki, err := rawPublicKey(&testConfig.Certificates[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyKey := func(rawPublicKey []byte, publicKey crypto.PublicKey) error {
+		if !bytes.Equal(rawPublicKey, spki) {
+			return errors.New("unknown raw public key")
+		}
+		return nil
+	}
+	rpk := []CertificateType{CertificateTypeRawPublicKey}
+	rpkOrX509 := []CertificateType{CertificateTypeRawPublicKey, CertificateTypeX509}
+
+	for _, tt := range []struct {
+		name          string
+		version       uint16
+		serverTypes   []CertificateType // of the server config
+		clientTypes   []CertificateType // of the server config
+		offerServer   []CertificateType // of the client config
+		offerClient   []CertificateType // of the client config
+		clientVerify  bool
+		serverVerify  bool
+		insecure      bool
+		clientAuth    ClientAuthType
+		minRSAKeySize int // of the client config
+		wantServerRPK bool
+		wantClientRPK bool
+		err           string
+	}{
+		{name: "Server", version: VersionTLS13, serverTypes: rpk, offerServer: rpkOrX509, clientVerify: true, wantServerRPK: true},
+		{name: "ServerPrefersX509", version: VersionTLS13, serverTypes: []CertificateType{CertificateTypeX509, CertificateTypeRawPublicKey}, offerServer: rpkOrX509, insecure: true},
+		{name: "NotOffered", version: VersionTLS13, serverTypes: rpkOrX509, insecure: true},
+		{name: "TLS12", version: VersionTLS12, serverTypes: rpkOrX509, offerServer: rpkOrX509, insecure: true},
+		{name: "InsecureSkipVerify", version: VersionTLS13, serverTypes: rpk, offerServer: rpk, insecure: true, wantServerRPK: true},
+		{name: "NoCallback", version: VersionTLS13, serverTypes: rpk, offerServer: rpk, err: "VerifyRawPublicKey is not set"},
+		{name: "Unsupported", version: VersionTLS13, serverTypes: rpk, insecure: true, err: "server certificate type"},
+		{name: "ServerOnlyX509", version: VersionTLS13, offerServer: rpk, clientVerify: true, err: "server certificate type"},
+		{name: "Mutual", version: VersionTLS13, serverTypes: rpk, clientTypes: rpk, offerServer: rpk, offerClient: rpk,
+			clientVerify: true, serverVerify: true, clientAuth: RequireAndVerifyClientCert, wantServerRPK: true, wantClientRPK: true},
+		{name: "ClientRequestOnly", version: VersionTLS13, clientTypes: rpk, offerClient: rpkOrX509, insecure: true,
+			clientAuth: RequestClientCert, wantClientRPK: true},
+		{name: "WeakServerKey", version: VersionTLS13, serverTypes: rpk, offerServer: rpk, clientVerify: true,
+			minRSAKeySize: 2048, err: "raw public key: RSA key of 1024 bits"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			serverConfig.ServerCertificateTypes = tt.serverTypes
+			serverConfig.ClientCertificateTypes = tt.clientTypes
+			serverConfig.ClientAuth = tt.clientAuth
+			if tt.serverVerify {
+				serverConfig.VerifyRawPublicKey = verifyKey
+			}
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.ServerCertificateTypes = tt.offerServer
+			clientConfig.ClientCertificateTypes = tt.offerClient
+			clientConfig.MinRSAKeySize = tt.minRSAKeySize
+			if tt.clientVerify {
+				clientConfig.VerifyRawPublicKey = verifyKey
+			}
+			clientConfig.InsecureSkipVerify = tt.insecure
+			clientConfig.ServerName = "example.golang"
+
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if tt.wantServerRPK {
+				if !bytes.Equal(cs.PeerRawPublicKey, spki) || len(cs.PeerCertificates) != 0 {
+					t.Error("server didn't authenticate with its raw public key")
+				}
+			} else if cs.PeerRawPublicKey != nil || len(cs.PeerCertificates) == 0 {
+				t.Error("server didn't authenticate with its certificate")
+			}
+			if tt.wantClientRPK {
+				if !bytes.Equal(ss.PeerRawPublicKey, spki) || len(ss.PeerCertificates) != 0 {
+					t.Error("client didn't authenticate with its raw public key")
+				}
+			} else if ss.PeerRawPublicKey != nil {
+				t.Error("client unexpectedly authenticated with a raw public key")
+			}
+		})
+	}
+}
+
+func TestRawPublicKeyClientWithoutCallback(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	serverConfig.ClientCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	serverConfig.ClientAuth = RequireAndVerifyClientCert
+	testRawPublicKeyClientRejected(t, serverConfig, "VerifyRawPublicKey is not set")
+}
+
+func TestRawPublicKeyClientWeakKey(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	serverConfig.ClientCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	serverConfig.ClientAuth = RequireAndVerifyClientCert
+	serverConfig.VerifyRawPublicKey = func([]byte, crypto.PublicKey) error { return nil }
+	serverConfig.MinRSAKeySize = 2048
+	testRawPublicKeyClientRejected(t, serverConfig, "raw public key: RSA key of 1024 bits")
+}
+
+// testRawPublicKeyClientRejected checks that serverConfig rejects the raw
+// public key of a client with an error containing want.
+func testRawPublicKeyClientRejected(t *testing.T, serverConfig *Config, want string) {
+	t.Helper()
+	clientConfig := testConfig.Clone()
+	clientConfig.ClientCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+
+	c, s := localPipe(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cli := Client(c, clientConfig)
+		if err := cli.Handshake(); err == nil {
+			io.ReadAll(cli)
+		}
+		cli.Close()
+	}()
+	err := Server(s, serverConfig).Handshake()
+	s.Close()
+	<-done
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+}
+
+func TestRawPublicKeyRejected(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	serverConfig.ServerCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	clientConfig := testConfig.Clone()
+	clientConfig.ServerCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	clientConfig.InsecureSkipVerify = false
+	clientConfig.ServerName = "example.golang"
+	clientConfig.VerifyRawPublicKey = func([]byte, crypto.PublicKey) error {
+		return errors.New("untrusted key")
+	}
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil || !strings.Contains(err.Error(), "untrusted key") {
+		t.Fatalf("got error %v, want the VerifyRawPublicKey error", err)
+	}
+}
+
+func TestRawPublicKeyNoResumption(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	serverConfig.ServerCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	clientConfig := testConfig.Clone()
+	clientConfig.ServerCertificateTypes = []CertificateType{CertificateTypeRawPublicKey}
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	for i := 0; i < 2; i++ {
+		_, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("handshake %d failed: %v", i, err)
+		}
+		if cs.DidResume || cs.PeerRawPublicKey == nil {
+			t.Fatalf("handshake %d resumed a session authenticated with a raw public key", i)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/metacubex/hkdf"
+	"github.com/metacubex/mlkem"
+)
+
+// A RealityConfig configures the server side of REALITY, the protocol of
+// Xray-core which hides a proxy behind the TLS handshake of a real site.
+// Clients authenticate covertly in the session ID of their ClientHello: the
+// first 16 bytes of the session ID are their version, a reserved byte, the
+// Unix time and a short ID, and are sealed with AES-GCM in the last 16 bytes,
+// with the ClientHello with a zero session ID as additional data. The key is
+// derived with HKDF-SHA256 from the X25519 shared secret of their key share
+// and PrivateKey, salted with the first 20 bytes of the client random, which
+// are followed by the nonce. The server then proves it knows PrivateKey with
+// a certificate whose signature is the HMAC-SHA512 of its Ed25519 public key
+// under that same key.
+//
+// A RealityConfig must not be modified after it's first used by
+// [RealityServer].
+type RealityConfig struct {
+	// Dest is the address of the real site, such as "example.com:443",
+	// which the connections of clients that aren't authenticated are
+	// proxied to.
+	Dest string
+
+	// DialContext dials Dest over TCP. If nil, a net.Dialer is used.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// ServerNames are the server names clients may send. Usually those of
+	// the certificate of Dest.
+	ServerNames []string
+
+	// PrivateKey is the X25519 private key of the server, whose public key
+	// is known to the clients.
+	PrivateKey []byte
+
+	// ShortIDs are the short IDs accepted from the clients, padded with
+	// zeros.
+	ShortIDs [][8]byte
+
+	// MinClientVersion and MaxClientVersion, if not empty, are the oldest
+	// and newest three bytes versions of the clients accepted.
+	MinClientVersion, MaxClientVersion []byte
+
+	// MaxTimeDiff, if not zero, is the maximum difference accepted between
+	// the time of the clients and Config.Time.
+	MaxTimeDiff time.Duration
+
+	once     sync.Once
+	certKey  ed25519.PrivateKey
+	certDER  []byte
+	onceErr  error
+	key      *ecdh.PrivateKey
+	shortIDs map[[8]byte]bool
+}
+
+// ErrRealityFallback is returned by [RealityServer] for the connections it
+// proxied to RealityConfig.Dest.
+var ErrRealityFallback = errors.New("tls: REALITY client not authenticated, connection proxied to the target")
+
+// realityInfo is the HKDF info of the authentication key.
+const realityInfo = "REALITY"
+
+func (r *RealityConfig) init() error {
+	r.once.Do(func() {
+		r.key, r.onceErr = ecdh.X25519().NewPrivateKey(r.PrivateKey)
+		if r.onceErr != nil {
+			r.onceErr = errors.New("tls: invalid REALITY private key: " + r.onceErr.Error())
+			return
+		}
+		r.shortIDs = make(map[[8]byte]bool, len(r.ShortIDs))
+		for _, id := range r.ShortIDs {
+			r.shortIDs[id] = true
+		}
+		// The signature is replaced for each client, so the certificate
+		// is only signed to get its encoding.
+		var pub ed25519.PublicKey
+		pub, r.certKey, r.onceErr = ed25519.GenerateKey(rand.Reader)
+		if r.onceErr != nil {
+			return
+		}
+		template := &x509.Certificate{SerialNumber: new(big.Int)}
+		r.certDER, r.onceErr = x509.CreateCertificate(rand.Reader, template, template, pub, r.certKey)
+	})
+	return r.onceErr
+}
+
+// RealityServer reads the ClientHello from conn and, if the client
+// authenticates with reality, returns a server Conn using config, with
+// the TLS 1.3 handshake completed and its certificate replaced by the one
+// proving the server knows RealityConfig.PrivateKey. The caller then serves
+// its proxied traffic over the Conn.
+//
+// Otherwise, or if Config.ClientHelloReplayFilter reports the ClientHello as
+// a replay, the connection is transparently proxied to RealityConfig.Dest,
+// starting with the bytes already read, so that active probes only ever see
+// the real site. RealityServer then returns ErrRealityFallback once both
+// sides are done, after closing conn.
+//
+// The context only bounds reading the ClientHello, dialing Dest and the
+// handshake.
+func RealityServer(ctx context.Context, conn net.Conn, config *Config, reality *RealityConfig) (*Conn, error) {
+	if err := reality.init(); err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	raw, hello := readRealityClientHello(conn)
+	conn.SetReadDeadline(time.Time{})
+	if hello == nil {
+		return nil, reality.fallback(ctx, conn, raw)
+	}
+	if config == nil {
+		config = defaultConfig()
+	}
+	// Replays of the ClientHello of a client would otherwise authenticate.
+	if filter := config.ClientHelloReplayFilter; filter != nil && filter.SeenClientHello(hello.random) {
+		return nil, reality.fallback(ctx, conn, raw)
+	}
+	authKey := reality.authenticate(config, hello)
+	if authKey == nil {
+		return nil, reality.fallback(ctx, conn, raw)
+	}
+
+	config = config.Clone()
+	config.ClientHelloReplayFilter = nil
+	config.MinVersion = VersionTLS13
+	config.GetConfigForClient = nil
+	config.GetCertificateForHello = nil
+	cert := reality.certificate(authKey)
+	config.Certificates = nil
+	config.GetCertificate = func(*ClientHelloInfo) (*Certificate, error) {
+		return cert, nil
+	}
+	c := Server(&prefixConn{Conn: conn, prefix: raw}, config)
+	if err := c.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// readRealityClientHello reads the records of the ClientHello from conn. It
+// returns the bytes read, and the parsed ClientHello, or nil if conn doesn't
+// start with a valid one.
+func readRealityClientHello(conn net.Conn) ([]byte, *clientHelloMsg) {
+	var raw, data []byte
+	msgLen := func() int {
+		return 4 + (int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+	}
+	for len(data) < 4 || len(data) < msgLen() {
+		var hdr [recordHeaderLen]byte
+		n, err := io.ReadFull(conn, hdr[:])
+		raw = append(raw, hdr[:n]...)
+		if err != nil || recordType(hdr[0]) != recordTypeHandshake {
+			return raw, nil
+		}
+		length := int(binary.BigEndian.Uint16(hdr[3:]))
+		if length == 0 || length > maxPlaintext || len(raw)+length > maxHandshake {
+			return raw, nil
+		}
+		body := make([]byte, length)
+		n, err = io.ReadFull(conn, body)
+		raw = append(raw, body[:n]...)
+		if err != nil {
+			return raw, nil
+		}
+		data = append(data, body...)
+		if data[0] != typeClientHello {
+			return raw, nil
+		}
+	}
+	hello := new(clientHelloMsg)
+	if len(data) != msgLen() || !hello.unmarshal(data) {
+		return raw, nil
+	}
+	return raw, hello
+}
+
+// authenticate returns the authentication key of the client of hello, or nil
+// if it isn't an authenticated REALITY client.
+func (r *RealityConfig) authenticate(config *Config, hello *clientHelloMsg) []byte {
+	if len(hello.sessionId) != 32 || !slicesContains(hello.supportedVersions, VersionTLS13) ||
+		!slicesContains(r.ServerNames, hello.serverName) {
+		return nil
+	}
+	// The session ID starts after the type, length, version, random and
+	// the length of the session ID.
+	const sessionIDOffset = 4 + 2 + 32 + 1
+	aad := bytes.Clone(hello.original)
+	copy(aad[sessionIDOffset:sessionIDOffset+32], make([]byte, 32))
+
+	for _, ks := range hello.keyShares {
+		share := ks.data
+		switch {
+		case ks.group == X25519 && len(share) == 32:
+		case ks.group == X25519MLKEM768 && len(share) == mlkem.EncapsulationKeySize768+32:
+			share = share[mlkem.EncapsulationKeySize768:]
+		default:
+			continue
+		}
+		peer, err := ecdh.X25519().NewPublicKey(share)
+		if err != nil {
+			continue
+		}
+		shared, err := r.key.ECDH(peer)
+		if err != nil {
+			continue
+		}
+		authKey, err := hkdf.Key(sha256.New, shared, hello.random[:20], realityInfo, 32)
+		if err != nil {
+			continue
+		}
+		block, _ := aes.NewCipher(authKey)
+		aead, _ := cipher.NewGCM(block)
+		plaintext, err := aead.Open(nil, hello.random[20:], hello.sessionId, aad)
+		if err != nil {
+			continue
+		}
+		if r.checkSessionID(config, plaintext) {
+			return authKey
+		}
+		return nil
+	}
+	return nil
+}
+
+// checkSessionID reports whether the decrypted session ID carries an accepted
+// version, time and short ID.
+func (r *RealityConfig) checkSessionID(config *Config, plaintext []byte) bool {
+	version := plaintext[:3]
+	if len(r.MinClientVersion) > 0 && bytes.Compare(version, r.MinClientVersion) < 0 ||
+		len(r.MaxClientVersion) > 0 && bytes.Compare(version, r.MaxClientVersion) > 0 {
+		return false
+	}
+	if r.MaxTimeDiff != 0 {
+		clientTime := time.Unix(int64(binary.BigEndian.Uint32(plaintext[4:])), 0)
+		diff := config.time().Sub(clientTime)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > r.MaxTimeDiff {
+			return false
+		}
+	}
+	var shortID [8]byte
+	copy(shortID[:], plaintext[8:])
+	return r.shortIDs[shortID]
+}
+
+// certificate returns the certificate proving to the client with authKey that
+// the server knows PrivateKey.
+func (r *RealityConfig) certificate(authKey []byte) *Certificate {
+	der := bytes.Clone(r.certDER)
+	// An Ed25519 signature is the last 64 bytes of the certificate.
+	h := hmac.New(sha512.New, authKey)
+	h.Write(r.certKey.Public().(ed25519.PublicKey))
+	h.Sum(der[:len(der)-ed25519.SignatureSize])
+	return &Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  r.certKey,
+	}
+}
+
+// fallback proxies conn to Dest, first sending raw, until both sides are done.
+func (r *RealityConfig) fallback(ctx context.Context, conn net.Conn, raw []byte) error {
+	if err := FallbackProxy(r.Dest, r.DialContext)(ctx, conn, raw); err != nil {
+		return errors.Join(ErrRealityFallback, err)
+	}
+	return ErrRealityFallback
+}
+
+// A prefixConn is a net.Conn whose reads return prefix first.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
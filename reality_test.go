@@ -0,0 +1,208 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/metacubex/hkdf"
+)
+
+// realityClient returns a client Conn which authenticates with REALITY to the
+// server with public key serverKey, as Xray-core does, and checks the
+// certificate proves the server knows the private key.
+func realityClient(conn net.Conn, config *Config, serverKey *ecdh.PublicKey, shortID [8]byte) *Conn {
+	c := Client(conn, config)
+	var authKey []byte
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(certs [][]byte, _ [][]*x509.Certificate) error {
+		cert, err := x509.ParseCertificate(certs[0])
+		if err != nil {
+			return err
+		}
+		pub, ok := cert.PublicKey.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("not an Ed25519 certificate")
+		}
+		h := hmac.New(sha512.New, authKey)
+		h.Write(pub)
+		if !bytes.Equal(h.Sum(nil), cert.Signature) {
+			return errors.New("certificate not signed with the authentication key")
+		}
+		return nil
+	}
+	c.handshakeFn = func(ctx context.Context) error {
+		hello, keyShareKeys, _, err := c.makeClientHello()
+		if err != nil {
+			return err
+		}
+		shared, err := keyShareKeys.ecdhe.ECDH(serverKey)
+		if err != nil {
+			return err
+		}
+		authKey, err = hkdf.Key(sha256.New, shared, hello.random[:20], realityInfo, 32)
+		if err != nil {
+			return err
+		}
+		plaintext := []byte{1, 8, 0, 0}
+		plaintext = binary.BigEndian.AppendUint32(plaintext, uint32(c.config.time().Unix()))
+		plaintext = append(plaintext, shortID[:]...)
+		hello.sessionId = make([]byte, 32)
+		aad, err := hello.marshal()
+		if err != nil {
+			return err
+		}
+		block, _ := aes.NewCipher(authKey)
+		aead, _ := cipher.NewGCM(block)
+		hello.sessionId = aead.Seal(nil, hello.random[20:], plaintext, aad)
+
+		c.serverName = hello.serverName
+		if _, err := c.writeHandshakeRecord(hello, nil); err != nil {
+			return err
+		}
+		msg, err := c.readHandshake(nil)
+		if err != nil {
+			return err
+		}
+		serverHello, ok := msg.(*serverHelloMsg)
+		if !ok {
+			return unexpectedMessageError(serverHello, msg)
+		}
+		if err := c.pickTLSVersion(serverHello); err != nil {
+			return err
+		}
+		hs := &clientHandshakeStateTLS13{
+			c:            c,
+			ctx:          ctx,
+			serverHello:  serverHello,
+			hello:        hello,
+			keyShareKeys: keyShareKeys,
+		}
+		return hs.handshake()
+	}
+	return c
+}
+
+func TestReality(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortID := [8]byte{0x12, 0x34}
+
+	for _, tt := range []struct {
+		name       string
+		curves     []CurveID
+		shortID    [8]byte
+		serverName string
+		replayed   bool
+		wantAuth   bool
+	}{
+		{name: "X25519", curves: []CurveID{X25519}, shortID: shortID, serverName: "example.com", wantAuth: true},
+		{name: "X25519MLKEM768", curves: []CurveID{X25519MLKEM768}, shortID: shortID, serverName: "example.com", wantAuth: true},
+		{name: "WrongShortID", curves: []CurveID{X25519}, shortID: [8]byte{1}, serverName: "example.com"},
+		{name: "WrongServerName", curves: []CurveID{X25519}, shortID: shortID, serverName: "example.org"},
+		{name: "NotReality", serverName: "example.com"},
+		{name: "Replayed", curves: []CurveID{X25519}, shortID: shortID, serverName: "example.com", replayed: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The target site answers with the test certificate.
+			targetDone := make(chan error, 1)
+			reality := &RealityConfig{
+				Dest:        "example.com:443",
+				ServerNames: []string{"example.com"},
+				PrivateKey:  privateKey.Bytes(),
+				ShortIDs:    [][8]byte{shortID},
+				MaxTimeDiff: time.Minute,
+				DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+					c, s := net.Pipe()
+					go func() {
+						srv := Server(s, testConfig.Clone())
+						_, err := srv.Write([]byte("target"))
+						srv.Close()
+						targetDone <- err
+					}()
+					return c, nil
+				},
+			}
+
+			serverConfig := testConfig.Clone()
+			serverConfig.CurvePreferences = nil
+			serverConfig.ClientHelloReplayFilter = NewClientHelloReplayFilter(time.Minute)
+			if tt.replayed {
+				// The zero Rand of testConfig makes clients send a zero random.
+				serverConfig.ClientHelloReplayFilter.SeenClientHello(make([]byte, 32))
+			}
+			c, s := localPipe(t)
+			serverDone := make(chan error, 1)
+			go func() {
+				conn, err := RealityServer(context.Background(), s, serverConfig, reality)
+				if err != nil {
+					serverDone <- err
+					return
+				}
+				_, err = conn.Write([]byte("proxy"))
+				conn.Close()
+				serverDone <- err
+			}()
+
+			clientConfig := testConfig.Clone()
+			clientConfig.ServerName = tt.serverName
+			var client *Conn
+			if tt.curves != nil {
+				clientConfig.CurvePreferences = tt.curves
+				client = realityClient(c, clientConfig, privateKey.PublicKey(), tt.shortID)
+			} else {
+				clientConfig.InsecureSkipVerify = true
+				client = Client(c, clientConfig)
+			}
+			clientErr := client.Handshake()
+			got, _ := io.ReadAll(client)
+			client.Close()
+
+			err := <-serverDone
+			if tt.wantAuth {
+				if clientErr != nil {
+					t.Fatalf("client: %v", clientErr)
+				}
+				if err != nil {
+					t.Fatalf("server: %v", err)
+				}
+				if string(got) != "proxy" {
+					t.Errorf("got %q from the REALITY server", got)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRealityFallback) {
+				t.Fatalf("got %v, want ErrRealityFallback", err)
+			}
+			if tt.curves != nil {
+				// REALITY clients reject the certificate of the target.
+				if clientErr == nil {
+					t.Error("REALITY client accepted the target site")
+				}
+				return
+			}
+			if err := <-targetDone; err != nil {
+				t.Fatal(err)
+			}
+			if clientErr != nil || string(got) != "target" {
+				t.Errorf("got %q, %v instead of the target site", got, clientErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package tls
+
+import "encoding/binary"
+
+// A RecordInfo describes a TLS record sent or received by a connection,
+// reported to [Config.RecordObserver] to build diagnostics such as those of
+// Wireshark.
+type RecordInfo struct {
+	// Sent is true for the records sent, and false for those received.
+	Sent bool
+
+	// Type is the content type of the record, such as 22 for handshake or
+	// 23 for application_data, the inner one for TLS 1.3.
+	Type uint8
+
+	// Length is the length of the record on the wire, with its header.
+	Length int
+
+	// Epoch is the number of times the keys of the direction of the record
+	// changed before it, zero for the records in the clear. In TLS 1.3,
+	// handshake keys are the first epoch, application keys the second one,
+	// if there is no 0-RTT data, and each KeyUpdate adds one.
+	Epoch int
+
+	// Seq is the sequence number of the record in its epoch, zero for the
+	// records in the clear, including the change_cipher_spec ones of TLS 1.3.
+	Seq uint64
+
+	// Plaintext is the content of the record, without padding, only set if
+	// Config.RecordObserverPlaintext is. It must not be retained.
+	Plaintext []byte
+}
+
+// seqNum returns the sequence number of the next record of hc.
+func (hc *halfConn) seqNum() uint64 {
+	return binary.BigEndian.Uint64(hc.seq[:])
+}
+
+// observeRecord reports a record of hc, of length bytes on the wire with
+// plaintext and sequence number seq, to Config.RecordObserver.
+func (c *Conn) observeRecord(hc *halfConn, typ recordType, seq uint64, length int, plaintext []byte) {
+	info := RecordInfo{Sent: hc == &c.out, Type: uint8(typ), Length: length, Epoch: hc.epoch, Seq: seq}
+	if hc.cipher == nil || hc.version == VersionTLS13 && typ == recordTypeChangeCipherSpec {
+		info.Epoch, info.Seq = 0, 0
+	}
+	c.qlog.record(info)
+	if c.config.RecordObserver == nil {
+		return
+	}
+	if c.config.RecordObserverPlaintext {
+		info.Plaintext = plaintext
+	}
+	c.config.RecordObserver(info)
+}
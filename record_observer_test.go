@@ -0,0 +1,90 @@
+package tls
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestRecordObserver(t *testing.T) {
+	for _, plaintext := range []bool{false, true} {
+		var mu sync.Mutex
+		var sent, received []RecordInfo
+		clientConfig := testConfig.Clone()
+		clientConfig.RecordObserverPlaintext = plaintext
+		clientConfig.RecordObserver = func(r RecordInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			r.Plaintext = slicesClone(r.Plaintext)
+			if r.Sent {
+				sent = append(sent, r)
+			} else {
+				received = append(received, r)
+			}
+		}
+
+		c, s := localPipe(t)
+		recorder := &writeRecorder{Conn: c}
+		client := Client(recorder, clientConfig)
+		server := Server(s, testConfig)
+		errChan := make(chan error, 1)
+		go func() {
+			if _, err := server.Write([]byte("world")); err != nil {
+				errChan <- err
+				return
+			}
+			_, err := io.ReadFull(server, make([]byte, 5))
+			errChan <- err
+		}()
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		server.Close()
+
+		mu.Lock()
+		recorder.Lock()
+		total := 0
+		for _, r := range sent {
+			total += r.Length
+		}
+		if want := len(recorder.written); total != want {
+			t.Errorf("sent records of %d bytes, want %d", total, want)
+		}
+		recorder.Unlock()
+		if first := sent[0]; first.Type != uint8(recordTypeHandshake) || first.Epoch != 0 || first.Seq != 0 {
+			t.Errorf("first record sent %+v", first)
+		}
+		var data []RecordInfo
+		for _, r := range append(sent, received...) {
+			if r.Type == uint8(recordTypeApplicationData) {
+				data = append(data, r)
+			}
+		}
+		if len(data) != 2 || data[0].Epoch != 2 || data[1].Epoch != 2 {
+			t.Fatalf("records of application data %+v", data)
+		}
+		if got := string(data[0].Plaintext) + string(data[1].Plaintext); plaintext && got != "helloworld" || !plaintext && got != "" {
+			t.Errorf("plaintexts %q", got)
+		}
+		for _, records := range [][]RecordInfo{sent, received} {
+			for i := 1; i < len(records); i++ {
+				prev, r := records[i-1], records[i]
+				want := prev.Seq + 1
+				if r.Epoch == 0 || r.Epoch != prev.Epoch {
+					want = 0
+				}
+				if r.Seq != want {
+					t.Errorf("record %+v after %+v", r, prev)
+				}
+			}
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,53 @@
+package tls
+
+import "errors"
+
+// minRecordSizeLimit is the smallest record_size_limit allowed by RFC 8449,
+// Section 4.
+const minRecordSizeLimit = 64
+
+// recordSizeLimit returns the value of the record_size_limit extension sent
+// by c with version vers, which in TLS 1.3 counts the content type. It's
+// maxPlaintext if RecordSizeLimit is zero, as servers answer clients sending
+// the extension even without a limit of their own.
+func (c *Config) recordSizeLimit(vers uint16) uint16 {
+	limit := maxPlaintext
+	if c.RecordSizeLimit != 0 {
+		limit = c.RecordSizeLimit
+	}
+	if limit < minRecordSizeLimit {
+		limit = minRecordSizeLimit
+	} else if limit > maxPlaintext {
+		limit = maxPlaintext
+	}
+	if vers == VersionTLS13 {
+		limit++
+	}
+	return uint16(limit)
+}
+
+// recordSizeLimitPlaintext returns the maximum size of the plaintext of the
+// records with version vers under the record_size_limit limit.
+func recordSizeLimitPlaintext(limit, vers uint16) int {
+	n := int(limit)
+	if vers == VersionTLS13 {
+		n-- // the content type
+	}
+	if n > maxPlaintext {
+		n = maxPlaintext
+	}
+	return n
+}
+
+// setRecordSizeLimits sets the record size limits of c from the
+// record_size_limit extensions sent to and received from the peer, after the
+// version is negotiated. The limits only apply to protected records.
+func (c *Conn) setRecordSizeLimits(limit, peerLimit uint16) error {
+	if peerLimit < minRecordSizeLimit {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: peer sent an invalid record_size_limit")
+	}
+	c.recordSizeLimit = recordSizeLimitPlaintext(limit, c.vers)
+	c.peerRecordSizeLimit = recordSizeLimitPlaintext(peerLimit, c.vers)
+	return nil
+}
@@ -0,0 +1,83 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRecordSizeLimit(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		version     uint16
+		clientLimit int
+		serverLimit int
+		wantClient  int
+		wantServer  int
+	}{
+		{"TLS12", VersionTLS12, 256, 0, maxPlaintext, 256},
+		{"TLS13", VersionTLS13, 256, 0, maxPlaintext, 256},
+		{"TLS13-Both", VersionTLS13, 100, 512, 512, 100},
+		{"TLS12-ServerOnly", VersionTLS12, 0, 512, maxPlaintext, maxPlaintext},
+		{"TLS13-Clamped", VersionTLS13, 1, 0, maxPlaintext, minRecordSizeLimit},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.RecordSizeLimit = tt.clientLimit
+			serverConfig := testConfig.Clone()
+			serverConfig.RecordSizeLimit = tt.serverLimit
+
+			c, s := localPipe(t)
+			payload := bytes.Repeat([]byte("a"), 10000)
+			done := make(chan error, 1)
+			go func() {
+				server := Server(s, serverConfig)
+				defer server.Close()
+				if err := server.Handshake(); err != nil {
+					done <- err
+					return
+				}
+				if got := server.ConnectionState().RecordSizeLimit; got != tt.wantServer {
+					t.Errorf("got server RecordSizeLimit %d, want %d", got, tt.wantServer)
+				}
+				_, err := server.Write(payload)
+				done <- err
+			}()
+
+			client := Client(c, clientConfig)
+			defer client.Close()
+			// Reading the payload fails with record_overflow if the server
+			// doesn't respect the client's limit.
+			got := make([]byte, len(payload))
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+			if got := client.ConnectionState().RecordSizeLimit; got != tt.wantClient {
+				t.Errorf("got client RecordSizeLimit %d, want %d", got, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestRecordSizeLimitOverflow(t *testing.T) {
+	c, s := localPipe(t)
+	client := Client(c, testConfig.Clone())
+	client.recordSizeLimit = 100
+	client.isHandshakeComplete.Store(true)
+	client.haveVers, client.vers = true, VersionTLS12
+	go func() {
+		s.Write([]byte{byte(recordTypeApplicationData), 3, 3, 0, 200})
+		s.Write(make([]byte, 200))
+	}()
+	if _, err := client.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "record overflow") {
+		t.Fatalf("got error %v, want record overflow", err)
+	}
+}
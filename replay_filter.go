@@ -0,0 +1,145 @@
+package tls
+
+import (
+	"errors"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+// A ClientHelloReplayFilter detects the ClientHellos a server already
+// received, such as those active probes record and replay to servers
+// camouflaged as TLS, to tell them apart from their clients. It is set in
+// [Config.ClientHelloReplayFilter], and replays are reported in
+// [ClientHelloInfo.Replayed].
+//
+// A single instance only covers the servers that share it. Implementations
+// must be safe for concurrent use.
+type ClientHelloReplayFilter interface {
+	// SeenClientHello is called by servers with the random of each
+	// ClientHello they receive, the first one if ECH is used, before any
+	// callback of the handshake. It records random, and reports whether it
+	// was already recorded.
+	SeenClientHello(random []byte) bool
+}
+
+// NewClientHelloReplayFilter returns a [ClientHelloReplayFilter] that
+// remembers each random for window. Its memory grows with the rate of
+// ClientHellos; see [NewClientHelloBloomFilter] for a bounded one.
+//
+// The returned value is local to the process, and must be shared by all the
+// Configs of the servers a ClientHello could be replayed to.
+func NewClientHelloReplayFilter(window time.Duration) ClientHelloReplayFilter {
+	return &replayWindow{
+		window: window,
+		seen:   make(map[string]struct{}),
+	}
+}
+
+type replayWindow struct {
+	window time.Duration
+
+	sync.Mutex
+	seen map[string]struct{}
+	// queue holds the randoms in seen in the order they were recorded, and
+	// so in the order they expire.
+	queue []replayWindowEntry
+}
+
+type replayWindowEntry struct {
+	random string
+	expiry time.Time
+}
+
+func (w *replayWindow) SeenClientHello(random []byte) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now()
+	i := 0
+	for i < len(w.queue) && now.After(w.queue[i].expiry) {
+		delete(w.seen, w.queue[i].random)
+		i++
+	}
+	w.queue = w.queue[i:]
+
+	if _, ok := w.seen[string(random)]; ok {
+		return true
+	}
+	w.seen[string(random)] = struct{}{}
+	w.queue = append(w.queue, replayWindowEntry{
+		random: string(random),
+		expiry: now.Add(w.window),
+	})
+	return false
+}
+
+// NewClientHelloBloomFilter returns a [ClientHelloReplayFilter] of fixed
+// size, made of two bloom filters of capacity randoms each: when the current
+// one is full, it replaces the previous one, and a new one is started. It
+// remembers at least the last capacity randoms, and reports new ones as
+// replays with a rate of at most about twice falsePositiveRate.
+//
+// The filters are keyed with a random seed, so clients can't choose randoms
+// that collide. The returned value is local to the process, like those of
+// [NewClientHelloReplayFilter]. falsePositiveRate must be between 0 and 1,
+// exclusive.
+func NewClientHelloBloomFilter(capacity int, falsePositiveRate float64) (ClientHelloReplayFilter, error) {
+	if !(falsePositiveRate > 0 && falsePositiveRate < 1) {
+		return nil, errors.New("tls: ClientHello bloom filter false positive rate must be between 0 and 1")
+	}
+	capacity = maxInt(capacity, 1)
+	bits := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	bits = math.Max(bits, 64)
+	return &replayBloom{
+		capacity: capacity,
+		bits:     uint64(bits),
+		hashes:   maxInt(int(math.Round(bits/float64(capacity)*math.Ln2)), 1),
+		seeds:    [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+		current:  make([]uint64, (uint64(bits)+63)/64),
+		previous: make([]uint64, (uint64(bits)+63)/64),
+	}, nil
+}
+
+type replayBloom struct {
+	capacity int
+	bits     uint64
+	hashes   int
+	seeds    [2]maphash.Seed
+
+	sync.Mutex
+	current, previous []uint64
+	// count is the number of randoms added to current.
+	count int
+}
+
+func (f *replayBloom) SeenClientHello(random []byte) bool {
+	// Double hashing, as in Kirsch and Mitzenmacher, "Less Hashing, Same
+	// Performance: Building a Better Bloom Filter".
+	h1 := maphash.Bytes(f.seeds[0], random)
+	h2 := maphash.Bytes(f.seeds[1], random) | 1
+	inCurrent, inPrevious := true, true
+	f.Lock()
+	defer f.Unlock()
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.bits
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		inPrevious = inPrevious && f.previous[word]&mask != 0
+		if f.current[word]&mask == 0 {
+			inCurrent = false
+			f.current[word] |= mask
+		}
+	}
+	if !inCurrent {
+		f.count++
+	}
+	if f.count >= f.capacity {
+		f.previous, f.current = f.current, f.previous
+		for i := range f.current {
+			f.current[i] = 0
+		}
+		f.count = 0
+	}
+	return inCurrent || inPrevious
+}
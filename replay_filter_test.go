@@ -0,0 +1,84 @@
+package tls
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestClientHelloReplayFilter(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	serverConfig.ClientHelloReplayFilter = NewClientHelloReplayFilter(time.Minute)
+	var replayed []bool
+	serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+		replayed = append(replayed, chi.Replayed)
+		return nil, nil
+	}
+	// The zero Rand of testConfig makes clients send the same random.
+	for i := 0; i < 2; i++ {
+		if _, _, err := testHandshake(t, testConfig, serverConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fresh := testConfig.Clone()
+	fresh.Rand = rand.Reader
+	if _, _, err := testHandshake(t, fresh, serverConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 3 || replayed[0] || !replayed[1] || replayed[2] {
+		t.Errorf("replayed %v, want [false true false]", replayed)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	f := NewClientHelloReplayFilter(50 * time.Millisecond)
+	random := make([]byte, 32)
+	if f.SeenClientHello(random) {
+		t.Error("new random seen")
+	}
+	if !f.SeenClientHello(random) {
+		t.Error("replay not seen")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if f.SeenClientHello(random) {
+		t.Error("random seen after the window")
+	}
+}
+
+func TestReplayBloom(t *testing.T) {
+	const capacity = 1000
+	f, err := NewClientHelloBloomFilter(capacity, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := func(i int) []byte {
+		b := make([]byte, 32)
+		binary.BigEndian.PutUint64(b, uint64(i))
+		return b
+	}
+	falsePositives := 0
+	for i := 0; i < 3*capacity; i++ {
+		if f.SeenClientHello(random(i)) {
+			falsePositives++
+		}
+	}
+	// The last capacity randoms are remembered.
+	for i := 3*capacity - 1; i >= 2*capacity; i-- {
+		if !f.SeenClientHello(random(i)) {
+			t.Fatalf("random %d forgotten", i)
+		}
+	}
+	if falsePositives > 20 {
+		t.Errorf("%d false positives in %d randoms", falsePositives, 3*capacity)
+	}
+}
+
+func TestReplayBloomRate(t *testing.T) {
+	for _, rate := range []float64{0, -0.1, 1, 2, math.NaN()} {
+		if _, err := NewClientHelloBloomFilter(1000, rate); err == nil {
+			t.Errorf("false positive rate %v accepted", rate)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package tls
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A HandshakeScript shapes a connection after a script shared by the
+// endpoints of a protocol camouflaged as TLS, such as restls, within what the
+// handshake allows: the preference lists of the ClientHello can be reordered
+// or shortened, and the lengths of the TLS 1.3 records of application data
+// sent after the handshake, and dummy records among them, are scripted. It
+// is set in [Config.HandshakeScript].
+type HandshakeScript struct {
+	// ClientHello, if not nil, is called by clients with the preferences
+	// of the ClientHello they're about to send, which it can reorder or
+	// shorten. Values not in the original lists are rejected, as are key
+	// shares left without their group.
+	ClientHello func(*ScriptedClientHello) error
+
+	// Records are the lengths of the first TLS 1.3 records of application
+	// data sent after the handshake, as returned by [ParseRecordScript].
+	Records []ScriptedRecord
+}
+
+// A ScriptedClientHello holds the preferences of a ClientHello a
+// HandshakeScript can change.
+type ScriptedClientHello struct {
+	CipherSuites     []uint16
+	SupportedCurves  []CurveID
+	SignatureSchemes []SignatureScheme
+	ALPNProtocols    []string
+}
+
+// A ScriptedRecord is an entry of HandshakeScript.Records.
+type ScriptedRecord struct {
+	// Length is the length of the content of the record, plus a random
+	// amount from zero to Jitter. Records with more data are split, and
+	// those with less are padded.
+	Length, Jitter int
+
+	// Dummy records hold no data, only padding. They're sent before the
+	// next record of data. TLS 1.3 endpoints drop them, but this package
+	// aborts the connections sending more than 16 in a row.
+	Dummy bool
+}
+
+// ParseRecordScript parses a comma-separated list of records, each of them
+// a length, such as "250", or a length and a jitter, such as "250~100" for
+// 250 to 350 bytes, with a leading "!" for dummy records, such as "!100".
+func ParseRecordScript(script string) ([]ScriptedRecord, error) {
+	var records []ScriptedRecord
+	for _, entry := range strings.Split(script, ",") {
+		var r ScriptedRecord
+		entry = strings.TrimSpace(entry)
+		if strings.HasPrefix(entry, "!") {
+			r.Dummy = true
+			entry = entry[1:]
+		}
+		length, jitter, hasJitter := strings.Cut(entry, "~")
+		var err error
+		if r.Length, err = strconv.Atoi(length); err != nil || r.Length <= 0 {
+			return nil, fmt.Errorf("tls: invalid record length %q in script", length)
+		}
+		if hasJitter {
+			if r.Jitter, err = strconv.Atoi(jitter); err != nil || r.Jitter < 0 {
+				return nil, fmt.Errorf("tls: invalid record jitter %q in script", jitter)
+			}
+		}
+		if r.Length+r.Jitter > maxPlaintext {
+			return nil, fmt.Errorf("tls: record length %q in script over %d bytes", entry, maxPlaintext)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// scriptClientHello applies Config.HandshakeScript.ClientHello to hello.
+func (c *Conn) scriptClientHello(hello *clientHelloMsg) error {
+	scripted := &ScriptedClientHello{
+		CipherSuites:     slicesClone(hello.cipherSuites),
+		SupportedCurves:  slicesClone(hello.supportedCurves),
+		SignatureSchemes: slicesClone(hello.supportedSignatureAlgorithms),
+		ALPNProtocols:    slicesClone(hello.alpnProtocols),
+	}
+	if err := c.config.HandshakeScript.ClientHello(scripted); err != nil {
+		return err
+	}
+	if !isSubset(scripted.CipherSuites, hello.cipherSuites) {
+		return errors.New("tls: HandshakeScript added cipher suites to the ClientHello")
+	}
+	if !isSubset(scripted.SupportedCurves, hello.supportedCurves) {
+		return errors.New("tls: HandshakeScript added groups to the ClientHello")
+	}
+	if !isSubset(scripted.SignatureSchemes, hello.supportedSignatureAlgorithms) {
+		return errors.New("tls: HandshakeScript added signature schemes to the ClientHello")
+	}
+	if !isSubset(scripted.ALPNProtocols, hello.alpnProtocols) {
+		return errors.New("tls: HandshakeScript added ALPN protocols to the ClientHello")
+	}
+	for _, ks := range hello.keyShares {
+		if !slicesContains(scripted.SupportedCurves, ks.group) {
+			return errors.New("tls: HandshakeScript removed a group with a key share from the ClientHello")
+		}
+	}
+	hello.cipherSuites = scripted.CipherSuites
+	hello.supportedCurves = scripted.SupportedCurves
+	hello.supportedSignatureAlgorithms = scripted.SignatureSchemes
+	hello.alpnProtocols = scripted.ALPNProtocols
+	return nil
+}
+
+// isSubset reports whether all the values of list are in of, without
+// duplicates.
+func isSubset[T comparable](list, of []T) bool {
+	seen := make(map[T]bool, len(list))
+	for _, v := range list {
+		if seen[v] || !slicesContains(of, v) {
+			return false
+		}
+		seen[v] = true
+	}
+	return true
+}
+
+// scriptedRecord returns the length and kind of the next record of type typ
+// of Config.HandshakeScript, at most max, and counts it, or false if the
+// script doesn't apply.
+func (c *Conn) scriptedRecord(typ recordType, max int) (length int, dummy, ok bool) {
+	script := c.config.HandshakeScript
+	if script == nil || c.scriptedRecords >= len(script.Records) || typ != recordTypeApplicationData ||
+		c.out.version != VersionTLS13 || c.out.cipher == nil || !c.isHandshakeComplete.Load() {
+		return 0, false, false
+	}
+	r := script.Records[c.scriptedRecords]
+	c.scriptedRecords++
+	length = r.Length
+	if r.Jitter > 0 {
+		length += randIntn(c.config.rand(), r.Jitter+1)
+	}
+	if length > max {
+		length = max
+	}
+	return length, r.Dummy, true
+}
@@ -0,0 +1,155 @@
+package tls
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRecordScript(t *testing.T) {
+	got, err := ParseRecordScript("250, 300~100,!64,!10~5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ScriptedRecord{{Length: 250}, {Length: 300, Jitter: 100}, {Length: 64, Dummy: true}, {Length: 10, Jitter: 5, Dummy: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %+v, want %+v", got, want)
+	}
+	for _, script := range []string{"", "250,", "0", "-1", "100~", "100~-1", "!", "16000~1000", "a~1"} {
+		if _, err := ParseRecordScript(script); err == nil {
+			t.Errorf("parsed %q", script)
+		}
+	}
+}
+
+// recordLengths returns the lengths of the plaintexts of the TLS 1.3 records
+// of application data in b, protected with AES-GCM or ChaCha20-Poly1305.
+func recordLengths(b []byte) []int {
+	var lengths []int
+	for len(b) >= recordHeaderLen {
+		n := int(b[3])<<8 | int(b[4])
+		if recordType(b[0]) == recordTypeApplicationData {
+			lengths = append(lengths, n-1-16)
+		}
+		b = b[recordHeaderLen+n:]
+	}
+	return lengths
+}
+
+func TestHandshakeScriptRecords(t *testing.T) {
+	records, err := ParseRecordScript("300~50,!200,100,!64,!64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig := testConfig.Clone()
+	clientConfig.HandshakeScript = &HandshakeScript{Records: records}
+	c, s := localPipe(t)
+	recorder := &writeRecorder{Conn: c}
+	client := Client(recorder, clientConfig)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		if err := server.Handshake(); err != nil {
+			errChan <- err
+			return
+		}
+		_, err := io.ReadFull(server, make([]byte, 650))
+		errChan <- err
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Lock()
+	before := len(recorder.written)
+	recorder.Unlock()
+	if _, err := client.Write(make([]byte, 150)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.Lock()
+	defer recorder.Unlock()
+	lengths := recordLengths(recorder.written[before:])
+	if len(lengths) != 6 || lengths[0] < 300 || lengths[0] > 350 || !reflect.DeepEqual(lengths[1:], []int{200, 100, 64, 64, 400}) {
+		t.Errorf("records of %v bytes", lengths)
+	}
+}
+
+func TestHandshakeScriptClientHello(t *testing.T) {
+	var serverSuites []uint16
+	var serverCurves []CurveID
+	serverConfig := testConfig.Clone()
+	serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+		serverSuites, serverCurves = chi.CipherSuites, chi.SupportedCurves
+		return nil, nil
+	}
+
+	clientConfig := testConfig.Clone()
+	clientConfig.CurvePreferences = []CurveID{X25519, CurveP256, CurveP384}
+	clientConfig.HandshakeScript = &HandshakeScript{ClientHello: func(hello *ScriptedClientHello) error {
+		// Move the TLS 1.3 cipher suites last, and drop a group.
+		var tls13 []uint16
+		hello.CipherSuites = slicesDeleteFunc(hello.CipherSuites, func(id uint16) bool {
+			if id>>8 == 0x13 {
+				tls13 = append(tls13, id)
+				return true
+			}
+			return false
+		})
+		hello.CipherSuites = append(hello.CipherSuites, tls13...)
+		hello.SupportedCurves = []CurveID{X25519, CurveP384}
+		return nil
+	}}
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+		t.Fatal(err)
+	}
+	if last := serverSuites[len(serverSuites)-1]; last>>8 != 0x13 {
+		t.Errorf("server received the cipher suites %v", serverSuites)
+	}
+	if !reflect.DeepEqual(serverCurves, []CurveID{X25519, CurveP384}) {
+		t.Errorf("server received the groups %v", serverCurves)
+	}
+
+	errScript := errors.New("script error")
+	for _, tt := range []struct {
+		name   string
+		script func(*ScriptedClientHello) error
+		err    string
+	}{
+		{"Error", func(*ScriptedClientHello) error { return errScript }, "script error"},
+		{"AddedSuite", func(hello *ScriptedClientHello) error {
+			hello.CipherSuites = append(hello.CipherSuites, 0x1337)
+			return nil
+		}, "added cipher suites"},
+		{"DuplicateGroup", func(hello *ScriptedClientHello) error {
+			hello.SupportedCurves = append(hello.SupportedCurves, hello.SupportedCurves[0])
+			return nil
+		}, "added groups"},
+		{"KeyShareGroup", func(hello *ScriptedClientHello) error {
+			hello.SupportedCurves = hello.SupportedCurves[1:]
+			return nil
+		}, "key share"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := clientConfig.Clone()
+			config.HandshakeScript = &HandshakeScript{ClientHello: tt.script}
+			c, s := localPipe(t)
+			defer s.Close()
+			err := Client(c, config).Handshake()
+			c.Close()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("handshake returned %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
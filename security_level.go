@@ -0,0 +1,110 @@
+package tls
+
+import "crypto"
+
+// securityLevel is the set of constraints of a Config.SecurityLevel.
+type securityLevel struct {
+	// bits is the minimum security in bits of the cipher suites, key
+	// exchanges, signature algorithms and elliptic curve keys.
+	bits int
+	// rsaBits is the minimum size in bits of the RSA keys and DHE primes.
+	rsaBits int
+	// minVersion is the minimum protocol version.
+	minVersion uint16
+	// forwardSecrecy is whether the TLS 1.0–1.2 cipher suites must use an
+	// ephemeral key exchange.
+	forwardSecrecy bool
+	// noSHA1MAC is whether the cipher suites using HMAC-SHA1 are disabled.
+	noSHA1MAC bool
+}
+
+// securityLevels are the levels of Config.SecurityLevel, which follow those
+// of OpenSSL 3, described in SSL_CTX_set_security_level(3).
+var securityLevels = [...]securityLevel{
+	{},
+	{bits: 80, rsaBits: 1024, minVersion: VersionTLS12},
+	{bits: 112, rsaBits: 2048, minVersion: VersionTLS12},
+	{bits: 128, rsaBits: 3072, minVersion: VersionTLS12, forwardSecrecy: true},
+	{bits: 192, rsaBits: 7680, minVersion: VersionTLS12, forwardSecrecy: true, noSHA1MAC: true},
+	{bits: 256, rsaBits: 15360, minVersion: VersionTLS12, forwardSecrecy: true, noSHA1MAC: true},
+}
+
+func (c *Config) securityLevel() securityLevel {
+	if c == nil || c.SecurityLevel <= 0 {
+		return securityLevels[0]
+	}
+	if c.SecurityLevel >= len(securityLevels) {
+		return securityLevels[len(securityLevels)-1]
+	}
+	return securityLevels[c.SecurityLevel]
+}
+
+// cipherSuiteStrength returns the security in bits of the cipher of a TLS
+// 1.0–1.2 cipher suite.
+func cipherSuiteStrength(suite *cipherSuite) int {
+	switch suite.id {
+	case TLS_RSA_WITH_RC4_128_SHA, TLS_ECDHE_RSA_WITH_RC4_128_SHA, TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:
+		// RC4 is broken, and OpenSSL disables it from level 2.
+		return 80
+	case TLS_RSA_WITH_3DES_EDE_CBC_SHA, TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:
+		return 112
+	}
+	return suite.keyLen * 8
+}
+
+// allowsCipherSuite reports whether the TLS 1.0–1.2 cipher suite with the
+// given ID meets the level.
+func (l securityLevel) allowsCipherSuite(id uint16) bool {
+	suite := cipherSuiteByID(id)
+	if suite == nil {
+		return false
+	}
+	if suite.flags&suiteTLCP != 0 {
+		// TLCP has its own, fixed, algorithms.
+		return true
+	}
+	if cipherSuiteStrength(suite) < l.bits {
+		return false
+	}
+	if l.forwardSecrecy && suite.flags&(suiteECDHE|suiteDHE) == 0 {
+		return false
+	}
+	// HMAC-SHA1 is the only MAC with a 20 bytes key.
+	return !l.noSHA1MAC || suite.macLen != 20
+}
+
+// allowsCipherSuiteTLS13 reports whether the TLS 1.3 cipher suite with the
+// given ID meets the level.
+func (l securityLevel) allowsCipherSuiteTLS13(id uint16) bool {
+	suite := cipherSuiteTLS13ByID(id)
+	return suite != nil && suite.keyLen*8 >= l.bits
+}
+
+// allowsSignatureAlgorithm reports whether the security of the hash of a
+// signature algorithm meets the level. The key is checked separately.
+func (l securityLevel) allowsSignatureAlgorithm(s SignatureScheme) bool {
+	if l.bits == 0 {
+		return true
+	}
+	var strength int
+	switch s {
+	case Ed25519, SM2WithSM3:
+		strength = 128
+	case Ed448:
+		strength = 224
+	default:
+		_, sigHash, err := typeAndHashFromSignatureScheme(s)
+		if err != nil {
+			return false
+		}
+		switch sigHash {
+		case crypto.SHA256:
+			strength = 128
+		case crypto.SHA384:
+			strength = 192
+		case crypto.SHA512:
+			strength = 256
+		}
+	}
+	return strength >= l.bits
+}
@@ -0,0 +1,88 @@
+package tls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecurityLevel(t *testing.T) {
+	ecdsaCert := Certificate{
+		Certificate: [][]byte{testP256Certificate},
+		PrivateKey:  testP256PrivateKey,
+	}
+
+	for _, tt := range []struct {
+		name        string
+		level       int
+		maxVersion  uint16
+		serverCert  *Certificate
+		wantError   string
+		wantVersion uint16
+	}{
+		{name: "Zero", maxVersion: VersionTLS10, wantVersion: VersionTLS10},
+		{name: "OldVersion", level: 1, maxVersion: VersionTLS11, wantError: "no supported versions"},
+		{name: "SmallRSA", level: 2, maxVersion: VersionTLS12, wantError: "RSA key of 1024 bits"},
+		{name: "P256", level: 3, serverCert: &ecdsaCert, wantVersion: VersionTLS13},
+		{name: "WeakP256", level: 4, serverCert: &ecdsaCert, wantError: "signature algorithms"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.SecurityLevel = tt.level
+			if tt.maxVersion != 0 {
+				clientConfig.MaxVersion = tt.maxVersion
+			}
+			serverConfig := testConfig.Clone()
+			if tt.serverCert != nil {
+				serverConfig.Certificates = []Certificate{*tt.serverCert}
+				serverConfig.NameToCertificate = nil
+			}
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("got error %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cs.Version != tt.wantVersion {
+				t.Errorf("got version %s, want %s", VersionName(cs.Version), VersionName(tt.wantVersion))
+			}
+		})
+	}
+}
+
+func TestSecurityLevelAlgorithms(t *testing.T) {
+	config := &Config{SecurityLevel: 3, CipherSuites: allCipherSuites()}
+	for _, id := range config.cipherSuites(true) {
+		suite := cipherSuiteByID(id)
+		if suite.flags&(suiteECDHE|suiteDHE) == 0 || suite.keyLen < 16 || strings.Contains(CipherSuiteName(id), "RC4") {
+			t.Errorf("cipher suite %s enabled at level 3", CipherSuiteName(id))
+		}
+	}
+
+	config.SecurityLevel = 5
+	for _, id := range config.cipherSuites(true) {
+		if name := CipherSuiteName(id); !strings.Contains(name, "256") || strings.HasSuffix(name, "_SHA") {
+			t.Errorf("cipher suite %s enabled at level 5", name)
+		}
+	}
+	for _, id := range config.tls13CipherSuites(defaultCipherSuitesTLS13, false) {
+		if id == TLS_AES_128_GCM_SHA256 {
+			t.Error("TLS_AES_128_GCM_SHA256 enabled at level 5")
+		}
+	}
+	if got := config.curvePreferences(VersionTLS13); len(got) != 1 || got[0] != CurveP521 {
+		t.Errorf("got curve preferences %v at level 5, want only P-521", got)
+	}
+	for _, s := range config.supportedSignatureAlgorithms(VersionTLS12) {
+		if _, sigHash, _ := typeAndHashFromSignatureScheme(s); sigHash.Size() != 64 {
+			t.Errorf("signature algorithm %v enabled at level 5", s)
+		}
+	}
+	if got := config.ffdheGroups(); len(got) != 0 {
+		t.Errorf("got FFDHE groups %v at level 5, want none", got)
+	}
+}
@@ -0,0 +1,45 @@
+package tls
+
+// SessionEventKind is a type of session lifecycle event.
+type SessionEventKind int
+
+const (
+	// SessionTicketIssued indicates that the server sent a session ticket.
+	// [SessionEvent.Session] is the session the ticket resumes.
+	// This event only occurs on server connections.
+	SessionTicketIssued SessionEventKind = iota + 1
+
+	// SessionTicketReceived indicates that the client received a session
+	// ticket it can resume later. [SessionEvent.Session] is set.
+	// This event only occurs on client connections.
+	SessionTicketReceived
+
+	// SessionResumed indicates that the handshake is resuming a session.
+	// [SessionEvent.Session] is the resumed session.
+	SessionResumed
+
+	// SessionResumptionRejected indicates that the client offered one or
+	// more sessions, but the handshake is not resuming any of them.
+	// For client connections, [SessionEvent.Session] is the offered session.
+	// For server connections, it is nil.
+	SessionResumptionRejected
+)
+
+// A SessionEvent is a session lifecycle event, reported to
+// [Config.SessionEvent].
+//
+// Resumption events are reported while the handshake is in progress: the
+// handshake may still fail afterwards.
+type SessionEvent struct {
+	Kind SessionEventKind
+
+	// Session is the session the event is about, if any. It must not be
+	// modified.
+	Session *SessionState
+}
+
+func (c *Conn) sessionEvent(kind SessionEventKind, session *SessionState) {
+	if c.config.SessionEvent != nil {
+		c.config.SessionEvent(SessionEvent{Kind: kind, Session: session})
+	}
+}
@@ -0,0 +1,152 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// The primitives of ShadowTLS v3, where a relay passes a real TLS 1.3
+// handshake with a handshake server through, and then takes over the
+// connection if the client authenticated with a password.
+//
+// The client fills its session ID with [ShadowTLSSessionIDGenerator], which
+// the relay checks with [VerifyShadowTLSClientHello], before forwarding the
+// records read with [ReadRawRecord] both ways. The ServerHello gives it the
+// server random, from [ServerHelloRandom], which keys the MACs of the data
+// frames, [NewShadowTLSFrameMAC]. Until the client sends its first data frame,
+// the relay tags the application data records of the handshake server with
+// the "S" frame MAC and masks them with [ShadowTLSMask]. Once the handshake is
+// complete, the client takes over its connection with [Conn.Hijack] and
+// exchanges application data records whose payload starts with the tag of
+// its frame MAC.
+
+// shadowTLSTagSize is the size of the MACs of ShadowTLS v3, truncated
+// HMAC-SHA1.
+const shadowTLSTagSize = 4
+
+// clientHelloSessionIDOffset is the offset of the session ID in a ClientHello
+// handshake message, after its type, length, version, random and the length
+// of the session ID.
+const clientHelloSessionIDOffset = 4 + 2 + 32 + 1
+
+// ShadowTLSSessionIDGenerator returns the Config.SessionIDGenerator of a
+// ShadowTLS v3 client with password. The session ID is 28 random bytes,
+// followed by the MAC of the ClientHello.
+func ShadowTLSSessionIDGenerator(password string) func(clientHello, sessionID []byte) error {
+	return func(clientHello, sessionID []byte) error {
+		if len(clientHello) < clientHelloSessionIDOffset+32 || len(sessionID) != 32 {
+			return errors.New("tls: unexpected ClientHello for ShadowTLS")
+		}
+		msg := bytes.Clone(clientHello)
+		if _, err := io.ReadFull(rand.Reader, msg[clientHelloSessionIDOffset:clientHelloSessionIDOffset+32-shadowTLSTagSize]); err != nil {
+			return err
+		}
+		copy(sessionID, msg[clientHelloSessionIDOffset:])
+		copy(sessionID[32-shadowTLSTagSize:], shadowTLSHelloMAC(password, msg))
+		return nil
+	}
+}
+
+// VerifyShadowTLSClientHello reports whether the record, with its header,
+// holds a ClientHello of a ShadowTLS v3 client with password.
+func VerifyShadowTLSClientHello(record []byte, password string) bool {
+	if len(record) < recordHeaderLen+clientHelloSessionIDOffset+32 ||
+		recordType(record[0]) != recordTypeHandshake || record[recordHeaderLen] != typeClientHello ||
+		record[recordHeaderLen+clientHelloSessionIDOffset-1] != 32 {
+		return false
+	}
+	msg := record[recordHeaderLen:]
+	tag := msg[clientHelloSessionIDOffset+32-shadowTLSTagSize : clientHelloSessionIDOffset+32]
+	return hmac.Equal(shadowTLSHelloMAC(password, msg), tag)
+}
+
+// shadowTLSHelloMAC returns the MAC of the ClientHello message msg, with the
+// tag at the end of its session ID set to zero.
+func shadowTLSHelloMAC(password string, msg []byte) []byte {
+	const tagOffset = clientHelloSessionIDOffset + 32 - shadowTLSTagSize
+	h := hmac.New(sha1.New, []byte(password))
+	h.Write(msg[:tagOffset])
+	h.Write(make([]byte, shadowTLSTagSize))
+	h.Write(msg[tagOffset+shadowTLSTagSize:])
+	return h.Sum(nil)[:shadowTLSTagSize]
+}
+
+// ReadRawRecord reads a record from r without processing it, for relays. It
+// returns the whole record, header included.
+func ReadRawRecord(r io.Reader) ([]byte, error) {
+	record := make([]byte, recordHeaderLen, recordHeaderLen+maxCiphertext)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(record[3:]))
+	if n > maxCiphertext {
+		return nil, errors.New("tls: oversized record received")
+	}
+	record = record[:recordHeaderLen+n]
+	if _, err := io.ReadFull(r, record[recordHeaderLen:]); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ServerHelloRandom returns the random of the ServerHello held by the record,
+// with its header, if it negotiates TLS 1.3 without a HelloRetryRequest.
+func ServerHelloRandom(record []byte) ([]byte, bool) {
+	if len(record) < recordHeaderLen || recordType(record[0]) != recordTypeHandshake {
+		return nil, false
+	}
+	m := new(serverHelloMsg)
+	if !m.unmarshal(record[recordHeaderLen:]) || m.supportedVersion != VersionTLS13 ||
+		bytes.Equal(m.random, helloRetryRequestRandom) {
+		return nil, false
+	}
+	return m.random, true
+}
+
+// NewShadowTLSFrameMAC returns the running MAC of the ShadowTLS v3 data
+// frames of one direction, keyed with password and serverRandom. label is
+// "C" for the frames of the client and "S" for those of the relay.
+func NewShadowTLSFrameMAC(password string, serverRandom []byte, label string) *ShadowTLSFrameMAC {
+	h := hmac.New(sha1.New, []byte(password))
+	h.Write(serverRandom)
+	h.Write([]byte(label))
+	return &ShadowTLSFrameMAC{h}
+}
+
+// A ShadowTLSFrameMAC computes the tags prefixed to the payloads of the data
+// frames, each covering all the frames before it.
+type ShadowTLSFrameMAC struct {
+	h hash.Hash
+}
+
+// Tag returns the tag of the next frame, with payload.
+func (m *ShadowTLSFrameMAC) Tag(payload []byte) []byte {
+	m.h.Write(payload)
+	tag := m.h.Sum(nil)[:shadowTLSTagSize]
+	m.h.Write(tag)
+	return tag
+}
+
+// Verify reports whether tag is the tag of the next frame, with payload. The
+// MAC advances either way, so the relay looking for the first data frame of
+// the client checks each candidate with a new ShadowTLSFrameMAC.
+func (m *ShadowTLSFrameMAC) Verify(tag, payload []byte) bool {
+	return hmac.Equal(m.Tag(payload), tag)
+}
+
+// ShadowTLSMask returns the key the relay XORs the application data records
+// of the handshake server with, repeated over their payload, before the
+// first data frame of the client.
+func ShadowTLSMask(password string, serverRandom []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(password))
+	h.Write(serverRandom)
+	return h.Sum(nil)
+}
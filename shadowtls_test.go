@@ -0,0 +1,126 @@
+package tls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShadowTLS(t *testing.T) {
+	const password = "password"
+	clientConn, relayClient := localPipe(t)
+	relayServer, serverConn := localPipe(t)
+	defer relayServer.Close()
+
+	serverConfig := testConfig.Clone()
+	serverConfig.MinVersion = VersionTLS13
+	go Server(serverConn, serverConfig).Handshake()
+
+	serverRandom := make(chan []byte, 1)
+	go func() {
+		for first := true; ; first = false {
+			record, err := ReadRawRecord(relayServer)
+			if err != nil {
+				return
+			}
+			if first {
+				random, ok := ServerHelloRandom(record)
+				if !ok {
+					t.Error("first record of the server isn't a TLS 1.3 ServerHello")
+				}
+				serverRandom <- random
+			}
+			relayClient.Write(record)
+		}
+	}()
+
+	frame := make(chan []byte, 1)
+	go func() {
+		record, err := ReadRawRecord(relayClient)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !VerifyShadowTLSClientHello(record, password) {
+			t.Error("ClientHello not verified")
+		}
+		if VerifyShadowTLSClientHello(record, "wrong") {
+			t.Error("ClientHello verified with the wrong password")
+		}
+		relayServer.Write(record)
+		random := <-serverRandom
+		for {
+			record, err := ReadRawRecord(relayClient)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(record) > recordHeaderLen+shadowTLSTagSize && recordType(record[0]) == recordTypeApplicationData &&
+				NewShadowTLSFrameMAC(password, random, "C").Verify(record[recordHeaderLen:][:shadowTLSTagSize], record[recordHeaderLen+shadowTLSTagSize:]) {
+				frame <- record[recordHeaderLen+shadowTLSTagSize:]
+				return
+			}
+			relayServer.Write(record)
+		}
+	}()
+
+	clientConfig := testConfig.Clone()
+	clientConfig.SessionIDGenerator = ShadowTLSSessionIDGenerator(password)
+	// The client gets the server random from the first flight of the server.
+	received := &recordingConn{Conn: clientConn}
+	client := Client(received, clientConfig)
+	if _, _, err := client.Hijack(); err == nil {
+		t.Error("Hijack succeeded before the handshake")
+	}
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	conn, buffered, err := client.Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if len(buffered) != 0 {
+		t.Errorf("got %d bytes buffered", len(buffered))
+	}
+
+	serverHello, err := ReadRawRecord(bytes.NewReader(received.flows[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	random, ok := ServerHelloRandom(serverHello)
+	if !ok {
+		t.Fatal("no ServerHello received")
+	}
+	payload := []byte("hijacked")
+	record := []byte{byte(recordTypeApplicationData), 3, 3, 0, byte(shadowTLSTagSize + len(payload))}
+	record = append(record, NewShadowTLSFrameMAC(password, random, "C").Tag(payload)...)
+	record = append(record, payload...)
+	if _, err := conn.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-frame; !bytes.Equal(got, payload) {
+		t.Errorf("relay got frame %q", got)
+	}
+}
+
+func TestHijackKernelTLS(t *testing.T) {
+	c, s := localPipe(t)
+	client := Client(c, testConfig.Clone())
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+	go server.Handshake()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// As if KernelTX had handed the records sent over to the kernel.
+	client.out.Lock()
+	client.out.kernel = true
+	client.out.Unlock()
+	if _, _, err := client.Hijack(); err == nil {
+		t.Error("Hijack succeeded with kernel TLS")
+	}
+	client.out.Lock()
+	client.out.kernel = false
+	client.out.Unlock()
+}
@@ -0,0 +1,122 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"io"
+
+	smcipher "github.com/emmansun/gmsm/cipher"
+	sm2ecdh "github.com/emmansun/gmsm/ecdh"
+	"github.com/emmansun/gmsm/sm4"
+)
+
+// shangMiCipherSuitesTLS13 are the ShangMi cipher suites of RFC 8998, in
+// preference order.
+var shangMiCipherSuitesTLS13 = []uint16{
+	TLS_SM4_GCM_SM3,
+	TLS_SM4_CCM_SM3,
+}
+
+// sm4GCMAble is implemented by the SM4 ciphers of the sm4 package which have
+// an assembly GCM, as the interface crypto/cipher.NewGCM looks for.
+type sm4GCMAble interface {
+	NewGCM(nonceSize, tagSize int) (cipher.AEAD, error)
+}
+
+// newSM4GCM returns SM4-GCM keyed with key. The sm4 package picks the fastest
+// implementation for the CPU: the SM4 instructions of ARMv8.2, or else SM4
+// computed with the AES instructions, along with carry-less multiplication
+// for GHASH on amd64, arm64 and ppc64. It's then used directly, as
+// crypto/cipher.NewGCM only reaches it through an interface the standard
+// library means to remove, and otherwise falls back to a slow generic GCM.
+func newSM4GCM(key []byte) cipher.AEAD {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	var aead cipher.AEAD
+	if g, ok := block.(sm4GCMAble); ok {
+		aead, err = g.NewGCM(aeadNonceLength, 16)
+	} else {
+		aead, err = cipher.NewGCM(block)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func aeadSM4GCMTLS13(key, nonceMask []byte) aead {
+	if len(nonceMask) != aeadNonceLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	aead := newSM4GCM(key)
+
+	ret := &xorNonceAEAD{aead: aead}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
+
+func aeadSM4CCMTLS13(key, nonceMask []byte) aead {
+	if len(nonceMask) != aeadNonceLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := smcipher.NewCCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &xorNonceAEAD{aead: copySealAEAD{aead}}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
+
+// copySealAEAD copies the plaintext before sealing it, for AEADs like the CCM
+// of gmsm that compute a wrong tag when sealing in place, as records are.
+type copySealAEAD struct {
+	cipher.AEAD
+}
+
+func (a copySealAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return a.AEAD.Seal(dst, nonce, slicesClone(plaintext), additionalData)
+}
+
+// sm2KeyExchange is the ECDHE key exchange on the SM2 curve of RFC 8998,
+// Section 3.1, whose key shares are uncompressed points like those of
+// CurveP256.
+type sm2KeyExchange struct{}
+
+func (ke *sm2KeyExchange) keyShares(rand io.Reader) (*keySharePrivateKeys, []keyShare, error) {
+	priv, err := sm2ecdh.P256().GenerateKey(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &keySharePrivateKeys{sm2: priv}, []keyShare{{CurveSM2, priv.PublicKey().Bytes()}}, nil
+}
+
+func (ke *sm2KeyExchange) serverSharedSecret(rand io.Reader, clientKeyShare []byte) ([]byte, keyShare, error) {
+	key, err := sm2ecdh.P256().GenerateKey(rand)
+	if err != nil {
+		return nil, keyShare{}, err
+	}
+	peerKey, err := sm2ecdh.P256().NewPublicKey(clientKeyShare)
+	if err != nil {
+		return nil, keyShare{}, err
+	}
+	sharedKey, err := key.ECDH(peerKey)
+	if err != nil {
+		return nil, keyShare{}, err
+	}
+	return sharedKey, keyShare{CurveSM2, key.PublicKey().Bytes()}, nil
+}
+
+func (ke *sm2KeyExchange) clientSharedSecret(priv *keySharePrivateKeys, serverKeyShare []byte) ([]byte, error) {
+	peerKey, err := sm2ecdh.P256().NewPublicKey(serverKeyShare)
+	if err != nil {
+		return nil, err
+	}
+	return priv.sm2.ECDH(peerKey)
+}
@@ -0,0 +1,105 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm4"
+)
+
+func TestShangMiCipherSuites(t *testing.T) {
+	cert, err := X509KeyPair([]byte(sm2CertificatePEM), []byte(sm2KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name         string
+		suite        uint16
+		clientCurves []CurveID
+		serverCurves []CurveID
+		serverOptIn  bool
+		wantCurve    CurveID
+	}{
+		{name: "GCM", suite: TLS_SM4_GCM_SM3, clientCurves: []CurveID{CurveSM2}, serverOptIn: true, wantCurve: CurveSM2},
+		{name: "CCM", suite: TLS_SM4_CCM_SM3, clientCurves: []CurveID{CurveSM2}, serverOptIn: true, wantCurve: CurveSM2},
+		{name: "HelloRetryRequest", suite: TLS_SM4_GCM_SM3, serverCurves: []CurveID{CurveSM2}, serverOptIn: true, wantCurve: CurveSM2},
+		{name: "X25519", suite: TLS_SM4_GCM_SM3, serverOptIn: true, wantCurve: X25519MLKEM768},
+		{name: "NotEnabled", suite: TLS_SM4_GCM_SM3, clientCurves: []CurveID{CurveSM2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Make the client offer only the ShangMi suites, like GM clients.
+			defer func(suites, suitesNoAES, shangMi []uint16) {
+				defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES, shangMiCipherSuitesTLS13 = suites, suitesNoAES, shangMi
+			}(defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES, shangMiCipherSuitesTLS13)
+			defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = nil, nil
+			shangMiCipherSuitesTLS13 = []uint16{tt.suite}
+
+			clientConfig := testConfig.Clone()
+			clientConfig.MinVersion = VersionTLS13
+			clientConfig.ShangMiCipherSuites = true
+			clientConfig.CurvePreferences = tt.clientCurves
+			serverConfig := testConfig.Clone()
+			serverConfig.Certificates = []Certificate{cert}
+			serverConfig.NameToCertificate = nil
+			serverConfig.ShangMiCipherSuites = tt.serverOptIn
+			serverConfig.CurvePreferences = tt.serverCurves
+			// SM2 signing never completes with the zero Rand of testConfig.
+			clientConfig.Rand, serverConfig.Rand = nil, nil
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if !tt.serverOptIn {
+				if err == nil {
+					t.Fatal("ShangMi cipher suite negotiated by a server not enabling it")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cs.CipherSuite != tt.suite {
+				t.Errorf("got cipher suite %s, want %s", CipherSuiteName(cs.CipherSuite), CipherSuiteName(tt.suite))
+			}
+			if cs.CurveID != tt.wantCurve {
+				t.Errorf("got key exchange %v, want %v", cs.CurveID, tt.wantCurve)
+			}
+			if cs.PeerSignatureScheme != SM2WithSM3 {
+				t.Errorf("got peer signature algorithm %v, want SM2WithSM3", cs.PeerSignatureScheme)
+			}
+		})
+	}
+}
+
+func TestSM4GCM(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hide the methods of the sm4 cipher, for the generic GCM.
+	generic, err := cipher.NewGCM(struct{ cipher.Block }{block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead := newSM4GCM(key)
+
+	nonce := make([]byte, aeadNonceLength)
+	aad := []byte("additional data")
+	for _, n := range []int{0, 1, 15, 16, 17, 255, 1024 + 3} {
+		plaintext := bytes.Repeat([]byte{byte(n)}, n)
+		sealed := aead.Seal(nil, nonce, plaintext, aad)
+		if want := generic.Seal(nil, nonce, plaintext, aad); !bytes.Equal(sealed, want) {
+			t.Fatalf("%d bytes: got %x, want %x", n, sealed, want)
+		}
+		opened, err := aead.Open(nil, nonce, sealed, aad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("%d bytes: got %x, %v opening", n, opened, err)
+		}
+		sealed[0] ^= 1
+		if _, err := aead.Open(nil, nonce, sealed, aad); err == nil {
+			t.Fatalf("%d bytes: tampered record opened", n)
+		}
+		nonce[0]++
+	}
+}
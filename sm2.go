@@ -0,0 +1,53 @@
+package tls
+
+import (
+	"crypto"
+	"crypto/x509"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// sm2SignatureID is the distinguishing identifier of SM2 signatures in
+// TLS 1.3, see RFC 8998, Section 3.2.1.
+var sm2SignatureID = []byte("TLSv1.3+GM+Cipher+Suite")
+
+// sm2SignerOpts makes SM2 private keys sign messages with sm2SignatureID.
+var sm2SignerOpts = sm2.NewSM2SignerOption(true, sm2SignatureID)
+
+// isSM2PublicKey reports whether pub is an SM2 key, which is an ECDSA key on
+// the SM2 curve.
+func isSM2PublicKey(pub crypto.PublicKey) bool {
+	return sm2.IsSM2PublicKey(pub)
+}
+
+// parseSM2Certificate parses a certificate with an SM2 key, which
+// crypto/x509 rejects as using an unsupported curve.
+func parseSM2Certificate(der []byte) (*x509.Certificate, bool) {
+	cert, err := smx509.ParseCertificate(der)
+	if err != nil || !isSM2PublicKey(cert.PublicKey) {
+		return nil, false
+	}
+	return cert.ToX509(), true
+}
+
+// parseSM2PublicKey parses an SM2 SubjectPublicKeyInfo.
+func parseSM2PublicKey(der []byte) (crypto.PublicKey, bool) {
+	pub, err := smx509.ParsePKIXPublicKey(der)
+	if err != nil || !isSM2PublicKey(pub) {
+		return nil, false
+	}
+	return pub, true
+}
+
+// parseSM2PrivateKey parses an SM2 key in PKCS #8 or SEC 1 form.
+func parseSM2PrivateKey(der []byte) (*sm2.PrivateKey, bool) {
+	if key, err := smx509.ParsePKCS8PrivateKey(der); err == nil {
+		key, ok := key.(*sm2.PrivateKey)
+		return key, ok
+	}
+	if key, err := smx509.ParseSM2PrivateKey(der); err == nil {
+		return key, true
+	}
+	return nil, false
+}
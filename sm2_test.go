@@ -0,0 +1,92 @@
+package tls
+
+import (
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+const sm2CertificatePEM = `
+-----BEGIN CERTIFICATE-----
+MIIBxDCCAWqgAwIBAgIUdh5AfAAcyMjLeUhaa5/tp670hKkwCgYIKoEcz1UBg3Uw
+EjEQMA4GA1UECgwHQWNtZSBDbzAgFw0yNjEwMTQwNzU4NTVaGA8yMTI2MDkyMDA3
+NTg1NVowEjEQMA4GA1UECgwHQWNtZSBDbzBZMBMGByqGSM49AgEGCCqBHM9VAYIt
+A0IABNWskGdgPG1xEHPOuYFkEXBOvt+RGyqxytgn45axQD/FZuA42OUCnrAztmIc
+uZNzSz/ib9F/0DqQNL7Fwz+y3QSjgZswgZgwHQYDVR0OBBYEFBFMFYk/O/nxfQY1
+divZNG6vEXu2MB8GA1UdIwQYMBaAFBFMFYk/O/nxfQY1divZNG6vEXu2MBkGA1Ud
+EQQSMBCCDmV4YW1wbGUuZ29sYW5nMA4GA1UdDwEB/wQEAwIHgDAdBgNVHSUEFjAU
+BggrBgEFBQcDAQYIKwYBBQUHAwIwDAYDVR0TAQH/BAIwADAKBggqgRzPVQGDdQNI
+ADBFAiEA47NRLE77LG5FJ7jtzO8R/xL8pXVhVMmYGWhgCLjAfnQCIGYqoArHY0Ms
+gVBvcNqbtOibNhrpuH3XFRe600Haouix
+-----END CERTIFICATE-----`
+
+var sm2KeyPEM = testingKey(`
+-----BEGIN TESTING KEY-----
+MIGHAgEAMBMGByqGSM49AgEGCCqBHM9VAYItBG0wawIBAQQgUYCnydAXPKVUbl/p
+Oll1VANpSxRCO0X5ak6EzhDLiRKhRANCAATVrJBnYDxtcRBzzrmBZBFwTr7fkRsq
+scrYJ+OWsUA/xWbgONjlAp6wM7ZiHLmTc0s/4m/Rf9A6kDS+xcM/st0E
+-----END TESTING KEY-----`)
+
+func TestSM2(t *testing.T) {
+	cert, err := X509KeyPair([]byte(sm2CertificatePEM), []byte(sm2KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cert.PrivateKey.(*sm2.PrivateKey); !ok {
+		t.Fatalf("got private key of type %T", cert.PrivateKey)
+	}
+	withSM2 := append(supportedSignatureAlgorithms(VersionTLS12), SM2WithSM3)
+
+	for _, tt := range []struct {
+		name       string
+		version    uint16
+		clientAuth bool
+		optIn      bool
+		ok         bool
+	}{
+		{name: "Server", version: VersionTLS13, optIn: true, ok: true},
+		{name: "Client", version: VersionTLS13, clientAuth: true, optIn: true, ok: true},
+		{name: "NotOffered", version: VersionTLS13},
+		{name: "TLS12", version: VersionTLS12, optIn: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			// SM2 signing never completes with the zero Rand of testConfig.
+			clientConfig.Rand, serverConfig.Rand = nil, nil
+			if tt.optIn {
+				clientConfig.SignatureSchemes = withSM2
+				serverConfig.SignatureSchemes = withSM2
+			}
+			if tt.clientAuth {
+				serverConfig.ClientAuth = RequireAnyClientCert
+				clientConfig.Certificates = []Certificate{cert}
+			} else {
+				serverConfig.Certificates = []Certificate{cert}
+				serverConfig.NameToCertificate = nil
+			}
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("SM2 certificate used without SM2WithSM3 support")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := cs
+			if tt.clientAuth {
+				state = ss
+			}
+			if state.PeerSignatureScheme != SM2WithSM3 {
+				t.Errorf("peer signature algorithm is %v, want SM2WithSM3", state.PeerSignatureScheme)
+			}
+			if len(state.PeerCertificates) != 1 || !isSM2PublicKey(state.PeerCertificates[0].PublicKey) {
+				t.Errorf("SM2 peer certificate not parsed")
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@
 package tls
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
@@ -21,7 +22,11 @@ import (
 type SessionState struct {
 	// Encoded as a SessionState (in the language of RFC 8446, Section 3).
 	//
-	//   enum { server(1), client(2) } SessionStateType;
+	//   enum {
+	//       server(1), client(2),
+	//       server_early_data(3), client_early_data(4),
+	//       server_encrypt_then_mac(5), client_encrypt_then_mac(6)
+	//   } SessionStateType;
 	//
 	//   opaque Certificate<1..2^24-1>;
 	//
@@ -48,10 +53,19 @@ type SessionState struct {
 	//           case VersionTLS10..VersionTLS12: uint16 curve_id;
 	//           case VersionTLS13: select (SessionState.type) {
 	//               case server: Empty;
+	//               case server_early_data: struct {
+	//                   uint32 age_add;
+	//                   uint32 max_early_data;
+	//               };
 	//               case client: struct {
 	//                   uint64 use_by;
 	//                   uint32 age_add;
 	//               };
+	//               case client_early_data: struct {
+	//                   uint64 use_by;
+	//                   uint32 age_add;
+	//                   uint32 max_early_data;
+	//               };
 	//           };
 	//       };
 	//   } SessionState;
@@ -59,6 +73,12 @@ type SessionState struct {
 	// The format can be extended backwards-compatibly by adding new fields at
 	// the end. Otherwise, a new SessionStateType must be used, as different Go
 	// versions may share the same session ticket encryption key.
+	//
+	// The server_early_data and client_early_data types are only used for the
+	// TLS 1.3 sessions that carry early data parameters, so that the others
+	// can still be parsed by versions that predate them. Likewise, the
+	// server_encrypt_then_mac and client_encrypt_then_mac types mark the
+	// TLS 1.0–1.2 sessions that used encrypt-then-MAC.
 
 	// Extra is ignored by crypto/tls, but is encoded by [SessionState.Bytes]
 	// and parsed by [ParseSessionState].
@@ -74,8 +94,9 @@ type SessionState struct {
 	Extra [][]byte
 
 	// EarlyData indicates whether the ticket can be used for 0-RTT in a QUIC
-	// connection. The application may set this to false if it is true to
-	// decline to offer 0-RTT even if supported.
+	// connection, or in a TCP connection with [Config.EarlyData] set. The
+	// application may set this to false if it is true to decline to offer
+	// 0-RTT even if supported.
 	EarlyData bool
 
 	version     uint16
@@ -94,13 +115,16 @@ type SessionState struct {
 	verifiedChains    [][]*x509.Certificate
 	alpnProtocol      string // only set if EarlyData is true
 
-	// Client-side TLS 1.3-only fields.
-	useBy  uint64 // seconds since UNIX epoch
-	ageAdd uint32
-	ticket []byte
+	// TLS 1.3-only fields. useBy and ticket are client-side, ageAdd and
+	// maxEarlyData are also stored by servers if EarlyData is true.
+	useBy        uint64 // seconds since UNIX epoch
+	ageAdd       uint32
+	ticket       []byte
+	maxEarlyData uint32 // max_early_data_size from the NewSessionTicket
 
 	// TLS 1.0–1.2 only fields.
-	curveID CurveID
+	curveID        CurveID
+	encryptThenMAC bool // see RFC 7366, Section 3.1
 }
 
 // Bytes encodes the session, including any private fields, so that it can be
@@ -112,9 +136,20 @@ type SessionState struct {
 func (s *SessionState) Bytes() ([]byte, error) {
 	var b cryptobyte.Builder
 	b.AddUint16(s.version)
-	if s.isClient {
+	hasEarlyDataFields := s.hasEarlyDataFields()
+	encryptThenMAC := s.version < VersionTLS13 && s.encryptThenMAC
+	switch {
+	case s.isClient && hasEarlyDataFields:
+		b.AddUint8(4) // client_early_data
+	case s.isClient && encryptThenMAC:
+		b.AddUint8(6) // client_encrypt_then_mac
+	case s.isClient:
 		b.AddUint8(2) // client
-	} else {
+	case hasEarlyDataFields:
+		b.AddUint8(3) // server_early_data
+	case encryptThenMAC:
+		b.AddUint8(5) // server_encrypt_then_mac
+	default:
 		b.AddUint8(1) // server
 	}
 	b.AddUint16(s.cipherSuite)
@@ -143,7 +178,7 @@ func (s *SessionState) Bytes() ([]byte, error) {
 		Certificate:                 certificatesToBytesSlice(s.peerCertificates),
 		OCSPStaple:                  s.ocspResponse,
 		SignedCertificateTimestamps: s.scts,
-	})
+	}, nil)
 	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
 		for _, chain := range s.verifiedChains {
 			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
@@ -170,12 +205,31 @@ func (s *SessionState) Bytes() ([]byte, error) {
 			addUint64(&b, s.useBy)
 			b.AddUint32(s.ageAdd)
 		}
+		if hasEarlyDataFields {
+			if !s.isClient {
+				b.AddUint32(s.ageAdd)
+			}
+			b.AddUint32(s.maxEarlyData)
+		}
 	} else {
 		b.AddUint16(uint16(s.curveID))
 	}
 	return b.Bytes()
 }
 
+// hasEarlyDataFields reports whether s is encoded with one of the
+// server_early_data and client_early_data types. Servers only need age_add to
+// check the freshness of the early data they accept.
+func (s *SessionState) hasEarlyDataFields() bool {
+	if s.version < VersionTLS13 {
+		return false
+	}
+	if s.isClient {
+		return s.maxEarlyData != 0
+	}
+	return s.EarlyData && (s.ageAdd != 0 || s.maxEarlyData != 0)
+}
+
 func certificatesToBytesSlice(certs []*x509.Certificate) [][]byte {
 	s := make([][]byte, 0, len(certs))
 	for _, c := range certs {
@@ -200,7 +254,7 @@ func ParseSessionState(data []byte) (*SessionState, error) {
 		!s.ReadUint8(&extMasterSecret) ||
 		!s.ReadUint8(&earlyData) ||
 		len(ss.secret) == 0 ||
-		!unmarshalCertificate(&s, &cert) {
+		!unmarshalCertificate(&s, &cert, nil) {
 		return nil, errors.New("tls: invalid session encoding")
 	}
 	for !extra.Empty() {
@@ -210,11 +264,24 @@ func ParseSessionState(data []byte) (*SessionState, error) {
 		}
 		ss.Extra = append(ss.Extra, e)
 	}
+	var hasEarlyDataFields bool
 	switch typ {
 	case 1:
 		ss.isClient = false
 	case 2:
 		ss.isClient = true
+	case 3:
+		ss.isClient = false
+		hasEarlyDataFields = true
+	case 4:
+		ss.isClient = true
+		hasEarlyDataFields = true
+	case 5:
+		ss.isClient = false
+		ss.encryptThenMAC = true
+	case 6:
+		ss.isClient = true
+		ss.encryptThenMAC = true
 	default:
 		return nil, errors.New("tls: unknown session encoding")
 	}
@@ -283,13 +350,22 @@ func ParseSessionState(data []byte) (*SessionState, error) {
 		ss.alpnProtocol = string(alpn)
 	}
 	if ss.version >= VersionTLS13 {
+		if ss.encryptThenMAC {
+			return nil, errors.New("tls: invalid session encoding")
+		}
 		if ss.isClient {
 			if !s.ReadUint64(&ss.useBy) || !s.ReadUint32(&ss.ageAdd) {
 				return nil, errors.New("tls: invalid session encoding")
 			}
 		}
+		if hasEarlyDataFields {
+			if !ss.isClient && (!ss.EarlyData || !s.ReadUint32(&ss.ageAdd)) ||
+				!s.ReadUint32(&ss.maxEarlyData) {
+				return nil, errors.New("tls: invalid session encoding")
+			}
+		}
 	} else {
-		if !s.ReadUint16((*uint16)(&ss.curveID)) {
+		if hasEarlyDataFields || !s.ReadUint16((*uint16)(&ss.curveID)) {
 			return nil, errors.New("tls: invalid session encoding")
 		}
 	}
@@ -315,10 +391,79 @@ func (c *Conn) sessionState() *SessionState {
 	}
 }
 
+// singleUseTicketLen is the length of the random identities of single-use
+// session tickets.
+const singleUseTicketLen = 32
+
+// singleUseTicketsUnavailable reports whether SingleUseTickets is set without
+// a ServerSessionCache to store the sessions in, so no tickets can be issued.
+func (c *Config) singleUseTicketsUnavailable() bool {
+	return c.SingleUseTickets && c.ServerSessionCache == nil && c.WrapSession == nil
+}
+
+// storeSingleUseSession stores ss in the ServerSessionCache and returns the
+// random ticket identity that refers to it.
+func (c *Config) storeSingleUseSession(ss *SessionState) ([]byte, error) {
+	identity := make([]byte, singleUseTicketLen)
+	if _, err := io.ReadFull(c.rand(), identity); err != nil {
+		return nil, err
+	}
+	c.ServerSessionCache.Put(string(identity), ss)
+	return identity, nil
+}
+
+// takeSingleUseSession removes the session referred to by a single-use ticket
+// identity from the ServerSessionCache and returns it, or returns nil.
+func (c *Config) takeSingleUseSession(identity []byte) *SessionState {
+	if c.ServerSessionCache == nil || len(identity) != singleUseTicketLen {
+		return nil
+	}
+	return c.ServerSessionCache.Take(string(identity))
+}
+
+// TicketNonceScheme selects how servers generate the ticket_nonce of TLS 1.3
+// NewSessionTicket messages, which makes the PSK of each ticket issued on a
+// connection distinct. See RFC 8446, Section 4.6.1.
+type TicketNonceScheme int
+
+const (
+	// TicketNonceCounter numbers the tickets issued on a connection, in the
+	// shortest big-endian encoding, so the first ticket has an empty nonce.
+	TicketNonceCounter TicketNonceScheme = iota
+
+	// TicketNonceRandom uses 16 bytes from Config.Rand for each ticket, for
+	// deployments that require unpredictable nonces.
+	TicketNonceRandom
+)
+
+const ticketNonceRandomLen = 16
+
+// nextTicketNonce returns the ticket_nonce of the next session ticket sent
+// on c.
+func (c *Conn) nextTicketNonce() ([]byte, error) {
+	n := c.sessionTicketsSent
+	c.sessionTicketsSent++
+	if c.config.SessionTicketNonce == TicketNonceRandom {
+		nonce := make([]byte, ticketNonceRandomLen)
+		if _, err := io.ReadFull(c.config.rand(), nonce); err != nil {
+			return nil, err
+		}
+		return nonce, nil
+	}
+	var nonce []byte
+	for ; n > 0; n >>= 8 {
+		nonce = append([]byte{byte(n)}, nonce...)
+	}
+	return nonce, nil
+}
+
 // EncryptTicket encrypts a ticket with the [Config]'s configured (or default)
 // session ticket keys. It can be used as a [Config.WrapSession] implementation.
 func (c *Config) EncryptTicket(cs ConnectionState, ss *SessionState) ([]byte, error) {
-	ticketKeys := c.ticketKeys(nil)
+	ticketKeys, err := c.ticketKeys(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
 	stateBytes, err := ss.Bytes()
 	if err != nil {
 		return nil, err
@@ -330,6 +475,9 @@ func (c *Config) encryptTicket(state []byte, ticketKeys []ticketKey) ([]byte, er
 	if len(ticketKeys) == 0 {
 		return nil, errors.New("tls: internal error: session ticket keys unavailable")
 	}
+	if ticketKeys[0].decryptOnly {
+		return nil, errors.New("tls: no active session ticket key")
+	}
 
 	encrypted := make([]byte, aes.BlockSize+len(state)+sha256.Size)
 	iv := encrypted[:aes.BlockSize]
@@ -359,7 +507,10 @@ func (c *Config) encryptTicket(state []byte, ticketKeys []ticketKey) ([]byte, er
 //
 // If the ticket can't be decrypted or parsed, DecryptTicket returns (nil, nil).
 func (c *Config) DecryptTicket(identity []byte, cs ConnectionState) (*SessionState, error) {
-	ticketKeys := c.ticketKeys(nil)
+	ticketKeys, err := c.ticketKeys(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
 	stateBytes := c.decryptTicket(identity, ticketKeys)
 	if stateBytes == nil {
 		return nil, nil
@@ -0,0 +1,128 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TicketKey is a session ticket key shared by servers that resume each other's
+// sessions, along with the window during which it is in use.
+type TicketKey struct {
+	// ID identifies the key within a [TicketKeyStore]. It is never sent on
+	// the wire, and must not be empty.
+	ID string
+
+	// Key is the secret key material. It must be generated randomly, and is
+	// expanded into encryption and authentication keys the same way as the
+	// keys passed to [Config.SetSessionTicketKeys].
+	Key [32]byte
+
+	// NotBefore and NotAfter bound the window during which the key is
+	// active. Servers encrypt new tickets with the active key that has the
+	// latest NotBefore, and decrypt tickets with any key until its NotAfter,
+	// including keys that are not active yet, so that keys distributed ahead
+	// of their rotation work even across servers with skewed clocks. A zero
+	// value leaves the corresponding side of the window open.
+	//
+	// Tickets encrypted with a key can't be resumed after its NotAfter,
+	// regardless of their lifetime.
+	NotBefore, NotAfter time.Time
+}
+
+// TicketKeyStore is a source of session ticket keys, usually replicated
+// across a fleet of servers, for example through etcd or Redis. If
+// [Config.TicketKeyStore] is set, its keys take precedence over the ones set
+// with [Config.SetSessionTicketKeys] and over automatic key rotation.
+//
+// Implementations must be safe for concurrent use. See [TicketKeyRing] for
+// one that is updated by pushing keys to it.
+type TicketKeyStore interface {
+	// TicketKeys returns the current session ticket keys. It is called at
+	// the start of every server handshake, so it should return quickly, for
+	// example from a local copy of the shared store kept up to date in the
+	// background. If it returns an error, the handshake fails.
+	TicketKeys(ctx context.Context) ([]TicketKey, error)
+}
+
+// TicketKeyRing is a [TicketKeyStore] that holds the keys most recently
+// pushed to it with SetTicketKeys. The zero value is an empty key ring, which
+// neither issues nor accepts session tickets.
+type TicketKeyRing struct {
+	mu   sync.RWMutex
+	keys []TicketKey
+}
+
+// SetTicketKeys replaces the keys in the ring. It is typically called when
+// the shared store changes. The keys must have unique, non-empty IDs, and
+// NotAfter, if set, must be after NotBefore.
+func (r *TicketKeyRing) SetTicketKeys(keys []TicketKey) error {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return errors.New("tls: empty session ticket key ID")
+		}
+		if seen[k.ID] {
+			return errors.New("tls: duplicate session ticket key ID " + k.ID)
+		}
+		seen[k.ID] = true
+		if !k.NotAfter.IsZero() && !k.NotAfter.After(k.NotBefore) {
+			return errors.New("tls: session ticket key " + k.ID + " expires before it is valid")
+		}
+	}
+
+	r.mu.Lock()
+	r.keys = slicesClone(keys)
+	r.mu.Unlock()
+	return nil
+}
+
+// TicketKeys returns the keys last set with SetTicketKeys.
+func (r *TicketKeyRing) TicketKeys(ctx context.Context) ([]TicketKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys, nil
+}
+
+// storeTicketKeys returns the keys of c.TicketKeyStore that can decrypt
+// tickets now, with the one to encrypt new tickets first. If none of them
+// is active, the first key is marked decryptOnly.
+func (c *Config) storeTicketKeys(ctx context.Context) ([]ticketKey, error) {
+	keys, err := c.TicketKeyStore.TicketKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.time()
+	var ret []ticketKey
+	active := -1
+	var activeSince time.Time
+	for _, k := range keys {
+		if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+			continue
+		}
+		if !k.NotBefore.After(now) && (active < 0 || k.NotBefore.After(activeSince)) {
+			active, activeSince = len(ret), k.NotBefore
+		}
+		ret = append(ret, c.ticketKeyFromBytes(k.Key))
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	if active < 0 {
+		ret[0].decryptOnly = true
+	} else {
+		ret[0], ret[active] = ret[active], ret[0]
+	}
+	return ret, nil
+}
+
+// ticketKeysUnavailable reports whether new session tickets would have to be
+// encrypted with the connection's ticket keys, but none of them is active.
+func (c *Conn) ticketKeysUnavailable() bool {
+	if c.config.WrapSession != nil || c.config.SingleUseTickets {
+		return false
+	}
+	return len(c.ticketKeys) == 0 || c.ticketKeys[0].decryptOnly
+}
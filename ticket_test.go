@@ -4,5 +4,61 @@
 
 package tls
 
+import (
+	"encoding/hex"
+	"testing"
+)
+
 var _ = &Config{WrapSession: (&Config{}).EncryptTicket}
 var _ = &Config{UnwrapSession: (&Config{}).DecryptTicket}
+
+func TestParseSessionStateWithoutEarlyDataFields(t *testing.T) {
+	// A TLS 1.3 server session with early data, as encoded before the
+	// server_early_data type was added.
+	b, _ := hex.DecodeString("03040113010000000000000001067365637265740000000001000000000000026833")
+	ss, err := ParseSessionState(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.isClient || !ss.EarlyData || ss.alpnProtocol != "h3" || ss.ageAdd != 0 || ss.maxEarlyData != 0 {
+		t.Errorf("parsed %+v", ss)
+	}
+
+	for _, isClient := range []bool{false, true} {
+		s := &SessionState{
+			version:          VersionTLS13,
+			isClient:         isClient,
+			cipherSuite:      TLS_AES_128_GCM_SHA256,
+			secret:           []byte("secret"),
+			EarlyData:        true,
+			alpnProtocol:     "h3",
+			peerCertificates: sessionTestCerts[:1],
+		}
+		if isClient {
+			s.useBy, s.ageAdd = 1, 2
+		}
+		b, err := s.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ, want := b[2], map[bool]uint8{false: 1, true: 2}[isClient]; typ != want {
+			t.Errorf("client %v: encoded type %d, want %d", isClient, typ, want)
+		}
+		if _, err := ParseSessionState(b); err != nil {
+			t.Errorf("client %v: %v", isClient, err)
+		}
+
+		s.ageAdd, s.maxEarlyData = 2, 3
+		b, err = s.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ, want := b[2], map[bool]uint8{false: 3, true: 4}[isClient]; typ != want {
+			t.Errorf("client %v: encoded type %d, want %d", isClient, typ, want)
+		}
+		ss, err := ParseSessionState(b)
+		if err != nil || ss.ageAdd != 2 || ss.maxEarlyData != 3 {
+			t.Errorf("client %v: parsed %+v, %v", isClient, ss, err)
+		}
+	}
+}
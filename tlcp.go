@@ -0,0 +1,227 @@
+package tls
+
+import (
+	"context"
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/x509"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+	"github.com/emmansun/gmsm/sm4"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// cipherSuitesTLCP are the TLCP cipher suites of GB/T 38636-2020, in
+// preference order. Only the ECC key exchange, where the client encrypts the
+// pre-master secret to the encryption certificate of the server, is
+// implemented.
+var cipherSuitesTLCP = []*cipherSuite{
+	{ECC_SM4_GCM_SM3, 16, 0, 4, tlcpECCKA, suiteTLCP, nil, nil, aeadSM4GCM},
+	{ECC_SM4_CBC_SM3, 16, 32, 16, tlcpECCKA, suiteTLCP, cipherSM4, macSM3, nil},
+}
+
+// tlcpCipherSuites returns the TLCP cipher suites enabled by c, in
+// preference order.
+func (c *Config) tlcpCipherSuites() []uint16 {
+	var ids []uint16
+	for _, suite := range cipherSuitesTLCP {
+		if c.CipherSuites == nil || slicesContains(c.CipherSuites, suite.id) {
+			ids = append(ids, suite.id)
+		}
+	}
+	return ids
+}
+
+// makeTLCPClientHello returns the ClientHello of a TLCP client. It only offers
+// the TLCP cipher suites, and none of the TLS extensions that don't apply.
+func (c *Conn) makeTLCPClientHello() (*clientHelloMsg, error) {
+	config := c.config
+	hello := &clientHelloMsg{
+		vers:                         VersionTLCP,
+		random:                       make([]byte, 32),
+		cipherSuites:                 config.tlcpCipherSuites(),
+		compressionMethods:           []uint8{compressionNone},
+		serverName:                   hostnameInSNI(config.sentServerName()),
+		secureRenegotiationSupported: true,
+		alpnProtocols:                config.NextProtos,
+	}
+	if len(hello.cipherSuites) == 0 {
+		return nil, errors.New("tls: CipherSuites includes no TLCP cipher suite")
+	}
+	if c.handshakes > 0 {
+		hello.secureRenegotiation = c.clientFinished[:]
+	}
+	if _, err := io.ReadFull(config.rand(), hello.random); err != nil {
+		return nil, errors.New("tls: short read from Rand: " + err.Error())
+	}
+	return hello, nil
+}
+
+// tlcpCertificates returns the certificate list of a TLCP server: the signing
+// certificate, followed by the encryption certificate and the chain of the
+// signing certificate. See GB/T 38636-2020, Section 6.4.5.3.
+func tlcpCertificates(signCert, encCert *Certificate) [][]byte {
+	certs := [][]byte{signCert.Certificate[0], encCert.Certificate[0]}
+	return append(certs, signCert.Certificate[1:]...)
+}
+
+func cipherSM4(key, iv []byte, isRead bool) any {
+	block, _ := sm4.NewCipher(key)
+	if isRead {
+		return cipher.NewCBCDecrypter(block, iv)
+	}
+	return cipher.NewCBCEncrypter(block, iv)
+}
+
+// macSM3 returns an HMAC-SM3 MAC.
+func macSM3(key []byte) hash.Hash {
+	return newRecordMAC(hmac.New(sm3.New, key), sm3.New, key)
+}
+
+func aeadSM4GCM(key, noncePrefix []byte) aead {
+	if len(noncePrefix) != noncePrefixLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	aead := newSM4GCM(key)
+
+	ret := &prefixNonceAEAD{aead: aead}
+	copy(ret.nonce[:], noncePrefix)
+	return ret
+}
+
+func tlcpECCKA(version uint16) keyAgreement {
+	return &tlcpECCKeyAgreement{}
+}
+
+// tlcpECCKeyAgreement implements the ECC key exchange of TLCP, where the
+// client encrypts the pre-master secret with SM2 to the encryption
+// certificate of the server, which binds it to the handshake by signing it
+// with its signing certificate. See GB/T 38636-2020, Section 6.4.5.4.
+type tlcpECCKeyAgreement struct {
+	// encCert is the encryption certificate of the server, set by clients
+	// before processServerKeyExchange.
+	encCert *x509.Certificate
+	// verified is whether processServerKeyExchange checked the signature
+	// over encCert.
+	verified bool
+	// clientVersion is the version of the ClientHello, set by servers in
+	// generateServerKeyExchange, which the client puts at the start of the
+	// pre-master secret.
+	clientVersion uint16
+}
+
+// tlcpSignedParams returns the message signed in the ServerKeyExchange of
+// the ECC key exchange.
+func tlcpSignedParams(clientRandom, serverRandom, encCert []byte) []byte {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddBytes(clientRandom)
+	b.AddBytes(serverRandom)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(encCert)
+	})
+	return b.BytesOrPanic()
+}
+
+func (ka *tlcpECCKeyAgreement) generateServerKeyExchange(ctx context.Context, config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	encCert := config.TLCPEncryptionCertificate
+	if encCert == nil || len(encCert.Certificate) == 0 {
+		return nil, errors.New("tls: TLCP requires a TLCPEncryptionCertificate")
+	}
+	priv, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok || !isSM2PublicKey(priv.Public()) {
+		return nil, errors.New("tls: TLCP requires an SM2 signing certificate")
+	}
+
+	signed := tlcpSignedParams(clientHello.random, hello.random, encCert.Certificate[0])
+	sig, err := signContext(ctx, priv, config.rand(), signed, sm2.DefaultSM2SignerOpts)
+	if err != nil {
+		return nil, errors.New("tls: failed to sign ECC parameters: " + err.Error())
+	}
+
+	ka.clientVersion = clientHello.vers
+
+	skx := new(serverKeyExchangeMsg)
+	skx.key = make([]byte, 2+len(sig))
+	skx.key[0] = byte(len(sig) >> 8)
+	skx.key[1] = byte(len(sig))
+	copy(skx.key[2:], sig)
+	return skx, nil
+}
+
+func (ka *tlcpECCKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
+	if len(ckx.ciphertext) < 2 {
+		return nil, errClientKeyExchange
+	}
+	ciphertextLen := int(ckx.ciphertext[0])<<8 | int(ckx.ciphertext[1])
+	if ciphertextLen != len(ckx.ciphertext)-2 {
+		return nil, errClientKeyExchange
+	}
+
+	priv, ok := config.TLCPEncryptionCertificate.PrivateKey.(crypto.Decrypter)
+	if !ok {
+		return nil, errors.New("tls: TLCP encryption certificate private key does not implement crypto.Decrypter")
+	}
+	// Unlike RSA PKCS #1 v1.5, SM2 ciphertexts carry a hash of the
+	// plaintext, so decryption errors don't leak anything about it, and the
+	// version in the pre-master secret can be checked.
+	preMasterSecret, err := priv.Decrypt(config.rand(), ckx.ciphertext[2:], nil)
+	if err != nil || len(preMasterSecret) != masterSecretLength ||
+		uint16(preMasterSecret[0])<<8|uint16(preMasterSecret[1]) != ka.clientVersion {
+		return nil, errClientKeyExchange
+	}
+	return preMasterSecret, nil
+}
+
+func (ka *tlcpECCKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	if len(skx.key) < 2 {
+		return errServerKeyExchange
+	}
+	sigLen := int(skx.key[0])<<8 | int(skx.key[1])
+	if sigLen != len(skx.key)-2 {
+		return errServerKeyExchange
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !isSM2PublicKey(pub) {
+		return errors.New("tls: TLCP server signing certificate is not an SM2 certificate")
+	}
+
+	signed := tlcpSignedParams(clientHello.random, serverHello.random, ka.encCert.Raw)
+	if !sm2.VerifyASN1WithSM2(pub, nil, signed, skx.key[2:]) {
+		return errors.New("tls: invalid signature by the server certificate: SM2 verification failure")
+	}
+	ka.verified = true
+	return nil
+}
+
+func (ka *tlcpECCKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
+	if !ka.verified {
+		return nil, nil, errors.New("tls: missing ServerKeyExchange message")
+	}
+	pub, ok := ka.encCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !isSM2PublicKey(pub) {
+		return nil, nil, errors.New("tls: TLCP server encryption certificate is not an SM2 certificate")
+	}
+
+	preMasterSecret := make([]byte, masterSecretLength)
+	preMasterSecret[0] = byte(clientHello.vers >> 8)
+	preMasterSecret[1] = byte(clientHello.vers)
+	if _, err := io.ReadFull(config.rand(), preMasterSecret[2:]); err != nil {
+		return nil, nil, err
+	}
+	encrypted, err := sm2.EncryptASN1(config.rand(), pub, preMasterSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	ckx := new(clientKeyExchangeMsg)
+	ckx.ciphertext = make([]byte, len(encrypted)+2)
+	ckx.ciphertext[0] = byte(len(encrypted) >> 8)
+	ckx.ciphertext[1] = byte(len(encrypted))
+	copy(ckx.ciphertext[2:], encrypted)
+	return preMasterSecret, ckx, nil
+}
@@ -0,0 +1,99 @@
+package tls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTLCP(t *testing.T) {
+	cert, err := X509KeyPair([]byte(sm2CertificatePEM), []byte(sm2KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		suite       uint16
+		clientTLCP  bool
+		serverTLCP  bool
+		wantVersion uint16
+	}{
+		{name: "GCM", suite: ECC_SM4_GCM_SM3, clientTLCP: true, serverTLCP: true, wantVersion: VersionTLCP},
+		{name: "CBC", suite: ECC_SM4_CBC_SM3, clientTLCP: true, serverTLCP: true, wantVersion: VersionTLCP},
+		{name: "TLSClient", suite: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, serverTLCP: true, wantVersion: VersionTLS12},
+		{name: "NotEnabled", suite: ECC_SM4_GCM_SM3, clientTLCP: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.TLCP = tt.clientTLCP
+			clientConfig.MaxVersion = VersionTLS12
+			clientConfig.CipherSuites = []uint16{tt.suite}
+			serverConfig := testConfig.Clone()
+			serverConfig.CipherSuites = []uint16{tt.suite}
+			serverConfig.TLCP = tt.serverTLCP
+			serverConfig.TLCPEncryptionCertificate = &cert
+			if tt.clientTLCP {
+				serverConfig.Certificates = []Certificate{cert}
+				serverConfig.NameToCertificate = nil
+			}
+			// SM2 signing never completes with the zero Rand of testConfig.
+			clientConfig.Rand, serverConfig.Rand = nil, nil
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.wantVersion == 0 {
+				if err == nil {
+					t.Fatal("TLCP negotiated by a server not enabling it")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cs.Version != tt.wantVersion {
+				t.Errorf("got version %s, want %s", VersionName(cs.Version), VersionName(tt.wantVersion))
+			}
+			if cs.CipherSuite != tt.suite {
+				t.Errorf("got cipher suite %s, want %s", CipherSuiteName(cs.CipherSuite), CipherSuiteName(tt.suite))
+			}
+			if tt.clientTLCP && len(cs.PeerCertificates) != 2 {
+				t.Errorf("got %d peer certificates, want the signing and encryption certificates", len(cs.PeerCertificates))
+			}
+		})
+	}
+}
+
+func TestTLCPClientKeyExchange(t *testing.T) {
+	cert, err := X509KeyPair([]byte(sm2CertificatePEM), []byte(sm2KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := parseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{TLCPEncryptionCertificate: &cert}
+	client := &tlcpECCKeyAgreement{encCert: leaf, verified: true}
+	preMasterSecret, ckx, err := client.generateClientKeyExchange(config, &clientHelloMsg{vers: VersionTLCP}, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &tlcpECCKeyAgreement{clientVersion: VersionTLCP}
+	got, err := server.processClientKeyExchange(config, nil, ckx, VersionTLCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, preMasterSecret) {
+		t.Errorf("decrypted pre-master secret %x, want %x", got, preMasterSecret)
+	}
+
+	server = &tlcpECCKeyAgreement{clientVersion: VersionTLS12}
+	if _, err := server.processClientKeyExchange(config, nil, ckx, VersionTLCP); err != errClientKeyExchange {
+		t.Errorf("pre-master secret with the wrong version: got error %v", err)
+	}
+	ckx.ciphertext[len(ckx.ciphertext)-1] ^= 0xff
+	server = &tlcpECCKeyAgreement{clientVersion: VersionTLCP}
+	if _, err := server.processClientKeyExchange(config, nil, ckx, VersionTLCP); err != errClientKeyExchange {
+		t.Errorf("corrupted pre-master secret: got error %v", err)
+	}
+}
@@ -7,21 +7,30 @@
 //
 // # FIPS 140-3 mode
 //
-// When the program is in [FIPS 140-3 mode], this package behaves as if only
-// SP 800-140C and SP 800-140D approved protocol versions, cipher suites,
-// signature algorithms, certificate public key types and sizes, and key
-// exchange and derivation algorithms were implemented. Others are silently
-// ignored and not negotiated, or rejected. This set may depend on the
-// algorithms supported by the FIPS 140-3 Go Cryptographic Module selected with
-// GOFIPS140, and may change across Go versions.
+// When [Config.FIPSMode] is set, this package behaves as if only SP 800-140C
+// and SP 800-140D approved protocol versions, cipher suites, signature
+// algorithms, certificate public key types and sizes, and key exchange and
+// derivation algorithms were implemented. Others are silently ignored and not
+// negotiated, or rejected. This is the set of the [FIPS 140-3 mode] of the
+// standard library: TLS 1.2 and 1.3, the AES-GCM cipher suites, the P-256,
+// P-384 and P-521 curves, the hybrid ML-KEM key exchanges, ECDSA over those
+// curves, RSA keys of at least 2048 bits with SHA-256 or better, and Ed25519.
+// TLS 1.2 additionally requires the extended master secret, the only approved
+// derivation of its master secret.
+//
+// This only restricts what is negotiated. The key exchanges, key derivations,
+// signatures and record protection still run on the implementations of this
+// package and of its dependencies, not on a validated module, so connections
+// made with FIPSMode are not FIPS 140-3 compliant.
 //
 // [FIPS 140-3 mode]: https://go.dev/doc/security/fips140
 package tls
 
 // BUG(agl): The crypto/tls package only implements some countermeasures
-// against Lucky13 attacks on CBC-mode encryption, and only on SHA1
-// variants. See http://www.isg.rhul.ac.uk/tls/TLStiming.pdf and
-// https://www.imperialviolet.org/2013/02/04/luckythirteen.html.
+// against Lucky13 attacks on CBC-mode encryption when the MAC hash doesn't
+// expose its state through encoding.BinaryMarshaler, as the built-in
+// SHA-1, SHA-256 and SM3 do. See http://www.isg.rhul.ac.uk/tls/TLStiming.pdf
+// and https://www.imperialviolet.org/2013/02/04/luckythirteen.html.
 
 import (
 	"context"
@@ -36,6 +45,9 @@ import (
 	"net"
 	"os"
 	"strings"
+
+	"github.com/cloudflare/circl/sign/ed448"
+	"github.com/emmansun/gmsm/sm2"
 )
 
 // Server returns a new TLS server side connection
@@ -48,13 +60,16 @@ func Server(conn net.Conn, config *Config) *Conn {
 		config: config,
 	}
 	c.handshakeFn = c.serverHandshake
+	if conn != nil {
+		c.qlog = newQlogger(c)
+	}
 	return c
 }
 
 // Client returns a new TLS client side connection
 // using conn as the underlying transport.
-// The config cannot be nil: users must set either ServerName or
-// InsecureSkipVerify in the config.
+// The config cannot be nil: users must set either ServerName,
+// InsecureSkipVerify, or ExternalPSKs in the config.
 func Client(conn net.Conn, config *Config) *Conn {
 	c := &Conn{
 		conn:     conn,
@@ -62,6 +77,9 @@ func Client(conn net.Conn, config *Config) *Conn {
 		isClient: true,
 	}
 	c.handshakeFn = c.clientHandshake
+	if conn != nil {
+		c.qlog = newQlogger(c)
+	}
 	return c
 }
 
@@ -99,8 +117,8 @@ func NewListener(inner net.Listener, config *Config) net.Listener {
 func Listen(network, laddr string, config *Config) (net.Listener, error) {
 	// If this condition changes, consider updating http.Server.ServeTLS too.
 	if config == nil || len(config.Certificates) == 0 &&
-		config.GetCertificate == nil && config.GetConfigForClient == nil {
-		return nil, errors.New("tls: neither Certificates, GetCertificate, nor GetConfigForClient set in Config")
+		config.GetCertificate == nil && config.GetCertificateForHello == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("tls: neither Certificates, GetCertificate, GetCertificateForHello, nor GetConfigForClient set in Config")
 	}
 	l, err := net.Listen(network, laddr)
 	if err != nil {
@@ -196,6 +214,11 @@ type Dialer struct {
 	// configuration; see the documentation of Config for the
 	// defaults.
 	Config *Config
+
+	// PinnedPeerSPKIHashes, if not nil, replaces Config.PinnedPeerSPKIHashes
+	// for the connections of this Dialer, so that a Config can be shared by
+	// Dialers connecting to servers with different pins.
+	PinnedPeerSPKIHashes [][]byte
 }
 
 // Dial connects to the given network address and initiates a TLS
@@ -226,7 +249,15 @@ func (d *Dialer) netDialer() *net.Dialer {
 //
 // The returned [Conn], if any, will always be of type *[Conn].
 func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	c, err := dial(ctx, d.netDialer(), network, addr, d.Config)
+	config := d.Config
+	if d.PinnedPeerSPKIHashes != nil {
+		if config == nil {
+			config = defaultConfig()
+		}
+		config = config.Clone()
+		config.PinnedPeerSPKIHashes = d.PinnedPeerSPKIHashes
+	}
+	c, err := dial(ctx, d.netDialer(), network, addr, config)
 	if err != nil {
 		// Don't return c (a typed nil) in an interface.
 		return nil, err
@@ -309,7 +340,7 @@ func X509KeyPair(certPEMBlock, keyPEMBlock []byte) (Certificate, error) {
 
 	// We don't need to parse the public key for TLS, but we so do anyway
 	// to check that it looks sane and matches the private key.
-	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	x509Cert, err := parseCertificate(cert.Certificate[0])
 	if err != nil {
 		return fail(err)
 	}
@@ -332,6 +363,9 @@ func X509KeyPair(certPEMBlock, keyPEMBlock []byte) (Certificate, error) {
 		}
 	case *ecdsa.PublicKey:
 		priv, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+		if sm2Priv, isSM2 := cert.PrivateKey.(*sm2.PrivateKey); isSM2 {
+			priv, ok = &sm2Priv.PrivateKey, true
+		}
 		if !ok {
 			return fail(errors.New("tls: private key type does not match public key type"))
 		}
@@ -346,6 +380,14 @@ func X509KeyPair(certPEMBlock, keyPEMBlock []byte) (Certificate, error) {
 		if !priv.Public().(ed25519.PublicKey).Equal(pub) {
 			return fail(errors.New("tls: private key does not match public key"))
 		}
+	case ed448.PublicKey:
+		priv, ok := cert.PrivateKey.(ed448.PrivateKey)
+		if !ok {
+			return fail(errors.New("tls: private key type does not match public key type"))
+		}
+		if !priv.Public().(ed448.PublicKey).Equal(pub) {
+			return fail(errors.New("tls: private key does not match public key"))
+		}
 	default:
 		return fail(errors.New("tls: unknown public key algorithm"))
 	}
@@ -371,6 +413,47 @@ func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
 	if key, err := x509.ParseECPrivateKey(der); err == nil {
 		return key, nil
 	}
+	if key, err := parseEd448PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, ok := parseSM2PrivateKey(der); ok {
+		return key, nil
+	}
 
 	return nil, errors.New("tls: failed to parse private key")
 }
+
+// parseCertificate is like x509.ParseCertificate, but also supports
+// certificates with Ed448 keys, whose PublicKey crypto/x509 leaves nil, and
+// with SM2 keys, which it rejects.
+func parseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		if cert, ok := parseSM2Certificate(der); ok {
+			return cert, nil
+		}
+		return nil, err
+	}
+	if cert.PublicKey == nil {
+		if pub, ok := parseEd448PublicKey(cert.RawSubjectPublicKeyInfo); ok {
+			cert.PublicKey = pub
+		}
+	}
+	return cert, nil
+}
+
+// parsePKIXPublicKey is like x509.ParsePKIXPublicKey, but also accepts Ed448
+// and SM2 keys.
+func parsePKIXPublicKey(der []byte) (crypto.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		if pub, ok := parseEd448PublicKey(der); ok {
+			return pub, nil
+		}
+		if pub, ok := parseSM2PublicKey(der); ok {
+			return pub, nil
+		}
+		return nil, err
+	}
+	return pub, nil
+}
@@ -27,17 +27,23 @@ func tls12pHash[H hash.Hash](hash_ func() H, result, secret, seed []byte) {
 	h.Write(seed)
 	a := h.Sum(nil)
 
+	var b []byte
 	for len(result) > 0 {
 		h.Reset()
 		h.Write(a)
 		h.Write(seed)
-		b := h.Sum(nil)
-		n := copy(result, b)
-		result = result[n:]
+		if len(result) >= h.Size() {
+			// Write whole blocks directly into result.
+			h.Sum(result[:0])
+			result = result[h.Size():]
+		} else {
+			b = h.Sum(b[:0])
+			result = result[copy(result, b):]
+		}
 
 		h.Reset()
 		h.Write(a)
-		a = h.Sum(nil)
+		a = h.Sum(a[:0])
 	}
 }
 
@@ -7,6 +7,7 @@
 package tls
 
 import (
+	"crypto/hmac"
 	"encoding/binary"
 	"hash"
 
@@ -18,8 +19,30 @@ import (
 // its own.
 
 // tls13ExpandLabel implements HKDF-Expand-Label from RFC 8446, Section 7.1.
-func tls13ExpandLabel[H hash.Hash](hash func() H, secret []byte, label string, context []byte, length int) []byte {
-	if len("tls13 ")+len(label) > 255 || len(context) > 255 {
+func tls13ExpandLabel[H hash.Hash](h func() H, secret []byte, label string, context []byte, length int) []byte {
+	k := newTLS13KDF(func() hash.Hash { return h() }, secret)
+	return k.expandLabel(make([]byte, 0, length), label, context, length)
+}
+
+// tls13KDF computes HKDF-Expand-Label derivations from a single secret. It
+// keeps the HMAC keyed with the secret and its scratch buffers around, so
+// that each further derivation doesn't allocate beyond its output.
+type tls13KDF struct {
+	hash      func() hash.Hash
+	mac       hash.Hash
+	info      []byte // HkdfLabel, followed by the HKDF-Expand counter byte
+	block     []byte // the last HMAC output
+	context   []byte // the last transcript hash
+	emptyHash []byte // the hash of the empty string, computed on first use
+}
+
+func newTLS13KDF(h func() hash.Hash, secret []byte) *tls13KDF {
+	return &tls13KDF{hash: h, mac: hmac.New(h, secret)}
+}
+
+// expandLabel appends HKDF-Expand-Label(secret, label, context, length) to dst.
+func (k *tls13KDF) expandLabel(dst []byte, label string, context []byte, length int) []byte {
+	if len("tls13 ")+len(label) > 255 || len(context) > 255 || length > 255*k.mac.Size() {
 		// It should be impossible for this to panic: labels are fixed strings,
 		// and context is either a fixed-length computed hash, or parsed from a
 		// field which has the same length limitation.
@@ -30,18 +53,49 @@ func tls13ExpandLabel[H hash.Hash](hash func() H, secret []byte, label string, c
 		// confusing to users.
 		panic("tls13: label or context too long")
 	}
-	hkdfLabel := make([]byte, 0, 2+1+len("tls13 ")+len(label)+1+len(context))
-	hkdfLabel = binary.BigEndian.AppendUint16(hkdfLabel, uint16(length))
-	hkdfLabel = append(hkdfLabel, byte(len("tls13 ")+len(label)))
-	hkdfLabel = append(hkdfLabel, "tls13 "...)
-	hkdfLabel = append(hkdfLabel, label...)
-	hkdfLabel = append(hkdfLabel, byte(len(context)))
-	hkdfLabel = append(hkdfLabel, context...)
-	b, err := hkdf.Expand(hash, secret, string(hkdfLabel), length)
-	if err != nil {
-		panic(err)
+	info := k.info[:0]
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len("tls13 ")+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+	info = append(info, 0)
+	k.info = info
+
+	// HKDF-Expand from RFC 5869, Section 2.3.
+	var prev []byte
+	for counter := 1; length > 0; counter++ {
+		info[len(info)-1] = byte(counter)
+		k.mac.Reset()
+		k.mac.Write(prev)
+		k.mac.Write(info)
+		k.block = k.mac.Sum(k.block[:0])
+		n := len(k.block)
+		if n > length {
+			n = length
+		}
+		dst = append(dst, k.block[:n]...)
+		length -= n
+		prev = k.block
 	}
-	return b
+	return dst
+}
+
+// deriveSecret implements Derive-Secret from RFC 8446, Section 7.1, with a
+// nil transcript standing for the empty one.
+func (k *tls13KDF) deriveSecret(label string, transcript hash.Hash) []byte {
+	var context []byte
+	if transcript == nil {
+		if k.emptyHash == nil {
+			k.emptyHash = k.hash().Sum(nil)
+		}
+		context = k.emptyHash
+	} else {
+		k.context = transcript.Sum(k.context[:0])
+		context = k.context
+	}
+	return k.expandLabel(make([]byte, 0, len(context)), label, context, len(context))
 }
 
 func tls13extract[H hash.Hash](hash func() H, newSecret, currentSecret []byte) []byte {
@@ -55,15 +109,9 @@ func tls13extract[H hash.Hash](hash func() H, newSecret, currentSecret []byte) [
 	return b
 }
 
-func tls13deriveSecret[H hash.Hash](hash func() H, secret []byte, label string, transcript hash.Hash) []byte {
-	if transcript == nil {
-		transcript = hash()
-	}
-	return tls13ExpandLabel(hash, secret, label, transcript.Sum(nil), transcript.Size())
-}
-
 const (
 	resumptionBinderLabel         = "res binder"
+	importedBinderLabel           = "imp binder"
 	clientEarlyTrafficLabel       = "c e traffic"
 	clientHandshakeTrafficLabel   = "c hs traffic"
 	serverHandshakeTrafficLabel   = "s hs traffic"
@@ -77,79 +125,95 @@ const (
 type tls13EarlySecret struct {
 	secret []byte
 	hash   func() hash.Hash
+	kdf    *tls13KDF
 }
 
 func tls13NewEarlySecret[H hash.Hash](h func() H, psk []byte) *tls13EarlySecret {
+	hashFunc := func() hash.Hash { return h() }
+	secret := tls13extract(h, psk, nil)
 	return &tls13EarlySecret{
-		secret: tls13extract(h, psk, nil),
-		hash:   func() hash.Hash { return h() },
+		secret: secret,
+		hash:   hashFunc,
+		kdf:    newTLS13KDF(hashFunc, secret),
 	}
 }
 
 func (s *tls13EarlySecret) ResumptionBinderKey() []byte {
-	return tls13deriveSecret(s.hash, s.secret, resumptionBinderLabel, nil)
+	return s.kdf.deriveSecret(resumptionBinderLabel, nil)
+}
+
+// ImportedBinderKey derives the binder_key of a PSK imported as specified in
+// RFC 9258, Section 5.1.
+func (s *tls13EarlySecret) ImportedBinderKey() []byte {
+	return s.kdf.deriveSecret(importedBinderLabel, nil)
 }
 
 // ClientEarlyTrafficSecret derives the client_early_traffic_secret from the
 // early secret and the transcript up to the ClientHello.
 func (s *tls13EarlySecret) ClientEarlyTrafficSecret(transcript hash.Hash) []byte {
-	return tls13deriveSecret(s.hash, s.secret, clientEarlyTrafficLabel, transcript)
+	return s.kdf.deriveSecret(clientEarlyTrafficLabel, transcript)
 }
 
 type tls13HandshakeSecret struct {
 	secret []byte
 	hash   func() hash.Hash
+	kdf    *tls13KDF
 }
 
 func (s *tls13EarlySecret) HandshakeSecret(sharedSecret []byte) *tls13HandshakeSecret {
-	derived := tls13deriveSecret(s.hash, s.secret, "derived", nil)
+	derived := s.kdf.deriveSecret("derived", nil)
+	secret := tls13extract(s.hash, sharedSecret, derived)
 	return &tls13HandshakeSecret{
-		secret: tls13extract(s.hash, sharedSecret, derived),
+		secret: secret,
 		hash:   s.hash,
+		kdf:    newTLS13KDF(s.hash, secret),
 	}
 }
 
 // ClientHandshakeTrafficSecret derives the client_handshake_traffic_secret from
 // the handshake secret and the transcript up to the ServerHello.
 func (s *tls13HandshakeSecret) ClientHandshakeTrafficSecret(transcript hash.Hash) []byte {
-	return tls13deriveSecret(s.hash, s.secret, clientHandshakeTrafficLabel, transcript)
+	return s.kdf.deriveSecret(clientHandshakeTrafficLabel, transcript)
 }
 
 // ServerHandshakeTrafficSecret derives the server_handshake_traffic_secret from
 // the handshake secret and the transcript up to the ServerHello.
 func (s *tls13HandshakeSecret) ServerHandshakeTrafficSecret(transcript hash.Hash) []byte {
-	return tls13deriveSecret(s.hash, s.secret, serverHandshakeTrafficLabel, transcript)
+	return s.kdf.deriveSecret(serverHandshakeTrafficLabel, transcript)
 }
 
 type tls13MasterSecret struct {
 	secret []byte
 	hash   func() hash.Hash
+	kdf    *tls13KDF
 }
 
 func (s *tls13HandshakeSecret) MasterSecret() *tls13MasterSecret {
-	derived := tls13deriveSecret(s.hash, s.secret, "derived", nil)
+	derived := s.kdf.deriveSecret("derived", nil)
+	secret := tls13extract(s.hash, nil, derived)
 	return &tls13MasterSecret{
-		secret: tls13extract(s.hash, nil, derived),
+		secret: secret,
 		hash:   s.hash,
+		kdf:    newTLS13KDF(s.hash, secret),
 	}
 }
 
 // ClientApplicationTrafficSecret derives the client_application_traffic_secret_0
 // from the master secret and the transcript up to the server Finished.
 func (s *tls13MasterSecret) ClientApplicationTrafficSecret(transcript hash.Hash) []byte {
-	return tls13deriveSecret(s.hash, s.secret, clientApplicationTrafficLabel, transcript)
+	return s.kdf.deriveSecret(clientApplicationTrafficLabel, transcript)
 }
 
 // ServerApplicationTrafficSecret derives the server_application_traffic_secret_0
 // from the master secret and the transcript up to the server Finished.
 func (s *tls13MasterSecret) ServerApplicationTrafficSecret(transcript hash.Hash) []byte {
-	return tls13deriveSecret(s.hash, s.secret, serverApplicationTrafficLabel, transcript)
+	return s.kdf.deriveSecret(serverApplicationTrafficLabel, transcript)
 }
 
 // ResumptionMasterSecret derives the resumption_master_secret from the master secret
 // and the transcript up to the client Finished.
 func (s *tls13MasterSecret) ResumptionMasterSecret(transcript hash.Hash) []byte {
-	return tls13deriveSecret(s.hash, s.secret, resumptionLabel, transcript)
+	return s.kdf.deriveSecret(resumptionLabel, transcript)
 }
 
 type tls13ExporterMasterSecret struct {
@@ -160,8 +224,9 @@ type tls13ExporterMasterSecret struct {
 // ExporterMasterSecret derives the exporter_master_secret from the master secret
 // and the transcript up to the server Finished.
 func (s *tls13MasterSecret) ExporterMasterSecret(transcript hash.Hash) *tls13ExporterMasterSecret {
+	secret := s.kdf.deriveSecret(exporterLabel, transcript)
 	return &tls13ExporterMasterSecret{
-		secret: tls13deriveSecret(s.hash, s.secret, exporterLabel, transcript),
+		secret: secret,
 		hash:   s.hash,
 	}
 }
@@ -169,17 +234,19 @@ func (s *tls13MasterSecret) ExporterMasterSecret(transcript hash.Hash) *tls13Exp
 // EarlyExporterMasterSecret derives the exporter_master_secret from the early secret
 // and the transcript up to the ClientHello.
 func (s *tls13EarlySecret) EarlyExporterMasterSecret(transcript hash.Hash) *tls13ExporterMasterSecret {
+	secret := s.kdf.deriveSecret(earlyExporterLabel, transcript)
 	return &tls13ExporterMasterSecret{
-		secret: tls13deriveSecret(s.hash, s.secret, earlyExporterLabel, transcript),
+		secret: secret,
 		hash:   s.hash,
 	}
 }
 
+// Exporter doesn't keep a [tls13KDF] around, as it may be called concurrently.
 func (s *tls13ExporterMasterSecret) Exporter(label string, context []byte, length int) []byte {
-	secret := tls13deriveSecret(s.hash, s.secret, label, nil)
+	kdf := newTLS13KDF(s.hash, newTLS13KDF(s.hash, s.secret).deriveSecret(label, nil))
 	h := s.hash()
 	h.Write(context)
-	return tls13ExpandLabel(s.hash, secret, "exporter", h.Sum(nil), length)
+	return kdf.expandLabel(make([]byte, 0, length), "exporter", h.Sum(nil), length)
 }
 
 func tls13TestingOnlyExporterSecret(s *tls13ExporterMasterSecret) []byte {
@@ -750,7 +750,7 @@ func TestWarningAlertFlood(t *testing.T) {
 }
 
 func TestCloneFuncFields(t *testing.T) {
-	const expectedCount = 10
+	const expectedCount = 25
 	called := 0
 
 	c1 := Config{
@@ -794,6 +794,62 @@ func TestCloneFuncFields(t *testing.T) {
 			called |= 1 << 9
 			return nil, nil
 		},
+		GetExternalPSK: func(identity, context []byte) (*ExternalPSK, error) {
+			called |= 1 << 10
+			return nil, nil
+		},
+		GetClientPSK: func(hint []byte) (*ExternalPSK, error) {
+			called |= 1 << 11
+			return nil, nil
+		},
+		ApproveResumption: func(*SessionState, *ClientHelloInfo) bool {
+			called |= 1 << 12
+			return false
+		},
+		SessionEvent: func(SessionEvent) {
+			called |= 1 << 13
+		},
+		VerifyRawPublicKey: func(rawPublicKey []byte, publicKey crypto.PublicKey) error {
+			called |= 1 << 14
+			return nil
+		},
+		VerifyCertificateChains: func(rawCerts [][]byte, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+			called |= 1 << 15
+			return nil, nil
+		},
+		OnPinFailure: func(*PinError) {
+			called |= 1 << 16
+		},
+		GetCertificateForHello: func(*ClientHello) (*Certificate, error) {
+			called |= 1 << 17
+			return nil, nil
+		},
+		GetCipherSuitePreference: func(*ClientHelloInfo) ([]uint16, error) {
+			called |= 1 << 18
+			return nil, nil
+		},
+		SessionIDGenerator: func(clientHello, sessionID []byte) error {
+			called |= 1 << 19
+			return nil
+		},
+		Fallback: func(ctx context.Context, conn net.Conn, received []byte) error {
+			called |= 1 << 20
+			return nil
+		},
+		HandshakeTransform: func(msg []byte) ([]byte, error) {
+			called |= 1 << 21
+			return msg, nil
+		},
+		RecordObserver: func(RecordInfo) {
+			called |= 1 << 22
+		},
+		AlertObserver: func(AlertInfo) {
+			called |= 1 << 23
+		},
+		QlogWriter: func(*Conn) io.WriteCloser {
+			called |= 1 << 24
+			return nil
+		},
 	}
 
 	c2 := c1.Clone()
@@ -808,6 +864,21 @@ func TestCloneFuncFields(t *testing.T) {
 	c2.WrapSession(ConnectionState{}, nil)
 	c2.EncryptedClientHelloRejectionVerify(ConnectionState{})
 	c2.GetEncryptedClientHelloKeys(nil)
+	c2.GetExternalPSK(nil, nil)
+	c2.GetClientPSK(nil)
+	c2.ApproveResumption(nil, nil)
+	c2.SessionEvent(SessionEvent{})
+	c2.VerifyRawPublicKey(nil, nil)
+	c2.VerifyCertificateChains(nil, x509.VerifyOptions{})
+	c2.OnPinFailure(nil)
+	c2.GetCertificateForHello(nil)
+	c2.GetCipherSuitePreference(nil)
+	c2.SessionIDGenerator(nil, nil)
+	c2.Fallback(context.Background(), nil, nil)
+	c2.HandshakeTransform(nil)
+	c2.RecordObserver(RecordInfo{})
+	c2.AlertObserver(AlertInfo{})
+	c2.QlogWriter(nil)
 
 	if called != (1<<expectedCount)-1 {
 		t.Fatalf("expected %d calls but saw calls %b", expectedCount, called)
@@ -826,7 +897,7 @@ func TestCloneNonFuncFields(t *testing.T) {
 		switch fn := typ.Field(i).Name; fn {
 		case "Rand":
 			f.Set(reflect.ValueOf(io.Reader(os.Stdin)))
-		case "Time", "GetCertificate", "GetConfigForClient", "VerifyPeerCertificate", "VerifyConnection", "GetClientCertificate", "WrapSession", "UnwrapSession", "EncryptedClientHelloRejectionVerify", "GetEncryptedClientHelloKeys":
+		case "Time", "GetCertificate", "GetConfigForClient", "VerifyPeerCertificate", "VerifyConnection", "GetClientCertificate", "WrapSession", "UnwrapSession", "EncryptedClientHelloRejectionVerify", "GetEncryptedClientHelloKeys", "GetExternalPSK", "GetClientPSK", "ApproveResumption", "SessionEvent", "VerifyRawPublicKey", "VerifyCertificateChains", "OnPinFailure", "GetCertificateForHello", "GetCipherSuitePreference", "SessionIDGenerator", "Fallback", "HandshakeTransform", "RecordObserver", "AlertObserver", "QlogWriter":
 			// DeepEqual can't compare functions. If you add a
 			// function field to this list, you must also change
 			// TestCloneFuncFields to ensure that the func field is
@@ -841,33 +912,109 @@ func TestCloneNonFuncFields(t *testing.T) {
 			f.Set(reflect.ValueOf(x509.NewCertPool()))
 		case "ClientSessionCache":
 			f.Set(reflect.ValueOf(NewLRUClientSessionCache(10)))
+		case "ExternalPSKs":
+			f.Set(reflect.ValueOf([]ExternalPSK{{Identity: []byte("psk"), Key: []byte("key")}}))
+		case "ExternalPSKModes":
+			f.Set(reflect.ValueOf([]PSKMode{PSKModeKE}))
+		case "ServerSessionCache":
+			f.Set(reflect.ValueOf(NewLRUServerSessionCache(10)))
+		case "SessionTicketLifetime":
+			f.Set(reflect.ValueOf(time.Hour))
+		case "SessionTicketNonce":
+			f.Set(reflect.ValueOf(TicketNonceRandom))
+		case "SessionTicketCount":
+			f.Set(reflect.ValueOf(3))
+		case "TicketKeyStore":
+			f.Set(reflect.ValueOf(&TicketKeyRing{}))
+		case "AEADEngine":
+			f.Set(reflect.ValueOf(AEADEngine(&testAEADEngine{})))
+		case "MetricsRecorder":
+			f.Set(reflect.ValueOf(&metricsLog{}))
+		case "TrafficMorpher":
+			f.Set(reflect.ValueOf(LengthDistributionMorpher([]int{100}, []int{1})))
+		case "PaddingPolicy":
+			f.Set(reflect.ValueOf(FixedPadding(256)))
+		case "HandshakeLimiter":
+			f.Set(reflect.ValueOf(&HandshakeLimiter{MaxHandshakes: 1}))
+		case "CTLogs":
+			f.Set(reflect.ValueOf([]CTLog{{Description: "test log"}}))
+		case "RevocationChecker":
+			f.Set(reflect.ValueOf(RevocationChecker(&CRLChecker{})))
+		case "RevocationMode":
+			f.Set(reflect.ValueOf(RevocationHardFail))
+		case "OCSPStapler":
+			f.Set(reflect.ValueOf(&OCSPStapler{}))
+		case "RecordPacing":
+			f.Set(reflect.ValueOf(&RecordPacing{}))
+		case "ClientHelloFragmentation":
+			f.Set(reflect.ValueOf(&ClientHelloFragmentation{}))
+		case "CovertAuth":
+			f.Set(reflect.ValueOf(&CovertAuth{}))
+		case "HandshakeScript":
+			f.Set(reflect.ValueOf(&HandshakeScript{}))
+		case "DecoyMirror":
+			f.Set(reflect.ValueOf(&DecoyMirror{}))
+		case "Verifiers":
+			f.Set(reflect.ValueOf([]Verifier{VerifierFunc(nil)}))
+		case "TLCPEncryptionCertificate":
+			f.Set(reflect.ValueOf(&Certificate{}))
+		case "AIAFetcher":
+			f.Set(reflect.ValueOf(&AIAFetcher{}))
+		case "ClientHelloReplayFilter":
+			f.Set(reflect.ValueOf(NewClientHelloReplayFilter(time.Second)))
+		case "EarlyDataAntiReplay":
+			f.Set(reflect.ValueOf(NewEarlyDataAntiReplay(time.Second)))
+		case "MaxEarlyData":
+			f.Set(reflect.ValueOf(uint32(1024)))
+		case "KeyLogger":
+			f.Set(reflect.ValueOf(KeyLoggerFunc(nil)))
 		case "KeyLogWriter":
 			f.Set(reflect.ValueOf(io.Writer(os.Stdout)))
 		case "NextProtos":
 			f.Set(reflect.ValueOf([]string{"a", "b"}))
-		case "ServerName":
+		case "ServerName", "SendServerName":
 			f.Set(reflect.ValueOf("b"))
 		case "ClientAuth":
 			f.Set(reflect.ValueOf(VerifyClientCertIfGiven))
-		case "InsecureSkipVerify", "SessionTicketsDisabled", "DynamicRecordSizingDisabled", "PreferServerCipherSuites":
+		case "InsecureSkipVerify", "SessionTicketsDisabled", "DynamicRecordSizingDisabled", "PreferServerCipherSuites", "EarlyData", "SingleUseTickets", "TicketAgeObfuscationDisabled", "ResumptionTicketsDisabled", "KernelTX", "KernelRX", "FalseStart", "EncryptThenMAC", "AcceptDelegatedCredentials", "RequireCT", "RankCertificates", "PostHandshakeAuth", "SendCertificateAuthorities", "RequirePSS", "PinReportOnly", "ShangMiCipherSuites", "TLCP", "FIPSMode", "SplitClientHelloAtServerName", "OmitServerName", "RecordObserverPlaintext", "RetainHandshakeMessages":
 			f.Set(reflect.ValueOf(true))
+		case "DynamicRecordSizingThreshold":
+			f.Set(reflect.ValueOf(4096))
+		case "MinRSAKeySize", "MinECStrength", "MinDHGroupSize", "RecordSizeLimit", "MaxCBCRecords":
+			f.Set(reflect.ValueOf(2048))
+		case "SecurityLevel":
+			f.Set(reflect.ValueOf(3))
+		case "DynamicRecordSizingIdleTimeout", "WriteCoalescingWindow":
+			f.Set(reflect.ValueOf(time.Second))
 		case "MinVersion", "MaxVersion":
 			f.Set(reflect.ValueOf(uint16(VersionTLS12)))
 		case "SessionTicketKey":
 			f.Set(reflect.ValueOf([32]byte{}))
-		case "CipherSuites":
+		case "CipherSuites", "InsecureCipherSuites":
 			f.Set(reflect.ValueOf([]uint16{1, 2}))
 		case "CurvePreferences":
 			f.Set(reflect.ValueOf([]CurveID{CurveP256}))
+		case "CertificateSignatureSchemes", "SignatureSchemes":
+			f.Set(reflect.ValueOf([]SignatureScheme{Ed25519}))
+		case "CertificateCompressors":
+			f.Set(reflect.ValueOf([]CertificateCompressor{ZlibCertificateCompressor{}}))
+		case "ServerCertificateTypes", "ClientCertificateTypes":
+			f.Set(reflect.ValueOf([]CertificateType{CertificateTypeRawPublicKey}))
+		case "AESGCMPreference":
+			f.Set(reflect.ValueOf(AESGCMPreferenceNever))
 		case "Renegotiation":
 			f.Set(reflect.ValueOf(RenegotiateOnceAsClient))
-		case "EncryptedClientHelloConfigList":
+		case "TLSARecords":
+			f.Set(reflect.ValueOf([]TLSARecord{{Usage: TLSAUsageDANEEE, Data: []byte{'x'}}}))
+		case "PinnedPeerSPKIHashes":
+			f.Set(reflect.ValueOf([][]byte{{'x'}}))
+		case "EncryptedClientHelloConfigList", "PSKIdentityHint":
 			f.Set(reflect.ValueOf([]byte{'x'}))
 		case "EncryptedClientHelloKeys":
 			f.Set(reflect.ValueOf([]EncryptedClientHelloKey{
 				{Config: []byte{1}, PrivateKey: []byte{1}},
 			}))
-		case "mutex", "autoSessionTicketKeys", "sessionTicketKeys":
+		case "mutex", "autoSessionTicketKeys", "sessionTicketKeys", "ticketKeysSnapshot":
 			continue // these are unexported fields that are handled separately
 		default:
 			t.Errorf("all fields must be accounted for, but saw unknown field %q", fn)
@@ -1184,6 +1331,31 @@ func TestConnectionState(t *testing.T) {
 				t.Errorf("got nil TLSUnique")
 			}
 		}
+
+		if isClient && version >= VersionTLS12 && cs.PeerSignatureScheme == 0 {
+			t.Errorf("got zero PeerSignatureScheme")
+		}
+		if cs.ExtendedMasterSecret != (version != VersionTLS13) {
+			t.Errorf("got ExtendedMasterSecret %v", cs.ExtendedMasterSecret)
+		}
+		if cs.SecureRenegotiation != (version != VersionTLS13) {
+			t.Errorf("got SecureRenegotiation %v", cs.SecureRenegotiation)
+		}
+		if cs.RecordSizeLimit != maxPlaintext {
+			t.Errorf("got RecordSizeLimit %d, expected %d", cs.RecordSizeLimit, maxPlaintext)
+		}
+		if cs.Resumption != ResumptionNone {
+			t.Errorf("got Resumption %d, expected ResumptionNone", cs.Resumption)
+		}
+		if isClient && cs.PeerALPNProtocols != nil {
+			t.Errorf("got PeerALPNProtocols %q, expected nil", cs.PeerALPNProtocols)
+		} else if !isClient && !reflect.DeepEqual(cs.PeerALPNProtocols, []string{alpnProtocol}) {
+			t.Errorf("got PeerALPNProtocols %q, expected %q", cs.PeerALPNProtocols, alpnProtocol)
+		}
+		// The ALPN extension is in the EncryptedExtensions in TLS 1.3.
+		if wantALPN := !isClient || version != VersionTLS13; slicesContains(cs.PeerHelloExtensions, extensionALPN) != wantALPN {
+			t.Errorf("got PeerHelloExtensions %v, expected ALPN: %v", cs.PeerHelloExtensions, wantALPN)
+		}
 	}
 
 	compareConnectionStates := func(t *testing.T, cs1, cs2 ConnectionState) {
@@ -1272,6 +1444,13 @@ func TestConnectionState(t *testing.T) {
 				if !cs1.DidResume || !ss1.DidResume {
 					t.Errorf("DidResume is false")
 				}
+				want := ResumptionTicket
+				if v == VersionTLS13 {
+					want = ResumptionPSK
+				}
+				if cs1.Resumption != want || ss1.Resumption != want {
+					t.Errorf("got Resumption %d and %d, expected %d", cs1.Resumption, ss1.Resumption, want)
+				}
 
 				t.Run("Client", func(t *testing.T) { compareConnectionStates(t, cs, cs1) })
 				t.Run("Server", func(t *testing.T) { compareConnectionStates(t, ss, ss1) })
@@ -1280,6 +1459,58 @@ func TestConnectionState(t *testing.T) {
 	}
 }
 
+func TestKeyUpdateCounts(t *testing.T) {
+	c, s := localPipe(t)
+	client := Client(c, testConfig)
+	server := Server(s, testConfig)
+	defer client.Close()
+	defer server.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			errChan <- err
+			return
+		}
+		_, err := server.Write(buf)
+		errChan <- err
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients don't initiate key updates, so send one by hand.
+	kuMsg, err := (&keyUpdateMsg{updateRequested: true}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	suite := cipherSuiteTLS13ByID(client.cipherSuite)
+	client.out.Lock()
+	if _, err := client.writeRecordLocked(recordTypeHandshake, kuMsg); err != nil {
+		t.Fatal(err)
+	}
+	client.setWriteTrafficSecret(suite, QUICEncryptionLevelInitial, suite.nextTrafficSecret(client.out.trafficSecret))
+	client.out.Unlock()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	if ss := server.ConnectionState(); ss.KeyUpdatesReceived != 1 || ss.KeyUpdatesSent != 1 {
+		t.Errorf("server received %d key updates and sent %d, want 1 and 1", ss.KeyUpdatesReceived, ss.KeyUpdatesSent)
+	}
+	if cs := client.ConnectionState(); cs.KeyUpdatesReceived != 1 || cs.KeyUpdatesSent != 0 {
+		t.Errorf("client received %d key updates and sent %d, want 1 and 0", cs.KeyUpdatesReceived, cs.KeyUpdatesSent)
+	}
+}
+
 // Issue 28744: Ensure that we don't modify memory
 // that Config doesn't own such as Certificates.
 func TestBuildNameToCertificate_doesntModifyCertificates(t *testing.T) {
@@ -1351,6 +1582,16 @@ func TestClientHelloInfo_SupportsCertificate(t *testing.T) {
 			SignatureSchemes:  []SignatureScheme{PSSWithSHA256, ECDSAWithP256AndSHA256},
 			SupportedVersions: []uint16{VersionTLS13},
 		}, "signature algorithms"},
+		{rsaCert, &ClientHelloInfo{
+			SignatureSchemes:            []SignatureScheme{PSSWithSHA256},
+			CertificateSignatureSchemes: []SignatureScheme{PSSWithSHA256, PKCS1WithSHA256},
+			SupportedVersions:           []uint16{VersionTLS13},
+		}, ""},
+		{rsaCert, &ClientHelloInfo{
+			SignatureSchemes:            []SignatureScheme{PSSWithSHA256},
+			CertificateSignatureSchemes: []SignatureScheme{PSSWithSHA256},
+			SupportedVersions:           []uint16{VersionTLS13},
+		}, "signed with unsupported algorithm"},
 
 		{rsaCert, &ClientHelloInfo{
 			CipherSuites:      []uint16{TLS_RSA_WITH_AES_128_GCM_SHA256},
@@ -1478,6 +1719,244 @@ func TestClientHelloInfo_SupportsCertificate(t *testing.T) {
 	}
 }
 
+func TestRankCertificates(t *testing.T) {
+	issue := func(key crypto.Signer, name string) Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     []string{name},
+			NotBefore:    time.Unix(0, 0),
+			NotAfter:     time.Unix(1<<32, 0),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	}
+	config := &Config{
+		RankCertificates: true,
+		Certificates: []Certificate{
+			issue(testRSA2048PrivateKey, "example.com"),
+			issue(testP256PrivateKey, "*.example.com"),
+			issue(testECDSAPrivateKey, "example.com"),
+			issue(testP256PrivateKey, "example.com"),
+			issue(testEd25519PrivateKey, "example.com"),
+			issue(testRSA2048PrivateKey, "*.example.com"),
+		},
+	}
+
+	all := []SignatureScheme{Ed25519, ECDSAWithP256AndSHA256, ECDSAWithP521AndSHA512, PSSWithSHA256}
+	for _, tt := range []struct {
+		serverName string
+		schemes    []SignatureScheme
+		first      bool
+		want       int
+	}{
+		{"example.com", all, false, 4},
+		{"EXAMPLE.com", all, false, 4},
+		{"example.com", all[1:], false, 3},
+		{"example.com", all[2:], false, 2},
+		{"example.com", all[3:], false, 0},
+		{"foo.example.com", all, false, 1},
+		{"foo.example.com", all[3:], false, 5},
+		{"", all, false, 4},
+		{"example.com", all, true, 0},
+	} {
+		config := config.Clone()
+		config.RankCertificates = !tt.first
+		chi := &ClientHelloInfo{
+			ServerName:        tt.serverName,
+			SignatureSchemes:  tt.schemes,
+			SupportedCurves:   []CurveID{X25519, CurveP256},
+			CipherSuites:      []uint16{TLS_AES_128_GCM_SHA256},
+			SupportedVersions: []uint16{VersionTLS13},
+			config:            config,
+		}
+		cert, err := config.getCertificate(chi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slicesIndexFunc(config.Certificates, func(c Certificate) bool {
+			return c.Leaf == cert.Leaf
+		}); got != tt.want {
+			t.Errorf("%q with %v: got certificate %d, want %d", tt.serverName, tt.schemes, got, tt.want)
+		}
+	}
+}
+
+func TestCertificateAuthoritiesExtension(t *testing.T) {
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+
+	for _, tt := range []struct {
+		name    string
+		version uint16
+		send    bool
+		want    []byte
+	}{
+		{"Sent", VersionTLS13, true, testRSACertificate},
+		{"NotSent", VersionTLS13, false, testECDSACertificate},
+		{"TLS12", VersionTLS12, true, testECDSACertificate},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptableCAs [][]byte
+			serverConfig := testConfig.Clone()
+			serverConfig.Certificates = []Certificate{
+				{Certificate: [][]byte{testECDSACertificate}, PrivateKey: testECDSAPrivateKey},
+				{Certificate: [][]byte{testRSACertificate, testRSACertificateIssuer}, PrivateKey: testRSAPrivateKey},
+			}
+			serverConfig.NameToCertificate = nil
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				acceptableCAs = chi.AcceptableCAs
+				return nil, nil
+			}
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.RootCAs = roots
+			clientConfig.SendCertificateAuthorities = tt.send
+
+			_, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cs.PeerCertificates[0].Raw, tt.want) {
+				t.Errorf("server sent the wrong certificate, for %v", cs.PeerCertificates[0].Subject)
+			}
+			if wantCAs := tt.send && tt.version == VersionTLS13; wantCAs != (len(acceptableCAs) == 1) ||
+				wantCAs && !bytes.Equal(acceptableCAs[0], issuer.RawSubject) {
+				t.Errorf("got acceptable CAs %x", acceptableCAs)
+			}
+		})
+	}
+}
+
+func TestCertificateSignatureSchemes(t *testing.T) {
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+
+	for _, tt := range []struct {
+		name    string
+		schemes []SignatureScheme
+		ok      bool
+	}{
+		{"Default", nil, true},
+		{"Allowed", []SignatureScheme{PKCS1WithSHA256}, true},
+		// The root is signed with PKCS1WithSHA256 too, but isn't checked.
+		{"Disallowed", []SignatureScheme{PSSWithSHA256, ECDSAWithP256AndSHA256}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []SignatureScheme
+			serverConfig := testConfig.Clone()
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				sent = chi.CertificateSignatureSchemes
+				return nil, nil
+			}
+			clientConfig := testConfig.Clone()
+			clientConfig.InsecureSkipVerify = false
+			clientConfig.ServerName = "example.golang"
+			clientConfig.Time = testTime
+			clientConfig.RootCAs = roots
+			clientConfig.CertificateSignatureSchemes = tt.schemes
+
+			_, _, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "CertificateSignatureSchemes")) {
+				t.Fatalf("got error %v, want a rejected signature algorithm", err)
+			}
+			want := tt.schemes
+			if want == nil {
+				want = supportedSignatureAlgorithmsCert()
+			}
+			if !reflect.DeepEqual(sent, want) {
+				t.Errorf("signature_algorithms_cert is %v, want %v", sent, want)
+			}
+		})
+	}
+}
+
+func TestRequirePSS(t *testing.T) {
+	rsaCert := Certificate{Certificate: [][]byte{testRSACertificate}, PrivateKey: testRSAPrivateKey}
+	pkcs1Cert := rsaCert
+	pkcs1Cert.SupportedSignatureAlgorithms = []SignatureScheme{PKCS1WithSHA256}
+
+	for _, tt := range []struct {
+		name          string
+		version       uint16
+		clientPSS     bool
+		serverPSS     bool
+		clientSchemes []SignatureScheme
+		serverCert    Certificate
+		clientCert    *Certificate
+		ok            bool
+	}{
+		{name: "TLS12", version: VersionTLS12, clientPSS: true, serverPSS: true, serverCert: rsaCert, ok: true},
+		{name: "ClientCert", version: VersionTLS12, serverPSS: true, serverCert: rsaCert, clientCert: &rsaCert, ok: true},
+		{name: "PKCS1Client", version: VersionTLS12, serverPSS: true, clientSchemes: []SignatureScheme{PKCS1WithSHA256}, serverCert: rsaCert},
+		{name: "PKCS1Server", version: VersionTLS12, clientPSS: true, serverCert: pkcs1Cert},
+		{name: "PKCS1ClientCert", version: VersionTLS12, serverPSS: true, serverCert: rsaCert, clientCert: &pkcs1Cert},
+		{name: "TLS11", version: VersionTLS11, clientPSS: true, serverCert: rsaCert},
+		{name: "TLS11ECDSA", version: VersionTLS11, clientPSS: true, serverPSS: true, serverCert: Certificate{Certificate: [][]byte{testP256Certificate}, PrivateKey: testP256PrivateKey}, ok: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []SignatureScheme
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = tt.version
+			serverConfig.RequirePSS = tt.serverPSS
+			serverConfig.Certificates = []Certificate{tt.serverCert}
+			serverConfig.NameToCertificate = nil
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				sent = chi.SignatureSchemes
+				return nil, nil
+			}
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = tt.version
+			clientConfig.RequirePSS = tt.clientPSS
+			clientConfig.SignatureSchemes = tt.clientSchemes
+			clientConfig.Certificates = nil
+			if tt.clientCert != nil {
+				serverConfig.ClientAuth = RequireAnyClientCert
+				clientConfig.Certificates = []Certificate{*tt.clientCert}
+			}
+
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("handshake succeeded with a PKCS #1 v1.5 signature")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range sent {
+				if sigType, _, _ := typeAndHashFromSignatureScheme(s); sigType == signaturePKCS1v15 && tt.clientPSS {
+					t.Errorf("client advertised %v", s)
+				}
+			}
+			for _, state := range []ConnectionState{cs, ss} {
+				sigType, _, _ := typeAndHashFromSignatureScheme(state.PeerSignatureScheme)
+				if sigType == signaturePKCS1v15 {
+					t.Errorf("peer signed with %v", state.PeerSignatureScheme)
+				}
+			}
+		})
+	}
+}
+
 func TestCipherSuites(t *testing.T) {
 	var lastID uint16
 	for _, c := range CipherSuites() {
@@ -1535,7 +2014,7 @@ func TestCipherSuites(t *testing.T) {
 			if slicesContains(defaultCipherSuites(false), c.id) {
 				t.Errorf("%#04x: insecure suite in default list", c.id)
 			}
-		} else {
+		} else if !ecdhePSKCiphers[c.id] {
 			if !slicesContains(defaultCipherSuites(false), c.id) {
 				t.Errorf("%#04x: secure suite not in default list", c.id)
 			}
@@ -1675,6 +2154,12 @@ func TestCipherSuites(t *testing.T) {
 			} else if aSuite.flags&suiteECSign == 0 && bSuite.flags&suiteECSign != 0 {
 				return +1
 			}
+			// RSA < PSK
+			if aSuite.flags&suitePSK == 0 && bSuite.flags&suitePSK != 0 {
+				return -1
+			} else if aSuite.flags&suitePSK != 0 && bSuite.flags&suitePSK == 0 {
+				return +1
+			}
 			t.Fatalf("two ciphersuites are equal by all criteria: %v and %v", aName, bName)
 			panic("unreachable")
 		}
@@ -1684,6 +2169,44 @@ func TestCipherSuites(t *testing.T) {
 	}
 }
 
+func TestInsecureCipherSuitesOptIn(t *testing.T) {
+	suite := TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	clientConfig.CipherSuites = []uint16{suite}
+	serverConfig := testConfig.Clone()
+	serverConfig.CipherSuites = nil
+
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+		t.Fatal("3DES negotiated without being enabled")
+	}
+
+	serverConfig.InsecureCipherSuites = []uint16{suite, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.CipherSuite != suite || !ss.InsecureCipherSuite || !cs.InsecureCipherSuite {
+		t.Errorf("got cipher suite %s, insecure %v and %v", CipherSuiteName(ss.CipherSuite), ss.InsecureCipherSuite, cs.InsecureCipherSuite)
+	}
+
+	// Insecure cipher suites come after the default ones, and secure ones
+	// can't be enabled through InsecureCipherSuites.
+	got := serverConfig.cipherSuites(true)
+	want := append(defaultCipherSuites(true), suite)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got cipher suites %v, want %v", got, want)
+	}
+
+	clientConfig.CipherSuites = nil
+	clientConfig.MaxVersion = 0
+	if _, cs, err := testHandshake(t, clientConfig, testConfig); err != nil {
+		t.Fatal(err)
+	} else if cs.InsecureCipherSuite {
+		t.Errorf("cipher suite %s reported as insecure", CipherSuiteName(cs.CipherSuite))
+	}
+}
+
 func TestVersionName(t *testing.T) {
 	if got, exp := VersionName(VersionTLS13), "TLS 1.3"; got != exp {
 		t.Errorf("unexpected VersionName: got %q, expected %q", got, exp)
@@ -1704,6 +2227,11 @@ func http2isBadCipher(cipher uint16) bool {
 		TLS_RSA_WITH_AES_128_CBC_SHA256,
 		TLS_RSA_WITH_AES_128_GCM_SHA256,
 		TLS_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_PSK_WITH_AES_128_CBC_SHA,
+		TLS_PSK_WITH_AES_256_CBC_SHA,
+		TLS_PSK_WITH_AES_128_GCM_SHA256,
+		TLS_PSK_WITH_AES_256_GCM_SHA384,
+		TLS_PSK_WITH_AES_128_CBC_SHA256,
 		TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
 		TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
 		TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
@@ -1712,7 +2240,10 @@ func http2isBadCipher(cipher uint16) bool {
 		TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
 		TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
 		TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
-		TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:
+		TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256:
 		return true
 	default:
 		return false
@@ -1896,6 +2427,90 @@ func testVerifyCertificates(t *testing.T, version uint16) {
 	}
 }
 
+func TestVerifyCertificateChains(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testVerifyCertificateChains(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testVerifyCertificateChains(t, VersionTLS13) })
+}
+
+func testVerifyCertificateChains(t *testing.T, version uint16) {
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The peers trust issuer only through VerifyCertificateChains.
+	trusted := x509.NewCertPool()
+	trusted.AddCert(issuer)
+	var serverCalls, clientCalls int
+	var verifyErr error
+	verify := func(calls *int) func([][]byte, x509.VerifyOptions) ([][]*x509.Certificate, error) {
+		return func(rawCerts [][]byte, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+			*calls++
+			if verifyErr != nil {
+				return nil, verifyErr
+			}
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return nil, err
+			}
+			opts.Roots = trusted
+			return leaf.Verify(opts)
+		}
+	}
+
+	clientConfig := testConfig.Clone()
+	clientConfig.Time = testTime
+	clientConfig.MaxVersion = version
+	clientConfig.MinVersion = version
+	clientConfig.RootCAs = x509.NewCertPool()
+	clientConfig.InsecureSkipVerify = false
+	clientConfig.ServerName = "example.golang"
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	clientConfig.VerifyCertificateChains = verify(&clientCalls)
+	serverConfig := clientConfig.Clone()
+	serverConfig.ClientCAs = x509.NewCertPool()
+	serverConfig.ClientAuth = RequireAndVerifyClientCert
+	serverConfig.VerifyCertificateChains = verify(&serverCalls)
+
+	ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverCalls != 1 || clientCalls != 1 {
+		t.Errorf("VerifyCertificateChains called %d times on the server and %d on the client, want once", serverCalls, clientCalls)
+	}
+	for _, state := range []ConnectionState{ss, cs} {
+		if len(state.VerifiedChains) != 1 || !state.VerifiedChains[0][1].Equal(issuer) {
+			t.Errorf("unexpected verified chains %v", state.VerifiedChains)
+		}
+	}
+
+	// Resumption calls VerifyCertificateChains again instead of checking
+	// RootCAs and ClientCAs.
+	cs, _, err = testHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.DidResume {
+		t.Error("expected resumption")
+	}
+	if serverCalls != 2 || clientCalls != 2 {
+		t.Errorf("VerifyCertificateChains called %d times on the server and %d on the client after resumption, want twice", serverCalls, clientCalls)
+	}
+
+	verifyErr = errors.New("untrusted")
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil || !strings.Contains(err.Error(), "untrusted") {
+		t.Errorf("got error %v, want the VerifyCertificateChains error", err)
+	}
+
+	clientConfig.ClientSessionCache = nil
+	clientConfig.VerifyCertificateChains = func([][]byte, x509.VerifyOptions) ([][]*x509.Certificate, error) {
+		return [][]*x509.Certificate{{issuer}}, nil
+	}
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil || !strings.Contains(err.Error(), "not starting with the leaf") {
+		t.Errorf("got error %v, want an error about the returned chain", err)
+	}
+}
+
 func TestHandshakeMLKEM(t *testing.T) {
 	defaultWithPQ := []CurveID{X25519MLKEM768, SecP256r1MLKEM768, SecP384r1MLKEM1024,
 		X25519, CurveP256, CurveP384, CurveP521}
@@ -2288,6 +2903,81 @@ func TestECH(t *testing.T) {
 	check()
 }
 
+func TestGetCertificateForHello(t *testing.T) {
+	echKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := cryptobyte.NewBuilder(nil)
+	builder.AddUint16(extensionEncryptedClientHello)
+	builder.AddUint16LengthPrefixed(func(builder *cryptobyte.Builder) {
+		builder.AddUint8(1)
+		builder.AddUint16(0x0020 /* DHKEM(X25519, HKDF-SHA256) */)
+		builder.AddUint16LengthPrefixed(func(builder *cryptobyte.Builder) {
+			builder.AddBytes(echKey.PublicKey().Bytes())
+		})
+		builder.AddUint16LengthPrefixed(func(builder *cryptobyte.Builder) {
+			builder.AddUint16(0x0001 /* HKDF-SHA256 */)
+			builder.AddUint16(0x0001 /* AES-128-GCM */)
+		})
+		builder.AddUint8(32)
+		builder.AddUint8LengthPrefixed(func(builder *cryptobyte.Builder) {
+			builder.AddBytes([]byte("public.example"))
+		})
+		builder.AddUint16(0) // extensions
+	})
+	echConfig := builder.BytesOrPanic()
+	builder = cryptobyte.NewBuilder(nil)
+	builder.AddUint16LengthPrefixed(func(builder *cryptobyte.Builder) {
+		builder.AddBytes(echConfig)
+	})
+	echConfigList := builder.BytesOrPanic()
+
+	for _, useECH := range []bool{true, false} {
+		clientConfig, serverConfig := testConfig.Clone(), testConfig.Clone()
+		clientConfig.Rand, serverConfig.Rand = rand.Reader, rand.Reader
+		clientConfig.MinVersion = VersionTLS13
+		clientConfig.ServerName = "secret.example"
+		clientConfig.NextProtos = []string{"h2"}
+		if useECH {
+			clientConfig.EncryptedClientHelloConfigList = echConfigList
+		}
+		serverConfig.NextProtos = []string{"h2"}
+		serverConfig.EncryptedClientHelloKeys = []EncryptedClientHelloKey{
+			{Config: echConfig, PrivateKey: echKey.Bytes()},
+		}
+		var got *ClientHello
+		serverConfig.GetCertificate = func(*ClientHelloInfo) (*Certificate, error) {
+			t.Error("GetCertificate called with GetCertificateForHello set")
+			return nil, nil
+		}
+		serverConfig.GetCertificateForHello = func(hello *ClientHello) (*Certificate, error) {
+			got = hello
+			return &testConfig.Certificates[0], nil
+		}
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+			t.Fatal(err)
+		}
+		if got == nil {
+			t.Fatal("GetCertificateForHello wasn't called")
+		}
+		if got.ECHAccepted != useECH || got.ServerName != "secret.example" || !slicesEqual(got.SupportedProtos, []string{"h2"}) {
+			t.Errorf("ECH %v: got ECHAccepted %v, ServerName %q, SupportedProtos %q", useECH, got.ECHAccepted, got.ServerName, got.SupportedProtos)
+		}
+		wantOuter := ""
+		if useECH {
+			wantOuter = "public.example"
+		}
+		if got.OuterServerName != wantOuter {
+			t.Errorf("ECH %v: got OuterServerName %q, want %q", useECH, got.OuterServerName, wantOuter)
+		}
+		var msg clientHelloMsg
+		if !msg.unmarshal(got.Raw) || msg.serverName != "secret.example" {
+			t.Errorf("ECH %v: Raw isn't the ClientHello for secret.example", useECH)
+		}
+	}
+}
+
 func TestMessageSigner(t *testing.T) {
 	t.Run("TLSv10", func(t *testing.T) { testMessageSigner(t, VersionTLS10) })
 	t.Run("TLSv12", func(t *testing.T) { testMessageSigner(t, VersionTLS12) })
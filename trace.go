@@ -0,0 +1,99 @@
+package tls
+
+import (
+	"context"
+	"time"
+)
+
+// A ConnTrace is a set of hooks called during the handshakes of a
+// connection, like those of net/http/httptrace, to diagnose slow or failing
+// handshakes. Each is called with the time of the event. Any of them may be
+// nil. It's attached to the context of [Conn.HandshakeContext] with
+// [WithConnTrace].
+//
+// The hooks are called from the goroutine running the handshake, which
+// waits for them to return.
+type ConnTrace struct {
+	// HandshakeStart is called when a handshake starts.
+	HandshakeStart func(time.Time)
+
+	// HelloSent is called after a client sends a ClientHello, including
+	// the one answering a HelloRetryRequest, or a server a ServerHello.
+	HelloSent func(time.Time)
+
+	// HelloReceived is called when a server receives a ClientHello, or a
+	// client a ServerHello, including a HelloRetryRequest.
+	HelloReceived func(time.Time)
+
+	// HelloRetryRequest is called when a server sends a HelloRetryRequest,
+	// or a client receives one.
+	HelloRetryRequest func(time.Time)
+
+	// CertificatesReceived is called with the certificates of the peer
+	// before they're verified. Those of clients may be empty.
+	CertificatesReceived func(t time.Time, certificates [][]byte)
+
+	// VerificationDone is called once the certificates of the peer are
+	// verified, with the error that fails the handshake, if any.
+	VerificationDone func(t time.Time, err error)
+
+	// HandshakeDone is called when a handshake completes, with its error if
+	// it failed.
+	HandshakeDone func(t time.Time, err error)
+}
+
+type connTraceKey struct{}
+
+// WithConnTrace returns a context based on ctx that makes the handshakes it's
+// passed to call the hooks of trace.
+func WithConnTrace(ctx context.Context, trace *ConnTrace) context.Context {
+	return context.WithValue(ctx, connTraceKey{}, trace)
+}
+
+// ContextConnTrace returns the ConnTrace of ctx, or nil if there is none.
+func ContextConnTrace(ctx context.Context) *ConnTrace {
+	trace, _ := ctx.Value(connTraceKey{}).(*ConnTrace)
+	return trace
+}
+
+func (t *ConnTrace) handshakeStart() {
+	if t != nil && t.HandshakeStart != nil {
+		t.HandshakeStart(time.Now())
+	}
+}
+
+func (t *ConnTrace) helloSent() {
+	if t != nil && t.HelloSent != nil {
+		t.HelloSent(time.Now())
+	}
+}
+
+func (t *ConnTrace) helloReceived() {
+	if t != nil && t.HelloReceived != nil {
+		t.HelloReceived(time.Now())
+	}
+}
+
+func (t *ConnTrace) helloRetryRequest() {
+	if t != nil && t.HelloRetryRequest != nil {
+		t.HelloRetryRequest(time.Now())
+	}
+}
+
+func (t *ConnTrace) certificatesReceived(certificates [][]byte) {
+	if t != nil && t.CertificatesReceived != nil {
+		t.CertificatesReceived(time.Now(), certificates)
+	}
+}
+
+func (t *ConnTrace) verificationDone(err error) {
+	if t != nil && t.VerificationDone != nil {
+		t.VerificationDone(time.Now(), err)
+	}
+}
+
+func (t *ConnTrace) handshakeDone(err error) {
+	if t != nil && t.HandshakeDone != nil {
+		t.HandshakeDone(time.Now(), err)
+	}
+}
@@ -0,0 +1,91 @@
+package tls
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// traceEvents returns a ConnTrace recording the names of its events.
+func traceEvents(t *testing.T) (*ConnTrace, func() []string) {
+	var mu sync.Mutex
+	var events []string
+	var last time.Time
+	record := func(name string, at time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if at.Before(last) {
+			t.Errorf("%s at %v, before the previous event", name, at)
+		}
+		last = at
+		events = append(events, name)
+	}
+	trace := &ConnTrace{
+		HandshakeStart:    func(at time.Time) { record("HandshakeStart", at) },
+		HelloSent:         func(at time.Time) { record("HelloSent", at) },
+		HelloReceived:     func(at time.Time) { record("HelloReceived", at) },
+		HelloRetryRequest: func(at time.Time) { record("HelloRetryRequest", at) },
+		CertificatesReceived: func(at time.Time, certificates [][]byte) {
+			if len(certificates) == 0 {
+				t.Error("no certificates")
+			}
+			record("CertificatesReceived", at)
+		},
+		VerificationDone: func(at time.Time, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			record("VerificationDone", at)
+		},
+		HandshakeDone: func(at time.Time, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			record("HandshakeDone", at)
+		},
+	}
+	return trace, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+}
+
+func TestConnTrace(t *testing.T) {
+	clientTrace, clientEvents := traceEvents(t)
+	serverTrace, serverEvents := traceEvents(t)
+
+	clientConfig := testConfig.Clone()
+	serverConfig := testConfig.Clone()
+	serverConfig.CurvePreferences = []CurveID{CurveP384}
+	serverConfig.ClientAuth = RequireAnyClientCert
+
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, serverConfig)
+	defer client.Close()
+	defer server.Close()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.HandshakeContext(WithConnTrace(context.Background(), serverTrace))
+	}()
+	if err := client.HandshakeContext(WithConnTrace(context.Background(), clientTrace)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"HandshakeStart", "HelloSent", "HelloReceived", "HelloRetryRequest", "HelloSent",
+		"HelloReceived", "CertificatesReceived", "VerificationDone", "HandshakeDone"}
+	if got := clientEvents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("client events %v, want %v", got, want)
+	}
+	want = []string{"HandshakeStart", "HelloReceived", "HelloRetryRequest", "HelloReceived", "HelloSent",
+		"CertificatesReceived", "VerificationDone", "HandshakeDone"}
+	if got := serverEvents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("server events %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,569 @@
+package tls
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// A ClientHelloID identifies the ClientHello a UConn sends, either that of
+// this package, that of a ClientHelloSpec set with ApplyPreset, or a preset
+// of a browser.
+type ClientHelloID struct {
+	Client  string
+	Version string
+}
+
+// Str returns the name of the ClientHelloID.
+func (p *ClientHelloID) Str() string {
+	return fmt.Sprintf("%s-%s", p.Client, p.Version)
+}
+
+const (
+	helloGolang     = "Golang"
+	helloCustom     = "Custom"
+	helloChrome     = "Chrome"
+	helloFirefox    = "Firefox"
+	helloSafari     = "Safari"
+	helloIOS        = "iOS"
+	helloEdge       = "Edge"
+	helloRandomized = "Randomized"
+	helloAutoVers   = "0"
+)
+
+var (
+	// HelloGolang is the ClientHello of this package.
+	HelloGolang = ClientHelloID{helloGolang, helloAutoVers}
+	// HelloCustom is the ClientHelloSpec set with UConn.ApplyPreset.
+	HelloCustom = ClientHelloID{helloCustom, helloAutoVers}
+
+	// HelloChrome_Auto is the latest Chrome preset of this package.
+	HelloChrome_Auto = HelloChrome_106_Shuffle
+	// HelloChrome_106_Shuffle is the ClientHello of Chrome 106 and later
+	// before post-quantum key shares, with the extensions in a random
+	// order. Chrome offers brotli certificate compression, which this
+	// package can't decompress: the compress_certificate extension is only
+	// sent if Config.CertificateCompressors implements brotli, and the
+	// ClientHello differs from that of Chrome otherwise.
+	HelloChrome_106_Shuffle = ClientHelloID{helloChrome, "106_shuffle"}
+
+	// HelloFirefox_Auto, HelloSafari_Auto, HelloIOS_Auto, HelloEdge_Auto
+	// and HelloRandomized are the ClientHelloIDs of uTLS without a preset
+	// in this package. The handshakes of UConns using them fail, unless a
+	// ClientHelloSpec is set with UConn.ApplyPreset.
+	HelloFirefox_Auto = ClientHelloID{helloFirefox, helloAutoVers}
+	HelloSafari_Auto  = ClientHelloID{helloSafari, helloAutoVers}
+	HelloIOS_Auto     = ClientHelloID{helloIOS, helloAutoVers}
+	HelloEdge_Auto    = ClientHelloID{helloEdge, helloAutoVers}
+	HelloRandomized   = ClientHelloID{helloRandomized, helloAutoVers}
+)
+
+// UTLSIdToSpec returns the ClientHelloSpec of a preset. The order of the
+// extensions of those that randomize it is drawn for each call.
+func UTLSIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
+	switch id {
+	case HelloChrome_106_Shuffle:
+		return ClientHelloSpec{
+			CipherSuites: []uint16{
+				GREASE_PLACEHOLDER,
+				TLS_AES_128_GCM_SHA256,
+				TLS_AES_256_GCM_SHA384,
+				TLS_CHACHA20_POLY1305_SHA256,
+				TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				TLS_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_RSA_WITH_AES_256_GCM_SHA384,
+				TLS_RSA_WITH_AES_128_CBC_SHA,
+				TLS_RSA_WITH_AES_256_CBC_SHA,
+			},
+			CompressionMethods: []uint8{compressionNone},
+			Extensions: ShuffleChromeTLSExtensions([]TLSExtension{
+				&UtlsGREASEExtension{},
+				&SNIExtension{},
+				&ExtendedMasterSecretExtension{},
+				&RenegotiationInfoExtension{Renegotiation: RenegotiateOnceAsClient},
+				&SupportedCurvesExtension{Curves: []CurveID{GREASE_PLACEHOLDER, X25519, CurveP256, CurveP384}},
+				&SupportedPointsExtension{SupportedPoints: []uint8{pointFormatUncompressed}},
+				&SessionTicketExtension{},
+				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
+				&StatusRequestExtension{},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
+					ECDSAWithP256AndSHA256,
+					PSSWithSHA256,
+					PKCS1WithSHA256,
+					ECDSAWithP384AndSHA384,
+					PSSWithSHA384,
+					PKCS1WithSHA384,
+					PSSWithSHA512,
+					PKCS1WithSHA512,
+				}},
+				&SCTExtension{},
+				&KeyShareExtension{KeyShares: []KeyShare{
+					{Group: GREASE_PLACEHOLDER, Data: []byte{0}},
+					{Group: X25519},
+				}},
+				&PSKKeyExchangeModesExtension{Modes: []uint8{PskModeDHE}},
+				&SupportedVersionsExtension{Versions: []uint16{GREASE_PLACEHOLDER, VersionTLS13, VersionTLS12}},
+				&UtlsCompressCertExtension{Algorithms: []CertCompressionAlgo{CertCompressionBrotli}},
+				&ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}},
+				&UtlsGREASEExtension{Body: []byte{0}},
+				&UtlsPaddingExtension{GetPaddingLen: BoringPaddingStyle},
+			}),
+		}, nil
+	}
+	switch id.Client {
+	case helloFirefox, helloSafari, helloIOS, helloEdge, helloRandomized:
+		return ClientHelloSpec{}, fmt.Errorf("tls: ClientHelloID %s has no preset in this package, set its ClientHelloSpec with UConn.ApplyPreset", id.Str())
+	}
+	return ClientHelloSpec{}, fmt.Errorf("tls: unknown ClientHelloID %s", id.Str())
+}
+
+// ShuffleChromeTLSExtensions returns exts in a random order, as Chrome sends
+// them, except for the GREASE, padding and pre_shared_key extensions, which
+// keep their positions.
+func ShuffleChromeTLSExtensions(exts []TLSExtension) []TLSExtension {
+	exts = slicesClone(exts)
+	var movable []int
+	for i, ext := range exts {
+		switch ext.(type) {
+		case *UtlsGREASEExtension, *UtlsPaddingExtension:
+			continue
+		}
+		if id, _, err := marshalExtension(ext); err == nil && id == extensionPreSharedKey {
+			continue
+		}
+		movable = append(movable, i)
+	}
+	for i := len(movable) - 1; i > 0; i-- {
+		j := randIntn(rand.Reader, i+1)
+		exts[movable[i]], exts[movable[j]] = exts[movable[j]], exts[movable[i]]
+	}
+	return exts
+}
+
+// A UConn is a client connection sending the ClientHello of a ClientHelloID,
+// for projects written for the uTLS package to use this one instead.
+//
+// Only part of the uTLS API is available. The only browser preset is
+// HelloChrome_106_Shuffle: the Firefox, Safari, iOS, Edge and randomized
+// ClientHelloIDs of uTLS have no preset, and their ClientHelloSpecs must be
+// set with ApplyPreset, for example as parsed with ParseClientHelloSpec from
+// a capture. The UConn methods are the handshake and I/O methods of Conn,
+// ApplyPreset, SetSNI and RemoveSNIExtension, and the ClientHello can't be
+// edited once built.
+//
+// The ClientHello is shaped after the ClientHelloSpec: the cipher suites,
+// the extensions in their order, with their contents and GREASE values, and
+// the padding. The connection-specific extensions, such as the server name,
+// the key shares and the session ticket or PSK identity, are those of this
+// package, at the positions of the ClientHelloSpec; the key shares are only
+// generated for the first supported group. ECH, QUIC and
+// Config.HandshakeScript aren't supported.
+type UConn struct {
+	*Conn
+
+	ClientHelloID ClientHelloID
+}
+
+// UClient returns a new client connection sending the ClientHello of
+// clientHelloID. The preferences of the ClientHello, such as the protocol
+// versions, the cipher suites or the ALPN protocols, replace those of a copy
+// of config. HelloCustom connections need a ClientHelloSpec set with
+// ApplyPreset before the handshake.
+func UClient(conn net.Conn, config *Config, clientHelloID ClientHelloID) *UConn {
+	if config == nil {
+		config = &Config{}
+	}
+	u := &UConn{Conn: Client(conn, config.Clone()), ClientHelloID: clientHelloID}
+	switch clientHelloID {
+	case HelloGolang:
+	case HelloCustom:
+		u.helloSpecErr = errors.New("tls: HelloCustom requires a ClientHelloSpec set with ApplyPreset")
+	default:
+		spec, err := UTLSIdToSpec(clientHelloID)
+		if err == nil {
+			err = u.ApplyPreset(&spec)
+		}
+		u.helloSpecErr = err
+	}
+	return u
+}
+
+// ApplyPreset sets the ClientHelloSpec of the ClientHellos sent by u, and
+// its preferences in the Config of u.
+func (u *UConn) ApplyPreset(p *ClientHelloSpec) error {
+	for _, ext := range p.Extensions {
+		if _, _, err := marshalExtension(ext); err != nil {
+			return err
+		}
+	}
+	spec := *p
+	spec.CipherSuites = slicesClone(p.CipherSuites)
+	spec.CompressionMethods = slicesClone(p.CompressionMethods)
+	spec.Extensions = slicesClone(p.Extensions)
+	spec.ApplyTo(u.config)
+	u.helloSpec, u.helloSpecErr = &spec, nil
+	return nil
+}
+
+// SetSNI sets the server name sent, and verified, by u.
+func (u *UConn) SetSNI(sni string) {
+	u.config.ServerName = sni
+}
+
+// RemoveSNIExtension makes u send no server name, while still verifying
+// Config.ServerName, per Config.OmitServerName.
+func (u *UConn) RemoveSNIExtension() error {
+	u.config.OmitServerName = true
+	return nil
+}
+
+// A helloExtension is an extension of the ClientHello shaped after a
+// ClientHelloSpec.
+type helloExtension struct {
+	id   uint16
+	data []byte
+	// padding sets the length of the padding extension.
+	padding *UtlsPaddingExtension
+}
+
+// isConnectionExtension reports whether the contents of the extension id
+// are those of the connection, rather than of the ClientHelloSpec.
+func isConnectionExtension(id uint16) bool {
+	switch id {
+	case extensionServerName, extensionSessionTicket, extensionRenegotiationInfo, extensionKeyShare,
+		extensionPadding, extensionPreSharedKey, extensionEarlyData, extensionCookie:
+		return true
+	}
+	return false
+}
+
+// greaseValue returns the i-th GREASE value of RFC 8701, from 0x0a0a to
+// 0xfafa.
+func greaseValue(i int) uint16 {
+	return GREASE_PLACEHOLDER | uint16(i)<<12 | uint16(i)<<4
+}
+
+// applyClientHelloSpec shapes hello after the ClientHelloSpec of the UConn.
+func (c *Conn) applyClientHelloSpec(hello *clientHelloMsg, ech *echClientContext) error {
+	if ech != nil || c.quic != nil || c.config.HandshakeScript != nil {
+		return errors.New("tls: UConn can't be used with ECH, QUIC or Config.HandshakeScript")
+	}
+	spec := c.helloSpec
+
+	// The GREASE values are mapped to random ones, keeping distinct values
+	// distinct. Those of the cipher suites, groups, extensions and versions
+	// are shifted apart, as the independent values of BoringSSL.
+	const (
+		greaseCipher = iota
+		greaseGroup
+		greaseExtension
+		greaseVersion = greaseExtension + 2
+	)
+	var perm [16]int
+	for i := range perm {
+		j := randIntn(c.config.rand(), i+1)
+		perm[i], perm[j] = perm[j], i
+	}
+	grease := func(v uint16, offset int) uint16 {
+		if !isGREASE(v) {
+			return v
+		}
+		return greaseValue(perm[(int(v>>12)+offset)%16])
+	}
+	greaseList := func(data []byte, offset, stride, greaseOffset int) []byte {
+		data = slicesClone(data)
+		for i := offset; i+2 <= len(data); {
+			v := grease(uint16(data[i])<<8|uint16(data[i+1]), greaseOffset)
+			data[i], data[i+1] = byte(v>>8), byte(v)
+			if stride > 0 {
+				i += stride
+			} else if i+4 <= len(data) {
+				// A key share, with its length.
+				i += 4 + (int(data[i+2])<<8 | int(data[i+3]))
+			} else {
+				break
+			}
+		}
+		return data
+	}
+
+	hello.layout = nil
+	greaseExtensions := 0
+	for _, ext := range spec.Extensions {
+		id, data, err := marshalExtension(ext)
+		if err != nil {
+			return err
+		}
+		if g, ok := ext.(*UtlsGREASEExtension); ok && (g.Value == 0 || g.Value == GREASE_PLACEHOLDER) {
+			id = greaseValue(greaseExtensions % 16)
+			greaseExtensions++
+		}
+		layoutExt := helloExtension{id: grease(id, greaseExtension), data: data}
+		switch layoutExt.id {
+		case extensionSupportedCurves:
+			layoutExt.data = greaseList(data, 2, 2, greaseGroup)
+		case extensionSupportedVersions:
+			layoutExt.data = greaseList(data, 1, 2, greaseVersion)
+		case extensionKeyShare:
+			layoutExt.data = greaseList(data, 2, 0, greaseGroup)
+		case extensionSessionTicket:
+			// Sent even without a session cache, as browsers do.
+			hello.ticketSupported = true
+		case extensionCompressCertificate:
+			// The certificates compressed with algorithms that can't be
+			// decompressed would fail the handshake.
+			var ok bool
+			if layoutExt.data, ok = supportedCertCompressionAlgorithms(data, c.config.certCompressionAlgorithms()); !ok {
+				continue
+			}
+		case extensionESNI:
+			if esni, ok := ext.(*FakeESNIExtension); ok {
+				if layoutExt.data, err = esni.generate(c.config.rand()); err != nil {
+					return err
+				}
+			}
+		case extensionPadding:
+			if layoutExt.padding, _ = ext.(*UtlsPaddingExtension); layoutExt.padding == nil {
+				layoutExt.padding = &UtlsPaddingExtension{}
+			}
+		}
+		hello.layout = append(hello.layout, layoutExt)
+	}
+	hello.vers = cmpOr(spec.LegacyVersion, hello.vers)
+	hello.cipherSuites = nil
+	for _, id := range spec.CipherSuites {
+		hello.cipherSuites = append(hello.cipherSuites, grease(id, greaseCipher))
+	}
+	hello.compressionMethods = spec.CompressionMethods
+	if len(hello.compressionMethods) == 0 {
+		hello.compressionMethods = []uint8{compressionNone}
+	}
+
+	// The preferences of the handshake are those sent, as parsed back.
+	var b cryptobyte.Builder
+	b.AddUint8(typeClientHello)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(hello.vers)
+		b.AddBytes(hello.random)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, id := range hello.cipherSuites {
+				b.AddUint16(id)
+			}
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(hello.compressionMethods)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, ext := range hello.layout {
+				if !isConnectionExtension(ext.id) {
+					b.AddUint16(ext.id)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes(ext.data)
+					})
+				}
+			}
+		})
+	})
+	msg, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	var sent clientHelloMsg
+	if !sent.unmarshal(msg) {
+		return errors.New("tls: ClientHelloSpec has malformed extensions")
+	}
+	hello.ocspStapling = sent.ocspStapling
+	hello.supportedCurves = sent.supportedCurves
+	hello.supportedPoints = sent.supportedPoints
+	hello.supportedSignatureAlgorithms = sent.supportedSignatureAlgorithms
+	hello.supportedSignatureAlgorithmsCert = sent.supportedSignatureAlgorithmsCert
+	hello.extendedMasterSecret = sent.extendedMasterSecret
+	hello.encryptThenMAC = sent.encryptThenMAC
+	hello.recordSizeLimit = sent.recordSizeLimit
+	hello.alpnProtocols = sent.alpnProtocols
+	hello.scts = sent.scts
+	hello.pskModes = sent.pskModes
+	hello.certCompressionAlgorithms = sent.certCompressionAlgorithms
+	hello.delegatedCredentialSchemes = sent.delegatedCredentialSchemes
+	hello.serverCertificateTypes = sent.serverCertificateTypes
+	hello.clientCertificateTypes = sent.clientCertificateTypes
+	hello.postHandshakeAuth = sent.postHandshakeAuth
+	hello.certificateAuthorities = sent.certificateAuthorities
+	return nil
+}
+
+// supportedCertCompressionAlgorithms returns the compress_certificate
+// extension_data data without the algorithms not in supported, and whether
+// any is left.
+func supportedCertCompressionAlgorithms(data []byte, supported []uint16) ([]byte, bool) {
+	s := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !s.ReadUint8LengthPrefixed(&list) {
+		return data, true // rejected when parsed back
+	}
+	var algs []uint16
+	for !list.Empty() {
+		var alg uint16
+		if !list.ReadUint16(&alg) {
+			return data, true
+		}
+		if slicesContains(supported, alg) {
+			algs = append(algs, alg)
+		}
+	}
+	if len(algs) == 0 {
+		return nil, false
+	}
+	return buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, alg := range algs {
+				b.AddUint16(alg)
+			}
+		})
+	}), true
+}
+
+// layoutExtensions returns the extensions of m, marshaled as marshaled, in
+// the layout of its ClientHelloSpec. headerLen is the length of the rest of
+// the message, for the padding.
+func (m *clientHelloMsg) layoutExtensions(marshaled []byte, headerLen int) ([]byte, error) {
+	ours := make(map[uint16][]byte)
+	s := cryptobyte.String(marshaled)
+	for !s.Empty() {
+		var id uint16
+		var data []byte
+		if !s.ReadUint16(&id) || !readUint16LengthPrefixed(&s, &data) {
+			return nil, errors.New("tls: internal error: malformed ClientHello extensions")
+		}
+		ours[id] = data
+	}
+
+	var exts []helloExtension
+	sent := make(map[uint16]bool)
+	padding := -1
+	for _, ext := range m.layout {
+		switch {
+		case ext.id == extensionPadding:
+			padding = len(exts)
+		case ext.id == extensionPreSharedKey:
+			// It must be the last extension, see RFC 8446, Section 4.2.11.
+			continue
+		case ext.id == extensionKeyShare:
+			data, ok := ours[ext.id]
+			if !ok {
+				continue
+			}
+			ext = helloExtension{id: ext.id, data: mergeKeyShares(ext.data, data)}
+		case isConnectionExtension(ext.id):
+			data, ok := ours[ext.id]
+			if !ok {
+				continue
+			}
+			ext = helloExtension{id: ext.id, data: data}
+		}
+		exts = append(exts, ext)
+		sent[ext.id] = true
+	}
+	// The extensions the handshake depends on are sent even if the
+	// ClientHelloSpec lacks them, such as the cookie of a HelloRetryRequest.
+	for _, id := range []uint16{extensionKeyShare, extensionEarlyData, extensionCookie, extensionPreSharedKey} {
+		if data, ok := ours[id]; ok && !sent[id] {
+			exts = append(exts, helloExtension{id: id, data: data})
+		}
+	}
+	if padding >= 0 {
+		unpaddedLen := headerLen
+		for i, ext := range exts {
+			if i != padding {
+				unpaddedLen += 4 + len(ext.data)
+			}
+		}
+		if n, ok := exts[padding].padding.paddingLen(unpaddedLen); ok {
+			exts[padding].data = make([]byte, n)
+		} else {
+			exts = append(exts[:padding], exts[padding+1:]...)
+		}
+	}
+
+	var b cryptobyte.Builder
+	for _, ext := range exts {
+		b.AddUint16(ext.id)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(ext.data)
+		})
+	}
+	return b.Bytes()
+}
+
+// parseKeyShares parses the extension_data of a key_share extension.
+func parseKeyShares(data []byte) []keyShare {
+	var shares []keyShare
+	s := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&list) {
+		return nil
+	}
+	for !list.Empty() {
+		var ks keyShare
+		if !list.ReadUint16((*uint16)(&ks.group)) || !readUint16LengthPrefixed(&list, &ks.data) {
+			return nil
+		}
+		shares = append(shares, ks)
+	}
+	return shares
+}
+
+func marshalKeyShares(shares []keyShare) []byte {
+	return buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, ks := range shares {
+				b.AddUint16(uint16(ks.group))
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(ks.data)
+				})
+			}
+		})
+	})
+}
+
+// mergeKeyShares returns the key_share extension_data with the GREASE
+// entries of layout and the key shares of ours, in the order of layout.
+func mergeKeyShares(layout, ours []byte) []byte {
+	ourShares := parseKeyShares(ours)
+	var shares []keyShare
+	for _, ks := range parseKeyShares(layout) {
+		if isGREASE(uint16(ks.group)) {
+			shares = append(shares, ks)
+		} else if i := slicesIndexFunc(ourShares, func(our keyShare) bool { return our.group == ks.group }); i >= 0 {
+			shares = append(shares, ourShares[i])
+			ourShares = append(ourShares[:i], ourShares[i+1:]...)
+		}
+	}
+	return marshalKeyShares(append(shares, ourShares...))
+}
+
+// retryLayout returns layout for the ClientHello answering a
+// HelloRetryRequest, which has a single key share, without GREASE.
+func retryLayout(layout []helloExtension) []helloExtension {
+	layout = slicesClone(layout)
+	for i, ext := range layout {
+		if ext.id == extensionKeyShare {
+			shares := slicesDeleteFunc(parseKeyShares(ext.data), func(ks keyShare) bool {
+				return isGREASE(uint16(ks.group))
+			})
+			layout[i].data = marshalKeyShares(shares)
+		}
+	}
+	return layout
+}
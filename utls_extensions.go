@@ -0,0 +1,447 @@
+package tls
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// The extension types of the uTLS compatibility layer, with which a
+// ClientHelloSpec is written. Extensions whose contents are those of the
+// connection, such as SNIExtension, KeyShareExtension or
+// SessionTicketExtension, only mark their position: a UConn sends the
+// contents of its own handshake there.
+
+// GREASE_PLACEHOLDER stands for a GREASE value of RFC 8701, replaced by a
+// random one by each UConn, in the cipher suites, supported groups, key
+// shares and supported versions of a ClientHelloSpec.
+const GREASE_PLACEHOLDER = 0x0a0a
+
+const (
+	extensionApplicationSettings uint16 = 17513
+	extensionESNI                uint16 = 0xffce
+)
+
+// The PSK key exchange modes of RFC 8446, Section 4.2.9.
+const (
+	PskModePlain uint8 = pskModePlain
+	PskModeDHE   uint8 = pskModeDHE
+)
+
+// CertCompressionAlgo is the name of CertificateCompressionAlgorithm in uTLS.
+type CertCompressionAlgo = CertificateCompressionAlgorithm
+
+const (
+	CertCompressionZlib   = CertificateCompressionZlib
+	CertCompressionBrotli = CertificateCompressionBrotli
+	CertCompressionZstd   = CertificateCompressionZstd
+)
+
+// buildExtension returns the extension_data built by f.
+func buildExtension(f func(b *cryptobyte.Builder)) []byte {
+	var b cryptobyte.Builder
+	f(&b)
+	return b.BytesOrPanic()
+}
+
+// UtlsGREASEExtension is a GREASE extension of RFC 8701. If Value is zero or
+// GREASE_PLACEHOLDER, each one of the ClientHelloSpec gets a distinct random
+// value.
+type UtlsGREASEExtension struct {
+	Value uint16
+	Body  []byte
+}
+
+func (e *UtlsGREASEExtension) extension() (uint16, []byte) {
+	return cmpOr(e.Value, GREASE_PLACEHOLDER), e.Body
+}
+func (e *UtlsGREASEExtension) Len() int                   { return extensionLen(e) }
+func (e *UtlsGREASEExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// SNIExtension marks the position of the server_name extension, which
+// holds Config.ServerName.
+type SNIExtension struct {
+	ServerName string
+}
+
+func (e *SNIExtension) extension() (uint16, []byte) {
+	if e.ServerName == "" {
+		return extensionServerName, nil
+	}
+	return extensionServerName, buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint8(0) // name_type = host_name
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes([]byte(e.ServerName))
+			})
+		})
+	})
+}
+func (e *SNIExtension) Len() int                   { return extensionLen(e) }
+func (e *SNIExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// StatusRequestExtension is the status_request extension of RFC 6066,
+// requesting an OCSP response.
+type StatusRequestExtension struct{}
+
+func (e *StatusRequestExtension) extension() (uint16, []byte) {
+	return extensionStatusRequest, []byte{statusTypeOCSP, 0, 0, 0, 0}
+}
+func (e *StatusRequestExtension) Len() int                   { return extensionLen(e) }
+func (e *StatusRequestExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// SupportedCurvesExtension is the supported_groups extension.
+type SupportedCurvesExtension struct {
+	Curves []CurveID
+}
+
+func (e *SupportedCurvesExtension) extension() (uint16, []byte) {
+	return extensionSupportedCurves, buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, curve := range e.Curves {
+				b.AddUint16(uint16(curve))
+			}
+		})
+	})
+}
+func (e *SupportedCurvesExtension) Len() int                   { return extensionLen(e) }
+func (e *SupportedCurvesExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// SupportedPointsExtension is the ec_point_formats extension of RFC 8422.
+type SupportedPointsExtension struct {
+	SupportedPoints []uint8
+}
+
+func (e *SupportedPointsExtension) extension() (uint16, []byte) {
+	return extensionSupportedPoints, buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(e.SupportedPoints)
+		})
+	})
+}
+func (e *SupportedPointsExtension) Len() int                   { return extensionLen(e) }
+func (e *SupportedPointsExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+func signatureSchemesExtension(schemes []SignatureScheme) []byte {
+	return buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, scheme := range schemes {
+				b.AddUint16(uint16(scheme))
+			}
+		})
+	})
+}
+
+// SignatureAlgorithmsExtension is the signature_algorithms extension.
+type SignatureAlgorithmsExtension struct {
+	SupportedSignatureAlgorithms []SignatureScheme
+}
+
+func (e *SignatureAlgorithmsExtension) extension() (uint16, []byte) {
+	return extensionSignatureAlgorithms, signatureSchemesExtension(e.SupportedSignatureAlgorithms)
+}
+func (e *SignatureAlgorithmsExtension) Len() int                   { return extensionLen(e) }
+func (e *SignatureAlgorithmsExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// SignatureAlgorithmsCertExtension is the signature_algorithms_cert
+// extension of RFC 8446, Section 4.2.3.
+type SignatureAlgorithmsCertExtension struct {
+	SupportedSignatureAlgorithms []SignatureScheme
+}
+
+func (e *SignatureAlgorithmsCertExtension) extension() (uint16, []byte) {
+	return extensionSignatureAlgorithmsCert, signatureSchemesExtension(e.SupportedSignatureAlgorithms)
+}
+func (e *SignatureAlgorithmsCertExtension) Len() int                   { return extensionLen(e) }
+func (e *SignatureAlgorithmsCertExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+func protocolsExtension(protocols []string) []byte {
+	return buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, proto := range protocols {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte(proto))
+				})
+			}
+		})
+	})
+}
+
+// ALPNExtension is the application_layer_protocol_negotiation extension,
+// whose protocols replace Config.NextProtos.
+type ALPNExtension struct {
+	AlpnProtocols []string
+}
+
+func (e *ALPNExtension) extension() (uint16, []byte) {
+	return extensionALPN, protocolsExtension(e.AlpnProtocols)
+}
+func (e *ALPNExtension) Len() int                   { return extensionLen(e) }
+func (e *ALPNExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// ApplicationSettingsExtension is the application_settings (ALPS) extension
+// of Chrome. This package doesn't implement ALPS, and ignores the settings
+// of the servers.
+type ApplicationSettingsExtension struct {
+	SupportedProtocols []string
+}
+
+func (e *ApplicationSettingsExtension) extension() (uint16, []byte) {
+	return extensionApplicationSettings, protocolsExtension(e.SupportedProtocols)
+}
+func (e *ApplicationSettingsExtension) Len() int                   { return extensionLen(e) }
+func (e *ApplicationSettingsExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// SCTExtension is the signed_certificate_timestamp extension of RFC 6962.
+type SCTExtension struct{}
+
+func (e *SCTExtension) extension() (uint16, []byte) { return extensionSCT, nil }
+func (e *SCTExtension) Len() int                    { return extensionLen(e) }
+func (e *SCTExtension) Read(b []byte) (int, error)  { return readExtension(e, b) }
+
+// SessionTicketExtension marks the position of the session_ticket extension
+// of RFC 5077, which holds the ticket of the session being resumed, if any.
+type SessionTicketExtension struct{}
+
+func (e *SessionTicketExtension) extension() (uint16, []byte) { return extensionSessionTicket, nil }
+func (e *SessionTicketExtension) Len() int                    { return extensionLen(e) }
+func (e *SessionTicketExtension) Read(b []byte) (int, error)  { return readExtension(e, b) }
+
+// ExtendedMasterSecretExtension is the extended_master_secret extension of
+// RFC 7627.
+type ExtendedMasterSecretExtension struct{}
+
+func (e *ExtendedMasterSecretExtension) extension() (uint16, []byte) {
+	return extensionExtendedMasterSecret, nil
+}
+func (e *ExtendedMasterSecretExtension) Len() int                   { return extensionLen(e) }
+func (e *ExtendedMasterSecretExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// RenegotiationInfoExtension marks the position of the renegotiation_info
+// extension of RFC 5746. Renegotiation is set in Config.Renegotiation.
+type RenegotiationInfoExtension struct {
+	Renegotiation RenegotiationSupport
+}
+
+func (e *RenegotiationInfoExtension) extension() (uint16, []byte) {
+	return extensionRenegotiationInfo, []byte{0}
+}
+func (e *RenegotiationInfoExtension) Len() int                   { return extensionLen(e) }
+func (e *RenegotiationInfoExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// SupportedVersionsExtension is the supported_versions extension.
+type SupportedVersionsExtension struct {
+	Versions []uint16
+}
+
+func (e *SupportedVersionsExtension) extension() (uint16, []byte) {
+	return extensionSupportedVersions, buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, v := range e.Versions {
+				b.AddUint16(v)
+			}
+		})
+	})
+}
+func (e *SupportedVersionsExtension) Len() int                   { return extensionLen(e) }
+func (e *SupportedVersionsExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// PSKKeyExchangeModesExtension is the psk_key_exchange_modes extension.
+type PSKKeyExchangeModesExtension struct {
+	Modes []uint8
+}
+
+func (e *PSKKeyExchangeModesExtension) extension() (uint16, []byte) {
+	return extensionPSKModes, buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(e.Modes)
+		})
+	})
+}
+func (e *PSKKeyExchangeModesExtension) Len() int                   { return extensionLen(e) }
+func (e *PSKKeyExchangeModesExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// A KeyShare is an entry of a KeyShareExtension.
+type KeyShare struct {
+	Group CurveID
+	Data  []byte
+}
+
+// KeyShareExtension marks the position of the key_share extension. The
+// GREASE entries are sent as is, and the others are replaced by the key
+// shares of the connection, which this package generates for the first
+// supported group and, for hybrids, their ECDH component.
+type KeyShareExtension struct {
+	KeyShares []KeyShare
+}
+
+func (e *KeyShareExtension) extension() (uint16, []byte) {
+	return extensionKeyShare, buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, ks := range e.KeyShares {
+				b.AddUint16(uint16(ks.Group))
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(ks.Data)
+				})
+			}
+		})
+	})
+}
+func (e *KeyShareExtension) Len() int                   { return extensionLen(e) }
+func (e *KeyShareExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// UtlsCompressCertExtension is the compress_certificate extension of RFC
+// 8879. The algorithms Config.CertificateCompressors doesn't implement
+// aren't sent, as this package couldn't decompress the certificates of the
+// servers using them, and the extension isn't sent if none is left.
+type UtlsCompressCertExtension struct {
+	Algorithms []CertCompressionAlgo
+}
+
+func (e *UtlsCompressCertExtension) extension() (uint16, []byte) {
+	return extensionCompressCertificate, buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, alg := range e.Algorithms {
+				b.AddUint16(uint16(alg))
+			}
+		})
+	})
+}
+func (e *UtlsCompressCertExtension) Len() int                   { return extensionLen(e) }
+func (e *UtlsCompressCertExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// FakeESNIExtension is the encrypted_server_name extension of
+// draft-ietf-tls-esni-02, sent by Firefox and Cloudflare clients before ECH,
+// for the ClientHelloSpecs of those. It's well-formed but encrypts nothing:
+// each UConn sends a fresh key share and random encrypted_sni, and servers
+// ignore it. The ServerName can be left out of the ClientHello with
+// Config.OmitServerName, as those clients did.
+type FakeESNIExtension struct {
+	// CipherSuite is that of the ESNIKeys record, TLS_AES_128_GCM_SHA256 if
+	// zero.
+	CipherSuite uint16
+
+	// Group is the group of the key share, X25519 if zero.
+	Group CurveID
+
+	// RecordDigest is the SHA-256 digest of the ESNIKeys record, random if
+	// nil.
+	RecordDigest []byte
+
+	// PaddedLength is the padded_length of the ESNIKeys record, 260 if zero,
+	// as in those of Cloudflare.
+	PaddedLength int
+}
+
+func (e *FakeESNIExtension) extension() (uint16, []byte) {
+	// Zeroes in place of the contents of a UConn, for the length.
+	var publicLen int
+	switch cmpOr(e.Group, X25519) {
+	case X25519:
+		publicLen = 32
+	case CurveP256:
+		publicLen = 65
+	case CurveP384:
+		publicLen = 97
+	case CurveP521:
+		publicLen = 133
+	}
+	digest := e.RecordDigest
+	if digest == nil {
+		digest = make([]byte, 32)
+	}
+	return extensionESNI, e.marshal(make([]byte, publicLen), digest, make([]byte, e.encryptedSNILen()))
+}
+func (e *FakeESNIExtension) Len() int                   { return extensionLen(e) }
+func (e *FakeESNIExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// encryptedSNILen returns the length of the nonce and padded
+// server_name_list of ClientESNIInner, encrypted with their AEAD tag.
+func (e *FakeESNIExtension) encryptedSNILen() int {
+	return 16 + cmpOr(e.PaddedLength, 260) + 16
+}
+
+// marshal returns the extension_data of e with the given contents.
+func (e *FakeESNIExtension) marshal(public, digest, encryptedSNI []byte) []byte {
+	return buildExtension(func(b *cryptobyte.Builder) {
+		b.AddUint16(cmpOr(e.CipherSuite, TLS_AES_128_GCM_SHA256))
+		b.AddUint16(uint16(cmpOr(e.Group, X25519)))
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(public)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(digest)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(encryptedSNI)
+		})
+	})
+}
+
+// generate returns the extension_data of e for a ClientHello, with a fresh
+// key share and random contents read from rand.
+func (e *FakeESNIExtension) generate(rand io.Reader) ([]byte, error) {
+	curve, ok := curveForCurveID(cmpOr(e.Group, X25519))
+	if !ok {
+		return nil, errors.New("tls: unsupported group in FakeESNIExtension")
+	}
+	key, err := curve.GenerateKey(rand)
+	if err != nil {
+		return nil, err
+	}
+	digest := e.RecordDigest
+	if digest == nil {
+		digest = make([]byte, 32)
+		if _, err := io.ReadFull(rand, digest); err != nil {
+			return nil, err
+		}
+	}
+	encryptedSNI := make([]byte, e.encryptedSNILen())
+	if _, err := io.ReadFull(rand, encryptedSNI); err != nil {
+		return nil, err
+	}
+	return e.marshal(key.PublicKey().Bytes(), digest, encryptedSNI), nil
+}
+
+// UtlsPaddingExtension is the padding extension of RFC 7685. Its length is
+// that returned by GetPaddingLen, called with the length of the ClientHello
+// without it, or else PaddingLen if WillPad is set. Otherwise, or if the
+// ClientHelloSpec was parsed, it's that of BoringPaddingStyle.
+type UtlsPaddingExtension struct {
+	PaddingLen    int
+	WillPad       bool
+	GetPaddingLen func(clientHelloUnpaddedLen int) (paddingLen int, willPad bool)
+}
+
+func (e *UtlsPaddingExtension) extension() (uint16, []byte) {
+	return extensionPadding, make([]byte, e.PaddingLen)
+}
+func (e *UtlsPaddingExtension) Len() int                   { return extensionLen(e) }
+func (e *UtlsPaddingExtension) Read(b []byte) (int, error) { return readExtension(e, b) }
+
+// paddingLen returns the length of the padding of a ClientHello of
+// unpaddedLen bytes.
+func (e *UtlsPaddingExtension) paddingLen(unpaddedLen int) (int, bool) {
+	switch {
+	case e.GetPaddingLen != nil:
+		return e.GetPaddingLen(unpaddedLen)
+	case e.WillPad:
+		return e.PaddingLen, true
+	}
+	return BoringPaddingStyle(unpaddedLen)
+}
+
+// BoringPaddingStyle returns the length of the padding BoringSSL, and so
+// Chrome, adds to ClientHellos of unpaddedLen bytes, with their handshake
+// header, to work around servers failing on those of 256 to 511 bytes.
+func BoringPaddingStyle(unpaddedLen int) (int, bool) {
+	if unpaddedLen <= 0xff || unpaddedLen >= 0x200 {
+		return 0, false
+	}
+	paddingLen := 0x200 - unpaddedLen
+	if paddingLen >= 4+1 {
+		return paddingLen - 4, true
+	}
+	return 1, true
+}
@@ -0,0 +1,243 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// greaseFree returns ids with the GREASE values replaced by a placeholder.
+func greaseFree(ids []uint16) []uint16 {
+	var out []uint16
+	for _, id := range ids {
+		if isGREASE(id) {
+			id = GREASE_PLACEHOLDER
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// brotliCertCompressor stands for a brotli CertificateCompressor, for the
+// ClientHellos offering brotli.
+type brotliCertCompressor struct{}
+
+func (brotliCertCompressor) Algorithm() CertificateCompressionAlgorithm {
+	return CertificateCompressionBrotli
+}
+
+func (brotliCertCompressor) Compress([]byte) ([]byte, error) {
+	return nil, errors.New("brotli not implemented")
+}
+
+func (brotliCertCompressor) NewDecompressor(io.Reader) (io.Reader, error) {
+	return nil, errors.New("brotli not implemented")
+}
+
+func TestUClientChrome(t *testing.T) {
+	config := testConfig.Clone()
+	config.ServerName = "example.com"
+	config.CertificateCompressors = []CertificateCompressor{brotliCertCompressor{}}
+	record := firstRecord(t, func(c net.Conn) { UClient(c, config, HelloChrome_Auto).Handshake() })
+	spec, err := ParseClientHelloSpec(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := spec.JA4(false), "t13d1516h2_8daaf6152771_e5627efa2ab1"; got != want {
+		t.Errorf("JA4 %s, want %s", got, want)
+	}
+	if len(record) != recordHeaderLen+0x200 {
+		t.Errorf("ClientHello of %d bytes, want it padded to 512", len(record)-recordHeaderLen)
+	}
+	ids := spec.extensionIDs()
+	first, last := ids[0], ids[len(ids)-2]
+	if !isGREASE(first) || !isGREASE(last) || first == last || ids[len(ids)-1] != extensionPadding {
+		t.Errorf("extensions %x, want distinct GREASE first and before the padding", ids)
+	}
+	if !isGREASE(spec.CipherSuites[0]) {
+		t.Errorf("cipher suites %x, want GREASE first", spec.CipherSuites)
+	}
+	// The GREASE key share is that of the GREASE group.
+	groups, _ := spec.Extension(extensionSupportedCurves)
+	shares, _ := spec.Extension(extensionKeyShare)
+	if len(groups) < 4 || len(shares) < 4 || groups[2] != shares[2] || groups[3] != shares[3] || !isGREASE(uint16(groups[2])<<8|uint16(groups[3])) {
+		t.Errorf("groups %x and key shares %x, want the same GREASE value first", groups, shares)
+	}
+
+	// A spec parsed from a capture is sent in the same order.
+	u := func(c net.Conn) {
+		u := UClient(c, config, HelloCustom)
+		if err := u.ApplyPreset(spec); err != nil {
+			t.Error(err)
+		}
+		u.Handshake()
+	}
+	respec, err := ParseClientHelloSpec(firstRecord(t, u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := greaseFree(respec.extensionIDs()), greaseFree(ids); !slicesEqual(got, want) {
+		t.Errorf("extensions %x, want %x", got, want)
+	}
+	if got, want := greaseFree(respec.CipherSuites), greaseFree(spec.CipherSuites); !slicesEqual(got, want) {
+		t.Errorf("cipher suites %x, want %x", got, want)
+	}
+	// With the zero Rand of testConfig, the GREASE values are still moved.
+	if respec.CipherSuites[0] == spec.CipherSuites[0] {
+		t.Error("GREASE values not randomized")
+	}
+}
+
+func TestUClientHandshake(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		curves []CurveID
+		vers   uint16
+	}{
+		{"TLS13", nil, VersionTLS13},
+		{"TLS12", nil, VersionTLS12},
+		{"HelloRetryRequest", []CurveID{CurveP384}, VersionTLS13},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+			serverConfig := testConfig.Clone()
+			serverConfig.CurvePreferences = tt.curves
+			serverConfig.MaxVersion = tt.vers
+			for i := 0; i < 2; i++ {
+				c, s := localPipe(t)
+				errChan := make(chan error, 1)
+				go func() {
+					server := Server(s, serverConfig)
+					_, err := server.Write([]byte("hello"))
+					errChan <- err
+					io.Copy(io.Discard, server)
+					server.Close()
+				}()
+				client := UClient(c, clientConfig, HelloChrome_Auto)
+				// Reading processes the session tickets.
+				if _, err := client.Read(make([]byte, 5)); err != nil {
+					t.Fatalf("connection %d: %v, server: %v", i, err, <-errChan)
+				}
+				if err := <-errChan; err != nil {
+					t.Fatal(err)
+				}
+				cs := client.ConnectionState()
+				client.Close()
+				if cs.Version != tt.vers || cs.DidResume != (i == 1) {
+					t.Errorf("connection %d negotiated %x, resumed: %v", i, cs.Version, cs.DidResume)
+				}
+				if tt.curves != nil && !cs.HelloRetryRequest {
+					t.Error("no HelloRetryRequest")
+				}
+			}
+		})
+	}
+}
+
+func TestUClientCertCompression(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		compressors []CertificateCompressor
+		want        []byte // the compress_certificate extension_data
+	}{
+		{"None", nil, nil},
+		{"Zlib", []CertificateCompressor{ZlibCertificateCompressor{}}, []byte{2, 0, 1}},
+		{"Both", []CertificateCompressor{ZlibCertificateCompressor{}, brotliCertCompressor{}}, []byte{4, 0, 1, 0, 2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig.Clone()
+			config.CertificateCompressors = tt.compressors
+			record := firstRecord(t, func(c net.Conn) {
+				u := UClient(c, config, HelloCustom)
+				if err := u.ApplyPreset(&ClientHelloSpec{Extensions: []TLSExtension{
+					&SupportedVersionsExtension{Versions: []uint16{VersionTLS13}},
+					&UtlsCompressCertExtension{Algorithms: []CertCompressionAlgo{CertCompressionZlib, CertCompressionBrotli}},
+				}}); err != nil {
+					t.Error(err)
+				}
+				u.Handshake()
+			})
+			spec, err := ParseClientHelloSpec(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := spec.Extension(extensionCompressCertificate)
+			if ok != (tt.want != nil) || !bytes.Equal(got, tt.want) {
+				t.Errorf("compress_certificate %x (sent: %v), want %x", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestUClientErrors(t *testing.T) {
+	for _, id := range []ClientHelloID{HelloCustom, {Client: "Unknown", Version: "1"}, HelloFirefox_Auto, HelloRandomized} {
+		c, s := localPipe(t)
+		s.Close()
+		if err := UClient(c, testConfig, id).Handshake(); err == nil {
+			t.Errorf("%s handshake succeeded", id.Str())
+		}
+	}
+	if _, err := UTLSIdToSpec(HelloIOS_Auto); err == nil || !strings.Contains(err.Error(), "no preset") {
+		t.Errorf("got error %v for HelloIOS_Auto, want no preset", err)
+	}
+}
+
+func TestFakeESNIExtension(t *testing.T) {
+	spec, err := UTLSIdToSpec(HelloChrome_Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esni := &FakeESNIExtension{}
+	spec.Extensions = append([]TLSExtension{esni}, spec.Extensions...)
+	config := testConfig.Clone()
+	config.Rand = rand.Reader
+	config.OmitServerName = true
+	send := func(c net.Conn) {
+		u := UClient(c, config, HelloCustom)
+		if err := u.ApplyPreset(&spec); err != nil {
+			t.Error(err)
+		}
+		u.Handshake()
+	}
+
+	var sent [][]byte
+	for i := 0; i < 2; i++ {
+		got, err := ParseClientHelloSpec(firstRecord(t, send))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := got.Extension(extensionESNI)
+		if !ok || len(data) != esni.Len()-4 {
+			t.Fatalf("encrypted_server_name of %d bytes, want %d", len(data), esni.Len()-4)
+		}
+		if _, ok := got.Extension(extensionServerName); ok {
+			t.Error("server_name sent")
+		}
+		s := cryptobyte.String(data)
+		var suite, group uint16
+		var public, digest, encryptedSNI cryptobyte.String
+		if !s.ReadUint16(&suite) || !s.ReadUint16(&group) || !s.ReadUint16LengthPrefixed(&public) ||
+			!s.ReadUint16LengthPrefixed(&digest) || !s.ReadUint16LengthPrefixed(&encryptedSNI) || !s.Empty() {
+			t.Fatalf("malformed encrypted_server_name %x", data)
+		}
+		if suite != TLS_AES_128_GCM_SHA256 || CurveID(group) != X25519 || len(public) != 32 ||
+			len(digest) != 32 || len(encryptedSNI) != 16+260+16 {
+			t.Errorf("encrypted_server_name %x", data)
+		}
+		if _, err := ecdh.X25519().NewPublicKey(public); err != nil {
+			t.Error(err)
+		}
+		sent = append(sent, data)
+	}
+	if bytes.Equal(sent[0], sent[1]) {
+		t.Error("the same encrypted_server_name was sent twice")
+	}
+}
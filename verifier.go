@@ -0,0 +1,101 @@
+package tls
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+)
+
+// A VerifierDecision is the outcome of a [Verdict].
+type VerifierDecision int
+
+const (
+	// VerifierAbstain leaves the decision to the other Verifiers and to the
+	// chain verification.
+	VerifierAbstain VerifierDecision = iota
+	// VerifierAccept accepts the peer, even if its chain failed to verify.
+	VerifierAccept
+	// VerifierReject rejects the peer, aborting the handshake.
+	VerifierReject
+)
+
+// A Verdict is the decision of a [Verifier] about a peer.
+type Verdict struct {
+	Decision VerifierDecision
+	// Err is the reason a peer is rejected, returned by the handshake.
+	Err error
+	// Alert is the alert sent when a peer is rejected. If zero,
+	// bad_certificate is sent.
+	Alert AlertError
+}
+
+// PeerVerification is the information a [Verifier] decides about. It should
+// not be modified.
+type PeerVerification struct {
+	// RawCerts are the raw ASN.1 certificates sent by the peer. They may be
+	// empty on servers, if ClientAuth doesn't require a certificate.
+	RawCerts [][]byte
+
+	// VerifiedChains are the chains built from RawCerts by the chain
+	// verification, and VerifyError its error if it failed. Both are nil
+	// when certificates aren't verified, on clients if InsecureSkipVerify is
+	// set, and on servers depending on ClientAuth.
+	VerifiedChains [][]*x509.Certificate
+	VerifyError    error
+
+	// State is the state of the connection so far, including the stapled
+	// OCSP response and the SCTs of the peer.
+	State ConnectionState
+}
+
+// A Verifier is a peer verification policy, which [Config.Verifiers] compose.
+// Implementations must be safe for concurrent use.
+type Verifier interface {
+	// VerifyPeer decides whether peer is accepted. ctx is the context of
+	// the handshake.
+	VerifyPeer(ctx context.Context, peer *PeerVerification) Verdict
+}
+
+// VerifierFunc is a [Verifier] implemented by a function.
+type VerifierFunc func(ctx context.Context, peer *PeerVerification) Verdict
+
+// VerifyPeer implements [Verifier] by calling f.
+func (f VerifierFunc) VerifyPeer(ctx context.Context, peer *PeerVerification) Verdict {
+	return f(ctx, peer)
+}
+
+// consultVerifiers asks c.config.Verifiers about the peer that sent rawCerts,
+// whose chain verification failed with verifyErr, to be reported with
+// verifyAlert, if not nil. It is an error for the chain to fail unless one of
+// the Verifiers accepts the peer, and for any of them to reject it.
+func (c *Conn) consultVerifiers(ctx context.Context, rawCerts [][]byte, verifyErr error, verifyAlert alert) error {
+	peer := &PeerVerification{
+		RawCerts:       rawCerts,
+		VerifiedChains: c.verifiedChains,
+		VerifyError:    verifyErr,
+		State:          c.connectionStateLocked(),
+	}
+	var accepted bool
+	for _, v := range c.config.Verifiers {
+		verdict := v.VerifyPeer(ctx, peer)
+		switch verdict.Decision {
+		case VerifierAccept:
+			accepted = true
+		case VerifierReject:
+			a := alertBadCertificate
+			if verdict.Alert != 0 {
+				a = alert(verdict.Alert)
+			}
+			c.sendAlert(a)
+			if verdict.Err == nil {
+				return errors.New("tls: peer rejected by Verifier")
+			}
+			return verdict.Err
+		}
+	}
+	if verifyErr != nil && !accepted {
+		c.sendAlert(verifyAlert)
+		return verifyErr
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifiers(t *testing.T) {
+	leaf, err := x509.ParseCertificate(testRSACertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pinLeaf accepts the test leaf whether or not its chain verified.
+	pinLeaf := VerifierFunc(func(_ context.Context, peer *PeerVerification) Verdict {
+		if len(peer.RawCerts) > 0 && bytes.Equal(peer.RawCerts[0], leaf.Raw) {
+			return Verdict{Decision: VerifierAccept}
+		}
+		return Verdict{}
+	})
+	abstain := VerifierFunc(func(context.Context, *PeerVerification) Verdict { return Verdict{} })
+	reject := VerifierFunc(func(context.Context, *PeerVerification) Verdict {
+		return Verdict{Decision: VerifierReject, Err: errors.New("rejected by policy"), Alert: AlertError(alertAccessDenied)}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		verifiers  []Verifier
+		clientAuth bool
+		wantErr    string
+	}{
+		{name: "Accept", verifiers: []Verifier{abstain, pinLeaf}},
+		{name: "Abstain", verifiers: []Verifier{abstain}, wantErr: "unknown authority"},
+		{name: "Reject", verifiers: []Verifier{pinLeaf, reject}, wantErr: "rejected by policy"},
+		{name: "ClientCertAccept", verifiers: []Verifier{pinLeaf}, clientAuth: true},
+		{name: "ClientCertAbstain", verifiers: []Verifier{abstain}, clientAuth: true, wantErr: "unknown authority"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			serverConfig := testConfig.Clone()
+			verifying := clientConfig
+			if tt.clientAuth {
+				serverConfig.ClientAuth = RequireAndVerifyClientCert
+				serverConfig.ClientCAs = x509.NewCertPool()
+				serverConfig.Time = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+				clientConfig.Certificates = testConfig.Certificates[:1]
+				verifying = serverConfig
+				// Fail on the server before the client reads its response.
+				serverConfig.MaxVersion = VersionTLS12
+			} else {
+				clientConfig.InsecureSkipVerify = false
+				clientConfig.RootCAs = x509.NewCertPool()
+				clientConfig.ServerName = "example.golang"
+				clientConfig.Time = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+			}
+			var peers []*PeerVerification
+			record := VerifierFunc(func(_ context.Context, peer *PeerVerification) Verdict {
+				peers = append(peers, peer)
+				return Verdict{}
+			})
+			verifying.Verifiers = append([]Verifier{record}, tt.verifiers...)
+
+			ss, cs, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(peers) != 1 {
+				t.Fatalf("Verifiers consulted %d times, want once", len(peers))
+			}
+			if peers[0].VerifyError == nil || peers[0].VerifiedChains != nil {
+				t.Errorf("got VerifyError %v and VerifiedChains %v, want a failed verification", peers[0].VerifyError, peers[0].VerifiedChains)
+			}
+			if len(peers[0].State.PeerCertificates) != 1 {
+				t.Errorf("got %d peer certificates in State, want 1", len(peers[0].State.PeerCertificates))
+			}
+			if tt.wantErr == "" {
+				state := cs
+				if tt.clientAuth {
+					state = ss
+				}
+				if len(state.VerifiedChains) != 0 {
+					t.Errorf("got verified chains %v, want none", state.VerifiedChains)
+				}
+			}
+		})
+	}
+}